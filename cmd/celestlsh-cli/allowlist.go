@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/Magonia-Research/CelesTLSH-CLI/pkg/celestlsh"
+)
+
+// allowlist holds known-good SHA256 and TLSH values from --allowlist.
+// Matches against them are suppressed rather than reported.
+type allowlist struct {
+	sha256 map[string]bool
+	tlsh   map[string]bool
+}
+
+// loadAllowlist reads the allowlist at path, returning nil if path is
+// empty. Each line holds one SHA256 or TLSH value; blank lines and
+// everything after a # are ignored. Lines holding anything else are
+// reported on stderr and skipped.
+func loadAllowlist(path string) (*allowlist, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read allowlist: %w", err)
+	}
+	defer f.Close()
+
+	a := &allowlist{sha256: make(map[string]bool), tlsh: make(map[string]bool)}
+	sc := bufio.NewScanner(f)
+	for line := 1; sc.Scan(); line++ {
+		value, _, _ := strings.Cut(sc.Text(), "#")
+		value = strings.TrimSpace(value)
+		switch {
+		case value == "":
+		case isSHA256(value):
+			a.sha256[strings.ToLower(value)] = true
+		case isTLSH(value):
+			a.tlsh[normalizeTLSH(value)] = true
+		default:
+			fmt.Fprintf(os.Stderr, "Warning: %s:%d: %q is not a SHA256 or TLSH value; ignoring it\n", path, line, value)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read allowlist: %w", err)
+	}
+
+	return a, nil
+}
+
+// suppresses reports whether result should be suppressed: its matched
+// record's SHA256 or TLSH, or the scanned data's own SHA256 or TLSH, is
+// allowlisted.
+func (a *allowlist) suppresses(result scanResult) bool {
+	if a == nil || result.Match == nil {
+		return false
+	}
+	m := result.Match
+	return a.sha256[strings.ToLower(m.SHA256Hash)] ||
+		a.tlsh[normalizeTLSH(m.TLSHHash)] ||
+		(result.SHA256 != "" && a.sha256[result.SHA256]) ||
+		(result.TLSH != "" && a.tlsh[normalizeTLSH(result.TLSH)])
+}
+
+func isSHA256(s string) bool {
+	_, err := hex.DecodeString(s)
+	return err == nil && len(s) == 64
+}
+
+func isTLSH(s string) bool {
+	s = normalizeTLSH(s)
+	return celestlsh.ValidateHash(s) == nil
+}
+
+// normalizeTLSH lowercases a TLSH hash and strips the T1 version prefix
+// some tools add, so that either spelling compares equal.
+func normalizeTLSH(s string) string {
+	return strings.ToLower(celestlsh.NormalizeHash(s))
+}
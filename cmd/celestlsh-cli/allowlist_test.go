@@ -0,0 +1,106 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+func TestLoadAllowlist(t *testing.T) {
+	records := testRecords(t)
+	sum := sha256.Sum256(testSample)
+	path := writeFile(t, t.TempDir(), "allow.txt", []byte(strings.Join([]string{
+		"# golden image tools",
+		strings.ToUpper(hex.EncodeToString(sum[:])) + "  # known.exe",
+		"",
+		"T1" + strings.ToUpper(records[2].TLSHHash),
+		"psexec.exe",
+		"abcd",
+	}, "\n")))
+
+	_, errOut, _, err := runCLI(t, "-c", "--allowlist", path, "--db", writeTestDatabase(t), records[3].TLSHHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{`allow.txt:5: "psexec.exe" is not a SHA256 or TLSH value`, `allow.txt:6: "abcd" is not`} {
+		if !strings.Contains(errOut, want) {
+			t.Errorf("warnings lack %q:\n%s", want, errOut)
+		}
+	}
+	if strings.Count(errOut, "Warning:") != 2 {
+		t.Errorf("warnings = %q, want two", errOut)
+	}
+
+	a, err := loadAllowlist(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(a.sha256) != 1 || !a.sha256[hex.EncodeToString(sum[:])] || len(a.tlsh) != 1 || !a.tlsh[records[2].TLSHHash] {
+		t.Errorf("allowlist = %+v, want the SHA256 of known.exe and the TLSH of other.exe", a)
+	}
+}
+
+func TestScanAllowlist(t *testing.T) {
+	records := testRecords(t)
+	sum := sha256.Sum256(testSample)
+	dir := t.TempDir()
+	writeFile(t, dir, "known.exe", testSample)
+	writeFile(t, dir, "other.exe", sampleData(2, 8192))
+	db := writeTestDatabase(t)
+
+	tests := []struct {
+		name       string
+		allow      string
+		matched    int64
+		suppressed int64
+		st         status
+	}{
+		{"own SHA256", hex.EncodeToString(sum[:]), 1, 1, statusMatch},
+		{"record TLSH", "T1" + records[2].TLSHHash, 1, 1, statusMatch},
+		// Every test record has the same SHA256.
+		{"record SHA256", records[0].SHA256Hash, 0, 2, statusOK},
+		{"unrelated", records[3].TLSHHash, 2, 0, statusMatch},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			allow := writeFile(t, t.TempDir(), "allow.txt", []byte(tt.allow+"\n"))
+			out, _, st, err := runCLI(t, "-s", "--jsonl", "--max-distance", "30", "--allowlist", allow, "--db", db, dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			results, summary := parseJSONL(t, out)
+			if summary.Matched != tt.matched || summary.Suppressed != tt.suppressed || int64(len(results)) != tt.matched {
+				t.Errorf("%d results, %d matched, %d suppressed; want %d matched, %d suppressed", len(results), summary.Matched, summary.Suppressed, tt.matched, tt.suppressed)
+			}
+			if st != tt.st {
+				t.Errorf("status = %v, want %v", st, tt.st)
+			}
+
+			out, _, st, err = runCLI(t, "-s", "--jsonl", "--max-distance", "30", "--show-suppressed", "--allowlist", allow, "--db", db, dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			results, _ = parseJSONL(t, out)
+			var shown int64
+			for _, r := range results {
+				if r.Suppressed {
+					shown++
+				}
+			}
+			if len(results) != 2 || shown != tt.suppressed || st != tt.st {
+				t.Errorf("--show-suppressed: %d results, %d suppressed, status %v; want 2, %d, %v", len(results), shown, st, tt.suppressed, tt.st)
+			}
+		})
+	}
+
+	// Check mode suppresses by the record's TLSH too.
+	allow := writeFile(t, t.TempDir(), "allow.txt", []byte(records[2].TLSHHash+"\n"))
+	out, _, st, err := runCLI(t, "-c", "--allowlist", allow, "--db", db, testHash(t, sampleData(2, 8192)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st != statusOK || !strings.Contains(out, "outside the allowlist") {
+		t.Errorf("check: status %v, output %q; want the match suppressed", st, out)
+	}
+}
@@ -0,0 +1,390 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"os"
+	"strings"
+)
+
+const (
+	// archiveMemberLimit caps the decompressed size of a single archive
+	// member that will be hashed.
+	archiveMemberLimit = 512 << 20
+
+	// spoolMemoryLimit is the largest nested zip archive buffered in
+	// memory; bigger ones are spooled to a temporary file, since zip
+	// archives need random access.
+	spoolMemoryLimit = 32 << 20
+
+	// archiveSeparator joins an archive path and the path of a member
+	// inside it in scan results.
+	archiveSeparator = "!"
+)
+
+type archiveKind int
+
+const (
+	notArchive archiveKind = iota
+	zipArchive
+	tarArchive
+	tarGzipArchive
+)
+
+// sniffLen is how much of a file is read to recognise archive formats; the
+// tar magic sits at offset 257 of the first header block.
+const sniffLen = 512
+
+var (
+	zipMagic    = []byte("PK\x03\x04")
+	gzipMagic   = []byte("\x1f\x8b")
+	tarMagic    = []byte("ustar")
+	tarMagicOff = 257
+
+	errArchiveBudget = errors.New("decompressed size budget exhausted")
+	errMemberLimit   = fmt.Errorf("member is larger than %d bytes", archiveMemberLimit)
+)
+
+// detectArchive recognises zip, tar and gzip-compressed tar archives from a
+// file's extension or from head, the first bytes of its contents.
+func detectArchive(path string, head []byte) archiveKind {
+	lower := strings.ToLower(path)
+
+	switch {
+	case strings.HasSuffix(lower, ".zip") || bytes.HasPrefix(head, zipMagic):
+		return zipArchive
+	case strings.HasSuffix(lower, ".tar") || isTarHeader(head):
+		return tarArchive
+	case bytes.HasPrefix(head, gzipMagic):
+		if strings.HasSuffix(lower, ".tgz") || strings.HasSuffix(lower, ".tar.gz") {
+			return tarGzipArchive
+		}
+		// A plain .gz of a single file is hashed as it is; only look
+		// inside when the decompressed data starts with a tar header.
+		zr, err := gzip.NewReader(bytes.NewReader(head))
+		if err != nil {
+			return notArchive
+		}
+		inner := make([]byte, tarMagicOff+len(tarMagic))
+		n, _ := io.ReadFull(zr, inner)
+		if isTarHeader(inner[:n]) {
+			return tarGzipArchive
+		}
+	}
+
+	return notArchive
+}
+
+func isTarHeader(head []byte) bool {
+	return len(head) >= tarMagicOff+len(tarMagic) && bytes.Equal(head[tarMagicOff:tarMagicOff+len(tarMagic)], tarMagic)
+}
+
+// extractBudget is the number of bytes that may still be decompressed
+// while scanning one top-level archive, shared by every nested archive
+// inside it.
+type extractBudget struct {
+	remaining int64
+	exhausted bool
+}
+
+// archiveSource is an archive to scan. Zip archives need readerAt and size;
+// tar archives are streamed from reader.
+type archiveSource struct {
+	reader   io.Reader
+	readerAt io.ReaderAt
+	size     int64
+}
+
+// scanArchive checks the members of an archive against the database,
+// descending into nested archives while level is below --archive-depth.
+// name is the archive's own path, including any enclosing archives.
+func (s *scanner) scanArchive(ctx context.Context, name string, kind archiveKind, src archiveSource, level int, budget *extractBudget) {
+	switch kind {
+	case zipArchive:
+		s.scanZip(ctx, name, src.readerAt, src.size, level, budget)
+	case tarArchive:
+		s.scanTar(ctx, name, src.reader, false, level, budget)
+	case tarGzipArchive:
+		s.scanTar(ctx, name, src.reader, true, level, budget)
+	}
+}
+
+// scanMember checks one archive member, or scans it as an archive itself
+// when it is one and the depth limit allows.
+func (s *scanner) scanMember(ctx context.Context, name string, r io.Reader, level int, budget *extractBudget) {
+	if level >= s.config.ArchiveDepth && s.config.Types == 0 {
+		s.emit(s.check(ctx, name, r))
+		return
+	}
+
+	br := bufio.NewReaderSize(r, sniffLen)
+	head, _ := br.Peek(sniffLen)
+	kind := notArchive
+	if level < s.config.ArchiveDepth {
+		kind = detectArchive(name, head)
+	}
+	if kind == notArchive && !s.wantedType(name, head) {
+		return
+	}
+
+	switch kind {
+	case notArchive:
+		s.emit(s.check(ctx, name, br))
+
+	case zipArchive:
+		ra, size, cleanup, err := spool(br)
+		if err != nil {
+			if !budget.exhausted {
+				s.emit(scanResult{Path: name, Error: fmt.Sprintf("failed to read nested archive: %v", err)})
+			}
+			return
+		}
+		defer cleanup()
+		s.scanArchive(ctx, name, kind, archiveSource{readerAt: ra, size: size}, level+1, budget)
+
+	default:
+		s.scanArchive(ctx, name, kind, archiveSource{reader: br}, level+1, budget)
+	}
+}
+
+// scanZip checks every regular member of the zip archive in r against the
+// database, reporting each as archivePath!member.
+func (s *scanner) scanZip(ctx context.Context, archivePath string, r io.ReaderAt, size int64, level int, budget *extractBudget) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil && !errors.Is(err, zip.ErrInsecurePath) {
+		s.emit(scanResult{Path: archivePath, Error: fmt.Sprintf("failed to read zip archive: %v", err)})
+		return
+	}
+
+	for _, f := range zr.File {
+		if ctx.Err() != nil || budget.exhausted {
+			return
+		}
+
+		if f.FileInfo().IsDir() || f.UncompressedSize64 == 0 {
+			continue
+		}
+
+		name := archivePath + archiveSeparator + f.Name
+
+		if f.UncompressedSize64 > archiveMemberLimit {
+			s.emit(scanResult{Path: name, Error: errMemberLimit.Error() + "; skipped"})
+			continue
+		}
+
+		rc, err := s.openZipMember(f)
+		if err != nil {
+			s.emit(scanResult{Path: name, Error: fmt.Sprintf("failed to open zip member: %v", err)})
+			continue
+		}
+
+		s.scanMember(ctx, name, &budgetReader{r: rc, budget: budget, member: archiveMemberLimit}, level, budget)
+		rc.Close()
+	}
+}
+
+// openZipMember opens a zip member for reading, decrypting it with the
+// configured password when it uses ZipCrypto encryption.
+func (s *scanner) openZipMember(f *zip.File) (io.ReadCloser, error) {
+	if f.Flags&0x1 == 0 {
+		return f.Open()
+	}
+
+	raw, err := f.OpenRaw()
+	if err != nil {
+		return nil, err
+	}
+
+	checkByte := byte(f.CRC32 >> 24)
+	if f.Flags&0x8 != 0 {
+		checkByte = byte(f.ModifiedTime >> 8)
+	}
+
+	plain, err := newZipCryptoReader(raw, s.config.ZipPassword, checkByte)
+	if err != nil {
+		return nil, err
+	}
+
+	var rc io.ReadCloser
+	switch f.Method {
+	case zip.Store:
+		rc = io.NopCloser(plain)
+	case zip.Deflate:
+		rc = flate.NewReader(plain)
+	default:
+		return nil, zip.ErrAlgorithm
+	}
+
+	return &zipCryptoMember{r: rc, sum: crc32.NewIEEE(), crc: f.CRC32}, nil
+}
+
+// zipCryptoMember verifies the CRC of a decrypted member once it has been
+// read in full, catching wrong passwords that pass the header check.
+type zipCryptoMember struct {
+	r   io.ReadCloser
+	sum hash.Hash32
+	crc uint32
+}
+
+func (m *zipCryptoMember) Read(p []byte) (int, error) {
+	n, err := m.r.Read(p)
+	m.sum.Write(p[:n])
+	if err == io.EOF && m.sum.Sum32() != m.crc {
+		return n, errZipWrongPassword
+	}
+	return n, err
+}
+
+func (m *zipCryptoMember) Close() error { return m.r.Close() }
+
+// scanTar checks every regular file in the tar stream r, which is gzip
+// compressed when compressed is set, reporting each as archivePath!member.
+func (s *scanner) scanTar(ctx context.Context, archivePath string, r io.Reader, compressed bool, level int, budget *extractBudget) {
+	if compressed {
+		zr, err := gzip.NewReader(r)
+		if err != nil {
+			s.emit(scanResult{Path: archivePath, Error: fmt.Sprintf("failed to read gzip stream: %v", err)})
+			return
+		}
+		defer zr.Close()
+		r = zr
+	}
+
+	// Everything decompressed counts against the budget, including the
+	// data of entries that are skipped rather than hashed.
+	tr := tar.NewReader(&budgetReader{r: r, budget: budget, member: -1})
+
+	for {
+		if ctx.Err() != nil || budget.exhausted {
+			return
+		}
+
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			if !budget.exhausted {
+				s.emit(scanResult{Path: archivePath, Error: fmt.Sprintf("failed to read tar archive: %v", err)})
+			}
+			return
+		}
+
+		name := archivePath + archiveSeparator + hdr.Name
+
+		switch hdr.Typeflag {
+		case tar.TypeReg, tar.TypeRegA:
+		case tar.TypeDir:
+			continue
+		default:
+			s.note(name, tarTypeName(hdr.Typeflag)+" entry")
+			continue
+		}
+
+		if hdr.Size == 0 {
+			continue
+		}
+		if hdr.Size > archiveMemberLimit {
+			s.emit(scanResult{Path: name, Error: errMemberLimit.Error() + "; skipped"})
+			continue
+		}
+
+		s.scanMember(ctx, name, tr, level, budget)
+	}
+}
+
+func tarTypeName(flag byte) string {
+	switch flag {
+	case tar.TypeLink:
+		return "hard link"
+	case tar.TypeSymlink:
+		return "symlink"
+	case tar.TypeChar:
+		return "character device"
+	case tar.TypeBlock:
+		return "block device"
+	case tar.TypeFifo:
+		return "FIFO"
+	default:
+		return fmt.Sprintf("type %q", flag)
+	}
+}
+
+// spool buffers a nested archive so that it can be read at random offsets,
+// in memory when it is small and in a temporary file otherwise.
+func spool(r io.Reader) (io.ReaderAt, int64, func(), error) {
+	var buf bytes.Buffer
+	n, err := io.CopyN(&buf, r, spoolMemoryLimit+1)
+	if err != nil && err != io.EOF {
+		return nil, 0, nil, err
+	}
+	if n <= spoolMemoryLimit {
+		return bytes.NewReader(buf.Bytes()), n, func() {}, nil
+	}
+
+	tmp, err := os.CreateTemp("", "celestlsh-archive-*")
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	cleanup := func() {
+		tmp.Close()
+		os.Remove(tmp.Name())
+	}
+
+	size, err := io.Copy(tmp, io.MultiReader(&buf, r))
+	if err != nil {
+		cleanup()
+		return nil, 0, nil, err
+	}
+
+	return tmp, size, cleanup, nil
+}
+
+// budgetReader charges every byte read to a shared extraction budget, and
+// to a per-member limit, failing once either is exceeded rather than
+// reporting a short read. A negative member disables the member limit.
+type budgetReader struct {
+	r      io.Reader
+	budget *extractBudget
+	member int64
+}
+
+func (b *budgetReader) Read(p []byte) (int, error) {
+	limit := b.budget.remaining
+	if b.member >= 0 && b.member < limit {
+		limit = b.member
+	}
+
+	if limit <= 0 {
+		// Only a reader with data left has actually gone over the limit.
+		var probe [1]byte
+		if n, err := b.r.Read(probe[:]); n == 0 && err != nil {
+			return 0, err
+		}
+		if b.budget.remaining <= 0 {
+			b.budget.exhausted = true
+			return 0, errArchiveBudget
+		}
+		return 0, errMemberLimit
+	}
+
+	if int64(len(p)) > limit {
+		p = p[:limit]
+	}
+	n, err := b.r.Read(p)
+	b.budget.remaining -= int64(n)
+	if b.member >= 0 {
+		b.member -= int64(n)
+	}
+	return n, err
+}
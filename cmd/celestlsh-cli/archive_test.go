@@ -0,0 +1,270 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// archiveEntry is a member of a test archive. A non-empty link makes it a
+// symbolic link to that target, and a name ending in / a directory.
+type archiveEntry struct {
+	name string
+	data []byte
+	link string
+}
+
+func makeTar(t testing.TB, entries ...archiveEntry) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, e := range entries {
+		hdr := &tar.Header{Name: e.name, Mode: 0644, Size: int64(len(e.data)), Typeflag: tar.TypeReg}
+		switch {
+		case e.link != "":
+			hdr = &tar.Header{Name: e.name, Mode: 0777, Linkname: e.link, Typeflag: tar.TypeSymlink}
+		case strings.HasSuffix(e.name, "/"):
+			hdr = &tar.Header{Name: e.name, Mode: 0755, Typeflag: tar.TypeDir}
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write(e.data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func makeZip(t testing.TB, entries ...archiveEntry) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, e := range entries {
+		w, err := zw.Create(e.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write(e.data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func gzipData(t testing.TB, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write(data)
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestDetectArchive(t *testing.T) {
+	tarData := makeTar(t, archiveEntry{name: "a", data: []byte("a")})
+
+	tests := []struct {
+		name string
+		path string
+		data []byte
+		want archiveKind
+	}{
+		{"zip by content", "sample.bin", makeZip(t, archiveEntry{name: "a", data: []byte("a")}), zipArchive},
+		{"zip by extension", "sample.ZIP", []byte("garbage"), zipArchive},
+		{"tar by content", "sample.bin", tarData, tarArchive},
+		{"tar by extension", "sample.tar", []byte("garbage"), tarArchive},
+		{"tgz", "sample.tgz", gzipData(t, tarData), tarGzipArchive},
+		{"tar.gz", "sample.tar.gz", gzipData(t, tarData), tarGzipArchive},
+		{"gzipped tar without extension", "sample.gz", gzipData(t, tarData), tarGzipArchive},
+		{"plain gzip", "sample.gz", gzipData(t, testSample), notArchive},
+		{"executable", "sample.exe", []byte("MZ\x90\x00"), notArchive},
+		{"empty", "sample", nil, notArchive},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			head := tt.data
+			if len(head) > sniffLen {
+				head = head[:sniffLen]
+			}
+			if got := detectArchive(tt.path, head); got != tt.want {
+				t.Errorf("detectArchive = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestScanTarArchives(t *testing.T) {
+	dir := t.TempDir()
+	tarData := makeTar(t,
+		archiveEntry{name: "bin/"},
+		archiveEntry{name: "bin/known.exe", data: testSample},
+		archiveEntry{name: "bin/other.bin", data: sampleData(7, 4096)},
+		archiveEntry{name: "bin/empty"},
+		archiveEntry{name: "bin/link.exe", link: "known.exe"},
+	)
+	writeFile(t, dir, "sample.tar", tarData)
+	writeFile(t, dir, "sample.tar.gz", gzipData(t, tarData))
+	writeFile(t, dir, "renamed.bin", gzipData(t, tarData))
+	// A gzip of a single file is hashed as it is, not opened.
+	writeFile(t, dir, "notes.txt.gz", gzipData(t, sampleData(8, 4096)))
+
+	out, _, st, err := runCLI(t, "-s", "--jsonl", "--max-distance", "30", "--db", writeTestDatabase(t), dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st != statusMatch {
+		t.Errorf("status = %v, want a match", st)
+	}
+	results, summary := parseJSONL(t, out)
+	byPath := resultsByPath(results)
+
+	for _, archive := range []string{"sample.tar", "sample.tar.gz", "renamed.bin"} {
+		name := filepath.Join(dir, archive) + "!bin/known.exe"
+		r, ok := byPath[name]
+		if !ok || r.Match == nil || r.Match.FileName != "known.exe" || r.Match.Distance != 0 {
+			t.Errorf("%s: result %+v, want a match for known.exe at 0", name, r)
+		}
+		if r.TLSH != testRecords(t)[0].TLSHHash {
+			t.Errorf("%s: TLSH %s, want that of the sample", name, r.TLSH)
+		}
+		other := filepath.Join(dir, archive) + "!bin/other.bin"
+		if r, ok := byPath[other]; !ok || r.Error != "" || r.TLSH == "" {
+			t.Errorf("%s: result %+v, want a hash", other, r)
+		}
+		for _, skipped := range []string{"!bin/", "!bin/empty", "!bin/link.exe"} {
+			if _, ok := byPath[filepath.Join(dir, archive)+skipped]; ok {
+				t.Errorf("%s%s was scanned, want it skipped", archive, skipped)
+			}
+		}
+	}
+	gz := filepath.Join(dir, "notes.txt.gz")
+	if r, ok := byPath[gz]; !ok || r.TLSH == "" || r.Match != nil {
+		t.Errorf("%s: result %+v, want it hashed as a plain file", gz, r)
+	}
+
+	if len(results) != 7 || summary.Results != 7 || summary.Matched != 3 || summary.Files != 4 {
+		t.Errorf("%d results, summary %+v; want 7 results, 3 matched, from 4 files", len(results), summary)
+	}
+}
+
+func TestScanTarArchiveDepthZero(t *testing.T) {
+	dir := t.TempDir()
+	archive := writeFile(t, dir, "sample.tar", makeTar(t, archiveEntry{name: "known.exe", data: testSample}))
+
+	out, _, _, err := runCLI(t, "-s", "--jsonl", "--archive-depth", "0", "--db", writeTestDatabase(t), dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	results, _ := parseJSONL(t, out)
+	if len(results) != 1 || results[0].Path != archive {
+		t.Errorf("results = %+v, want the archive hashed as one file", results)
+	}
+}
+
+func TestScanTruncatedTar(t *testing.T) {
+	dir := t.TempDir()
+	data := makeTar(t, archiveEntry{name: "known.exe", data: testSample})
+	archive := writeFile(t, dir, "broken.tar", data[:len(data)/2])
+
+	out, _, st, err := runCLI(t, "-s", "--jsonl", "--db", writeTestDatabase(t), dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The member is cut short, and then so is the archive.
+	results, _ := parseJSONL(t, out)
+	byPath := resultsByPath(results)
+	if len(results) != 2 || byPath[archive+"!known.exe"].Error == "" || !strings.Contains(byPath[archive].Error, "failed to read tar archive") {
+		t.Errorf("results = %+v, want errors for the member and the archive", results)
+	}
+	if st != statusPartial {
+		t.Errorf("status = %v, want partial", st)
+	}
+}
+
+func TestScanNestedArchives(t *testing.T) {
+	dir := t.TempDir()
+	payload := makeZip(t, archiveEntry{name: "known.exe", data: testSample})
+	inner := gzipData(t, makeTar(t, archiveEntry{name: "payload.zip", data: payload}))
+	outer := writeFile(t, dir, "outer.zip", makeZip(t, archiveEntry{name: "inner.tgz", data: inner}))
+
+	tests := []struct {
+		depth string
+		want  string
+	}{
+		{"1", outer + "!inner.tgz"},
+		{"2", outer + "!inner.tgz!payload.zip"},
+		{"3", outer + "!inner.tgz!payload.zip!known.exe"},
+	}
+	for _, tt := range tests {
+		t.Run("depth "+tt.depth, func(t *testing.T) {
+			out, _, _, err := runCLI(t, "-s", "--jsonl", "--archive-depth", tt.depth, "--db", writeTestDatabase(t), dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			results, _ := parseJSONL(t, out)
+			if len(results) != 1 || results[0].Path != tt.want || results[0].Error != "" {
+				t.Fatalf("results = %+v, want one for %s", results, tt.want)
+			}
+			if tt.depth == "3" && (results[0].Match == nil || results[0].Match.Distance != 0) {
+				t.Errorf("match = %+v, want known.exe at 0", results[0].Match)
+			}
+		})
+	}
+}
+
+func TestScanArchiveBudget(t *testing.T) {
+	dir := t.TempDir()
+	// Eight MiB of zeros deflates to a few KiB.
+	bomb := writeFile(t, dir, "bomb.zip", makeZip(t,
+		archiveEntry{name: "known.exe", data: testSample},
+		archiveEntry{name: "zeros.bin", data: make([]byte, 8<<20)},
+		archiveEntry{name: "after.bin", data: sampleData(9, 4096)},
+	))
+	if info, err := os.Stat(bomb); err != nil || info.Size() > 64<<10 {
+		t.Fatalf("fixture is %v bytes (%v), want a high-ratio archive", info.Size(), err)
+	}
+
+	out, _, st, err := runCLI(t, "-s", "--jsonl", "--max-extracted", "1M", "--db", writeTestDatabase(t), dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	results, _ := parseJSONL(t, out)
+	byPath := resultsByPath(results)
+	if r := byPath[bomb+"!known.exe"]; r.Match == nil || r.Match.Distance != 0 {
+		t.Errorf("known.exe: result %+v, want it scanned before the budget ran out", r)
+	}
+	if r := byPath[bomb]; !strings.Contains(r.Error, "scan truncated") {
+		t.Errorf("archive: result %+v, want the scan reported as truncated", r)
+	}
+	if _, ok := byPath[bomb+"!after.bin"]; ok {
+		t.Errorf("after.bin was scanned after the budget ran out")
+	}
+	// A match outranks the failure.
+	if st != statusMatch {
+		t.Errorf("status = %v, want a match", st)
+	}
+
+	// Within the budget, the whole archive is scanned.
+	out, _, _, err = runCLI(t, "-s", "--jsonl", "--max-extracted", "16M", "--db", writeTestDatabase(t), dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	results, _ = parseJSONL(t, out)
+	if _, ok := resultsByPath(results)[bomb+"!after.bin"]; !ok {
+		t.Errorf("results = %+v, want after.bin scanned", results)
+	}
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Magonia-Research/CelesTLSH-CLI/pkg/celestlsh"
+)
+
+// benchSizes are the sizes of the generated buffers hashed by --bench.
+var benchSizes = []int{4 << 10, 64 << 10, 1 << 20, 16 << 20}
+
+// benchQueries is the number of distinct hashes looked up by --bench.
+const benchQueries = 64
+
+// benchReport is the outcome of --bench. Database is nil when the database
+// does not exist.
+type benchReport struct {
+	Workers  int            `json:"workers"`
+	Hashing  []hashingBench `json:"hashing"`
+	Database *databaseBench `json:"database,omitempty"`
+}
+
+type hashingBench struct {
+	Size        int     `json:"size"`
+	Hashes      int64   `json:"hashes"`
+	Seconds     float64 `json:"seconds"`
+	MBPerSecond float64 `json:"mb_per_second"`
+}
+
+// databaseBench times loading the database and looking hashes up in it.
+// ComparisonsPerSecond counts every record compared, so it is only set
+// without --max-distance, when lookups compare all of them.
+type databaseBench struct {
+	Path                 string  `json:"path"`
+	Records              int     `json:"records"`
+	LoadSeconds          float64 `json:"load_seconds"`
+	Queries              int64   `json:"queries"`
+	QueriesPerSecond     float64 `json:"queries_per_second"`
+	ComparisonsPerSecond float64 `json:"comparisons_per_second,omitempty"`
+}
+
+// executeBench measures hashing throughput on generated buffers with
+// --workers goroutines, and how long the database takes to load and to
+// look hashes up in. Each measurement repeats for at least --bench-time.
+func executeBench(ctx context.Context, config Config) error {
+	report := benchReport{Workers: workerCount(config)}
+
+	// The progress line names the measurement under way, as a full run
+	// takes several times --bench-time.
+	var stage atomic.Pointer[string]
+	setStage := func(format string, args ...any) {
+		s := fmt.Sprintf(format, args...)
+		stage.Store(&s)
+	}
+	var progress *progressLine
+	if progressEnabled(config) {
+		setStage("starting")
+		progress = startProgress(func(time.Duration) string { return "Benchmarking: " + *stage.Load() })
+	}
+	defer func() { progress.finish() }()
+
+	for _, size := range benchSizes {
+		setStage("hashing %s buffers", formatSize(int64(size)))
+		result, err := benchHashing(ctx, config, size)
+		if err != nil {
+			return err
+		}
+		report.Hashing = append(report.Hashing, result)
+	}
+
+	if _, err := os.Stat(config.DbPath); os.IsNotExist(err) {
+		clearProgress()
+		fmt.Fprintf(os.Stderr, "Warning: database %s not found, skipping the database benchmarks; download it first with --download\n", config.DbPath)
+	} else {
+		setStage("loading and searching %s", config.DbPath)
+		result, err := benchDatabase(ctx, config)
+		if err != nil {
+			return err
+		}
+		report.Database = result
+	}
+
+	progress.finish()
+	progress = nil
+
+	if config.OutputJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(report); err != nil {
+			return fmt.Errorf("failed to write results: %w", err)
+		}
+		return nil
+	}
+	printBenchReport(report)
+	return nil
+}
+
+// benchHashing hashes a pseudorandom buffer of size bytes on every worker
+// until --bench-time has passed.
+func benchHashing(ctx context.Context, config Config, size int) (hashingBench, error) {
+	data := pseudorandom(uint64(size), size)
+	hasher := newHasher(config)
+
+	var hashes atomic.Int64
+	var mu sync.Mutex
+	var failure error
+	start := time.Now()
+	deadline := start.Add(config.BenchTime)
+	var wg sync.WaitGroup
+	for range workerCount(config) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ctx.Err() == nil {
+				if _, err := hasher.HashBytes(data); err != nil {
+					mu.Lock()
+					failure = err
+					mu.Unlock()
+					return
+				}
+				if hashes.Add(1); time.Now().After(deadline) {
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	if err := ctx.Err(); err != nil {
+		return hashingBench{}, err
+	}
+	if failure != nil {
+		return hashingBench{}, fmt.Errorf("failed to calculate TLSH hash: %w", failure)
+	}
+	n := hashes.Load()
+	return hashingBench{
+		Size:        size,
+		Hashes:      n,
+		Seconds:     elapsed.Seconds(),
+		MBPerSecond: float64(n) * float64(size) / 1e6 / elapsed.Seconds(),
+	}, nil
+}
+
+// benchDatabase loads the database until --bench-time has passed, and then
+// looks up generated hashes, each with the --workers goroutines of a check,
+// for as long again.
+func benchDatabase(ctx context.Context, config Config) (*databaseBench, error) {
+	var db *celestlsh.Database
+	loads := 0
+	start := time.Now()
+	for loads == 0 || time.Since(start) < config.BenchTime {
+		var err error
+		db, err = celestlsh.Load(ctx, config.DbPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load database: %w", err)
+		}
+		loads++
+	}
+	load := time.Since(start) / time.Duration(loads)
+	logDatabase(config.DbPath, db, load)
+	db.Workers = config.Workers
+
+	queries := make([]string, benchQueries)
+	hasher := newHasher(config)
+	for i := range queries {
+		hash, err := hasher.HashBytes(pseudorandom(uint64(i+1), 4<<10))
+		if err != nil {
+			return nil, fmt.Errorf("failed to calculate TLSH hash: %w", err)
+		}
+		queries[i] = hash
+	}
+
+	var n int64
+	start = time.Now()
+	for n == 0 || time.Since(start) < config.BenchTime {
+		if _, err := db.Nearest(ctx, queries[n%benchQueries], config.MaxDistance, 1); err != nil {
+			return nil, fmt.Errorf("failed to check TLSH against database: %w", err)
+		}
+		n++
+	}
+	elapsed := time.Since(start)
+	slog.Debug("database benchmarked", "loads", loads, "queries", n, "duration", elapsed)
+
+	result := &databaseBench{
+		Path:             config.DbPath,
+		Records:          db.Len(),
+		LoadSeconds:      load.Seconds(),
+		Queries:          n,
+		QueriesPerSecond: float64(n) / elapsed.Seconds(),
+	}
+	if config.MaxDistance < 0 {
+		result.ComparisonsPerSecond = result.QueriesPerSecond * float64(db.Len())
+	}
+	return result, nil
+}
+
+func printBenchReport(report benchReport) {
+	fmt.Printf("Workers: %d\n", report.Workers)
+	fmt.Println("Hashing:")
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	for _, h := range report.Hashing {
+		fmt.Fprintf(tw, "  %s\t%.1f MB/s\t%d hashes in %.2fs\t\n", formatSize(int64(h.Size)), h.MBPerSecond, h.Hashes, h.Seconds)
+	}
+	tw.Flush()
+
+	d := report.Database
+	if d == nil {
+		return
+	}
+	fmt.Printf("Database %s: %d records, loaded in %s\n", d.Path, d.Records, time.Duration(d.LoadSeconds*float64(time.Second)).Round(time.Microsecond))
+	if d.ComparisonsPerSecond > 0 {
+		fmt.Printf("Checks: %.1f queries/s, %.0f comparisons/s\n", d.QueriesPerSecond, d.ComparisonsPerSecond)
+	} else {
+		fmt.Printf("Checks: %.1f queries/s\n", d.QueriesPerSecond)
+	}
+}
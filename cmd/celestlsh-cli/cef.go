@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// cefHeaderEscaper escapes the characters CEF reserves in header fields.
+var cefHeaderEscaper = strings.NewReplacer(`\`, `\\`, `|`, `\|`)
+
+// cefExtensionEscaper escapes the characters CEF reserves in extension
+// values. Pipes need no escaping there.
+var cefExtensionEscaper = strings.NewReplacer(`\`, `\\`, `=`, `\=`, "\n", `\n`, "\r", `\r`)
+
+// cefSeverity maps a TLSH distance to a CEF severity from 0 to 10: the
+// closer the match, the more likely the file is a build of the tool.
+func cefSeverity(distance int) int {
+	switch {
+	case distance == 0:
+		return 10
+	case distance <= 30:
+		return 8
+	case distance <= 70:
+		return 6
+	case distance <= 150:
+		return 4
+	default:
+		return 2
+	}
+}
+
+// cefLine renders a match as a CEF event.
+func cefLine(result scanResult) string {
+	m := result.Match
+
+	header := []string{
+		"CEF:0",
+		"Magonia",
+		"CelesTLSH",
+		cefHeaderEscaper.Replace(version),
+		"tlsh-match",
+		cefHeaderEscaper.Replace("TLSH match: " + m.RepoName),
+		fmt.Sprint(cefSeverity(m.Distance)),
+	}
+
+	var ext []string
+	field := func(key, value string) {
+		if value != "" {
+			ext = append(ext, key+"="+cefExtensionEscaper.Replace(value))
+		}
+	}
+	if result.Path != "" {
+		field("filePath", result.Path)
+		field("fname", filepath.Base(result.Path))
+	}
+	field("fileHash", result.SHA256)
+	for _, custom := range []struct{ key, label, value string }{
+		{"cs1", "TLSH", result.TLSH},
+		{"cs2", "Matched Repository", m.RepoName},
+		{"cs3", "Matched File", m.FileName},
+		{"cs4", "Matched Version", m.Version},
+		{"cs5", "Matched SHA256", m.SHA256Hash},
+		{"cs6", "Match Type", result.MatchType},
+		{"cn1", "TLSH Distance", fmt.Sprint(m.Distance)},
+	} {
+		if custom.value != "" {
+			field(custom.key+"Label", custom.label)
+			field(custom.key, custom.value)
+		}
+	}
+
+	return strings.Join(header, "|") + "|" + strings.Join(ext, " ")
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/Magonia-Research/CelesTLSH-CLI/pkg/celestlsh"
+)
+
+func TestCEFEscaping(t *testing.T) {
+	tests := []struct {
+		path, wantPath, wantName string
+	}{
+		{`/srv/drop/tool.exe`, `/srv/drop/tool.exe`, `tool.exe`},
+		{`/srv/a|b/c|d.exe`, `/srv/a|b/c|d.exe`, `c|d.exe`},
+		{`C:\Users\x\t.exe`, `C:\\Users\\x\\t.exe`, `C:\\Users\\x\\t.exe`},
+		{`/srv/k=v/a=b.exe`, `/srv/k\=v/a\=b.exe`, `a\=b.exe`},
+		{"/srv/line\nbreak\r.exe", `/srv/line\nbreak\r.exe`, `line\nbreak\r.exe`},
+		{`/srv/\|=\=.exe`, `/srv/\\|\=\\\=.exe`, `\\|\=\\\=.exe`},
+	}
+	for _, tt := range tests {
+		line := cefLine(scanResult{
+			Path:  tt.path,
+			Match: &celestlsh.HashRecord{RepoName: "Known|Tool\\X", FileName: "k.exe", Distance: 12},
+		})
+		header := `CEF:0|Magonia|CelesTLSH|dev|tlsh-match|TLSH match: Known\|Tool\\X|8|`
+		if !strings.HasPrefix(line, header) {
+			t.Errorf("%q: header of %q, want %q", tt.path, line, header)
+		}
+		ext := strings.TrimPrefix(line, header)
+		if !strings.HasPrefix(ext, "filePath="+tt.wantPath+" fname="+tt.wantName+" ") {
+			t.Errorf("%q: extension %q, want filePath=%s fname=%s", tt.path, ext, tt.wantPath, tt.wantName)
+		}
+		// Pipes are left alone in extensions, and the repository there
+		// only has its backslash escaped.
+		if !strings.Contains(ext, `cs2=Known|Tool\\X `) {
+			t.Errorf("%q: extension %q lacks the escaped repository", tt.path, ext)
+		}
+	}
+}
+
+func TestCEFSeverity(t *testing.T) {
+	for _, tt := range []struct{ distance, want int }{
+		{0, 10}, {1, 8}, {30, 8}, {31, 6}, {70, 6}, {71, 4}, {150, 4}, {151, 2}, {400, 2},
+	} {
+		if got := cefSeverity(tt.distance); got != tt.want {
+			t.Errorf("cefSeverity(%d) = %d, want %d", tt.distance, got, tt.want)
+		}
+	}
+}
+
+func TestScanCEF(t *testing.T) {
+	dir := t.TempDir()
+	known := writeFile(t, dir, "known.exe", testSample)
+	writeFile(t, dir, "other.bin", sampleData(5, 8192))
+
+	out, _, _, err := runCLI(t, "-s", "--sha256", "--format", "cef", "--max-distance", "30", "--db", writeTestDatabase(t), dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(out, "\n"), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("lines = %q, want one event for the match", lines)
+	}
+	for _, want := range []string{
+		"|10|filePath=" + known + " ",
+		" fileHash=" + sha256sum(t, known) + " ",
+		" cs1Label=TLSH cs1=" + testHash(t, testSample) + " ",
+		" cn1Label=TLSH Distance cn1=0",
+	} {
+		if !strings.Contains(lines[0], want) {
+			t.Errorf("event %q lacks %q", lines[0], want)
+		}
+	}
+}
@@ -0,0 +1,447 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/Magonia-Research/CelesTLSH-CLI/pkg/celestlsh"
+)
+
+// defaultResultLimit is the --limit of check, cross-check, serve and
+// daemon modes: more matches than anyone reads, yet few enough that a
+// query matching much of the database does not hold all of it in memory.
+const defaultResultLimit = 5000
+
+// checkResult is the outcome of checking one hash in check mode. Match is
+// nil when nothing was found within the distance limit. Identical holds
+// the other records at the same distance as Match, such as the same binary
+// shipped in several releases, up to --limit records in all, and
+// IdenticalTruncated is set if there were more. Matches, in place of
+// Identical, is only set with --top, Repos with --group-by-repo, Histogram
+// with --histogram, and Explain, the terms of the distance to Match, with
+// --explain.
+type checkResult struct {
+	TLSH               string                   `json:"tlsh"`
+	Match              *celestlsh.HashRecord    `json:"match,omitempty"`
+	Identical          []celestlsh.HashRecord   `json:"identical_matches,omitempty"`
+	IdenticalTruncated bool                     `json:"identical_truncated,omitempty"`
+	Confidence         string                   `json:"confidence,omitempty"`
+	Matches            []celestlsh.HashRecord   `json:"matches,omitempty"`
+	Explain            *celestlsh.DistanceParts `json:"explain,omitempty"`
+	Repos              []celestlsh.RepoMatch    `json:"repos,omitempty"`
+	Histogram          []histogramBucket        `json:"histogram,omitempty"`
+	Suppressed         bool                     `json:"suppressed,omitempty"`
+	Error              string                   `json:"error,omitempty"`
+	ErrorCode          string                   `json:"error_code,omitempty"`
+}
+
+// scanResult returns the result in the form shared with scan mode, for the
+// allowlist, colours and the document formats.
+func (r checkResult) scanResult() scanResult {
+	return scanResult{
+		Path:       r.TLSH,
+		Digests:    celestlsh.Digests{TLSH: r.TLSH},
+		Match:      r.Match,
+		Confidence: r.Confidence,
+		Repos:      r.Repos,
+		Suppressed: r.Suppressed,
+		Error:      r.Error,
+		ErrorCode:  r.ErrorCode,
+	}
+}
+
+func executeCheck(ctx context.Context, config Config) (status, error) {
+	allow, err := loadAllowlist(config.Allowlist)
+	if err != nil {
+		return statusOK, err
+	}
+
+	lookup := &checkLookup{config: config, noDaemon: !useDaemon(config)}
+	if lookup.remote, err = newRemoteLookup(config); err != nil {
+		return statusOK, err
+	}
+	defer lookup.remote.close()
+	age, err := checkStale(config)
+	if err != nil {
+		return statusOK, err
+	}
+	results := make([]checkResult, len(config.Hashes))
+	for i, hash := range config.Hashes {
+		if err := ctx.Err(); err != nil {
+			return statusOK, err
+		}
+		result := &results[i]
+		result.TLSH = hash
+
+		// An invalid hash fails the run when it is the only one, and
+		// otherwise only its own result.
+		if err := celestlsh.ValidateHash(hash); err != nil {
+			// Labelled as the database lookup labels it.
+			var hashErr *celestlsh.HashError
+			if errors.As(err, &hashErr) {
+				err = &celestlsh.HashError{Role: "input", Hash: hashErr.Hash, Err: hashErr.Err}
+			}
+			err = fmt.Errorf("failed to check TLSH against database: %w", err)
+			if len(config.Hashes) == 1 {
+				return statusOK, err
+			}
+			result.Error, result.ErrorCode = err.Error(), errorCode(err)
+			continue
+		}
+
+		if config.GroupByRepo {
+			result.Repos, err = lookup.nearestRepos(ctx, hash)
+			if len(result.Repos) > 0 {
+				best := result.Repos[0].HashRecord
+				sortRepos(config.Sort, result.Repos)
+				result.Match = &best
+			}
+		} else if config.Top != -1 {
+			result.Matches, err = lookup.query(ctx, hash, config.MaxDistance, config.Top)
+			if len(result.Matches) > 0 {
+				best := result.Matches[0]
+				sortMatches(config.Sort, result.Matches)
+				result.Match = &best
+			}
+		} else {
+			result.Match, result.Identical, result.IdenticalTruncated, err = lookup.nearest(ctx, hash)
+		}
+		if err != nil {
+			return statusOK, err
+		}
+		if config.Histogram {
+			matches, err := lookup.all(ctx, hash)
+			if err != nil {
+				return statusOK, err
+			}
+			result.Histogram = distanceHistogram(matches, config.HistogramWidth)
+		}
+		if result.Match == nil {
+			continue
+		}
+
+		result.Confidence = config.ConfidenceBands.label(result.Match.Distance)
+		if config.Explain {
+			parts, err := celestlsh.ExplainDistance(hash, result.Match.TLSHHash)
+			if err != nil {
+				return statusOK, err
+			}
+			result.Explain = &parts
+		}
+		result.Suppressed = allow.suppresses(result.scanResult())
+		if result.Suppressed && !config.ShowSuppressed {
+			result.Match, result.Identical, result.IdenticalTruncated = nil, nil, false
+			result.Confidence, result.Matches, result.Repos, result.Explain = "", nil, nil, nil
+		}
+	}
+
+	return printCheckResults(config, results, age)
+}
+
+// checkLookup looks hashes up on the --remote or --grpc server, if set, or
+// in the daemon listening on --socket, if it is running, and otherwise in
+// the database, which is loaded once.
+type checkLookup struct {
+	config   Config
+	db       *celestlsh.Database
+	remote   *remoteLookup
+	noDaemon bool
+}
+
+// nearest returns the closest record to hash within --max-distance, or
+// nil, and the other records at the same distance, in database order, up
+// to --limit records in all; truncated is set if there were more. It asks
+// the daemon or the database twice: for the closest record, and for every
+// record as close.
+func (l *checkLookup) nearest(ctx context.Context, hash string) (best *celestlsh.HashRecord, identical []celestlsh.HashRecord, truncated bool, err error) {
+	matches, err := l.query(ctx, hash, l.config.MaxDistance, 1)
+	if err != nil || len(matches) == 0 {
+		return nil, nil, false, err
+	}
+	matches, err = l.query(ctx, hash, matches[0].Distance, l.config.Limit+1)
+	if err != nil {
+		return nil, nil, false, err
+	}
+	if len(matches) > l.config.Limit {
+		matches, truncated = matches[:l.config.Limit], true
+	}
+	return &matches[0], matches[1:], truncated, nil
+}
+
+// query returns up to top records within maxDistance of hash, closest
+// first, from the --remote or --grpc server, the daemon if it is running, or the
+// database.
+func (l *checkLookup) query(ctx context.Context, hash string, maxDistance, top int) ([]celestlsh.HashRecord, error) {
+	if l.remote != nil {
+		matches, err := l.remote.query(ctx, hash, maxDistance, top)
+		if err != nil {
+			return nil, fmt.Errorf("failed to check TLSH against %s: %w", l.remote.name, err)
+		}
+		return matches, nil
+	}
+	if l.config.Socket != "" && !l.noDaemon {
+		req := checkRequest{TLSH: hash, Top: &top}
+		if maxDistance >= 0 {
+			req.MaxDistance = &maxDistance
+		}
+		resp, err := queryDaemon(ctx, l.config.Socket, req)
+		slog.Debug("daemon query", "socket", l.config.Socket, "error", err)
+		if err == nil {
+			return resp.Matches, nil
+		}
+		if !errors.Is(err, errDaemonUnavailable) {
+			return nil, fmt.Errorf("failed to check TLSH against database: %w", err)
+		}
+		l.noDaemon = true
+	}
+
+	if err := l.load(ctx); err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	matches, err := l.db.Nearest(ctx, hash, maxDistance, top)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check TLSH against database: %w", err)
+	}
+	slog.Debug("database searched", "tlsh", hash, "max_distance", maxDistance, "matches", len(matches), "duration", time.Since(start))
+	return matches, nil
+}
+
+// nearestRepos returns the best match of each repository within
+// --max-distance, for --group-by-repo. The daemon only answers with the
+// closest records, which do not necessarily cover every repository, so
+// the database is always used.
+func (l *checkLookup) nearestRepos(ctx context.Context, hash string) ([]celestlsh.RepoMatch, error) {
+	if err := l.load(ctx); err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	repos, err := l.db.NearestRepos(ctx, hash, l.config.MaxDistance, l.config.Top)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check TLSH against database: %w", err)
+	}
+	slog.Debug("database searched", "tlsh", hash, "max_distance", l.config.MaxDistance, "repos", len(repos), "duration", time.Since(start))
+	return repos, nil
+}
+
+// all returns every record within --max-distance, for --histogram. Like
+// nearestRepos, it always uses the database.
+func (l *checkLookup) all(ctx context.Context, hash string) ([]celestlsh.HashRecord, error) {
+	if err := l.load(ctx); err != nil {
+		return nil, err
+	}
+	matches, err := l.db.Nearest(ctx, hash, l.config.MaxDistance, -1)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check TLSH against database: %w", err)
+	}
+	return matches, nil
+}
+
+// load loads the database, unless it already was.
+func (l *checkLookup) load(ctx context.Context) error {
+	if l.db != nil {
+		return nil
+	}
+	if _, err := os.Stat(l.config.DbPath); os.IsNotExist(err) && l.config.DbPath != stdinDatabase && !useEmbedded(l.config) {
+		return fmt.Errorf("%w; download it first with --download", &celestlsh.DatabaseNotFoundError{Path: l.config.DbPath})
+	}
+
+	start := time.Now()
+	db, err := loadVerifiedDatabase(ctx, l.config, databaseFilters(l.config)...)
+	if err != nil {
+		return fmt.Errorf("failed to check TLSH against database: %w", err)
+	}
+	db.Workers = l.config.Workers
+	logDatabase(l.config.DbPath, db, time.Since(start))
+	l.db = db
+	return nil
+}
+
+// printCheckResults prints the results of the checked hashes in the order
+// they were given. When several hashes are checked, each result is
+// labelled with its hash. A match suppressed by the allowlist is only
+// printed with --show-suppressed, and never counts as a match for the
+// exit status.
+// printCheckResults prints the results of check mode. age is that of the
+// database, or -1 if unknown, for the summary that ends --jsonl output.
+func printCheckResults(config Config, results []checkResult, age time.Duration) (status, error) {
+	matched, failed := 0, 0
+	for _, r := range results {
+		switch {
+		case r.Error != "":
+			failed++
+		case r.Match != nil && !r.Suppressed:
+			matched++
+		}
+	}
+	several := len(results) > 1
+
+	switch {
+	case config.Format == "cef":
+		// Only matches are events.
+		for _, r := range results {
+			if r.Error != "" {
+				printResultError(config, r.scanResult())
+			} else if r.Match != nil && !r.Suppressed {
+				fmt.Println(cefLine(r.scanResult()))
+			}
+		}
+
+	case newDocument(config) != nil:
+		doc := newDocument(config)
+		for _, r := range results {
+			if !r.Suppressed {
+				doc.add(r.scanResult())
+			}
+		}
+		if err := doc.write(os.Stdout); err != nil {
+			return statusOK, fmt.Errorf("failed to write results: %w", err)
+		}
+
+	case config.OutputJSONL:
+		for _, r := range results {
+			line, err := json.Marshal(r)
+			if err != nil {
+				return statusOK, fmt.Errorf("failed to write results: %w", err)
+			}
+			fmt.Println(string(line))
+		}
+		summary := jsonlSummary{Files: int64(len(results)), Results: int64(len(results)), Matched: int64(matched), Failed: int64(failed)}
+		summary.setDatabaseAge(config, age)
+		printJSONLSummary(summary)
+
+	case config.Template != nil:
+		for _, r := range results {
+			if r.Error != "" {
+				printResultError(config, r.scanResult())
+			} else {
+				printTemplate(config, r.scanResult())
+			}
+		}
+
+	case config.OutputJSON:
+		checked := []checkResult{}
+		for _, r := range results {
+			if r.Error != "" {
+				printResultError(config, r.scanResult())
+			} else {
+				checked = append(checked, r)
+			}
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(checked); err != nil {
+			return statusOK, fmt.Errorf("failed to write results: %w", err)
+		}
+
+	default:
+		for i, r := range results {
+			if r.Error != "" {
+				printResultError(config, r.scanResult())
+				continue
+			}
+			if several && textFormat(config) {
+				if i > 0 {
+					fmt.Println()
+				}
+				fmt.Printf("Hash: %s\n", r.TLSH)
+			}
+			if !config.HistogramOnly {
+				printCheckResult(config, r, several)
+			}
+			if config.Histogram {
+				printHistogram(r.Histogram)
+			}
+		}
+	}
+
+	return batchStatus(matched, failed), nil
+}
+
+// printCheckResult prints the result of one hash in the text, CSV or quiet
+// format. When several hashes are checked, CSV rows and quiet lines start
+// with the hash; a single hash keeps the columns it always had.
+func printCheckResult(config Config, r checkResult, several bool) {
+	prefix := ""
+	if several {
+		prefix = r.TLSH + ","
+	}
+	switch {
+	case config.OutputCSV && r.Repos != nil:
+		for _, repo := range r.Repos {
+			fmt.Printf("%s%s,%s,%s,%s,%d,%s,%d\n", prefix, repo.RepoName, repo.FileName, repo.Version, repo.SHA256Hash, repo.Distance, config.ConfidenceBands.label(repo.Distance), repo.Count)
+		}
+
+	case config.OutputCSV && r.Match == nil && several:
+		fmt.Printf("%s,,,,,,\n", r.TLSH)
+
+	case config.OutputCSV && r.Matches != nil:
+		for _, m := range r.Matches {
+			fmt.Printf("%s%s,%s,%s,%s,%d,%s\n", prefix, m.RepoName, m.FileName, m.Version, m.SHA256Hash, m.Distance, config.ConfidenceBands.label(m.Distance))
+		}
+
+	case config.OutputCSV && r.Match != nil:
+		m := r.Match
+		fmt.Printf("%s%s,%s,%s,%s,%d,%s\n", prefix, m.RepoName, m.FileName, m.Version, m.SHA256Hash, m.Distance, r.Confidence)
+
+	case config.Quiet:
+		var sums []string
+		if r.Repos != nil {
+			for _, repo := range r.Repos {
+				sums = append(sums, repo.SHA256Hash)
+			}
+		} else if r.Matches != nil {
+			for _, m := range r.Matches {
+				sums = append(sums, m.SHA256Hash)
+			}
+		} else if r.Match != nil {
+			sums = append(sums, r.Match.SHA256Hash)
+		}
+		for _, sum := range sums {
+			if several {
+				fmt.Printf("%s %s\n", r.TLSH, sum)
+			} else {
+				fmt.Println(sum)
+			}
+		}
+
+	case r.Match == nil:
+		if r.Suppressed {
+			fmt.Println(paint(styleClean, "No matches found in the database outside the allowlist"))
+		} else {
+			fmt.Println(paint(styleClean, "No matches found in the database"))
+		}
+
+	case r.Repos != nil || len(r.Matches) > 1 || len(r.Identical) > 0 || config.Table:
+		printMatchTable(config, r)
+
+	default:
+		m := r.Match
+		style := resultStyle(config, r.scanResult())
+		if r.Suppressed {
+			fmt.Println(paint(style, "Best match found (suppressed by the allowlist):"))
+		} else {
+			fmt.Println(paint(style, "Best match found:"))
+		}
+		fmt.Printf("  Tool: %s\n", m.RepoName)
+		fmt.Printf("  File: %s\n", m.FileName)
+		fmt.Printf("  SHA256: %s\n", m.SHA256Hash)
+		if confidence := confidencePhrase(r.Confidence); confidence != "" {
+			fmt.Printf("  Distance: %s (%s)\n", paint(style, strconv.Itoa(m.Distance)), confidence)
+		} else {
+			fmt.Printf("  Distance: %s\n", paint(style, strconv.Itoa(m.Distance)))
+		}
+		if r.Explain != nil {
+			fmt.Printf("  %s\n", formatDistanceParts(*r.Explain))
+		}
+		if r.IdenticalTruncated {
+			fmt.Printf("  (more records at this distance not shown; raise --limit to list them)\n")
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/Magonia-Research/CelesTLSH-CLI/pkg/celestlsh"
+)
+
+func TestCheckInvalidHash(t *testing.T) {
+	_, _, _, err := runCLI(t, "-c", "--db", writeTestDatabase(t), "bogus")
+	if err == nil || !strings.Contains(err.Error(), "error parsing input hash: wrong length") {
+		t.Errorf("error = %v, want the input hash named", err)
+	}
+}
+
+func TestCheckT1Prefix(t *testing.T) {
+	records := testRecords(t)
+	prefixed := testRecords(t)
+	for i := range prefixed {
+		prefixed[i].TLSHHash = celestlsh.WithT1Prefix(prefixed[i].TLSHHash)
+	}
+
+	tests := []struct {
+		name  string
+		db    []celestlsh.HashRecord
+		query string
+	}{
+		{"T1 query, plain rows", records, celestlsh.WithT1Prefix(records[0].TLSHHash)},
+		{"plain query, T1 rows", prefixed, records[0].TLSHHash},
+		{"lowercase t1 query", prefixed, "t1" + strings.ToLower(records[0].TLSHHash)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, _, st, err := runCLI(t, "-c", "--json", "--db", writeTestDatabase(t, tt.db...), tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if st != statusMatch || !strings.Contains(out, `"file_name": "known.exe"`) || !strings.Contains(out, `"distance": 0`) {
+				t.Errorf("status %v, output:\n%s\nwant known.exe at 0", st, out)
+			}
+		})
+	}
+}
+
+func TestHashT1(t *testing.T) {
+	path := writeFile(t, t.TempDir(), "known.exe", testSample)
+	hash := testRecords(t)[0].TLSHHash
+	for _, tt := range []struct {
+		args []string
+		want string
+	}{
+		{nil, hash},
+		{[]string{"--t1"}, "T1" + hash},
+	} {
+		out, _, _, err := runCLI(t, append(append([]string{"-h", "--quiet"}, tt.args...), path)...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.TrimSpace(out) != tt.want {
+			t.Errorf("hash %v = %q, want %q", tt.args, out, tt.want)
+		}
+	}
+}
+
+func TestCheckDateRange(t *testing.T) {
+	records := testRecords(t)
+	records[0].DateAdded = "2024-03-01T18:00:00Z" // known.exe
+	records[1].DateAdded = "2024/03/02"           // known-variant.exe
+	records[2].DateAdded = ""
+	records[3].DateAdded = "2023-12-31 23:00:00"
+	db := writeTestDatabase(t, records...)
+	query := records[0].TLSHHash
+
+	tests := []struct {
+		args  []string
+		match string
+	}{
+		// A date-only --until includes that whole day.
+		{[]string{"--until", "2024-03-01"}, "known.exe"},
+		{[]string{"--until", "2024-03-01T12:00"}, ""},
+		{[]string{"--since", "2024-03-02"}, "known-variant.exe"},
+		{[]string{"--since", "2024-01-01", "--until", "2024-03-02T00:00:00Z", "--strict-dates"}, "known.exe"},
+	}
+	for _, tt := range tests {
+		args := append(append([]string{"-c", "--json", "--max-distance", "100", "--db", db}, tt.args...), query)
+		out, _, st, err := runCLI(t, args...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tt.match == "" {
+			if st == statusMatch {
+				t.Errorf("%v matched:\n%s", tt.args, out)
+			}
+			continue
+		}
+		if st != statusMatch || !strings.Contains(out, `"file_name": "`+tt.match+`"`) {
+			t.Errorf("%v: status %v, output:\n%s\nwant %s", tt.args, st, out, tt.match)
+		}
+	}
+}
+
+func TestCheckCSVColumns(t *testing.T) {
+	db := writeTestDatabase(t)
+	hash := testRecords(t)[0].TLSHHash
+	far := testHash(t, sampleData(99, 8192))
+
+	// A single hash keeps the columns check mode has always printed.
+	out, _, _, err := runCLI(t, "-c", "--csv", "--db", db, hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rows := readCSV(t, out); len(rows) != 1 || len(rows[0]) != 6 || rows[0][0] != "KnownTool" || rows[0][4] != "0" {
+		t.Errorf("single hash CSV = %q, want RepoName,FileName,Version,SHA256Hash,Distance,Confidence", rows)
+	}
+
+	// Several start each row with their hash, empty when nothing matched.
+	out, _, _, err = runCLI(t, "-c", "--csv", "--db", db, hash, far)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows := readCSV(t, out)
+	if len(rows) != 2 || len(rows[0]) != 7 || rows[0][0] != hash || rows[0][1] != "KnownTool" || rows[1][0] != far || rows[1][1] != "" {
+		t.Errorf("several hashes CSV = %q, want each row led by its hash", rows)
+	}
+}
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// checkpointInterval is how often a scan's progress is saved to its
+// --checkpoint file.
+const checkpointInterval = 5 * time.Second
+
+// checkpointState is the content of a --checkpoint file. Walks visit
+// entries in a fixed order and results are printed in that order, so the
+// progress of a scan is the last file finished, its frontier: everything
+// before it in the root it belongs to, and every earlier root, is done.
+type checkpointState struct {
+	Roots    []string `json:"roots"`
+	Root     int      `json:"root"`
+	Frontier string   `json:"frontier,omitempty"`
+
+	// Done lists files finished after the frontier, which concurrent
+	// workers can leave behind when a scan is interrupted.
+	Done []string `json:"done,omitempty"`
+
+	// Files, Matched and Failed are the totals of the runs so far, so that
+	// the run that finishes the scan can exit with the status of it all.
+	Files   int64 `json:"files"`
+	Matched int64 `json:"matched"`
+	Failed  int64 `json:"failed"`
+
+	Updated string `json:"updated"`
+}
+
+// checkpoint saves the progress of a scan to a file and, when the scan is
+// restarted with the same file, skips what was already done.
+type checkpoint struct {
+	path  string
+	stats *scanStats
+
+	// resumed is the state loaded at startup, whose totals are added to
+	// this run's; current is the root being walked.
+	resumed checkpointState
+	current int
+
+	done map[string]bool
+
+	mu      sync.Mutex
+	state   checkpointState
+	changed bool
+	stalled bool
+
+	stop    chan struct{}
+	stopped sync.WaitGroup
+}
+
+// checkpointPos is the position of a file in the scan.
+type checkpointPos struct {
+	root int
+	path string
+}
+
+// newCheckpoint returns the checkpoint for --checkpoint, or nil if it is not
+// set, resuming from the file if it exists. Saving starts with start.
+func newCheckpoint(config Config, stats *scanStats) (*checkpoint, error) {
+	if config.Checkpoint == "" {
+		return nil, nil
+	}
+	c := &checkpoint{path: config.Checkpoint, stats: stats}
+
+	data, err := os.ReadFile(config.Checkpoint)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		c.resumed = checkpointState{Roots: config.Paths, Root: -1}
+	case err != nil:
+		return nil, fmt.Errorf("failed to read checkpoint: %w", err)
+	default:
+		if err := json.Unmarshal(data, &c.resumed); err != nil {
+			return nil, fmt.Errorf("failed to read checkpoint %s: %v", config.Checkpoint, err)
+		}
+		if !slices.Equal(c.resumed.Roots, config.Paths) {
+			return nil, fmt.Errorf("checkpoint %s was saved by a scan of %s; remove it to start over", config.Checkpoint, strings.Join(c.resumed.Roots, ", "))
+		}
+	}
+	c.state = c.resumed
+	c.done = make(map[string]bool, len(c.resumed.Done))
+	for _, path := range c.resumed.Done {
+		c.done[path] = true
+	}
+	return c, nil
+}
+
+// resuming reports whether the scan continues an earlier one.
+func (c *checkpoint) resuming() bool {
+	return c != nil && c.resumed.Root >= 0
+}
+
+// resumedFiles, resumedMatched and resumedFailed are the counts of the
+// earlier runs.
+func (c *checkpoint) resumedFiles() int64 {
+	if c == nil {
+		return 0
+	}
+	return c.resumed.Files
+}
+
+func (c *checkpoint) resumedMatched() int64 {
+	if c == nil {
+		return 0
+	}
+	return c.resumed.Matched
+}
+
+func (c *checkpoint) resumedFailed() int64 {
+	if c == nil {
+		return 0
+	}
+	return c.resumed.Failed
+}
+
+// start saves the checkpoint every checkpointInterval until finish.
+func (c *checkpoint) start() {
+	if c == nil {
+		return
+	}
+	c.stop = make(chan struct{})
+	c.stopped.Add(1)
+	go func() {
+		defer c.stopped.Done()
+		ticker := time.NewTicker(checkpointInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-c.stop:
+				return
+			case <-ticker.C:
+				if err := c.save(); err != nil {
+					clearProgress()
+					fmt.Fprintf(os.Stderr, "Warning: failed to save checkpoint: %v\n", err)
+				}
+			}
+		}
+	}()
+}
+
+// enter records that the walk of the root at index i begins.
+func (c *checkpoint) enter(i int) {
+	if c != nil {
+		c.current = i
+	}
+}
+
+// position returns where path, in the root being walked, is in the scan.
+func (c *checkpoint) position(path string) checkpointPos {
+	if c == nil {
+		return checkpointPos{}
+	}
+	return checkpointPos{root: c.current, path: path}
+}
+
+// skipFile reports whether the file at path, in the root being walked, was
+// finished by an earlier run.
+func (c *checkpoint) skipFile(path string) bool {
+	if c != nil && c.done[path] {
+		return true
+	}
+	if c == nil || c.current != c.resumed.Root {
+		return c != nil && c.current < c.resumed.Root
+	}
+	return walkOrder(c.resumed.Roots[c.current], path, c.resumed.Frontier) <= 0
+}
+
+// skipDir reports whether every file below the directory at path, in the
+// root being walked, was finished by an earlier run.
+func (c *checkpoint) skipDir(path string) bool {
+	if c == nil || c.current != c.resumed.Root {
+		return c != nil && c.current < c.resumed.Root
+	}
+	return walkOrder(c.resumed.Roots[c.current], path, c.resumed.Frontier) < 0 && !withinDir(path, c.resumed.Frontier)
+}
+
+// finished records that the file at pos was scanned, if ok, and its results
+// printed. Files finish in the order they were found; once one is not ok,
+// because the scan was interrupted first, the frontier stays before it and
+// files finished after it are listed individually.
+func (c *checkpoint) finished(pos checkpointPos, ok bool) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !ok {
+		c.stalled = true
+		return
+	}
+	// Results are counted as they are printed, so the counts now are those
+	// of the files finished.
+	if c.stalled {
+		c.state.Done = append(c.state.Done, pos.path)
+	} else {
+		c.state.Root, c.state.Frontier = pos.root, pos.path
+	}
+	c.state.Files++
+	c.state.Matched = c.resumed.Matched + c.stats.matched.Load()
+	c.state.Failed = c.resumed.Failed + c.stats.failed.Load()
+	c.changed = true
+}
+
+// save writes the checkpoint if it changed since it was last written,
+// replacing the file atomically so that a crash leaves the old or the new
+// state but never a mix.
+func (c *checkpoint) save() error {
+	c.mu.Lock()
+	if !c.changed {
+		c.mu.Unlock()
+		return nil
+	}
+	state := c.state
+	c.changed = false
+	c.mu.Unlock()
+
+	state.Updated = time.Now().UTC().Format(time.RFC3339)
+
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(c.path), filepath.Base(c.path)+".*")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), c.path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+// finish stops the periodic saves and either removes the checkpoint, once
+// the scan is complete, or saves where it stopped.
+func (c *checkpoint) finish(complete bool) error {
+	if c == nil {
+		return nil
+	}
+	close(c.stop)
+	c.stopped.Wait()
+
+	if complete {
+		if err := os.Remove(c.path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("failed to remove checkpoint: %w", err)
+		}
+		return nil
+	}
+	if err := c.save(); err != nil {
+		return fmt.Errorf("failed to save checkpoint: %w", err)
+	}
+	return nil
+}
+
+// walkOrder compares the positions of paths a and b below root in the order
+// walks visit them: directory entries in byte order of their names, each
+// directory before its contents.
+func walkOrder(root, a, b string) int {
+	ra, _ := filepath.Rel(root, a)
+	rb, _ := filepath.Rel(root, b)
+	return slices.Compare(strings.Split(ra, string(filepath.Separator)), strings.Split(rb, string(filepath.Separator)))
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCheckpointResume(t *testing.T) {
+	root := t.TempDir()
+	for i := range 100 {
+		writeFile(t, root, fmt.Sprintf("d%d/f%02d.bin", i%4, i), sampleData(uint64(i), 8192))
+	}
+	checkpoint := filepath.Join(t.TempDir(), "scan.checkpoint")
+	// At half a MiB a second, the 800 KiB take over a second to read.
+	args := []string{"-s", "--jsonl", "--workers", "4", "--throttle", "0.5", "--checkpoint", checkpoint, "--db", writeTestDatabase(t), root}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	out, _, _, _ := runCLIContext(t, ctx, args...)
+	first, summary := parseJSONL(t, out)
+	if !summary.Interrupted || len(first) == 0 || len(first) == 100 {
+		t.Fatalf("first run: %d results, interrupted %v; want it cut short", len(first), summary.Interrupted)
+	}
+	if _, err := os.Stat(checkpoint); err != nil {
+		t.Fatalf("no checkpoint after the interruption: %v", err)
+	}
+
+	out, _, _, err := runCLI(t, args...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, summary := parseJSONL(t, out)
+	if summary.Resumed != int64(len(first)) {
+		t.Errorf("second run resumed after %d files, want %d", summary.Resumed, len(first))
+	}
+
+	seen := make(map[string]int)
+	for _, r := range append(first, second...) {
+		seen[r.Path]++
+	}
+	for i := range 100 {
+		path := filepath.Join(root, fmt.Sprintf("d%d/f%02d.bin", i%4, i))
+		if seen[path] != 1 {
+			t.Errorf("%s scanned %d times, want once", path, seen[path])
+		}
+	}
+	if len(seen) != 100 {
+		t.Errorf("%d files scanned, want 100", len(seen))
+	}
+
+	if _, err := os.Stat(checkpoint); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("checkpoint left behind after the scan finished: %v", err)
+	}
+}
+
+func TestCheckpointOtherRoots(t *testing.T) {
+	checkpoint := writeFile(t, t.TempDir(), "scan.checkpoint", []byte(`{"roots":["/elsewhere"],"root":0,"frontier":"/elsewhere/a","files":1,"matched":0,"failed":0}`))
+	_, _, _, err := runCLI(t, "-s", "--checkpoint", checkpoint, "--db", writeTestDatabase(t), t.TempDir())
+	if err == nil || !strings.Contains(err.Error(), "was saved by a scan of /elsewhere") {
+		t.Errorf("error = %v, want the checkpoint refused", err)
+	}
+}
+
+func TestWalkOrder(t *testing.T) {
+	root := filepath.FromSlash("/r")
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"/r/a", "/r/b", -1},
+		{"/r/a/z", "/r/b", -1},
+		{"/r/a", "/r/a/z", -1},
+		// Entries are ordered by name, and "a" sorts before "a-b".
+		{"/r/a-b", "/r/a/b", 1},
+		{"/r/b", "/r/b", 0},
+		{"/r/b/a", "/r/a/z", 1},
+	}
+	for _, tt := range tests {
+		if got := walkOrder(root, filepath.FromSlash(tt.a), filepath.FromSlash(tt.b)); got != tt.want {
+			t.Errorf("walkOrder(%s, %s) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
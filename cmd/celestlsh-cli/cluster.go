@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/Magonia-Research/CelesTLSH-CLI/pkg/celestlsh"
+)
+
+// cluster is a group of inputs connected by distances within the
+// clustering threshold.
+type cluster struct {
+	ID      int         `json:"id"`
+	Size    int         `json:"size"`
+	Medoid  string      `json:"medoid"`
+	Members []namedHash `json:"members"`
+}
+
+func executeCluster(ctx context.Context, config Config) (status, error) {
+	inputs, err := collectInputs(config)
+	if err != nil {
+		return statusOK, err
+	}
+
+	inputs, err = expandDirectories(inputs)
+	if err != nil {
+		return statusOK, err
+	}
+
+	hashes, failed, err := hashInputs(ctx, config, inputs)
+	if err != nil {
+		return statusOK, err
+	}
+
+	// Files the TLSH algorithm rejects, typically because they are too
+	// small or too uniform, are listed as unclustered; anything else is an
+	// error reading the input.
+	unclustered := []string{}
+	skipped := 0
+	for _, f := range failed {
+		var hashingErr *celestlsh.HashingError
+		if errors.As(f.Err, &hashingErr) {
+			unclustered = append(unclustered, f.Name)
+			continue
+		}
+		skipped++
+		fmt.Fprintf(os.Stderr, "Warning: skipping %s: %v\n", f.Name, f.Err)
+	}
+
+	distances, err := distanceMatrix(hashes)
+	if err != nil {
+		return statusOK, err
+	}
+
+	clusters := clusterHashes(hashes, distances, config.ClusterThreshold)
+
+	if config.OutputJSON {
+		return batchStatus(0, skipped), printJSON(struct {
+			Threshold   int       `json:"threshold"`
+			Clusters    []cluster `json:"clusters"`
+			Unclustered []string  `json:"unclustered"`
+		}{config.ClusterThreshold, clusters, unclustered})
+	}
+
+	for _, c := range clusters {
+		noun := "members"
+		if c.Size == 1 {
+			noun = "member"
+		}
+		fmt.Printf("Cluster %d (%d %s, medoid %s)\n", c.ID, c.Size, noun, c.Medoid)
+		for _, m := range c.Members {
+			fmt.Printf("  %s\n", m.Name)
+		}
+	}
+	if len(unclustered) > 0 {
+		fmt.Printf("Unclustered (%d too small or uniform to hash)\n", len(unclustered))
+		for _, name := range unclustered {
+			fmt.Printf("  %s\n", name)
+		}
+	}
+
+	return batchStatus(0, skipped), nil
+}
+
+// expandDirectories replaces each directory among inputs with the regular
+// files below it. Other inputs, including hashes, are kept as they are.
+func expandDirectories(inputs []string) ([]string, error) {
+	var expanded []string
+	for _, input := range inputs {
+		info, err := os.Stat(input)
+		if err != nil || !info.IsDir() {
+			expanded = append(expanded, input)
+			continue
+		}
+
+		err = filepath.WalkDir(input, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: skipping %s: %v\n", path, err)
+				return nil
+			}
+			if d.Type().IsRegular() {
+				expanded = append(expanded, path)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return expanded, nil
+}
+
+// clusterHashes groups hashes by single linkage: two inputs share a cluster
+// when a chain of inputs connects them with every step at most threshold
+// apart. Clusters are ordered largest first.
+func clusterHashes(hashes []namedHash, distances [][]int, threshold int) []cluster {
+	parent := make([]int, len(hashes))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+
+	for i := range hashes {
+		for j := i + 1; j < len(hashes); j++ {
+			if distances[i][j] <= threshold {
+				parent[find(i)] = find(j)
+			}
+		}
+	}
+
+	groups := make(map[int][]int)
+	var roots []int
+	for i := range hashes {
+		root := find(i)
+		if _, ok := groups[root]; !ok {
+			roots = append(roots, root)
+		}
+		groups[root] = append(groups[root], i)
+	}
+
+	sort.SliceStable(roots, func(a, b int) bool {
+		return len(groups[roots[a]]) > len(groups[roots[b]])
+	})
+
+	clusters := make([]cluster, 0, len(roots))
+	for n, root := range roots {
+		members := groups[root]
+
+		medoid, best := members[0], -1
+		for _, i := range members {
+			total := 0
+			for _, j := range members {
+				total += distances[i][j]
+			}
+			if best < 0 || total < best {
+				medoid, best = i, total
+			}
+		}
+
+		c := cluster{ID: n + 1, Size: len(members), Medoid: hashes[medoid].Name}
+		for _, i := range members {
+			c.Members = append(c.Members, hashes[i])
+		}
+		clusters = append(clusters, c)
+	}
+
+	return clusters
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"os"
+)
+
+// style is how a piece of text output is highlighted on a colour terminal.
+type style string
+
+const (
+	styleNone       style = ""
+	styleMatch      style = "31" // red
+	styleBorderline style = "33" // yellow
+	styleClean      style = "32" // green
+	styleMuted      style = "2"  // dim
+)
+
+// colorThreshold is the distance at or below which a match is highlighted
+// as a match when --max-distance is not set. Matches up to twice the
+// threshold are borderline.
+const colorThreshold = 30
+
+// colorOutput is whether the text format is coloured, decided by setupColor
+// once stdout is known. Other formats are never coloured.
+var colorOutput bool
+
+// setupColor decides whether to colour text output for --color, which
+// --no-color sets to never. In auto mode, colour is used only when stdout
+// is a terminal and NO_COLOR is not set.
+func setupColor(config Config) {
+	switch config.Color {
+	case "always":
+		colorOutput = true
+	case "never":
+		colorOutput = false
+	default:
+		colorOutput = os.Getenv("NO_COLOR") == "" && os.Getenv("TERM") != "dumb" && isTerminal(os.Stdout)
+	}
+}
+
+// paint returns text in the given style if colour is enabled.
+func paint(s style, text string) string {
+	if !colorOutput || s == styleNone {
+		return text
+	}
+	return "\x1b[" + string(s) + "m" + text + "\x1b[0m"
+}
+
+// resultStyle returns the style of a result: matches within --max-distance,
+// or colorThreshold without it, are matches, those up to twice as far are
+// borderline, files without a close match are clean and suppressed matches
+// are muted.
+func resultStyle(config Config, result scanResult) style {
+	switch {
+	case result.Error != "":
+		return styleNone
+	case result.Suppressed:
+		return styleMuted
+	case result.Match == nil && result.HighEntropy:
+		return styleBorderline
+	case result.Match == nil:
+		return styleClean
+	}
+	threshold := colorThreshold
+	if config.MaxDistance >= 0 {
+		threshold = config.MaxDistance
+	}
+	switch distance := result.Match.Distance; {
+	case distance <= threshold:
+		return styleMatch
+	case distance <= 2*threshold:
+		return styleBorderline
+	default:
+		return styleClean
+	}
+}
+
+// textFormat reports whether results are printed in the plain text format,
+// the only one that is coloured.
+func textFormat(config Config) bool {
+	return !config.OutputJSONL && !config.OutputJSON && !config.OutputCSV && !config.Quiet && config.Format == "" && config.Template == nil
+}
@@ -0,0 +1,402 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"text/tabwriter"
+
+	"github.com/Magonia-Research/CelesTLSH-CLI/pkg/celestlsh"
+)
+
+// defaultCompareDistance is the compare-dirs threshold between similar and
+// modified files used when --max-distance is not given.
+const defaultCompareDistance = 30
+
+// Verdicts of compare-dirs mode.
+const (
+	verdictIdentical = "identical"
+	verdictSimilar   = "similar"
+	verdictModified  = "modified"
+	verdictOnlyInA   = "only-in-a"
+	verdictOnlyInB   = "only-in-b"
+)
+
+// treeFile is a regular file found below a directory compared in
+// compare-dirs mode or listed in manifest mode. TLSH is empty for files
+// the TLSH algorithm rejects, which can then only be told identical or not
+// by their SHA256.
+type treeFile struct {
+	Rel     string
+	Path    string
+	TLSH    string
+	SHA256  string
+	Imphash string
+}
+
+// dirDiff is the verdict on one file of either tree, or on a pair of them.
+// A and B are paths relative to their directories, and either is empty
+// for a file only found in the other tree. Distance is only set when both
+// files have a TLSH hash.
+type dirDiff struct {
+	Verdict  string `json:"verdict"`
+	A        string `json:"a,omitempty"`
+	B        string `json:"b,omitempty"`
+	Distance *int   `json:"distance,omitempty"`
+}
+
+// executeCompareDirs hashes two directory trees and reports how each file
+// of one relates to its counterpart in the other. Files are paired by
+// relative path first; the rest are paired by identical SHA256 and then by
+// nearest TLSH neighbour within the threshold, closest pairs first, so that
+// renamed and moved files are still found.
+func executeCompareDirs(ctx context.Context, config Config) (status, error) {
+	threshold := config.MaxDistance
+	if threshold < 0 {
+		threshold = defaultCompareDistance
+	}
+
+	filter, err := newPathFilter(config.Include, config.Exclude)
+	if err != nil {
+		return statusOK, err
+	}
+	var trees [2][]treeFile
+	var files []*treeFile
+	for i, dir := range []string{config.CompareDirA, config.CompareDirB} {
+		trees[i], err = listTree(dir, filter)
+		if err != nil {
+			return statusOK, err
+		}
+		for j := range trees[i] {
+			files = append(files, &trees[i][j])
+		}
+	}
+
+	failed, err := hashTreeFiles(ctx, config, files, celestlsh.DigestSHA256)
+	if err != nil {
+		return statusOK, err
+	}
+	for _, f := range failed {
+		fmt.Fprintf(os.Stderr, "Warning: skipping %s: %v\n", f.Name, f.Err)
+	}
+
+	diffs, err := compareTrees(trees[0], trees[1], threshold)
+	if err != nil {
+		return statusOK, err
+	}
+
+	counts := make(map[string]int)
+	for _, d := range diffs {
+		counts[d.Verdict]++
+	}
+	st := batchStatus(0, len(failed))
+	if counts[verdictModified]+counts[verdictOnlyInA]+counts[verdictOnlyInB] > 0 {
+		st = statusMismatch
+	}
+
+	switch {
+	case config.OutputJSON:
+		if diffs == nil {
+			diffs = []dirDiff{}
+		}
+		return st, printJSON(struct {
+			A         string         `json:"a"`
+			B         string         `json:"b"`
+			Threshold int            `json:"threshold"`
+			Skipped   int            `json:"skipped"`
+			Summary   map[string]int `json:"summary"`
+			Files     []dirDiff      `json:"files"`
+		}{config.CompareDirA, config.CompareDirB, threshold, len(failed), counts, diffs})
+	case config.OutputCSV:
+		return st, printDirDiffsCSV(diffs)
+	}
+
+	printDirDiffsTable(diffs)
+	if !config.Quiet {
+		fmt.Fprintf(os.Stderr, "%d identical, %d similar, %d modified (threshold %d), %d only in %s, %d only in %s\n",
+			counts[verdictIdentical], counts[verdictSimilar], counts[verdictModified], threshold,
+			counts[verdictOnlyInA], config.CompareDirA, counts[verdictOnlyInB], config.CompareDirB)
+	}
+	return st, nil
+}
+
+// listTree returns the regular files below dir that pass filter, in
+// lexical order of their relative paths.
+func listTree(dir string, filter *pathFilter) ([]treeFile, error) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", dir)
+	}
+
+	var files []treeFile
+	err = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: skipping %s: %v\n", path, err)
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil || rel == "." {
+			return nil
+		}
+		if d.IsDir() {
+			if filter.skipDir(rel) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.Type().IsRegular() && !filter.skipFile(rel) {
+			files = append(files, treeFile{Rel: filepath.ToSlash(rel), Path: path})
+		}
+		return nil
+	})
+	return files, err
+}
+
+// hashTreeFiles fills in the TLSH hash of files in parallel, with the
+// SHA256 and, if selected by which, the imphash. It returns the files that
+// could not be read. Files too small or too uniform for TLSH keep an empty
+// TLSH hash but still get their SHA256.
+func hashTreeFiles(ctx context.Context, config Config, files []*treeFile, which celestlsh.Digest) ([]inputFailure, error) {
+	errs := make([]error, len(files))
+
+	work := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workerCount(config); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			hasher := newHasher(config)
+			for i := range work {
+				f := files[i]
+				digests, err := hasher.DigestFile(ctx, f.Path, which|celestlsh.DigestSHA256)
+				var hashingErr *celestlsh.HashingError
+				if errors.As(err, &hashingErr) {
+					f.SHA256, err = fileSHA256(f.Path)
+				} else {
+					f.TLSH, f.SHA256, f.Imphash = digests.TLSH, digests.SHA256, digests.Imphash
+				}
+				errs[i] = err
+			}
+		}()
+	}
+
+	for i := range files {
+		if ctx.Err() != nil {
+			break
+		}
+		work <- i
+	}
+	close(work)
+	wg.Wait()
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	var failed []inputFailure
+	for i, f := range files {
+		if errs[i] != nil {
+			failed = append(failed, inputFailure{Name: f.Path, Err: errs[i]})
+			f.SHA256 = ""
+		}
+	}
+	return failed, nil
+}
+
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	sum := sha256.New()
+	if _, err := io.Copy(sum, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(sum.Sum(nil)), nil
+}
+
+// compareTrees pairs the files of a and b and gives each pair, and each
+// file left over, its verdict. Files that could not be hashed have no
+// SHA256 and are left out.
+func compareTrees(a, b []treeFile, threshold int) ([]dirDiff, error) {
+	var diffs []dirDiff
+	var restA, restB []*treeFile
+
+	byRel := make(map[string]*treeFile, len(b))
+	for i := range b {
+		if b[i].SHA256 != "" {
+			byRel[b[i].Rel] = &b[i]
+		}
+	}
+	for i := range a {
+		fa := &a[i]
+		if fa.SHA256 == "" {
+			continue
+		}
+		fb := byRel[fa.Rel]
+		if fb == nil {
+			restA = append(restA, fa)
+			continue
+		}
+		delete(byRel, fa.Rel)
+		d, err := compareFiles(fa, fb, threshold)
+		if err != nil {
+			return nil, err
+		}
+		diffs = append(diffs, d)
+	}
+	for i := range b {
+		if byRel[b[i].Rel] != nil {
+			restB = append(restB, &b[i])
+		}
+	}
+
+	// Moved copies are paired first, taking the first unpaired copy in b
+	// for each file in a.
+	bySum := make(map[string][]*treeFile)
+	for _, fb := range restB {
+		bySum[fb.SHA256] = append(bySum[fb.SHA256], fb)
+	}
+	paired := make(map[*treeFile]bool)
+	for _, fa := range restA {
+		if copies := bySum[fa.SHA256]; len(copies) > 0 {
+			bySum[fa.SHA256] = copies[1:]
+			paired[fa], paired[copies[0]] = true, true
+			d, err := compareFiles(fa, copies[0], threshold)
+			if err != nil {
+				return nil, err
+			}
+			diffs = append(diffs, d)
+		}
+	}
+
+	type candidate struct {
+		a, b     *treeFile
+		distance int
+	}
+	var candidates []candidate
+	for _, fa := range restA {
+		if paired[fa] || fa.TLSH == "" {
+			continue
+		}
+		for _, fb := range restB {
+			if paired[fb] || fb.TLSH == "" {
+				continue
+			}
+			d, err := celestlsh.Distance(fa.TLSH, fb.TLSH)
+			if err != nil {
+				return nil, fmt.Errorf("failed to calculate TLSH distance: %w", err)
+			}
+			if d <= threshold {
+				candidates = append(candidates, candidate{fa, fb, d})
+			}
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].distance < candidates[j].distance
+	})
+	for _, c := range candidates {
+		if paired[c.a] || paired[c.b] {
+			continue
+		}
+		paired[c.a], paired[c.b] = true, true
+		distance := c.distance
+		diffs = append(diffs, dirDiff{Verdict: verdictSimilar, A: c.a.Rel, B: c.b.Rel, Distance: &distance})
+	}
+
+	for _, fa := range restA {
+		if !paired[fa] {
+			diffs = append(diffs, dirDiff{Verdict: verdictOnlyInA, A: fa.Rel})
+		}
+	}
+	for _, fb := range restB {
+		if !paired[fb] {
+			diffs = append(diffs, dirDiff{Verdict: verdictOnlyInB, B: fb.Rel})
+		}
+	}
+
+	sort.SliceStable(diffs, func(i, j int) bool {
+		return diffSortKey(diffs[i]) < diffSortKey(diffs[j])
+	})
+	return diffs, nil
+}
+
+// compareFiles gives the verdict on a pair of files.
+func compareFiles(a, b *treeFile, threshold int) (dirDiff, error) {
+	d := dirDiff{A: a.Rel, B: b.Rel}
+	if a.TLSH != "" && b.TLSH != "" {
+		distance, err := celestlsh.Distance(a.TLSH, b.TLSH)
+		if err != nil {
+			return d, fmt.Errorf("failed to calculate TLSH distance: %w", err)
+		}
+		d.Distance = &distance
+	}
+	switch {
+	case a.SHA256 == b.SHA256:
+		d.Verdict = verdictIdentical
+	case d.Distance != nil && *d.Distance <= threshold:
+		d.Verdict = verdictSimilar
+	default:
+		d.Verdict = verdictModified
+	}
+	return d, nil
+}
+
+// diffSortKey orders verdicts by the path in a, or in b for files only
+// found there.
+func diffSortKey(d dirDiff) string {
+	if d.A != "" {
+		return d.A
+	}
+	return d.B
+}
+
+func formatDiffDistance(d dirDiff) string {
+	if d.Distance == nil {
+		return ""
+	}
+	return strconv.Itoa(*d.Distance)
+}
+
+func printDirDiffsCSV(diffs []dirDiff) error {
+	w := csv.NewWriter(os.Stdout)
+	w.Write([]string{"verdict", "distance", "a", "b"})
+	for _, d := range diffs {
+		w.Write([]string{d.Verdict, formatDiffDistance(d), d.A, d.B})
+	}
+	w.Flush()
+	return w.Error()
+}
+
+// printDirDiffsTable prints the verdicts as an aligned table, with - for a
+// missing distance or file.
+func printDirDiffsTable(diffs []dirDiff) {
+	dash := func(s string) string {
+		if s == "" {
+			return "-"
+		}
+		return s
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "Verdict\tDistance\tA\tB")
+	for _, d := range diffs {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", d.Verdict, dash(formatDiffDistance(d)), dash(d.A), dash(d.B))
+	}
+	tw.Flush()
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// confidenceLabels are the confidence labels, most confident first. A match
+// gets the first label whose band its distance falls in, or the last.
+var confidenceLabels = []string{"high", "medium", "low", "none"}
+
+// exactConfidence labels a scanned file whose SHA256 is that of the matched
+// record, which is more than any distance can say.
+const exactConfidence = "exact"
+
+// Match types of a scanned file: its SHA256 is that of the matched record,
+// or only its TLSH hash is close.
+const (
+	matchExact   = "exact"
+	matchSimilar = "similar"
+)
+
+// verifyMatch compares the SHA256 of a scanned file with that of its
+// matched record, labelling an exact match with exactConfidence and any
+// other match as similar. Records without a SHA256 can only be similar.
+func verifyMatch(result *scanResult) {
+	if result.Match == nil {
+		return
+	}
+	if result.SHA256 != "" && strings.EqualFold(result.SHA256, result.Match.SHA256Hash) {
+		result.MatchType, result.Confidence = matchExact, exactConfidence
+	} else {
+		result.MatchType = matchSimilar
+	}
+}
+
+// describeMatch describes the confidence of a match for plain output, as
+// "exact match", or "medium confidence, similar (TLSH only)" for a scanned
+// file that is only similar; matches from check mode have no match type.
+// It returns "" for a match from check mode with no confidence.
+func describeMatch(result scanResult) string {
+	confidence := confidencePhrase(result.Confidence)
+	switch {
+	case result.MatchType == matchExact:
+		return "exact match"
+	case result.MatchType == matchSimilar && confidence != "":
+		return confidence + ", similar (TLSH only)"
+	case result.MatchType == matchSimilar:
+		return "similar (TLSH only)"
+	default:
+		return confidence
+	}
+}
+
+// confidencePhrase renders a confidence label as "high confidence", or as
+// "" when there is no confidence to speak of.
+func confidencePhrase(label string) string {
+	if label == "" || label == "none" {
+		return ""
+	}
+	return label + " confidence"
+}
+
+// confidenceBands are the largest distances labelled high, medium and low
+// confidence; anything further away has no confidence.
+type confidenceBands [3]int
+
+// defaultConfidenceBands are the bands used without --confidence-bands.
+var defaultConfidenceBands = confidenceBands{30, 60, 100}
+
+// parseConfidenceBands parses --confidence-bands, three increasing distances
+// separated by commas, such as "30,60,100".
+func parseConfidenceBands(s string) (confidenceBands, error) {
+	var bands confidenceBands
+	fields := strings.Split(s, ",")
+	if len(fields) != len(bands) {
+		return bands, fmt.Errorf("want %d distances separated by commas, got %q", len(bands), s)
+	}
+	for i, field := range fields {
+		n, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil || n < 0 {
+			return bands, fmt.Errorf("invalid distance %q", field)
+		}
+		if i > 0 && n <= bands[i-1] {
+			return bands, fmt.Errorf("distances must increase, got %q", s)
+		}
+		bands[i] = n
+	}
+	return bands, nil
+}
+
+// label returns the confidence label of a match at distance.
+func (b confidenceBands) label(distance int) string {
+	for i, max := range b {
+		if distance <= max {
+			return confidenceLabels[i]
+		}
+	}
+	return confidenceLabels[len(b)]
+}
+
+// maxDistance returns the largest distance with at least the confidence of
+// label, or -1 for "none", which admits any distance. ok is false if label
+// is unknown.
+func (b confidenceBands) maxDistance(label string) (distance int, ok bool) {
+	for i, l := range confidenceLabels {
+		if l != label {
+			continue
+		}
+		if i == len(b) {
+			return -1, true
+		}
+		return b[i], true
+	}
+	return 0, false
+}
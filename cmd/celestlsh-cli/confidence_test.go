@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestConfidenceLabel(t *testing.T) {
+	custom := confidenceBands{10, 20, 40}
+	tests := []struct {
+		bands    confidenceBands
+		distance int
+		want     string
+	}{
+		{defaultConfidenceBands, 0, "high"},
+		{defaultConfidenceBands, 30, "high"},
+		{defaultConfidenceBands, 31, "medium"},
+		{defaultConfidenceBands, 60, "medium"},
+		{defaultConfidenceBands, 61, "low"},
+		{defaultConfidenceBands, 100, "low"},
+		{defaultConfidenceBands, 101, "none"},
+		{custom, 10, "high"},
+		{custom, 37, "low"},
+		{custom, 41, "none"},
+	}
+	for _, tt := range tests {
+		if got := tt.bands.label(tt.distance); got != tt.want {
+			t.Errorf("%v.label(%d) = %q, want %q", tt.bands, tt.distance, got, tt.want)
+		}
+	}
+}
+
+func TestParseConfidenceBands(t *testing.T) {
+	bands, err := parseConfidenceBands("10, 20,40")
+	if err != nil || bands != (confidenceBands{10, 20, 40}) {
+		t.Errorf("parseConfidenceBands = %v, %v; want [10 20 40]", bands, err)
+	}
+	for _, s := range []string{"", "10,20", "10,20,30,40", "10,x,30", "30,20,10", "10,10,20", "-1,20,30"} {
+		if _, err := parseConfidenceBands(s); err == nil {
+			t.Errorf("parseConfidenceBands(%q) succeeded, want an error", s)
+		}
+	}
+}
+
+func TestConfidenceMaxDistance(t *testing.T) {
+	for label, want := range map[string]int{"high": 30, "medium": 60, "low": 100, "none": -1} {
+		if got, ok := defaultConfidenceBands.maxDistance(label); !ok || got != want {
+			t.Errorf("maxDistance(%q) = %d, %v; want %d", label, got, ok, want)
+		}
+	}
+	if _, ok := defaultConfidenceBands.maxDistance("certain"); ok {
+		t.Error("maxDistance accepted an unknown label")
+	}
+}
+
+func TestDescribeMatch(t *testing.T) {
+	tests := []struct {
+		matchType, confidence, want string
+	}{
+		{"", "high", "high confidence"},
+		{"", "none", ""},
+		{"", "", ""},
+		{matchExact, exactConfidence, "exact match"},
+		{matchSimilar, "medium", "medium confidence, similar (TLSH only)"},
+		{matchSimilar, "none", "similar (TLSH only)"},
+	}
+	for _, tt := range tests {
+		if got := describeMatch(scanResult{MatchType: tt.matchType, Confidence: tt.confidence}); got != tt.want {
+			t.Errorf("describeMatch(%q, %q) = %q, want %q", tt.matchType, tt.confidence, got, tt.want)
+		}
+	}
+}
+
+func TestCheckConfidence(t *testing.T) {
+	records := testRecords(t)
+	db := writeTestDatabase(t)
+
+	out, _, _, err := runCLI(t, "-c", "--db", db, testHash(t, variantData(testSample, 40)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out, "  Distance: 0 (high confidence)\n") {
+		t.Errorf("output %q lacks the high confidence", out)
+	}
+
+	// The closest record to third.dll, without it, has no confidence, so
+	// it is no match unless --max-distance lifts the limit.
+	without := writeTestDatabase(t, records[:3]...)
+	out, _, st, err := runCLI(t, "-c", "--db", without, records[3].TLSHHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st != statusOK || !strings.Contains(out, "No matches found") {
+		t.Errorf("output %q, status %v; want no match", out, st)
+	}
+	out, _, st, err = runCLI(t, "-c", "--max-distance", "-1", "--db", without, records[3].TLSHHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st != statusMatch || strings.Contains(out, "confidence") || !strings.Contains(out, "  Distance: ") {
+		t.Errorf("output %q, status %v; want a distance without a confidence", out, st)
+	}
+
+	out, _, _, err = runCLI(t, "-c", "--json", "--confidence-bands", "10,20,50", "--db", db, records[1].TLSHHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var results []struct {
+		Match      struct{ Distance int }
+		Confidence string
+	}
+	if err := json.Unmarshal([]byte(out), &results); err != nil {
+		t.Fatalf("parsing %s: %v", out, err)
+	}
+	if len(results) != 1 || results[0].Match.Distance != 0 || results[0].Confidence != "high" {
+		t.Errorf("results = %+v, want the variant itself with high confidence", results)
+	}
+}
+
+func TestScanMinConfidence(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "known.exe", testSample)
+	writeFile(t, dir, "other.bin", sampleData(5, 8192))
+
+	out, _, _, err := runCLI(t, "-s", "--min-confidence", "low", "--db", writeTestDatabase(t), dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out, "known.exe (distance 0, ") || !strings.Contains(out, "other.bin: no match") {
+		t.Errorf("output %q, want known.exe matched and other.bin beyond low confidence", out)
+	}
+
+	out, _, _, err = runCLI(t, "-s", "--db", writeTestDatabase(t), dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(out, "none confidence") || !strings.Contains(out, ", similar (TLSH only))") {
+		t.Errorf("output %q, want the distant match of other.bin without a confidence", out)
+	}
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Magonia-Research/CelesTLSH-CLI/pkg/celestlsh"
+)
+
+// listEntry is a TLSH hash read from a cross-check list, with the line it
+// is on so that matches can be traced back to the rest of its row.
+type listEntry struct {
+	Hash string
+	Line int
+}
+
+// crossPair is a hash of the first list and a similar one of the second.
+type crossPair struct {
+	A        string `json:"a"`
+	ALine    int    `json:"a_line"`
+	B        string `json:"b"`
+	BLine    int    `json:"b_line"`
+	Distance int    `json:"distance"`
+}
+
+// executeCrossCheck compares every hash of one list with the hashes of
+// another, printing the pairs within --max-distance or, with --nearest,
+// the closest hash of the second list for each of the first. Each hash of
+// the first list gets at most --limit pairs, the closest. The second list
+// is held as a database, so that a distance limit can use its index, and
+// hashes of the first list are compared in parallel.
+func executeCrossCheck(ctx context.Context, config Config) (status, error) {
+	listA, invalidA, err := readHashList(config.CrossListA, config.Column)
+	if err != nil {
+		return statusOK, err
+	}
+	listB, invalidB, err := readHashList(config.CrossListB, config.Column)
+	if err != nil {
+		return statusOK, err
+	}
+
+	// Each record carries the line of its hash as its file name.
+	records := make([]celestlsh.HashRecord, len(listB))
+	for i, e := range listB {
+		records[i] = celestlsh.HashRecord{TLSHHash: e.Hash, FileName: strconv.Itoa(e.Line)}
+	}
+	db, err := celestlsh.NewDatabase(records)
+	if err != nil {
+		return statusOK, err
+	}
+	// Parallelism comes from comparing several hashes at once.
+	db.Workers = 1
+
+	top := config.Limit
+	if config.Nearest {
+		top = 1
+	}
+
+	var done, truncated atomic.Int64
+	var progress *progressLine
+	if progressEnabled(config) {
+		progress = startProgress(func(time.Duration) string {
+			return fmt.Sprintf("Cross-checking: %d / %d hashes", done.Load(), len(listA))
+		})
+	}
+
+	pairs := make([][]crossPair, len(listA))
+	work := make(chan int)
+	var wg sync.WaitGroup
+	var firstErr error
+	var errOnce sync.Once
+	for w := 0; w < workerCount(config); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range work {
+				c := celestlsh.NewCollector(top)
+				if err := db.Collect(ctx, listA[i].Hash, config.MaxDistance, c); err != nil {
+					errOnce.Do(func() { firstErr = err })
+					continue
+				}
+				if !config.Nearest && c.Dropped() > 0 {
+					truncated.Add(1)
+				}
+				for _, m := range c.Records() {
+					line, _ := strconv.Atoi(m.FileName)
+					pairs[i] = append(pairs[i], crossPair{A: listA[i].Hash, ALine: listA[i].Line, B: m.TLSHHash, BLine: line, Distance: m.Distance})
+				}
+				done.Add(1)
+			}
+		}()
+	}
+	for i := range listA {
+		if ctx.Err() != nil {
+			break
+		}
+		work <- i
+	}
+	close(work)
+	wg.Wait()
+	progress.finish()
+
+	if err := ctx.Err(); err != nil {
+		return statusOK, err
+	}
+	if firstErr != nil {
+		return statusOK, firstErr
+	}
+
+	all := []crossPair{}
+	for _, p := range pairs {
+		all = append(all, p...)
+	}
+
+	if config.OutputJSON {
+		err = printJSON(all)
+	} else {
+		w := csv.NewWriter(os.Stdout)
+		w.Write([]string{"a", "a_line", "b", "b_line", "distance"})
+		for _, p := range all {
+			w.Write([]string{p.A, strconv.Itoa(p.ALine), p.B, strconv.Itoa(p.BLine), strconv.Itoa(p.Distance)})
+		}
+		w.Flush()
+		err = w.Error()
+	}
+	if err != nil {
+		return statusOK, err
+	}
+
+	if !config.Quiet {
+		fmt.Fprintf(os.Stderr, "%d pairs from %d x %d hashes", len(all), len(listA), len(listB))
+		if config.MaxDistance >= 0 {
+			fmt.Fprintf(os.Stderr, " within distance %d", config.MaxDistance)
+		}
+		fmt.Fprintln(os.Stderr)
+	}
+	if n := truncated.Load(); n > 0 {
+		fmt.Fprintf(os.Stderr, "Warning: %d hashes had more than %d pairs; only the closest %d of each are listed (raise --limit to list more)\n", n, config.Limit, config.Limit)
+	}
+	return batchStatus(0, invalidA+invalidB), nil
+}
+
+// readHashList reads the TLSH hashes of a cross-check list, "-" being
+// stdin. Without column, the list has one hash per line, skipping blank
+// lines and # comments; with it, the list is a CSV with a header and the
+// hashes are in the column of that name, or number counting from 1. Empty
+// and N/A values are skipped, and other values that are not TLSH hashes
+// are reported and counted.
+func readHashList(path, column string) ([]listEntry, int, error) {
+	f := os.Stdin
+	if path != "-" {
+		var err error
+		f, err = os.Open(path)
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to read hash list: %w", err)
+		}
+		defer f.Close()
+	}
+
+	var entries []listEntry
+	invalid := 0
+	add := func(value string, line int) {
+		value = strings.TrimSpace(value)
+		if value == "" || value == manifestMissingHash {
+			return
+		}
+		if err := celestlsh.ValidateHash(value); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: skipping %s line %d: %v\n", path, line, err)
+			invalid++
+			return
+		}
+		entries = append(entries, listEntry{Hash: value, Line: line})
+	}
+
+	if column == "" {
+		sc := bufio.NewScanner(f)
+		for line := 1; sc.Scan(); line++ {
+			if text := strings.TrimSpace(sc.Text()); !strings.HasPrefix(text, "#") {
+				add(text, line)
+			}
+		}
+		if err := sc.Err(); err != nil {
+			return nil, 0, fmt.Errorf("failed to read hash list: %w", err)
+		}
+		return entries, invalid, nil
+	}
+
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = -1
+	header, err := r.Read()
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to read the header of %s: %w", path, err)
+	}
+	col, err := findColumn(header, column)
+	if err != nil {
+		return nil, 0, fmt.Errorf("%s: %w", path, err)
+	}
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to read hash list: %w", err)
+		}
+		line, _ := r.FieldPos(0)
+		if col >= len(record) {
+			fmt.Fprintf(os.Stderr, "Warning: skipping %s line %d: no column %s\n", path, line, column)
+			invalid++
+			continue
+		}
+		add(record[col], line)
+	}
+	return entries, invalid, nil
+}
+
+// findColumn returns the index of the column named name in header,
+// ignoring case, or of column number name counting from 1.
+func findColumn(header []string, name string) (int, error) {
+	for i, h := range header {
+		if strings.EqualFold(strings.TrimSpace(h), name) {
+			return i, nil
+		}
+	}
+	if n, err := strconv.Atoi(name); err == nil && n >= 1 && n <= len(header) {
+		return n - 1, nil
+	}
+	return 0, fmt.Errorf("no column %q in header %s", name, strings.Join(header, ","))
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	daemonDialTimeout = 500 * time.Millisecond
+	daemonIdleTimeout = 5 * time.Minute
+	maxDaemonLineSize = maxCheckBodyBytes
+)
+
+// daemonResponse is one line written back by the daemon: either a check
+// result or an error.
+type daemonResponse struct {
+	checkResponse
+	Error string `json:"error,omitempty"`
+}
+
+func executeDaemon(ctx context.Context, config Config) error {
+	if config.Socket == "" {
+		return errors.New("daemon mode requires --socket <path>")
+	}
+
+	db, err := newLiveDatabase(ctx, config)
+	if err != nil {
+		return err
+	}
+
+	ln, err := listenSocket(config.Socket)
+	if err != nil {
+		return err
+	}
+
+	go db.watch(ctx)
+
+	if !config.Quiet {
+		fmt.Printf("Serving %d database records on %s\n", db.get().Len(), config.Socket)
+	}
+
+	return serveSocket(ctx, ln, db)
+}
+
+// listenSocket listens on a Unix socket at path, replacing a stale socket
+// file left behind by a daemon that did not shut down cleanly, and restricts
+// the socket to the current user.
+func listenSocket(path string) (*net.UnixListener, error) {
+	if _, err := os.Stat(path); err == nil {
+		if conn, err := net.DialTimeout("unix", path, daemonDialTimeout); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("a daemon is already listening on %s", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, fmt.Errorf("failed to remove stale socket: %w", err)
+		}
+	}
+
+	ln, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on socket: %w", err)
+	}
+
+	if err := os.Chmod(path, 0600); err != nil {
+		ln.Close()
+		return nil, fmt.Errorf("failed to set socket permissions: %w", err)
+	}
+
+	return ln, nil
+}
+
+// serveSocket answers newline-delimited JSON check requests on ln until ctx
+// is done, then waits for open connections to finish their current request.
+func serveSocket(ctx context.Context, ln *net.UnixListener, db *liveDatabase) error {
+	var wg sync.WaitGroup
+	conns := make(map[net.Conn]struct{})
+	var mu sync.Mutex
+
+	go func() {
+		<-ctx.Done()
+		ln.Close()
+
+		mu.Lock()
+		for conn := range conns {
+			conn.SetReadDeadline(time.Now())
+		}
+		mu.Unlock()
+	}()
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				break
+			}
+			return fmt.Errorf("failed to accept connection: %w", err)
+		}
+
+		mu.Lock()
+		conns[conn] = struct{}{}
+		mu.Unlock()
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() {
+				mu.Lock()
+				delete(conns, conn)
+				mu.Unlock()
+				conn.Close()
+			}()
+			handleDaemonConn(ctx, conn, db)
+		}()
+	}
+
+	wg.Wait()
+	return nil
+}
+
+func handleDaemonConn(ctx context.Context, conn net.Conn, db *liveDatabase) {
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 0, 4096), maxDaemonLineSize)
+	enc := json.NewEncoder(conn)
+
+	for {
+		if ctx.Err() != nil {
+			return
+		}
+		conn.SetReadDeadline(time.Now().Add(daemonIdleTimeout))
+		if !scanner.Scan() {
+			return
+		}
+
+		var resp daemonResponse
+		var req checkRequest
+		if err := json.Unmarshal(scanner.Bytes(), &req); err != nil {
+			resp.Error = fmt.Sprintf("invalid JSON request: %v", err)
+		} else if result, err := runCheck(ctx, db.get(), req, db.config.Limit); err != nil {
+			resp.Error = err.Error()
+		} else {
+			resp.checkResponse = result
+		}
+
+		if err := enc.Encode(resp); err != nil {
+			return
+		}
+	}
+}
+
+// errDaemonUnavailable is returned by queryDaemon when nothing is listening
+// on the socket, so that callers can fall back to reading the database.
+var errDaemonUnavailable = errors.New("daemon unavailable")
+
+// queryDaemon sends a single check request to the daemon listening on path.
+func queryDaemon(ctx context.Context, path string, req checkRequest) (checkResponse, error) {
+	dialer := net.Dialer{Timeout: daemonDialTimeout}
+	conn, err := dialer.DialContext(ctx, "unix", path)
+	if err != nil {
+		return checkResponse{}, errDaemonUnavailable
+	}
+	defer conn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		return checkResponse{}, fmt.Errorf("error sending request to daemon: %w", err)
+	}
+
+	var resp daemonResponse
+	if err := json.NewDecoder(bufio.NewReader(conn)).Decode(&resp); err != nil {
+		return checkResponse{}, fmt.Errorf("error reading response from daemon: %w", err)
+	}
+
+	if resp.Error != "" {
+		return checkResponse{}, errors.New(resp.Error)
+	}
+
+	return resp.checkResponse, nil
+}
+
+// useDaemon reports whether checks may be sent to the daemon on --socket.
+// The daemon holds the whole database, so it cannot answer checks
+// restricted by date, its copy was not verified by this process, and it
+// does not serve the embedded snapshot.
+func useDaemon(config Config) bool {
+	return config.Socket != "" && databaseFilters(config) == nil && !config.RequireSigned && !config.UseEmbedded && config.DbPath != stdinDatabase
+}
+
+// newDaemonLookup returns the lookup that sends the checks of a scan to the
+// daemon on --socket, falling back to the local database for the rest of
+// the run if no daemon is listening.
+func newDaemonLookup(config Config) *remoteLookup {
+	return &remoteLookup{config: config, name: "daemon on " + config.Socket, client: daemonClient(config.Socket), fallback: true, quiet: true}
+}
+
+// daemonClient sends checks to the daemon listening on the socket it names.
+type daemonClient string
+
+func (d daemonClient) check(ctx context.Context, req checkRequest) (checkResponse, error) {
+	return queryDaemon(ctx, string(d), req)
+}
+
+func (d daemonClient) unavailable(err error) bool {
+	return errors.Is(err, errDaemonUnavailable)
+}
+
+func (d daemonClient) close() {}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// startDaemon runs daemon mode on a socket in a temporary directory until
+// the returned function stops it, which reports what executeDaemon
+// returned.
+func startDaemon(t *testing.T) (socket string, stop func() error) {
+	t.Helper()
+	// Unix socket paths are short; t.TempDir can be too long on macOS.
+	dir, err := os.MkdirTemp("", "celestlsh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	socket = filepath.Join(dir, "d.sock")
+
+	config := parseTestFlags(t, "--daemon", "--quiet", "--socket", socket, "--db", writeTestDatabase(t))
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- executeDaemon(ctx, config) }()
+
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		if conn, err := net.Dial("unix", socket); err == nil {
+			conn.Close()
+			break
+		}
+		if time.Now().After(deadline) {
+			cancel()
+			t.Fatalf("daemon did not start listening: %v", <-done)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	return socket, func() error {
+		cancel()
+		select {
+		case err := <-done:
+			return err
+		case <-time.After(5 * time.Second):
+			t.Fatal("daemon did not shut down")
+			return nil
+		}
+	}
+}
+
+func TestDaemon(t *testing.T) {
+	socket, stop := startDaemon(t)
+	defer stop()
+	records := testRecords(t)
+
+	info, err := os.Stat(socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0600 {
+		t.Errorf("socket mode = %o, want 600", perm)
+	}
+
+	top := 2
+	resp, err := queryDaemon(context.Background(), socket, checkRequest{TLSH: records[0].TLSHHash, Top: &top})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Matches) != 2 || resp.Matches[0].FileName != "known.exe" || resp.Matches[0].Distance != 0 || resp.Matches[1].FileName != "known-variant.exe" {
+		t.Errorf("daemon answered %+v, want known.exe then its variant", resp.Matches)
+	}
+
+	if _, err := queryDaemon(context.Background(), socket, checkRequest{TLSH: "bogus"}); err == nil || errors.Is(err, errDaemonUnavailable) {
+		t.Errorf("invalid hash: error = %v, want the daemon's error", err)
+	}
+
+	// Several requests on one connection, one of them invalid.
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("not json\n{\"tlsh\":\"" + records[2].TLSHHash + "\"}\n"))
+	sc := bufio.NewScanner(conn)
+	var lines []daemonResponse
+	for len(lines) < 2 && sc.Scan() {
+		var r daemonResponse
+		if err := json.Unmarshal(sc.Bytes(), &r); err != nil {
+			t.Fatal(err)
+		}
+		lines = append(lines, r)
+	}
+	if len(lines) != 2 || lines[0].Error == "" || len(lines[1].Matches) != 1 || lines[1].Matches[0].FileName != "other.exe" {
+		t.Errorf("responses = %+v, want an error then other.exe", lines)
+	}
+}
+
+func TestDaemonCheckLookup(t *testing.T) {
+	socket, stop := startDaemon(t)
+	hash := testRecords(t)[0].TLSHHash
+
+	// The local database lacks known.exe, so a match for it can only come
+	// from the daemon.
+	local := writeTestDatabase(t, testRecords(t)[2:]...)
+	l := &checkLookup{config: parseTestFlags(t, "-c", "--max-distance", "-1", "--socket", socket, "--db", local, hash)}
+	best, _, _, err := l.nearest(context.Background(), hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if best.FileName != "known.exe" || l.db != nil {
+		t.Errorf("match %s, database loaded %v; want known.exe from the daemon", best.FileName, l.db != nil)
+	}
+
+	if err := stop(); err != nil {
+		t.Fatalf("executeDaemon = %v, want a clean shutdown", err)
+	}
+	if _, err := os.Stat(socket); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("socket left behind after shutdown: %v", err)
+	}
+
+	// With the daemon gone, the lookup falls back to the local database.
+	l = &checkLookup{config: l.config}
+	best, _, _, err = l.nearest(context.Background(), hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !l.noDaemon || l.db == nil || best.FileName == "known.exe" {
+		t.Errorf("match %s, daemon skipped %v; want a match from the local database", best.FileName, l.noDaemon)
+	}
+}
+
+func TestDaemonScan(t *testing.T) {
+	socket, stop := startDaemon(t)
+	dir := t.TempDir()
+	writeFile(t, dir, "known.exe", testSample)
+	writeFile(t, dir, "clean.bin", sampleData(9, 8192))
+
+	// Without a local database, the matches come from the daemon.
+	out, _, st, err := runCLI(t, "-s", "--jsonl", "--max-distance", "30", "--socket", socket, "--db", missingDatabase(t), dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	results, summary := parseJSONL(t, out)
+	byPath := resultsByPath(results)
+	if m := byPath[filepath.Join(dir, "known.exe")].Match; m == nil || m.FileName != "known.exe" || m.Distance != 0 {
+		t.Errorf("known.exe matched %+v, want known.exe at 0", m)
+	}
+	if st != statusMatch || summary.Files != 2 || summary.Matched != 1 {
+		t.Errorf("status %v, summary %+v, want one match of two files", st, summary)
+	}
+
+	// With the daemon gone, the scan reads the local database, which
+	// lacks known.exe.
+	if err := stop(); err != nil {
+		t.Fatal(err)
+	}
+	local := writeTestDatabase(t, testRecords(t)[2:]...)
+	out, stderr, _, err := runCLI(t, "-s", "--jsonl", "--max-distance", "30", "--socket", socket, "--db", local, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	results, _ = parseJSONL(t, out)
+	if m := resultsByPath(results)[filepath.Join(dir, "known.exe")].Match; m != nil && m.FileName == "known.exe" {
+		t.Errorf("known.exe matched %+v, want a match from the local database", m)
+	}
+	if stderr != "" {
+		t.Errorf("stderr = %q, want the fallback to be silent", stderr)
+	}
+	out, _, _, err = runCLI(t, "-s", "--jsonl", "--socket", socket, "--db", missingDatabase(t), dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, summary := parseJSONL(t, out); summary.Failed != 2 {
+		t.Errorf("no daemon and no database: %d files failed, want 2", summary.Failed)
+	}
+}
+
+func TestDaemonShutdownClosesIdleConnections(t *testing.T) {
+	socket, stop := startDaemon(t)
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if err := stop(); err != nil {
+		t.Fatalf("executeDaemon = %v, want a clean shutdown", err)
+	}
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err == nil || errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("idle connection read = %v, want it closed by the daemon", err)
+	}
+}
+
+func TestDaemonRefusesSecondInstance(t *testing.T) {
+	socket, stop := startDaemon(t)
+	defer stop()
+	if _, err := listenSocket(socket); err == nil {
+		t.Fatal("listenSocket succeeded on a socket a daemon is listening on")
+	}
+
+	// A stale socket file, with nothing listening, is replaced.
+	stale := filepath.Join(filepath.Dir(socket), "stale.sock")
+	ln, err := net.Listen("unix", stale)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln.(*net.UnixListener).SetUnlinkOnClose(false)
+	ln.Close()
+	ln2, err := listenSocket(stale)
+	if err != nil {
+		t.Fatalf("listenSocket over a stale socket: %v", err)
+	}
+	ln2.Close()
+}
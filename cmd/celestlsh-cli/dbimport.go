@@ -0,0 +1,266 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Magonia-Research/CelesTLSH-CLI/pkg/celestlsh"
+)
+
+// importKey identifies a database row for deduplication: its TLSH hash,
+// without the T1 prefix, and its SHA256, both lowercased. Rows whose TLSH
+// column is N/A are told apart by their SHA256 alone.
+type importKey struct {
+	tlsh, sha256 string
+}
+
+func newImportKey(tlshHash, sha256 string) importKey {
+	return importKey{
+		tlsh:   strings.ToLower(celestlsh.NormalizeHash(tlshHash)),
+		sha256: strings.ToLower(sha256),
+	}
+}
+
+// importRejection is a manifest row that is not added to the database.
+type importRejection struct {
+	Line   int
+	Reason string
+}
+
+// executeDBImport appends the rows of a manifest in the database CSV
+// schema, such as one written by manifest mode, to the --db database.
+// Rows already in the database, or earlier in the manifest, with the same
+// TLSH hash and SHA256 are skipped, and invalid rows are rejected with
+// their line numbers. The database is rewritten beside itself and renamed
+// into place, so it is never seen half-written; with --dry-run it is left
+// alone and the rows that would be added are printed instead.
+func executeDBImport(config Config) (status, error) {
+	existing, err := os.ReadFile(config.DbPath)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return statusOK, fmt.Errorf("failed to read database: %w", err)
+	}
+	seen, err := databaseKeys(existing)
+	if err != nil {
+		return statusOK, err
+	}
+
+	rows, duplicates, rejected, err := readImportRows(config.DbImport, seen)
+	if err != nil {
+		return statusOK, err
+	}
+	for _, r := range rejected {
+		fmt.Fprintf(os.Stderr, "Warning: rejecting %s line %d: %s\n", config.DbImport, r.Line, r.Reason)
+	}
+
+	if config.DryRun {
+		w := csv.NewWriter(os.Stdout)
+		for _, row := range rows {
+			w.Write(row)
+		}
+		w.Flush()
+		if err := w.Error(); err != nil {
+			return statusOK, err
+		}
+		if !config.Quiet {
+			fmt.Fprintf(os.Stderr, "Would add %d rows to %s; %d duplicates skipped, %d invalid rows rejected (dry run)\n", len(rows), config.DbPath, duplicates, len(rejected))
+		}
+		return batchStatus(0, len(rejected)), nil
+	}
+
+	if len(rows) > 0 {
+		if err := writeImportedDatabase(config.DbPath, existing, rows); err != nil {
+			return statusOK, err
+		}
+		removeStaleSignature(config.DbPath)
+	}
+	if !config.Quiet {
+		fmt.Fprintf(os.Stderr, "Added %d rows to %s; %d duplicates skipped, %d invalid rows rejected\n", len(rows), config.DbPath, duplicates, len(rejected))
+	}
+	return batchStatus(0, len(rejected)), nil
+}
+
+// databaseKeys returns the keys of the rows of a database CSV. Short rows
+// are ignored, as the database loader ignores them.
+func databaseKeys(data []byte) (map[importKey]bool, error) {
+	keys := make(map[importKey]bool)
+	if len(data) == 0 {
+		return keys, nil
+	}
+
+	r := csv.NewReader(bytes.NewReader(data))
+	r.FieldsPerRecord = -1
+	if _, err := r.Read(); err != nil {
+		return nil, &celestlsh.DatabaseError{Op: "reading CSV header", Err: err}
+	}
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, &celestlsh.DatabaseError{Op: "reading CSV record", Err: err}
+		}
+		if len(record) >= celestlsh.Columns {
+			keys[newImportKey(record[3], record[4])] = true
+		}
+	}
+	return keys, nil
+}
+
+// readImportRows reads the manifest at path, returning the rows to add,
+// how many were duplicates of a key in seen, which is updated as rows are
+// accepted, and the rows rejected as invalid.
+func readImportRows(path string, seen map[importKey]bool) ([][]string, int, []importRejection, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, 0, nil, fmt.Errorf("failed to read manifest: %w", err)
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = -1
+	header, err := r.Read()
+	if err != nil {
+		return nil, 0, nil, fmt.Errorf("failed to read manifest header: %w", err)
+	}
+	if len(header) < celestlsh.Columns {
+		return nil, 0, nil, fmt.Errorf("manifest header has %d columns, want %d: %s", len(header), celestlsh.Columns, strings.Join(celestlsh.Header, ","))
+	}
+
+	var rows [][]string
+	var rejected []importRejection
+	duplicates := 0
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		line, _ := r.FieldPos(0)
+		if err != nil {
+			var parseErr *csv.ParseError
+			if !errors.As(err, &parseErr) {
+				return nil, 0, nil, fmt.Errorf("failed to read manifest: %w", err)
+			}
+			rejected = append(rejected, importRejection{Line: parseErr.StartLine, Reason: parseErr.Err.Error()})
+			continue
+		}
+		if reason := invalidImportRow(record); reason != "" {
+			rejected = append(rejected, importRejection{Line: line, Reason: reason})
+			continue
+		}
+
+		key := newImportKey(record[3], record[4])
+		if seen[key] {
+			duplicates++
+			continue
+		}
+		seen[key] = true
+		rows = append(rows, record[:celestlsh.Columns])
+	}
+	return rows, duplicates, rejected, nil
+}
+
+// invalidImportRow returns why a manifest row cannot be added, or "" if it
+// can.
+func invalidImportRow(record []string) string {
+	switch {
+	case len(record) != celestlsh.Columns:
+		return fmt.Sprintf("%d columns, want %d", len(record), celestlsh.Columns)
+	case strings.TrimSpace(record[0]) == "":
+		return "empty Repo Name"
+	case strings.TrimSpace(record[1]) == "":
+		return "empty File Name"
+	case !isSHA256(record[4]):
+		return fmt.Sprintf("invalid SHA256 %q", record[4])
+	}
+	if record[3] != manifestMissingHash {
+		if err := celestlsh.ValidateHash(record[3]); err != nil {
+			return err.Error()
+		}
+	}
+	if record[6] != "" {
+		if _, err := celestlsh.ParseDate(record[6]); err != nil {
+			return fmt.Sprintf("invalid Date Added %q", record[6])
+		}
+	}
+	return ""
+}
+
+// writeImportedDatabase writes existing followed by rows over path. A
+// database that does not exist yet is created with a header.
+func writeImportedDatabase(path string, existing []byte, rows [][]string) error {
+	return replaceDatabase(path, path, func(f *os.File) error {
+		w := csv.NewWriter(f)
+		if len(existing) == 0 {
+			w.Write(celestlsh.Header)
+		} else {
+			if _, err := f.Write(existing); err != nil {
+				return err
+			}
+			if existing[len(existing)-1] != '\n' {
+				if _, err := f.Write([]byte("\n")); err != nil {
+					return err
+				}
+			}
+		}
+		for _, row := range rows {
+			w.Write(row)
+		}
+		w.Flush()
+		return w.Error()
+	})
+}
+
+// removeStaleSignature removes the saved signature of a database that has
+// been changed. It no longer matches, and would make --require-signed
+// refuse the database with a less helpful error.
+func removeStaleSignature(db string) {
+	if err := os.Remove(signaturePath(db)); err == nil {
+		fmt.Fprintf(os.Stderr, "Warning: removed %s, which no longer matches the database\n", signaturePath(db))
+	}
+}
+
+// replaceDatabase has write fill a temporary file beside path and renames
+// it over path, so that the database is never seen half-written. The file
+// gets the permissions of like, the database it was made from, if that
+// exists.
+func replaceDatabase(path, like string, write func(*os.File) error) (err error) {
+	mode := fs.FileMode(0644)
+	if info, statErr := os.Stat(like); statErr == nil {
+		mode = info.Mode().Perm()
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to write database: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+
+	if err = write(tmp); err != nil {
+		return fmt.Errorf("failed to write database: %w", err)
+	}
+	if err = tmp.Sync(); err != nil {
+		return fmt.Errorf("failed to write database: %w", err)
+	}
+	if err = tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write database: %w", err)
+	}
+	if err = os.Chmod(tmp.Name(), mode); err != nil {
+		return fmt.Errorf("failed to write database: %w", err)
+	}
+	if err = os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to write database: %w", err)
+	}
+	return nil
+}
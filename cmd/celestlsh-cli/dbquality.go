@@ -0,0 +1,313 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Magonia-Research/CelesTLSH-CLI/pkg/celestlsh"
+)
+
+// defaultQualitySample is how many records db-quality compares pairwise
+// without --full: about two million pairs, a few seconds on one CPU.
+const defaultQualitySample = 2000
+
+// qualitySeed seeds the choice of sampled records, so that reports on the
+// same database can be compared from one run to the next.
+const qualitySeed = 0x43656c6573544c53
+
+// qualityTopRepos is how many repositories the report lists.
+const qualityTopRepos = 10
+
+// qualityBand counts the pairs of records within one confidence band's
+// distance of each other. Estimated scales a sampled count up to the
+// pairs of the whole database.
+type qualityBand struct {
+	Label       string `json:"label"`
+	MaxDistance int    `json:"max_distance"`
+	Pairs       int64  `json:"pairs"`
+	Estimated   int64  `json:"estimated_pairs"`
+}
+
+// qualityRepo is a repository with near-duplicate pairs among its own
+// records, within the high confidence band.
+type qualityRepo struct {
+	Repo    string `json:"repo"`
+	Records int    `json:"records"`
+	Pairs   int64  `json:"pairs"`
+}
+
+// qualityDuplicate is a TLSH hash that more than one record has verbatim.
+type qualityDuplicate struct {
+	TLSH    string   `json:"tlsh"`
+	Records int      `json:"records"`
+	Repos   []string `json:"repos"`
+}
+
+// qualityReport is the result of db-quality mode. Pair counts and repos
+// cover the Sampled records; Duplicates always covers every record.
+type qualityReport struct {
+	Records    int                `json:"records"`
+	Sampled    int                `json:"sampled"`
+	Full       bool               `json:"full"`
+	Compared   int64              `json:"pairs_compared"`
+	Total      int64              `json:"pairs_total"`
+	Bands      []qualityBand      `json:"bands"`
+	Repos      []qualityRepo      `json:"repos"`
+	Duplicates []qualityDuplicate `json:"verbatim_duplicates"`
+}
+
+// executeDBQuality reports how self-similar the database is: how many
+// pairs of records fall within each --confidence-bands distance, which
+// repositories hold the most near-duplicates of their own records, and
+// which TLSH hashes appear verbatim on several records. Comparing every
+// pair is quadratic, so unless --full is given the pairs are counted
+// within a random sample of --sample records and scaled up to the whole
+// database; verbatim duplicates are always found exactly.
+func executeDBQuality(ctx context.Context, config Config) (status, error) {
+	db, err := loadDatabase(ctx, config)
+	if err != nil {
+		return statusOK, err
+	}
+	records := db.Records()
+
+	sample := records
+	if !config.QualityFull && len(records) > config.QualitySample {
+		r := rand.New(rand.NewPCG(qualitySeed, uint64(len(records))))
+		picked := r.Perm(len(records))[:config.QualitySample]
+		sort.Ints(picked)
+		sample = make([]celestlsh.HashRecord, len(picked))
+		for i, p := range picked {
+			sample[i] = records[p]
+		}
+	}
+
+	report := qualityReport{
+		Records:  len(records),
+		Sampled:  len(sample),
+		Full:     len(sample) == len(records),
+		Compared: pairCount(len(sample)),
+		Total:    pairCount(len(records)),
+	}
+
+	bands, repoPairs, err := countSimilarPairs(ctx, config, sample)
+	if err != nil {
+		return statusOK, err
+	}
+	for i, max := range config.ConfidenceBands {
+		b := qualityBand{Label: confidenceLabels[i], MaxDistance: max, Pairs: bands[i], Estimated: bands[i]}
+		if report.Compared > 0 && report.Compared < report.Total {
+			b.Estimated = int64(float64(bands[i]) * float64(report.Total) / float64(report.Compared))
+		}
+		report.Bands = append(report.Bands, b)
+	}
+
+	repoRecords := make(map[string]int)
+	for _, r := range sample {
+		repoRecords[r.RepoName]++
+	}
+	report.Repos = []qualityRepo{}
+	for repo, pairs := range repoPairs {
+		report.Repos = append(report.Repos, qualityRepo{Repo: repo, Records: repoRecords[repo], Pairs: pairs})
+	}
+	sort.Slice(report.Repos, func(i, j int) bool {
+		a, b := report.Repos[i], report.Repos[j]
+		if a.Pairs != b.Pairs {
+			return a.Pairs > b.Pairs
+		}
+		return a.Repo < b.Repo
+	})
+	if len(report.Repos) > qualityTopRepos {
+		report.Repos = report.Repos[:qualityTopRepos]
+	}
+
+	report.Duplicates = verbatimDuplicates(records)
+
+	if config.OutputJSON {
+		return statusOK, printJSON(report)
+	}
+	printQualityReport(report)
+	return statusOK, nil
+}
+
+// countSimilarPairs compares every pair of records and returns how many
+// are within each confidence band, and how many pairs of each repository's
+// own records are within the high confidence band. Records are held as a
+// database, so that its index rules out distant pairs, and are compared in
+// parallel.
+func countSimilarPairs(ctx context.Context, config Config, records []celestlsh.HashRecord) ([]int64, map[string]int64, error) {
+	bands := make([]int64, len(config.ConfidenceBands))
+	repoPairs := make(map[string]int64)
+
+	// Each record carries its index as its file name, so that every pair
+	// is counted once, from its first record.
+	indexed := make([]celestlsh.HashRecord, len(records))
+	for i, r := range records {
+		indexed[i] = celestlsh.HashRecord{TLSHHash: r.TLSHHash, FileName: strconv.Itoa(i)}
+	}
+	db, err := celestlsh.NewDatabase(indexed)
+	if err != nil {
+		return bands, nil, err
+	}
+	// Parallelism comes from comparing several records at once.
+	db.Workers = 1
+	limit := config.ConfidenceBands[len(config.ConfidenceBands)-1]
+
+	var done atomic.Int64
+	var progress *progressLine
+	if progressEnabled(config) {
+		progress = startProgress(func(time.Duration) string {
+			return fmt.Sprintf("Comparing: %d / %d records", done.Load(), len(records))
+		})
+	}
+
+	var mu sync.Mutex
+	work := make(chan int)
+	var wg sync.WaitGroup
+	var firstErr error
+	var errOnce sync.Once
+	for w := 0; w < workerCount(config); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			local := make([]int64, len(bands))
+			localRepos := make(map[string]int64)
+			for i := range work {
+				matches, err := db.Nearest(ctx, records[i].TLSHHash, limit, -1)
+				if err != nil {
+					errOnce.Do(func() { firstErr = err })
+					continue
+				}
+				for _, m := range matches {
+					j, _ := strconv.Atoi(m.FileName)
+					if j <= i {
+						continue
+					}
+					for b, max := range config.ConfidenceBands {
+						if m.Distance <= max {
+							local[b]++
+						}
+					}
+					if m.Distance <= config.ConfidenceBands[0] && records[j].RepoName == records[i].RepoName {
+						localRepos[records[i].RepoName]++
+					}
+				}
+				done.Add(1)
+			}
+			mu.Lock()
+			for b := range bands {
+				bands[b] += local[b]
+			}
+			for repo, n := range localRepos {
+				repoPairs[repo] += n
+			}
+			mu.Unlock()
+		}()
+	}
+	for i := range records {
+		if ctx.Err() != nil {
+			break
+		}
+		work <- i
+	}
+	close(work)
+	wg.Wait()
+	progress.finish()
+
+	if err := ctx.Err(); err != nil {
+		return bands, nil, err
+	}
+	return bands, repoPairs, firstErr
+}
+
+// verbatimDuplicates returns the TLSH hashes that more than one record
+// has, ignoring case and the T1 prefix, most repeated first.
+func verbatimDuplicates(records []celestlsh.HashRecord) []qualityDuplicate {
+	index := make(map[string]int)
+	var all []qualityDuplicate
+	for _, r := range records {
+		key := strings.ToLower(celestlsh.NormalizeHash(r.TLSHHash))
+		i, ok := index[key]
+		if !ok {
+			i = len(all)
+			index[key] = i
+			all = append(all, qualityDuplicate{TLSH: key})
+		}
+		all[i].Records++
+		if !slices.Contains(all[i].Repos, r.RepoName) {
+			all[i].Repos = append(all[i].Repos, r.RepoName)
+		}
+	}
+
+	duplicates := []qualityDuplicate{}
+	for _, d := range all {
+		if d.Records > 1 {
+			duplicates = append(duplicates, d)
+		}
+	}
+	sort.SliceStable(duplicates, func(i, j int) bool {
+		return duplicates[i].Records > duplicates[j].Records
+	})
+	return duplicates
+}
+
+func printQualityReport(report qualityReport) {
+	if report.Full {
+		fmt.Printf("%d records, all %d pairs compared\n", report.Records, report.Compared)
+	} else {
+		fmt.Printf("%d records, %d sampled: %d of %d pairs compared\n", report.Records, report.Sampled, report.Compared, report.Total)
+	}
+
+	fmt.Println("\nPairs within distance:")
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	for _, b := range report.Bands {
+		if report.Full {
+			fmt.Fprintf(tw, "  %d (%s)\t%d pairs\n", b.MaxDistance, b.Label, b.Pairs)
+		} else {
+			fmt.Fprintf(tw, "  %d (%s)\t%d pairs\tabout %d in the database\n", b.MaxDistance, b.Label, b.Pairs, b.Estimated)
+		}
+	}
+	tw.Flush()
+
+	where := "records"
+	if !report.Full {
+		where = "sampled records"
+	}
+	if len(report.Repos) == 0 {
+		fmt.Printf("\nNo repository has near-duplicate %s within distance %d\n", where, report.Bands[0].MaxDistance)
+	} else {
+		fmt.Printf("\nRepositories with the most near-duplicate %s within distance %d:\n", where, report.Bands[0].MaxDistance)
+		tw = tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		for _, r := range report.Repos {
+			fmt.Fprintf(tw, "  %s\t%d pairs\tof %d records\n", r.Repo, r.Pairs, r.Records)
+		}
+		tw.Flush()
+	}
+
+	if len(report.Duplicates) == 0 {
+		fmt.Println("\nNo TLSH hash appears on more than one record")
+		return
+	}
+	repeated := 0
+	for _, d := range report.Duplicates {
+		repeated += d.Records
+	}
+	fmt.Printf("\n%d TLSH hashes appear verbatim on %d records:\n", len(report.Duplicates), repeated)
+	for _, d := range report.Duplicates {
+		fmt.Printf("  %s  x%d  %s\n", d.TLSH, d.Records, strings.Join(d.Repos, ", "))
+	}
+}
+
+// pairCount returns the number of unordered pairs of n items.
+func pairCount(n int) int64 {
+	return int64(n) * int64(n-1) / 2
+}
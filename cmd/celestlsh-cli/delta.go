@@ -0,0 +1,325 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/Magonia-Research/CelesTLSH-CLI/pkg/celestlsh"
+)
+
+// dbMeta describes a downloaded database. It is saved beside the database
+// after every download, so that --delta can ask for the rows added since
+// LastAdded, and tell from SHA256 whether the file has changed since.
+type dbMeta struct {
+	URL        string `json:"url"`
+	Downloaded string `json:"downloaded"`
+	Rows       int    `json:"rows"`
+	LastAdded  string `json:"last_added,omitempty"`
+	SHA256     string `json:"sha256"`
+}
+
+// dbMetaPath is where the metadata of a downloaded database is kept.
+func dbMetaPath(dbPath string) string {
+	return dbPath + ".meta.json"
+}
+
+// describeDatabase returns the metadata of the database CSV data,
+// downloaded from source. LastAdded is the latest Date Added of its rows,
+// as written in the file.
+func describeDatabase(data []byte, source string) (dbMeta, error) {
+	sum := sha256.Sum256(data)
+	meta := dbMeta{URL: source, Downloaded: time.Now().UTC().Format(time.RFC3339), SHA256: hex.EncodeToString(sum[:])}
+
+	r := csv.NewReader(bytes.NewReader(data))
+	r.FieldsPerRecord = -1
+	if _, err := r.Read(); err != nil {
+		return meta, &celestlsh.DatabaseError{Op: "reading CSV header", Err: err}
+	}
+	var last time.Time
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return meta, &celestlsh.DatabaseError{Op: "reading CSV record", Err: err}
+		}
+		meta.Rows++
+		if len(record) < celestlsh.Columns {
+			continue
+		}
+		if added, err := celestlsh.ParseDate(record[6]); err == nil && added.After(last) {
+			last, meta.LastAdded = added, strings.TrimSpace(record[6])
+		}
+	}
+	return meta, nil
+}
+
+// saveDBMeta records the metadata of the database at dbPath, just
+// downloaded from source. Failing to is only worth a warning, as the next
+// --delta then falls back to a full download.
+func saveDBMeta(dbPath, source string) {
+	err := func() error {
+		data, err := os.ReadFile(dbPath)
+		if err != nil {
+			return err
+		}
+		meta, err := describeDatabase(data, source)
+		if err != nil {
+			return err
+		}
+		out, err := json.MarshalIndent(meta, "", "  ")
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(dbMetaPath(dbPath), append(out, '\n'), 0644)
+	}()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to save %s: %v\n", dbMetaPath(dbPath), err)
+	}
+}
+
+// deltaUnavailable is why a --delta download falls back to a full one.
+type deltaUnavailable struct {
+	reason string
+}
+
+func (e *deltaUnavailable) Error() string { return e.reason }
+
+// downloadDelta updates the database at --db with the rows added since it
+// was downloaded, fetched from --delta-url, or the database URL, with a
+// since parameter. It returns a *deltaUnavailable error when a full
+// download is needed instead: when there is no metadata from an earlier
+// download of the same URL, the database has changed since, or the server
+// does not answer with rows in the database schema. A server that ignores
+// since and sends the whole database is recognised by rows older than it,
+// and its answer is saved as a full download.
+func downloadDelta(ctx context.Context, config Config, d *celestlsh.Downloader) error {
+	existing, err := os.ReadFile(config.DbPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return &deltaUnavailable{config.DbPath + " does not exist"}
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read database: %w", err)
+	}
+	metaData, err := os.ReadFile(dbMetaPath(config.DbPath))
+	if err != nil {
+		return &deltaUnavailable{"no metadata from an earlier download in " + dbMetaPath(config.DbPath)}
+	}
+	var meta dbMeta
+	if err := json.Unmarshal(metaData, &meta); err != nil {
+		return &deltaUnavailable{fmt.Sprintf("%s is unreadable: %v", dbMetaPath(config.DbPath), err)}
+	}
+	if sum := sha256.Sum256(existing); hex.EncodeToString(sum[:]) != meta.SHA256 {
+		return &deltaUnavailable{config.DbPath + " has changed since it was downloaded"}
+	}
+	if meta.URL != d.URL {
+		return &deltaUnavailable{"the database was downloaded from " + meta.URL}
+	}
+	since, err := celestlsh.ParseDate(meta.LastAdded)
+	if err != nil {
+		return &deltaUnavailable{"the database has no dated rows"}
+	}
+
+	location := config.DeltaURL
+	if location == "" {
+		location = d.URL
+	}
+	u, err := url.Parse(location)
+	if err != nil {
+		return fmt.Errorf("invalid --delta-url: %w", err)
+	}
+	query := u.Query()
+	query.Set("since", meta.LastAdded)
+	u.RawQuery = query.Encode()
+	location = u.String()
+
+	slog.Info("downloading database delta", "url", location, "since", meta.LastAdded)
+	body, err := fetchDelta(ctx, d, location)
+	if err != nil {
+		return err
+	}
+
+	header, seen, err := databaseRows(existing)
+	if err != nil {
+		return err
+	}
+	rows, duplicates, full, err := readDeltaRows(body, header, seen, since)
+	if err != nil {
+		return err
+	}
+
+	if full {
+		slog.Info("delta server sent the whole database", "url", location)
+		if err := replaceDatabase(config.DbPath, config.DbPath, func(f *os.File) error {
+			_, err := f.Write(body)
+			return err
+		}); err != nil {
+			return err
+		}
+	} else if len(rows) > 0 {
+		if err := writeImportedDatabase(config.DbPath, existing, rows); err != nil {
+			return err
+		}
+	}
+	if full || len(rows) > 0 {
+		removeStaleSignature(config.DbPath)
+		saveDBMeta(config.DbPath, d.URL)
+	}
+
+	if !config.Quiet {
+		switch {
+		case full:
+			fmt.Printf("CSV database downloaded to %s (the delta server sent the whole database)\n", config.DbPath)
+		case len(rows) == 0:
+			fmt.Printf("%s is up to date: no rows added since %s\n", config.DbPath, meta.LastAdded)
+		default:
+			fmt.Printf("Added %d rows dated %s or later to %s; %d were already present\n", len(rows), meta.LastAdded, config.DbPath, duplicates)
+		}
+	}
+	return nil
+}
+
+// fetchDelta requests location, returning its body, or nil when the
+// server has nothing new. Statuses saying the request is not understood
+// mean the server does not serve deltas.
+func fetchDelta(ctx context.Context, d *celestlsh.Downloader, location string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, location, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download delta: %w", &celestlsh.RequestError{URL: location, Err: err})
+	}
+	for name, values := range d.Header {
+		req.Header[name] = append(req.Header[name], values...)
+	}
+	resp, err := d.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download delta: %w", &celestlsh.RequestError{URL: location, Err: err})
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNoContent, http.StatusNotModified:
+		return nil, nil
+	case http.StatusBadRequest, http.StatusNotFound, http.StatusMethodNotAllowed, http.StatusNotImplemented:
+		return nil, &deltaUnavailable{fmt.Sprintf("the server does not serve deltas (status %d)", resp.StatusCode)}
+	default:
+		return nil, fmt.Errorf("failed to download delta: %w", &celestlsh.StatusError{URL: location, StatusCode: resp.StatusCode})
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download delta: %w", &celestlsh.RequestError{URL: location, Err: err})
+	}
+	return body, nil
+}
+
+// databaseRows returns the header of a database CSV and the set of its
+// rows, each keyed by its fields, so that delta rows already in the
+// database are told apart exactly.
+func databaseRows(data []byte) ([]string, map[string]bool, error) {
+	r := csv.NewReader(bytes.NewReader(data))
+	r.FieldsPerRecord = -1
+	header, err := r.Read()
+	if err != nil {
+		return nil, nil, &celestlsh.DatabaseError{Op: "reading CSV header", Err: err}
+	}
+	rows := make(map[string]bool)
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, &celestlsh.DatabaseError{Op: "reading CSV record", Err: err}
+		}
+		rows[strings.Join(record, "\x00")] = true
+	}
+	return trimBOM(header), rows, nil
+}
+
+// readDeltaRows parses a delta, which must have the database's header,
+// returning its rows not in seen and how many were. full is set when a
+// row was added before since, meaning that the server sent the whole
+// database. A row that is not valid for the database fails the delta,
+// leaving the database as it was.
+func readDeltaRows(body []byte, header []string, seen map[string]bool, since time.Time) (rows [][]string, duplicates int, full bool, err error) {
+	if len(bytes.TrimSpace(body)) == 0 {
+		return nil, 0, false, nil
+	}
+	r := csv.NewReader(bytes.NewReader(body))
+	r.FieldsPerRecord = -1
+	got, err := r.Read()
+	if err != nil || !slices.Equal(trimBOM(got), header) {
+		return nil, 0, false, &deltaUnavailable{"the server did not answer with rows of the database"}
+	}
+
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		line, _ := r.FieldPos(0)
+		if err != nil {
+			return nil, 0, false, fmt.Errorf("invalid delta: %w", err)
+		}
+		if reason := invalidDeltaRow(record); reason != "" {
+			return nil, 0, false, fmt.Errorf("invalid delta: line %d: %s", line, reason)
+		}
+		if added, err := celestlsh.ParseDate(record[6]); err == nil && added.Before(since) {
+			full = true
+		}
+
+		key := strings.Join(record, "\x00")
+		if seen[key] {
+			duplicates++
+			continue
+		}
+		seen[key] = true
+		rows = append(rows, record)
+	}
+	return rows, duplicates, full, nil
+}
+
+// invalidDeltaRow returns why a delta row cannot be added to the database,
+// or "" if it can. Rows are held to what the database itself may contain,
+// so that a delta gives the same rows as a full download.
+func invalidDeltaRow(record []string) string {
+	if len(record) < celestlsh.Columns {
+		return fmt.Sprintf("%d columns, want %d", len(record), celestlsh.Columns)
+	}
+	if record[3] != "" && record[3] != manifestMissingHash {
+		if err := celestlsh.ValidateHash(record[3]); err != nil {
+			return err.Error()
+		}
+	}
+	if record[6] != "" {
+		if _, err := celestlsh.ParseDate(record[6]); err != nil {
+			return fmt.Sprintf("invalid Date Added %q", record[6])
+		}
+	}
+	return ""
+}
+
+// trimBOM removes a UTF-8 byte order mark from the first field of a CSV
+// header.
+func trimBOM(header []string) []string {
+	if len(header) > 0 {
+		header = slices.Clone(header)
+		header[0] = strings.TrimPrefix(header[0], "\ufeff")
+	}
+	return header
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/Magonia-Research/CelesTLSH-CLI/pkg/celestlsh"
+)
+
+// deltaServer serves a database at /db.csv, answering requests with a
+// since parameter with the rows added on or after that date, unless
+// answer is set to answer them instead.
+type deltaServer struct {
+	*httptest.Server
+	mu      sync.Mutex
+	records []celestlsh.HashRecord
+	answer  func(w http.ResponseWriter, r *http.Request)
+}
+
+func newDeltaServer(t *testing.T, records []celestlsh.HashRecord) *deltaServer {
+	s := &deltaServer{records: records}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		since := r.URL.Query().Get("since")
+		if since != "" && s.answer != nil {
+			s.answer(w, r)
+			return
+		}
+		var rows []celestlsh.HashRecord
+		for _, rec := range s.records {
+			if since == "" || rec.DateAdded >= since {
+				rows = append(rows, rec)
+			}
+		}
+		w.Write(databaseCSV(rows))
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *deltaServer) set(records []celestlsh.HashRecord, answer func(w http.ResponseWriter, r *http.Request)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.records, s.answer = records, answer
+}
+
+func databaseCSV(records []celestlsh.HashRecord) []byte {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	w.Write(celestlsh.Header)
+	for _, r := range records {
+		w.Write(r.CSVRow())
+	}
+	w.Flush()
+	return buf.Bytes()
+}
+
+// deltaRecords returns the records of testRecords, dated a few days apart,
+// and two more added later: one on the day of the last of them and one a
+// month after.
+func deltaRecords(t *testing.T) (initial, later []celestlsh.HashRecord) {
+	records := testRecords(t)
+	for i, date := range []string{"2024-03-01", "2024-03-02", "2024-03-05", "2024-03-05"} {
+		records[i].DateAdded = date
+	}
+	added := records[0]
+	added.FileName, added.TLSHHash, added.DateAdded = "added.exe", testHash(t, sampleData(4, 8192)), "2024-04-01"
+	return records[:3], append(records, added)
+}
+
+// fullDownload downloads the database at url to path, as download mode
+// does without --delta.
+func fullDownload(t *testing.T, url, path string) {
+	t.Helper()
+	d := celestlsh.NewDownloader()
+	d.URL = url
+	if err := d.Download(context.Background(), path); err != nil {
+		t.Fatal(err)
+	}
+	saveDBMeta(path, d.URL)
+}
+
+// sortedLines returns the lines of the file at path, the header first and
+// the rows sorted.
+func sortedLines(t *testing.T, path string) []string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	slices.Sort(lines[1:])
+	return lines
+}
+
+func TestDownloadDelta(t *testing.T) {
+	initial, later := deltaRecords(t)
+	srv := newDeltaServer(t, initial)
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, "db.csv")
+	fullDownload(t, srv.URL+"/db.csv", dbPath)
+
+	var meta dbMeta
+	data, _ := os.ReadFile(dbMetaPath(dbPath))
+	if err := json.Unmarshal(data, &meta); err != nil || meta.Rows != 3 || meta.LastAdded != "2024-03-05" || meta.URL != srv.URL+"/db.csv" {
+		t.Fatalf("metadata %s, %v; want 3 rows, last added 2024-03-05", data, err)
+	}
+
+	// The delta has the last record already present and two new ones;
+	// the result is what a full download gives.
+	srv.set(later, nil)
+	d := celestlsh.NewDownloader()
+	d.URL = srv.URL + "/db.csv"
+	if err := downloadDelta(context.Background(), Config{DbPath: dbPath, Quiet: true}, d); err != nil {
+		t.Fatal(err)
+	}
+	fresh := filepath.Join(dir, "fresh.csv")
+	fullDownload(t, srv.URL+"/db.csv", fresh)
+	if got, want := sortedLines(t, dbPath), sortedLines(t, fresh); !slices.Equal(got, want) {
+		t.Errorf("after the delta:\n%s\nwant as a full download:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+	data, _ = os.ReadFile(dbMetaPath(dbPath))
+	if err := json.Unmarshal(data, &meta); err != nil || meta.Rows != 5 || meta.LastAdded != "2024-04-01" {
+		t.Errorf("metadata after the delta %s, %v; want 5 rows, last added 2024-04-01", data, err)
+	}
+
+	// With nothing new, the database is left as it is.
+	before, _ := os.ReadFile(dbPath)
+	if err := downloadDelta(context.Background(), Config{DbPath: dbPath, Quiet: true}, d); err != nil {
+		t.Fatal(err)
+	}
+	if after, _ := os.ReadFile(dbPath); !bytes.Equal(before, after) {
+		t.Errorf("database changed by an empty delta:\n%s", after)
+	}
+
+	// A separate delta URL is asked with the since parameter too.
+	var asked string
+	deltaSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		asked = r.URL.RawQuery
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer deltaSrv.Close()
+	if err := downloadDelta(context.Background(), Config{DbPath: dbPath, Quiet: true, DeltaURL: deltaSrv.URL + "/delta?format=csv"}, d); err != nil {
+		t.Fatal(err)
+	}
+	if asked != "format=csv&since=2024-04-01" {
+		t.Errorf("delta URL asked with %q", asked)
+	}
+}
+
+func TestDownloadDeltaFullAnswer(t *testing.T) {
+	// A server that ignores since sends the whole database, which replaces
+	// the local one.
+	initial, later := deltaRecords(t)
+	srv := newDeltaServer(t, initial)
+	dbPath := filepath.Join(t.TempDir(), "db.csv")
+	fullDownload(t, srv.URL+"/db.csv", dbPath)
+
+	srv.set(later, func(w http.ResponseWriter, r *http.Request) {
+		w.Write(databaseCSV(later))
+	})
+	d := celestlsh.NewDownloader()
+	d.URL = srv.URL + "/db.csv"
+	if err := downloadDelta(context.Background(), Config{DbPath: dbPath, Quiet: true}, d); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := os.ReadFile(dbPath); !bytes.Equal(got, databaseCSV(later)) {
+		t.Errorf("database:\n%s\nwant the whole database sent", got)
+	}
+}
+
+func TestDownloadDeltaUnavailable(t *testing.T) {
+	initial, later := deltaRecords(t)
+
+	tests := []struct {
+		name   string
+		setup  func(t *testing.T, srv *deltaServer, dbPath string)
+		url    string
+		reason string
+	}{
+		{"no metadata", func(t *testing.T, _ *deltaServer, dbPath string) {
+			os.Remove(dbMetaPath(dbPath))
+		}, "", "no metadata from an earlier download"},
+		{"unreadable metadata", func(t *testing.T, _ *deltaServer, dbPath string) {
+			os.WriteFile(dbMetaPath(dbPath), []byte("{"), 0644)
+		}, "", "is unreadable"},
+		{"database changed", func(t *testing.T, _ *deltaServer, dbPath string) {
+			f, _ := os.OpenFile(dbPath, os.O_APPEND|os.O_WRONLY, 0)
+			f.WriteString("Mine,mine.exe,,,,,,\n")
+			f.Close()
+		}, "", "has changed since it was downloaded"},
+		{"other URL", nil, "/other.csv", "the database was downloaded from"},
+		{"not served", func(t *testing.T, srv *deltaServer, _ string) {
+			srv.set(later, func(w http.ResponseWriter, r *http.Request) { http.NotFound(w, r) })
+		}, "", "the server does not serve deltas (status 404)"},
+		{"other schema", func(t *testing.T, srv *deltaServer, _ string) {
+			srv.set(later, func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("<html>sign in</html>\n")) })
+		}, "", "the server did not answer with rows of the database"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newDeltaServer(t, initial)
+			dbPath := filepath.Join(t.TempDir(), "db.csv")
+			fullDownload(t, srv.URL+"/db.csv", dbPath)
+			if tt.setup != nil {
+				tt.setup(t, srv, dbPath)
+			}
+			before, _ := os.ReadFile(dbPath)
+
+			d := celestlsh.NewDownloader()
+			d.URL = srv.URL + "/db.csv"
+			if tt.url != "" {
+				d.URL = srv.URL + tt.url
+			}
+			err := downloadDelta(context.Background(), Config{DbPath: dbPath, Quiet: true}, d)
+			var unavailable *deltaUnavailable
+			if !errors.As(err, &unavailable) || !strings.Contains(err.Error(), tt.reason) {
+				t.Errorf("downloadDelta = %v, want a fallback because %s", err, tt.reason)
+			}
+			if after, _ := os.ReadFile(dbPath); !bytes.Equal(before, after) {
+				t.Errorf("database changed:\n%s", after)
+			}
+		})
+	}
+
+	// A missing database falls back too.
+	d := celestlsh.NewDownloader()
+	err := downloadDelta(context.Background(), Config{DbPath: filepath.Join(t.TempDir(), "db.csv"), Quiet: true}, d)
+	var unavailable *deltaUnavailable
+	if !errors.As(err, &unavailable) || !strings.Contains(err.Error(), "does not exist") {
+		t.Errorf("downloadDelta without a database = %v", err)
+	}
+}
+
+func TestDownloadDeltaInvalid(t *testing.T) {
+	initial, later := deltaRecords(t)
+	srv := newDeltaServer(t, initial)
+	dbPath := filepath.Join(t.TempDir(), "db.csv")
+	fullDownload(t, srv.URL+"/db.csv", dbPath)
+	before, _ := os.ReadFile(dbPath)
+
+	bad := later[3]
+	bad.TLSHHash = "not a hash"
+	for _, tt := range []struct {
+		name string
+		rows []celestlsh.HashRecord
+		want string
+	}{
+		{"hash", []celestlsh.HashRecord{later[3], bad}, "invalid delta: line 3: "},
+		{"date", []celestlsh.HashRecord{{RepoName: "Tool", FileName: "x.exe", DateAdded: "someday"}}, `invalid delta: line 2: invalid Date Added "someday"`},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			srv.set(later, func(w http.ResponseWriter, r *http.Request) { w.Write(databaseCSV(tt.rows)) })
+			d := celestlsh.NewDownloader()
+			d.URL = srv.URL + "/db.csv"
+			err := downloadDelta(context.Background(), Config{DbPath: dbPath, Quiet: true}, d)
+			var unavailable *deltaUnavailable
+			if err == nil || errors.As(err, &unavailable) || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("downloadDelta = %v, want an error saying %q", err, tt.want)
+			}
+			if after, _ := os.ReadFile(dbPath); !bytes.Equal(before, after) {
+				t.Errorf("database changed by an invalid delta:\n%s", after)
+			}
+		})
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bufio"
+	"cmp"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/Magonia-Research/CelesTLSH-CLI/pkg/celestlsh"
+)
+
+// candidateDistance is a candidate hash of --distance-many and its
+// distance from the subject, or why it has none.
+type candidateDistance struct {
+	TLSH     string `json:"tlsh"`
+	Distance *int   `json:"distance,omitempty"`
+	Nearest  bool   `json:"nearest,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// executeDistanceMany prints the distance of the subject hash from each
+// candidate, closest first. Every candidate at the smallest distance is
+// marked nearest. Invalid candidates are listed last, in the order given,
+// each with its error.
+func executeDistanceMany(config Config) (status, error) {
+	if err := celestlsh.ValidateHash(config.Hash1); err != nil {
+		return statusOK, fmt.Errorf("invalid subject hash: %w", err)
+	}
+
+	candidates := slices.Clone(config.Candidates)
+	if config.AgainstFile != "" {
+		read, err := readCandidates(config.AgainstFile)
+		if err != nil {
+			return statusOK, err
+		}
+		candidates = append(candidates, read...)
+	}
+	if len(candidates) == 0 {
+		return statusOK, errors.New("no candidate hashes to compare with")
+	}
+
+	var results, invalid []candidateDistance
+	for _, hash := range candidates {
+		if err := celestlsh.ValidateHash(hash); err != nil {
+			invalid = append(invalid, candidateDistance{TLSH: hash, Error: err.Error()})
+			continue
+		}
+		distance, err := celestlsh.Distance(config.Hash1, hash)
+		if err != nil {
+			invalid = append(invalid, candidateDistance{TLSH: hash, Error: err.Error()})
+			continue
+		}
+		results = append(results, candidateDistance{TLSH: hash, Distance: &distance})
+	}
+	if len(results) == 0 {
+		return statusOK, errors.New("no valid candidate hashes to compare with")
+	}
+
+	slices.SortStableFunc(results, func(a, b candidateDistance) int {
+		return cmp.Compare(*a.Distance, *b.Distance)
+	})
+	for i := 0; i < len(results) && *results[i].Distance == *results[0].Distance; i++ {
+		results[i].Nearest = true
+	}
+	nearest := results[0]
+	results = append(results, invalid...)
+
+	var err error
+	switch {
+	case config.OutputJSON:
+		err = printJSON(struct {
+			Subject    string              `json:"subject"`
+			Nearest    candidateDistance   `json:"nearest"`
+			Candidates []candidateDistance `json:"candidates"`
+		}{config.Hash1, nearest, results})
+	case config.OutputCSV:
+		w := csv.NewWriter(os.Stdout)
+		w.Write([]string{"candidate", "distance", "nearest", "error"})
+		for _, r := range results {
+			distance := ""
+			if r.Distance != nil {
+				distance = strconv.Itoa(*r.Distance)
+			}
+			w.Write([]string{r.TLSH, distance, strconv.FormatBool(r.Nearest), r.Error})
+		}
+		w.Flush()
+		err = w.Error()
+	case config.Quiet:
+		fmt.Printf("%s %d\n", nearest.TLSH, *nearest.Distance)
+	default:
+		fmt.Printf("Distances from %s:\n", config.Hash1)
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		for _, r := range results {
+			switch {
+			case r.Distance == nil:
+				fmt.Fprintf(tw, "  -\t%s\tinvalid: %s\n", r.TLSH, r.Error)
+			case r.Nearest:
+				// Only the last cell is painted, as tabwriter would count
+				// the escapes towards the width of the others.
+				fmt.Fprintf(tw, "  %d\t%s\t%s\n", *r.Distance, r.TLSH, paint(styleMatch, "[nearest]"))
+			default:
+				fmt.Fprintf(tw, "  %d\t%s\t\n", *r.Distance, r.TLSH)
+			}
+		}
+		tw.Flush()
+	}
+	if err != nil {
+		return statusOK, fmt.Errorf("failed to write results: %w", err)
+	}
+	return batchStatus(0, len(invalid)), nil
+}
+
+// readCandidates reads the candidate hashes of --against-file, "-" being
+// stdin: one per line, skipping blank lines and # comments, as the lists
+// of cross-check mode. They are validated with the rest.
+func readCandidates(path string) ([]string, error) {
+	f := os.Stdin
+	if path != "-" {
+		var err error
+		f, err = os.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read candidate hashes: %w", err)
+		}
+		defer f.Close()
+	}
+
+	var hashes []string
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		if text := strings.TrimSpace(sc.Text()); text != "" && !strings.HasPrefix(text, "#") {
+			hashes = append(hashes, text)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read candidate hashes: %w", err)
+	}
+	return hashes, nil
+}
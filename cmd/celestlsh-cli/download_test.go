@@ -0,0 +1,65 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDownloadManifest(t *testing.T) {
+	database, err := os.ReadFile(writeTestDatabase(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	header, rest, _ := strings.Cut(string(database), "\n")
+	rows := strings.SplitAfter(rest, "\n")
+	shards := map[string]string{
+		"known.csv": header + "\n" + strings.Join(rows[:2], ""),
+		"other.csv": header + "\n" + strings.Join(rows[2:], ""),
+	}
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(r.URL.Path, "/")
+		if name == "manifest.json" {
+			var entries []string
+			for _, name := range []string{"known.csv", "other.csv"} {
+				sum := sha256.Sum256([]byte(shards[name]))
+				entries = append(entries, fmt.Sprintf(`{"url":%q,"size":%d,"sha256":%q}`, name, len(shards[name]), hex.EncodeToString(sum[:])))
+			}
+			fmt.Fprintf(w, "[%s]", strings.Join(entries, ","))
+			return
+		}
+		data, ok := shards[name]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(data))
+	}))
+	defer ts.Close()
+
+	dbPath := filepath.Join(t.TempDir(), "db.csv")
+	out, _, _, err := runCLI(t, "--download", "--manifest-url", ts.URL+"/manifest.json", "--workers", "2", "--db", dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out, "CSV database downloaded to "+dbPath) {
+		t.Errorf("output:\n%s", out)
+	}
+	// Joined, the shards make up the database they were cut from.
+	if got, _ := os.ReadFile(dbPath); string(got) != string(database) {
+		t.Errorf("downloaded:\n%s\nwant:\n%s", got, database)
+	}
+	// The database checks as the original does.
+	out, _, st, err := runCLI(t, "-c", "--json", "--db", dbPath, testRecords(t)[2].TLSHHash)
+	if err != nil || st != statusMatch || !strings.Contains(out, "other.exe") {
+		t.Errorf("check against the download: status %v, %v, output:\n%s", st, err, out)
+	}
+
+}
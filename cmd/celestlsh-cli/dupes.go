@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/Magonia-Research/CelesTLSH-CLI/pkg/celestlsh"
+)
+
+// defaultDupeDistance is the find-dupes threshold used when --max-distance
+// is not given; TLSH distances this low usually mean rebuilds of the same
+// source.
+const defaultDupeDistance = 30
+
+// dupeMember is a file in a group of near-duplicates. Identical is set when
+// another member of the group has the same SHA256.
+type dupeMember struct {
+	Name      string `json:"name"`
+	TLSH      string `json:"tlsh"`
+	SHA256    string `json:"sha256"`
+	Size      int64  `json:"size"`
+	Identical bool   `json:"identical"`
+}
+
+// dupeGroup is a set of files within the threshold of one another, linked
+// as in cluster mode. Reclaimable is the space freed by keeping only the
+// medoid.
+type dupeGroup struct {
+	ID          int          `json:"id"`
+	Files       int          `json:"files"`
+	TotalSize   int64        `json:"total_size"`
+	Reclaimable int64        `json:"reclaimable"`
+	Medoid      string       `json:"medoid"`
+	Members     []dupeMember `json:"members"`
+}
+
+func executeFindDupes(ctx context.Context, config Config) (status, error) {
+	threshold := config.MaxDistance
+	if threshold < 0 {
+		threshold = defaultDupeDistance
+	}
+
+	paths, err := expandDirectories([]string{config.DupesDir})
+	if err != nil {
+		return statusOK, err
+	}
+
+	var inputs []string
+	small, skipped := 0, 0
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			skipped++
+			fmt.Fprintf(os.Stderr, "Warning: skipping %s: %v\n", path, err)
+			continue
+		}
+		if info.Size() < config.MinSize {
+			small++
+			continue
+		}
+		inputs = append(inputs, path)
+	}
+
+	// Every input is a file, even one whose name looks like a TLSH hash.
+	config.DistanceFiles = true
+	config.Digests = celestlsh.DigestSHA256
+
+	hashes, failed, err := hashInputs(ctx, config, inputs)
+	if err != nil {
+		return statusOK, err
+	}
+	for _, f := range failed {
+		var hashingErr *celestlsh.HashingError
+		if errors.As(f.Err, &hashingErr) {
+			small++
+			continue
+		}
+		skipped++
+		fmt.Fprintf(os.Stderr, "Warning: skipping %s: %v\n", f.Name, f.Err)
+	}
+
+	distances, err := distanceMatrix(hashes)
+	if err != nil {
+		return statusOK, err
+	}
+
+	var groups []dupeGroup
+	for _, c := range clusterHashes(hashes, distances, threshold) {
+		if c.Size < 2 {
+			continue
+		}
+		groups = append(groups, newDupeGroup(len(groups)+1, c))
+	}
+
+	if config.OutputJSON {
+		if groups == nil {
+			groups = []dupeGroup{}
+		}
+		return batchStatus(0, skipped), printJSON(struct {
+			Threshold int         `json:"threshold"`
+			Files     int         `json:"files"`
+			Skipped   int         `json:"skipped"`
+			Groups    []dupeGroup `json:"groups"`
+		}{threshold, len(hashes), small, groups})
+	}
+
+	var reclaimable int64
+	for _, g := range groups {
+		fmt.Printf("Group %d (%d files, %s total, %s reclaimable, medoid %s)\n", g.ID, g.Files, formatSize(g.TotalSize), formatSize(g.Reclaimable), g.Medoid)
+		for _, m := range g.Members {
+			kind := "similar"
+			if m.Identical {
+				kind = "identical"
+			}
+			fmt.Printf("  %-9s %10s  %s  %s\n", kind, formatSize(m.Size), m.SHA256, m.Name)
+		}
+		reclaimable += g.Reclaimable
+	}
+
+	if !config.Quiet {
+		fmt.Fprintf(os.Stderr, "%d files compared, %d groups of near-duplicates within distance %d, %s reclaimable", len(hashes), len(groups), threshold, formatSize(reclaimable))
+		if small > 0 {
+			fmt.Fprintf(os.Stderr, "; %d files too small to compare", small)
+		}
+		fmt.Fprintln(os.Stderr)
+	}
+
+	return batchStatus(0, skipped), nil
+}
+
+func newDupeGroup(id int, c cluster) dupeGroup {
+	g := dupeGroup{ID: id, Files: c.Size, Medoid: c.Medoid}
+
+	copies := make(map[string]int)
+	for _, m := range c.Members {
+		copies[m.SHA256]++
+	}
+
+	for _, m := range c.Members {
+		g.TotalSize += m.Size
+		if m.Name != c.Medoid {
+			g.Reclaimable += m.Size
+		}
+		g.Members = append(g.Members, dupeMember{
+			Name:      m.Name,
+			TLSH:      m.TLSH,
+			SHA256:    m.SHA256,
+			Size:      m.Size,
+			Identical: copies[m.SHA256] > 1,
+		})
+	}
+
+	return g
+}
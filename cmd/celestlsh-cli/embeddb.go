@@ -0,0 +1,14 @@
+//go:build embeddb
+
+package main
+
+// The database snapshot bundled into the binary. Copy the CSV to
+// embedded/tlsh_hashes.csv before building with -tags embeddb.
+import _ "embed"
+
+//go:embed embedded/tlsh_hashes.csv
+var bundledDatabase []byte
+
+func init() {
+	embeddedDatabase = bundledDatabase
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/Magonia-Research/CelesTLSH-CLI/pkg/celestlsh"
+)
+
+// embeddedDatabase is the database snapshot bundled into the binary. It is
+// set by embeddb.go, which is only built with -tags embeddb so that normal
+// builds stay small, and is nil otherwise.
+var embeddedDatabase []byte
+
+// useEmbedded reports whether the embedded snapshot is used instead of
+// --db: always with --use-embedded, and otherwise when the binary has one
+// and --db does not exist. Stdin, as --db -, is never missing.
+func useEmbedded(config Config) bool {
+	if config.UseEmbedded {
+		return true
+	}
+	if embeddedDatabase == nil || config.DbPath == stdinDatabase {
+		return false
+	}
+	_, err := os.Stat(config.DbPath)
+	return errors.Is(err, os.ErrNotExist)
+}
+
+// loadEmbeddedDatabase loads the embedded snapshot, noting on stderr that
+// it is in use and how old it is.
+func loadEmbeddedDatabase(ctx context.Context, config Config, filters ...celestlsh.Filter) (*celestlsh.Database, error) {
+	if embeddedDatabase == nil {
+		return nil, errors.New("--use-embedded needs a database built into the binary, which this build lacks; rebuild with -tags embeddb")
+	}
+	db, err := celestlsh.LoadReader(ctx, bytes.NewReader(embeddedDatabase), filters...)
+	if err != nil {
+		return nil, fmt.Errorf("embedded database: %w", err)
+	}
+	if !config.Quiet {
+		clearProgress()
+		fmt.Fprintf(os.Stderr, "Using the embedded database snapshot: %d records, newest added %s\n", db.Len(), embeddedSnapshotDate())
+	}
+	return db, nil
+}
+
+// embeddedSnapshotDate returns the newest Date Added in the embedded
+// snapshot, as the best indication of when it was taken, or "unknown" if
+// no record has a valid date.
+func embeddedSnapshotDate() string {
+	reader := csv.NewReader(bytes.NewReader(embeddedDatabase))
+	reader.FieldsPerRecord = -1
+	var newest time.Time
+	for {
+		record, err := reader.Read()
+		if err != nil {
+			break
+		}
+		if len(record) < celestlsh.Columns {
+			continue
+		}
+		if t, err := celestlsh.ParseDate(record[6]); err == nil && t.After(newest) {
+			newest = t
+		}
+	}
+	if newest.IsZero() {
+		return "unknown"
+	}
+	return newest.Format(time.DateOnly)
+}
+
+// executeDumpEmbedded writes the embedded snapshot to config.DumpPath, or
+// to stdout for "-".
+func executeDumpEmbedded(config Config) error {
+	if embeddedDatabase == nil {
+		return errors.New("this build has no embedded database; rebuild with -tags embeddb")
+	}
+	if config.DumpPath == "-" {
+		_, err := os.Stdout.Write(embeddedDatabase)
+		return err
+	}
+	if err := os.WriteFile(config.DumpPath, embeddedDatabase, 0644); err != nil {
+		return fmt.Errorf("failed to write embedded database: %w", err)
+	}
+	if !config.Quiet {
+		fmt.Printf("Embedded database (newest record added %s) written to %s\n", embeddedSnapshotDate(), config.DumpPath)
+	}
+	return nil
+}
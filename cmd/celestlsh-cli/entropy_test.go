@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/Magonia-Research/CelesTLSH-CLI/pkg/celestlsh"
+)
+
+func TestScanMinEntropy(t *testing.T) {
+	dir := t.TempDir()
+	text := bytes.Repeat([]byte("2024-03-01 12:00:00 INFO worker 7 finished job 1234 in 56ms\n"), 100)
+	writeFile(t, dir, "packed.bin", sampleData(20, 16384))
+	writeFile(t, dir, "known.exe", testSample)
+	writeFile(t, dir, "service.log", text)
+
+	out, _, _, err := runCLI(t, "-s", "--jsonl", "--workers", "1", "--max-distance", "30", "--min-entropy", "7.2", "--db", writeTestDatabase(t), dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	results, summary := parseJSONL(t, out)
+	byPath := resultsByPath(results)
+	tests := []struct {
+		name    string
+		high    bool
+		matched bool
+	}{
+		// Flagged whether or not it matched.
+		{"packed.bin", true, false},
+		{"known.exe", true, true},
+		{"service.log", false, false},
+	}
+	for _, tt := range tests {
+		r, ok := byPath[dir+"/"+tt.name]
+		if !ok {
+			t.Fatalf("no result for %s in %v", tt.name, byPath)
+		}
+		if r.HighEntropy != tt.high || (r.Match != nil) != tt.matched || r.Digests.Entropy == 0 {
+			t.Errorf("%s: high entropy %v, match %v, entropy %v; want %v, matched %v", tt.name, r.HighEntropy, r.Match, r.Digests.Entropy, tt.high, tt.matched)
+		}
+	}
+	if summary.HighEntropy != 2 {
+		t.Errorf("summary counts %d high-entropy files, want 2", summary.HighEntropy)
+	}
+
+	out, _, _, err = runCLI(t, "-s", "--no-color", "--workers", "1", "--min-entropy", "7.2", "--max-distance", "30", "--db", writeTestDatabase(t), dir+"/packed.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out, "packed.bin: no match entropy=") || !strings.Contains(out, " [high entropy]") {
+		t.Errorf("text output:\n%s\nwant the entropy and the flag", out)
+	}
+}
+
+func TestHashEntropyCSV(t *testing.T) {
+	data := sampleData(21, 8192)
+	path := writeFile(t, t.TempDir(), "sample.bin", data)
+	out, _, _, err := runCLI(t, "-h", "--csv", "--sha256", "--entropy", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows := readCSV(t, out)
+	if len(rows) != 1 || len(rows[0]) != 4 {
+		t.Fatalf("rows = %q, want path, TLSH, SHA256 and entropy", rows)
+	}
+	if want := strconv.FormatFloat(celestlsh.Entropy(data), 'f', 3, 64); rows[0][3] != want {
+		t.Errorf("entropy column = %q, want %s", rows[0][3], want)
+	}
+}
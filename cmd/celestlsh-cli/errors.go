@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+
+	"github.com/Magonia-Research/CelesTLSH-CLI/pkg/celestlsh"
+)
+
+// Error codes reported with --json and --jsonl. They are part of the
+// output format and must not change once released.
+const (
+	codeDatabaseNotFound = "db_not_found"
+	codeMalformedCSV     = "malformed_csv"
+	codeInvalidHash      = "invalid_hash"
+	codeFileNotFound     = "file_not_found"
+	codePermission       = "permission_denied"
+	codeFileTooSmall     = "file_too_small"
+	codeFileTimeout      = "file_timeout"
+	codeDownloadFailed   = "download_failed"
+	codeAuthFailed       = "auth_failed"
+	codeBadSignature     = "invalid_signature"
+	codeInterrupted      = "interrupted"
+	codeError            = "error"
+)
+
+// errInterrupted replaces the error of a run stopped by a signal.
+var errInterrupted = errors.New("interrupted")
+
+// errorCode returns the code of err, from the identity of the errors it
+// wraps, or codeError if it has none of the known causes.
+func errorCode(err error) string {
+	switch {
+	case errors.Is(err, errInterrupted), errors.Is(err, context.Canceled):
+		return codeInterrupted
+	case errors.Is(err, celestlsh.ErrDatabaseNotFound):
+		return codeDatabaseNotFound
+	case errors.Is(err, celestlsh.ErrMalformedDatabase):
+		return codeMalformedCSV
+	case errors.Is(err, celestlsh.ErrInvalidHash):
+		return codeInvalidHash
+	case errors.Is(err, celestlsh.ErrInsufficientData):
+		return codeFileTooSmall
+	case errors.Is(err, errFileTimeout):
+		return codeFileTimeout
+	case errors.Is(err, celestlsh.ErrInvalidSignature):
+		return codeBadSignature
+	case errors.Is(err, celestlsh.ErrAuthenticationFailed):
+		return codeAuthFailed
+	case errors.Is(err, celestlsh.ErrDownloadFailed):
+		return codeDownloadFailed
+	case errors.Is(err, fs.ErrNotExist):
+		return codeFileNotFound
+	case errors.Is(err, fs.ErrPermission):
+		return codePermission
+	default:
+		return codeError
+	}
+}
+
+// jsonError is the structured form of an error, printed as
+// {"error": {...}}.
+type jsonError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Path    string `json:"path,omitempty"`
+	URL     string `json:"url,omitempty"`
+}
+
+// newJSONError describes err, with the path or URL it concerns if the
+// error records one.
+func newJSONError(err error) jsonError {
+	e := jsonError{Code: errorCode(err), Message: err.Error()}
+	var notFound *celestlsh.DatabaseNotFoundError
+	var fileErr *celestlsh.FileError
+	var pathErr *fs.PathError
+	switch {
+	case errors.As(err, &notFound):
+		e.Path = notFound.Path
+	case errors.As(err, &fileErr):
+		e.Path = fileErr.Path
+	case errors.As(err, &pathErr):
+		e.Path = pathErr.Path
+	}
+	var reqErr *celestlsh.RequestError
+	var statusErr *celestlsh.StatusError
+	switch {
+	case errors.As(err, &reqErr):
+		e.URL = reqErr.URL
+	case errors.As(err, &statusErr):
+		e.URL = statusErr.URL
+	}
+	return e
+}
+
+// jsonErrors reports whether errors are printed as JSON objects rather
+// than text, which is the case with --json and --jsonl.
+func jsonErrors(config Config) bool {
+	return config.OutputJSON || config.OutputJSONL
+}
+
+// printError prints err on stderr, as a JSON object with --json and
+// --jsonl and as an "Error:" line otherwise.
+func printError(config Config, err error) {
+	if jsonErrors(config) {
+		writeJSONError(newJSONError(err))
+		return
+	}
+	fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+}
+
+// printResultError prints the error of a file that could not be checked on
+// stderr, like printError.
+func printResultError(config Config, result scanResult) {
+	if jsonErrors(config) {
+		code := result.ErrorCode
+		if code == "" {
+			code = codeError
+		}
+		writeJSONError(jsonError{Code: code, Message: result.Error, Path: result.Path})
+		return
+	}
+	fmt.Fprintf(os.Stderr, "Error: %s: %s\n", displayPath(result.Path), result.Error)
+}
+
+func writeJSONError(e jsonError) {
+	line, err := json.Marshal(struct {
+		Error jsonError `json:"error"`
+	}{e})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", e.Message)
+		return
+	}
+	fmt.Fprintln(os.Stderr, string(line))
+}
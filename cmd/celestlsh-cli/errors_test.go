@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Magonia-Research/CelesTLSH-CLI/pkg/celestlsh"
+)
+
+func TestErrorCode(t *testing.T) {
+	missing := filepath.Join(t.TempDir(), "missing.csv")
+	_, dbErr := celestlsh.Load(context.Background(), missing)
+	_, csvErr := celestlsh.LoadReader(context.Background(), strings.NewReader("repo_name,file_name\n\"unterminated\n"))
+	var h celestlsh.Hasher
+	_, smallErr := h.HashBytes([]byte("tiny"))
+	_, fileErr := h.HashFile(context.Background(), missing)
+
+	tests := []struct {
+		name string
+		err  error
+		code string
+		path string
+		url  string
+	}{
+		{"missing database", dbErr, codeDatabaseNotFound, missing, ""},
+		{"malformed CSV", csvErr, codeMalformedCSV, "", ""},
+		{"invalid hash", celestlsh.ValidateHash("bogus"), codeInvalidHash, "", ""},
+		{"too small", smallErr, codeFileTooSmall, "", ""},
+		{"missing file", fileErr, codeFileNotFound, missing, ""},
+		{"server error", fmt.Errorf("downloading: %w", &celestlsh.StatusError{URL: "https://example.com/db.csv", StatusCode: 500}), codeDownloadFailed, "", "https://example.com/db.csv"},
+		{"unauthorized", &celestlsh.StatusError{URL: "https://example.com/db.csv", StatusCode: 401}, codeAuthFailed, "", "https://example.com/db.csv"},
+		{"interrupted", fmt.Errorf("scan: %w", context.Canceled), codeInterrupted, "", ""},
+		{"other", errors.New("something else"), codeError, "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.err == nil {
+				t.Fatal("no error to map")
+			}
+			e := newJSONError(tt.err)
+			if e.Code != tt.code || e.Path != tt.path || e.URL != tt.url || e.Message != tt.err.Error() {
+				t.Errorf("newJSONError(%v) = %+v, want code %s, path %q, url %q", tt.err, e, tt.code, tt.path, tt.url)
+			}
+		})
+	}
+}
+
+func TestJSONErrorOutput(t *testing.T) {
+	missing := filepath.Join(t.TempDir(), "missing.csv")
+	code, stderr := runMain(t, "--json", "-c", "--db", missing, testRecords(t)[0].TLSHHash)
+	if code != exitError {
+		t.Errorf("exit code %d, want %d", code, exitError)
+	}
+	var got struct {
+		Error jsonError `json:"error"`
+	}
+	if err := json.Unmarshal([]byte(strings.TrimSpace(stderr)), &got); err != nil {
+		t.Fatalf("stderr %q is not one JSON error: %v", stderr, err)
+	}
+	if got.Error.Code != codeDatabaseNotFound || got.Error.Path != missing || got.Error.Message == "" {
+		t.Errorf("error = %+v, want db_not_found for %s", got.Error, missing)
+	}
+
+	// Without --json, the error stays text.
+	_, stderr = runMain(t, "-c", "--db", missing, testRecords(t)[0].TLSHHash)
+	if !strings.HasPrefix(stderr, "Error: ") {
+		t.Errorf("stderr %q, want an Error: line", stderr)
+	}
+}
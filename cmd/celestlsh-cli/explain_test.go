@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/Magonia-Research/CelesTLSH-CLI/pkg/celestlsh"
+)
+
+func TestDistanceExplain(t *testing.T) {
+	records := testRecords(t)
+	hash1, hash2 := records[0].TLSHHash, records[1].TLSHHash
+	parts, err := celestlsh.ExplainDistance(hash1, hash2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out, _, _, err := runCLI(t, "-d", "--explain", hash1, hash2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := fmt.Sprintf("Distance between hashes: %d\nHeader %d (length %d, quartile ratios %d + %d, checksum %d) + body %d = %d\n",
+		parts.Total, parts.Header, parts.Length, parts.Q1Ratio, parts.Q2Ratio, parts.Checksum, parts.Body, parts.Total)
+	if out != want {
+		t.Errorf("output:\n%s\nwant:\n%s", out, want)
+	}
+
+	out, _, _, err = runCLI(t, "-d", "--explain", "--json", hash1, hash2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got struct {
+		Distance int
+		Explain  *celestlsh.DistanceParts
+	}
+	if err := json.Unmarshal([]byte(out), &got); err != nil {
+		t.Fatalf("%v in output:\n%s", err, out)
+	}
+	if got.Explain == nil || *got.Explain != parts || got.Distance != parts.Total {
+		t.Errorf("JSON output:\n%s\nwant explain %+v", out, parts)
+	}
+
+	// Without --explain, the JSON has no explain member.
+	out, _, _, err = runCLI(t, "-d", "--json", hash1, hash2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(out, "explain") {
+		t.Errorf("JSON output without --explain:\n%s", out)
+	}
+}
+
+func TestCheckExplain(t *testing.T) {
+	query := testHash(t, variantData(testSample, 60))
+	out, _, st, err := runCLI(t, "-c", "--explain", "--json", "--db", writeTestDatabase(t), query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var results []checkResult
+	if err := json.Unmarshal([]byte(out), &results); err != nil {
+		t.Fatalf("%v in output:\n%s", err, out)
+	}
+	if st != statusMatch || len(results) != 1 || results[0].Match == nil || results[0].Explain == nil {
+		t.Fatalf("status %v, output:\n%s\nwant a match with its distance explained", st, out)
+	}
+	r := results[0]
+	if r.Explain.Total != r.Match.Distance || r.Explain.Header+r.Explain.Body != r.Explain.Total {
+		t.Errorf("explain %+v for a match at distance %d", *r.Explain, r.Match.Distance)
+	}
+
+	out, _, _, err = runCLI(t, "-c", "--explain", "--db", writeTestDatabase(t), query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out, fmt.Sprintf("+ body %d = %d", r.Explain.Body, r.Explain.Total)) {
+		t.Errorf("output:\n%s\nwant the distance explained", out)
+	}
+}
+
+func TestExplainFlags(t *testing.T) {
+	_, stderr := runMain(t, "-s", "--explain", t.TempDir())
+	if !strings.Contains(stderr, "--explain only applies to distance and check modes") {
+		t.Errorf("stderr = %q", stderr)
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// parseExtensions parses a comma-separated --skip-ext or --only-ext list
+// into a set of lowercase extensions with their leading dot, accepting
+// them with or without it.
+func parseExtensions(s string) (map[string]bool, error) {
+	if s == "" {
+		return nil, nil
+	}
+	exts := make(map[string]bool)
+	for _, ext := range strings.Split(s, ",") {
+		ext = strings.ToLower(strings.TrimSpace(ext))
+		ext = strings.TrimPrefix(ext, ".")
+		if ext == "" {
+			return nil, fmt.Errorf("empty extension in %q", s)
+		}
+		if strings.ContainsAny(ext, `./\`) {
+			return nil, fmt.Errorf("invalid extension %q: only the final extension is matched, such as iso for disk.img.iso", ext)
+		}
+		exts["."+ext] = true
+	}
+	return exts, nil
+}
+
+// wantedExt reports whether the file at path passes --skip-ext and
+// --only-ext, counting and noting it if not. Only the final extension of
+// the name is compared, ignoring case, so that archive.tar.gz is a .gz
+// file; names without one, including dotfiles such as .bashrc, only pass
+// when --only-ext is not given. It needs nothing but the name, so skipped
+// files are never opened or stat'ed.
+func (s *scanner) wantedExt(path string) bool {
+	if s.config.SkipExt == nil && s.config.OnlyExt == nil {
+		return true
+	}
+	ext := fileExt(filepath.Base(path))
+	var reason string
+	switch {
+	case s.config.SkipExt[ext]:
+		reason = "extension " + ext + " skipped with --skip-ext"
+	case s.config.OnlyExt != nil && !s.config.OnlyExt[ext]:
+		if ext == "" {
+			reason = "no extension selected with --only-ext"
+		} else {
+			reason = "extension " + ext + " not selected with --only-ext"
+		}
+	default:
+		return true
+	}
+	s.stats.skippedExt.Add(1)
+	s.note(path, reason)
+	return false
+}
+
+// fileExt returns the final extension of name in lowercase, or "" if it
+// has none. The name of a dotfile is not an extension.
+func fileExt(name string) string {
+	ext := filepath.Ext(name)
+	if ext == name || ext == "." {
+		return ""
+	}
+	return strings.ToLower(ext)
+}
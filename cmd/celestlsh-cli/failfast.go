@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+// failFast stops a scan at its first match, for --fail-fast. The match is
+// output as usual, then the walk is cancelled, files not yet started are
+// skipped and files being read are cut short, and every result that
+// arrives afterwards is dropped so that the match is the only one
+// reported.
+type failFast struct {
+	stopWalk context.CancelFunc
+	stopWork context.CancelFunc
+	tripped  atomic.Bool
+}
+
+// newFailFast returns the --fail-fast state of a scan that walks with
+// walk and reads files with work, and the contexts to use in their place,
+// or nil and the contexts unchanged if --fail-fast is not set.
+func newFailFast(config Config, walk, work context.Context) (*failFast, context.Context, context.Context) {
+	if !config.FailFast {
+		return nil, walk, work
+	}
+	f := &failFast{}
+	walk, f.stopWalk = context.WithCancel(walk)
+	work, f.stopWork = context.WithCancel(work)
+	return f, walk, work
+}
+
+// done reports whether the scan has stopped at a match.
+func (f *failFast) done() bool {
+	return f != nil && f.tripped.Load()
+}
+
+// trip stops the scan after result was output, if it is a match.
+func (f *failFast) trip(result scanResult) {
+	if f == nil || result.Error != "" || result.Match == nil || result.Suppressed {
+		return
+	}
+	if f.tripped.CompareAndSwap(false, true) {
+		f.stopWalk()
+		f.stopWork()
+	}
+}
+
+// release frees the contexts once the scan is over.
+func (f *failFast) release() {
+	if f != nil {
+		f.stopWalk()
+		f.stopWork()
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// failFastTree writes 60 files that match nothing under dir, in
+// directories walked in name order, with matching copies of known.exe at
+// the positions given.
+func failFastTree(t *testing.T, matches ...string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for i := range 60 {
+		writeFile(t, dir, fmt.Sprintf("m%d/clean%02d.bin", i/20, i), sampleData(uint64(200+i), 8192))
+	}
+	for _, name := range matches {
+		writeFile(t, dir, name, testSample)
+	}
+	return dir
+}
+
+func TestScanFailFast(t *testing.T) {
+	db := writeTestDatabase(t)
+
+	tests := []struct {
+		name    string
+		matches []string
+		workers string
+	}{
+		{"early", []string{"a/known.exe", "b/copy.exe"}, "1"},
+		{"early, several workers", []string{"a/known.exe", "b/copy.exe"}, "4"},
+		{"late", []string{"z/known.exe"}, "1"},
+		{"late, several workers", []string{"z/known.exe"}, "4"},
+		{"middle", []string{"m1/known.exe", "m2/copy.exe"}, "2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := failFastTree(t, tt.matches...)
+			before := runtime.NumGoroutine()
+
+			out, _, st, err := runCLI(t, "-s", "--jsonl", "--fail-fast", "--max-distance", "30", "--workers", tt.workers, "--db", db, dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			results, summary := parseJSONL(t, out)
+			var matched []string
+			for _, r := range results {
+				if r.Match != nil {
+					matched = append(matched, r.Path)
+				}
+			}
+			if st != statusMatch || len(matched) != 1 || summary.Matched != 1 {
+				t.Errorf("status %v, matches %v, summary %d matched; want a single match", st, matched, summary.Matched)
+			}
+			if !summary.StoppedEarly {
+				t.Errorf("summary %+v does not say the scan stopped early", summary)
+			}
+			// Once the match is reported, nothing else is.
+			if last := results[len(results)-1]; last.Match == nil {
+				t.Errorf("result after the match: %+v", last)
+			}
+			if strings.HasPrefix(tt.name, "early") && summary.Files > 10 {
+				t.Errorf("%d files scanned after a match among the first", summary.Files)
+			}
+
+			// The workers and the walk have all stopped.
+			deadline := time.Now().Add(5 * time.Second)
+			for runtime.NumGoroutine() > before {
+				if time.Now().After(deadline) {
+					buf := make([]byte, 1<<20)
+					t.Fatalf("%d goroutines left running, %d before the scan:\n%s", runtime.NumGoroutine(), before, buf[:runtime.Stack(buf, true)])
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+		})
+	}
+}
+
+func TestScanFailFastNoMatch(t *testing.T) {
+	dir := failFastTree(t)
+	out, _, st, err := runCLI(t, "-s", "--jsonl", "--fail-fast", "--max-distance", "30", "--db", writeTestDatabase(t), dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	results, summary := parseJSONL(t, out)
+	if st != statusOK || len(results) != 60 || summary.StoppedEarly || summary.Files != 60 {
+		t.Errorf("status %v, %d results, summary %+v; want all 60 files scanned", st, len(results), summary)
+	}
+}
+
+func TestScanFailFastSummary(t *testing.T) {
+	dir := failFastTree(t, "a/known.exe")
+	_, stderr, _, err := runCLI(t, "-s", "--fail-fast", "--max-distance", "30", "--workers", "1", "--db", writeTestDatabase(t), dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(stderr, "Scan stopped at the first match") {
+		t.Errorf("stderr = %q, want the scan noted as stopped at the first match", stderr)
+	}
+}
+
+func TestFailFastFlags(t *testing.T) {
+	_, stderr := runMain(t, "-c", "--fail-fast", testRecords(t)[0].TLSHHash)
+	if !strings.Contains(stderr, "--fail-fast only applies to scan mode") {
+		t.Errorf("stderr = %q", stderr)
+	}
+}
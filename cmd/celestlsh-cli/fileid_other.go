@@ -0,0 +1,11 @@
+//go:build windows || plan9
+
+package main
+
+import "io/fs"
+
+// statFileID reports that file identities are not available, so callers
+// fall back to comparing resolved paths.
+func statFileID(info fs.FileInfo) (fileID, bool) {
+	return fileID{}, false
+}
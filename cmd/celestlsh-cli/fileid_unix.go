@@ -0,0 +1,17 @@
+//go:build !windows && !plan9
+
+package main
+
+import (
+	"io/fs"
+	"syscall"
+)
+
+// statFileID returns the device and inode of the file described by info.
+func statFileID(info fs.FileInfo) (fileID, bool) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return fileID{}, false
+	}
+	return fileID{dev: uint64(st.Dev), ino: uint64(st.Ino)}, true
+}
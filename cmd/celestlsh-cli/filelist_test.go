@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReadFileList(t *testing.T) {
+	list := writeFile(t, t.TempDir(), "list.txt", []byte("# suspicious\n/a/b\n\n  /c d/e  \r\n#/skipped\n/f\n"))
+	got, err := readFileList(list, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"/a/b", "/c d/e", "/f"}; strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("readFileList = %q, want %q", got, want)
+	}
+}
+
+func TestScanFileList(t *testing.T) {
+	dir := t.TempDir()
+	var list strings.Builder
+	list.WriteString("# triage output\n\n")
+	for i := range 3000 {
+		path := writeFile(t, dir, fmt.Sprintf("d%d/f%d.bin", i%10, i), sampleData(uint64(i), 512))
+		fmt.Fprintln(&list, path)
+	}
+	missing := filepath.Join(dir, "gone.bin")
+	fmt.Fprintln(&list, missing)
+	// A listed directory is walked, with the filters.
+	logs := t.TempDir()
+	writeFile(t, logs, "app.log", sampleData(1, 512))
+	writeFile(t, logs, "app.bin", sampleData(2, 512))
+	fmt.Fprintln(&list, logs)
+	// A file both listed and given is scanned once; one only given is
+	// scanned too.
+	extra := writeFile(t, t.TempDir(), "extra/known.exe", testSample)
+	listPath := writeFile(t, t.TempDir(), "list.txt", []byte(list.String()))
+
+	out, _, _, err := runCLI(t, "-s", "--jsonl", "--workers", "8", "--exclude", "*.log", "--filelist", listPath, "--db", writeTestDatabase(t),
+		filepath.Join(dir, "d0", "f0.bin"), filepath.Dir(extra))
+	if err != nil {
+		t.Fatal(err)
+	}
+	results, summary := parseJSONL(t, out)
+	byPath := resultsByPath(results)
+	if len(results) != 3003 || len(byPath) != 3003 {
+		t.Errorf("%d results for %d paths, want 3003: the 3000 listed files, the missing one, app.bin and known.exe", len(results), len(byPath))
+	}
+	if _, ok := byPath[filepath.Join(logs, "app.bin")]; !ok || summary.Filtered != 1 {
+		t.Errorf("app.bin scanned %v, %d filtered; want app.bin scanned and app.log filtered", ok, summary.Filtered)
+	}
+	if r := byPath[missing]; !strings.Contains(r.Error, "no such file") || r.ErrorCode != codeFileNotFound {
+		t.Errorf("missing file: result %+v, want a file_not_found error", r)
+	}
+	if r := byPath[extra]; r.Match == nil || r.Match.FileName != "known.exe" {
+		t.Errorf("%s: result %+v, want the given directory scanned", extra, r)
+	}
+	if summary.Failed != 1 {
+		t.Errorf("%d failed, want the missing file alone", summary.Failed)
+	}
+}
+
+func TestHashFileListStdin(t *testing.T) {
+	dir := t.TempDir()
+	a := writeFile(t, dir, "a.bin", sampleData(1, 512))
+	b := writeFile(t, dir, "b.bin", sampleData(2, 512))
+	stdin := writeFile(t, t.TempDir(), "stdin", []byte(a+"\n# b is given\n"+b+"\n"))
+	f, err := os.Open(stdin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	old := os.Stdin
+	os.Stdin = f
+	defer func() { os.Stdin = old }()
+
+	out, _, _, err := runCLI(t, "-h", "--csv", "--filelist", "-", b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows := readCSV(t, out)
+	if len(rows) != 2 || rows[0][0] != b || rows[1][0] != a {
+		t.Errorf("rows = %q, want the given file then the listed one, once each", rows)
+	}
+}
+
+func TestReadFileListNUL(t *testing.T) {
+	list := writeFile(t, t.TempDir(), "list", []byte("new\nline\x00 spaced # kept \x00\x00last"))
+	got, err := readFileList(list, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"new\nline", " spaced # kept ", "last"}; strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("readFileList = %q, want %q", got, want)
+	}
+}
+
+func TestDisplayPath(t *testing.T) {
+	tests := []struct{ path, want string }{
+		{"/srv/bin/tool", "/srv/bin/tool"},
+		{"/srv/with space/été", "/srv/with space/été"},
+		{"/srv/new\nline", `"/srv/new\nline"`},
+		{"/srv/tab\there", `"/srv/tab\there"`},
+		{"/srv/esc\x1b[31m", `"/srv/esc\x1b[31m"`},
+		{"/srv/bad\xffutf8", `"/srv/bad\xffutf8"`},
+	}
+	for _, tt := range tests {
+		if got := displayPath(tt.path); got != tt.want {
+			t.Errorf("displayPath(%q) = %s, want %s", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestScanFileListNUL(t *testing.T) {
+	dir := t.TempDir()
+	var paths []string
+	for _, name := range []string{"new\nline.exe", "tab\tname.exe", "plain.exe"} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, testSample, 0644); err != nil {
+			t.Skipf("file names with control characters unavailable: %v", err)
+		}
+		paths = append(paths, path)
+	}
+	list := writeFile(t, t.TempDir(), "list", []byte(strings.Join(paths, "\x00")+"\x00"))
+	db := writeTestDatabase(t)
+
+	out, _, _, err := runCLI(t, "-s", "--jsonl", "--max-distance", "30", "--filelist0", list, "--db", db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	results, _ := parseJSONL(t, out)
+	byPath := resultsByPath(results)
+	for _, path := range paths {
+		if r, ok := byPath[path]; !ok || r.Match == nil {
+			t.Errorf("%q: result %+v, want a match", path, r)
+		}
+	}
+
+	// Plain output quotes the paths with control characters, so that each
+	// result stays on one line.
+	out, _, _, err = runCLI(t, "-s", "--max-distance", "30", "--filelist0", list, "--db", db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{`"` + dir + `/new\nline.exe"`, `"` + dir + `/tab\tname.exe"`, dir + "/plain.exe"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output lacks %s:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "new\nline") || strings.Contains(out, "\t") {
+		t.Errorf("output holds raw control characters:\n%q", out)
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// defaultFileTimeout is the --file-timeout of scan and watch modes.
+const defaultFileTimeout = 5 * time.Minute
+
+// errFileTimeout is the cause of a file context that ran out of time.
+var errFileTimeout = errors.New("file timed out")
+
+// scanSource is an open file being scanned.
+type scanSource interface {
+	io.Reader
+	io.ReaderAt
+	io.Seeker
+	Stat() (os.FileInfo, error)
+}
+
+// openWithin opens path for a worker that must not wait on it once ctx is
+// done, as an open on a hung network mount can block indefinitely. An open
+// that completes after the worker has given up is closed straight away.
+func openWithin(ctx context.Context, path string) (*os.File, error) {
+	type opened struct {
+		f   *os.File
+		err error
+	}
+	done := make(chan opened, 1)
+	go func() {
+		f, err := os.Open(path)
+		done <- opened{f, err}
+	}()
+
+	select {
+	case o := <-done:
+		return o.f, o.err
+	case <-ctx.Done():
+		go func() {
+			if o := <-done; o.f != nil {
+				o.f.Close()
+			}
+		}()
+		return nil, context.Cause(ctx)
+	}
+}
+
+// deadlineFile reads f for a worker that must not wait on it once ctx is
+// done. Each read runs in a goroutine of its own, into a buffer of the
+// deadlineFile's, and when ctx is done first the read is abandoned and it
+// and every later one fail with the cause of ctx. The worker is expected
+// to close f when ctx is done: that unblocks a stuck read where the
+// platform allows, and otherwise takes effect once the read returns, so
+// the descriptor is not leaked either way.
+type deadlineFile struct {
+	ctx context.Context
+	f   *os.File
+
+	// mu serialises reads, which share buf; ReadAt may be called
+	// concurrently.
+	mu        sync.Mutex
+	buf       []byte
+	abandoned bool
+}
+
+func (d *deadlineFile) Read(p []byte) (int, error) {
+	return d.read(p, d.f.Read)
+}
+
+func (d *deadlineFile) ReadAt(p []byte, off int64) (int, error) {
+	return d.read(p, func(b []byte) (int, error) { return d.f.ReadAt(b, off) })
+}
+
+func (d *deadlineFile) Seek(offset int64, whence int) (int64, error) {
+	return d.f.Seek(offset, whence)
+}
+
+func (d *deadlineFile) Stat() (os.FileInfo, error) {
+	return d.f.Stat()
+}
+
+func (d *deadlineFile) read(p []byte, read func([]byte) (int, error)) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.abandoned {
+		return 0, context.Cause(d.ctx)
+	}
+	if cap(d.buf) < len(p) {
+		d.buf = make([]byte, len(p))
+	}
+	buf := d.buf[:len(p)]
+
+	type result struct {
+		n   int
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		n, err := read(buf)
+		done <- result{n, err}
+	}()
+
+	select {
+	case r := <-done:
+		copy(p, buf[:r.n])
+		return r.n, r.err
+	case <-d.ctx.Done():
+		// The goroutine may still write to buf, which is never used again.
+		d.abandoned = true
+		return 0, context.Cause(d.ctx)
+	}
+}
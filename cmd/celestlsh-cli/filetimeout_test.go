@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDeadlineFile(t *testing.T) {
+	pr, pw, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pw.Close()
+
+	ctx, cancel := context.WithTimeoutCause(context.Background(), 200*time.Millisecond, errFileTimeout)
+	defer cancel()
+	// As scanFile does, the file is closed when time runs out.
+	defer context.AfterFunc(ctx, func() { pr.Close() })()
+	d := &deadlineFile{ctx: ctx, f: pr}
+
+	// Reads that complete in time pass the data through.
+	if _, err := pw.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 16)
+	n, err := d.Read(buf)
+	if err != nil || string(buf[:n]) != "hello" {
+		t.Fatalf("Read = %q, %v; want hello", buf[:n], err)
+	}
+
+	// With nothing written, the next read blocks until time runs out.
+	start := time.Now()
+	n, err = d.Read(buf)
+	if n != 0 || !errors.Is(err, errFileTimeout) {
+		t.Errorf("blocked Read = %d, %v; want errFileTimeout", n, err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("blocked Read returned after %v", elapsed)
+	}
+
+	// Data arriving later is not read: the file is abandoned.
+	pw.Write([]byte("late"))
+	if n, err := d.Read(buf); n != 0 || !errors.Is(err, errFileTimeout) {
+		t.Errorf("Read after the timeout = %d, %v; want errFileTimeout", n, err)
+	}
+}
+
+func TestDeadlineFileRegular(t *testing.T) {
+	data := sampleData(5, 100000)
+	f, err := os.Open(writeFile(t, t.TempDir(), "data.bin", data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	d := &deadlineFile{ctx: context.Background(), f: f}
+
+	got, err := io.ReadAll(d)
+	if err != nil || string(got) != string(data) {
+		t.Fatalf("ReadAll = %d bytes, %v; want the %d bytes written", len(got), err, len(data))
+	}
+	buf := make([]byte, 10)
+	if _, err := d.ReadAt(buf, 500); err != nil || string(buf) != string(data[500:510]) {
+		t.Errorf("ReadAt = %x, %v; want %x", buf, err, data[500:510])
+	}
+	if _, err := d.Seek(0, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadFull(d, buf); err != nil || string(buf) != string(data[:10]) {
+		t.Errorf("Read after Seek = %x, %v; want %x", buf, err, data[:10])
+	}
+}
+
+func TestSummaryTimedOut(t *testing.T) {
+	s := jsonlSummary{Files: 5, Failed: 2, TimedOut: 1}
+	if text := s.text(); !strings.Contains(text, "5 files scanned, 0 matched, 2 failed (1 timed out), 0 pending") {
+		t.Errorf("summary %q, want the timed out files counted", text)
+	}
+	s.TimedOut = 0
+	if text := s.text(); strings.Contains(text, "timed out") {
+		t.Errorf("summary %q, want no timed out count", text)
+	}
+}
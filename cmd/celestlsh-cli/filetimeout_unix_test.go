@@ -0,0 +1,71 @@
+//go:build !windows && !plan9
+
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// openFiles returns the number of descriptors the process has open, or -1
+// where /proc does not list them.
+func openFiles() int {
+	entries, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		return -1
+	}
+	return len(entries)
+}
+
+func TestOpenWithinFIFO(t *testing.T) {
+	// Opening a FIFO for reading blocks until a writer opens it, as an
+	// open on a hung mount would.
+	path := filepath.Join(t.TempDir(), "fifo")
+	if err := syscall.Mkfifo(path, 0o600); err != nil {
+		t.Skipf("mkfifo: %v", err)
+	}
+	before := openFiles()
+
+	ctx, cancel := context.WithTimeoutCause(context.Background(), 100*time.Millisecond, errFileTimeout)
+	defer cancel()
+	f, err := openWithin(ctx, path)
+	if f != nil || !errors.Is(err, errFileTimeout) {
+		t.Fatalf("openWithin = %v, %v; want errFileTimeout", f, err)
+	}
+
+	// The open completes once a writer arrives, and the file it returns
+	// late is closed rather than leaked.
+	w, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+	if before < 0 {
+		return
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for openFiles() != before {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d descriptors open, want %d as before", openFiles(), before)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestOpenWithin(t *testing.T) {
+	path := writeFile(t, t.TempDir(), "data.bin", []byte("data"))
+	f, err := openWithin(context.Background(), path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	if _, err := openWithin(context.Background(), path+".missing"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("openWithin of a missing file = %v, want os.ErrNotExist", err)
+	}
+}
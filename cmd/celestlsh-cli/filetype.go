@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+// fileType is a kind of file recognised from its first bytes, selected
+// with --types. Files of none of the types, such as logs and images, have
+// type 0.
+type fileType uint8
+
+const (
+	typePE fileType = 1 << iota
+	typeELF
+	typeMachO
+	typeScript
+)
+
+var fileTypeNames = []struct {
+	name string
+	t    fileType
+}{
+	{"pe", typePE},
+	{"elf", typeELF},
+	{"macho", typeMachO},
+	{"script", typeScript},
+}
+
+// parseFileTypes parses a comma-separated --types list. The result is 0,
+// selecting every file, when the list includes all.
+func parseFileTypes(s string) (fileType, error) {
+	var types fileType
+	for _, name := range strings.Split(s, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "all" {
+			return 0, nil
+		}
+		var found bool
+		for _, n := range fileTypeNames {
+			if n.name == name {
+				types |= n.t
+				found = true
+			}
+		}
+		if !found {
+			return 0, fmt.Errorf("unknown type %q (want pe, elf, macho, script or all)", name)
+		}
+	}
+	return types, nil
+}
+
+func (t fileType) String() string {
+	var names []string
+	for _, n := range fileTypeNames {
+		if t&n.t != 0 {
+			names = append(names, n.name)
+		}
+	}
+	if len(names) == 0 {
+		return "other"
+	}
+	return strings.Join(names, ",")
+}
+
+var (
+	peMagic     = []byte("MZ")
+	elfMagic    = []byte("\x7fELF")
+	scriptMagic = []byte("#!")
+
+	// machOMagics are the 32 and 64-bit Mach-O magics in either byte
+	// order.
+	machOMagics = [][]byte{
+		{0xfe, 0xed, 0xfa, 0xce}, {0xce, 0xfa, 0xed, 0xfe},
+		{0xfe, 0xed, 0xfa, 0xcf}, {0xcf, 0xfa, 0xed, 0xfe},
+	}
+	// Universal binaries start with 0xcafebabe, or 0xcafebabf for 64-bit
+	// offsets, like Java class files.
+	fatMagic   = []byte{0xca, 0xfe, 0xba, 0xbe}
+	fat64Magic = []byte{0xca, 0xfe, 0xba, 0xbf}
+)
+
+// maxFatArches is the most architectures a universal binary is taken to
+// hold. A Java class file has its version where a universal binary has its
+// architecture count, and class file versions start at 45.
+const maxFatArches = 44
+
+// detectFileType recognises the type of a file from head, its first bytes.
+func detectFileType(head []byte) fileType {
+	switch {
+	case bytes.HasPrefix(head, peMagic):
+		return typePE
+	case bytes.HasPrefix(head, elfMagic):
+		return typeELF
+	case bytes.HasPrefix(head, scriptMagic):
+		return typeScript
+	case bytes.HasPrefix(head, fatMagic) || bytes.HasPrefix(head, fat64Magic):
+		if len(head) >= 8 {
+			if n := binary.BigEndian.Uint32(head[4:8]); n > 0 && n <= maxFatArches {
+				return typeMachO
+			}
+		}
+		return 0
+	}
+	for _, magic := range machOMagics {
+		if bytes.HasPrefix(head, magic) {
+			return typeMachO
+		}
+	}
+	return 0
+}
+
+// wantedType reports whether a file starting with head is of a type
+// selected with --types, counting and noting it if not.
+func (s *scanner) wantedType(path string, head []byte) bool {
+	if s.config.Types == 0 {
+		return true
+	}
+	t := detectFileType(head)
+	if t&s.config.Types != 0 {
+		return true
+	}
+	if s.stats != nil {
+		s.stats.skippedType.Add(1)
+	}
+	s.note(path, "type "+t.String()+" not selected with --types")
+	return false
+}
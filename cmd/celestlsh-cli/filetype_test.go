@@ -0,0 +1,117 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDetectFileType(t *testing.T) {
+	tests := []struct {
+		name string
+		head string
+		want fileType
+	}{
+		{"PE", "MZ\x90\x00\x03\x00", typePE},
+		{"ELF", "\x7fELF\x02\x01\x01", typeELF},
+		{"Mach-O 32-bit", "\xfe\xed\xfa\xce\x00", typeMachO},
+		{"Mach-O 32-bit little-endian", "\xce\xfa\xed\xfe\x07", typeMachO},
+		{"Mach-O 64-bit", "\xfe\xed\xfa\xcf\x01", typeMachO},
+		{"Mach-O 64-bit little-endian", "\xcf\xfa\xed\xfe\x07", typeMachO},
+		{"universal binary", "\xca\xfe\xba\xbe\x00\x00\x00\x02", typeMachO},
+		{"universal binary, 64-bit offsets", "\xca\xfe\xba\xbf\x00\x00\x00\x02", typeMachO},
+		{"Java class file", "\xca\xfe\xba\xbe\x00\x00\x00\x34", 0},
+		{"universal binary cut short", "\xca\xfe\xba\xbe\x00\x00", 0},
+		{"shell script", "#!/bin/sh\necho hi\n", typeScript},
+		{"env script", "#!/usr/bin/env python3\n", typeScript},
+		{"log", "2024-03-01 12:00:00 INFO started\n", 0},
+		{"PNG", "\x89PNG\r\n\x1a\n", 0},
+		{"ELF cut short", "\x7fEL", 0},
+		{"single M", "M", 0},
+		{"empty", "", 0},
+	}
+	for _, tt := range tests {
+		if got := detectFileType([]byte(tt.head)); got != tt.want {
+			t.Errorf("%s: detectFileType = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestParseFileTypes(t *testing.T) {
+	tests := []struct {
+		in   string
+		want fileType
+		err  string
+	}{
+		{"pe", typePE, ""},
+		{"PE, elf", typePE | typeELF, ""},
+		{"macho,script,pe", typeMachO | typeScript | typePE, ""},
+		{"pe,all", 0, ""},
+		{"all", 0, ""},
+		{"pe,exe", 0, `unknown type "exe" (want pe, elf, macho, script or all)`},
+		{"", 0, `unknown type ""`},
+	}
+	for _, tt := range tests {
+		got, err := parseFileTypes(tt.in)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("parseFileTypes(%q) = %v, %v; want error %q", tt.in, got, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("parseFileTypes(%q) = %v, %v; want %v", tt.in, got, err, tt.want)
+		}
+	}
+	if s := (typePE | typeMachO).String(); s != "pe,macho" {
+		t.Errorf("String = %q, want pe,macho", s)
+	}
+}
+
+func TestScanTypes(t *testing.T) {
+	dir := t.TempDir()
+	typed := func(magic string, seed uint64) []byte {
+		return append([]byte(magic), sampleData(seed, 4096)...)
+	}
+	writeFile(t, dir, "tool.exe", typed("MZ", 10))
+	writeFile(t, dir, "tool", typed("\x7fELF", 11))
+	writeFile(t, dir, "tool.dylib", typed("\xcf\xfa\xed\xfe", 12))
+	writeFile(t, dir, "run.sh", typed("#!/bin/sh\n", 13))
+	writeFile(t, dir, "notes.log", typed("2024-03-01 ", 14))
+	writeFile(t, dir, "photo.png", typed("\x89PNG\r\n\x1a\n", 15))
+	// Archive members are sniffed too; the archive itself is opened.
+	writeFile(t, dir, "bundle.tar", makeTar(t,
+		archiveEntry{name: "bin/payload.exe", data: typed("MZ", 16)},
+		archiveEntry{name: "README.txt", data: typed("Read me ", 17)},
+	))
+	db := writeTestDatabase(t)
+
+	tests := []struct {
+		types   string
+		paths   []string
+		skipped int64
+	}{
+		{"all", []string{"bundle.tar!bin/payload.exe", "bundle.tar!README.txt", "notes.log", "photo.png", "run.sh", "tool", "tool.dylib", "tool.exe"}, 0},
+		{"pe", []string{"bundle.tar!bin/payload.exe", "tool.exe"}, 6},
+		{"elf,macho", []string{"tool", "tool.dylib"}, 6},
+		{"script", []string{"run.sh"}, 7},
+	}
+	for _, tt := range tests {
+		t.Run(tt.types, func(t *testing.T) {
+			out, _, _, err := runCLI(t, "-s", "--jsonl", "--workers", "1", "--types", tt.types, "--db", db, dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			results, summary := parseJSONL(t, out)
+			var paths []string
+			for _, r := range results {
+				paths = append(paths, strings.TrimPrefix(strings.TrimPrefix(r.Path, dir), "/"))
+			}
+			if strings.Join(paths, " ") != strings.Join(tt.paths, " ") {
+				t.Errorf("scanned %q, want %q", paths, tt.paths)
+			}
+			if summary.SkippedType != tt.skipped {
+				t.Errorf("%d skipped by type, want %d", summary.SkippedType, tt.skipped)
+			}
+		})
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// pathFilter decides which files found while walking a directory are
+// scanned, from the --include and --exclude patterns. Excludes are checked
+// first and win: an excluded directory is not entered at all, and an
+// excluded file is skipped even if it is also included. When includes are
+// given, only files matching one of them are scanned.
+type pathFilter struct {
+	include []globPattern
+	exclude []globPattern
+}
+
+// globPattern is a parsed --include or --exclude pattern. Patterns are
+// matched against paths relative to the scanned directory, with / as the
+// separator. Each segment is a path.Match pattern, and a ** segment
+// matches any number of directories, including none. A pattern without a
+// slash, such as node_modules or *.log, matches a name at any depth.
+type globPattern struct {
+	segments []string
+	anywhere bool
+}
+
+// globList collects repeated --include or --exclude flags, checking each
+// pattern as it is given.
+type globList []string
+
+func (g *globList) String() string { return strings.Join(*g, ", ") }
+
+func (g *globList) Set(value string) error {
+	if _, err := parseGlob(value); err != nil {
+		return err
+	}
+	*g = append(*g, value)
+	return nil
+}
+
+// newPathFilter parses the patterns, returning nil if there are none.
+func newPathFilter(include, exclude []string) (*pathFilter, error) {
+	if len(include) == 0 && len(exclude) == 0 {
+		return nil, nil
+	}
+
+	f := &pathFilter{}
+	for _, list := range []struct {
+		patterns []string
+		into     *[]globPattern
+	}{{include, &f.include}, {exclude, &f.exclude}} {
+		for _, p := range list.patterns {
+			g, err := parseGlob(p)
+			if err != nil {
+				return nil, err
+			}
+			*list.into = append(*list.into, g)
+		}
+	}
+	return f, nil
+}
+
+func parseGlob(pattern string) (globPattern, error) {
+	p := foldCase(filepath.ToSlash(pattern))
+	p = strings.TrimPrefix(p, "./")
+	p = strings.Trim(p, "/")
+	if p == "" {
+		return globPattern{}, fmt.Errorf("empty pattern %q", pattern)
+	}
+
+	g := globPattern{segments: strings.Split(p, "/")}
+	g.anywhere = len(g.segments) == 1 && g.segments[0] != "**"
+	for _, seg := range g.segments {
+		if _, err := path.Match(seg, ""); err != nil {
+			return globPattern{}, fmt.Errorf("invalid pattern %q: %v", pattern, err)
+		}
+	}
+	return g, nil
+}
+
+// skipDir reports whether the directory at rel is excluded.
+func (f *pathFilter) skipDir(rel string) bool {
+	return f != nil && matchAny(f.exclude, rel)
+}
+
+// skipFile reports whether the file at rel is excluded or not included.
+func (f *pathFilter) skipFile(rel string) bool {
+	if f == nil {
+		return false
+	}
+	if matchAny(f.exclude, rel) {
+		return true
+	}
+	return len(f.include) > 0 && !matchAny(f.include, rel)
+}
+
+func matchAny(patterns []globPattern, rel string) bool {
+	parts := strings.Split(foldCase(filepath.ToSlash(rel)), "/")
+	for _, g := range patterns {
+		if g.match(parts) {
+			return true
+		}
+	}
+	return false
+}
+
+func (g globPattern) match(parts []string) bool {
+	if g.anywhere {
+		ok, _ := path.Match(g.segments[0], parts[len(parts)-1])
+		return ok
+	}
+	return matchSegments(g.segments, parts)
+}
+
+func matchSegments(pattern, parts []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			// Collapse repeated ** and try every possible split.
+			for len(pattern) > 0 && pattern[0] == "**" {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 0 {
+				return true
+			}
+			for i := range parts {
+				if matchSegments(pattern, parts[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+
+		if len(parts) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], parts[0]); !ok {
+			return false
+		}
+		pattern, parts = pattern[1:], parts[1:]
+	}
+	return len(parts) == 0
+}
+
+// foldCase lowercases paths and patterns on Windows, whose file names are
+// case-insensitive.
+func foldCase(s string) string {
+	if runtime.GOOS == "windows" {
+		return strings.ToLower(s)
+	}
+	return s
+}
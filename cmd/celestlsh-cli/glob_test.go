@@ -0,0 +1,137 @@
+package main
+
+import (
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestGlobMatch(t *testing.T) {
+	tests := []struct {
+		pattern, path string
+		want          bool
+	}{
+		// A pattern without a slash matches a name at any depth.
+		{"*.log", "a.log", true},
+		{"*.log", "deep/er/a.log", true},
+		{"*.log", "a.log.gz", false},
+		{"node_modules", "node_modules", true},
+		{"node_modules", "web/node_modules", true},
+		{"node_modules", "web/node_modules2", false},
+		// With a slash it is anchored at the scanned directory.
+		{"bin/*.exe", "bin/a.exe", true},
+		{"bin/*.exe", "x/bin/a.exe", false},
+		{"bin/*.exe", "bin/sub/a.exe", false},
+		{"./bin/*.exe", "bin/a.exe", true},
+		{"/bin/", "bin", true},
+		// * does not cross a slash; ** crosses any number, including none.
+		{"usr/*/doc", "usr/share/doc", true},
+		{"usr/*/doc", "usr/share/x/doc", false},
+		{"**/*.exe", "a.exe", true},
+		{"**/*.exe", "a/b/c.exe", true},
+		{"usr/**/doc", "usr/doc", true},
+		{"usr/**/doc", "usr/share/x/doc", true},
+		{"usr/**/doc", "usr/share/x/docs", false},
+		{"usr/**", "usr/anything/at/all", true},
+		{"usr/**/**/doc", "usr/a/doc", true},
+		{"**", "a/b", true},
+		{"a/**/b/**/c", "a/x/b/y/z/c", true},
+		{"a/**/b/**/c", "a/x/y/z/c", false},
+		// Character classes and ?.
+		{"file?.[ch]", "src/file1.c", true},
+		{"file?.[ch]", "src/file10.c", false},
+		{"[^.]*", "visible", true},
+		{"[^.]*", ".hidden", false},
+	}
+	for _, tt := range tests {
+		g, err := parseGlob(tt.pattern)
+		if err != nil {
+			t.Fatalf("parseGlob(%q): %v", tt.pattern, err)
+		}
+		if got := matchAny([]globPattern{g}, filepath.FromSlash(tt.path)); got != tt.want {
+			t.Errorf("%q against %q = %v, want %v", tt.pattern, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestParseGlobInvalid(t *testing.T) {
+	for _, pattern := range []string{"", "/", "./", "[", "a/[b"} {
+		if _, err := parseGlob(pattern); err == nil {
+			t.Errorf("parseGlob(%q) succeeded, want an error", pattern)
+		}
+	}
+}
+
+func TestPathFilter(t *testing.T) {
+	tests := []struct {
+		include, exclude []string
+		path             string
+		dir              bool
+		skip             bool
+	}{
+		{nil, nil, "a.exe", false, false},
+		{[]string{"*.exe"}, nil, "a.exe", false, false},
+		{[]string{"*.exe"}, nil, "a.txt", false, true},
+		// Includes do not prune directories.
+		{[]string{"*.exe"}, nil, "docs", true, false},
+		{nil, []string{"*.exe"}, "a.exe", false, true},
+		{nil, []string{"vendor"}, "vendor", true, true},
+		{nil, []string{"vendor"}, "src/vendor", true, true},
+		// Exclude wins over include.
+		{[]string{"*.exe"}, []string{"bad.exe"}, "bad.exe", false, true},
+		{[]string{"**/*.exe"}, []string{"tmp/**"}, "tmp/a.exe", false, true},
+		{[]string{"**/*.exe"}, []string{"tmp/**"}, "bin/a.exe", false, false},
+		{[]string{"*.exe", "*.dll"}, nil, "x/a.dll", false, false},
+	}
+	for _, tt := range tests {
+		f, err := newPathFilter(tt.include, tt.exclude)
+		if err != nil {
+			t.Fatal(err)
+		}
+		skip := f.skipFile(tt.path)
+		if tt.dir {
+			skip = f.skipDir(tt.path)
+		}
+		if skip != tt.skip {
+			t.Errorf("include %q, exclude %q: skip %s = %v, want %v", tt.include, tt.exclude, tt.path, skip, tt.skip)
+		}
+	}
+	if f, _ := newPathFilter(nil, nil); f != nil || f.skipFile("a") || f.skipDir("a") {
+		t.Error("a filter without patterns skips something")
+	}
+}
+
+func TestScanIncludeExclude(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{
+		"bin/a.exe", "bin/b.dll", "bin/notes.txt",
+		"node_modules/x/c.exe", "web/node_modules/d.exe",
+		"tmp/e.exe", "lib/deep/f.dll",
+	} {
+		writeFile(t, dir, name, sampleData(uint64(len(name)), 2048))
+	}
+
+	out, _, _, err := runCLI(t, "-s", "--jsonl",
+		"--exclude", "node_modules", "--exclude", "tmp/**",
+		"--include", "**/*.exe", "--include", "**/*.dll",
+		"--db", writeTestDatabase(t), dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	results, summary := parseJSONL(t, out)
+	var got []string
+	for _, r := range results {
+		rel, _ := filepath.Rel(dir, r.Path)
+		got = append(got, filepath.ToSlash(rel))
+	}
+	slices.Sort(got)
+	want := []string{"bin/a.exe", "bin/b.dll", "lib/deep/f.dll"}
+	if !slices.Equal(got, want) {
+		t.Errorf("scanned %q, want %q", got, want)
+	}
+	// notes.txt is not included. Both node_modules directories, and tmp,
+	// which tmp/** matches too, are pruned without being entered.
+	if summary.Filtered != 1 || summary.FilteredDir != 3 {
+		t.Errorf("filtered %d files and %d directories, want 1 and 3", summary.Filtered, summary.FilteredDir)
+	}
+}
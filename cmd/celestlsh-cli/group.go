@@ -0,0 +1,26 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/Magonia-Research/CelesTLSH-CLI/pkg/celestlsh"
+)
+
+// repoSummary renders the repositories of a scan result grouped with
+// --group-by-repo on one line, best first.
+func repoSummary(bands confidenceBands, repos []celestlsh.RepoMatch) string {
+	parts := make([]string, len(repos))
+	for i, r := range repos {
+		matches := "matches"
+		if r.Count == 1 {
+			matches = "match"
+		}
+		detail := fmt.Sprintf("distance %d", r.Distance)
+		if confidence := confidencePhrase(bands.label(r.Distance)); confidence != "" {
+			detail += ", " + confidence
+		}
+		parts[i] = fmt.Sprintf("%s %s (%s, %d %s)", r.RepoName, r.FileName, detail, r.Count, matches)
+	}
+	return strings.Join(parts, "; ")
+}
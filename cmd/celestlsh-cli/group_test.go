@@ -0,0 +1,63 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestScanGroupByRepoCSV(t *testing.T) {
+	path := writeFile(t, t.TempDir(), "dropped, 2024.exe", testSample)
+
+	out, _, _, err := runCLI(t, "-s", "--group-by-repo", "--csv", "--max-distance", "-1", "--db", writeTestDatabase(t), path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows := readCSV(t, out)
+	want := [][]string{
+		{"KnownTool", "known.exe", "0", "high", "2"},
+		{"OtherTool", "other.exe", "218", "none", "1"},
+		{"ThirdTool", "third.dll", "239", "none", "1"},
+	}
+	if len(rows) != len(want) {
+		t.Fatalf("rows = %q, want one per repository", rows)
+	}
+	for i, row := range rows {
+		if len(row) != 9 || row[0] != path {
+			t.Fatalf("row %q, want 9 fields starting with %s", row, path)
+		}
+		if got := []string{row[2], row[3], row[6], row[7], row[8]}; strings.Join(got, ",") != strings.Join(want[i], ",") {
+			t.Errorf("row %d = %q, want repo, file, distance, confidence and count %q", i, row, want[i])
+		}
+	}
+}
+
+func TestCheckGroupByRepo(t *testing.T) {
+	hash := testRecords(t)[0].TLSHHash
+
+	tests := []struct {
+		args []string
+		want []string
+	}{
+		{[]string{"--csv", "--max-distance", "-1"}, []string{"KnownTool", "OtherTool", "ThirdTool"}},
+		{[]string{"--csv", "--max-distance", "-1", "--top", "2"}, []string{"KnownTool", "OtherTool"}},
+		{[]string{"--csv", "--max-distance", "100"}, []string{"KnownTool"}},
+		// Without a threshold, repositories with no confidence are left out.
+		{[]string{"--csv"}, []string{"KnownTool"}},
+	}
+	for _, tt := range tests {
+		t.Run(strings.Join(tt.args, " "), func(t *testing.T) {
+			args := append([]string{"-c", "--group-by-repo", "--db", writeTestDatabase(t)}, tt.args...)
+			out, _, _, err := runCLI(t, append(args, hash)...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var repos []string
+			for _, row := range readCSV(t, out) {
+				repos = append(repos, row[0])
+			}
+			if strings.Join(repos, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("repos = %q, want %q", repos, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,256 @@
+package main
+
+// The gRPC service of --grpc-listen and the client of --grpc, using the
+// code generated from proto/celestlsh/v1/celestlsh.proto.
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/Magonia-Research/CelesTLSH-CLI/pkg/celestlsh"
+	celestlshv1 "github.com/Magonia-Research/CelesTLSH-CLI/proto/celestlsh/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	grpcstatus "google.golang.org/grpc/status"
+)
+
+// grpcService is the gRPC server of --grpc-listen, serving s with the
+// CelesTLSH service and the standard health service, which reports it
+// serving until it stops.
+type grpcService struct {
+	*grpc.Server
+	health *health.Server
+}
+
+func newGRPCService(s *server) *grpcService {
+	g := &grpcService{
+		Server: grpc.NewServer(grpc.MaxRecvMsgSize(maxHashBodyBytes)),
+		health: health.NewServer(),
+	}
+	celestlshv1.RegisterCelesTLSHServer(g.Server, &grpcHandler{s: s})
+	healthpb.RegisterHealthServer(g.Server, g.health)
+	g.health.SetServingStatus(celestlshv1.CelesTLSH_ServiceDesc.ServiceName, healthpb.HealthCheckResponse_SERVING)
+	return g
+}
+
+// GracefulStop reports the service not serving, so that health checks
+// fail while open streams end, and then stops.
+func (g *grpcService) GracefulStop() {
+	g.health.Shutdown()
+	g.Server.GracefulStop()
+}
+
+func (g *grpcService) Stop() {
+	g.health.Shutdown()
+	g.Server.Stop()
+}
+
+// grpcHandler implements the CelesTLSH service for s.
+type grpcHandler struct {
+	celestlshv1.UnimplementedCelesTLSHServer
+	s *server
+}
+
+// The context of each call carries the client's deadline, which bounds
+// the search of the database as it does an HTTP request's.
+
+func (h *grpcHandler) Check(ctx context.Context, req *celestlshv1.CheckRequest) (*celestlshv1.CheckResponse, error) {
+	resp, err := runCheck(ctx, h.s.db.get(), checkRequestFromProto(req), h.s.db.config.Limit)
+	if err != nil {
+		return nil, grpcStatus(err)
+	}
+	h.s.metrics.checks.Inc()
+	for _, match := range resp.Matches {
+		h.s.metrics.observeMatch(match.Distance)
+	}
+	return checkResponseToProto(resp), nil
+}
+
+func (h *grpcHandler) Hash(ctx context.Context, req *celestlshv1.HashRequest) (*celestlshv1.HashResponse, error) {
+	hash, err := h.s.hasher.HashReader(ctx, bytes.NewReader(req.GetData()))
+	if err != nil {
+		return nil, grpcStatus(err)
+	}
+	h.s.metrics.hashes.Inc()
+	return &celestlshv1.HashResponse{Tlsh: hash}, nil
+}
+
+// BatchCheck answers each request of the stream in turn, as the daemon
+// does each line: a request that fails gets a response carrying its
+// error, and the stream goes on.
+func (h *grpcHandler) BatchCheck(stream celestlshv1.CelesTLSH_BatchCheckServer) error {
+	ctx := stream.Context()
+	for {
+		req, err := stream.Recv()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+		var resp *celestlshv1.CheckResponse
+		if result, err := runCheck(ctx, h.s.db.get(), checkRequestFromProto(req), h.s.db.config.Limit); err != nil {
+			if ctx.Err() != nil {
+				return grpcstatus.FromContextError(ctx.Err()).Err()
+			}
+			resp = &celestlshv1.CheckResponse{Tlsh: req.GetTlsh(), Error: err.Error()}
+		} else {
+			resp = checkResponseToProto(result)
+			h.s.metrics.checks.Inc()
+			for _, match := range result.Matches {
+				h.s.metrics.observeMatch(match.Distance)
+			}
+		}
+		if err := stream.Send(resp); err != nil {
+			return err
+		}
+	}
+}
+
+// grpcStatus returns the status of a failed call: the deadline or
+// cancellation of its context, or an invalid request.
+func grpcStatus(err error) error {
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+		return grpcstatus.FromContextError(err).Err()
+	}
+	return grpcstatus.Error(codes.InvalidArgument, err.Error())
+}
+
+// grpcRemote sends checks to the Check RPC of the server at --grpc. The
+// connection is over TLS, with the options of the download client, only
+// with --grpc-tls. --header and the bearer token are sent as metadata.
+type grpcRemote struct {
+	conn   *grpc.ClientConn
+	client celestlshv1.CelesTLSHClient
+	md     metadata.MD
+}
+
+func newGRPCRemote(config Config) (remoteClient, error) {
+	creds := insecure.NewCredentials()
+	if config.GRPCTLS {
+		tlsConfig, err := newTLSConfig(config)
+		if err != nil {
+			return nil, err
+		}
+		creds = credentials.NewTLS(tlsConfig)
+	}
+	conn, err := grpc.NewClient(config.GRPC, append([]grpc.DialOption{grpc.WithTransportCredentials(creds)}, grpcDialOptions...)...)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --grpc: %w", err)
+	}
+
+	md := metadata.MD{}
+	for name, values := range config.Headers.header() {
+		md.Append(name, values...)
+	}
+	if config.BearerToken != "" {
+		md.Set("authorization", "Bearer "+config.BearerToken)
+	}
+	return &grpcRemote{conn: conn, client: celestlshv1.NewCelesTLSHClient(conn), md: md}, nil
+}
+
+func (r *grpcRemote) check(ctx context.Context, req checkRequest) (checkResponse, error) {
+	ctx, cancel := context.WithTimeout(ctx, remoteTimeout)
+	defer cancel()
+	if len(r.md) > 0 {
+		ctx = metadata.NewOutgoingContext(ctx, r.md)
+	}
+
+	resp, err := r.client.Check(ctx, checkRequestToProto(req))
+	if err != nil {
+		switch grpcstatus.Code(err) {
+		case codes.Unauthenticated, codes.PermissionDenied:
+			return checkResponse{}, fmt.Errorf("%w: %s", celestlsh.ErrAuthenticationFailed, grpcstatus.Convert(err).Message())
+		}
+		return checkResponse{}, err
+	}
+	return checkResponseFromProto(resp), nil
+}
+
+// unavailable holds connection failures and calls that timed out.
+func (r *grpcRemote) unavailable(err error) bool {
+	switch grpcstatus.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded:
+		return true
+	}
+	return false
+}
+
+func (r *grpcRemote) close() {
+	r.conn.Close()
+}
+
+// grpcDialOptions are added to the options of the --grpc connection. It
+// is a variable so that tests can dial an in-memory listener.
+var grpcDialOptions []grpc.DialOption
+
+// The messages of the service carry the same fields as those of POST
+// /check, with int32 for int.
+
+func checkRequestToProto(req checkRequest) *celestlshv1.CheckRequest {
+	return &celestlshv1.CheckRequest{Tlsh: req.TLSH, MaxDistance: int32Ptr(req.MaxDistance), Top: int32Ptr(req.Top)}
+}
+
+func checkRequestFromProto(req *celestlshv1.CheckRequest) checkRequest {
+	return checkRequest{TLSH: req.GetTlsh(), MaxDistance: intPtr(req.MaxDistance), Top: intPtr(req.Top)}
+}
+
+func checkResponseToProto(resp checkResponse) *celestlshv1.CheckResponse {
+	out := &celestlshv1.CheckResponse{Tlsh: resp.TLSH, Truncated: int32(resp.Truncated)}
+	for _, r := range resp.Matches {
+		out.Matches = append(out.Matches, &celestlshv1.Record{
+			RepoName:  r.RepoName,
+			FileName:  r.FileName,
+			Version:   r.Version,
+			Tlsh:      r.TLSHHash,
+			Sha256:    r.SHA256Hash,
+			Imphash:   r.Imphash,
+			DateAdded: r.DateAdded,
+			Intel:     r.Intel,
+			Distance:  int32(r.Distance),
+		})
+	}
+	return out
+}
+
+func checkResponseFromProto(resp *celestlshv1.CheckResponse) checkResponse {
+	out := checkResponse{TLSH: resp.GetTlsh(), Truncated: int(resp.GetTruncated())}
+	for _, r := range resp.GetMatches() {
+		out.Matches = append(out.Matches, celestlsh.HashRecord{
+			RepoName:   r.GetRepoName(),
+			FileName:   r.GetFileName(),
+			Version:    r.GetVersion(),
+			TLSHHash:   r.GetTlsh(),
+			SHA256Hash: r.GetSha256(),
+			Imphash:    r.GetImphash(),
+			DateAdded:  r.GetDateAdded(),
+			Intel:      r.GetIntel(),
+			Distance:   int(r.GetDistance()),
+		})
+	}
+	return out
+}
+
+func int32Ptr(n *int) *int32 {
+	if n == nil {
+		return nil
+	}
+	v := int32(*n)
+	return &v
+}
+
+func intPtr(n *int32) *int {
+	if n == nil {
+		return nil
+	}
+	v := int(*n)
+	return &v
+}
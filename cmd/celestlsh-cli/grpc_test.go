@@ -0,0 +1,384 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	celestlshv1 "github.com/Magonia-Research/CelesTLSH-CLI/proto/celestlsh/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	grpcstatus "google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// testGRPC is the test database served over gRPC on an in-memory
+// listener, with a connection to it.
+type testGRPC struct {
+	s    *server
+	g    *grpcService
+	l    *bufconn.Listener
+	conn *grpc.ClientConn
+}
+
+// newTestGRPC serves the test database over gRPC, configured by extra
+// flags.
+func newTestGRPC(t *testing.T, extra ...string) *testGRPC {
+	t.Helper()
+	s, _ := newTestServer(t, extra...)
+	tg := &testGRPC{s: s, g: newGRPCService(s), l: bufconn.Listen(1 << 20)}
+	go tg.g.Serve(tg.l)
+	t.Cleanup(tg.g.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufconn",
+		grpc.WithContextDialer(tg.dial),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	tg.conn = conn
+	return tg
+}
+
+func (tg *testGRPC) dial(ctx context.Context, _ string) (net.Conn, error) {
+	return tg.l.DialContext(ctx)
+}
+
+func (tg *testGRPC) client() celestlshv1.CelesTLSHClient {
+	return celestlshv1.NewCelesTLSHClient(tg.conn)
+}
+
+func int32Of(n int32) *int32 { return &n }
+
+func TestGRPCCheck(t *testing.T) {
+	client := newTestGRPC(t, "--limit", "3").client()
+	hash := testRecords(t)[0].TLSHHash
+
+	tests := []struct {
+		name      string
+		req       *celestlshv1.CheckRequest
+		files     []string
+		truncated int32
+	}{
+		{"closest only", &celestlshv1.CheckRequest{Tlsh: hash}, []string{"known.exe"}, 0},
+		{"top", &celestlshv1.CheckRequest{Tlsh: hash, Top: int32Of(2)}, []string{"known.exe", "known-variant.exe"}, 0},
+		{"max distance", &celestlshv1.CheckRequest{Tlsh: hash, Top: int32Of(3), MaxDistance: int32Of(100)}, []string{"known.exe", "known-variant.exe"}, 0},
+		{"max distance 0", &celestlshv1.CheckRequest{Tlsh: testHash(t, sampleData(9, 8192)), MaxDistance: int32Of(0)}, nil, 0},
+		{"top over limit", &celestlshv1.CheckRequest{Tlsh: hash, Top: int32Of(10)}, []string{"known.exe", "known-variant.exe", "other.exe"}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := client.Check(context.Background(), tt.req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var files []string
+			for _, m := range resp.GetMatches() {
+				files = append(files, m.GetFileName())
+			}
+			if strings.Join(files, ",") != strings.Join(tt.files, ",") || resp.GetTruncated() != tt.truncated || resp.GetTlsh() != tt.req.GetTlsh() {
+				t.Errorf("matches %v, truncated %d; want %v, %d", files, resp.GetTruncated(), tt.files, tt.truncated)
+			}
+			if len(files) > 0 && tt.name != "max distance 0" {
+				if m := resp.GetMatches()[0]; m.GetDistance() != 0 || m.GetTlsh() != hash || m.GetSha256() == "" {
+					t.Errorf("closest match %v, want known.exe at 0 with every field", m)
+				}
+			}
+		})
+	}
+}
+
+func TestGRPCCheckInvalid(t *testing.T) {
+	client := newTestGRPC(t).client()
+	hash := testRecords(t)[0].TLSHHash
+
+	for name, req := range map[string]*celestlshv1.CheckRequest{
+		"no hash":               {},
+		"bad hash":              {Tlsh: "bogus"},
+		"negative max distance": {Tlsh: hash, MaxDistance: int32Of(-1)},
+		"top 0":                 {Tlsh: hash, Top: int32Of(0)},
+	} {
+		_, err := client.Check(context.Background(), req)
+		if code := grpcstatus.Code(err); code != codes.InvalidArgument {
+			t.Errorf("%s: code %v (%v), want InvalidArgument", name, code, err)
+		}
+	}
+}
+
+func TestGRPCHash(t *testing.T) {
+	client := newTestGRPC(t).client()
+
+	resp, err := client.Hash(context.Background(), &celestlshv1.HashRequest{Data: testSample})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := testRecords(t)[0].TLSHHash; resp.GetTlsh() != want {
+		t.Errorf("hash = %s, want %s", resp.GetTlsh(), want)
+	}
+
+	_, err = client.Hash(context.Background(), &celestlshv1.HashRequest{Data: []byte("too small")})
+	if code := grpcstatus.Code(err); code != codes.InvalidArgument {
+		t.Errorf("too small: code %v (%v), want InvalidArgument", code, err)
+	}
+}
+
+func TestGRPCBatchCheck(t *testing.T) {
+	client := newTestGRPC(t).client()
+	records := testRecords(t)
+
+	stream, err := client.BatchCheck(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	reqs := []*celestlshv1.CheckRequest{
+		{Tlsh: records[0].TLSHHash},
+		{Tlsh: "bogus"},
+		{Tlsh: records[2].TLSHHash, Top: int32Of(0)},
+		{Tlsh: records[3].TLSHHash},
+	}
+	// The requests are all sent before any answer is read, as a client
+	// streaming a batch would.
+	for _, req := range reqs {
+		if err := stream.Send(req); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := stream.CloseSend(); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for {
+		resp, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		switch {
+		case resp.GetError() != "":
+			got = append(got, resp.GetTlsh()+": error")
+		case len(resp.GetMatches()) > 0:
+			got = append(got, resp.GetMatches()[0].GetFileName())
+		default:
+			got = append(got, "no match")
+		}
+	}
+	want := []string{"known.exe", "bogus: error", records[2].TLSHHash + ": error", "third.dll"}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("responses:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestGRPCDeadline(t *testing.T) {
+	tg := newTestGRPC(t)
+	hash := testRecords(t)[0].TLSHHash
+	expired, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+
+	// The handlers search and hash within the context of the call, which
+	// carries the client's deadline.
+	h := &grpcHandler{s: tg.s}
+	if _, err := h.Check(expired, &celestlshv1.CheckRequest{Tlsh: hash}); grpcstatus.Code(err) != codes.DeadlineExceeded {
+		t.Errorf("Check handler: %v, want DeadlineExceeded", err)
+	}
+	if _, err := h.Hash(expired, &celestlshv1.HashRequest{Data: testSample}); grpcstatus.Code(err) != codes.DeadlineExceeded {
+		t.Errorf("Hash handler: %v, want DeadlineExceeded", err)
+	}
+	if _, err := tg.client().Check(expired, &celestlshv1.CheckRequest{Tlsh: hash}); grpcstatus.Code(err) != codes.DeadlineExceeded {
+		t.Errorf("Check: %v, want DeadlineExceeded", err)
+	}
+
+	// A stream is answered until its deadline, and then ends.
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	stream, err := tg.client().BatchCheck(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := stream.Send(&celestlshv1.CheckRequest{Tlsh: hash}); err != nil {
+		t.Fatal(err)
+	}
+	if resp, err := stream.Recv(); err != nil || len(resp.GetMatches()) != 1 {
+		t.Fatalf("before the deadline: %v, %v", resp, err)
+	}
+	if _, err := stream.Recv(); grpcstatus.Code(err) != codes.DeadlineExceeded {
+		t.Errorf("after the deadline: %v, want DeadlineExceeded", err)
+	}
+}
+
+func TestGRPCHealth(t *testing.T) {
+	tg := newTestGRPC(t)
+	health := healthpb.NewHealthClient(tg.conn)
+	for _, service := range []string{"", celestlshv1.CelesTLSH_ServiceDesc.ServiceName} {
+		resp, err := health.Check(context.Background(), &healthpb.HealthCheckRequest{Service: service})
+		if err != nil || resp.GetStatus() != healthpb.HealthCheckResponse_SERVING {
+			t.Errorf("service %q: %v, %v; want SERVING", service, resp, err)
+		}
+	}
+
+	// Once the server starts stopping, it reports not serving while open
+	// streams finish.
+	watchCtx, stopWatching := context.WithCancel(context.Background())
+	defer stopWatching()
+	watch, err := health.Watch(watchCtx, &healthpb.HealthCheckRequest{Service: celestlshv1.CelesTLSH_ServiceDesc.ServiceName})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp, err := watch.Recv(); err != nil || resp.GetStatus() != healthpb.HealthCheckResponse_SERVING {
+		t.Fatalf("watch: %v, %v; want SERVING", resp, err)
+	}
+	stream, err := tg.client().BatchCheck(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := stream.Send(&celestlshv1.CheckRequest{Tlsh: testRecords(t)[0].TLSHHash}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stream.Recv(); err != nil {
+		t.Fatal(err)
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		tg.g.GracefulStop()
+		close(stopped)
+	}()
+	if resp, err := watch.Recv(); err != nil || resp.GetStatus() != healthpb.HealthCheckResponse_NOT_SERVING {
+		t.Errorf("watch while stopping: %v, %v; want NOT_SERVING", resp, err)
+	}
+	stopWatching()
+	select {
+	case <-stopped:
+		t.Fatal("stopped with a stream still open")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// The open stream is still answered.
+	if err := stream.Send(&celestlshv1.CheckRequest{Tlsh: testRecords(t)[3].TLSHHash}); err != nil {
+		t.Fatal(err)
+	}
+	if resp, err := stream.Recv(); err != nil || resp.GetMatches()[0].GetFileName() != "third.dll" {
+		t.Errorf("stream while stopping: %v, %v", resp, err)
+	}
+	stream.CloseSend()
+	if _, err := stream.Recv(); !errors.Is(err, io.EOF) {
+		t.Errorf("stream end: %v, want EOF", err)
+	}
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("did not stop once the stream ended")
+	}
+}
+
+// useTestGRPC makes --grpc connect to tg, recording the metadata of each
+// call.
+func useTestGRPC(t *testing.T, tg *testGRPC) func() []metadata.MD {
+	var mu sync.Mutex
+	var calls []metadata.MD
+	record := func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		md, _ := metadata.FromOutgoingContext(ctx)
+		mu.Lock()
+		calls = append(calls, md)
+		mu.Unlock()
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+	old := grpcDialOptions
+	grpcDialOptions = []grpc.DialOption{grpc.WithContextDialer(tg.dial), grpc.WithUnaryInterceptor(record)}
+	t.Cleanup(func() { grpcDialOptions = old })
+	return func() []metadata.MD {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]metadata.MD(nil), calls...)
+	}
+}
+
+func TestGRPCRemote(t *testing.T) {
+	tg := newTestGRPC(t)
+	calls := useTestGRPC(t, tg)
+	records := testRecords(t)
+
+	// A check over --grpc answers as a local one does.
+	for _, args := range [][]string{
+		{records[0].TLSHHash},
+		{"--top", "2", records[0].TLSHHash},
+		{"--max-distance", "0", testHash(t, sampleData(9, 8192))},
+	} {
+		local, _, localStatus, err := runCLI(t, append([]string{"-c", "--json", "--db", writeTestDatabase(t)}, args...)...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		remote, _, remoteStatus, err := runCLI(t, append([]string{"-c", "--json", "--grpc", "localhost:9090", "--db", missingDatabase(t)}, args...)...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if remote != local || remoteStatus != localStatus {
+			t.Errorf("%v over --grpc (status %v):\n%s\nwant as local (status %v):\n%s", args, remoteStatus, remote, localStatus, local)
+		}
+	}
+
+	dir := t.TempDir()
+	writeFile(t, dir, "known.exe", testSample)
+	writeFile(t, dir, "clean.bin", sampleData(9, 8192))
+	before := len(calls())
+	out, _, st, err := runCLI(t, "-s", "--jsonl", "--max-distance", "30", "--grpc", "localhost:9090", "--db", missingDatabase(t),
+		"--bearer-token", "secret", "--header", "X-Team: red", dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, summary := parseJSONL(t, out); st != statusMatch || summary.Files != 2 || summary.Matched != 1 {
+		t.Errorf("scan: status %v, summary %+v, want one match of two files", st, summary)
+	}
+	scanCalls := calls()[before:]
+	if len(scanCalls) != 2 {
+		t.Fatalf("scan made %d calls, want one per file", len(scanCalls))
+	}
+	for _, md := range scanCalls {
+		if got := md.Get("authorization"); len(got) != 1 || got[0] != "Bearer secret" {
+			t.Errorf("authorization metadata = %q", got)
+		}
+		if got := md.Get("x-team"); len(got) != 1 || got[0] != "red" {
+			t.Errorf("x-team metadata = %q", got)
+		}
+	}
+}
+
+func TestGRPCRemoteFallback(t *testing.T) {
+	tg := newTestGRPC(t)
+	useTestGRPC(t, tg)
+	tg.l.Close()
+	hash := testRecords(t)[0].TLSHHash
+
+	out, stderr, st, err := runCLI(t, "-c", "--json", "--grpc", "localhost:9090", "--remote-fallback", "local", "--db", writeTestDatabase(t), hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var results []checkResult
+	if err := json.Unmarshal([]byte(out), &results); err != nil {
+		t.Fatal(err)
+	}
+	if st != statusMatch || len(results) != 1 || results[0].Match == nil || results[0].Match.FileName != "known.exe" {
+		t.Errorf("status %v, output:\n%s\nwant known.exe from the local database", st, out)
+	}
+	if !strings.Contains(stderr, "localhost:9090 is unavailable, checking against the local database instead") {
+		t.Errorf("stderr = %q, want the fallback warned about", stderr)
+	}
+
+	_, _, _, err = runCLI(t, "-c", "--json", "--grpc", "localhost:9090", "--db", writeTestDatabase(t), hash)
+	if err == nil || !strings.Contains(err.Error(), "Unavailable") {
+		t.Errorf("without --remote-fallback: %v, want Unavailable", err)
+	}
+}
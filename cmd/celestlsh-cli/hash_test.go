@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// sha256sum returns what the sha256sum tool prints for path, skipping the
+// test where it is missing.
+func sha256sum(t *testing.T, path string) string {
+	t.Helper()
+	if _, err := exec.LookPath("sha256sum"); err != nil {
+		t.Skip("sha256sum not found")
+	}
+	out, err := exec.Command("sha256sum", path).Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum, _, _ := strings.Cut(string(out), " ")
+	return sum
+}
+
+// readCSV parses CSV output, failing the test if it is malformed.
+func readCSV(t *testing.T, out string) [][]string {
+	t.Helper()
+	r := csv.NewReader(strings.NewReader(out))
+	r.FieldsPerRecord = -1
+	rows, err := r.ReadAll()
+	if err != nil {
+		t.Fatalf("parsing CSV %q: %v", out, err)
+	}
+	return rows
+}
+
+func TestHashSHA256(t *testing.T) {
+	path := writeFile(t, t.TempDir(), "sample.bin", testSample)
+	sum := sha256sum(t, path)
+
+	out, _, _, err := runCLI(t, "-h", "--sha256", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out, "SHA256 hash of "+path+": "+sum+"\n") {
+		t.Errorf("output %q lacks the SHA256 %s", out, sum)
+	}
+
+	out, _, _, err = runCLI(t, "-h", "--sha256", "--quiet", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := testHash(t, testSample) + " " + sum + "\n"; out != want {
+		t.Errorf("--quiet printed %q, want %q", out, want)
+	}
+}
+
+func TestHashCSVQuotesPath(t *testing.T) {
+	path := writeFile(t, t.TempDir(), `report, "final".bin`, testSample)
+
+	out, _, _, err := runCLI(t, "-h", "--all-hashes", "--csv", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows := readCSV(t, out)
+	if len(rows) != 1 || len(rows[0]) != 5 {
+		t.Fatalf("rows = %q, want one of path, TLSH, MD5, SHA1 and SHA256", rows)
+	}
+	if rows[0][0] != path || rows[0][1] != testHash(t, testSample) || rows[0][4] != sha256sum(t, path) {
+		t.Errorf("row = %q, want the path, TLSH and SHA256 of %s", rows[0], path)
+	}
+}
+
+func TestScanSHA256(t *testing.T) {
+	dir := t.TempDir()
+	known := writeFile(t, dir, "known, copy.exe", testSample)
+	other := writeFile(t, dir, "other.bin", sampleData(5, 4096))
+
+	out, _, _, err := runCLI(t, "-s", "--sha256", "--csv", "--max-distance", "30", "--db", writeTestDatabase(t), dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows := readCSV(t, out)
+	byPath := make(map[string][]string)
+	for _, row := range rows {
+		if len(row) != 9 {
+			t.Fatalf("row %q has %d fields, want 9", row, len(row))
+		}
+		byPath[row[0]] = row
+	}
+	if row := byPath[known]; row == nil || row[2] != sha256sum(t, known) || row[4] != "known.exe" || row[7] != "0" {
+		t.Errorf("row for %s = %q, want its SHA256 and a match for known.exe", known, row)
+	}
+	if row := byPath[other]; row == nil || row[2] != sha256sum(t, other) || row[3] != "" {
+		t.Errorf("row for %s = %q, want its SHA256 and no match", other, row)
+	}
+}
+
+func TestHashMultipleFiles(t *testing.T) {
+	dir := t.TempDir()
+	a := writeFile(t, dir, "a, first.exe", testSample)
+	b := writeFile(t, dir, "b.dll", sampleData(2, 8192))
+	missing := filepath.Join(dir, "missing.exe")
+
+	for _, workers := range []string{"1", "4"} {
+		t.Run("workers "+workers, func(t *testing.T) {
+			out, stderr, st, err := runCLI(t, "-h", "--csv", "--workers", workers, a, missing, b)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if st != statusPartial {
+				t.Errorf("status = %v, want partial", st)
+			}
+			if !strings.Contains(stderr, "missing.exe") {
+				t.Errorf("stderr %q does not report the missing file", stderr)
+			}
+			rows := readCSV(t, out)
+			if len(rows) != 2 || rows[0][0] != a || rows[1][0] != b {
+				t.Fatalf("rows = %q, want %s then %s", rows, a, b)
+			}
+			if rows[0][1] != testHash(t, testSample) {
+				t.Errorf("hash of %s = %s, want that of the sample", a, rows[0][1])
+			}
+		})
+	}
+
+	out, _, st, err := runCLI(t, "-h", a, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := a + ": " + testHash(t, testSample) + "\n"; st != statusOK || !strings.HasPrefix(out, want) {
+		t.Errorf("printed %q with status %v, want lines starting %q", out, st, want)
+	}
+}
+
+func TestHashTooSmall(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name string
+		data []byte
+		want string
+	}{
+		{"empty", nil, "file is too small for a TLSH hash: 0 bytes, the minimum is 256"},
+		{"ten bytes", []byte("0123456789"), "file is too small for a TLSH hash: 10 bytes, the minimum is 256"},
+		{"forceable", sampleData(1, 100), "100 bytes, the minimum is 256 (--force lowers it to 50)"},
+		{"low entropy", bytes.Repeat([]byte("ab"), 2048), "file contents are too uniform for a TLSH hash (4096 bytes with too little variation)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeFile(t, dir, tt.name, tt.data)
+			_, _, _, err := runCLI(t, "-h", path)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestHashForce(t *testing.T) {
+	path := writeFile(t, t.TempDir(), "short.bin", sampleData(1, 100))
+
+	if _, _, _, err := runCLI(t, "-h", path); err == nil {
+		t.Error("100 bytes hashed without --force")
+	}
+	out, _, _, err := runCLI(t, "-h", "--force", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(out, "TLSH hash of "+path+": ") {
+		t.Errorf("output %q, want the hash", out)
+	}
+
+	short := writeFile(t, t.TempDir(), "shorter.bin", sampleData(1, 40))
+	if _, _, _, err := runCLI(t, "-h", "--force", short); err == nil || !strings.Contains(err.Error(), "40 bytes, the minimum is 50") {
+		t.Errorf("error = %v, want the forced minimum", err)
+	}
+}
+
+func TestScanSkipsTooSmall(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "empty", nil)
+	writeFile(t, dir, "tiny", []byte("0123456789"))
+	writeFile(t, dir, "uniform", make([]byte, 4096))
+	writeFile(t, dir, "known.exe", testSample)
+
+	out, _, st, err := runCLI(t, "-s", "--jsonl", "--max-distance", "30", "--db", writeTestDatabase(t), dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	results, summary := parseJSONL(t, out)
+	if len(results) != 1 || summary.TooSmall != 3 || summary.Failed != 0 {
+		t.Errorf("%d results, %d too small, %d failed; want known.exe alone, 3 too small, none failed", len(results), summary.TooSmall, summary.Failed)
+	}
+	if st != statusMatch {
+		t.Errorf("status = %v, want a match", st)
+	}
+}
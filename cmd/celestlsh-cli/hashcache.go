@@ -0,0 +1,365 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Magonia-Research/CelesTLSH-CLI/pkg/celestlsh"
+)
+
+// hashCacheMigrations create and update the schema of a hash cache, as
+// resultsMigrations do for a results database. mtime is in nanoseconds,
+// digests is the celestlsh.Digest set the hashes were computed with, and
+// type the fileType of the contents, for --types.
+var hashCacheMigrations = []string{
+	`CREATE TABLE files (
+		path      TEXT PRIMARY KEY,
+		size      INTEGER NOT NULL,
+		mtime     INTEGER NOT NULL,
+		inode     INTEGER NOT NULL,
+		type      INTEGER NOT NULL,
+		digests   INTEGER NOT NULL,
+		hashes    TEXT NOT NULL,
+		hashed_at TEXT NOT NULL
+	) WITHOUT ROWID;`,
+}
+
+// hashCacheBatch is how many new entries are held before they are written
+// to the cache in one transaction.
+const hashCacheBatch = 1000
+
+// cachedFile is a hash cache entry: the digests computed with Which from
+// the contents of a file, of type Kind, that had the Size, modification
+// time in nanoseconds and Inode given.
+type cachedFile struct {
+	Size    int64
+	MTime   int64
+	Inode   uint64
+	Kind    fileType
+	Which   celestlsh.Digest
+	Digests celestlsh.Digests
+}
+
+// hashCache remembers the digests of files hashed by earlier scans, keyed
+// by absolute path and checked against the size, modification time and
+// inode the file had then, so that a re-scan does not read unchanged
+// files. Only plain files of at least celestlsh.MinDataLength bytes are
+// cached: archives are opened again to scan their members, and smaller
+// files hash differently with --force.
+//
+// The cache is a SQLite file. Entries are looked up as the scan reaches
+// each file and written in batches, each in a short transaction, so that
+// scans sharing a cache at once each add their entries to it. When the
+// scan ends, the entries for files under its directories that no longer
+// exist are removed. A cache that cannot be read is only a cache: it is
+// deleted and rebuilt with a warning, and if even that fails the scan goes
+// on without one.
+type hashCache struct {
+	path    string
+	refresh bool
+	roots   []string
+
+	mu      sync.Mutex
+	db      *sql.DB
+	pending map[string]cachedFile
+}
+
+// newHashCache opens the cache for --hash-cache, or returns nil if it is
+// not set or --no-cache is given.
+func newHashCache(config Config) *hashCache {
+	if config.HashCache == "" || config.NoCache {
+		return nil
+	}
+	c := &hashCache{path: config.HashCache, refresh: config.RefreshCache, roots: config.Paths, pending: make(map[string]cachedFile)}
+	if err := c.open(); err != nil {
+		c.rebuild(err)
+	}
+	if c.db == nil {
+		return nil
+	}
+	return c
+}
+
+// open opens, creating or migrating as needed, the cache file.
+func (c *hashCache) open() error {
+	if dir := filepath.Dir(c.path); dir != "." {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+	db, err := openSQLite(context.Background(), c.path, hashCacheMigrations)
+	if err != nil {
+		return err
+	}
+	c.db = db
+	return nil
+}
+
+// rebuild deletes a cache that failed with err and creates it again,
+// leaving c without a database if that fails too. It is called with mu
+// held, or before the cache is shared.
+func (c *hashCache) rebuild(err error) {
+	clearProgress()
+	fmt.Fprintf(os.Stderr, "Warning: hash cache %s is unusable, rebuilding it: %v\n", c.path, err)
+	if c.db != nil {
+		c.db.Close()
+		c.db = nil
+	}
+	for _, suffix := range []string{"", "-journal", "-wal", "-shm"} {
+		if rerr := os.Remove(c.path + suffix); rerr != nil && !errors.Is(rerr, fs.ErrNotExist) {
+			fmt.Fprintf(os.Stderr, "Warning: scanning without the hash cache: %v\n", rerr)
+			return
+		}
+	}
+	if err := c.open(); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: scanning without the hash cache: %v\n", err)
+	}
+}
+
+// lookup returns the entry for the file at path, described by info, if it
+// has one computed with at least the digests which and the file has not
+// changed since.
+func (c *hashCache) lookup(path string, info fs.FileInfo, which celestlsh.Digest) (cachedFile, bool) {
+	if c == nil || c.refresh || !cacheable(info) {
+		return cachedFile{}, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.db == nil {
+		return cachedFile{}, false
+	}
+
+	var entry cachedFile
+	var hashes string
+	err := c.db.QueryRow("SELECT size, mtime, inode, type, digests, hashes FROM files WHERE path = ?", absPath(path)).
+		Scan(&entry.Size, &entry.MTime, &entry.Inode, &entry.Kind, &entry.Which, &hashes)
+	if errors.Is(err, sql.ErrNoRows) {
+		return cachedFile{}, false
+	}
+	if err == nil {
+		err = json.Unmarshal([]byte(hashes), &entry.Digests)
+	}
+	if err != nil {
+		c.rebuild(err)
+		return cachedFile{}, false
+	}
+	if entry.Size != info.Size() || entry.MTime != info.ModTime().UnixNano() || entry.Inode != fileInode(info) || entry.Which&which != which {
+		return cachedFile{}, false
+	}
+	return entry, true
+}
+
+// store records the digests of the file at path, computed with which from
+// contents of the given type, while the file was as described by info.
+func (c *hashCache) store(path string, info fs.FileInfo, kind fileType, which celestlsh.Digest, digests celestlsh.Digests) {
+	if c == nil || !cacheable(info) {
+		return
+	}
+	entry := cachedFile{Size: info.Size(), MTime: info.ModTime().UnixNano(), Inode: fileInode(info), Kind: kind, Which: which, Digests: digests}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.db == nil {
+		return
+	}
+	c.pending[absPath(path)] = entry
+	if len(c.pending) >= hashCacheBatch {
+		if err := c.flush(); err != nil {
+			c.rebuild(err)
+		}
+	}
+}
+
+// flush writes the pending entries in one transaction, replacing those
+// of the same paths, whichever scan wrote them. It is called with mu held.
+func (c *hashCache) flush() error {
+	if len(c.pending) == 0 {
+		return nil
+	}
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	put, err := tx.Prepare(`INSERT OR REPLACE INTO files (path, size, mtime, inode, type, digests, hashes, hashed_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return err
+	}
+	defer put.Close()
+
+	now := timestamp(time.Now())
+	for path, entry := range c.pending {
+		hashes, err := json.Marshal(entry.Digests)
+		if err != nil {
+			return err
+		}
+		if _, err := put.Exec(path, entry.Size, entry.MTime, entry.Inode, entry.Kind, entry.Which, string(hashes), now); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	clear(c.pending)
+	return nil
+}
+
+// prune removes the entries for files under the scanned directories that
+// no longer exist. It is called with mu held.
+func (c *hashCache) prune() error {
+	var gone []string
+	for _, root := range c.roots {
+		if info, err := os.Stat(root); err != nil || !info.IsDir() {
+			continue
+		}
+		prefix := absPath(root)
+		if !strings.HasSuffix(prefix, string(filepath.Separator)) {
+			prefix += string(filepath.Separator)
+		}
+		// The paths starting with prefix sort from it up to, but not
+		// including, prefix with its separator incremented.
+		end := prefix[:len(prefix)-1] + string(filepath.Separator+1)
+		rows, err := c.db.Query("SELECT path FROM files WHERE path >= ? AND path < ?", prefix, end)
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			var path string
+			if err := rows.Scan(&path); err != nil {
+				rows.Close()
+				return err
+			}
+			if _, err := os.Lstat(path); errors.Is(err, fs.ErrNotExist) {
+				gone = append(gone, path)
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+	}
+	if len(gone) == 0 {
+		return nil
+	}
+
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, path := range gone {
+		if _, err := tx.Exec("DELETE FROM files WHERE path = ?", path); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// close saves the pending entries, prunes the cache and closes it,
+// warning if that fails.
+func (c *hashCache) close() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.db == nil {
+		return
+	}
+	err := c.flush()
+	if err == nil {
+		err = c.prune()
+	}
+	if cerr := c.db.Close(); err == nil {
+		err = cerr
+	}
+	c.db = nil
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to save hash cache %s: %v\n", c.path, err)
+	}
+}
+
+// cacheable reports whether a file described by info may be cached.
+func cacheable(info fs.FileInfo) bool {
+	return info.Mode().IsRegular() && info.Size() >= celestlsh.MinDataLength
+}
+
+// fileInode returns the inode of the file described by info, or 0 where
+// the platform has none.
+func fileInode(info fs.FileInfo) uint64 {
+	id, _ := statFileID(info)
+	return id.ino
+}
+
+// cached checks the file at path with its digests from the hash cache,
+// looked up in the database afresh, and reports whether it did; a hit for
+// a type not selected with --types is skipped as a read would be. On a
+// miss it returns the file as stat'ed before the lookup, or nil if it
+// cannot be cached, for storing its digests once the file is hashed.
+func (s *scanner) cached(ctx context.Context, path string) (fs.FileInfo, bool) {
+	if s.cache == nil {
+		return nil, false
+	}
+	info, err := os.Stat(path)
+	if err != nil || !cacheable(info) {
+		return nil, false
+	}
+	which := s.digests()
+	entry, ok := s.cache.lookup(path, info, which)
+	if !ok {
+		return info, false
+	}
+	s.stats.cacheHits.Add(1)
+	if s.config.Types != 0 && entry.Kind&s.config.Types == 0 {
+		s.stats.skippedType.Add(1)
+		s.note(path, "type "+entry.Kind.String()+" not selected with --types")
+		return nil, true
+	}
+
+	result := scanResult{Path: path, Digests: selectDigests(entry.Digests, which), size: info.Size(), file: path}
+	s.emit(s.lookup(ctx, result))
+	return nil, true
+}
+
+// cacheMiss counts the hashing of a file the hash cache did not have, and
+// stores its digests if the file is still as it was stat'ed before.
+func (s *scanner) cacheMiss(path string, before fs.FileInfo, src scanSource, kind fileType, digests celestlsh.Digests) {
+	s.stats.cacheMisses.Add(1)
+	after, err := src.Stat()
+	if err != nil || after.Size() != before.Size() || !after.ModTime().Equal(before.ModTime()) || fileInode(after) != fileInode(before) {
+		return
+	}
+	s.cache.store(path, after, kind, s.digests(), digests)
+}
+
+// selectDigests returns d with only the digests in which, as if it had
+// been computed with them alone.
+func selectDigests(d celestlsh.Digests, which celestlsh.Digest) celestlsh.Digests {
+	for _, digest := range []struct {
+		which celestlsh.Digest
+		value *string
+	}{
+		{celestlsh.DigestMD5, &d.MD5},
+		{celestlsh.DigestSHA1, &d.SHA1},
+		{celestlsh.DigestSHA256, &d.SHA256},
+		{celestlsh.DigestImphash, &d.Imphash},
+		{celestlsh.DigestSSDeep, &d.SSDeep},
+	} {
+		if which&digest.which == 0 {
+			*digest.value = ""
+		}
+	}
+	if which&celestlsh.DigestEntropy == 0 {
+		d.Entropy = 0
+	}
+	return d
+}
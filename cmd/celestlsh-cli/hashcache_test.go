@@ -0,0 +1,322 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Magonia-Research/CelesTLSH-CLI/pkg/celestlsh"
+)
+
+// cacheScan scans dir with the hash cache at cache and returns the results
+// by path, the summary and what was written to stderr.
+func cacheScan(t *testing.T, db, cache, dir string, extra ...string) (map[string]scanResult, jsonlSummary, string) {
+	t.Helper()
+	args := append([]string{"-s", "--jsonl", "--max-distance", "30", "--db", db, "--hash-cache", cache}, extra...)
+	out, stderr, _, err := runCLI(t, append(args, dir)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	results, summary := parseJSONL(t, out)
+	return resultsByPath(results), summary, stderr
+}
+
+func TestHashCache(t *testing.T) {
+	dir := t.TempDir()
+	known := writeFile(t, dir, "known.exe", testSample)
+	other := writeFile(t, dir, "other.bin", sampleData(20, 8192))
+	writeFile(t, dir, "tiny.txt", []byte("too small to cache"))
+	writeFile(t, dir, "bundle.tar", makeTar(t, archiveEntry{name: "bin/tool.exe", data: testSample}))
+	db := writeTestDatabase(t)
+	cache := filepath.Join(t.TempDir(), "cache", "hashes.cache")
+
+	counts := func(name string, s jsonlSummary, hits, misses int64) {
+		t.Helper()
+		if s.CacheHits != hits || s.CacheMisses != misses {
+			t.Errorf("%s: %d cache hits, %d misses; want %d, %d", name, s.CacheHits, s.CacheMisses, hits, misses)
+		}
+	}
+
+	// Only the two plain files large enough are cached; the archive is
+	// opened every time to scan its members.
+	first, s, _ := cacheScan(t, db, cache, dir)
+	counts("first scan", s, 0, 2)
+	if _, err := os.Stat(cache); err != nil {
+		t.Fatalf("cache not saved: %v", err)
+	}
+
+	again, s, _ := cacheScan(t, db, cache, dir)
+	counts("second scan", s, 2, 0)
+	for _, path := range []string{known, other, filepath.Join(dir, "bundle.tar") + "!bin/tool.exe"} {
+		a, b := first[path], again[path]
+		if a.TLSH == "" || a.TLSH != b.TLSH || a.SHA256 != b.SHA256 || (a.Match == nil) != (b.Match == nil) {
+			t.Errorf("%s: first scan %+v, from the cache %+v", path, a, b)
+		}
+	}
+	if m := again[known].Match; m == nil || m.FileName != "known.exe" {
+		t.Errorf("known.exe from the cache matched %+v, want known.exe", m)
+	}
+
+	// A file rewritten with the same size and modification time is taken
+	// from the cache without being read, so its old hash is reported.
+	info, err := os.Stat(other)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(other, sampleData(21, 8192), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(other, info.ModTime(), info.ModTime()); err != nil {
+		t.Fatal(err)
+	}
+	results, s, _ := cacheScan(t, db, cache, dir)
+	counts("unread scan", s, 2, 0)
+	if results[other].TLSH != first[other].TLSH {
+		t.Errorf("other.bin hashed again, though it looked unchanged")
+	}
+
+	// --refresh-cache reads every file again and updates the entries.
+	results, s, _ = cacheScan(t, db, cache, dir, "--refresh-cache")
+	counts("refresh", s, 0, 2)
+	fresh := results[other].TLSH
+	if fresh == first[other].TLSH {
+		t.Errorf("other.bin not hashed again with --refresh-cache")
+	}
+	results, s, _ = cacheScan(t, db, cache, dir)
+	counts("after refresh", s, 2, 0)
+	if results[other].TLSH != fresh {
+		t.Errorf("other.bin from the cache %s, want the refreshed %s", results[other].TLSH, fresh)
+	}
+
+	// A changed modification time is a change.
+	later := info.ModTime().Add(time.Hour)
+	if err := os.Chtimes(known, later, later); err != nil {
+		t.Fatal(err)
+	}
+	_, s, _ = cacheScan(t, db, cache, dir)
+	counts("touched", s, 1, 1)
+
+	// A digest the entries lack is computed by reading the files once.
+	results, s, _ = cacheScan(t, db, cache, dir, "--entropy")
+	counts("new digest", s, 0, 2)
+	results, s, _ = cacheScan(t, db, cache, dir, "--entropy")
+	counts("new digest again", s, 2, 0)
+	if results[other].Entropy == 0 {
+		t.Errorf("other.bin from the cache has no entropy: %+v", results[other])
+	}
+	// Entries with more digests serve scans asking for fewer, which do
+	// not report the others.
+	results, s, _ = cacheScan(t, db, cache, dir)
+	counts("fewer digests", s, 2, 0)
+	if results[other].Entropy != 0 {
+		t.Errorf("entropy %v reported without --entropy", results[other].Entropy)
+	}
+
+	// --no-cache leaves the cache alone.
+	_, s, _ = cacheScan(t, db, cache, dir, "--no-cache")
+	counts("no cache", s, 0, 0)
+}
+
+func TestHashCacheRebuild(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "known.exe", testSample)
+	writeFile(t, dir, "other.bin", sampleData(20, 8192))
+	db := writeTestDatabase(t)
+
+	// A cache from a version of the tool with one more migration.
+	newer := filepath.Join(t.TempDir(), "newer.cache")
+	sdb, err := openSQLite(context.Background(), newer, append(hashCacheMigrations[:len(hashCacheMigrations):len(hashCacheMigrations)], "CREATE TABLE later (x)"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sdb.Close()
+
+	for _, tt := range []struct {
+		name  string
+		cache string
+		want  string
+	}{
+		{"corrupt", writeFile(t, t.TempDir(), "corrupt.cache", []byte("not a cache at all")), "is unusable, rebuilding it"},
+		{"truncated", "", "is unusable, rebuilding it"},
+		{"newer", newer, fmt.Sprintf("schema version %d is newer than this version of the tool supports", len(hashCacheMigrations)+1)},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			cache := tt.cache
+			if cache == "" {
+				// Half of a good cache.
+				cache = filepath.Join(t.TempDir(), "truncated.cache")
+				cacheScan(t, db, cache, dir)
+				data, err := os.ReadFile(cache)
+				if err != nil {
+					t.Fatal(err)
+				}
+				os.WriteFile(cache, data[:len(data)/2], 0644)
+			}
+
+			results, s, stderr := cacheScan(t, db, cache, dir)
+			if !strings.Contains(stderr, "Warning: hash cache "+cache) || !strings.Contains(stderr, tt.want) {
+				t.Errorf("stderr = %q, want a warning that %s", stderr, tt.want)
+			}
+			if len(results) != 2 || s.CacheMisses != 2 {
+				t.Errorf("%d results, %d cache misses; want the scan to go on and read both files", len(results), s.CacheMisses)
+			}
+			_, s, stderr = cacheScan(t, db, cache, dir)
+			if stderr != "" || s.CacheHits != 2 {
+				t.Errorf("after rebuilding: %d cache hits, stderr %q; want 2 and no warning", s.CacheHits, stderr)
+			}
+		})
+	}
+}
+
+// cachedPaths returns the paths with an entry in the hash cache at cache.
+func cachedPaths(t *testing.T, cache string) []string {
+	t.Helper()
+	db, err := openSQLite(context.Background(), cache, hashCacheMigrations)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	rows, err := db.Query("SELECT path FROM files ORDER BY path")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var paths []string
+	for rows.Next() {
+		var path string
+		if err := rows.Scan(&path); err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, path)
+	}
+	return paths
+}
+
+func TestHashCachePrune(t *testing.T) {
+	dir, elsewhere := t.TempDir(), t.TempDir()
+	keep := writeFile(t, dir, "sub/keep.bin", sampleData(30, 8192))
+	gone := writeFile(t, dir, "sub/gone.bin", sampleData(31, 8192))
+	other := writeFile(t, elsewhere, "other.bin", sampleData(32, 8192))
+	// A sibling whose name starts like the scanned directory's.
+	sibling := writeFile(t, dir+"-old", "gone.bin", sampleData(33, 8192))
+	db := writeTestDatabase(t)
+	cache := filepath.Join(t.TempDir(), "hashes.cache")
+
+	for _, d := range []string{dir, elsewhere, dir + "-old"} {
+		cacheScan(t, db, cache, d)
+	}
+	if got := cachedPaths(t, cache); len(got) != 4 {
+		t.Fatalf("cached %q, want 4 files", got)
+	}
+
+	// Files gone from the scanned directory lose their entries; those
+	// outside it keep theirs, even if they are gone too, until their own
+	// directory is scanned.
+	for _, path := range []string{gone, other, sibling} {
+		if err := os.Remove(path); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cacheScan(t, db, cache, dir)
+	want := []string{absPath(other), absPath(sibling), absPath(keep)}
+	if got := cachedPaths(t, cache); strings.Join(got, " ") != strings.Join(sortedStrings(want), " ") {
+		t.Errorf("cached %q after removing files, want %q", got, sortedStrings(want))
+	}
+}
+
+func TestHashCacheShared(t *testing.T) {
+	dir := t.TempDir()
+	cache := filepath.Join(t.TempDir(), "hashes.cache")
+	a := writeFile(t, dir, "a.bin", sampleData(40, 8192))
+	b := writeFile(t, dir, "b.bin", sampleData(41, 8192))
+
+	// Two scans sharing the cache at once, the first to finish saving
+	// its entries before the second: both are kept.
+	c1 := newHashCache(Config{HashCache: cache})
+	c2 := newHashCache(Config{HashCache: cache})
+	for _, s := range []struct {
+		c    *hashCache
+		path string
+	}{{c1, a}, {c2, b}} {
+		info, err := os.Stat(s.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.c.store(s.path, info, typePE, celestlsh.DigestSHA256, celestlsh.Digests{TLSH: "T1" + filepath.Base(s.path)})
+	}
+	c1.close()
+	c2.close()
+
+	want := sortedStrings([]string{absPath(a), absPath(b)})
+	if got := cachedPaths(t, cache); strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("cached %q, want both scans' entries %q", got, want)
+	}
+	info, err := os.Stat(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c3 := newHashCache(Config{HashCache: cache})
+	defer c3.close()
+	if entry, ok := c3.lookup(a, info, celestlsh.DigestSHA256); !ok || entry.Digests.TLSH != "T1a.bin" {
+		t.Errorf("lookup of a.bin = %+v, %v; want the first scan's entry", entry, ok)
+	}
+}
+
+func sortedStrings(s []string) []string {
+	s = slices.Clone(s)
+	slices.Sort(s)
+	return s
+}
+
+func TestHashCacheFlags(t *testing.T) {
+	for _, tt := range []struct {
+		args []string
+		want string
+	}{
+		{[]string{"-s", "--no-cache", "--refresh-cache", "--hash-cache", "x", "."}, "--no-cache and --refresh-cache cannot be used together"},
+		{[]string{"-s", "--refresh-cache", "."}, "--no-cache and --refresh-cache require --hash-cache or $CELESTLSH_HASH_CACHE"},
+		{[]string{"-c", "--hash-cache", "x", testRecords(t)[0].TLSHHash}, "--hash-cache only applies to scan mode"},
+	} {
+		t.Setenv("CELESTLSH_HASH_CACHE", "")
+		if _, stderr := runMain(t, tt.args...); !strings.Contains(stderr, tt.want) {
+			t.Errorf("%v: stderr = %q, want %q", tt.args, stderr, tt.want)
+		}
+	}
+}
+
+// BenchmarkRescan measures a re-scan of an unchanged tree, read in full
+// without the hash cache and taken from it with one.
+func BenchmarkRescan(b *testing.B) {
+	dir := b.TempDir()
+	for i := range 200 {
+		writeFile(b, dir, fmt.Sprintf("dir%d/file%d.bin", i%10, i), sampleData(uint64(100+i), 256<<10))
+	}
+	db := writeTestDatabase(b)
+	cache := filepath.Join(b.TempDir(), "hashes.cache")
+	if _, _, _, err := runCLI(b, "-s", "--quiet", "--db", db, "--hash-cache", cache, dir); err != nil {
+		b.Fatal(err)
+	}
+
+	for _, bench := range []struct {
+		name string
+		args []string
+	}{
+		{"without cache", nil},
+		{"with cache", []string{"--hash-cache", cache}},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			args := append(append([]string{"-s", "--quiet", "--db", db}, bench.args...), dir)
+			b.SetBytes(200 * 256 << 10)
+			for i := 0; i < b.N; i++ {
+				if _, _, _, err := runCLI(b, args...); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,14 @@
+//go:build !windows
+
+package main
+
+import (
+	"io/fs"
+	"strings"
+)
+
+// isHidden reports whether the directory entry d is hidden, which on Unix
+// means its name starts with a dot.
+func isHidden(d fs.DirEntry) bool {
+	return strings.HasPrefix(d.Name(), ".")
+}
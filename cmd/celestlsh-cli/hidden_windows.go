@@ -0,0 +1,18 @@
+//go:build windows
+
+package main
+
+import (
+	"io/fs"
+	"syscall"
+)
+
+// isHidden reports whether the directory entry d has the hidden attribute.
+func isHidden(d fs.DirEntry) bool {
+	info, err := d.Info()
+	if err != nil {
+		return false
+	}
+	attrs, ok := info.Sys().(*syscall.Win32FileAttributeData)
+	return ok && attrs.FileAttributes&syscall.FILE_ATTRIBUTE_HIDDEN != 0
+}
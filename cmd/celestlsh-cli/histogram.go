@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/Magonia-Research/CelesTLSH-CLI/pkg/celestlsh"
+)
+
+// histogramBarWidth is the length of the bar of the fullest bucket.
+const histogramBarWidth = 40
+
+// histogramBucket counts the records at a distance from Start to End
+// inclusive.
+type histogramBucket struct {
+	Start int `json:"bucket_start"`
+	End   int `json:"bucket_end"`
+	Count int `json:"count"`
+}
+
+// distanceHistogram counts matches by distance in buckets of width
+// distances, 0 to width, width+1 to 2*width and so on, up to the bucket of
+// the furthest match. Empty buckets in between are kept, so the gaps show.
+func distanceHistogram(matches []celestlsh.HashRecord, width int) []histogramBucket {
+	var buckets []histogramBucket
+	for _, m := range matches {
+		i := 0
+		if m.Distance > 0 {
+			i = (m.Distance - 1) / width
+		}
+		for len(buckets) <= i {
+			n := len(buckets)
+			start := n*width + 1
+			if n == 0 {
+				start = 0
+			}
+			buckets = append(buckets, histogramBucket{Start: start, End: (n + 1) * width})
+		}
+		buckets[i].Count++
+	}
+	return buckets
+}
+
+// printHistogram prints buckets as a table with a bar of '#' per bucket,
+// scaled to the fullest one.
+func printHistogram(buckets []histogramBucket) {
+	total, most := 0, 0
+	for _, b := range buckets {
+		total += b.Count
+		most = max(most, b.Count)
+	}
+	records := "records"
+	if total == 1 {
+		records = "record"
+	}
+	fmt.Printf("Distance histogram (%d %s):\n", total, records)
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	for _, b := range buckets {
+		fmt.Fprintf(tw, "  %d-%d\t%d\t%s\n", b.Start, b.End, b.Count, histogramBar(b.Count, most))
+	}
+	tw.Flush()
+}
+
+// histogramBar returns the bar of a bucket holding count records, when the
+// fullest holds most, preceded by a space to set it off from the count.
+func histogramBar(count, most int) string {
+	if count == 0 {
+		return ""
+	}
+	return " " + strings.Repeat("#", max(1, count*histogramBarWidth/most))
+}
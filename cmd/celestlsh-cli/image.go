@@ -0,0 +1,416 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+)
+
+const (
+	// whiteoutPrefix marks a layer entry deleting the file of the same
+	// name, without the prefix, from the layers below it.
+	whiteoutPrefix = ".wh."
+	// opaqueWhiteout marks a directory whose contents in the layers below
+	// are hidden.
+	opaqueWhiteout = ".wh..wh..opq"
+
+	// imageMetadataLimit caps the manifests and configs read from an
+	// image.
+	imageMetadataLimit = 4 << 20
+
+	ociImageIndex = "application/vnd.oci.image.index.v1+json"
+	// dockerManifestList is the Docker equivalent of an OCI image index.
+	dockerManifestList = "application/vnd.docker.distribution.manifest.list.v2+json"
+)
+
+// imageArchive is an image saved by docker save, or another tool writing
+// the OCI image layout, opened for --scan-image. Its layers are read from
+// the tar at the offsets recorded in entries.
+type imageArchive struct {
+	path    string
+	f       *os.File
+	entries map[string]imageEntry
+}
+
+// imageEntry is where a file of the image tar is stored.
+type imageEntry struct {
+	offset int64
+	size   int64
+}
+
+// imageLayer is a layer of an image: the name of its tar in the image
+// tar, and the digest results name it by.
+type imageLayer struct {
+	file   string
+	digest string
+}
+
+// openImage indexes the files of the image tar at name.
+func openImage(name string) (*imageArchive, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	img := &imageArchive{path: name, f: f, entries: make(map[string]imageEntry)}
+
+	// The tar reader seeks over the data of each file, so that only the
+	// headers are read, and the offset after a header is where its data
+	// starts.
+	r := &offsetReader{r: f}
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("failed to read image archive: %w", err)
+		}
+		if hdr.Typeflag == tar.TypeReg || hdr.Typeflag == tar.TypeRegA {
+			img.entries[path.Clean(hdr.Name)] = imageEntry{offset: r.offset, size: hdr.Size}
+		}
+	}
+	return img, nil
+}
+
+func (img *imageArchive) close() {
+	img.f.Close()
+}
+
+// open returns the contents of the file named name in the image tar.
+func (img *imageArchive) open(name string) (*io.SectionReader, error) {
+	e, ok := img.entries[path.Clean(name)]
+	if !ok {
+		return nil, fmt.Errorf("%s is missing from the image", name)
+	}
+	return io.NewSectionReader(img.f, e.offset, e.size), nil
+}
+
+// readJSON decodes the JSON file named name in the image tar into v.
+func (img *imageArchive) readJSON(name string, v any) error {
+	r, err := img.open(name)
+	if err != nil {
+		return err
+	}
+	if err := json.NewDecoder(io.LimitReader(r, imageMetadataLimit)).Decode(v); err != nil {
+		return fmt.Errorf("invalid %s: %w", name, err)
+	}
+	return nil
+}
+
+// images returns the layers of each image in the archive, bottom first.
+// The manifest.json written by docker save is used if there is one, and
+// the index.json of the OCI image layout otherwise.
+func (img *imageArchive) images() ([][]imageLayer, error) {
+	if _, ok := img.entries["manifest.json"]; ok {
+		return img.dockerImages()
+	}
+	if _, ok := img.entries["index.json"]; ok {
+		var index ociIndex
+		if err := img.readJSON("index.json", &index); err != nil {
+			return nil, err
+		}
+		var images [][]imageLayer
+		if err := img.ociImages(index, &images, 0); err != nil {
+			return nil, err
+		}
+		return images, nil
+	}
+	return nil, errors.New("not an image archive: it has neither a manifest.json nor an index.json")
+}
+
+// dockerManifest is an image listed in the manifest.json of docker save.
+type dockerManifest struct {
+	Config string
+	Layers []string
+}
+
+// dockerImages returns the layers of each image in manifest.json. Layers
+// stored as OCI blobs are named by their digest; older archives store them
+// as <id>/layer.tar, and name them by the diff ID in the image config.
+func (img *imageArchive) dockerImages() ([][]imageLayer, error) {
+	var manifests []dockerManifest
+	if err := img.readJSON("manifest.json", &manifests); err != nil {
+		return nil, err
+	}
+	var images [][]imageLayer
+	for _, m := range manifests {
+		var config struct {
+			RootFS struct {
+				DiffIDs []string `json:"diff_ids"`
+			} `json:"rootfs"`
+		}
+		if m.Config != "" {
+			// The config only supplies names; layers are still read
+			// without it.
+			img.readJSON(m.Config, &config)
+		}
+		layers := make([]imageLayer, len(m.Layers))
+		for i, file := range m.Layers {
+			layers[i] = imageLayer{file: file, digest: blobDigest(file)}
+			if layers[i].digest == "" {
+				if i < len(config.RootFS.DiffIDs) {
+					layers[i].digest = config.RootFS.DiffIDs[i]
+				} else {
+					layers[i].digest = path.Dir(file)
+				}
+			}
+		}
+		images = append(images, layers)
+	}
+	return images, nil
+}
+
+// ociDescriptor points to a blob of an OCI image layout.
+type ociDescriptor struct {
+	MediaType string `json:"mediaType"`
+	Digest    string `json:"digest"`
+}
+
+// ociIndex is an OCI image index, or an image manifest, which lists
+// layers instead of manifests.
+type ociIndex struct {
+	MediaType string          `json:"mediaType"`
+	Manifests []ociDescriptor `json:"manifests"`
+	Layers    []ociDescriptor `json:"layers"`
+}
+
+// ociImages appends the layers of each image manifest below index,
+// following nested indexes, such as those of multi-platform images, up to
+// a few levels deep.
+func (img *imageArchive) ociImages(index ociIndex, images *[][]imageLayer, depth int) error {
+	if depth > 4 {
+		return errors.New("image indexes are nested too deeply")
+	}
+	for _, d := range index.Manifests {
+		file, err := blobPath(d.Digest)
+		if err != nil {
+			return err
+		}
+		var m ociIndex
+		if err := img.readJSON(file, &m); err != nil {
+			return err
+		}
+		if d.MediaType == ociImageIndex || d.MediaType == dockerManifestList || len(m.Manifests) > 0 {
+			if err := img.ociImages(m, images, depth+1); err != nil {
+				return err
+			}
+			continue
+		}
+		layers := make([]imageLayer, 0, len(m.Layers))
+		for _, l := range m.Layers {
+			file, err := blobPath(l.Digest)
+			if err != nil {
+				return err
+			}
+			layers = append(layers, imageLayer{file: file, digest: l.Digest})
+		}
+		*images = append(*images, layers)
+	}
+	return nil
+}
+
+// blobPath returns where the OCI image layout stores the blob with digest.
+func blobPath(digest string) (string, error) {
+	algorithm, hex, ok := strings.Cut(digest, ":")
+	if !ok || algorithm == "" || hex == "" || strings.ContainsAny(digest, "/\\") {
+		return "", fmt.Errorf("invalid digest %q", digest)
+	}
+	return path.Join("blobs", algorithm, hex), nil
+}
+
+// blobDigest returns the digest of the blob stored at file in the OCI
+// image layout, or "" if file is not a blob.
+func blobDigest(file string) string {
+	parts := strings.Split(path.Clean(file), "/")
+	if len(parts) != 3 || parts[0] != "blobs" {
+		return ""
+	}
+	return parts[1] + ":" + parts[2]
+}
+
+// imageFilesystem tracks the paths hidden by the layers already read
+// while an image's layers are read from the top down: the paths they hold
+// or delete, which hide the same path in the layers below, and the files
+// replacing, directories emptying and paths deleting everything below
+// them.
+type imageFilesystem struct {
+	exact map[string]bool
+	below map[string]bool
+}
+
+// hidden reports whether the layers already read hide the entry at p in
+// the layers below them.
+func (seen *imageFilesystem) hidden(p string) bool {
+	if seen.exact[p] {
+		return true
+	}
+	for dir := p; dir != "/"; {
+		dir = path.Dir(dir)
+		if seen.below[dir] {
+			return true
+		}
+	}
+	return false
+}
+
+// scanImage checks the files an image in the archive at imagePath holds
+// once its layers are applied, skipping those a later layer deleted with
+// a whiteout or replaced, and reports each as
+// imagePath:layer-digest:/path, named by the layer holding it.
+func (s *scanner) scanImage(ctx, work context.Context, imagePath string) error {
+	img, err := openImage(imagePath)
+	if err != nil {
+		s.emit(scanResult{Path: imagePath, Error: err.Error(), ErrorCode: errorCode(err)})
+		return nil
+	}
+	defer img.close()
+
+	images, err := img.images()
+	if err != nil {
+		s.emit(scanResult{Path: imagePath, Error: err.Error(), ErrorCode: errorCode(err)})
+		return nil
+	}
+
+	// A match quarantines the image, as it does an archive.
+	s.source = imagePath
+	defer func() { s.source = "" }()
+
+	for _, layers := range images {
+		seen := &imageFilesystem{exact: make(map[string]bool), below: make(map[string]bool)}
+		for i := len(layers) - 1; i >= 0; i-- {
+			if err := s.scanLayer(ctx, work, img, layers[i], seen); err != nil {
+				return err
+			}
+		}
+	}
+	return ctx.Err()
+}
+
+// scanLayer checks the files of layer not hidden by the layers above it,
+// then adds what it holds and deletes to seen, for the layers below.
+func (s *scanner) scanLayer(ctx, work context.Context, img *imageArchive, layer imageLayer, seen *imageFilesystem) error {
+	name := img.path + ":" + layer.digest
+	r, err := img.open(layer.file)
+	if err != nil {
+		s.emit(scanResult{Path: name, Error: err.Error()})
+		return nil
+	}
+	br := bufio.NewReader(r)
+	var lr io.Reader = br
+	if head, _ := br.Peek(len(gzipMagic)); bytes.Equal(head, gzipMagic) {
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			s.emit(scanResult{Path: name, Error: fmt.Sprintf("failed to read gzip stream: %v", err)})
+			return nil
+		}
+		defer zr.Close()
+		lr = zr
+	}
+
+	// Whiteouts only apply to the layers below, so this layer's are held
+	// back until it has been read.
+	exact, below := make(map[string]bool), make(map[string]bool)
+	tr := tar.NewReader(lr)
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			s.emit(scanResult{Path: name, Error: fmt.Sprintf("failed to read layer: %v", err)})
+			return nil
+		}
+
+		p := path.Join("/", hdr.Name)
+		dir, base := path.Split(p)
+		dir = path.Clean(dir)
+		switch {
+		case base == opaqueWhiteout:
+			below[dir] = true
+			continue
+		case strings.HasPrefix(base, whiteoutPrefix):
+			deleted := path.Join(dir, strings.TrimPrefix(base, whiteoutPrefix))
+			exact[deleted], below[deleted] = true, true
+			continue
+		}
+		if seen.hidden(p) {
+			continue
+		}
+		exact[p] = true
+		if hdr.Typeflag != tar.TypeDir {
+			below[p] = true
+		}
+		s.scanImageEntry(work, name+":"+p, hdr, tr)
+	}
+
+	for p := range exact {
+		seen.exact[p] = true
+	}
+	for p := range below {
+		seen.below[p] = true
+	}
+	return nil
+}
+
+// scanImageEntry checks the layer entry described by hdr and read from r,
+// reporting it under name. Entries are filtered by extension, size and
+// type like files on disk, and archives among them opened as usual.
+func (s *scanner) scanImageEntry(ctx context.Context, name string, hdr *tar.Header, r io.Reader) {
+	switch hdr.Typeflag {
+	case tar.TypeReg, tar.TypeRegA:
+	case tar.TypeDir:
+		return
+	default:
+		s.note(name, tarTypeName(hdr.Typeflag)+" entry")
+		return
+	}
+	if hdr.Size == 0 || !s.wantedExt(name) || s.tooLarge(name, hdr.FileInfo()) {
+		return
+	}
+
+	s.stats.discovered.Add(1)
+	defer s.stats.processed.Add(1)
+	if hdr.Size > archiveMemberLimit {
+		s.emit(scanResult{Path: name, Error: errMemberLimit.Error() + "; skipped"})
+		return
+	}
+	budget := &extractBudget{remaining: s.config.MaxExtracted}
+	s.scanMember(ctx, name, r, 0, budget)
+	if budget.exhausted {
+		s.emit(scanResult{Path: name, Error: fmt.Sprintf("scan truncated: more than %s would be decompressed (see --max-extracted)", formatSize(s.config.MaxExtracted))})
+	}
+}
+
+// offsetReader tracks how far into r it has read or seeked.
+type offsetReader struct {
+	r      io.ReadSeeker
+	offset int64
+}
+
+func (o *offsetReader) Read(p []byte) (int, error) {
+	n, err := o.r.Read(p)
+	o.offset += int64(n)
+	return n, err
+}
+
+func (o *offsetReader) Seek(offset int64, whence int) (int64, error) {
+	n, err := o.r.Seek(offset, whence)
+	if err == nil {
+		o.offset = n
+	}
+	return n, err
+}
@@ -0,0 +1,378 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// imageBuilder writes an image archive, with its files added as they
+// would be by docker save or in the OCI image layout.
+type imageBuilder struct {
+	t       *testing.T
+	entries []archiveEntry
+}
+
+// blob adds data as a blob of the OCI image layout and returns its
+// digest and file name.
+func (b *imageBuilder) blob(data []byte) (digest, file string) {
+	sum := sha256.Sum256(data)
+	digest = "sha256:" + hex.EncodeToString(sum[:])
+	file = "blobs/sha256/" + hex.EncodeToString(sum[:])
+	if !slices.ContainsFunc(b.entries, func(e archiveEntry) bool { return e.name == file }) {
+		b.entries = append(b.entries, archiveEntry{name: file, data: data})
+	}
+	return digest, file
+}
+
+func (b *imageBuilder) json(name string, v any) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		b.t.Fatal(err)
+	}
+	b.entries = append(b.entries, archiveEntry{name: name, data: data})
+}
+
+func (b *imageBuilder) write(dir, name string) string {
+	return writeFile(b.t, dir, name, makeTar(b.t, b.entries...))
+}
+
+// ociManifest adds the manifest of an image with layers, bottom first,
+// and returns its digest.
+func (b *imageBuilder) ociManifest(layers ...[]byte) string {
+	type descriptor struct {
+		MediaType string `json:"mediaType"`
+		Digest    string `json:"digest"`
+		Size      int    `json:"size"`
+	}
+	config, _ := b.blob([]byte(`{"architecture":"amd64","os":"linux"}`))
+	m := struct {
+		SchemaVersion int          `json:"schemaVersion"`
+		MediaType     string       `json:"mediaType"`
+		Config        descriptor   `json:"config"`
+		Layers        []descriptor `json:"layers"`
+	}{SchemaVersion: 2, MediaType: "application/vnd.oci.image.manifest.v1+json", Config: descriptor{"application/vnd.oci.image.config.v1+json", config, 37}}
+	for _, l := range layers {
+		digest, _ := b.blob(l)
+		m.Layers = append(m.Layers, descriptor{"application/vnd.oci.image.layer.v1.tar", digest, len(l)})
+	}
+	data, _ := json.Marshal(m)
+	digest, _ := b.blob(data)
+	return digest
+}
+
+// ociIndexOf returns an image index listing manifests.
+func ociIndexOf(manifests ...string) map[string]any {
+	var list []map[string]string
+	for _, m := range manifests {
+		list = append(list, map[string]string{"mediaType": "application/vnd.oci.image.manifest.v1+json", "digest": m})
+	}
+	return map[string]any{"schemaVersion": 2, "mediaType": ociImageIndex, "manifests": list}
+}
+
+// dockerSave writes an image as docker save does since Docker 25: the
+// OCI image layout, with a manifest.json listing the layers as blobs.
+func dockerSave(t *testing.T, dir string, layers ...[]byte) (image string, digests []string) {
+	b := &imageBuilder{t: t}
+	_, configFile := b.blob([]byte(`{"rootfs":{"type":"layers","diff_ids":[]}}`))
+	var files []string
+	for _, l := range layers {
+		digest, file := b.blob(l)
+		digests, files = append(digests, digest), append(files, file)
+	}
+	b.json("manifest.json", []map[string]any{{"Config": configFile, "RepoTags": []string{"app:1.4"}, "Layers": files}})
+	b.json("index.json", ociIndexOf(b.ociManifest(layers...)))
+	b.json("oci-layout", map[string]string{"imageLayoutVersion": "1.0.0"})
+	return b.write(dir, "app.tar"), digests
+}
+
+// imageResults scans image and returns its results by path, with the
+// image path left out, and the summary.
+func imageResults(t *testing.T, image string, args ...string) (map[string]scanResult, jsonlSummary) {
+	t.Helper()
+	args = append([]string{"--scan-image", image, "--jsonl", "--max-distance", "30", "--workers", "1", "--db", writeTestDatabase(t)}, args...)
+	out, _, _, err := runCLI(t, args...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	results, summary := parseJSONL(t, out)
+	byPath := make(map[string]scanResult, len(results))
+	for _, r := range results {
+		p, ok := strings.CutPrefix(r.Path, image+":")
+		if !ok {
+			t.Errorf("result %s is not named after the image", r.Path)
+		}
+		byPath[p] = r
+	}
+	return byPath, summary
+}
+
+func sortedKeys(m map[string]scanResult) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+func TestScanImageWhiteouts(t *testing.T) {
+	clean := func(seed uint64) []byte { return sampleData(seed, 4096) }
+	base := makeTar(t,
+		archiveEntry{name: "usr/"},
+		archiveEntry{name: "usr/bin/"},
+		archiveEntry{name: "usr/bin/known", data: testSample},
+		archiveEntry{name: "usr/bin/tool", data: clean(1)},
+		archiveEntry{name: "etc/deleted.bin", data: testSample},
+		archiveEntry{name: "opt/app/old.exe", data: testSample},
+		archiveEntry{name: "opt/app/lib/old.so", data: clean(2)},
+		archiveEntry{name: "tmp/keep.bin", data: clean(3)},
+		archiveEntry{name: "var/cache/gone/a.bin", data: clean(4)},
+	)
+	// The second layer deletes a file and a directory, empties another
+	// with an opaque whiteout, and replaces a file with the known sample.
+	// The whiteouts only hide what is below, not the layer's own files.
+	top := gzipData(t, makeTar(t,
+		archiveEntry{name: "etc/.wh.deleted.bin"},
+		archiveEntry{name: "var/cache/.wh.gone"},
+		archiveEntry{name: "opt/app/.wh..wh..opq"},
+		archiveEntry{name: "opt/app/new.bin", data: clean(5)},
+		archiveEntry{name: "usr/bin/tool", data: testSample},
+		archiveEntry{name: "usr/bin/link", link: "known"},
+	))
+	// The third layer brings back a file deleted by the second.
+	restore := makeTar(t, archiveEntry{name: "etc/deleted.bin", data: clean(6)})
+
+	image, digests := dockerSave(t, t.TempDir(), base, top, restore)
+	byPath, summary := imageResults(t, image)
+
+	want := []string{
+		digests[0] + ":/tmp/keep.bin",
+		digests[0] + ":/usr/bin/known",
+		digests[1] + ":/opt/app/new.bin",
+		digests[1] + ":/usr/bin/tool",
+		digests[2] + ":/etc/deleted.bin",
+	}
+	slices.Sort(want)
+	if got := sortedKeys(byPath); !slices.Equal(got, want) {
+		t.Errorf("results:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+	for _, p := range []string{digests[0] + ":/usr/bin/known", digests[1] + ":/usr/bin/tool"} {
+		if m := byPath[p].Match; m == nil || m.FileName != "known.exe" || m.Distance != 0 {
+			t.Errorf("%s matched %+v, want known.exe at 0", p, m)
+		}
+	}
+	if m := byPath[digests[2]+":/etc/deleted.bin"].Match; m != nil {
+		t.Errorf("the restored file matched %+v, as if the deleted one were reported", m)
+	}
+	if summary.Files != 5 || summary.Matched != 2 || summary.Failed != 0 {
+		t.Errorf("summary %+v, want 5 files with 2 matches", summary)
+	}
+}
+
+func TestScanImageLayouts(t *testing.T) {
+	layer := makeTar(t, archiveEntry{name: "app/known.exe", data: testSample})
+	other := makeTar(t, archiveEntry{name: "app/other.bin", data: sampleData(8, 4096)})
+	layerDigest := func(data []byte) string {
+		sum := sha256.Sum256(data)
+		return "sha256:" + hex.EncodeToString(sum[:])
+	}
+
+	tests := []struct {
+		name  string
+		build func(b *imageBuilder)
+		want  []string
+	}{
+		{
+			// docker save before Docker 25: each layer in <id>/layer.tar,
+			// named by the diff ID the config gives it.
+			"legacy docker save",
+			func(b *imageBuilder) {
+				b.entries = append(b.entries, archiveEntry{name: "5f3a/layer.tar", data: layer})
+				b.json("cfg.json", map[string]any{"rootfs": map[string]any{"type": "layers", "diff_ids": []string{"sha256:diffid"}}})
+				b.json("manifest.json", []map[string]any{{"Config": "cfg.json", "Layers": []string{"5f3a/layer.tar"}}})
+			},
+			[]string{"sha256:diffid:/app/known.exe"},
+		},
+		{
+			// Without a config, the layer is named by its directory.
+			"legacy docker save without config",
+			func(b *imageBuilder) {
+				b.entries = append(b.entries, archiveEntry{name: "5f3a/layer.tar", data: layer})
+				b.json("manifest.json", []map[string]any{{"Layers": []string{"5f3a/layer.tar"}}})
+			},
+			[]string{"5f3a:/app/known.exe"},
+		},
+		{
+			"OCI layout",
+			func(b *imageBuilder) {
+				b.json("index.json", ociIndexOf(b.ociManifest(layer)))
+				b.json("oci-layout", map[string]string{"imageLayoutVersion": "1.0.0"})
+			},
+			[]string{layerDigest(layer) + ":/app/known.exe"},
+		},
+		{
+			// A multi-platform image has an index of indexes; each image
+			// below it is scanned on its own.
+			"OCI multi-platform index",
+			func(b *imageBuilder) {
+				data, _ := json.Marshal(ociIndexOf(b.ociManifest(layer), b.ociManifest(other)))
+				nested, _ := b.blob(data)
+				b.json("index.json", map[string]any{"schemaVersion": 2, "manifests": []map[string]string{{"mediaType": ociImageIndex, "digest": nested}}})
+			},
+			[]string{layerDigest(layer) + ":/app/known.exe", layerDigest(other) + ":/app/other.bin"},
+		},
+		{
+			"docker save listing two images",
+			func(b *imageBuilder) {
+				_, f1 := b.blob(layer)
+				_, f2 := b.blob(other)
+				b.json("manifest.json", []map[string]any{{"Layers": []string{f1}}, {"Layers": []string{f2}}})
+			},
+			[]string{layerDigest(layer) + ":/app/known.exe", layerDigest(other) + ":/app/other.bin"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := &imageBuilder{t: t}
+			tt.build(b)
+			byPath, summary := imageResults(t, b.write(t.TempDir(), "image.tar"))
+			slices.Sort(tt.want)
+			if got := sortedKeys(byPath); !slices.Equal(got, tt.want) {
+				t.Errorf("results %q, want %q", got, tt.want)
+			}
+			if summary.Matched != 1 || summary.Failed != 0 {
+				t.Errorf("summary %+v, want one match", summary)
+			}
+		})
+	}
+}
+
+func TestScanImageGates(t *testing.T) {
+	typed := func(magic string, seed uint64, n int) []byte {
+		return append([]byte(magic), sampleData(seed, n)...)
+	}
+	layer := makeTar(t,
+		archiveEntry{name: "bin/tool", data: typed("\x7fELF", 20, 4096)},
+		archiveEntry{name: "bin/big", data: typed("\x7fELF", 21, 64<<10)},
+		archiveEntry{name: "bin/run.sh", data: typed("#!/bin/sh\n", 22, 4096)},
+		archiveEntry{name: "srv/app.exe", data: typed("MZ", 23, 4096)},
+		archiveEntry{name: "srv/notes.log", data: typed("2024-03-01 ", 24, 4096)},
+		archiveEntry{name: "srv/bundle.tar", data: makeTar(t,
+			archiveEntry{name: "known.exe", data: testSample},
+			archiveEntry{name: "lib.so", data: typed("\x7fELF", 25, 4096)},
+		)},
+		archiveEntry{name: "srv/tiny.bin", data: []byte("too small for TLSH")},
+	)
+	image, digests := dockerSave(t, t.TempDir(), layer)
+	at := func(p string) string { return digests[0] + ":" + p }
+
+	tests := []struct {
+		name    string
+		args    []string
+		want    []string
+		summary jsonlSummary
+	}{
+		{"everything", nil,
+			[]string{at("/bin/big"), at("/bin/run.sh"), at("/bin/tool"), at("/srv/app.exe"), at("/srv/bundle.tar!known.exe"), at("/srv/bundle.tar!lib.so"), at("/srv/notes.log")},
+			jsonlSummary{TooSmall: 1}},
+		{"size cap", []string{"--max-file-size", "32K"},
+			[]string{at("/bin/run.sh"), at("/bin/tool"), at("/srv/app.exe"), at("/srv/bundle.tar!known.exe"), at("/srv/bundle.tar!lib.so"), at("/srv/notes.log")},
+			jsonlSummary{Oversized: 1, TooSmall: 1}},
+		{"types", []string{"--types", "elf"},
+			[]string{at("/bin/big"), at("/bin/tool"), at("/srv/bundle.tar!lib.so")},
+			jsonlSummary{SkippedType: 5}},
+		{"extensions", []string{"--only-ext", "exe,tar"},
+			[]string{at("/srv/app.exe"), at("/srv/bundle.tar!known.exe"), at("/srv/bundle.tar!lib.so")},
+			jsonlSummary{}},
+		{"archives not opened", []string{"--archive-depth", "0"},
+			[]string{at("/bin/big"), at("/bin/run.sh"), at("/bin/tool"), at("/srv/app.exe"), at("/srv/bundle.tar"), at("/srv/notes.log")},
+			jsonlSummary{TooSmall: 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			byPath, summary := imageResults(t, image, tt.args...)
+			if got := sortedKeys(byPath); !slices.Equal(got, tt.want) {
+				t.Errorf("results:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+			}
+			if summary.Oversized != tt.summary.Oversized || summary.SkippedType != tt.summary.SkippedType || summary.TooSmall != tt.summary.TooSmall {
+				t.Errorf("%d too large, %d skipped by type, %d too small; want %d, %d and %d",
+					summary.Oversized, summary.SkippedType, summary.TooSmall, tt.summary.Oversized, tt.summary.SkippedType, tt.summary.TooSmall)
+			}
+			if m := byPath[at("/srv/bundle.tar!known.exe")].Match; slices.Contains(tt.want, at("/srv/bundle.tar!known.exe")) && (m == nil || m.FileName != "known.exe") {
+				t.Errorf("the planted sample in the nested archive matched %+v", m)
+			}
+		})
+	}
+}
+
+func TestScanImageErrors(t *testing.T) {
+	dir := t.TempDir()
+	layer := makeTar(t, archiveEntry{name: "app/known.exe", data: testSample})
+
+	notImage := writeFile(t, dir, "plain.tar", makeTar(t, archiveEntry{name: "known.exe", data: testSample}))
+	missing := &imageBuilder{t: t, entries: []archiveEntry{{name: "blobs/sha256/abc", data: layer}}}
+	missing.json("manifest.json", []map[string]any{{"Layers": []string{"blobs/sha256/abc", "blobs/sha256/def"}}})
+	badDigest := &imageBuilder{t: t}
+	badDigest.json("index.json", map[string]any{"manifests": []map[string]string{{"digest": "sha256:../../etc/passwd"}}})
+	badJSON := &imageBuilder{t: t, entries: []archiveEntry{{name: "manifest.json", data: []byte("{not json")}}}
+	corrupt := &imageBuilder{t: t, entries: []archiveEntry{{name: "l/layer.tar", data: append(gzipData(t, layer)[:30:30], "garbage"...)}}}
+	corrupt.json("manifest.json", []map[string]any{{"Layers": []string{"l/layer.tar"}}})
+
+	tests := []struct {
+		name, image string
+		path, want  string
+		matched     int64
+	}{
+		{"not an image", notImage, notImage, "not an image archive", 0},
+		{"not a tar", writeFile(t, dir, "junk.tar", sampleData(30, 4096)), "", "failed to read image archive", 0},
+		{"missing layer", missing.write(dir, "missing.tar"), "def", "blobs/sha256/def is missing from the image", 1},
+		{"invalid digest", badDigest.write(dir, "digest.tar"), "", `invalid digest "sha256:../../etc/passwd"`, 0},
+		{"invalid manifest", badJSON.write(dir, "json.tar"), "", "invalid manifest.json", 0},
+		{"corrupt layer", corrupt.write(dir, "corrupt.tar"), "l", "", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, _, st, err := runCLI(t, "--scan-image", tt.image, "--jsonl", "--max-distance", "30", "--db", writeTestDatabase(t))
+			if err != nil {
+				t.Fatal(err)
+			}
+			results, summary := parseJSONL(t, out)
+			var failed []scanResult
+			for _, r := range results {
+				if r.Error != "" {
+					failed = append(failed, r)
+				}
+			}
+			if len(failed) != 1 || !strings.Contains(failed[0].Error, tt.want) || !strings.HasPrefix(failed[0].Path, tt.image) || !strings.HasSuffix(failed[0].Path, tt.path) {
+				t.Errorf("errors %+v, want one for %s containing %q", failed, tt.path, tt.want)
+			}
+			if summary.Matched != tt.matched || st == statusOK {
+				t.Errorf("status %v, %d matched; want a failure and %d matched", st, summary.Matched, tt.matched)
+			}
+		})
+	}
+}
+
+func TestScanImageFlags(t *testing.T) {
+	image, _ := dockerSave(t, t.TempDir(), makeTar(t, archiveEntry{name: "known.exe", data: testSample}))
+	for _, tt := range []struct {
+		args []string
+		want string
+	}{
+		{[]string{"--scan-image", image, t.TempDir()}, "--scan-image takes a single image archive and no other paths"},
+		{[]string{"--scan-image", image, "--max-depth", "1"}, "--scan-image cannot be combined with"},
+		{[]string{"--scan-image", image, "--skip-hidden"}, "--scan-image cannot be combined with"},
+		{[]string{"--scan-image", image, "--exclude", "*.so"}, "--scan-image cannot be combined with"},
+		{[]string{"--scan-image", image, "--hash-cache", filepath.Join(t.TempDir(), "c")}, "--scan-image cannot be combined with"},
+	} {
+		code, stderr := runMain(t, tt.args...)
+		if code != exitError || !strings.Contains(stderr, tt.want) {
+			t.Errorf("%v: exit %d, stderr %q; want %q", tt.args[2:], code, stderr, tt.want)
+		}
+	}
+}
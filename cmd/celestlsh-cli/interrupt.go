@@ -0,0 +1,24 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// interruptGrace is how long files already being hashed may take to finish
+// once the run is interrupted.
+const interruptGrace = 5 * time.Second
+
+// graceContext returns a context for work in progress that is cancelled
+// interruptGrace after ctx is, so that an interrupted run can finish the
+// files it has started without waiting indefinitely on a slow one.
+func graceContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	work, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	stop := context.AfterFunc(ctx, func() {
+		time.AfterFunc(interruptGrace, cancel)
+	})
+	return work, func() {
+		stop()
+		cancel()
+	}
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// junitTestSuites is the JUnit XML report written by --format junit, in the
+// subset that GitLab, Jenkins and other CI servers read: one suite, with a
+// test case per scanned file that fails when the file matched.
+type junitTestSuites struct {
+	XMLName  xml.Name         `xml:"testsuites"`
+	Name     string           `xml:"name,attr"`
+	Tests    int              `xml:"tests,attr"`
+	Failures int              `xml:"failures,attr"`
+	Errors   int              `xml:"errors,attr"`
+	Skipped  int              `xml:"skipped,attr"`
+	Time     string           `xml:"time,attr"`
+	Suites   []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name      string          `xml:"name,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Failures  int             `xml:"failures,attr"`
+	Errors    int             `xml:"errors,attr"`
+	Skipped   int             `xml:"skipped,attr"`
+	Time      string          `xml:"time,attr"`
+	Timestamp string          `xml:"timestamp,attr"`
+	Hostname  string          `xml:"hostname,attr,omitempty"`
+	Cases     []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitProblem `xml:"failure,omitempty"`
+	Error     *junitProblem `xml:"error,omitempty"`
+	Skipped   *junitProblem `xml:"skipped,omitempty"`
+}
+
+// junitProblem is the failure, error or skip of a test case: a one-line
+// message, and for failures the details of the match as text.
+type junitProblem struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr,omitempty"`
+	Text    string `xml:",chardata"`
+}
+
+// junitClassName groups the test cases of a scan in CI test views.
+const junitClassName = "celestlsh.scan"
+
+// junitDocument builds a JUnit XML report, for release pipelines that gate
+// on red-team tools found in their artifacts. Each scanned file is a test
+// case named by its path, failing when it matched within --max-distance
+// and erroring when it could not be checked; skipped files and directories
+// are skipped test cases. Skips are recorded by workers while results
+// arrive, hence the lock.
+type junitDocument struct {
+	start time.Time
+
+	mu    sync.Mutex
+	cases []junitTestCase
+}
+
+func newJUnitDocument() *junitDocument {
+	return &junitDocument{start: time.Now()}
+}
+
+func (d *junitDocument) add(result scanResult) {
+	c := junitTestCase{Name: displayPath(result.Path), ClassName: junitClassName, Time: "0"}
+	switch {
+	case result.Error != "":
+		c.Error = &junitProblem{Message: result.Error, Type: result.ErrorCode}
+	case result.Match != nil:
+		m := result.Match
+		c.Failure = &junitProblem{
+			Message: fmt.Sprintf("Matches %s %s version %s at TLSH distance %d%s", m.RepoName, m.FileName, m.Version, m.Distance, matchNote(result)),
+			Type:    "tlsh-match",
+			Text:    junitMatchDetails(result),
+		}
+	}
+	d.mu.Lock()
+	d.cases = append(d.cases, c)
+	d.mu.Unlock()
+}
+
+// skip records a file or directory the scan skipped.
+func (d *junitDocument) skip(path, reason string) {
+	d.mu.Lock()
+	d.cases = append(d.cases, junitTestCase{Name: displayPath(path), ClassName: junitClassName, Time: "0", Skipped: &junitProblem{Message: reason}})
+	d.mu.Unlock()
+}
+
+// junitMatchDetails describes the match of result, and with --group-by-repo
+// the best match of every repository, one field per line.
+func junitMatchDetails(result scanResult) string {
+	m := result.Match
+	details := fmt.Sprintf("File: %s\nTLSH: %s\n", displayPath(result.Path), result.TLSH)
+	if result.SHA256 != "" {
+		details += fmt.Sprintf("SHA256: %s\n", result.SHA256)
+	}
+	details += fmt.Sprintf("Matched repository: %s\nMatched file: %s\nVersion: %s\nDistance: %d%s\nMatched SHA256: %s\nMatched TLSH: %s\n",
+		m.RepoName, m.FileName, m.Version, m.Distance, matchNote(result), m.SHA256Hash, m.TLSHHash)
+	if m.Intel != "" {
+		details += fmt.Sprintf("Intel: %s\n", m.Intel)
+	}
+	if len(result.Repos) > 1 {
+		for _, r := range result.Repos[1:] {
+			details += fmt.Sprintf("Also matches: %s %s version %s at distance %d (%d records)\n", r.RepoName, r.FileName, r.Version, r.Distance, r.Count)
+		}
+	}
+	return details
+}
+
+func (d *junitDocument) write(w io.Writer) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	elapsed := fmt.Sprintf("%.3f", time.Since(d.start).Seconds())
+	suite := junitTestSuite{
+		Name:      "CelesTLSH scan",
+		Tests:     len(d.cases),
+		Time:      elapsed,
+		Timestamp: d.start.UTC().Format("2006-01-02T15:04:05"),
+		Cases:     d.cases,
+	}
+	suite.Hostname, _ = os.Hostname()
+	for _, c := range d.cases {
+		switch {
+		case c.Failure != nil:
+			suite.Failures++
+		case c.Error != nil:
+			suite.Errors++
+		case c.Skipped != nil:
+			suite.Skipped++
+		}
+	}
+	suites := junitTestSuites{
+		Name:     "celestlsh-cli",
+		Tests:    suite.Tests,
+		Failures: suite.Failures,
+		Errors:   suite.Errors,
+		Skipped:  suite.Skipped,
+		Time:     elapsed,
+		Suites:   []junitTestSuite{suite},
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(suites); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// matchNote is describeMatch in parentheses after a distance, or "" when
+// there is nothing to add.
+func matchNote(result scanResult) string {
+	if d := describeMatch(result); d != "" {
+		return " (" + d + ")"
+	}
+	return ""
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/xml"
+	"io"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// junitSchema lists, for each element of the JUnit XML schema that Jenkins
+// publishes and GitLab reads, the attributes and child elements it allows.
+var junitSchema = map[string]struct{ attrs, children []string }{
+	"testsuites": {[]string{"name", "time", "tests", "failures", "disabled", "errors", "skipped"}, []string{"testsuite"}},
+	"testsuite": {[]string{"name", "tests", "failures", "errors", "group", "time", "disabled", "skipped", "timestamp", "hostname", "id", "package", "file", "log", "url", "version"},
+		[]string{"properties", "testcase", "system-out", "system-err"}},
+	"testcase": {[]string{"name", "assertions", "time", "classname", "status", "group", "file", "line"}, []string{"skipped", "error", "failure", "system-out", "system-err"}},
+	"failure":  {[]string{"message", "type"}, nil},
+	"error":    {[]string{"message", "type"}, nil},
+	"skipped":  {[]string{"message"}, nil},
+}
+
+// validateJUnit checks that a report only uses the elements and attributes
+// of junitSchema, in the places it allows them.
+func validateJUnit(t *testing.T, report string) {
+	t.Helper()
+	dec := xml.NewDecoder(strings.NewReader(report))
+	var stack []string
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("invalid XML: %v\n%s", err, report)
+		}
+		switch tok := tok.(type) {
+		case xml.StartElement:
+			name := tok.Name.Local
+			schema, ok := junitSchema[name]
+			switch {
+			case !ok:
+				t.Errorf("unknown element <%s>", name)
+			case len(stack) == 0 && name != "testsuites":
+				t.Errorf("root element <%s>, want <testsuites>", name)
+			case len(stack) > 0 && !slices.Contains(junitSchema[stack[len(stack)-1]].children, name):
+				t.Errorf("<%s> inside <%s>", name, stack[len(stack)-1])
+			}
+			for _, a := range tok.Attr {
+				if !slices.Contains(schema.attrs, a.Name.Local) {
+					t.Errorf("attribute %s on <%s>", a.Name.Local, name)
+				}
+			}
+			stack = append(stack, name)
+		case xml.EndElement:
+			stack = stack[:len(stack)-1]
+		}
+	}
+}
+
+func TestScanJUnit(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "bin/tool.exe", testSample)
+	writeFile(t, dir, "bin/clean.bin", sampleData(9, 8192))
+	writeFile(t, dir, "bad.zip", []byte("PK\x03\x04 not really a zip archive"))
+	writeFile(t, dir, "disk.iso", sampleData(10, 8192))
+	writeFile(t, dir, "tiny.txt", []byte("too small"))
+
+	out, _, st, err := runCLI(t, "-s", "--format", "junit", "--max-distance", "30", "--skip-ext", ".iso", "--db", writeTestDatabase(t), dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st != statusMatch {
+		t.Errorf("status %v, want a match", st)
+	}
+	if !strings.HasPrefix(out, xml.Header) {
+		t.Errorf("report does not start with an XML declaration:\n%s", out)
+	}
+	validateJUnit(t, out)
+
+	var report junitTestSuites
+	if err := xml.Unmarshal([]byte(out), &report); err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Suites) != 1 {
+		t.Fatalf("%d test suites, want 1", len(report.Suites))
+	}
+	suite := report.Suites[0]
+	if report.Tests != 5 || report.Failures != 1 || report.Errors != 1 || report.Skipped != 2 ||
+		suite.Tests != report.Tests || suite.Failures != report.Failures || suite.Errors != report.Errors || suite.Skipped != report.Skipped {
+		t.Errorf("counts: suites %d tests, %d failures, %d errors, %d skipped; suite %d, %d, %d, %d; want 5, 1, 1, 2 in both",
+			report.Tests, report.Failures, report.Errors, report.Skipped, suite.Tests, suite.Failures, suite.Errors, suite.Skipped)
+	}
+	if _, err := strconv.ParseFloat(suite.Time, 64); err != nil {
+		t.Errorf("suite time %q is not a number of seconds", suite.Time)
+	}
+
+	cases := map[string]junitTestCase{}
+	for _, c := range suite.Cases {
+		rel, _ := filepath.Rel(dir, c.Name)
+		cases[filepath.ToSlash(rel)] = c
+		if c.ClassName != junitClassName {
+			t.Errorf("%s: classname %q", c.Name, c.ClassName)
+		}
+	}
+	tool := cases["bin/tool.exe"]
+	if tool.Failure == nil || tool.Failure.Type != "tlsh-match" ||
+		tool.Failure.Message != "Matches KnownTool known.exe version v1.0 at TLSH distance 0 (high confidence, similar (TLSH only))" ||
+		!strings.Contains(tool.Failure.Text, "Matched repository: KnownTool\n") ||
+		!strings.Contains(tool.Failure.Text, "Intel: https://example.com/KnownTool\n") {
+		t.Errorf("tool.exe: %+v, want a failure with the match", tool.Failure)
+	}
+	if clean, ok := cases["bin/clean.bin"]; !ok || clean.Failure != nil || clean.Error != nil || clean.Skipped != nil {
+		t.Errorf("clean.bin: %+v, %v; want a passing test case", clean, ok)
+	}
+	if bad := cases["bad.zip"]; bad.Error == nil || !strings.Contains(bad.Error.Message, "zip") {
+		t.Errorf("bad.zip: %+v, want an error", bad)
+	}
+	if iso := cases["disk.iso"]; iso.Skipped == nil || iso.Skipped.Message != "extension .iso skipped with --skip-ext" {
+		t.Errorf("disk.iso: %+v, want skipped by extension", iso)
+	}
+	if tiny := cases["tiny.txt"]; tiny.Skipped == nil || !strings.Contains(tiny.Skipped.Message, "too small") {
+		t.Errorf("tiny.txt: %+v, want skipped as too small", tiny)
+	}
+
+	// Without a match, the suite passes and so does the scan.
+	out, _, st, err = runCLI(t, "-s", "--format", "junit", "--max-distance", "30", "--db", writeTestDatabase(t), filepath.Join(dir, "bin", "clean.bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	validateJUnit(t, out)
+	if st != statusOK || !strings.Contains(out, `<testsuites name="celestlsh-cli" tests="1" failures="0" errors="0" skipped="0"`) {
+		t.Errorf("status %v, report:\n%s\nwant one passing test", st, out)
+	}
+}
+
+func TestJUnitFlags(t *testing.T) {
+	_, stderr := runMain(t, "-c", "--format", "junit", testRecords(t)[0].TLSHHash)
+	if !strings.Contains(stderr, "--format junit only applies to scan mode") {
+		t.Errorf("stderr = %q", stderr)
+	}
+}
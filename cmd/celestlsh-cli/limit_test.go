@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/Magonia-Research/CelesTLSH-CLI/pkg/celestlsh"
+)
+
+// copiesDatabase writes a database of n records of the hash of known.exe
+// under different names, all at distance 0 from it.
+func copiesDatabase(t *testing.T, n int) string {
+	t.Helper()
+	known := testRecords(t)[0]
+	records := make([]celestlsh.HashRecord, n)
+	for i := range records {
+		records[i] = known
+		records[i].FileName = fmt.Sprintf("copy%d.exe", i)
+	}
+	return writeTestDatabase(t, records...)
+}
+
+func TestCheckLimit(t *testing.T) {
+	db := copiesDatabase(t, 10)
+	hash := testRecords(t)[0].TLSHHash
+
+	for _, tt := range []struct {
+		limit     string
+		identical int
+		truncated bool
+	}{
+		{"3", 2, true},
+		{"10", 9, false},
+		{"50", 9, false},
+	} {
+		t.Run(tt.limit, func(t *testing.T) {
+			out, _, _, err := runCLI(t, "-c", "--json", "--limit", tt.limit, "--db", db, hash)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var results []checkResult
+			if err := json.Unmarshal([]byte(out), &results); err != nil {
+				t.Fatalf("%v in output:\n%s", err, out)
+			}
+			r := results[0]
+			if r.Match == nil || r.Match.FileName != "copy0.exe" || len(r.Identical) != tt.identical || r.IdenticalTruncated != tt.truncated {
+				t.Errorf("output:\n%s\nwant copy0.exe and %d identical, truncated %v", out, tt.identical, tt.truncated)
+			}
+
+			out, _, _, err = runCLI(t, "-c", "--limit", tt.limit, "--db", db, hash)
+			if err != nil {
+				t.Fatal(err)
+			}
+			note := strings.Contains(out, "more records at this distance not shown; raise --limit to list them")
+			if note != tt.truncated {
+				t.Errorf("output:\n%s\nwant the truncation noted: %v", out, tt.truncated)
+			}
+		})
+	}
+}
+
+func TestServeLimit(t *testing.T) {
+	hash := testRecords(t)[0].TLSHHash
+	for _, tt := range []struct {
+		limit, top         int
+		matches, truncated int
+	}{
+		{2, 10, 2, 2},
+		{2, 2, 2, 0},
+		{10, 3, 3, 0},
+	} {
+		t.Run(fmt.Sprintf("limit %d top %d", tt.limit, tt.top), func(t *testing.T) {
+			_, ts := newTestServer(t, "--limit", fmt.Sprint(tt.limit))
+			resp, err := http.Post(ts.URL+"/check", "application/json", strings.NewReader(fmt.Sprintf(`{"tlsh":%q,"top":%d}`, hash, tt.top)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			var got checkResponse
+			if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+				t.Fatal(err)
+			}
+			// The test database has 4 records, so top 10 leaves out 2
+			// beyond a limit of 2.
+			if len(got.Matches) != tt.matches || got.Truncated != tt.truncated {
+				t.Errorf("%d matches, truncated %d; want %d, %d", len(got.Matches), got.Truncated, tt.matches, tt.truncated)
+			}
+			if len(got.Matches) > 0 && got.Matches[0].FileName != "known.exe" {
+				t.Errorf("closest match %s, want known.exe", got.Matches[0].FileName)
+			}
+		})
+	}
+}
+
+func TestCrossCheckLimit(t *testing.T) {
+	dir := t.TempDir()
+	var hashes []string
+	for _, r := range testRecords(t) {
+		hashes = append(hashes, r.TLSHHash)
+	}
+	listA := writeFile(t, dir, "a.txt", []byte(hashes[0]+"\n"+hashes[2]+"\n"))
+	listB := writeFile(t, dir, "b.txt", []byte(strings.Join(hashes, "\n")+"\n"))
+
+	out, stderr, _, err := runCLI(t, "--cross-check", "--csv", "--max-distance", "1000", "--limit", "2", listA, listB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rows := readCSV(t, out); len(rows) != 1+2*2 {
+		t.Errorf("output:\n%s\nwant a header and 2 pairs for each of 2 hashes", out)
+	}
+	if !strings.Contains(stderr, "Warning: 2 hashes had more than 2 pairs; only the closest 2 of each are listed") {
+		t.Errorf("stderr = %q, want the truncation warned about", stderr)
+	}
+
+	_, stderr, _, err = runCLI(t, "--cross-check", "--csv", "--max-distance", "1000", "--limit", "4", listA, listB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(stderr, "Warning") {
+		t.Errorf("stderr = %q, want no warning without truncation", stderr)
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/Magonia-Research/CelesTLSH-CLI/pkg/celestlsh"
+)
+
+// setupLogging installs the default slog logger for -v/--verbose, --debug
+// and --log-file, returning a function that closes the log file. Without
+// any of them log messages are discarded. Logs never go to stdout, so they
+// are unaffected by --quiet and never mix with results.
+func setupLogging(config Config) (func(), error) {
+	level := slog.LevelInfo
+	switch {
+	case config.Debug:
+		level = slog.LevelDebug
+	case !config.Verbose && config.LogFile == "":
+		slog.SetDefault(slog.New(slog.DiscardHandler))
+		return func() {}, nil
+	}
+
+	w, closeLog := os.Stderr, func() {}
+	if config.LogFile != "" {
+		f, err := os.OpenFile(config.LogFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open log file: %w", err)
+		}
+		w, closeLog = f, func() { f.Close() }
+	}
+
+	slog.SetDefault(slog.New(&progressSafeHandler{slog.NewTextHandler(w, &slog.HandlerOptions{Level: level})}))
+	return closeLog, nil
+}
+
+// progressSafeHandler clears the progress line before each message, which
+// would otherwise be drawn over it on stderr.
+type progressSafeHandler struct {
+	slog.Handler
+}
+
+func (h *progressSafeHandler) Handle(ctx context.Context, r slog.Record) error {
+	clearProgress()
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h *progressSafeHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &progressSafeHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h *progressSafeHandler) WithGroup(name string) slog.Handler {
+	return &progressSafeHandler{h.Handler.WithGroup(name)}
+}
+
+// logDatabase logs where the database at path was loaded from, what it
+// holds and how long it took, and at debug level the rows it skipped.
+func logDatabase(path string, db *celestlsh.Database, elapsed time.Duration) {
+	switch abs, err := filepath.Abs(path); {
+	case path == stdinDatabase:
+		path = "stdin"
+	case err == nil:
+		path = abs
+	}
+	stats := db.LoadStats()
+	slog.Info("database loaded", "path", path, "rows", stats.Rows, "records", db.Len(),
+		"skipped_short", stats.ShortRows, "skipped_no_hash", stats.MissingHash,
+		"skipped_invalid_hash", stats.InvalidHash, "filtered", stats.Filtered, "duration", elapsed)
+	for _, row := range stats.Skipped {
+		slog.Debug("database row skipped", "path", path, "line", row.Line, "reason", row.Reason)
+	}
+}
+
+// loggingTransport logs a summary of every HTTP request at debug level.
+type loggingTransport struct {
+	next http.RoundTripper
+}
+
+// newLoggingTransport wraps next, or http.DefaultTransport if nil.
+func newLoggingTransport(next http.RoundTripper) http.RoundTripper {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &loggingTransport{next: next}
+}
+
+func (t *loggingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	slog.Debug("http request", "method", req.Method, "url", req.URL.Redacted(), "headers", headerNames(req.Header))
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		slog.Debug("http request failed", "method", req.Method, "url", req.URL.Redacted(), "error", err, "duration", time.Since(start))
+		return nil, err
+	}
+	slog.Debug("http response", "method", req.Method, "url", req.URL.Redacted(), "status", resp.StatusCode,
+		"content_length", resp.ContentLength, "content_type", resp.Header.Get("Content-Type"), "duration", time.Since(start))
+	return resp, nil
+}
+
+// headerNames lists the names of the headers of a request, for the log.
+// Their values are left out, as they may hold credentials.
+func headerNames(header http.Header) []string {
+	names := make([]string, 0, len(header))
+	for name := range header {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// logCheck logs the outcome of checking one file or archive member at
+// debug level.
+func logCheck(result scanResult, elapsed time.Duration) {
+	if !slog.Default().Enabled(context.Background(), slog.LevelDebug) {
+		return
+	}
+	attrs := []any{"path", result.Path, "bytes", result.size, "duration", elapsed}
+	switch {
+	case result.Error != "":
+		attrs = append(attrs, "error", result.Error)
+	case result.Match != nil:
+		attrs = append(attrs, "tlsh", result.TLSH, "match_repo", result.Match.RepoName, "match_file", result.Match.FileName,
+			"distance", result.Match.Distance, "suppressed", result.Suppressed)
+	default:
+		attrs = append(attrs, "tlsh", result.TLSH, "match", "none")
+	}
+	slog.Debug("file checked", attrs...)
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// logTestDatabase writes the test records and a row whose hash is invalid,
+// which loading skips.
+func logTestDatabase(t *testing.T) string {
+	t.Helper()
+	bad := testRecords(t)[0]
+	bad.FileName, bad.TLSHHash = "bad.exe", "not a hash"
+	return writeTestDatabase(t, append(testRecords(t), bad)...)
+}
+
+func TestDebugLogCheck(t *testing.T) {
+	code, stderr := runMain(t, "--debug", "-c", "--db", logTestDatabase(t), testRecords(t)[0].TLSHHash)
+	if code != exitMatch {
+		t.Fatalf("exit code %d, want %d:\n%s", code, exitMatch, stderr)
+	}
+	for _, want := range []string{
+		`level=INFO msg="database loaded"`, "rows=5", "records=4", "skipped_invalid_hash=1", "duration=",
+		`level=DEBUG msg="database row skipped"`, "line=6", "reason=",
+		`level=DEBUG msg="database searched"`, "max_distance=", "matches=",
+	} {
+		if !strings.Contains(stderr, want) {
+			t.Errorf("debug log lacks %q:\n%s", want, stderr)
+		}
+	}
+
+	// Verbose leaves the debug messages out.
+	_, stderr = runMain(t, "-v", "-c", "--db", logTestDatabase(t), testRecords(t)[0].TLSHHash)
+	if !strings.Contains(stderr, `msg="database loaded"`) || strings.Contains(stderr, "level=DEBUG") {
+		t.Errorf("verbose log:\n%s\nwant info messages only", stderr)
+	}
+	_, stderr = runMain(t, "-c", "--db", logTestDatabase(t), testRecords(t)[0].TLSHHash)
+	if strings.Contains(stderr, "level=") {
+		t.Errorf("log written without -v or --debug:\n%s", stderr)
+	}
+}
+
+func TestLogFileWithQuiet(t *testing.T) {
+	logFile := filepath.Join(t.TempDir(), "celestlsh.log")
+	closeLog, err := setupLogging(Config{Debug: true, LogFile: logFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := slog.Default()
+	defer slog.SetDefault(old)
+
+	out, stderr, _, err := runCLI(t, "--quiet", "-c", "--db", logTestDatabase(t), testRecords(t)[0].TLSHHash)
+	closeLog()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// --quiet only trims the results.
+	if strings.Count(out, "\n") != 1 || strings.Contains(out, "level=") || strings.Contains(stderr, "level=") {
+		t.Errorf("stdout %q, stderr %q; want one value and no log", out, stderr)
+	}
+	data, err := os.ReadFile(logFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{`msg="database loaded"`, `msg="database row skipped"`, `msg="database searched"`} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("log file lacks %q:\n%s", want, data)
+		}
+	}
+}
@@ -0,0 +1,2158 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/url"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"text/template"
+	"time"
+
+	"github.com/Magonia-Research/CelesTLSH-CLI/pkg/celestlsh"
+)
+
+type Config struct {
+	Mode  string
+	Hash1 string
+	// Hashes are the hashes checked in check mode.
+	Hashes    []string
+	Hash2     string
+	DbPath    string
+	Quiet     bool
+	OutputCSV bool
+	Listen    string
+	Socket    string
+	// Remote is the base URL of a serve mode instance that check and scan
+	// modes send hashes to instead of loading the database, which
+	// RemoteFallback "local" still uses when the server is unavailable.
+	Remote         string
+	RemoteFallback string
+	// GRPC is the address of a serve mode instance's --grpc-listen, used
+	// as Remote is; GRPCTLS connects to it over TLS.
+	GRPC    string
+	GRPCTLS bool
+	// GRPCListen is the address serve mode also serves gRPC on.
+	GRPCListen string
+
+	WatchDir    string
+	MaxDistance int
+	OutputJSONL bool
+	// Format names a structured output format other than CSV, JSON and
+	// JSON Lines, which have flags of their own; it is empty by default.
+	Format string
+	// MISPEventInfo is the title of the event written by --format misp.
+	MISPEventInfo string
+
+	// Syslog is "local" or the address matches are forwarded to.
+	Syslog         string
+	SyslogFacility string
+	SyslogSeverity string
+	SyslogSummary  bool
+
+	// CACert adds the PEM certificates in this file to the roots trusted by
+	// the download and --remote client. ClientCert and ClientKey, set together, are
+	// presented to servers that ask for a client certificate.
+	// InsecureSkipVerify turns off verification of the server altogether.
+	CACert             string
+	ClientCert         string
+	ClientKey          string
+	InsecureSkipVerify bool
+	// Headers are added to download and --remote requests, with an Authorization
+	// header for BearerToken if set.
+	Headers     headerList
+	BearerToken string
+	// Signature is the minisign signature a download is verified against,
+	// a file or URL, with PublicKey or the embedded publishingKey.
+	// RequireSigned refuses to load a database whose signature, saved
+	// when it was downloaded, does not verify.
+	Signature     string
+	PublicKey     string
+	RequireSigned bool
+	// ManifestURL, if set, downloads the database as the shards listed
+	// in the manifest at this URL.
+	ManifestURL string
+	// Delta downloads only the rows added since the last download, from
+	// DeltaURL or the database URL, when the server supports it.
+	Delta    bool
+	DeltaURL string
+	// UseEmbedded reads the database snapshot built into the binary even
+	// if DbPath exists. DumpPath is where dump-embedded mode writes it.
+	UseEmbedded bool
+	DumpPath    string
+	// CheckOnly makes update mode only report whether a newer release
+	// exists.
+	CheckOnly bool
+
+	// Webhook is the URL matches are posted to.
+	Webhook        string
+	WebhookHeaders headerList
+	WebhookTimeout time.Duration
+
+	Paths       []string
+	ZipPassword string
+	// Image is the docker save or OCI image archive whose filesystem scan
+	// mode checks in place of Paths, which then only holds it.
+	Image string
+
+	// Verbose and Debug select the level of the log written to stderr, or
+	// to LogFile if set.
+	Verbose bool
+	Debug   bool
+	LogFile string
+
+	// Include and Exclude filter the files found when scanning directories.
+	Include []string
+	Exclude []string
+
+	FollowSymlinks     bool
+	AllowExternalLinks bool
+	AllFilesystems     bool
+	// MaxDepth is how many levels of directories below each scanned one
+	// are entered; -1 means no limit. SkipHidden prunes hidden files and
+	// directories.
+	MaxDepth   int
+	SkipHidden bool
+
+	ArchiveDepth int
+	MaxExtracted int64
+	// MaxFileSize skips scanned files larger than this many bytes; 0 means
+	// no limit.
+	MaxFileSize int64
+	// SkipExt and OnlyExt filter the files found when scanning directories
+	// by their final extension, lowercase with its dot; nil sets pass
+	// every file.
+	SkipExt map[string]bool
+	OnlyExt map[string]bool
+	// Types limits scanned files to these types, recognised from their
+	// first bytes; 0 means every file.
+	Types fileType
+
+	Digests celestlsh.Digest
+	// MinEntropy flags scanned files with at least this entropy, in bits
+	// per byte, whether or not they match; 0 turns it off.
+	MinEntropy float64
+	// Force hashes files down to celestlsh.MinForcedDataLength bytes.
+	Force bool
+	// T1 prints hashed files' TLSH with the T1 version prefix.
+	T1 bool
+	// Sections also hashes the executable sections of PE and ELF files,
+	// and has scans check the .text section of files that do not match.
+	Sections bool
+
+	DistanceFiles bool
+
+	// Candidates are the hashes distance-many mode compares Hash1 with,
+	// from the arguments and --against, followed by those read from
+	// AgainstFile.
+	Candidates  []string
+	AgainstFile string
+
+	FileList string
+	// FileList0 makes FileList NUL-delimited rather than line-based.
+	FileList0  bool
+	OutputJSON bool
+	MatrixLong bool
+
+	ClusterThreshold int
+
+	DupesDir string
+	MinSize  int64
+
+	// CompareDirA and CompareDirB are the trees compared by compare-dirs
+	// mode.
+	CompareDirA string
+	CompareDirB string
+
+	// ManifestDir is the directory listed by manifest mode, whose rows get
+	// the other Manifest fields as their Repo Name, Release Version and
+	// Intel columns.
+	ManifestDir     string
+	ManifestRepo    string
+	ManifestVersion string
+	ManifestIntel   string
+
+	// DbImport is the manifest whose rows db-import mode adds to DbPath.
+	DbImport string
+
+	// Search is the query of search mode, matched against the Repo Name
+	// and File Name columns, and Intel with SearchIntel, as a substring or
+	// with SearchRegex a regular expression.
+	Search      string
+	SearchRegex bool
+	SearchIntel bool
+
+	// Limit caps the records kept for one query: those listed by search
+	// mode, where 0 means all, and the matches of check, cross-check,
+	// serve and daemon modes, the closest being kept.
+	Limit int
+
+	// PruneBefore is the date before which db-prune mode removes rows,
+	// only of PruneRepos if any are given and also undated ones with
+	// PruneUndated. PruneOut receives the result instead of DbPath.
+	PruneBefore  time.Time
+	PruneRepos   []string
+	PruneUndated bool
+	PruneOut     string
+
+	// QualityFull makes db-quality mode compare every pair of records
+	// rather than those among QualitySample records.
+	QualityFull   bool
+	QualitySample int
+
+	// Explain reports the terms of the distances of distance and check
+	// modes.
+	Explain bool
+
+	// CrossListA and CrossListB are the hash lists compared by cross-check
+	// mode, read from Column if set. Nearest keeps only the closest hash of
+	// the second list for each of the first.
+	CrossListA string
+	CrossListB string
+	Column     string
+	Nearest    bool
+
+	// BenchTime is how long each --bench measurement runs for.
+	BenchTime time.Duration
+
+	Workers   int
+	Unordered bool
+
+	NoProgress bool
+	// Color is when to colour text output: auto, always or never.
+	Color string
+	// Allowlist is a file of SHA256 and TLSH values whose matches are
+	// suppressed; ShowSuppressed still prints them, marked as such.
+	Allowlist      string
+	ShowSuppressed bool
+
+	// ConfidenceBands label match distances with a confidence;
+	// MinConfidence, if set, lowers MaxDistance to the least confident
+	// label admitted.
+	ConfidenceBands confidenceBands
+	MinConfidence   string
+
+	// GroupByRepo reports the best match of each repository instead of the
+	// single best match, for at most Top repositories (-1 for all). Without
+	// it, Top lists the Top closest records of a check.
+	GroupByRepo bool
+	Top         int
+	// Table prints check results as an aligned table, whose long fields
+	// Wide keeps whole. Results of several matches always use the table.
+	Table bool
+	Wide  bool
+	// Template renders each result in place of the selected format.
+	Template *template.Template
+	// Sort orders the repositories of a --group-by-repo result, or the
+	// records listed by --top; nil keeps them closest first.
+	Sort []sortKey
+	// Histogram adds a histogram of the distances of all records within
+	// MaxDistance to check results, in buckets of HistogramWidth.
+	// HistogramOnly prints it without the match.
+	Histogram      bool
+	HistogramOnly  bool
+	HistogramWidth int
+
+	// Since and Until restrict the database to records added in that
+	// range, Until excluded; either may be zero. StrictDates also leaves
+	// out records whose date is missing or unparseable.
+	Since       time.Time
+	Until       time.Time
+	StrictDates bool
+	// VersionFilter restricts the database to records whose Version
+	// matches this pattern.
+	VersionFilter celestlsh.Filter
+
+	// StatsOnly prints the end-of-scan summary instead of the results.
+	StatsOnly bool
+
+	// Quarantine is a directory that matched files are moved into; with
+	// DryRun they are only listed, as are the rows db-import would add,
+	// and download mode only describes what it would fetch.
+	Quarantine string
+	DryRun     bool
+
+	// FileTimeout bounds the time spent opening and reading one scanned
+	// file; 0 means no limit.
+	FileTimeout time.Duration
+
+	// StaleAfter is the age of the database past which check and scan
+	// modes warn, or with FailIfStale fail; 0 turns the check off.
+	StaleAfter  time.Duration
+	FailIfStale bool
+
+	// Checkpoint is a file that a scan's progress is saved to, and resumed
+	// from when it exists.
+	Checkpoint string
+
+	// Order is the --order directory scans dispatch files in, "" for the
+	// order walks find them; OrderLimit is how many files are held to be
+	// sorted before the rest of the scan streams in walk order.
+	Order      string
+	OrderLimit int
+
+	// ResultsDB is a SQLite file that scan and watch results are recorded
+	// in, and that results mode queries with ResultsQuery and ResultsArg.
+	ResultsDB    string
+	ResultsQuery string
+	ResultsArg   string
+
+	// Report is an HTML file a scan's results are written to when it
+	// ends.
+	Report string
+
+	// HashCache is a file caching the digests of scanned files, not
+	// used with NoCache; RefreshCache hashes every file again, updating it.
+	HashCache    string
+	NoCache      bool
+	RefreshCache bool
+
+	// FailFast stops a scan at its first match.
+	FailFast bool
+
+	// Throttle caps the bytes per second a scan reads from files, across
+	// all workers; 0 means no limit. NiceIdle rests each worker briefly
+	// after every file.
+	Throttle int64
+	NiceIdle bool
+
+	Output string
+	Append bool
+}
+
+func main() {
+	config := parseFlags()
+
+	closeLog, err := setupLogging(config)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(exitError)
+	}
+	defer closeLog()
+
+	output, err := openOutput(config)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(exitError)
+	}
+	setupColor(config)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	// After the first signal, a second one kills the process as usual.
+	context.AfterFunc(ctx, stop)
+
+	update := startUpdateCheck(config)
+	result, err := execute(ctx, config)
+	printUpdateNotice(update)
+	// Keep the results of a run that completed or was interrupted, but not
+	// the output of one that failed.
+	if cerr := output.close(err == nil || ctx.Err() != nil); cerr != nil && err == nil {
+		err = cerr
+	}
+	if err != nil {
+		code := exitError
+		if ctx.Err() != nil {
+			code = exitInterrupted
+			if errors.Is(err, context.Canceled) {
+				err = errInterrupted
+			}
+		}
+		printError(config, err)
+		os.Exit(code)
+	}
+	os.Exit(result.exitCode())
+}
+
+// version is the release of the tool, set at build time with
+// -ldflags "-X main.version=<version>".
+var version = "dev"
+
+// subcommands maps command words accepted as the first argument to the
+// mode flag they stand for, so that "celestlsh-cli procscan" works like
+// "celestlsh-cli --procscan".
+var subcommands = map[string]string{
+	"procscan": "--procscan",
+	"results":  "--results",
+	"update":   "--update",
+}
+
+func parseFlags() Config {
+	var config Config
+
+	if len(os.Args) > 1 {
+		if flagName, ok := subcommands[os.Args[1]]; ok {
+			os.Args[1] = flagName
+		}
+	}
+
+	hashFlag := flag.Bool("hash", false, "Calculate TLSH hash of a file")
+	hashShortFlag := flag.Bool("h", false, "Calculate TLSH hash of a file (shorthand)")
+
+	distanceFlag := flag.Bool("distance", false, "Calculate distance between two TLSH hashes")
+	distanceShortFlag := flag.Bool("d", false, "Calculate distance between two TLSH hashes (shorthand)")
+	distanceManyFlag := flag.String("distance-many", "", "Calculate the distance between this TLSH hash and each candidate hash, given as arguments, --against or --against-file")
+	againstFlag := flag.String("against", "", "Comma-separated candidate hashes for --distance-many")
+	againstFileFlag := flag.String("against-file", "", "File of candidate hashes for --distance-many, one per line, or - for stdin")
+	ssdeepCompareFlag := flag.Bool("ssdeep-compare", false, "Print the 0-100 ssdeep similarity of two ssdeep hashes or files")
+	matrixFlag := flag.Bool("matrix", false, "Print the pairwise TLSH distance matrix of files and hashes")
+	clusterFlag := flag.Int("cluster", -1, "Group files whose TLSH distance is within this threshold (single linkage)")
+	findDupesFlag := flag.String("find-dupes", "", "Report groups of near-duplicate files in a directory")
+	compareDirsFlag := flag.Bool("compare-dirs", false, "Compare two directory trees file by file, pairing renamed files by TLSH distance")
+	manifestFlag := flag.String("manifest", "", "Print every file in a directory as a row of the database CSV schema")
+	repoNameFlag := flag.String("repo-name", "", "Repo Name column of manifest rows")
+	releaseVersionFlag := flag.String("release-version", "", "Release Version column of manifest rows")
+	intelFlag := flag.String("intel", "", "Intel column of manifest rows, such as a link to the release")
+	dbImportFlag := flag.String("db-import", "", "Add the rows of a CSV in the database schema to --db, skipping duplicates")
+	searchFlag := flag.String("search", "", "List database records whose repo or file name contains this text, ignoring case")
+	regexFlag := flag.Bool("regex", false, "Match --search as a regular expression")
+	searchIntelFlag := flag.Bool("search-intel", false, "Also match --search against the Intel column")
+	limitFlag := flag.Int("limit", 0, fmt.Sprintf("Keep at most this many records per query in search, check, cross-check, serve and daemon modes (default: all for search, %d otherwise)", defaultResultLimit))
+	dbPruneFlag := flag.Bool("db-prune", false, "Remove database rows added before --before")
+	beforeFlag := flag.String("before", "", "ISO 8601 date before which db-prune removes rows")
+	var pruneRepos repoList
+	flag.Var(&pruneRepos, "repo", "Only prune rows of this repository (repeatable; db-prune mode)")
+	pruneUndatedFlag := flag.Bool("prune-undated", false, "Also prune rows without a valid Date Added")
+	outFlag := flag.String("out", "", "Write the pruned database here instead of replacing --db")
+	dbQualityFlag := flag.Bool("db-quality", false, "Report how many database records are near-duplicates of each other")
+	validateDBFlag := flag.Bool("validate-db", false, "Check that every row of the --db database follows the CSV schema")
+	fullFlag := flag.Bool("full", false, "Compare every pair of records in db-quality mode rather than a sample")
+	sampleFlag := flag.Int("sample", 0, fmt.Sprintf("Records compared pairwise by db-quality without --full (default: %d)", defaultQualitySample))
+	explainFlag := flag.Bool("explain", false, "Break distances down into header and body terms (distance and check modes)")
+	crossCheckFlag := flag.Bool("cross-check", false, "Find the similar pairs between two lists of TLSH hashes")
+	columnFlag := flag.String("column", "", "Read cross-check lists as CSV, taking hashes from the column of this name or number")
+	nearestFlag := flag.Bool("nearest", false, "Only report the closest hash of the second cross-check list for each of the first")
+	minSizeFlag := flag.String("min-size", "0", "Ignore files smaller than this size (only applies to find-dupes mode)")
+	longFlag := flag.Bool("long", false, "Print the matrix as one pair per row (only applies to matrix mode)")
+	fileList0Flag := flag.String("filelist0", "", "Like --filelist, but with paths separated by NUL bytes, as printed by find -print0")
+	fileListFlag := flag.String("filelist", "", "Read additional inputs, one per line, from a file (\"-\" for stdin; only applies to hash, scan, matrix and cluster modes)")
+	filesFlag := flag.Bool("files", false, "Treat distance mode arguments as file paths even if they parse as TLSH hashes")
+
+	downloadFlag := flag.Bool("download", false, "Download the CSV database of TLSH hashes")
+	downloadShortFlag := flag.Bool("dl", false, "Download the CSV database of TLSH hashes (shorthand)")
+
+	checkFlag := flag.Bool("check", false, "Check a TLSH hash against the database")
+	checkShortFlag := flag.Bool("c", false, "Check a TLSH hash against the database (shorthand)")
+	verifyFlag := flag.Bool("verify", false, "Check that a file is within --max-distance (default 0) of the TLSH hash it is expected to have")
+	benchFlag := flag.Bool("bench", false, "Measure hashing throughput, and how fast the database loads and answers checks")
+	benchTimeFlag := flag.Duration("bench-time", 2*time.Second, "How long each --bench measurement runs for")
+	selftestFlag := flag.Bool("selftest", false, "Hash built-in test vectors and compare the hashes and distances with the expected values")
+	validateFlag := flag.Bool("validate", false, "Check that TLSH hashes are well formed, reading them from stdin for '-'")
+
+	serveFlag := flag.Bool("serve", false, "Serve hash and check endpoints over HTTP")
+	listenFlag := flag.String("listen", "127.0.0.1:8080", "Address for the HTTP server to listen on (only applies to serve mode)")
+
+	daemonFlag := flag.Bool("daemon", false, "Serve checks over a Unix domain socket")
+	remoteFlag := flag.String("remote", "", "Check hashes against the serve mode instance at this base URL instead of the database (check and scan modes)")
+	remoteFallbackFlag := flag.String("remote-fallback", "", "Use the local database when --remote or --grpc is unavailable: local")
+	grpcFlag := flag.String("grpc", "", "Check hashes against the gRPC service of a serve mode instance at this host:port instead of the database (check and scan modes)")
+	grpcTLSFlag := flag.Bool("grpc-tls", false, "Connect to --grpc over TLS")
+	grpcListenFlag := flag.String("grpc-listen", "", "Address for serve mode to also serve gRPC on")
+	socketFlag := flag.String("socket", "", "Unix socket path for daemon mode; check and scan modes query the daemon there when it is running")
+
+	scanFlag := flag.Bool("scan", false, "Check files, directories and archives against the database")
+	scanShortFlag := flag.Bool("s", false, "Check files, directories and archives against the database (shorthand)")
+	scanImageFlag := flag.String("scan-image", "", "Check the files of a container image saved by docker save, or in the OCI image layout, against the database")
+	archiveDepthFlag := flag.Int("archive-depth", 1, "How many levels of nested archives to open (0 hashes archives as plain files)")
+	maxExtractedFlag := flag.String("max-extracted", "1G", "Maximum bytes decompressed from one archive, including nested archives")
+	var includeGlobs, excludeGlobs globList
+	flag.Var(&includeGlobs, "include", "Only scan files in directories whose relative path matches this glob (repeatable; scan, compare-dirs and manifest modes)")
+	flag.Var(&excludeGlobs, "exclude", "Skip files and directories whose relative path matches this glob (repeatable; scan, compare-dirs and manifest modes)")
+	followSymlinksFlag := flag.Bool("follow-symlinks", false, "Follow symbolic links when scanning directories, skipping loops (only applies to scan mode)")
+	allowExternalLinksFlag := flag.Bool("allow-external-links", false, "With --follow-symlinks, also follow links leading outside the scanned directory")
+	maxDepthFlag := flag.Int("max-depth", -1, "How many levels of subdirectories to descend into, 0 scanning only the files directly in each directory (-1 for no limit; only applies to scan mode)")
+	skipHiddenFlag := flag.Bool("skip-hidden", false, "Skip hidden files and directories: dotfiles on Unix, those with the hidden attribute on Windows (only applies to scan mode)")
+	allFilesystemsFlag := flag.Bool("all-filesystems", false, "Also scan mounts of virtual filesystems such as /proc, /sys, /dev and tmpfs (only applies to scan mode)")
+	skipExtFlag := flag.String("skip-ext", "", "Skip files with these extensions, comma-separated, such as .iso,.vmdk (only applies to scan mode)")
+	onlyExtFlag := flag.String("only-ext", "", "Only scan files with these extensions, comma-separated, such as .exe,.dll (only applies to scan mode)")
+	maxFileSizeFlag := flag.String("max-file-size", "0", "Skip files larger than this size, such as 500M or 2G (0 for no limit; only applies to scan mode)")
+	typesFlag := flag.String("types", "all", "Only hash files of these types, recognised from their first bytes: pe, elf, macho, script or all, comma-separated (scan and watch modes)")
+	zipPasswordFlag := flag.String("zip-password", "infected", "Password for encrypted zip archive members (only applies to scan and watch modes)")
+
+	procScanFlag := flag.Bool("procscan", false, "Check the executables of running processes against the database (Linux only)")
+
+	updateFlag := flag.Bool("update", false, "Replace this binary with the latest release, if newer, after verifying its published checksum")
+	checkOnlyFlag := flag.Bool("check-only", false, "Only report whether a newer release exists (only applies to update mode)")
+	resultsFlag := flag.Bool("results", false, "Query the history recorded with --results-db: last, path <path> or new <date>")
+	failFastFlag := flag.Bool("fail-fast", false, "Stop scanning at the first match, reporting only it (only applies to scan mode)")
+	throttleFlag := flag.Float64("throttle", 0, "Limit how many MiB per second a scan reads from files, across all workers (0 for no limit; only applies to scan mode)")
+	niceIdleFlag := flag.Bool("nice-idle", false, "Pause briefly after each scanned file, leaving the disk to other processes (only applies to scan mode)")
+	reportFlag := flag.String("report", "", "Write a self-contained HTML report of the scan to this file when it ends, even if interrupted (only applies to scan mode)")
+	resultsDBFlag := flag.String("results-db", "", "Record scan and watch results in this SQLite file")
+	hashCacheFlag := flag.String("hash-cache", "", "Cache the hashes of scanned files in this SQLite file, so that unchanged files are not read again; defaults to $CELESTLSH_HASH_CACHE (only applies to scan mode)")
+	noCacheFlag := flag.Bool("no-cache", false, "Do not use the --hash-cache for this scan")
+	refreshCacheFlag := flag.Bool("refresh-cache", false, "Hash every file again, replacing its --hash-cache entry")
+
+	watchFlag := flag.String("watch", "", "Watch a directory and check new or modified files against the database")
+
+	sha256Flag := flag.Bool("sha256", false, "Also compute the SHA256 of each file (hash, scan, watch and procscan modes)")
+	imphashFlag := flag.Bool("imphash", false, "Also compute the import hash of PE files (hash, scan, watch and procscan modes)")
+	ssdeepFlag := flag.Bool("ssdeep", false, "Also compute the ssdeep fuzzy hash of each file, which is not checked against the database (hash and scan modes)")
+	sectionsFlag := flag.Bool("sections", false, "Also hash each executable section of PE and ELF files, and check the .text section of scanned files whose whole-file hash does not match (hash and scan modes)")
+	entropyFlag := flag.Bool("entropy", false, "Also compute the Shannon entropy of each file, in bits per byte (hash and scan modes)")
+	minEntropyFlag := flag.Float64("min-entropy", 0, "Flag scanned files with at least this entropy, such as 7.2 for packed or encrypted data, even without a match (scan and watch modes)")
+	allHashesFlag := flag.Bool("all-hashes", false, "Also compute the MD5, SHA1 and SHA256 of each file (hash, scan, watch and procscan modes)")
+
+	dbPathFlag := flag.String("db", "tlsh_hashes.csv", "Path to the CSV database file, or - to read it, optionally gzipped, from stdin (check, scan, search, db-quality and procscan modes)")
+	quietFlag := flag.Bool("quiet", false, "Output only the hash or distance value")
+	verboseFlag := flag.Bool("verbose", false, "Log the database loaded, skipped entries and other decisions to stderr")
+	verboseShortFlag := flag.Bool("v", false, "Print notes about skipped entries to stderr (shorthand)")
+	debugFlag := flag.Bool("debug", false, "Also log debug details, such as skipped database rows, per-file decisions and HTTP requests")
+	logFileFlag := flag.String("log-file", "", "Append log messages to this file instead of stderr (logs at -v level unless --debug is given)")
+	csvOutputFlag := flag.Bool("csv", false, "Output results in CSV format (only applies to hash, check, scan, watch, compare-dirs and search modes)")
+	jsonOutputFlag := flag.Bool("json", false, "Output a single JSON document (only applies to hash, distance, check, validate, bench, matrix, cluster, find-dupes, compare-dirs, cross-check, search, db-quality and validate-db modes)")
+	jsonlOutputFlag := flag.Bool("jsonl", false, "Output one JSON object per result line (only applies to hash, validate, scan, watch and procscan modes)")
+	outputFlag := flag.String("output", "", "Write results to this file instead of stdout")
+	outputShortFlag := flag.String("o", "", "Write results to this file instead of stdout (shorthand)")
+	appendFlag := flag.Bool("append", false, "Append to the --output file rather than replacing it")
+	formatFlag := flag.String("format", "", "Output format: text, table, csv, json, jsonl, sarif, stix, misp, cef, markdown or junit (table only applies to check mode, junit to scan mode, and sarif, stix, misp, cef and markdown to check and scan modes)")
+	mispEventInfoFlag := flag.String("misp-event-info", defaultMISPEventInfo, "Title of the event written by --format misp")
+	var syslogTarget syslogFlag
+	flag.Var(&syslogTarget, "syslog", "Forward matches to the local syslog daemon, or with --syslog=[udp|tcp://]host[:port] to a remote one (scan and watch modes)")
+	syslogFacilityFlag := flag.String("syslog-facility", "user", "Syslog facility for forwarded matches")
+	syslogSeverityFlag := flag.String("syslog-severity", "warning", "Syslog severity for forwarded matches")
+	syslogSummaryFlag := flag.Bool("syslog-summary", false, "Also forward a summary when a scan finishes")
+	caCertFlag := flag.String("ca-cert", "", "Also trust the PEM CA certificates in this file, such as those of a TLS-intercepting proxy (only applies to download and update modes, --remote and --grpc-tls)")
+	clientCertFlag := flag.String("client-cert", "", "PEM client certificate presented to mirrors that require one, with --client-key")
+	clientKeyFlag := flag.String("client-key", "", "PEM private key of --client-cert")
+	var headers headerList
+	flag.Var(&headers, "header", "Header sent with download, --remote and --grpc requests, as 'Name: value' (repeatable)")
+	bearerTokenFlag := flag.String("bearer-token", "", "Token sent as 'Authorization: Bearer <token>' with download, --remote and --grpc requests; defaults to $CELESTLSH_TOKEN")
+	manifestURLFlag := flag.String("manifest-url", "", "Download the database as the shards listed in the JSON manifest at this URL, --workers at a time (only applies to download mode)")
+	deltaFlag := flag.Bool("delta", false, "Only download the rows added since the last download, falling back to a full download when that is not possible (only applies to download mode)")
+	deltaURLFlag := flag.String("delta-url", "", "With --delta, request new rows from this URL (default: the database URL), with a since parameter")
+	signatureFlag := flag.String("signature", "", "Verify the download against this minisign signature, a file or URL (default: the database URL with .minisig, when --pubkey is given)")
+	pubkeyFlag := flag.String("pubkey", "", "Minisign public key, or a file holding it, that signs the database (default: the key built into the binary, if any)")
+	requireSignedFlag := flag.Bool("require-signed", false, "Refuse to use a database without a valid signature recorded when it was downloaded (check, scan, watch, procscan, serve and daemon modes)")
+	insecureSkipVerifyFlag := flag.Bool("insecure-skip-verify", false, "Do not verify the server's TLS certificate; unsafe, use --ca-cert instead where possible")
+	webhookFlag := flag.String("webhook", "", "POST a JSON notification to this URL for every match (scan and watch modes)")
+	var webhookHeaders headerList
+	flag.Var(&webhookHeaders, "webhook-header", "Header sent with webhook notifications, as 'Name: value' (repeatable)")
+	fileTimeoutFlag := flag.Duration("file-timeout", defaultFileTimeout, "Give up on a scanned file that takes longer than this to open and read (0 for no limit; scan and watch modes)")
+	staleAfterFlag := flag.String("stale-after", "14d", "Warn when the database was downloaded longer ago than this, in days such as 14d or a duration such as 36h (0 to turn off; check and scan modes)")
+	failIfStaleFlag := flag.Bool("fail-if-stale", false, "Fail instead of warning when the database is older than --stale-after (check and scan modes)")
+	webhookTimeoutFlag := flag.Duration("webhook-timeout", 10*time.Second, "Timeout of each webhook request")
+	webhookTestFlag := flag.Bool("webhook-test", false, "Send a sample notification to --webhook and exit")
+	forceFlag := flag.Bool("force", false, fmt.Sprintf("Hash files of %d to %d bytes, which TLSH rejects by default", celestlsh.MinForcedDataLength, celestlsh.MinDataLength-1))
+	t1Flag := flag.Bool("t1", false, "Print TLSH hashes with the T1 version prefix newer tools use (only applies to hash mode)")
+	allowlistFlag := flag.String("allowlist", "", "File of known-good SHA256 and TLSH values, one per line, whose matches are suppressed (check, scan and watch modes)")
+	groupByRepoFlag := flag.Bool("group-by-repo", false, "Report the best match of each repository, with its number of matching records, instead of the single best match (check, scan and watch modes)")
+	topFlag := flag.Int("top", -1, "List this many of the closest records in check mode, or of the closest repositories with --group-by-repo (-1 for all repositories)")
+	sinceFlag := flag.String("since", "", "Only compare against database records added on or after this ISO 8601 date (check, scan, watch and procscan modes)")
+	untilFlag := flag.String("until", "", "Only compare against database records added before this ISO 8601 time, or on or before this date")
+	strictDatesFlag := flag.Bool("strict-dates", false, "With --since or --until, also leave out records whose date is missing or unparseable")
+	versionFilterFlag := flag.String("version-filter", "", "Only compare against database records whose version matches this glob, such as 4.* or 4.x (check, scan, watch and procscan modes)")
+	templateFlag := flag.String("template", "", "Render each result through this Go text/template, in which \\n and \\t stand for a newline and a tab (hash, check, scan and watch modes)")
+	templateFileFlag := flag.String("template-file", "", "Render each result through the Go text/template in this file")
+	useEmbeddedFlag := flag.Bool("use-embedded", false, "Use the database built into the binary even if --db exists, which it is otherwise only used in place of (check, scan, watch, procscan and daemon modes)")
+	dumpEmbeddedFlag := flag.String("dump-embedded", "", "Write the database built into the binary to this file, or stdout for -")
+	templateHelpFlag := flag.Bool("template-help", false, "List the fields available to --template")
+	wideFlag := flag.Bool("wide", false, "Do not cut long fields short in table output")
+	sortFlag := flag.String("sort", "", "Order --group-by-repo and --top results by distance (the default), repo, file, date or version, comma-separated, each with - for descending")
+	histogramFlag := flag.Bool("histogram", false, "Also print a histogram of the distances of all records within --max-distance (only applies to check mode)")
+	histogramOnlyFlag := flag.Bool("histogram-only", false, "Print the --histogram without the match")
+	histogramWidthFlag := flag.Int("histogram-width", 10, "Distances covered by each --histogram bucket")
+	showSuppressedFlag := flag.Bool("show-suppressed", false, "Print matches suppressed by --allowlist, marked as suppressed")
+	quarantineFlag := flag.String("quarantine", "", "Move files matching within --max-distance into this directory, recording them in its manifest.jsonl (scan and watch modes)")
+	dryRunFlag := flag.Bool("dry-run", false, "Show what --quarantine would move, --db-import would add or --db-prune would remove, or what --download would fetch, without changing anything")
+	orderFlag := flag.String("order", "walk", "Scan the files found in this order: mtime-desc, mtime-asc, size-asc, size-desc, name or walk (only applies to scan mode)")
+	orderLimitFlag := flag.Int("order-limit", defaultOrderLimit, "With --order, the most files held to be sorted; files found after that are scanned as they are found")
+	checkpointFlag := flag.String("checkpoint", "", "Save scan progress to this file every few seconds and resume from it when it exists; removed once the scan completes (only applies to scan mode)")
+	statsOnlyFlag := flag.Bool("stats-only", false, "Print only the end-of-scan summary, on stdout, instead of each result (only applies to scan mode)")
+	colorFlag := flag.String("color", "auto", "Colour text output: auto (when stdout is a terminal and NO_COLOR is unset), always or never")
+	noColorFlag := flag.Bool("no-color", false, "Do not colour text output, like --color=never")
+	noProgressFlag := flag.Bool("no-progress", false, "Do not show progress on stderr for downloads and scans")
+	workersFlag := flag.Int("workers", 0, "Number of files hashed, and database records compared, in parallel (0 for one per CPU, 1 for serial)")
+	unorderedFlag := flag.Bool("unordered", false, "Print results as files finish rather than in input order (only applies to hash and scan modes)")
+	confidenceBandsFlag := flag.String("confidence-bands", "30,60,100", "Largest distances labelled high, medium and low confidence; further matches have none")
+	minConfidenceFlag := flag.String("min-confidence", "", "Only report matches with at least this confidence: high, medium, low or none (check, scan and watch modes)")
+	maxDistanceFlag := flag.Int("max-distance", -1, "Only report database matches within this distance (-1 for no limit; by default, that of low confidence)")
+
+	// The flag package exits with 2 on bad flags, which is the exit code of
+	// a match here.
+	flag.CommandLine.Init(os.Args[0], flag.ContinueOnError)
+	if err := flag.CommandLine.Parse(os.Args[1:]); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			os.Exit(exitOK)
+		}
+		os.Exit(exitError)
+	}
+
+	args := flag.Args()
+
+	config.DbPath = *dbPathFlag
+	config.Quiet = *quietFlag
+	config.OutputCSV = *csvOutputFlag
+	config.Listen = *listenFlag
+	config.Socket = *socketFlag
+	config.Remote = *remoteFlag
+	config.RemoteFallback = *remoteFallbackFlag
+	config.GRPC = *grpcFlag
+	config.GRPCTLS = *grpcTLSFlag
+	config.GRPCListen = *grpcListenFlag
+	config.OutputJSONL = *jsonlOutputFlag
+	config.OutputJSON = *jsonOutputFlag
+	config.MaxDistance = *maxDistanceFlag
+	config.MinConfidence = *minConfidenceFlag
+	config.Workers = *workersFlag
+	config.Unordered = *unorderedFlag
+	config.NoProgress = *noProgressFlag
+	config.Color = *colorFlag
+	if *noColorFlag {
+		config.Color = "never"
+	}
+	config.StatsOnly = *statsOnlyFlag
+	config.Allowlist = *allowlistFlag
+	config.ShowSuppressed = *showSuppressedFlag
+	config.GroupByRepo = *groupByRepoFlag
+	config.Top = *topFlag
+	config.Wide = *wideFlag
+	config.StrictDates = *strictDatesFlag
+	config.Histogram = *histogramFlag || *histogramOnlyFlag
+	config.HistogramOnly = *histogramOnlyFlag
+	config.HistogramWidth = *histogramWidthFlag
+	config.Quarantine = *quarantineFlag
+	config.DryRun = *dryRunFlag
+	config.ResultsDB = *resultsDBFlag
+	config.HashCache = *hashCacheFlag
+	config.NoCache = *noCacheFlag
+	config.RefreshCache = *refreshCacheFlag
+	config.Report = *reportFlag
+	config.FailFast = *failFastFlag
+	config.Throttle = int64(*throttleFlag * (1 << 20))
+	config.NiceIdle = *niceIdleFlag
+	config.Checkpoint = *checkpointFlag
+	config.OrderLimit = *orderLimitFlag
+	config.Force = *forceFlag
+	config.T1 = *t1Flag
+	config.MISPEventInfo = *mispEventInfoFlag
+	config.Syslog = syslogTarget.target
+	config.SyslogFacility = *syslogFacilityFlag
+	config.SyslogSeverity = *syslogSeverityFlag
+	config.SyslogSummary = *syslogSummaryFlag
+	config.CACert = *caCertFlag
+	config.ClientCert = *clientCertFlag
+	config.ClientKey = *clientKeyFlag
+	config.InsecureSkipVerify = *insecureSkipVerifyFlag
+	config.Headers = headers
+	config.BearerToken = *bearerTokenFlag
+	config.Signature = *signatureFlag
+	config.ManifestURL = *manifestURLFlag
+	config.Delta = *deltaFlag
+	config.DeltaURL = *deltaURLFlag
+	config.PublicKey = *pubkeyFlag
+	config.RequireSigned = *requireSignedFlag
+	config.UseEmbedded = *useEmbeddedFlag
+	config.CheckOnly = *checkOnlyFlag
+	config.Webhook = *webhookFlag
+	config.WebhookHeaders = webhookHeaders
+	config.WebhookTimeout = *webhookTimeoutFlag
+	config.FileTimeout = *fileTimeoutFlag
+	staleAfter, err := parseStaleAfter(*staleAfterFlag)
+	if err != nil {
+		printUsage(fmt.Sprintf("Invalid --stale-after: %v", err))
+		os.Exit(1)
+	}
+	config.StaleAfter = staleAfter
+	config.FailIfStale = *failIfStaleFlag
+	config.Output = *outputFlag
+	if *outputShortFlag != "" {
+		config.Output = *outputShortFlag
+	}
+	config.Append = *appendFlag
+	config.ZipPassword = *zipPasswordFlag
+	config.Include = includeGlobs
+	config.Exclude = excludeGlobs
+	config.FollowSymlinks = *followSymlinksFlag
+	config.MaxDepth = *maxDepthFlag
+	config.SkipHidden = *skipHiddenFlag
+	config.AllowExternalLinks = *allowExternalLinksFlag
+	config.AllFilesystems = *allFilesystemsFlag
+	config.Verbose = *verboseFlag || *verboseShortFlag
+	config.Debug = *debugFlag
+	config.LogFile = *logFileFlag
+	config.ArchiveDepth = *archiveDepthFlag
+
+	if *sha256Flag {
+		config.Digests |= celestlsh.DigestSHA256
+	}
+	if *imphashFlag {
+		config.Digests |= celestlsh.DigestImphash
+	}
+	if *allHashesFlag {
+		config.Digests |= celestlsh.AllDigests
+	}
+	if *ssdeepFlag {
+		config.Digests |= celestlsh.DigestSSDeep
+	}
+	config.Sections = *sectionsFlag
+	if *minEntropyFlag < 0 || *minEntropyFlag > 8 {
+		printUsage("--min-entropy must be between 0 and 8 bits per byte")
+		os.Exit(1)
+	}
+	config.MinEntropy = *minEntropyFlag
+	if *entropyFlag || config.MinEntropy > 0 {
+		config.Digests |= celestlsh.DigestEntropy
+	}
+
+	maxExtracted, err := parseSize(*maxExtractedFlag)
+	if err != nil {
+		printUsage(fmt.Sprintf("Invalid --max-extracted: %v", err))
+		os.Exit(1)
+	}
+	config.MaxExtracted = maxExtracted
+
+	maxFileSize, err := parseSize(*maxFileSizeFlag)
+	if err != nil {
+		printUsage(fmt.Sprintf("Invalid --max-file-size: %v", err))
+		os.Exit(1)
+	}
+	config.MaxFileSize = maxFileSize
+
+	types, err := parseFileTypes(*typesFlag)
+	if err != nil {
+		printUsage(fmt.Sprintf("Invalid --types: %v", err))
+		os.Exit(1)
+	}
+	config.Types = types
+
+	if config.Order, err = parseOrder(*orderFlag); err != nil {
+		printUsage(fmt.Sprintf("Invalid --order: %v", err))
+		os.Exit(1)
+	}
+
+	if config.SkipExt, err = parseExtensions(*skipExtFlag); err != nil {
+		printUsage(fmt.Sprintf("Invalid --skip-ext: %v", err))
+		os.Exit(1)
+	}
+	if config.OnlyExt, err = parseExtensions(*onlyExtFlag); err != nil {
+		printUsage(fmt.Sprintf("Invalid --only-ext: %v", err))
+		os.Exit(1)
+	}
+
+	minSize, err := parseSize(*minSizeFlag)
+	if err != nil {
+		printUsage(fmt.Sprintf("Invalid --min-size: %v", err))
+		os.Exit(1)
+	}
+	config.MinSize = minSize
+
+	if *sinceFlag != "" {
+		config.Since, err = celestlsh.ParseDate(*sinceFlag)
+		if err != nil {
+			printUsage(fmt.Sprintf("Invalid --since: %v", err))
+			os.Exit(1)
+		}
+	}
+	if *untilFlag != "" {
+		config.Until, err = celestlsh.ParseDate(*untilFlag)
+		if err != nil {
+			printUsage(fmt.Sprintf("Invalid --until: %v", err))
+			os.Exit(1)
+		}
+		// A date without a time includes the whole day.
+		if !strings.Contains(*untilFlag, ":") {
+			config.Until = config.Until.AddDate(0, 0, 1)
+		}
+	}
+
+	if *versionFilterFlag != "" {
+		config.VersionFilter, err = celestlsh.VersionMatching(*versionFilterFlag)
+		if err != nil {
+			printUsage(fmt.Sprintf("Invalid --version-filter: %v", err))
+			os.Exit(1)
+		}
+	}
+
+	switch *formatFlag {
+	case "", "text":
+	case "csv":
+		config.OutputCSV = true
+	case "json":
+		config.OutputJSON = true
+	case "jsonl":
+		config.OutputJSONL = true
+	case "table":
+		config.Table = true
+	case "sarif", "stix", "misp", "cef", "markdown", "junit":
+		config.Format = *formatFlag
+	default:
+		printUsage(fmt.Sprintf("Unknown --format %q", *formatFlag))
+		os.Exit(1)
+	}
+
+	if *templateFlag != "" && *templateFileFlag != "" {
+		printUsage("--template and --template-file cannot be used together")
+		os.Exit(1)
+	}
+	if text := unescapeTemplate.Replace(*templateFlag); text != "" {
+		config.Template, err = parseTemplate(text)
+		if err != nil {
+			printUsage(fmt.Sprintf("Invalid --template: %v", err))
+			os.Exit(1)
+		}
+	}
+	if *templateFileFlag != "" {
+		text, err := os.ReadFile(*templateFileFlag)
+		if err != nil {
+			printUsage(fmt.Sprintf("Cannot read --template-file: %v", err))
+			os.Exit(1)
+		}
+		config.Template, err = parseTemplate(string(text))
+		if err != nil {
+			printUsage(fmt.Sprintf("Invalid --template-file: %v", err))
+			os.Exit(1)
+		}
+	}
+
+	if *sortFlag != "" {
+		config.Sort, err = parseSort(*sortFlag)
+		if err != nil {
+			printUsage(fmt.Sprintf("Invalid --sort: %v", err))
+			os.Exit(1)
+		}
+	}
+
+	bands, err := parseConfidenceBands(*confidenceBandsFlag)
+	if err != nil {
+		printUsage(fmt.Sprintf("Invalid --confidence-bands: %v", err))
+		os.Exit(1)
+	}
+	config.ConfidenceBands = bands
+
+	switch config.Color {
+	case "auto", "always", "never":
+	default:
+		printUsage(fmt.Sprintf("Unknown --color %q; use auto, always or never", config.Color))
+		os.Exit(1)
+	}
+
+	if config.Append && config.Output == "" {
+		printUsage("--append requires -o/--output")
+		os.Exit(1)
+	}
+
+	fileList := *fileListFlag
+	if *fileList0Flag != "" {
+		if fileList != "" {
+			printUsage("--filelist and --filelist0 cannot be combined")
+			os.Exit(1)
+		}
+		fileList, config.FileList0 = *fileList0Flag, true
+	}
+
+	switch {
+	case *hashFlag || *hashShortFlag:
+		config.Mode = "hash"
+		config.FileList = fileList
+		if len(args) < 1 && config.FileList == "" {
+			printUsage("No file path provided for hash calculation")
+			os.Exit(1)
+		}
+		config.Paths = args
+
+	case *distanceFlag || *distanceShortFlag:
+		config.Mode = "distance"
+		config.DistanceFiles = *filesFlag
+		if len(args) < 2 {
+			printUsage("Two TLSH hashes or files are required for distance calculation")
+			os.Exit(1)
+		}
+		config.Hash1 = args[0]
+		config.Hash2 = args[1]
+
+	case *distanceManyFlag != "":
+		config.Mode = "distance-many"
+		config.Hash1 = *distanceManyFlag
+		config.Candidates = args
+		for _, hash := range strings.Split(*againstFlag, ",") {
+			if hash = strings.TrimSpace(hash); hash != "" {
+				config.Candidates = append(config.Candidates, hash)
+			}
+		}
+		config.AgainstFile = *againstFileFlag
+		if len(config.Candidates) == 0 && config.AgainstFile == "" {
+			printUsage("--distance-many requires candidate hashes as arguments, --against or --against-file")
+			os.Exit(1)
+		}
+
+	case *ssdeepCompareFlag:
+		config.Mode = "ssdeep-compare"
+		if len(args) < 2 {
+			printUsage("Two ssdeep hashes or files are required for ssdeep comparison")
+			os.Exit(1)
+		}
+		config.Hash1 = args[0]
+		config.Hash2 = args[1]
+
+	case *matrixFlag:
+		config.Mode = "matrix"
+		config.DistanceFiles = *filesFlag
+		config.MatrixLong = *longFlag
+		config.FileList = fileList
+		config.Paths = args
+		if len(args) == 0 && config.FileList == "" {
+			printUsage("No files or hashes provided for the distance matrix")
+			os.Exit(1)
+		}
+
+	case *clusterFlag >= 0:
+		config.Mode = "cluster"
+		config.ClusterThreshold = *clusterFlag
+		config.DistanceFiles = *filesFlag
+		config.FileList = fileList
+		config.Paths = args
+		if len(args) == 0 && config.FileList == "" {
+			printUsage("No files or directories provided for clustering")
+			os.Exit(1)
+		}
+
+	case *findDupesFlag != "":
+		config.Mode = "find-dupes"
+		config.DupesDir = *findDupesFlag
+
+	case *compareDirsFlag:
+		config.Mode = "compare-dirs"
+		if len(args) != 2 {
+			printUsage("Two directories are required for comparison")
+			os.Exit(1)
+		}
+		config.CompareDirA = args[0]
+		config.CompareDirB = args[1]
+
+	case *manifestFlag != "":
+		config.Mode = "manifest"
+		config.ManifestDir = *manifestFlag
+		if *repoNameFlag == "" || *releaseVersionFlag == "" {
+			printUsage("--manifest requires --repo-name and --release-version")
+			os.Exit(1)
+		}
+
+	case *dbImportFlag != "":
+		config.Mode = "db-import"
+		config.DbImport = *dbImportFlag
+
+	case *searchFlag != "":
+		config.Mode = "search"
+		config.Search = *searchFlag
+		config.SearchRegex = *regexFlag
+		config.SearchIntel = *searchIntelFlag
+
+	case *dbPruneFlag:
+		config.Mode = "db-prune"
+		if *beforeFlag == "" {
+			printUsage("--db-prune requires --before <date>")
+			os.Exit(1)
+		}
+		config.PruneBefore, err = celestlsh.ParseDate(*beforeFlag)
+		if err != nil {
+			printUsage(fmt.Sprintf("Invalid --before: %v", err))
+			os.Exit(1)
+		}
+		config.PruneRepos = pruneRepos
+		config.PruneUndated = *pruneUndatedFlag
+		config.PruneOut = *outFlag
+
+	case *validateDBFlag:
+		config.Mode = "validate-db"
+
+	case *dbQualityFlag:
+		config.Mode = "db-quality"
+		config.QualityFull = *fullFlag
+		config.QualitySample = *sampleFlag
+		if config.QualitySample == 0 {
+			config.QualitySample = defaultQualitySample
+		}
+
+	case *crossCheckFlag:
+		config.Mode = "cross-check"
+		if len(args) != 2 {
+			printUsage("Two hash lists are required for cross-checking")
+			os.Exit(1)
+		}
+		if config.MaxDistance < 0 && !*nearestFlag {
+			printUsage("--cross-check requires --max-distance, --nearest or both")
+			os.Exit(1)
+		}
+		if args[0] == "-" && args[1] == "-" {
+			printUsage("Only one cross-check list can be read from stdin")
+			os.Exit(1)
+		}
+		config.CrossListA = args[0]
+		config.CrossListB = args[1]
+		config.Column = *columnFlag
+		config.Nearest = *nearestFlag
+
+	case *downloadFlag || *downloadShortFlag:
+		config.Mode = "download"
+
+	case *checkFlag || *checkShortFlag:
+		config.Mode = "check"
+		if len(args) < 1 {
+			printUsage("No TLSH hash provided for checking against the database")
+			os.Exit(1)
+		}
+		config.Hashes = args
+
+	case *verifyFlag:
+		config.Mode = "verify"
+		if len(args) != 2 {
+			printUsage("A file and its expected TLSH hash are required for verification")
+			os.Exit(1)
+		}
+		config.Paths = args[:1]
+		config.Hashes = args[1:]
+
+	case *validateFlag:
+		config.Mode = "validate"
+		if len(args) < 1 {
+			printUsage("No TLSH hash provided for validation; use - to read them from stdin")
+			os.Exit(1)
+		}
+		config.Hashes = args
+
+	case *benchFlag:
+		config.Mode = "bench"
+		config.BenchTime = *benchTimeFlag
+		if config.BenchTime <= 0 {
+			printUsage("--bench-time must be positive")
+			os.Exit(1)
+		}
+
+	case *selftestFlag:
+		config.Mode = "selftest"
+
+	case *templateHelpFlag:
+		config.Mode = "template-help"
+
+	case *updateFlag:
+		config.Mode = "update"
+
+	case *dumpEmbeddedFlag != "":
+		config.Mode = "dump-embedded"
+		config.DumpPath = *dumpEmbeddedFlag
+
+	case *serveFlag:
+		config.Mode = "serve"
+
+	case *daemonFlag:
+		config.Mode = "daemon"
+
+	case *scanImageFlag != "":
+		config.Mode = "scan"
+		if len(args) > 0 || fileList != "" {
+			printUsage("--scan-image takes a single image archive and no other paths")
+			os.Exit(1)
+		}
+		config.Image = *scanImageFlag
+		config.Paths = []string{config.Image}
+
+	case *scanFlag || *scanShortFlag:
+		config.Mode = "scan"
+		config.FileList = fileList
+		if len(args) < 1 && config.FileList == "" {
+			printUsage("No files or directories provided for scanning")
+			os.Exit(1)
+		}
+		config.Paths = args
+
+	case *procScanFlag:
+		config.Mode = "procscan"
+
+	case *watchFlag != "":
+		config.Mode = "watch"
+		config.WatchDir = *watchFlag
+
+	case *resultsFlag:
+		config.Mode = "results"
+		if config.ResultsDB == "" {
+			printUsage("results requires --results-db <path>")
+			os.Exit(1)
+		}
+		if len(args) < 1 {
+			printUsage("No results query provided: use last, path <path> or new <date>")
+			os.Exit(1)
+		}
+		config.ResultsQuery = args[0]
+		switch {
+		case config.ResultsQuery == "last" && len(args) == 1:
+		case (config.ResultsQuery == "path" || config.ResultsQuery == "new") && len(args) == 2:
+			config.ResultsArg = args[1]
+		default:
+			printUsage(fmt.Sprintf("Invalid results query %q: use last, path <path> or new <date>", strings.Join(args, " ")))
+			os.Exit(1)
+		}
+
+	case *webhookTestFlag:
+		config.Mode = "webhook-test"
+		if config.Webhook == "" {
+			printUsage("--webhook-test requires --webhook <url>")
+			os.Exit(1)
+		}
+
+	default:
+
+		printUsage("")
+		os.Exit(0)
+	}
+
+	if config.Format != "" && config.Mode != "check" && config.Mode != "scan" {
+		printUsage(fmt.Sprintf("--format %s only applies to check and scan modes", config.Format))
+		os.Exit(1)
+	}
+	if config.Format == "junit" && config.Mode != "scan" {
+		printUsage("--format junit only applies to scan mode")
+		os.Exit(1)
+	}
+	if config.Table && config.Mode != "check" && config.Mode != "compare-dirs" && config.Mode != "search" {
+		printUsage("--format table only applies to check, compare-dirs and search modes")
+		os.Exit(1)
+	}
+	if config.Template != nil {
+		if config.Mode != "hash" && config.Mode != "check" && config.Mode != "scan" && config.Mode != "watch" {
+			printUsage("--template only applies to hash, check, scan and watch modes")
+			os.Exit(1)
+		}
+		if config.OutputCSV || config.OutputJSON || config.OutputJSONL || config.Quiet || config.Format != "" || config.Table || config.StatsOnly {
+			printUsage("--template cannot be combined with another output format")
+			os.Exit(1)
+		}
+	}
+	if config.Allowlist != "" && config.Mode != "check" && config.Mode != "scan" && config.Mode != "watch" {
+		printUsage("--allowlist only applies to check, scan and watch modes")
+		os.Exit(1)
+	}
+	if config.MinConfidence != "" {
+		if config.Mode != "check" && config.Mode != "scan" && config.Mode != "watch" {
+			printUsage("--min-confidence only applies to check, scan and watch modes")
+			os.Exit(1)
+		}
+		limit, ok := config.ConfidenceBands.maxDistance(config.MinConfidence)
+		if !ok {
+			printUsage(fmt.Sprintf("Unknown --min-confidence %q; use high, medium, low or none", config.MinConfidence))
+			os.Exit(1)
+		}
+		if limit >= 0 && (config.MaxDistance < 0 || limit < config.MaxDistance) {
+			config.MaxDistance = limit
+		}
+	}
+	if config.GroupByRepo {
+		if config.Mode != "check" && config.Mode != "scan" && config.Mode != "watch" {
+			printUsage("--group-by-repo only applies to check, scan and watch modes")
+			os.Exit(1)
+		}
+		if config.Mode == "check" && config.Format != "" && config.Format != "markdown" {
+			printUsage(fmt.Sprintf("--group-by-repo cannot be used with --format %s in check mode", config.Format))
+			os.Exit(1)
+		}
+	}
+	if config.Sort != nil && !config.GroupByRepo && config.Top == -1 {
+		printUsage("--sort requires --group-by-repo or --top")
+		os.Exit(1)
+	}
+	if config.Top != -1 {
+		if !config.GroupByRepo && config.Mode != "check" {
+			printUsage("--top without --group-by-repo only applies to check mode")
+			os.Exit(1)
+		}
+		if !config.GroupByRepo && config.Format != "" {
+			printUsage(fmt.Sprintf("--top cannot be used with --format %s without --group-by-repo", config.Format))
+			os.Exit(1)
+		}
+		if config.Top < 1 {
+			printUsage("--top must be at least 1")
+			os.Exit(1)
+		}
+	}
+	if !config.Since.IsZero() || !config.Until.IsZero() {
+		if config.Mode != "check" && config.Mode != "scan" && config.Mode != "watch" && config.Mode != "procscan" {
+			printUsage("--since and --until only apply to check, scan, watch and procscan modes")
+			os.Exit(1)
+		}
+		if !config.Since.IsZero() && !config.Until.IsZero() && !config.Since.Before(config.Until) {
+			printUsage("--since must be before --until")
+			os.Exit(1)
+		}
+	} else if config.StrictDates {
+		printUsage("--strict-dates requires --since or --until")
+		os.Exit(1)
+	}
+	if config.VersionFilter != nil && config.Mode != "check" && config.Mode != "scan" && config.Mode != "watch" && config.Mode != "procscan" {
+		printUsage("--version-filter only applies to check, scan, watch and procscan modes")
+		os.Exit(1)
+	}
+	if config.Histogram {
+		if config.Mode != "check" {
+			printUsage("--histogram only applies to check mode")
+			os.Exit(1)
+		}
+		if config.OutputCSV || config.Quiet || config.Format != "" {
+			printUsage("--histogram can only be printed as text, --json or --jsonl")
+			os.Exit(1)
+		}
+		if config.HistogramWidth < 1 {
+			printUsage("--histogram-width must be at least 1")
+			os.Exit(1)
+		}
+	}
+	if config.Quarantine != "" {
+		if config.Mode != "scan" && config.Mode != "watch" {
+			printUsage("--quarantine only applies to scan and watch modes")
+			os.Exit(1)
+		}
+		if config.MaxDistance < 0 {
+			printUsage("--quarantine requires --max-distance, so that only close matches are moved")
+			os.Exit(1)
+		}
+	}
+	// Without a threshold, the nearest record is only a match if it has
+	// some confidence: one further away than the low band is no match, so
+	// that it neither sets the exit status nor fails a JUnit or SARIF
+	// report. --max-distance -1 reports the nearest record however far.
+	maxDistanceSet := false
+	flag.Visit(func(f *flag.Flag) { maxDistanceSet = maxDistanceSet || f.Name == "max-distance" })
+	if !maxDistanceSet && config.MinConfidence == "" && (config.Mode == "check" || config.Mode == "scan" || config.Mode == "watch" || config.Mode == "procscan") {
+		config.MaxDistance = config.ConfidenceBands[len(config.ConfidenceBands)-1]
+	}
+	if config.ResultsDB != "" && config.Mode != "scan" && config.Mode != "watch" && config.Mode != "results" {
+		printUsage("--results-db only applies to scan, watch and results modes")
+		os.Exit(1)
+	}
+	if config.FailFast && config.Mode != "scan" {
+		printUsage("--fail-fast only applies to scan mode")
+		os.Exit(1)
+	}
+	if *throttleFlag < 0 || (*throttleFlag > 0 && config.Throttle < 1) {
+		printUsage("--throttle must be a positive number of MiB per second, or 0 for no limit")
+		os.Exit(1)
+	}
+	if (config.Throttle > 0 || config.NiceIdle) && config.Mode != "scan" {
+		printUsage("--throttle and --nice-idle only apply to scan mode")
+		os.Exit(1)
+	}
+	if config.Report != "" && config.Mode != "scan" {
+		printUsage("--report only applies to scan mode")
+		os.Exit(1)
+	}
+	if config.HashCache != "" && config.Mode != "scan" {
+		printUsage("--hash-cache only applies to scan mode")
+		os.Exit(1)
+	}
+	if config.Image != "" {
+		// The image is read layer by layer, top down, rather than walked.
+		if config.Checkpoint != "" || config.HashCache != "" || config.Order != "" || len(config.Include) > 0 || len(config.Exclude) > 0 || config.FollowSymlinks || config.MaxDepth >= 0 || config.SkipHidden {
+			printUsage("--scan-image cannot be combined with --checkpoint, --hash-cache, --order, --include, --exclude, --follow-symlinks, --max-depth or --skip-hidden")
+			os.Exit(1)
+		}
+	}
+	if config.Sections {
+		if config.Mode != "hash" && config.Mode != "scan" {
+			printUsage("--sections only applies to hash and scan modes")
+			os.Exit(1)
+		}
+		// Cached entries hold no section hashes.
+		if config.HashCache != "" {
+			printUsage("--sections cannot be combined with --hash-cache")
+			os.Exit(1)
+		}
+	}
+	if config.Mode == "scan" && config.Image == "" && !config.Sections && config.HashCache == "" {
+		config.HashCache = os.Getenv("CELESTLSH_HASH_CACHE")
+	}
+	if config.NoCache && config.RefreshCache {
+		printUsage("--no-cache and --refresh-cache cannot be used together")
+		os.Exit(1)
+	}
+	if (config.NoCache || config.RefreshCache) && config.HashCache == "" {
+		printUsage("--no-cache and --refresh-cache require --hash-cache or $CELESTLSH_HASH_CACHE")
+		os.Exit(1)
+	}
+	if config.Checkpoint != "" {
+		if config.Mode != "scan" {
+			printUsage("--checkpoint only applies to scan mode")
+			os.Exit(1)
+		}
+		if config.Unordered {
+			printUsage("--checkpoint cannot be combined with --unordered, since progress is saved in scan order")
+			os.Exit(1)
+		}
+		if config.Order != "" {
+			printUsage("--checkpoint cannot be combined with --order, since progress is saved in walk order")
+			os.Exit(1)
+		}
+	}
+	if config.Order != "" && config.Mode != "scan" {
+		printUsage("--order only applies to scan mode")
+		os.Exit(1)
+	}
+	if config.OrderLimit < 1 {
+		printUsage("--order-limit must be at least 1")
+		os.Exit(1)
+	}
+	if config.DryRun && config.Quarantine == "" && config.Mode != "db-import" && config.Mode != "db-prune" && config.Mode != "download" {
+		printUsage("--dry-run requires --quarantine <dir>, --db-import <path>, --db-prune or --download")
+		os.Exit(1)
+	}
+	if config.FailIfStale && config.Mode != "check" && config.Mode != "scan" {
+		printUsage("--fail-if-stale only applies to check and scan modes")
+		os.Exit(1)
+	}
+	if config.FailIfStale && config.StaleAfter == 0 {
+		printUsage("--fail-if-stale requires a --stale-after other than 0")
+		os.Exit(1)
+	}
+	if config.FileTimeout < 0 {
+		printUsage("--file-timeout must not be negative")
+		os.Exit(1)
+	}
+	if config.AllowExternalLinks && !config.FollowSymlinks {
+		printUsage("--allow-external-links requires --follow-symlinks")
+		os.Exit(1)
+	}
+	if config.MaxDepth < -1 {
+		printUsage("--max-depth must be -1 (no limit) or more")
+		os.Exit(1)
+	}
+	if (config.MaxDepth >= 0 || config.SkipHidden) && config.Mode != "scan" {
+		printUsage("--max-depth and --skip-hidden only apply to scan mode")
+		os.Exit(1)
+	}
+	if (len(config.Include) > 0 || len(config.Exclude) > 0) && config.Mode != "scan" && config.Mode != "compare-dirs" && config.Mode != "manifest" {
+		printUsage("--include and --exclude only apply to scan, compare-dirs and manifest modes")
+		os.Exit(1)
+	}
+	config.Explain = *explainFlag
+	if (*regexFlag || *searchIntelFlag) && config.Mode != "search" {
+		printUsage("--regex and --search-intel only apply to search mode")
+		os.Exit(1)
+	}
+	switch config.Mode {
+	case "search":
+		config.Limit = *limitFlag
+	case "check", "cross-check", "serve", "daemon":
+		config.Limit = *limitFlag
+		if config.Limit == 0 {
+			config.Limit = defaultResultLimit
+		}
+	default:
+		if *limitFlag != 0 {
+			printUsage("--limit only applies to search, check, cross-check, serve and daemon modes")
+			os.Exit(1)
+		}
+	}
+	if *limitFlag < 0 {
+		printUsage("--limit must not be negative")
+		os.Exit(1)
+	}
+	if (*beforeFlag != "" || len(pruneRepos) > 0 || *pruneUndatedFlag || *outFlag != "") && config.Mode != "db-prune" {
+		printUsage("--before, --repo, --prune-undated and --out only apply to db-prune mode")
+		os.Exit(1)
+	}
+	if (*fullFlag || *sampleFlag != 0) && config.Mode != "db-quality" {
+		printUsage("--full and --sample only apply to db-quality mode")
+		os.Exit(1)
+	}
+	if *fullFlag && *sampleFlag != 0 {
+		printUsage("--full and --sample cannot be combined")
+		os.Exit(1)
+	}
+	if *sampleFlag < 0 || *sampleFlag == 1 {
+		printUsage("--sample must be at least 2")
+		os.Exit(1)
+	}
+	if config.Explain && config.Mode != "distance" && config.Mode != "check" {
+		printUsage("--explain only applies to distance and check modes")
+		os.Exit(1)
+	}
+	if (*againstFlag != "" || *againstFileFlag != "") && config.Mode != "distance-many" {
+		printUsage("--against and --against-file only apply to distance-many mode")
+		os.Exit(1)
+	}
+	if (*columnFlag != "" || *nearestFlag) && config.Mode != "cross-check" {
+		printUsage("--column and --nearest only apply to cross-check mode")
+		os.Exit(1)
+	}
+	if (*repoNameFlag != "" || *releaseVersionFlag != "" || *intelFlag != "") && config.Mode != "manifest" {
+		printUsage("--repo-name, --release-version and --intel only apply to manifest mode")
+		os.Exit(1)
+	}
+	config.ManifestRepo = *repoNameFlag
+	config.ManifestVersion = *releaseVersionFlag
+	config.ManifestIntel = *intelFlag
+	if (config.ClientCert == "") != (config.ClientKey == "") {
+		printUsage("--client-cert and --client-key must be given together")
+		os.Exit(1)
+	}
+	if config.Remote != "" {
+		if config.Mode != "check" && config.Mode != "scan" {
+			printUsage("--remote only applies to check and scan modes")
+			os.Exit(1)
+		}
+		if u, err := url.Parse(config.Remote); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			printUsage("--remote must be an http:// or https:// URL")
+			os.Exit(1)
+		}
+		// The server answers from its whole database with its closest
+		// records alone.
+		if config.GroupByRepo || config.Histogram || databaseFilters(config) != nil || config.Socket != "" {
+			printUsage("--remote cannot be combined with --group-by-repo, --histogram, --since, --until, --version-filter or --socket")
+			os.Exit(1)
+		}
+	}
+	if config.GRPC != "" {
+		if config.Mode != "check" && config.Mode != "scan" {
+			printUsage("--grpc only applies to check and scan modes")
+			os.Exit(1)
+		}
+		if config.Remote != "" {
+			printUsage("--grpc and --remote cannot be combined")
+			os.Exit(1)
+		}
+		if config.GroupByRepo || config.Histogram || databaseFilters(config) != nil || config.Socket != "" {
+			printUsage("--grpc cannot be combined with --group-by-repo, --histogram, --since, --until, --version-filter or --socket")
+			os.Exit(1)
+		}
+	}
+	if config.GRPCTLS && config.GRPC == "" {
+		printUsage("--grpc-tls requires --grpc")
+		os.Exit(1)
+	}
+	if config.GRPCListen != "" && config.Mode != "serve" {
+		printUsage("--grpc-listen only applies to serve mode")
+		os.Exit(1)
+	}
+	switch config.RemoteFallback {
+	case "":
+	case "local":
+		if config.Remote == "" && config.GRPC == "" {
+			printUsage("--remote-fallback requires --remote or --grpc")
+			os.Exit(1)
+		}
+	default:
+		printUsage(fmt.Sprintf("Invalid --remote-fallback %q: must be local", config.RemoteFallback))
+		os.Exit(1)
+	}
+	if tlsConfigured(config) && config.Mode != "download" && config.Mode != "update" && config.Remote == "" && !config.GRPCTLS {
+		printUsage("--ca-cert, --client-cert, --client-key and --insecure-skip-verify only apply to download and update modes, --remote and --grpc-tls")
+		os.Exit(1)
+	}
+	if (len(config.Headers) > 0 || config.BearerToken != "") && config.Mode != "download" && remoteName(config) == "" {
+		printUsage("--header and --bearer-token only apply to download mode, --remote and --grpc")
+		os.Exit(1)
+	}
+	if config.ManifestURL != "" && config.Mode != "download" {
+		printUsage("--manifest-url only applies to download mode")
+		os.Exit(1)
+	}
+	if config.Delta && config.Mode != "download" {
+		printUsage("--delta only applies to download mode")
+		os.Exit(1)
+	}
+	if config.DeltaURL != "" && !config.Delta {
+		printUsage("--delta-url requires --delta")
+		os.Exit(1)
+	}
+	if config.Mode == "download" && config.DryRun && (config.ManifestURL != "" || config.Delta) {
+		printUsage("--dry-run in download mode cannot be combined with --manifest-url or --delta")
+		os.Exit(1)
+	}
+	if config.Delta && (config.Signature != "" || config.PublicKey != "") {
+		printUsage("--delta cannot be combined with --signature or --pubkey, since the signature is of the full database")
+		os.Exit(1)
+	}
+	if config.Delta && config.ManifestURL != "" && config.DeltaURL == "" {
+		printUsage("--delta with --manifest-url requires --delta-url")
+		os.Exit(1)
+	}
+	if config.Signature != "" && config.Mode != "download" {
+		printUsage("--signature only applies to download mode")
+		os.Exit(1)
+	}
+	if config.PublicKey != "" && config.Mode != "download" && !config.RequireSigned {
+		printUsage("--pubkey only applies to download mode and --require-signed")
+		os.Exit(1)
+	}
+	if config.RequireSigned {
+		switch config.Mode {
+		case "check", "scan", "watch", "procscan", "serve", "daemon":
+		default:
+			printUsage("--require-signed only applies to check, scan, watch, procscan, serve and daemon modes")
+			os.Exit(1)
+		}
+	}
+	if config.MinEntropy > 0 && config.Mode != "scan" && config.Mode != "watch" {
+		printUsage("--min-entropy only applies to scan and watch modes")
+		os.Exit(1)
+	}
+	if (config.SkipExt != nil || config.OnlyExt != nil) && config.Mode != "scan" {
+		printUsage("--skip-ext and --only-ext only apply to scan mode")
+		os.Exit(1)
+	}
+	if config.Types != 0 && config.Mode != "scan" && config.Mode != "watch" {
+		printUsage("--types only applies to scan and watch modes")
+		os.Exit(1)
+	}
+	if config.CheckOnly && config.Mode != "update" {
+		printUsage("--check-only only applies to update mode")
+		os.Exit(1)
+	}
+	if config.UseEmbedded {
+		switch config.Mode {
+		case "check", "scan", "watch", "procscan", "daemon":
+		default:
+			printUsage("--use-embedded only applies to check, scan, watch, procscan and daemon modes")
+			os.Exit(1)
+		}
+	}
+	if config.DbPath == stdinDatabase {
+		switch config.Mode {
+		case "check", "scan", "search", "db-quality", "procscan":
+		default:
+			printUsage("--db - only applies to check, scan, search, db-quality and procscan modes, which read the database once")
+			os.Exit(1)
+		}
+		if config.RequireSigned || config.UseEmbedded {
+			printUsage("--db - cannot be combined with --require-signed or --use-embedded")
+			os.Exit(1)
+		}
+		if config.FileList == "-" {
+			printUsage("--db - and --filelist - cannot both read stdin")
+			os.Exit(1)
+		}
+	}
+	if (config.Mode == "download" || remoteName(config) != "") && config.BearerToken == "" {
+		config.BearerToken = os.Getenv("CELESTLSH_TOKEN")
+	}
+	if config.Webhook != "" && config.Mode != "scan" && config.Mode != "watch" && config.Mode != "webhook-test" {
+		printUsage("--webhook only applies to scan and watch modes")
+		os.Exit(1)
+	}
+	if config.Syslog != "" {
+		if config.Mode != "scan" && config.Mode != "watch" {
+			printUsage("--syslog only applies to scan and watch modes")
+			os.Exit(1)
+		}
+		if _, _, err := parseSyslogTarget(config.Syslog); err != nil {
+			printUsage(fmt.Sprintf("Invalid --syslog: %v", err))
+			os.Exit(1)
+		}
+		if _, ok := syslogFacilities[config.SyslogFacility]; !ok {
+			printUsage(fmt.Sprintf("Unknown --syslog-facility %q", config.SyslogFacility))
+			os.Exit(1)
+		}
+		if _, ok := syslogSeverities[config.SyslogSeverity]; !ok {
+			printUsage(fmt.Sprintf("Unknown --syslog-severity %q", config.SyslogSeverity))
+			os.Exit(1)
+		}
+	}
+	if config.Format == "stix" || config.Format == "misp" || config.Format == "cef" {
+		// Matched files are identified by their SHA-256.
+		config.Digests |= celestlsh.DigestSHA256
+	}
+
+	return config
+}
+
+// execute runs the selected mode. The status is only meaningful when the
+// error is nil.
+func execute(ctx context.Context, config Config) (status, error) {
+	if config.FileList != "" && (config.Mode == "hash" || config.Mode == "scan") {
+		paths, err := collectInputs(config)
+		if err != nil {
+			return statusOK, err
+		}
+		config.Paths = uniquePaths(paths)
+	}
+
+	switch config.Mode {
+	case "hash":
+		return executeHash(ctx, config)
+	case "distance":
+		return statusOK, executeDistance(ctx, config)
+	case "distance-many":
+		return executeDistanceMany(config)
+	case "ssdeep-compare":
+		return statusOK, executeSSDeepCompare(config)
+	case "matrix":
+		return executeMatrix(ctx, config)
+	case "cluster":
+		return executeCluster(ctx, config)
+	case "find-dupes":
+		return executeFindDupes(ctx, config)
+	case "compare-dirs":
+		return executeCompareDirs(ctx, config)
+	case "manifest":
+		return executeManifest(ctx, config)
+	case "db-import":
+		return executeDBImport(config)
+	case "cross-check":
+		return executeCrossCheck(ctx, config)
+	case "search":
+		return executeSearch(ctx, config)
+	case "db-prune":
+		return executeDBPrune(config)
+	case "db-quality":
+		return executeDBQuality(ctx, config)
+	case "validate-db":
+		return executeValidateDB(config)
+	case "download":
+		return statusOK, executeDownload(ctx, config)
+	case "check":
+		return executeCheck(ctx, config)
+	case "verify":
+		return executeVerify(ctx, config)
+	case "validate":
+		return executeValidate(config)
+	case "bench":
+		return statusOK, executeBench(ctx, config)
+	case "selftest":
+		return statusOK, executeSelftest(config)
+	case "update":
+		return statusOK, executeUpdate(ctx, config)
+	case "dump-embedded":
+		return statusOK, executeDumpEmbedded(config)
+	case "template-help":
+		printTemplateHelp()
+		return statusOK, nil
+	case "serve":
+		return statusOK, executeServe(ctx, config)
+	case "daemon":
+		return statusOK, executeDaemon(ctx, config)
+	case "scan":
+		return executeScan(ctx, config)
+	case "watch":
+		return statusOK, executeWatch(ctx, config)
+	case "procscan":
+		return executeProcScan(ctx, config)
+	case "results":
+		return statusOK, executeResults(ctx, config)
+	case "webhook-test":
+		return statusOK, executeWebhookTest(ctx, config)
+	default:
+		return statusOK, fmt.Errorf("unknown mode: %s", config.Mode)
+	}
+}
+
+func executeHash(ctx context.Context, config Config) (status, error) {
+	hasher := newHasher(config)
+
+	// With --json the results are printed together once all are hashed.
+	var hashed []scanResult
+	show := func(result scanResult) {
+		if config.OutputJSON && result.Error == "" {
+			hashed = append(hashed, result)
+			return
+		}
+		printHashResult(config, result, len(config.Paths) > 1)
+	}
+
+	if len(config.Paths) == 1 {
+		result := hashFile(ctx, &hasher, config, config.Paths[0])
+		if result.Error != "" {
+			return statusOK, errors.New(result.Error)
+		}
+		show(result)
+		return statusOK, printHashDocument(config, hashed)
+	}
+
+	done, failed := 0, 0
+	report := func(result scanResult) {
+		done++
+		if result.Error != "" {
+			failed++
+		}
+		show(result)
+	}
+
+	// Files being hashed when interrupted may finish; the rest are skipped.
+	work, cancel := graceContext(ctx)
+	defer cancel()
+
+	if workers := workerCount(config); workers > 1 {
+		pool := newResultPool(workers, config.Unordered, report)
+		for _, path := range config.Paths {
+			if ctx.Err() != nil {
+				break
+			}
+			pool.submit(func() []scanResult {
+				if ctx.Err() != nil {
+					return nil
+				}
+				hasher := newHasher(config)
+				return []scanResult{hashFile(work, &hasher, config, path)}
+			})
+		}
+		pool.wait()
+	} else {
+		for _, path := range config.Paths {
+			if ctx.Err() != nil {
+				break
+			}
+			report(hashFile(work, &hasher, config, path))
+		}
+	}
+
+	if config.OutputJSONL {
+		printJSONLSummary(jsonlSummary{
+			Files:       int64(done),
+			Results:     int64(done),
+			Failed:      int64(failed),
+			Pending:     int64(len(config.Paths) - done),
+			Interrupted: ctx.Err() != nil,
+		})
+	}
+
+	if err := printHashDocument(config, hashed); err != nil {
+		return statusOK, err
+	}
+	if ctx.Err() != nil {
+		return statusOK, fmt.Errorf("interrupted: %d of %d files hashed", done, len(config.Paths))
+	}
+	if failed > 0 {
+		fmt.Fprintf(os.Stderr, "Warning: failed to hash %d of %d files\n", failed, len(config.Paths))
+	}
+
+	return batchStatus(0, failed), nil
+}
+
+func hashFile(ctx context.Context, hasher *celestlsh.Hasher, config Config, path string) scanResult {
+	digests, err := hasher.DigestFile(ctx, path, config.Digests)
+	if err != nil {
+		return scanResult{Path: path, Error: hashError(err), ErrorCode: errorCode(err)}
+	}
+	if config.Sections {
+		if f, err := os.Open(path); err == nil {
+			digests.Sections = hashSections(ctx, hasher, path, f)
+			f.Close()
+		}
+	}
+	if config.T1 {
+		digests.TLSH = celestlsh.WithT1Prefix(digests.TLSH)
+		for i := range digests.Sections {
+			digests.Sections[i].TLSH = celestlsh.WithT1Prefix(digests.Sections[i].TLSH)
+		}
+	}
+	return scanResult{Path: path, Digests: digests}
+}
+
+// newHasher returns a hasher honouring --force.
+func newHasher(config Config) celestlsh.Hasher {
+	return celestlsh.Hasher{Force: config.Force}
+}
+
+// hashError describes a failure to hash a file, explaining files that are
+// too small or too uniform for TLSH rather than quoting the library error.
+func hashError(err error) string {
+	var short *celestlsh.InsufficientDataError
+	if !errors.As(err, &short) {
+		return fmt.Sprintf("failed to calculate TLSH hash: %v", err)
+	}
+	if short.LowComplexity {
+		return fmt.Sprintf("file contents are too uniform for a TLSH hash (%d bytes with too little variation)", short.Size)
+	}
+	msg := fmt.Sprintf("file is too small for a TLSH hash: %d bytes, the minimum is %d", short.Size, short.MinSize)
+	if short.MinSize > celestlsh.MinForcedDataLength && short.Size >= celestlsh.MinForcedDataLength {
+		msg += fmt.Sprintf(" (--force lowers it to %d)", celestlsh.MinForcedDataLength)
+	}
+	return msg
+}
+
+// printHashDocument prints the files hashed with --json as an array.
+func printHashDocument(config Config, hashed []scanResult) error {
+	if !config.OutputJSON {
+		return nil
+	}
+	if hashed == nil {
+		hashed = []scanResult{}
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(hashed); err != nil {
+		return fmt.Errorf("failed to write results: %w", err)
+	}
+	return nil
+}
+
+// printHashResult prints the digests of a hashed file. When several files
+// are hashed, the text format gives each one line, starting with its path.
+func printHashResult(config Config, result scanResult, several bool) {
+	digests := result.Digests
+
+	switch {
+	case result.Error != "":
+		printResultError(config, result)
+	case config.Template != nil:
+		printTemplate(config, result)
+	case config.OutputJSONL:
+		line, err := json.Marshal(result)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %s: %v\n", displayPath(result.Path), err)
+			return
+		}
+		fmt.Println(string(line))
+	case config.Quiet:
+		fields := digestFields(config.Digests, digests)
+		for i, field := range fields {
+			if field == "" {
+				fields[i] = "-"
+			}
+		}
+		fmt.Println(strings.Join(fields, " "))
+	case config.OutputCSV:
+		fmt.Println(csvLine(append([]string{result.Path}, digestFields(config.Digests, digests)...)))
+	case several:
+		fmt.Printf("%s: %s%s\n", displayPath(result.Path), digests.TLSH, digestSuffix(digests))
+	default:
+		fmt.Printf("TLSH hash of %s: %s\n", displayPath(result.Path), digests.TLSH)
+		for _, sum := range []struct{ name, value string }{{"MD5", digests.MD5}, {"SHA1", digests.SHA1}, {"SHA256", digests.SHA256}} {
+			if sum.value != "" {
+				fmt.Printf("%s hash of %s: %s\n", sum.name, displayPath(result.Path), sum.value)
+			}
+		}
+		if config.Digests&celestlsh.DigestImphash != 0 {
+			imphash := digests.Imphash
+			if imphash == "" {
+				imphash = "none (not a PE file with imports)"
+			}
+			fmt.Printf("Imphash of %s: %s\n", displayPath(result.Path), imphash)
+		}
+		if config.Digests&celestlsh.DigestEntropy != 0 {
+			fmt.Printf("Entropy of %s: %s bits per byte\n", displayPath(result.Path), formatEntropy(digests.Entropy))
+		}
+		if digests.SSDeep != "" {
+			fmt.Printf("ssdeep hash of %s: %s\n", displayPath(result.Path), digests.SSDeep)
+		}
+		if config.Sections {
+			if len(digests.Sections) == 0 {
+				fmt.Printf("Sections of %s: none (not a PE or ELF file with readable executable sections)\n", displayPath(result.Path))
+			}
+			for _, section := range digests.Sections {
+				fmt.Printf("TLSH hash of section %s of %s: %s\n", sectionName(section.Name), displayPath(result.Path), section.TLSH)
+			}
+		}
+	}
+}
+
+func executeDistance(ctx context.Context, config Config) error {
+	hash1, err := resolveDistanceInput(ctx, config, config.Hash1)
+	if err != nil {
+		return err
+	}
+
+	hash2, err := resolveDistanceInput(ctx, config, config.Hash2)
+	if err != nil {
+		return err
+	}
+
+	distance, err := celestlsh.Distance(hash1, hash2)
+	if err != nil {
+		return fmt.Errorf("failed to calculate TLSH distance: %w", err)
+	}
+	var parts *celestlsh.DistanceParts
+	if config.Explain {
+		p, err := celestlsh.ExplainDistance(hash1, hash2)
+		if err != nil {
+			return fmt.Errorf("failed to calculate TLSH distance: %w", err)
+		}
+		parts = &p
+	}
+
+	switch {
+	case config.OutputJSON:
+		return printJSON(struct {
+			Hash1    string                   `json:"hash1"`
+			Hash2    string                   `json:"hash2"`
+			Distance int                      `json:"distance"`
+			Explain  *celestlsh.DistanceParts `json:"explain,omitempty"`
+		}{hash1, hash2, distance, parts})
+	case config.Quiet:
+		fmt.Println(distance)
+	default:
+		fmt.Printf("Distance between hashes: %d\n", distance)
+		if parts != nil {
+			fmt.Println(formatDistanceParts(*parts))
+		}
+	}
+
+	return nil
+}
+
+// formatDistanceParts describes the terms of a distance for --explain.
+func formatDistanceParts(p celestlsh.DistanceParts) string {
+	return fmt.Sprintf("Header %d (length %d, quartile ratios %d + %d, checksum %d) + body %d = %d",
+		p.Header, p.Length, p.Q1Ratio, p.Q2Ratio, p.Checksum, p.Body, p.Total)
+}
+
+// resolveDistanceInput returns the TLSH hash for a distance mode argument.
+// Arguments that are valid hashes are used as they are unless --files is
+// given; otherwise an existing file is hashed, noting the hash unless in
+// quiet mode. Anything else is passed through to fail hash parsing.
+func resolveDistanceInput(ctx context.Context, config Config, arg string) (string, error) {
+	if !config.DistanceFiles {
+		if celestlsh.ValidateHash(arg) == nil {
+			return arg, nil
+		}
+		if info, err := os.Stat(arg); err != nil || info.IsDir() {
+			return arg, nil
+		}
+	}
+
+	hasher := newHasher(config)
+	hash, err := hasher.HashFile(ctx, arg)
+	if err != nil {
+		return "", fmt.Errorf("%s: %s", arg, hashError(err))
+	}
+
+	if !config.Quiet {
+		fmt.Printf("TLSH hash of %s: %s\n", arg, hash)
+	}
+
+	return hash, nil
+}
+
+func executeDownload(ctx context.Context, config Config) error {
+	downloader := celestlsh.NewDownloader()
+	transport, err := newHTTPTransport(config)
+	if err != nil {
+		return err
+	}
+	downloader.Client.Transport = transport
+	downloader.Header = config.Headers.header()
+	if config.BearerToken != "" {
+		downloader.Header.Set("Authorization", "Bearer "+config.BearerToken)
+	}
+	if config.DryRun {
+		return probeDownload(ctx, config, downloader)
+	}
+	download := downloader.Download
+	if config.ManifestURL != "" {
+		downloader.URL = config.ManifestURL
+		downloader.Concurrency = config.Workers
+		download = downloader.DownloadShards
+	}
+	if config.Delta {
+		err := downloadDelta(ctx, config, downloader)
+		var unavailable *deltaUnavailable
+		if !errors.As(err, &unavailable) {
+			return err
+		}
+		if !config.Quiet {
+			fmt.Fprintf(os.Stderr, "Falling back to a full download: %v\n", unavailable)
+		}
+	}
+	signed := config.Signature != "" || config.PublicKey != ""
+	if signed {
+		if err := verifyDownload(ctx, config, downloader); err != nil {
+			return err
+		}
+	}
+	slog.Info("downloading database", "url", downloader.URL, "path", config.DbPath)
+	start := time.Now()
+
+	// Shards all report to one progress line, started with the first.
+	var progress *progressLine
+	if progressEnabled(config) {
+		var received atomic.Int64
+		var once sync.Once
+		downloader.Progress = func(body io.Reader, total int64) io.Reader {
+			once.Do(func() { progress = startProgress(downloadProgress(&received, total)) })
+			return &countingReader{r: body, n: &received}
+		}
+	}
+
+	err = download(ctx, config.DbPath)
+	progress.finish()
+	if errors.Is(err, celestlsh.ErrAuthenticationFailed) {
+		return fmt.Errorf("failed to download CSV database: %w; check --bearer-token, $CELESTLSH_TOKEN or --header", err)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to download CSV database: %w", err)
+	}
+	slog.Info("database downloaded", "path", config.DbPath, "duration", time.Since(start))
+	saveDBMeta(config.DbPath, downloader.URL)
+	if !signed {
+		// A signature left from an earlier download no longer applies.
+		if err := os.Remove(signaturePath(config.DbPath)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+
+	if !config.Quiet {
+		fmt.Printf("CSV database downloaded to %s\n", config.DbPath)
+	}
+
+	return nil
+}
+
+func printUsage(errorMsg string) {
+	if errorMsg != "" {
+		fmt.Fprintf(os.Stderr, "Error: %s\n\n", errorMsg)
+	}
+
+	fmt.Println("TLSH CLI Tool - Calculate and compare TLSH hashes")
+	fmt.Println("\nUsage:")
+	fmt.Println("  Calculate TLSH hash of a file:")
+	fmt.Println("    tlsh-cli -h <file_path>...")
+	fmt.Println("    tlsh-cli --hash [--filelist[0] <path>] <file_path>...")
+	fmt.Println("\n  Calculate distance between two TLSH hashes:")
+	fmt.Println("    tlsh-cli -d <hash1> <hash2>")
+	fmt.Println("    tlsh-cli --distance <hash1> <hash2>")
+	fmt.Println("    tlsh-cli -d [--files] <file|hash> <file|hash>")
+	fmt.Println("\n  Calculate the distance between a TLSH hash and each of several candidates, closest first:")
+	fmt.Println("    tlsh-cli --distance-many <hash> [--against <h1,h2,...>] [--against-file <path>] [--csv|--json] [<hash>...]")
+	fmt.Println("\n  Calculate the similarity of two ssdeep hashes (0-100):")
+	fmt.Println("    tlsh-cli --ssdeep-compare <file|hash> <file|hash>")
+	fmt.Println("\n  Print the pairwise distance matrix of files and hashes:")
+	fmt.Println("    tlsh-cli --matrix [--files] [--filelist <path>] [--long] [--json] <file|hash>...")
+	fmt.Println("\n  Cluster files by TLSH distance:")
+	fmt.Println("    tlsh-cli --cluster <threshold> [--filelist <path>] [--json] <dir|file>...")
+	fmt.Println("\n  Find near-duplicate files in a directory:")
+	fmt.Println("    tlsh-cli --find-dupes <dir> [--max-distance <n>] [--min-size <size>] [--json]")
+	fmt.Println("\n  Compare two directory trees, pairing renamed files by TLSH distance:")
+	fmt.Println("    tlsh-cli --compare-dirs [--max-distance <n>] [--include <glob>] [--exclude <glob>] [--csv|--json] <dirA> <dirB>")
+	fmt.Println("\n  List a directory as rows of the database CSV schema, to contribute a release:")
+	fmt.Println("    tlsh-cli --manifest <dir> --repo-name <name> --release-version <version> [--intel <text>] [-o <path>]")
+	fmt.Println("\n  Add the rows of a manifest to the database, skipping duplicates:")
+	fmt.Println("    tlsh-cli --db-import <manifest.csv> [--db <database_path>] [--dry-run]")
+	fmt.Println("\n  Remove database rows added before a date:")
+	fmt.Println("    tlsh-cli --db-prune --before <date> [--repo <name>]... [--prune-undated] [--out <path>] [--dry-run] [--db <database_path>]")
+	fmt.Println("\n  Find the similar pairs between two lists of TLSH hashes:")
+	fmt.Println("    tlsh-cli --cross-check [--max-distance <n>] [--nearest] [--column <name|n>] [--json] <listA> <listB>")
+	fmt.Println("\n  Download the CSV database of TLSH hashes:")
+	fmt.Println("    tlsh-cli -dl [--db <output_path>]")
+	fmt.Println("    tlsh-cli --download [--db <output_path>]")
+	fmt.Println("    tlsh-cli --download --manifest-url <url> [--workers <n>] [--db <output_path>]")
+	fmt.Println("    tlsh-cli --download --delta [--delta-url <url>] [--db <output_path>]")
+	fmt.Println("    tlsh-cli --download --dry-run [--json] [--db <database_path>]")
+	fmt.Println("\n  Check TLSH hashes against the database:")
+	fmt.Println("    tlsh-cli -c <hash>... [--db <database_path>]")
+	fmt.Println("    tlsh-cli --check <hash>... [--db <database_path>]")
+	fmt.Println("\n  List database records by repo or file name:")
+	fmt.Println("    tlsh-cli --search <text> [--regex] [--search-intel] [--limit <n>] [--csv|--json] [--db <database_path>]")
+	fmt.Println("\n  Report how many database records are near-duplicates of each other:")
+	fmt.Println("    tlsh-cli --db-quality [--full|--sample <n>] [--confidence-bands <h,m,l>] [--json] [--db <database_path>]")
+	fmt.Println("\n  Check that every row of the database follows the CSV schema, as a manifest must:")
+	fmt.Println("    tlsh-cli --validate-db [--json] [--db <database_path>]")
+	fmt.Println("\n  Check that a file is within a distance of its expected TLSH hash:")
+	fmt.Println("    tlsh-cli --verify [--max-distance <n>] <file_path> <expected_hash>")
+	fmt.Println("\n  Check that TLSH hashes are well formed, reading them from stdin for -:")
+	fmt.Println("    tlsh-cli --validate [--json] <hash|->...")
+	fmt.Println("\n  Measure hashing and database lookup throughput:")
+	fmt.Println("    tlsh-cli --bench [--bench-time <duration>] [--workers <n>] [--db <database_path>] [--json]")
+	fmt.Println("\n  Update this binary to the latest release:")
+	fmt.Println("    tlsh-cli update [--check-only]")
+	fmt.Println("\n  Write out the database built into the binary (needs -tags embeddb):")
+	fmt.Println("    tlsh-cli --dump-embedded <path|->")
+	fmt.Println("\n  List the fields available to --template:")
+	fmt.Println("    tlsh-cli --template-help")
+	fmt.Println("\n  Check this build's TLSH implementation against built-in test vectors:")
+	fmt.Println("    tlsh-cli --selftest")
+	fmt.Println("\n  Serve hash and check endpoints over HTTP:")
+	fmt.Println("    tlsh-cli --serve [--listen <addr:port>] [--grpc-listen <addr:port>] [--db <database_path>]")
+	fmt.Println("\n  Serve checks over a Unix domain socket:")
+	fmt.Println("    tlsh-cli --daemon --socket <path> [--db <database_path>]")
+	fmt.Println("\n  Check files, directories and archives against the database:")
+	fmt.Println("    tlsh-cli -s <path>... [--db <database_path>]")
+	fmt.Println("    tlsh-cli --scan [--filelist[0] <path>] <path>... [--db <database_path>]")
+	fmt.Println("\n  Check the files of a container image saved by docker save:")
+	fmt.Println("    tlsh-cli --scan-image <image.tar> [--db <database_path>] [--max-distance <n>]")
+	fmt.Println("\n  Watch a directory and check new files against the database:")
+	fmt.Println("    tlsh-cli --watch <dir> [--db <database_path>] [--max-distance <n>] [--jsonl]")
+	fmt.Println("\n  Check the executables of running processes (Linux only):")
+	fmt.Println("    tlsh-cli procscan [--db <database_path>] [--max-distance <n>]")
+	fmt.Println("\n  Query scan history recorded with --results-db:")
+	fmt.Println("    tlsh-cli results --results-db <path> last | path <path> | new <date>")
+	fmt.Println("\nOptions:")
+	fmt.Println("  --quiet        Output only the hash, distance, or SHA256 value")
+	fmt.Println("  --sha256       Also compute the SHA256 of hashed files")
+	fmt.Println("  --all-hashes   Also compute the MD5, SHA1 and SHA256 of hashed files")
+	fmt.Println("  --imphash      Also compute the import hash of PE files")
+	fmt.Println("  --ssdeep       Also compute the ssdeep fuzzy hash of hashed files")
+	fmt.Println("  --entropy      Also compute the Shannon entropy of hashed files")
+	fmt.Println("  --sections     Also hash the executable sections of PE and ELF files")
+	fmt.Println("  --min-entropy <bits> Flag scanned files with at least this entropy")
+	fmt.Println("  --csv          Output check results in CSV format")
+	fmt.Println("  --db <path>    Specify the database path, or - for stdin (default: tlsh_hashes.csv)")
+	fmt.Println("  --stale-after <age> Warn when the database is older than this, e.g. 30d or 36h (default: 14d; 0 to turn off)")
+	fmt.Println("  --fail-if-stale Fail instead of warning when the database is older than --stale-after")
+	fmt.Println("  --listen <addr> Address for serve mode (default: 127.0.0.1:8080)")
+	fmt.Println("  --socket <path> Daemon socket; check and scan modes use it when the daemon is running")
+	fmt.Println("  --remote <url> Check hashes against a serve mode instance instead of the database (check and scan modes)")
+	fmt.Println("  --remote-fallback local Use the local database when --remote or --grpc is unavailable")
+	fmt.Println("  --grpc <host:port> Check hashes against a serve mode instance's gRPC service instead (check and scan modes)")
+	fmt.Println("  --grpc-tls     Connect to --grpc over TLS, with the --ca-cert and --client-cert options")
+	fmt.Println("  --grpc-listen <addr> Also serve the gRPC service and gRPC health checks on this address (serve mode)")
+	fmt.Println("  --max-distance <n> Only report matches within this distance (default: the end of the low confidence band; 30 for find-dupes and compare-dirs; -1 for no limit)")
+	fmt.Println("  --full, --sample <n> Compare every pair of records in db-quality mode, or a sample of n (default: 2000)")
+	fmt.Println("  --limit <n>    Records kept per query, closest first (search, check, cross-check, serve and daemon modes; default: all for search, 5000 otherwise)")
+	fmt.Println("  --explain      Break distances down into header and body terms (distance and check modes)")
+	fmt.Println("  --confidence-bands <h,m,l> Largest distances of high, medium and low confidence (default: 30,60,100)")
+	fmt.Println("  --min-confidence <label> Only report matches of at least high, medium or low confidence")
+	fmt.Println("  --group-by-repo Report the best match of each repository (check, scan and watch modes)")
+	fmt.Println("  --top <n>      List the n closest records in check mode, or repositories with --group-by-repo (default: all repositories)")
+	fmt.Println("  --sort <fields> Order --group-by-repo and --top results by distance, repo, file, date or version; - for descending")
+	fmt.Println("  --since <date>, --until <date> Only compare against records added in this range (check, scan, watch and procscan modes)")
+	fmt.Println("  --version-filter <glob> Only compare against records whose version matches, such as 4.* or 4.x")
+	fmt.Println("  --strict-dates Also leave out records without a valid date when filtering by date")
+	fmt.Println("  --histogram[-only] Print a histogram of the distances of all records within --max-distance (check mode)")
+	fmt.Println("  --histogram-width <n> Distances per histogram bucket (default: 10)")
+	fmt.Println("  --workers <n>  Files hashed and records compared in parallel (default: one per CPU)")
+	fmt.Println("  -o, --output <path> Write results to a file instead of stdout")
+	fmt.Println("  --append       Append to the output file (e.g. for watch mode)")
+	fmt.Println("  --syslog[=<addr>] Forward matches to local syslog, or udp://host:port or tcp://host:port (scan and watch modes)")
+	fmt.Println("  --syslog-facility <name> Syslog facility (default: user)")
+	fmt.Println("  --syslog-severity <name> Syslog severity of matches (default: warning)")
+	fmt.Println("  --syslog-summary Also forward a summary when a scan finishes")
+	fmt.Println("  --webhook <url> POST each match as JSON to this URL (scan and watch modes)")
+	fmt.Println("  --webhook-header 'Name: value' Extra header for webhook requests (repeatable)")
+	fmt.Println("  --file-timeout <duration> Give up on a scanned file that takes longer to open and read (default: 5m; 0 for no limit)")
+	fmt.Println("  --webhook-timeout <duration> Timeout of each webhook request (default: 10s)")
+	fmt.Println("  --webhook-test Send a sample notification to --webhook and exit")
+	fmt.Println("  --header 'Name: value' Extra header for download, --remote and --grpc requests (repeatable)")
+	fmt.Println("  --bearer-token <token> Bearer token for download, --remote and --grpc requests (default: $CELESTLSH_TOKEN)")
+	fmt.Println("  --use-embedded Use the database built into the binary even if --db exists")
+	fmt.Println("  --check-only   With update, only report whether a newer release exists")
+	fmt.Println("  --signature <path|url> Verify the download against this minisign signature")
+	fmt.Println("  --pubkey <key|path> Minisign public key signing the database")
+	fmt.Println("  --require-signed Refuse to use a database without a valid recorded signature")
+	fmt.Println("  --ca-cert <path> Also trust these PEM CA certificates when downloading or updating")
+	fmt.Println("  --client-cert <path>, --client-key <path> Client certificate for mirrors that require one")
+	fmt.Println("  --insecure-skip-verify Do not verify the server's certificate when downloading or updating (unsafe)")
+	fmt.Println("  --no-progress  Do not show download and scan progress on stderr")
+	fmt.Println("  --color=<when> Colour text output: auto, always or never (default: auto)")
+	fmt.Println("  --no-color     Do not colour text output; also set by the NO_COLOR environment variable")
+	fmt.Println("  --unordered    Print hash and scan results as they finish")
+	fmt.Println("  --fail-fast    Stop a scan at the first match")
+	fmt.Println("  --throttle <MiB/s> Limit how fast a scan reads files, across all workers (default: no limit)")
+	fmt.Println("  --nice-idle    Pause briefly after each scanned file")
+	fmt.Println("  --report <path> Write an HTML report of the scan to this file")
+	fmt.Println("  --hash-cache <path> Skip reading files unchanged since an earlier scan (default: $CELESTLSH_HASH_CACHE)")
+	fmt.Println("  --no-cache, --refresh-cache Ignore the hash cache, or hash every file again and update it")
+	fmt.Println("  --order <order> Scan files by mtime-desc, mtime-asc, size-asc, size-desc or name (default: walk order)")
+	fmt.Println("  --min-size <size> Ignore smaller files in find-dupes mode (default: 0)")
+	fmt.Println("  --json         Output a single JSON document (hash, distance, check, validate, bench, matrix, cluster, find-dupes, compare-dirs, cross-check, search, db-quality and validate-db modes)")
+	fmt.Println("  --format <name> Output format: text, table, csv, json, jsonl, sarif, stix, misp, cef, markdown or junit (check and scan modes; junit scan only)")
+	fmt.Println("  --template <tmpl>, --template-file <path> Render each result through a Go text/template (hash, check, scan and watch modes)")
+	fmt.Println("  --wide         Do not cut long fields short in table output")
+	fmt.Println("  --misp-event-info <title> Title of the MISP event (default: CelesTLSH scan results)")
+	fmt.Println("  --jsonl        Output one JSON object per result line (hash, validate, scan, watch and procscan modes)")
+	fmt.Println("  --zip-password <pw> Password for encrypted zip members (default: infected)")
+	fmt.Println("  --types <list>  Only hash pe, elf, macho and/or script files (default: all)")
+	fmt.Println("  --max-depth <n> Levels of subdirectories a scan descends into; 0 for none (default: no limit)")
+	fmt.Println("  --skip-hidden  Skip hidden files and directories when scanning")
+	fmt.Println("  --skip-ext <list>, --only-ext <list> Skip or only scan files with these extensions, such as .iso,.vmdk")
+	fmt.Println("  --archive-depth <n> Levels of nested archives to open (default: 1)")
+	fmt.Println("  --max-extracted <size> Decompressed bytes allowed per archive (default: 1G)")
+	fmt.Println("  -v, --verbose  Log the database loaded, skipped entries and timings to stderr")
+	fmt.Println("  --debug        Also log per-file decisions, skipped database rows and HTTP requests")
+	fmt.Println("  --log-file <path> Append the log to a file instead of stderr")
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Magonia-Research/CelesTLSH-CLI/pkg/celestlsh"
+)
+
+func TestMain(m *testing.M) {
+	// For runMain, the test binary runs as the program itself.
+	if os.Getenv("CELESTLSH_TEST_MAIN") == "1" {
+		main()
+	}
+	// main sets up logging from the flags; tests that want the log set
+	// their own.
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	os.Exit(m.Run())
+}
+
+// parseTestFlags returns the configuration parseFlags makes of args, as
+// if they followed the program name on the command line. Invalid args
+// exit the test binary, as they would the program.
+func parseTestFlags(t testing.TB, args ...string) Config {
+	t.Helper()
+	oldArgs, oldFlags := os.Args, flag.CommandLine
+	t.Cleanup(func() { os.Args, flag.CommandLine = oldArgs, oldFlags })
+
+	os.Args = append([]string{"celestlsh-cli"}, args...)
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	return parseFlags()
+}
+
+// sampleData returns n bytes of pseudo-random data that is the same for
+// the same seed, varied enough to hash.
+func sampleData(seed uint64, n int) []byte {
+	r := rand.New(rand.NewPCG(seed, seed))
+	data := make([]byte, n)
+	for i := range data {
+		data[i] = byte(r.Uint32())
+	}
+	return data
+}
+
+// variantData returns a copy of data with every step-th byte changed, for
+// a hash near that of data.
+func variantData(data []byte, step int) []byte {
+	out := append([]byte(nil), data...)
+	for i := 0; i < len(out); i += step {
+		out[i] ^= 0x5a
+	}
+	return out
+}
+
+func testHash(t testing.TB, data []byte) string {
+	t.Helper()
+	var h celestlsh.Hasher
+	hash, err := h.HashBytes(data)
+	if err != nil {
+		t.Fatalf("HashBytes: %v", err)
+	}
+	return hash
+}
+
+// testSample is a file planted in test trees and archives whose hash is in
+// the database of writeTestDatabase, as the record for known.exe.
+var testSample = sampleData(1, 8192)
+
+// testRecords returns the records of writeTestDatabase: known.exe, which
+// is testSample, a near variant of it, and two unrelated tools.
+func testRecords(t testing.TB) []celestlsh.HashRecord {
+	t.Helper()
+	record := func(repo, file string, data []byte) celestlsh.HashRecord {
+		return celestlsh.HashRecord{
+			RepoName:   repo,
+			FileName:   file,
+			Version:    "v1.0",
+			TLSHHash:   testHash(t, data),
+			SHA256Hash: strings.Repeat("ab", 32),
+			DateAdded:  "2024-03-01",
+			Intel:      "https://example.com/" + repo,
+		}
+	}
+	return []celestlsh.HashRecord{
+		record("KnownTool", "known.exe", testSample),
+		record("KnownTool", "known-variant.exe", variantData(testSample, 40)),
+		record("OtherTool", "other.exe", sampleData(2, 8192)),
+		record("ThirdTool", "third.dll", sampleData(3, 8192)),
+	}
+}
+
+// writeTestDatabase writes the records of testRecords, or the given ones,
+// as a database CSV in a temporary directory and returns its path.
+func writeTestDatabase(t testing.TB, records ...celestlsh.HashRecord) string {
+	t.Helper()
+	if records == nil {
+		records = testRecords(t)
+	}
+	path := filepath.Join(t.TempDir(), "db.csv")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := csv.NewWriter(f)
+	w.Write(celestlsh.Header)
+	for _, r := range records {
+		w.Write(r.CSVRow())
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// writeFile writes data to name under dir, creating its parent
+// directories, and returns its path.
+func writeFile(t testing.TB, dir, name string, data []byte) string {
+	t.Helper()
+	path := filepath.Join(dir, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// runCLI runs the program with args, as main would up to its exit, and
+// returns what it wrote to stdout and stderr with the status and error
+// execute returned.
+func runCLI(t testing.TB, args ...string) (stdout, stderr string, st status, err error) {
+	t.Helper()
+	return runCLIContext(t, context.Background(), args...)
+}
+
+// runCLIContext is runCLI with a context, to interrupt the run.
+func runCLIContext(t testing.TB, ctx context.Context, args ...string) (stdout, stderr string, st status, err error) {
+	t.Helper()
+	config := parseTestFlags(t, args...)
+
+	dir := t.TempDir()
+	outFile, err := os.Create(filepath.Join(dir, "stdout"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	errFile, err := os.Create(filepath.Join(dir, "stderr"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldOut, oldErr := os.Stdout, os.Stderr
+	os.Stdout, os.Stderr = outFile, errFile
+	func() {
+		defer func() { os.Stdout, os.Stderr = oldOut, oldErr }()
+		st, err = execute(ctx, config)
+	}()
+	outFile.Close()
+	errFile.Close()
+
+	out, _ := os.ReadFile(outFile.Name())
+	errOut, _ := os.ReadFile(errFile.Name())
+	return string(out), string(errOut), st, err
+}
+
+// parseJSONL splits the --jsonl output of a scan into its results and
+// its summary record.
+func parseJSONL(t testing.TB, out string) (results []scanResult, summary jsonlSummary) {
+	t.Helper()
+	sc := bufio.NewScanner(strings.NewReader(out))
+	sc.Buffer(nil, 1<<20)
+	for sc.Scan() {
+		line := sc.Bytes()
+		if strings.Contains(string(line), `"type":"summary"`) {
+			if err := json.Unmarshal(line, &summary); err != nil {
+				t.Fatalf("parsing summary %s: %v", line, err)
+			}
+			continue
+		}
+		var r scanResult
+		if err := json.Unmarshal(line, &r); err != nil {
+			t.Fatalf("parsing result %s: %v", line, err)
+		}
+		results = append(results, r)
+	}
+	return results, summary
+}
+
+// resultsByPath indexes scan results by their path.
+func resultsByPath(results []scanResult) map[string]scanResult {
+	m := make(map[string]scanResult, len(results))
+	for _, r := range results {
+		m[r.Path] = r
+	}
+	return m
+}
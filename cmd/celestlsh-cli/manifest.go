@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/Magonia-Research/CelesTLSH-CLI/pkg/celestlsh"
+)
+
+// manifestMissingHash is the TLSH column of files too small or too uniform
+// to hash, as the database has it for such files.
+const manifestMissingHash = "N/A"
+
+// executeManifest hashes every file below a directory and prints it as a
+// row of the database CSV schema, so that a tool release can be
+// contributed to the dataset. Rows are in lexical order of the relative
+// paths, which become the File Name column.
+func executeManifest(ctx context.Context, config Config) (status, error) {
+	filter, err := newPathFilter(config.Include, config.Exclude)
+	if err != nil {
+		return statusOK, err
+	}
+	tree, err := listTree(config.ManifestDir, filter)
+	if err != nil {
+		return statusOK, err
+	}
+	files := make([]*treeFile, len(tree))
+	for i := range tree {
+		files[i] = &tree[i]
+	}
+
+	failed, err := hashTreeFiles(ctx, config, files, celestlsh.DigestImphash)
+	if err != nil {
+		return statusOK, err
+	}
+	for _, f := range failed {
+		fmt.Fprintf(os.Stderr, "Warning: skipping %s: %v\n", f.Name, f.Err)
+	}
+
+	today := time.Now().UTC().Format("2006-01-02")
+	w := csv.NewWriter(os.Stdout)
+	w.Write(celestlsh.Header)
+	rows, unhashed := 0, 0
+	for _, f := range files {
+		if f.SHA256 == "" {
+			continue
+		}
+		record := celestlsh.HashRecord{
+			RepoName:   config.ManifestRepo,
+			FileName:   f.Rel,
+			Version:    config.ManifestVersion,
+			TLSHHash:   f.TLSH,
+			SHA256Hash: f.SHA256,
+			Imphash:    f.Imphash,
+			DateAdded:  today,
+			Intel:      config.ManifestIntel,
+		}
+		if record.TLSHHash == "" {
+			record.TLSHHash = manifestMissingHash
+			unhashed++
+		}
+		w.Write(record.CSVRow())
+		rows++
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return statusOK, err
+	}
+
+	if !config.Quiet {
+		fmt.Fprintf(os.Stderr, "%d files written", rows)
+		if unhashed > 0 {
+			fmt.Fprintf(os.Stderr, ", %d of them too small or uniform for TLSH (%s)", unhashed, manifestMissingHash)
+		}
+		fmt.Fprintln(os.Stderr)
+	}
+	return batchStatus(0, len(failed)), nil
+}
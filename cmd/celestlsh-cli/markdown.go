@@ -0,0 +1,179 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// markdownDocument renders the results of a run as a Markdown document, for
+// pasting into tickets and wikis: a summary naming the database, a table
+// of matches, and the Intel links of the matched records as footnotes.
+// Every value from a file name or the database is escaped, so that it
+// reads as text and cannot break the table.
+type markdownDocument struct {
+	config  Config
+	checked int
+	matched int
+	rows    []string
+	errors  []string
+	intel   []string
+	notes   map[string]int
+}
+
+func newMarkdownDocument(config Config) *markdownDocument {
+	return &markdownDocument{config: config, notes: make(map[string]int)}
+}
+
+func (d *markdownDocument) add(result scanResult) {
+	if result.Error != "" {
+		d.errors = append(d.errors, fmt.Sprintf("- %s: %s", markdownEscape(displayPath(result.Path)), markdownEscape(result.Error)))
+		return
+	}
+	d.checked++
+	if result.Match == nil {
+		return
+	}
+	d.matched++
+
+	name := markdownEscape(displayPath(result.Path))
+	if len(result.Repos) > 0 {
+		// One row per repository, with the number of its matches.
+		for _, r := range result.Repos {
+			d.rows = append(d.rows, fmt.Sprintf("| %s | %s%s | %s | %d (%s) | %s | %d |", name,
+				markdownEscape(r.RepoName), d.footnote(r.Intel), markdownEscape(r.Version),
+				r.Distance, d.config.ConfidenceBands.label(r.Distance), markdownEscape(r.SHA256Hash), r.Count))
+		}
+		return
+	}
+	m := result.Match
+	d.rows = append(d.rows, fmt.Sprintf("| %s | %s%s | %s | %d (%s) | %s |", name,
+		markdownEscape(m.RepoName), d.footnote(m.Intel), markdownEscape(m.Version),
+		m.Distance, result.Confidence, markdownEscape(m.SHA256Hash)))
+}
+
+// footnote returns the reference to the footnote for an Intel link,
+// adding one if it is new, or "" if there is no link.
+func (d *markdownDocument) footnote(intel string) string {
+	intel = strings.TrimSpace(intel)
+	if intel == "" {
+		return ""
+	}
+	n, ok := d.notes[intel]
+	if !ok {
+		d.intel = append(d.intel, intel)
+		n = len(d.intel)
+		d.notes[intel] = n
+	}
+	return fmt.Sprintf("[^%d]", n)
+}
+
+func (d *markdownDocument) write(w io.Writer) error {
+	var b strings.Builder
+	title, column, noun, nouns := "scan", "File", "file", "files"
+	if d.config.Mode == "check" {
+		title, column, noun, nouns = "check", "Hash", "hash", "hashes"
+	}
+	if d.checked != 1 {
+		noun = nouns
+	}
+
+	fmt.Fprintf(&b, "# CelesTLSH %s results\n\n", title)
+	fmt.Fprintf(&b, "Checked %d %s against %s: %d matched", d.checked, noun, markdownEscape(describeDatabaseSource(d.config)), d.matched)
+	if len(d.errors) > 0 {
+		fmt.Fprintf(&b, ", %d could not be checked", len(d.errors))
+	}
+	b.WriteString(".\n\n")
+
+	if len(d.rows) == 0 {
+		b.WriteString("No matches.\n")
+	} else {
+		if d.config.GroupByRepo {
+			fmt.Fprintf(&b, "| %s | Repo | Version | Distance | SHA256 | Matches |\n", column)
+			b.WriteString("| --- | --- | --- | --: | --- | --: |\n")
+		} else {
+			fmt.Fprintf(&b, "| %s | Repo | Version | Distance | SHA256 |\n", column)
+			b.WriteString("| --- | --- | --- | --: | --- |\n")
+		}
+		for _, row := range d.rows {
+			b.WriteString(row + "\n")
+		}
+	}
+
+	if len(d.errors) > 0 {
+		b.WriteString("\n## Errors\n\n")
+		for _, e := range d.errors {
+			b.WriteString(e + "\n")
+		}
+	}
+	if len(d.intel) > 0 {
+		b.WriteString("\n")
+		for i, intel := range d.intel {
+			fmt.Fprintf(&b, "[^%d]: %s\n", i+1, markdownLink(intel))
+		}
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// describeDatabaseSource names the database results were checked against
+// and how old it is: the time the file was last written, usually by a
+// download, or the date of the embedded snapshot. A database piped to
+// stdin has no age to give.
+func describeDatabaseSource(config Config) string {
+	if name := remoteName(config); name != "" {
+		return "the database served at " + name
+	}
+	if useEmbedded(config) {
+		return "the embedded snapshot of " + embeddedSnapshotDate()
+	}
+	if config.DbPath == stdinDatabase {
+		return "the database read from stdin"
+	}
+	info, err := os.Stat(config.DbPath)
+	if err != nil {
+		return displayPath(config.DbPath)
+	}
+	return fmt.Sprintf("%s (updated %s, %s)", displayPath(config.DbPath), info.ModTime().Format(time.DateOnly), describeAge(time.Since(info.ModTime())))
+}
+
+// describeAge renders an age in whole days, for a database.
+func describeAge(age time.Duration) string {
+	switch days := int(age.Hours() / 24); {
+	case days < 1:
+		return "less than a day old"
+	case days == 1:
+		return "1 day old"
+	default:
+		return fmt.Sprintf("%d days old", days)
+	}
+}
+
+// markdownReplacer escapes the characters that mean something inside a
+// line of Markdown, including the pipe that separates table cells, and
+// turns HTML into text. Line breaks, which would end a table row, are
+// flattened.
+var markdownReplacer = strings.NewReplacer(
+	`\`, `\\`, "`", "\\`", `*`, `\*`, `_`, `\_`, `[`, `\[`, `]`, `\]`,
+	`|`, `\|`, `~`, `\~`, `&`, `&amp;`, `<`, `&lt;`, `>`, `&gt;`,
+	"\r\n", " ", "\n", " ", "\r", " ",
+)
+
+// markdownEscape returns s as literal Markdown text.
+func markdownEscape(s string) string {
+	return markdownReplacer.Replace(s)
+}
+
+// markdownLink renders an Intel value as a link if it is an http or https
+// URL, and as text otherwise.
+func markdownLink(s string) string {
+	u, err := url.Parse(s)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || strings.ContainsAny(s, " <>\t\r\n") {
+		return markdownEscape(s)
+	}
+	return "<" + s + ">"
+}
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/Magonia-Research/CelesTLSH-CLI/pkg/celestlsh"
+)
+
+// namedHash is an input of matrix, cluster or find-dupes mode together with
+// its TLSH hash, given directly or computed from the file it names. Size is
+// only set for files, and SHA256 only when that digest was requested.
+type namedHash struct {
+	Name   string `json:"name"`
+	TLSH   string `json:"tlsh"`
+	SHA256 string `json:"sha256,omitempty"`
+	Size   int64  `json:"size,omitempty"`
+}
+
+// inputFailure is an input of matrix or cluster mode that could not be
+// hashed.
+type inputFailure struct {
+	Name string
+	Err  error
+}
+
+// matrixPair is one entry of the long form of a distance matrix.
+type matrixPair struct {
+	A        string `json:"a"`
+	B        string `json:"b"`
+	Distance int    `json:"distance"`
+}
+
+func executeMatrix(ctx context.Context, config Config) (status, error) {
+	inputs, err := collectInputs(config)
+	if err != nil {
+		return statusOK, err
+	}
+
+	hashes, failed, err := hashInputs(ctx, config, inputs)
+	if err != nil {
+		return statusOK, err
+	}
+	for _, f := range failed {
+		fmt.Fprintf(os.Stderr, "Warning: skipping %s: %v\n", f.Name, f.Err)
+	}
+
+	distances, err := distanceMatrix(hashes)
+	if err != nil {
+		return statusOK, err
+	}
+
+	if config.MatrixLong {
+		return batchStatus(0, len(failed)), printMatrixLong(config, hashes, distances)
+	}
+	return batchStatus(0, len(failed)), printMatrix(config, hashes, distances)
+}
+
+// collectInputs returns the positional arguments followed by the entries
+// of --filelist, if given.
+func collectInputs(config Config) ([]string, error) {
+	inputs := append([]string(nil), config.Paths...)
+
+	if config.FileList != "" {
+		listed, err := readFileList(config.FileList, config.FileList0)
+		if err != nil {
+			return nil, err
+		}
+		inputs = append(inputs, listed...)
+	}
+
+	if len(inputs) == 0 {
+		return nil, fmt.Errorf("no inputs provided")
+	}
+
+	return inputs, nil
+}
+
+// uniquePaths returns paths without repetitions, keeping the first of
+// each, so that a path both given and listed is processed once.
+func uniquePaths(paths []string) []string {
+	seen := make(map[string]bool, len(paths))
+	unique := paths[:0]
+	for _, path := range paths {
+		if !seen[path] {
+			seen[path] = true
+			unique = append(unique, path)
+		}
+	}
+	return unique
+}
+
+// readFileList reads one input per line from path, or from stdin when path
+// is "-". Blank lines and lines starting with # are ignored. With nul,
+// inputs are separated by NUL bytes instead and taken as they are, but for
+// empty ones, which are ignored.
+func readFileList(path string, nul bool) ([]string, error) {
+	f := os.Stdin
+	if path != "-" {
+		var err error
+		f, err = os.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read file list: %w", err)
+		}
+		defer f.Close()
+	}
+
+	var inputs []string
+	sc := bufio.NewScanner(f)
+	if nul {
+		sc.Split(scanNUL)
+	}
+	for sc.Scan() {
+		if nul {
+			if sc.Text() != "" {
+				inputs = append(inputs, sc.Text())
+			}
+		} else if line := strings.TrimSpace(sc.Text()); line != "" && !strings.HasPrefix(line, "#") {
+			inputs = append(inputs, line)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read file list: %w", err)
+	}
+
+	return inputs, nil
+}
+
+// scanNUL is a bufio.SplitFunc for NUL-terminated tokens.
+func scanNUL(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	if i := bytes.IndexByte(data, 0); i >= 0 {
+		return i + 1, data[:i], nil
+	}
+	if atEOF && len(data) > 0 {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
+
+// hashInputs resolves every input to a TLSH hash, hashing files in
+// parallel. Inputs that cannot be hashed are returned separately; both
+// lists keep the order of inputs.
+func hashInputs(ctx context.Context, config Config, inputs []string) ([]namedHash, []inputFailure, error) {
+	hashes := make([]namedHash, len(inputs))
+	errs := make([]error, len(inputs))
+
+	work := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workerCount(config); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			hasher := newHasher(config)
+			for i := range work {
+				hashes[i] = namedHash{Name: inputs[i]}
+				if !config.DistanceFiles && celestlsh.ValidateHash(inputs[i]) == nil {
+					hashes[i].TLSH = inputs[i]
+					continue
+				}
+
+				digests, err := hasher.DigestFile(ctx, inputs[i], config.Digests)
+				if err != nil {
+					errs[i] = err
+					continue
+				}
+				hashes[i].TLSH, hashes[i].SHA256 = digests.TLSH, digests.SHA256
+				if info, err := os.Stat(inputs[i]); err == nil {
+					hashes[i].Size = info.Size()
+				}
+			}
+		}()
+	}
+
+	for i := range inputs {
+		if ctx.Err() != nil {
+			break
+		}
+		work <- i
+	}
+	close(work)
+	wg.Wait()
+
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	var result []namedHash
+	var failed []inputFailure
+	for i, input := range inputs {
+		if errs[i] != nil {
+			failed = append(failed, inputFailure{Name: input, Err: errs[i]})
+			continue
+		}
+		result = append(result, hashes[i])
+	}
+
+	return result, failed, nil
+}
+
+// distanceMatrix returns the symmetric matrix of distances between hashes.
+func distanceMatrix(hashes []namedHash) ([][]int, error) {
+	matrix := make([][]int, len(hashes))
+	for i := range matrix {
+		matrix[i] = make([]int, len(hashes))
+	}
+
+	for i := range hashes {
+		for j := i + 1; j < len(hashes); j++ {
+			d, err := celestlsh.Distance(hashes[i].TLSH, hashes[j].TLSH)
+			if err != nil {
+				return nil, fmt.Errorf("failed to calculate TLSH distance: %w", err)
+			}
+			matrix[i][j], matrix[j][i] = d, d
+		}
+	}
+
+	return matrix, nil
+}
+
+func printMatrix(config Config, hashes []namedHash, distances [][]int) error {
+	if config.OutputJSON {
+		names := make([]string, len(hashes))
+		for i, h := range hashes {
+			names[i] = h.Name
+		}
+		return printJSON(struct {
+			Names     []string `json:"names"`
+			Hashes    []string `json:"tlsh"`
+			Distances [][]int  `json:"distances"`
+		}{names, hashList(hashes), distances})
+	}
+
+	w := csv.NewWriter(os.Stdout)
+	header := []string{""}
+	for _, h := range hashes {
+		header = append(header, h.Name)
+	}
+	w.Write(header)
+
+	for i, h := range hashes {
+		row := []string{h.Name}
+		for _, d := range distances[i] {
+			row = append(row, strconv.Itoa(d))
+		}
+		w.Write(row)
+	}
+
+	w.Flush()
+	return w.Error()
+}
+
+// printMatrixLong prints each unordered pair of inputs once.
+func printMatrixLong(config Config, hashes []namedHash, distances [][]int) error {
+	var pairs []matrixPair
+	for i := range hashes {
+		for j := i + 1; j < len(hashes); j++ {
+			pairs = append(pairs, matrixPair{A: hashes[i].Name, B: hashes[j].Name, Distance: distances[i][j]})
+		}
+	}
+
+	if config.OutputJSON {
+		if pairs == nil {
+			pairs = []matrixPair{}
+		}
+		return printJSON(pairs)
+	}
+
+	w := csv.NewWriter(os.Stdout)
+	w.Write([]string{"a", "b", "distance"})
+	for _, p := range pairs {
+		w.Write([]string{p.A, p.B, strconv.Itoa(p.Distance)})
+	}
+
+	w.Flush()
+	return w.Error()
+}
+
+func hashList(hashes []namedHash) []string {
+	list := make([]string, len(hashes))
+	for i, h := range hashes {
+		list[i] = h.TLSH
+	}
+	return list
+}
+
+// printJSON writes v to stdout as an indented JSON document.
+func printJSON(v any) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// distanceBuckets labels match distances for the matches counter. Each
+// bucket covers distances up to and including its upper bound.
+var distanceBuckets = []struct {
+	upper int
+	label string
+}{
+	{30, "0-30"},
+	{60, "31-60"},
+	{100, "61-100"},
+}
+
+const overflowBucket = "101+"
+
+type metrics struct {
+	registry        *prometheus.Registry
+	checks          prometheus.Counter
+	matches         *prometheus.CounterVec
+	hashes          prometheus.Counter
+	requestDuration *prometheus.HistogramVec
+}
+
+func newMetrics(s *server) *metrics {
+	m := &metrics{
+		registry: prometheus.NewRegistry(),
+		checks: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "celestlsh_checks_total",
+			Help: "Number of hashes checked against the database.",
+		}),
+		matches: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "celestlsh_matches_total",
+			Help: "Number of database matches returned, by distance bucket.",
+		}, []string{"distance"}),
+		hashes: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "celestlsh_hash_requests_total",
+			Help: "Number of TLSH hashes calculated.",
+		}),
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "celestlsh_http_request_duration_seconds",
+			Help:    "Latency of HTTP requests, by handler and status code.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"handler", "code"}),
+	}
+
+	m.registry.MustRegister(
+		m.checks,
+		m.matches,
+		m.hashes,
+		m.requestDuration,
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "celestlsh_database_records",
+			Help: "Number of database records with a usable TLSH hash.",
+		}, func() float64 {
+			return float64(s.db.get().Len())
+		}),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "celestlsh_database_age_seconds",
+			Help: "Seconds since the loaded database file was last modified.",
+		}, func() float64 {
+			return time.Since(s.db.modTime()).Seconds()
+		}),
+	)
+
+	for _, b := range distanceBuckets {
+		m.matches.WithLabelValues(b.label)
+	}
+	m.matches.WithLabelValues(overflowBucket)
+
+	return m
+}
+
+func (m *metrics) observeMatch(distance int) {
+	for _, b := range distanceBuckets {
+		if distance <= b.upper {
+			m.matches.WithLabelValues(b.label).Inc()
+			return
+		}
+	}
+	m.matches.WithLabelValues(overflowBucket).Inc()
+}
+
+func (m *metrics) instrument(name string, h http.HandlerFunc) http.Handler {
+	return promhttp.InstrumentHandlerDuration(
+		m.requestDuration.MustCurryWith(prometheus.Labels{"handler": name}), h)
+}
+
+func (m *metrics) handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestMetricsScrape(t *testing.T) {
+	_, ts := newTestServer(t)
+	records := testRecords(t)
+
+	// From known.exe, the variant is at 48 and the others over 100; the
+	// second check finds other.exe itself.
+	for _, body := range []string{
+		fmt.Sprintf(`{"tlsh":%q,"top":10}`, records[0].TLSHHash),
+		fmt.Sprintf(`{"tlsh":%q}`, records[2].TLSHHash),
+		`{"tlsh":"invalid"}`,
+	} {
+		resp, err := http.Post(ts.URL+"/check", "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+	resp, err := http.Post(ts.URL+"/hash", "application/octet-stream", bytes.NewReader(testSample))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	resp, err = http.Get(ts.URL + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	metrics := string(data)
+
+	for _, want := range []string{
+		"celestlsh_checks_total 2\n",
+		"celestlsh_hash_requests_total 1\n",
+		`celestlsh_matches_total{distance="0-30"} 2` + "\n",
+		`celestlsh_matches_total{distance="31-60"} 1` + "\n",
+		`celestlsh_matches_total{distance="61-100"} 0` + "\n",
+		`celestlsh_matches_total{distance="101+"} 2` + "\n",
+		"celestlsh_database_records 4\n",
+		`celestlsh_http_request_duration_seconds_count{code="200",handler="check"} 2` + "\n",
+		`celestlsh_http_request_duration_seconds_count{code="400",handler="check"} 1` + "\n",
+		`celestlsh_http_request_duration_seconds_count{code="200",handler="hash"} 1` + "\n",
+	} {
+		if !strings.Contains(metrics, want) {
+			t.Errorf("metrics lack %q", want)
+		}
+	}
+	if !strings.Contains(metrics, "celestlsh_database_age_seconds ") {
+		t.Errorf("metrics lack celestlsh_database_age_seconds")
+	}
+}
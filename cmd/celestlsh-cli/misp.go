@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+// defaultMISPEventInfo is the event title used without --misp-event-info.
+const defaultMISPEventInfo = "CelesTLSH scan results"
+
+type mispEvent struct {
+	Event mispEventBody `json:"Event"`
+}
+
+type mispEventBody struct {
+	Info          string          `json:"info"`
+	Date          string          `json:"date"`
+	ThreatLevelID string          `json:"threat_level_id"`
+	Analysis      string          `json:"analysis"`
+	Distribution  string          `json:"distribution"`
+	Published     bool            `json:"published"`
+	Attribute     []mispAttribute `json:"Attribute"`
+}
+
+type mispAttribute struct {
+	Type     string    `json:"type"`
+	Category string    `json:"category"`
+	Value    string    `json:"value"`
+	ToIDS    bool      `json:"to_ids"`
+	Comment  string    `json:"comment"`
+	Tag      []mispTag `json:"Tag,omitempty"`
+}
+
+type mispTag struct {
+	Name string `json:"name"`
+}
+
+// mispDocument builds a MISP event with a tlsh and a sha256 attribute for
+// each matched file, tagged with the matched repository. The event is left
+// unpublished, with distribution limited to the organisation, for an
+// analyst to review after import.
+type mispDocument struct {
+	info       string
+	attributes []mispAttribute
+	seen       map[string]bool
+}
+
+func newMISPDocument(info string) *mispDocument {
+	if info == "" {
+		info = defaultMISPEventInfo
+	}
+	return &mispDocument{info: info, seen: make(map[string]bool)}
+}
+
+func (d *mispDocument) add(result scanResult) {
+	if result.Error != "" || result.Match == nil {
+		return
+	}
+
+	m := result.Match
+	comment := fmt.Sprintf("Similar to %s %s version %s at TLSH distance %d", m.RepoName, m.FileName, m.Version, m.Distance)
+	if result.MatchType == matchExact {
+		comment = fmt.Sprintf("Identical to %s %s version %s, by SHA256", m.RepoName, m.FileName, m.Version)
+	}
+	if result.Path != "" {
+		comment = result.Path + ": " + comment
+	}
+	tags := []mispTag{{Name: m.RepoName}}
+
+	// IDS rules match exact values, which suits the SHA256 but not a
+	// similarity hash, so only the former is flagged for export.
+	d.attribute(mispAttribute{Type: "tlsh", Value: result.TLSH, Comment: comment, Tag: tags})
+	if result.SHA256 != "" {
+		d.attribute(mispAttribute{Type: "sha256", Value: result.SHA256, ToIDS: true, Comment: comment, Tag: tags})
+	}
+}
+
+// attribute adds a, unless the event already has its type and value.
+func (d *mispDocument) attribute(a mispAttribute) {
+	key := a.Type + "|" + a.Value
+	if d.seen[key] {
+		return
+	}
+	d.seen[key] = true
+
+	a.Category = "Payload delivery"
+	d.attributes = append(d.attributes, a)
+}
+
+func (d *mispDocument) write(w io.Writer) error {
+	attributes := d.attributes
+	if attributes == nil {
+		attributes = []mispAttribute{}
+	}
+
+	event := mispEvent{Event: mispEventBody{
+		Info: d.info,
+		Date: time.Now().Format("2006-01-02"),
+		// Medium threat, initial analysis, this organisation only.
+		ThreatLevelID: "2",
+		Analysis:      "0",
+		Distribution:  "0",
+		Attribute:     attributes,
+	}}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(event)
+}
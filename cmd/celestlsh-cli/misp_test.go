@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// mispAttributeCategories are the categories MISP accepts for the tlsh and
+// sha256 attribute types.
+var mispAttributeCategories = map[string][]string{
+	"tlsh":   {"Payload delivery", "Payload installation", "Artifacts dropped", "External analysis"},
+	"sha256": {"Payload delivery", "Payload installation", "Artifacts dropped", "External analysis", "Network activity", "Antivirus detection"},
+}
+
+// parseMISP checks that out is a MISP event that /events/add accepts as it
+// is and returns its title and attributes.
+func parseMISP(t *testing.T, out string) (info string, attributes []map[string]any) {
+	t.Helper()
+	var doc struct {
+		Event map[string]any
+	}
+	if err := json.Unmarshal([]byte(out), &doc); err != nil {
+		t.Fatalf("parsing event %s: %v", out, err)
+	}
+	event := doc.Event
+	if event == nil {
+		t.Fatalf("%s has no Event", out)
+	}
+
+	for name, values := range map[string][]string{
+		"threat_level_id": {"1", "2", "3", "4"},
+		"analysis":        {"0", "1", "2"},
+		"distribution":    {"0", "1", "2", "3", "4", "5"},
+	} {
+		if v, _ := event[name].(string); !slices.Contains(values, v) {
+			t.Errorf("%s %v, want one of %q", name, event[name], values)
+		}
+	}
+	if date, _ := event["date"].(string); date == "" {
+		t.Error("event has no date")
+	} else if _, err := time.Parse("2006-01-02", date); err != nil {
+		t.Errorf("date %q: %v", date, err)
+	}
+	info, _ = event["info"].(string)
+	if info == "" {
+		t.Error("event has no info")
+	}
+
+	list, ok := event["Attribute"].([]any)
+	if !ok {
+		t.Fatalf("Attribute = %v, want an array", event["Attribute"])
+	}
+	for _, item := range list {
+		a := item.(map[string]any)
+		typ, _ := a["type"].(string)
+		category, _ := a["category"].(string)
+		if !slices.Contains(mispAttributeCategories[typ], category) {
+			t.Errorf("attribute %v: category not valid for type %q", a, typ)
+		}
+		if v, _ := a["value"].(string); v == "" {
+			t.Errorf("attribute %v has no value", a)
+		}
+		if _, ok := a["to_ids"].(bool); !ok {
+			t.Errorf("attribute %v: to_ids is not a boolean", a)
+		}
+		attributes = append(attributes, a)
+	}
+	return info, attributes
+}
+
+func TestScanMISP(t *testing.T) {
+	dir := t.TempDir()
+	known := writeFile(t, dir, "a/known.exe", testSample)
+	writeFile(t, dir, "b/known.exe", testSample)
+	writeFile(t, dir, "c/other.bin", sampleData(5, 8192))
+
+	out, _, _, err := runCLI(t, "-s", "--sha256", "--format", "misp", "--misp-event-info", "IR-42 triage", "--max-distance", "30", "--db", writeTestDatabase(t), dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, attributes := parseMISP(t, out)
+	if info != "IR-42 triage" {
+		t.Errorf("info %q, want the --misp-event-info", info)
+	}
+	// Both copies have the same hashes, which the event holds once.
+	if len(attributes) != 2 {
+		t.Fatalf("attributes = %v, want a tlsh and a sha256", attributes)
+	}
+	for _, a := range attributes {
+		tags, _ := a["Tag"].([]any)
+		if len(tags) != 1 || tags[0].(map[string]any)["name"] != "KnownTool" {
+			t.Errorf("attribute %v not tagged with the repository", a)
+		}
+		comment, _ := a["comment"].(string)
+		if !strings.HasPrefix(comment, known+": ") || !strings.Contains(comment, "KnownTool known.exe version v1.0") {
+			t.Errorf("comment %q, want the path, repository, file and version", comment)
+		}
+		if a["to_ids"] != (a["type"] == "sha256") {
+			t.Errorf("attribute %v: only the sha256 should be flagged for IDS", a)
+		}
+	}
+	if attributes[0]["value"] != testHash(t, testSample) {
+		t.Errorf("tlsh value %v, want that of the sample", attributes[0]["value"])
+	}
+}
+
+func TestMISPWithoutMatches(t *testing.T) {
+	out, _, _, err := runCLI(t, "-c", "--format", "misp", "--max-distance", "30", "--db", writeTestDatabase(t), testHash(t, sampleData(5, 8192)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, attributes := parseMISP(t, out)
+	if info != defaultMISPEventInfo || len(attributes) != 0 {
+		t.Errorf("info %q, attributes %v; want the default title and no attributes", info, attributes)
+	}
+}
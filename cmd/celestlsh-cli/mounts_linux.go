@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// mountInfoPath lists the mounts visible to this process.
+const mountInfoPath = "/proc/self/mountinfo"
+
+// virtualMounts returns the mount points of pseudo and memory-backed
+// filesystems, mapped to their filesystem type.
+func virtualMounts() (map[string]string, error) {
+	f, err := os.Open(mountInfoPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	mounts := make(map[string]string)
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		// 36 35 98:0 /mnt1 /mnt2 rw,noatime master:1 - ext3 /dev/root rw
+		fields := strings.Fields(sc.Text())
+		sep := -1
+		for i, field := range fields {
+			if field == "-" {
+				sep = i
+				break
+			}
+		}
+		if sep < 5 || sep+1 >= len(fields) {
+			continue
+		}
+		if fsType := fields[sep+1]; virtualFilesystems[fsType] {
+			mounts[unescapeMountPath(fields[4])] = fsType
+		}
+	}
+	return mounts, sc.Err()
+}
+
+// unescapeMountPath decodes the octal escapes, such as \040 for a space,
+// used in mount table paths.
+func unescapeMountPath(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+4 <= len(s) {
+			if n, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(n))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+
+package main
+
+// virtualMounts returns no mounts on platforms without a Linux mount table;
+// only non-regular files are skipped there.
+func virtualMounts() (map[string]string, error) {
+	return nil, nil
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// defaultOrderLimit is the --order-limit of scan mode. Each file held
+// costs its path and about 50 bytes more, so a million files take in the
+// order of 150 MB.
+const defaultOrderLimit = 1000000
+
+// scanOrders are the orders --order accepts, in the order they are listed
+// in errors.
+var scanOrders = []string{"mtime-desc", "mtime-asc", "size-asc", "size-desc", "name"}
+
+// parseOrder checks --order, returning "" for walk, the default.
+func parseOrder(s string) (string, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if s == "" || s == "walk" {
+		return "", nil
+	}
+	if !slices.Contains(scanOrders, s) {
+		return "", fmt.Errorf("unknown order %q; use %s or walk", s, strings.Join(scanOrders, ", "))
+	}
+	return s, nil
+}
+
+// orderedFile is a file found by a scan with --order, held until every
+// root has been walked.
+type orderedFile struct {
+	path  string
+	size  int64
+	mtime time.Time
+}
+
+// scanQueue holds the files found by a scan with --order, so that they can
+// be scanned in that order rather than as the walks find them. Once it
+// holds --order-limit files, those are scanned in order and the rest of
+// the scan streams in walk order, to bound the memory used on huge trees.
+type scanQueue struct {
+	order     string
+	limit     int
+	files     []orderedFile
+	streaming bool
+}
+
+// newScanQueue returns the queue for --order, or nil to scan files as they
+// are found.
+func newScanQueue(config Config) *scanQueue {
+	if config.Order == "" {
+		return nil
+	}
+	return &scanQueue{order: config.Order, limit: config.OrderLimit}
+}
+
+// needsInfo reports whether queued files need their stat information.
+func (q *scanQueue) needsInfo() bool {
+	return q != nil && !q.streaming && q.order != "name"
+}
+
+// enqueue scans the file at path, described by info if needsInfo, now or,
+// with --order, once every root has been walked.
+func (s *scanner) enqueue(ctx, work context.Context, path string, info fs.FileInfo) {
+	q := s.queue
+	if q == nil || q.streaming {
+		s.dispatch(ctx, work, path)
+		return
+	}
+
+	f := orderedFile{path: path}
+	if info != nil {
+		f.size, f.mtime = info.Size(), info.ModTime()
+	}
+	q.files = append(q.files, f)
+	if len(q.files) < q.limit {
+		return
+	}
+
+	clearProgress()
+	fmt.Fprintf(os.Stderr, "Warning: %d files found, the --order-limit; scanning those by --order %s, then the rest as they are found\n", q.limit, q.order)
+	s.flushQueue(ctx, work)
+	q.streaming = true
+}
+
+// flushQueue scans the files held by the queue, sorted by --order. Files
+// equal in the order keep the order they were found in.
+func (s *scanner) flushQueue(ctx, work context.Context) {
+	q := s.queue
+	if q == nil {
+		return
+	}
+	slices.SortStableFunc(q.files, func(a, b orderedFile) int {
+		switch q.order {
+		case "mtime-desc":
+			return b.mtime.Compare(a.mtime)
+		case "mtime-asc":
+			return a.mtime.Compare(b.mtime)
+		case "size-asc":
+			return cmp.Compare(a.size, b.size)
+		case "size-desc":
+			return cmp.Compare(b.size, a.size)
+		default:
+			return strings.Compare(a.path, b.path)
+		}
+	})
+
+	files := q.files
+	q.files = nil
+	for _, f := range files {
+		if ctx.Err() != nil {
+			return
+		}
+		s.dispatch(ctx, work, f.path)
+	}
+}
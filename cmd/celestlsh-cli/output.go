@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// resultOutput is the file given with -o/--output, which replaces stdout for
+// the results of the run. Warnings, errors and progress stay on stderr.
+//
+// Output that is written all at once is built in a temporary file and renamed
+// into place when the run finishes, so the file is never seen half-written.
+// Streamed output (JSON Lines, watch mode and --append) goes straight to the
+// file so that it can be followed while the run continues.
+type resultOutput struct {
+	file *os.File
+	path string
+	temp bool
+}
+
+// openOutput opens the configured output file and redirects stdout to it.
+// It returns nil when results go to stdout.
+func openOutput(config Config) (*resultOutput, error) {
+	if config.Output == "" {
+		return nil, nil
+	}
+
+	dir := filepath.Dir(config.Output)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create output directory: %w", err)
+	}
+
+	out := &resultOutput{path: config.Output}
+	var err error
+	switch {
+	case config.Append:
+		out.file, err = os.OpenFile(config.Output, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	case config.OutputJSONL || config.Mode == "watch":
+		out.file, err = os.Create(config.Output)
+	default:
+		out.temp = true
+		out.file, err = os.CreateTemp(dir, "."+filepath.Base(config.Output)+".*.tmp")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open output file: %w", err)
+	}
+
+	os.Stdout = out.file
+	return out, nil
+}
+
+// close finishes the output file. A temporary file is moved into place if
+// keep is set and discarded otherwise, leaving any previous output intact.
+// It is safe on a nil output.
+func (o *resultOutput) close(keep bool) error {
+	if o == nil {
+		return nil
+	}
+
+	err := o.file.Close()
+	if !o.temp {
+		if err != nil {
+			return fmt.Errorf("failed to write output file: %w", err)
+		}
+		return nil
+	}
+
+	if err == nil && keep {
+		// CreateTemp makes the file private; give it the permissions
+		// os.Create would.
+		err = os.Chmod(o.file.Name(), 0644)
+		if err == nil {
+			err = os.Rename(o.file.Name(), o.path)
+		}
+	} else if err == nil {
+		err = os.Remove(o.file.Name())
+	}
+	if err != nil {
+		os.Remove(o.file.Name())
+		return fmt.Errorf("failed to write output file: %w", err)
+	}
+	return nil
+}
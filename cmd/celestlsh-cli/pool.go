@@ -0,0 +1,107 @@
+package main
+
+import (
+	"runtime"
+	"sync"
+)
+
+// resultPool runs tasks on a bounded number of goroutines and prints the
+// results of each task together, in the order the tasks were submitted or,
+// when unordered, as soon as each task finishes.
+type resultPool struct {
+	unordered bool
+	print     func(scanResult)
+
+	work    chan *poolTask
+	queue   chan *poolTask
+	workers sync.WaitGroup
+	printer sync.WaitGroup
+	mu      sync.Mutex
+}
+
+type poolTask struct {
+	run     func() []scanResult
+	then    func()
+	results []scanResult
+	done    chan struct{}
+}
+
+// workerCount returns the --workers setting, defaulting to one per CPU.
+func workerCount(config Config) int {
+	if config.Workers > 0 {
+		return config.Workers
+	}
+	return runtime.NumCPU()
+}
+
+func newResultPool(workers int, unordered bool, print func(scanResult)) *resultPool {
+	p := &resultPool{
+		unordered: unordered,
+		print:     print,
+		work:      make(chan *poolTask),
+		// Bounds how far finished results can run ahead of a slow task
+		// that must be printed first.
+		queue: make(chan *poolTask, workers*4),
+	}
+
+	for i := 0; i < workers; i++ {
+		p.workers.Add(1)
+		go func() {
+			defer p.workers.Done()
+			for t := range p.work {
+				t.results = t.run()
+				if p.unordered {
+					p.flush(t)
+				}
+				close(t.done)
+			}
+		}()
+	}
+
+	if !unordered {
+		p.printer.Add(1)
+		go func() {
+			defer p.printer.Done()
+			for t := range p.queue {
+				<-t.done
+				p.flush(t)
+			}
+		}()
+	}
+
+	return p
+}
+
+// submit queues run, blocking while all workers are busy.
+func (p *resultPool) submit(run func() []scanResult) {
+	p.submitThen(run, nil)
+}
+
+// submitThen queues run like submit, calling then, if set, once its results
+// have been printed.
+func (p *resultPool) submitThen(run func() []scanResult, then func()) {
+	t := &poolTask{run: run, then: then, done: make(chan struct{})}
+	if !p.unordered {
+		p.queue <- t
+	}
+	p.work <- t
+}
+
+// wait finishes every submitted task and prints the remaining results.
+func (p *resultPool) wait() {
+	close(p.work)
+	p.workers.Wait()
+	close(p.queue)
+	p.printer.Wait()
+}
+
+func (p *resultPool) flush(t *poolTask) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, r := range t.results {
+		p.print(r)
+	}
+	if t.then != nil {
+		t.then()
+	}
+}
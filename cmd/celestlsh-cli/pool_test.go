@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestResultPoolOrder(t *testing.T) {
+	const tasks = 50
+	for _, unordered := range []bool{false, true} {
+		t.Run(fmt.Sprintf("unordered %v", unordered), func(t *testing.T) {
+			var printed []string
+			var running, peak atomic.Int32
+			p := newResultPool(4, unordered, func(r scanResult) { printed = append(printed, r.Path) })
+			for i := range tasks {
+				p.submit(func() []scanResult {
+					n := running.Add(1)
+					for {
+						old := peak.Load()
+						if n <= old || peak.CompareAndSwap(old, n) {
+							break
+						}
+					}
+					// Early tasks finish last.
+					time.Sleep(time.Duration(tasks-i) * 100 * time.Microsecond)
+					running.Add(-1)
+					return []scanResult{{Path: fmt.Sprintf("%02d a", i)}, {Path: fmt.Sprintf("%02d b", i)}}
+				})
+			}
+			p.wait()
+
+			if len(printed) != 2*tasks {
+				t.Fatalf("printed %d results, want %d", len(printed), 2*tasks)
+			}
+			if peak.Load() > 4 {
+				t.Errorf("%d tasks ran at once, want at most 4", peak.Load())
+			}
+			// The results of a task stay together either way.
+			for i := 0; i < len(printed); i += 2 {
+				a, b := printed[i], printed[i+1]
+				if !strings.HasSuffix(a, " a") || b != strings.TrimSuffix(a, " a")+" b" {
+					t.Fatalf("results %q and %q of one task were split", a, b)
+				}
+			}
+			if sorted := slices.IsSorted(printed); sorted == unordered {
+				t.Errorf("printed in order %v, want %v: %q", sorted, !unordered, printed)
+			}
+		})
+	}
+}
+
+func TestHashManyFiles(t *testing.T) {
+	dir := t.TempDir()
+	var paths []string
+	hashes := make(map[string]string)
+	for i := range 40 {
+		data := sampleData(uint64(i+10), 1024+i*97)
+		path := writeFile(t, dir, fmt.Sprintf("f%02d.bin", i), data)
+		paths = append(paths, path)
+		hashes[path] = testHash(t, data)
+	}
+
+	for _, args := range [][]string{
+		{"--workers", "1"},
+		{"--workers", "8"},
+		{"--workers", "8", "--unordered"},
+	} {
+		t.Run(strings.Join(args, " "), func(t *testing.T) {
+			out, _, _, err := runCLI(t, append(append([]string{"-h", "--csv"}, args...), paths...)...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rows := readCSV(t, out)
+			var got []string
+			for _, row := range rows {
+				if row[1] != hashes[row[0]] {
+					t.Errorf("%s hashed as %s", row[0], row[1])
+				}
+				got = append(got, row[0])
+			}
+			if args[len(args)-1] == "--unordered" {
+				slices.Sort(got)
+			}
+			if !slices.Equal(got, paths) {
+				t.Errorf("hashed %q, want each of %d files once, in order", got, len(paths))
+			}
+		})
+	}
+}
+
+func TestScanWorkersEachFileOnce(t *testing.T) {
+	dir := t.TempDir()
+	for i := range 60 {
+		writeFile(t, dir, filepath.Join(fmt.Sprintf("d%d", i%5), fmt.Sprintf("f%02d.bin", i)), sampleData(uint64(i+10), 2048))
+	}
+
+	out, _, _, err := runCLI(t, "-s", "--jsonl", "--workers", "8", "--unordered", "--db", writeTestDatabase(t), dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	results, summary := parseJSONL(t, out)
+	seen := make(map[string]int)
+	for _, r := range results {
+		seen[r.Path]++
+	}
+	if len(results) != 60 || len(seen) != 60 || summary.Files != 60 {
+		t.Errorf("%d results for %d files, summary of %d files; want each of 60 once", len(results), len(seen), summary.Files)
+	}
+}
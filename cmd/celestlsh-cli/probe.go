@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Magonia-Research/CelesTLSH-CLI/pkg/celestlsh"
+)
+
+// downloadProbe is what download mode's --dry-run reports: the database
+// the server would send, and whether it appears newer than the one at --db
+// from the metadata saved by the download of it.
+type downloadProbe struct {
+	URL          string `json:"url"`
+	FinalURL     string `json:"final_url"`
+	Size         int64  `json:"size"`
+	LastModified string `json:"last_modified,omitempty"`
+	ETag         string `json:"etag,omitempty"`
+	Ranges       bool   `json:"ranges"`
+
+	Local *dbMeta `json:"local,omitempty"`
+
+	// RemoteNewer is null when it cannot be told, and Reason says why.
+	RemoteNewer *bool  `json:"remote_newer"`
+	Reason      string `json:"reason,omitempty"`
+}
+
+// probeDownload prints what downloading from d would fetch, without
+// writing anything.
+func probeDownload(ctx context.Context, config Config, d *celestlsh.Downloader) error {
+	info, err := d.Probe(ctx)
+	if errors.Is(err, celestlsh.ErrAuthenticationFailed) {
+		return fmt.Errorf("failed to query %s: %w; check --bearer-token, $CELESTLSH_TOKEN or --header", d.URL, err)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to query %s: %w", d.URL, err)
+	}
+
+	probe := downloadProbe{
+		URL:          d.URL,
+		FinalURL:     info.URL,
+		Size:         info.Size,
+		LastModified: info.LastModified,
+		ETag:         info.ETag,
+		Ranges:       info.Ranges,
+	}
+	probe.compare(config.DbPath)
+
+	if config.OutputJSON {
+		return printJSON(probe)
+	}
+	printDownloadProbe(config, probe)
+	return nil
+}
+
+// compare sets how the remote database compares with the one at dbPath.
+// A remote modified after the local one was downloaded appears newer; the
+// server's Last-Modified is all there is to go on, as the local metadata
+// holds no ETag.
+func (p *downloadProbe) compare(dbPath string) {
+	data, err := os.ReadFile(dbMetaPath(dbPath))
+	if err != nil {
+		p.Reason = "no metadata from an earlier download in " + dbMetaPath(dbPath)
+		return
+	}
+	var meta dbMeta
+	if err := json.Unmarshal(data, &meta); err != nil {
+		p.Reason = fmt.Sprintf("%s is unreadable: %v", dbMetaPath(dbPath), err)
+		return
+	}
+	p.Local = &meta
+	if meta.URL != p.URL {
+		p.Reason = "the local database was downloaded from " + meta.URL
+		return
+	}
+	downloaded, err := time.Parse(time.RFC3339, meta.Downloaded)
+	if err != nil {
+		p.Reason = fmt.Sprintf("%s has no valid download time", dbMetaPath(dbPath))
+		return
+	}
+	if p.LastModified == "" {
+		p.Reason = "the server does not report when the database was last modified"
+		return
+	}
+	modified, err := http.ParseTime(p.LastModified)
+	if err != nil {
+		p.Reason = fmt.Sprintf("the server's Last-Modified is invalid: %q", p.LastModified)
+		return
+	}
+	newer := modified.After(downloaded)
+	p.RemoteNewer = &newer
+}
+
+func printDownloadProbe(config Config, p downloadProbe) {
+	size := "unknown"
+	if p.Size >= 0 {
+		size = fmt.Sprintf("%s (%d bytes)", formatSize(p.Size), p.Size)
+	}
+	ranges := "not supported"
+	if p.Ranges {
+		ranges = "supported"
+	}
+	orNone := func(s string) string {
+		if s == "" {
+			return "not reported"
+		}
+		return s
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "URL:\t%s\n", p.FinalURL)
+	if p.FinalURL != p.URL {
+		fmt.Fprintf(tw, "Redirected from:\t%s\n", p.URL)
+	}
+	fmt.Fprintf(tw, "Size:\t%s\n", size)
+	fmt.Fprintf(tw, "Last-Modified:\t%s\n", orNone(p.LastModified))
+	fmt.Fprintf(tw, "ETag:\t%s\n", orNone(p.ETag))
+	fmt.Fprintf(tw, "Range requests:\t%s\n", ranges)
+	switch {
+	case p.RemoteNewer == nil:
+		fmt.Fprintf(tw, "Local database:\tcannot tell whether the remote is newer: %s\n", p.Reason)
+	case *p.RemoteNewer:
+		fmt.Fprintf(tw, "Local database:\t%s, downloaded %s; the remote appears newer\n", config.DbPath, p.Local.Downloaded)
+	default:
+		fmt.Fprintf(tw, "Local database:\t%s, downloaded %s; the remote does not appear newer\n", config.DbPath, p.Local.Downloaded)
+	}
+	tw.Flush()
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/Magonia-Research/CelesTLSH-CLI/pkg/celestlsh"
+)
+
+// procRoot is where the proc filesystem is mounted.
+const procRoot = "/proc"
+
+// deletedSuffix is appended by the kernel to the exe link of a process whose
+// executable has been unlinked since it was started.
+const deletedSuffix = " (deleted)"
+
+// procResult is the outcome of checking one process's executable.
+type procResult struct {
+	PID     int    `json:"pid"`
+	Name    string `json:"name"`
+	Exe     string `json:"exe"`
+	Deleted bool   `json:"deleted,omitempty"`
+	celestlsh.Digests
+	Match *celestlsh.HashRecord `json:"match,omitempty"`
+	Error string                `json:"error,omitempty"`
+
+	ErrorCode string `json:"error_code,omitempty"`
+}
+
+func executeProcScan(ctx context.Context, config Config) (status, error) {
+	db, err := loadDatabase(ctx, config)
+	if err != nil {
+		return statusOK, err
+	}
+
+	pids, err := listPIDs()
+	if err != nil {
+		return statusOK, err
+	}
+
+	// Processes are inspected first, so that each distinct executable can
+	// be hashed once, --workers at a time, before they are printed in
+	// order.
+	type process struct {
+		result procResult
+		id     fileID
+	}
+	var processes []process
+	var exes []fileID
+	links := make(map[fileID]string)
+	denied := 0
+	for _, pid := range pids {
+		if ctx.Err() != nil {
+			break
+		}
+
+		result, id, err := inspectProcess(pid)
+		if err != nil {
+			switch {
+			case errors.Is(err, fs.ErrPermission):
+				denied++
+			case errors.Is(err, fs.ErrNotExist):
+				// Exited since it was listed, or a kernel thread
+				// without an executable.
+			default:
+				fmt.Fprintf(os.Stderr, "Warning: pid %d: %v\n", pid, err)
+			}
+			continue
+		}
+		processes = append(processes, process{result, id})
+
+		// Hash through the proc link rather than the path, so binaries
+		// that were deleted or replaced after starting are still read.
+		if _, ok := links[id]; !ok {
+			links[id] = filepath.Join(procRoot, strconv.Itoa(pid), "exe")
+			exes = append(exes, id)
+		}
+	}
+
+	s := &scanner{config: config, db: db, hasher: newHasher(config)}
+	seen := hashExecutables(ctx, s, exes, links)
+
+	var printed, matched, failed int
+	for _, p := range processes {
+		checked, ok := seen[p.id]
+		if !ok {
+			// Interrupted before it was hashed.
+			break
+		}
+		result := p.result
+		result.Digests, result.Match, result.Error, result.ErrorCode = checked.Digests, checked.Match, checked.Error, checked.ErrorCode
+		switch {
+		case result.Error != "":
+			failed++
+		case result.Match != nil:
+			matched++
+		}
+		printProcResult(config, result)
+		printed++
+	}
+
+	if config.OutputJSONL {
+		printJSONLSummary(jsonlSummary{
+			Files:       int64(len(seen)),
+			Results:     int64(printed),
+			Matched:     int64(matched),
+			Failed:      int64(failed),
+			Interrupted: ctx.Err() != nil,
+		})
+	}
+
+	if !config.Quiet && !config.OutputJSONL {
+		fmt.Fprintf(os.Stderr, "Scanned %d processes (%d distinct executables)", printed, len(seen))
+		if denied > 0 {
+			fmt.Fprintf(os.Stderr, "; %d could not be inspected (permission denied)", denied)
+		}
+		fmt.Fprintln(os.Stderr)
+	}
+
+	return batchStatus(matched, failed), ctx.Err()
+}
+
+// hashExecutables checks each executable in exes through its proc link
+// in links, with a pool of --workers workers, and returns the results by
+// executable. Executables not reached before ctx is done are left out.
+func hashExecutables(ctx context.Context, s *scanner, exes []fileID, links map[fileID]string) map[fileID]scanResult {
+	seen := make(map[fileID]scanResult, len(exes))
+	workers := workerCount(s.config)
+	if workers <= 1 {
+		for _, id := range exes {
+			if ctx.Err() != nil {
+				break
+			}
+			seen[id] = s.checkFile(ctx, links[id])
+		}
+		return seen
+	}
+
+	// The pool prints one result at a time, so seen needs no lock.
+	byLink := make(map[string]fileID, len(exes))
+	for _, id := range exes {
+		byLink[links[id]] = id
+	}
+	pool := newResultPool(workers, true, func(result scanResult) {
+		seen[byLink[result.Path]] = result
+	})
+	for _, id := range exes {
+		if ctx.Err() != nil {
+			break
+		}
+		link := links[id]
+		pool.submit(func() []scanResult {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return []scanResult{s.checkFile(ctx, link)}
+		})
+	}
+	pool.wait()
+	return seen
+}
+
+// listPIDs returns the ids of all processes visible in /proc, in order.
+func listPIDs() ([]int, error) {
+	entries, err := os.ReadDir(procRoot)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list processes: %w", err)
+	}
+
+	var pids []int
+	for _, entry := range entries {
+		if pid, err := strconv.Atoi(entry.Name()); err == nil && entry.IsDir() {
+			pids = append(pids, pid)
+		}
+	}
+	sort.Ints(pids)
+
+	return pids, nil
+}
+
+// inspectProcess reads the name and executable of pid, returning the
+// identity of the executable for deduplication.
+func inspectProcess(pid int) (procResult, fileID, error) {
+	dir := filepath.Join(procRoot, strconv.Itoa(pid))
+	result := procResult{PID: pid}
+
+	exe, err := os.Readlink(filepath.Join(dir, "exe"))
+	if err != nil {
+		return result, fileID{}, err
+	}
+	result.Exe = exe
+	if strings.HasSuffix(exe, deletedSuffix) {
+		result.Exe = strings.TrimSuffix(exe, deletedSuffix)
+		result.Deleted = true
+	}
+
+	info, err := os.Stat(filepath.Join(dir, "exe"))
+	if err != nil {
+		return result, fileID{}, err
+	}
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return result, fileID{}, fmt.Errorf("unexpected file information for %s", exe)
+	}
+
+	if comm, err := os.ReadFile(filepath.Join(dir, "comm")); err == nil {
+		result.Name = strings.TrimSpace(string(comm))
+	}
+
+	return result, fileID{dev: uint64(st.Dev), ino: st.Ino}, nil
+}
+
+func printProcResult(config Config, result procResult) {
+	exe := result.Exe
+	if result.Deleted {
+		exe += deletedSuffix
+	}
+
+	switch {
+	case config.OutputJSONL:
+		line, err := json.Marshal(result)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: pid %d: %v\n", result.PID, err)
+			return
+		}
+		fmt.Println(string(line))
+
+	case result.Error != "":
+		fmt.Fprintf(os.Stderr, "Error: pid %d (%s) %s: %s\n", result.PID, result.Name, exe, result.Error)
+
+	case config.OutputCSV:
+		if result.Match == nil {
+			fmt.Printf("%d,%s,%s,%s,,,,,\n", result.PID, result.Name, exe, strings.Join(digestFields(config.Digests, result.Digests), ","))
+			return
+		}
+		m := result.Match
+		fmt.Printf("%d,%s,%s,%s,%s,%s,%s,%s,%d\n", result.PID, result.Name, exe, strings.Join(digestFields(config.Digests, result.Digests), ","), m.RepoName, m.FileName, m.Version, m.SHA256Hash, m.Distance)
+
+	case config.Quiet:
+		if result.Match != nil {
+			fmt.Printf("%d %s\n", result.PID, result.Match.SHA256Hash)
+		}
+
+	default:
+		if result.Match == nil {
+			fmt.Println(paint(styleClean, fmt.Sprintf("%d %s %s: no match%s", result.PID, result.Name, exe, digestSuffix(result.Digests))))
+			return
+		}
+		m := result.Match
+		line := fmt.Sprintf("%d %s %s: %s %s (distance %d)%s", result.PID, result.Name, exe, m.RepoName, m.FileName, m.Distance, digestSuffix(result.Digests))
+		fmt.Println(paint(resultStyle(config, scanResult{Match: m}), line))
+	}
+}
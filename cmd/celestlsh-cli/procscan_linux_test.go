@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"testing"
+)
+
+func TestHashExecutables(t *testing.T) {
+	dir := t.TempDir()
+	var exes []fileID
+	links := make(map[fileID]string)
+	for i := range 12 {
+		id := fileID{dev: 1, ino: uint64(i)}
+		exes = append(exes, id)
+		links[id] = writeFile(t, dir, fmt.Sprintf("exe%d", i), sampleData(uint64(i), 8192))
+	}
+	links[exes[0]] = writeFile(t, dir, "known.exe", testSample)
+	db := writeTestDatabase(t)
+
+	for _, workers := range []int{1, 4} {
+		t.Run(strconv.Itoa(workers), func(t *testing.T) {
+			config := parseTestFlags(t, "procscan", "--workers", strconv.Itoa(workers), "--max-distance", "30", "--db", db)
+			database, err := loadDatabase(context.Background(), config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			s := &scanner{config: config, db: database, hasher: newHasher(config)}
+			seen := hashExecutables(context.Background(), s, exes, links)
+			if len(seen) != len(exes) {
+				t.Fatalf("%d executables hashed, want %d", len(seen), len(exes))
+			}
+			for id, result := range seen {
+				if result.Path != links[id] || result.TLSH == "" || result.Error != "" {
+					t.Errorf("executable %v: %+v, want %s hashed", id, result, links[id])
+				}
+			}
+			if m := seen[exes[0]].Match; m == nil || m.FileName != "known.exe" {
+				t.Errorf("known.exe matched %+v", m)
+			}
+		})
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	s := &scanner{config: Config{Workers: 4}}
+	if seen := hashExecutables(ctx, s, exes, links); len(seen) != 0 {
+		t.Errorf("%d executables hashed after cancellation, want none", len(seen))
+	}
+}
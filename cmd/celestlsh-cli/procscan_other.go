@@ -0,0 +1,12 @@
+//go:build !linux
+
+package main
+
+import (
+	"context"
+	"errors"
+)
+
+func executeProcScan(ctx context.Context, config Config) (status, error) {
+	return statusOK, errors.New("procscan is unsupported on this platform; it requires Linux")
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// progressInterval is how often a progress line is redrawn.
+const progressInterval = 200 * time.Millisecond
+
+// progressEnabled reports whether a progress line may be drawn on stderr:
+// only for an interactive terminal, and not in quiet mode or with
+// --no-progress, so that logs are not filled with carriage returns.
+func progressEnabled(config Config) bool {
+	return !config.Quiet && !config.NoProgress && isTerminal(os.Stderr)
+}
+
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	r io.Reader
+	n *atomic.Int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n.Add(int64(n))
+	return n, err
+}
+
+// progressMu serialises drawing the progress line with clearProgress;
+// progressShown is set while a line is on screen.
+var (
+	progressMu    sync.Mutex
+	progressShown bool
+)
+
+// clearProgress erases the progress line, if one is drawn, so that a
+// message can be written to stderr. It is redrawn on the next tick.
+func clearProgress() {
+	progressMu.Lock()
+	defer progressMu.Unlock()
+	if progressShown {
+		fmt.Fprint(os.Stderr, "\r\x1b[K")
+		progressShown = false
+	}
+}
+
+// progressLine redraws a single status line on stderr until finished.
+type progressLine struct {
+	render func(elapsed time.Duration) string
+	start  time.Time
+	stop   chan struct{}
+	wg     sync.WaitGroup
+}
+
+func startProgress(render func(elapsed time.Duration) string) *progressLine {
+	p := &progressLine{render: render, start: time.Now(), stop: make(chan struct{})}
+
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		ticker := time.NewTicker(progressInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-p.stop:
+				// Clear the line so that later output starts clean.
+				clearProgress()
+				return
+			case <-ticker.C:
+				progressMu.Lock()
+				fmt.Fprintf(os.Stderr, "\r%s\x1b[K", p.render(time.Since(p.start)))
+				progressShown = true
+				progressMu.Unlock()
+			}
+		}
+	}()
+
+	return p
+}
+
+// finish stops redrawing and clears the line. It is safe on a nil line.
+func (p *progressLine) finish() {
+	if p == nil {
+		return
+	}
+	close(p.stop)
+	p.wg.Wait()
+}
+
+// downloadProgress renders bytes received so far, with rate and, when the
+// total is known, percentage and ETA.
+func downloadProgress(received *atomic.Int64, total int64) func(time.Duration) string {
+	return func(elapsed time.Duration) string {
+		n := received.Load()
+		rate := float64(n) / elapsed.Seconds()
+
+		if total <= 0 {
+			return fmt.Sprintf("Downloading: %s, %s/s", formatSize(n), formatSize(int64(rate)))
+		}
+
+		line := fmt.Sprintf("Downloading: %s / %s (%d%%), %s/s", formatSize(n), formatSize(total), n*100/total, formatSize(int64(rate)))
+		if rate > 0 && n < total {
+			eta := time.Duration(float64(total-n) / rate * float64(time.Second))
+			line += fmt.Sprintf(", ETA %s", eta.Round(time.Second))
+		}
+		return line
+	}
+}
+
+// scanStats counts the files found and finished by a scan, the results
+// that matched, were suppressed or failed, the bytes hashed, and the
+// entries skipped by --max-depth, --skip-hidden, --include, --exclude,
+// --skip-ext, --only-ext and --max-file-size, because they were too small
+// to hash, or because they were symbolic links that were not followed,
+// non-regular files or virtual filesystems. The counters are updated from every worker of the pool.
+type scanStats struct {
+	discovered    atomic.Int64
+	processed     atomic.Int64
+	results       atomic.Int64
+	matched       atomic.Int64
+	failed        atomic.Int64
+	timedOut      atomic.Int64
+	filteredFile  atomic.Int64
+	filteredDir   atomic.Int64
+	oversized     atomic.Int64
+	skippedExt    atomic.Int64
+	skippedType   atomic.Int64
+	tooSmall      atomic.Int64
+	skippedLinks  atomic.Int64
+	special       atomic.Int64
+	skippedMounts atomic.Int64
+	skippedDepth  atomic.Int64
+	skippedHidden atomic.Int64
+	bytes         atomic.Int64
+	suppressed    atomic.Int64
+	highEntropy   atomic.Int64
+
+	quarantined      atomic.Int64
+	quarantineFailed atomic.Int64
+
+	// cacheHits and cacheMisses count the files whose digests were, and
+	// were not, in the --hash-cache.
+	cacheHits   atomic.Int64
+	cacheMisses atomic.Int64
+
+	// byRepo counts matches by repository, under mu.
+	mu     sync.Mutex
+	byRepo map[string]int64
+}
+
+// tally counts a result as it is printed.
+func (p *scanStats) tally(result scanResult) {
+	p.results.Add(1)
+	if result.HighEntropy {
+		p.highEntropy.Add(1)
+	}
+	switch {
+	case result.Error != "":
+		p.failed.Add(1)
+		if result.ErrorCode == codeFileTimeout {
+			p.timedOut.Add(1)
+		}
+	case result.Suppressed:
+		p.suppressed.Add(1)
+	case result.Match != nil:
+		p.matched.Add(1)
+		p.mu.Lock()
+		if p.byRepo == nil {
+			p.byRepo = make(map[string]int64)
+		}
+		p.byRepo[result.Match.RepoName]++
+		p.mu.Unlock()
+	}
+}
+
+func (p *scanStats) render(time.Duration) string {
+	return fmt.Sprintf("Scanned %d / %d files", p.processed.Load(), p.discovered.Load())
+}
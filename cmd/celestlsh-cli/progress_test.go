@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+func TestCountingReader(t *testing.T) {
+	data := sampleData(1, 10000)
+	var n atomic.Int64
+	for _, r := range []io.Reader{
+		bytes.NewReader(data),
+		iotest.OneByteReader(bytes.NewReader(data)),
+		iotest.HalfReader(bytes.NewReader(data)),
+	} {
+		n.Store(0)
+		got, err := io.ReadAll(&countingReader{r: r, n: &n})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, data) || n.Load() != int64(len(data)) {
+			t.Errorf("read %d bytes, counted %d; want %d", len(got), n.Load(), len(data))
+		}
+	}
+
+	// Bytes read along with an error count too.
+	n.Store(0)
+	failing := io.MultiReader(bytes.NewReader(data[:100]), iotest.ErrReader(io.ErrUnexpectedEOF))
+	if _, err := io.ReadAll(&countingReader{r: failing, n: &n}); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("error = %v, want it passed on", err)
+	}
+	if n.Load() != 100 {
+		t.Errorf("counted %d bytes, want 100", n.Load())
+	}
+}
+
+func TestDownloadProgress(t *testing.T) {
+	var received atomic.Int64
+	received.Store(25 << 20)
+
+	tests := []struct {
+		total int64
+		want  string
+	}{
+		{100 << 20, "Downloading: 25.0 MiB / 100.0 MiB (25%), 5.0 MiB/s, ETA 15s"},
+		{25 << 20, "Downloading: 25.0 MiB / 25.0 MiB (100%), 5.0 MiB/s"},
+		{-1, "Downloading: 25.0 MiB, 5.0 MiB/s"},
+	}
+	for _, tt := range tests {
+		if got := downloadProgress(&received, tt.total)(5 * time.Second); got != tt.want {
+			t.Errorf("total %d: %q, want %q", tt.total, got, tt.want)
+		}
+	}
+}
+
+func TestScanStatsRender(t *testing.T) {
+	var s scanStats
+	s.discovered.Store(120)
+	s.processed.Store(45)
+	if got, want := s.render(time.Second), "Scanned 45 / 120 files"; got != want {
+		t.Errorf("render = %q, want %q", got, want)
+	}
+}
+
+func TestProgressDisabledWithoutTerminal(t *testing.T) {
+	f, err := os.Create(filepath.Join(t.TempDir(), "stderr"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	old := os.Stderr
+	os.Stderr = f
+	defer func() { os.Stderr = old }()
+
+	if progressEnabled(Config{}) {
+		t.Error("progress enabled with stderr redirected to a file")
+	}
+	if isTerminal(f) {
+		t.Error("a regular file counts as a terminal")
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Magonia-Research/CelesTLSH-CLI/pkg/celestlsh"
+)
+
+// repoList collects repeated --repo flags.
+type repoList []string
+
+func (r *repoList) String() string { return strings.Join(*r, ", ") }
+
+func (r *repoList) Set(value string) error {
+	if strings.TrimSpace(value) == "" {
+		return fmt.Errorf("empty repository name")
+	}
+	*r = append(*r, value)
+	return nil
+}
+
+// executeDBPrune removes the rows of the --db database added before
+// --before, only from the repositories named with --repo if any. Rows
+// whose date is missing or cannot be parsed are kept unless
+// --prune-undated is given, as are rows too short to have a date. The
+// database is replaced as db-import replaces it, or left alone and the
+// result written to --out; with --dry-run nothing is written and the rows
+// that would be removed are printed instead.
+func executeDBPrune(config Config) (status, error) {
+	f, err := os.Open(config.DbPath)
+	if err != nil {
+		return statusOK, fmt.Errorf("failed to read database: %w", err)
+	}
+	header, kept, removed, undated, err := pruneRows(f, config.PruneBefore, config.PruneRepos, config.PruneUndated)
+	f.Close()
+	if err != nil {
+		return statusOK, err
+	}
+
+	if config.DryRun {
+		w := csv.NewWriter(os.Stdout)
+		for _, row := range removed {
+			w.Write(row)
+		}
+		w.Flush()
+		if err := w.Error(); err != nil {
+			return statusOK, err
+		}
+		if !config.Quiet {
+			fmt.Fprintf(os.Stderr, "Would remove %d rows from %s and keep %d%s (dry run)\n", len(removed), config.DbPath, len(kept), undatedNote(undated))
+		}
+		return statusOK, nil
+	}
+
+	target := config.PruneOut
+	if target == "" {
+		target = config.DbPath
+	}
+	// Rewriting the database in place is only needed if rows go.
+	if len(removed) > 0 || target != config.DbPath {
+		err := replaceDatabase(target, config.DbPath, func(f *os.File) error {
+			w := csv.NewWriter(f)
+			w.Write(header)
+			for _, row := range kept {
+				w.Write(row)
+			}
+			w.Flush()
+			return w.Error()
+		})
+		if err != nil {
+			return statusOK, err
+		}
+		if target == config.DbPath {
+			removeStaleSignature(config.DbPath)
+		}
+	}
+	if !config.Quiet {
+		fmt.Fprintf(os.Stderr, "Removed %d rows and kept %d%s, written to %s\n", len(removed), len(kept), undatedNote(undated), target)
+	}
+	return statusOK, nil
+}
+
+// pruneRows reads a database CSV from r and splits its rows into those
+// kept and those removed for being added before the cutoff, counting the
+// undated rows kept. Only rows of the named repositories, compared
+// ignoring case, are removed if any are named.
+func pruneRows(r io.Reader, before time.Time, repos []string, pruneUndated bool) (header []string, kept, removed [][]string, undated int, err error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	header, err = reader.Read()
+	if err != nil {
+		return nil, nil, nil, 0, &celestlsh.DatabaseError{Op: "reading CSV header", Err: err}
+	}
+
+	// Rows added on or after the cutoff pass the filter, as do undated
+	// rows unless they are pruned too.
+	recent := celestlsh.AddedBetween(before, time.Time{}, pruneUndated)
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, nil, 0, &celestlsh.DatabaseError{Op: "reading CSV record", Err: err}
+		}
+		if len(record) < celestlsh.Columns || !pruneRepo(repos, record[0]) {
+			kept = append(kept, record)
+			continue
+		}
+		if recent(&celestlsh.HashRecord{DateAdded: record[6]}) {
+			if _, err := celestlsh.ParseDate(record[6]); err != nil {
+				undated++
+			}
+			kept = append(kept, record)
+			continue
+		}
+		removed = append(removed, record)
+	}
+	return header, kept, removed, undated, nil
+}
+
+// pruneRepo reports whether rows of repo may be pruned.
+func pruneRepo(repos []string, repo string) bool {
+	if len(repos) == 0 {
+		return true
+	}
+	for _, r := range repos {
+		if strings.EqualFold(strings.TrimSpace(r), strings.TrimSpace(repo)) {
+			return true
+		}
+	}
+	return false
+}
+
+func undatedNote(undated int) string {
+	if undated == 0 {
+		return ""
+	}
+	return fmt.Sprintf(", %d of them without a valid date", undated)
+}
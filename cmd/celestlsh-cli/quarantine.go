@@ -0,0 +1,253 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/Magonia-Research/CelesTLSH-CLI/pkg/celestlsh"
+)
+
+// quarantineManifest is the file in the quarantine directory that records
+// every file moved there.
+const quarantineManifest = "manifest.jsonl"
+
+// quarantine moves matched files out of the scanned tree into a directory,
+// keeping their path relative to the scanned directory, and records each
+// move in the directory's manifest so that it can be undone.
+type quarantine struct {
+	dir    string
+	absDir string
+	roots  []string
+	dryRun bool
+
+	// moved maps each file already quarantined to where it went, so that an
+	// archive with several matching members is only moved once; claimed
+	// holds the destinations taken, which a dry run does not create.
+	moved   map[string]string
+	claimed map[string]bool
+
+	manifest *os.File
+}
+
+// quarantineRecord is one line of the quarantine manifest.
+type quarantineRecord struct {
+	Time           string `json:"time"`
+	OriginalPath   string `json:"original_path"`
+	QuarantinePath string `json:"quarantine_path"`
+	Mode           string `json:"mode"`
+
+	// Path is the scanned path that matched, which names the member when
+	// the file moved is an archive.
+	Path string `json:"path"`
+	celestlsh.Digests
+	Match *celestlsh.HashRecord `json:"match"`
+}
+
+// newQuarantine returns the quarantine for --quarantine, or nil if it is not
+// set. Paths are made relative to whichever of roots they are found under.
+func newQuarantine(config Config, roots []string) (*quarantine, error) {
+	if config.Quarantine == "" {
+		return nil, nil
+	}
+	absDir, err := filepath.Abs(config.Quarantine)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve quarantine directory: %w", err)
+	}
+	return &quarantine{
+		dir:     config.Quarantine,
+		absDir:  absDir,
+		roots:   roots,
+		dryRun:  config.DryRun,
+		moved:   make(map[string]string),
+		claimed: make(map[string]bool),
+	}, nil
+}
+
+// contains reports whether the directory at the absolute path abs is the
+// quarantine directory, which walks must not enter.
+func (q *quarantine) contains(abs string) bool {
+	return q != nil && abs == q.absDir
+}
+
+// move quarantines file, the file on disk that result was scanned from,
+// returning where it went. With --dry-run it only reports where it would go.
+func (q *quarantine) move(file string, result scanResult) (string, error) {
+	if dst, ok := q.moved[file]; ok {
+		return dst, nil
+	}
+
+	// Move what a followed link points to rather than the link.
+	src, err := filepath.EvalSymlinks(file)
+	if err != nil {
+		return "", err
+	}
+	if src, err = filepath.Abs(src); err != nil {
+		return "", err
+	}
+	info, err := os.Lstat(src)
+	if err != nil {
+		return "", err
+	}
+
+	dst := q.destination(file)
+	q.claimed[dst] = true
+	if q.dryRun {
+		q.moved[file] = dst
+		return dst, nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(dst), 0o700); err != nil {
+		return "", err
+	}
+	if err := moveFile(src, dst); err != nil {
+		return "", err
+	}
+	q.moved[file] = dst
+
+	record := quarantineRecord{
+		Time:           time.Now().UTC().Format(time.RFC3339),
+		OriginalPath:   src,
+		QuarantinePath: dst,
+		Mode:           fmt.Sprintf("%#o", info.Mode().Perm()),
+		Path:           result.Path,
+		Digests:        result.Digests,
+		Match:          result.Match,
+	}
+	if err := q.record(record); err != nil {
+		return dst, fmt.Errorf("moved to %s but failed to record it in the manifest: %v", dst, err)
+	}
+	return dst, nil
+}
+
+// destination returns a path in the quarantine directory for file that is
+// not already taken, numbering it if need be.
+func (q *quarantine) destination(file string) string {
+	rel := filepath.Base(file)
+	longest := -1
+	for _, root := range q.roots {
+		root = filepath.Clean(root)
+		r, err := filepath.Rel(root, file)
+		if err == nil && r != "." && withinDir(root, file) && len(root) > longest {
+			rel, longest = r, len(root)
+		}
+	}
+
+	base := filepath.Join(q.dir, rel)
+	dst := base
+	for i := 1; ; i++ {
+		if _, err := os.Lstat(dst); errors.Is(err, fs.ErrNotExist) && !q.claimed[dst] {
+			return dst
+		}
+		dst = fmt.Sprintf("%s.%d", base, i)
+	}
+}
+
+// record appends r to the manifest, opening it on first use.
+func (q *quarantine) record(r quarantineRecord) error {
+	if q.manifest == nil {
+		f, err := os.OpenFile(filepath.Join(q.dir, quarantineManifest), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+		if err != nil {
+			return err
+		}
+		q.manifest = f
+	}
+	line, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	if _, err := q.manifest.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	return q.manifest.Sync()
+}
+
+func (q *quarantine) close() error {
+	if q == nil || q.manifest == nil {
+		return nil
+	}
+	return q.manifest.Close()
+}
+
+// moveFile renames src to dst or, across filesystems, copies it and removes
+// the original. A failed copy leaves neither a partial nor a second copy.
+func moveFile(src, dst string) error {
+	err := os.Rename(src, dst)
+	if err == nil || !crossDevice(err) {
+		return err
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	// Copy under a temporary name so that dst only ever holds a whole file.
+	tmp, err := os.CreateTemp(filepath.Dir(dst), ".quarantine-*")
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(tmp, in)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), dst)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	in.Close()
+	if err := os.Remove(src); err != nil {
+		os.Remove(dst)
+		return fmt.Errorf("copied the file but could not remove the original, so the copy was discarded: %w", err)
+	}
+	return nil
+}
+
+// quarantineFile moves the file result was scanned from into quarantine,
+// reporting the move, or the failure to make it, on stderr.
+func (s *scanner) quarantineFile(result *scanResult) {
+	_, again := s.quarantine.moved[result.file]
+	dst, err := s.quarantine.move(result.file, *result)
+	if err != nil {
+		clearProgress()
+		fmt.Fprintf(os.Stderr, "Error: failed to quarantine %s: %v\n", result.file, err)
+		if s.stats != nil {
+			s.stats.quarantineFailed.Add(1)
+		}
+		if dst == "" {
+			return
+		}
+	}
+	if s.quarantine.dryRun {
+		if !again {
+			clearProgress()
+			fmt.Fprintf(os.Stderr, "Would quarantine %s to %s\n", result.file, dst)
+		}
+		return
+	}
+
+	result.Quarantined = dst
+	if again {
+		return
+	}
+	if s.stats != nil {
+		s.stats.quarantined.Add(1)
+	}
+	if !s.config.Quiet && !s.config.OutputJSONL {
+		clearProgress()
+		fmt.Fprintf(os.Stderr, "Quarantined %s to %s\n", result.file, dst)
+	}
+}
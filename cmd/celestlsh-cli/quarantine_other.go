@@ -0,0 +1,10 @@
+//go:build windows || plan9
+
+package main
+
+// crossDevice reports that any failed rename may have crossed filesystems,
+// since the error does not say; the copy that follows fails cleanly if it
+// cannot be made either.
+func crossDevice(err error) bool {
+	return true
+}
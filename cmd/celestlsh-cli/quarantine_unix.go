@@ -0,0 +1,14 @@
+//go:build !windows && !plan9
+
+package main
+
+import (
+	"errors"
+	"syscall"
+)
+
+// crossDevice reports whether a rename failed because its source and
+// destination are on different filesystems.
+func crossDevice(err error) bool {
+	return errors.Is(err, syscall.EXDEV)
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/Magonia-Research/CelesTLSH-CLI/pkg/celestlsh"
+)
+
+// dbPollInterval is how often serve and daemon modes look at the database
+// file for changes. It is a variable so that tests can shorten it.
+var dbPollInterval = 2 * time.Second
+
+// liveDatabase is the database of serve and daemon modes, replaced by a
+// fresh copy when the file changes, such as after a nightly --download.
+// Checks already running keep the copy they started with.
+type liveDatabase struct {
+	config  Config
+	current atomic.Pointer[loadedDatabase]
+}
+
+// loadedDatabase is a parsed database and the file it was parsed from.
+type loadedDatabase struct {
+	db      *celestlsh.Database
+	modTime time.Time
+	size    int64
+}
+
+// newLiveDatabase loads the configured database.
+func newLiveDatabase(ctx context.Context, config Config) (*liveDatabase, error) {
+	// The file is looked at before it is read, so that a change made while
+	// it loads is picked up by the next poll.
+	info, statErr := os.Stat(config.DbPath)
+	db, err := loadDatabase(ctx, config)
+	if err != nil {
+		return nil, err
+	}
+	l := &liveDatabase{config: config}
+	loaded := &loadedDatabase{db: db}
+	if statErr == nil {
+		loaded.modTime, loaded.size = info.ModTime(), info.Size()
+	}
+	l.current.Store(loaded)
+	return l, nil
+}
+
+// get returns the current copy of the database.
+func (l *liveDatabase) get() *celestlsh.Database {
+	return l.current.Load().db
+}
+
+// modTime returns the modification time of the file the current copy was
+// loaded from.
+func (l *liveDatabase) modTime() time.Time {
+	return l.current.Load().modTime
+}
+
+// watch polls the database file until ctx is done, reloading it once a
+// change has settled: the file must have the same size and modification
+// time at two polls in a row, so that one still being written is not
+// read. A file that fails to load is not retried until it changes again.
+func (l *liveDatabase) watch(ctx context.Context) {
+	if useEmbedded(l.config) {
+		return
+	}
+	ticker := time.NewTicker(dbPollInterval)
+	defer ticker.Stop()
+
+	var seen, failed os.FileInfo
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		// A download renames the new file into place, but other tools may
+		// remove the file first; keep the current copy until it is back.
+		info, err := os.Stat(l.config.DbPath)
+		if err != nil {
+			continue
+		}
+		cur := l.current.Load()
+		if sameFileState(info, cur.modTime, cur.size) || (failed != nil && sameFileState(info, failed.ModTime(), failed.Size())) {
+			seen = nil
+			continue
+		}
+		if seen == nil || !sameFileState(info, seen.ModTime(), seen.Size()) {
+			seen = info
+			continue
+		}
+		seen = nil
+
+		db := reloadDatabase(ctx, l.config, cur.db)
+		if db == nil {
+			failed = info
+			continue
+		}
+		failed = nil
+		l.current.Store(&loadedDatabase{db: db, modTime: info.ModTime(), size: info.Size()})
+	}
+}
+
+func sameFileState(info os.FileInfo, modTime time.Time, size int64) bool {
+	return info.ModTime().Equal(modTime) && info.Size() == size
+}
+
+// reloadDatabase loads a fresh copy of the database to replace old. If the
+// file cannot be loaded it warns and returns nil, and old stays in use.
+func reloadDatabase(ctx context.Context, config Config, old *celestlsh.Database) *celestlsh.Database {
+	db, err := loadDatabase(ctx, config)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to reload database, keeping previous copy: %v\n", err)
+		return nil
+	}
+	if !config.Quiet {
+		fmt.Fprintf(os.Stderr, "Reloaded database: %d records (was %d)\n", db.Len(), old.Len())
+	}
+	return db
+}
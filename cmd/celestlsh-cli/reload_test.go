@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Magonia-Research/CelesTLSH-CLI/pkg/celestlsh"
+)
+
+func TestReloadWhileServing(t *testing.T) {
+	oldInterval := dbPollInterval
+	t.Cleanup(func() { dbPollInterval = oldInterval })
+	dbPollInterval = 5 * time.Millisecond
+
+	errFile, err := os.Create(filepath.Join(t.TempDir(), "stderr"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldErr := os.Stderr
+	os.Stderr = errFile
+	t.Cleanup(func() { os.Stderr = oldErr })
+
+	records := testRecords(t)
+	dbPath := writeTestDatabase(t)
+	// Each replacement is written beside the database and renamed over it,
+	// as a download does.
+	replace := func(data []byte) {
+		t.Helper()
+		tmp := dbPath + ".tmp"
+		if err := os.WriteFile(tmp, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Rename(tmp, dbPath); err != nil {
+			t.Fatal(err)
+		}
+	}
+	withExtra := func(n int) []byte {
+		t.Helper()
+		extra := append([]celestlsh.HashRecord(nil), records...)
+		for i := range n {
+			r := records[2]
+			r.FileName = fmt.Sprintf("extra-%d.exe", i)
+			extra = append(extra, r)
+		}
+		data, err := os.ReadFile(writeTestDatabase(t, extra...))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+
+	config := parseTestFlags(t, "--serve", "--db", dbPath)
+	db, err := newLiveDatabase(context.Background(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go db.watch(ctx)
+	ts := httptest.NewServer(newServer(db).routes())
+	defer ts.Close()
+
+	// Checks run throughout; each must find known.exe, in whichever copy
+	// of the database it got.
+	body := fmt.Sprintf(`{"tlsh":%q}`, records[0].TLSHHash)
+	var wg sync.WaitGroup
+	var checks, failures atomic.Int64
+	var firstFailure atomic.Value
+	stop := make(chan struct{})
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				resp, err := http.Post(ts.URL+"/check", "application/json", strings.NewReader(body))
+				if err != nil {
+					failures.Add(1)
+					firstFailure.CompareAndSwap(nil, err.Error())
+					continue
+				}
+				var got checkResponse
+				err = json.NewDecoder(resp.Body).Decode(&got)
+				resp.Body.Close()
+				if err != nil || resp.StatusCode != http.StatusOK || len(got.Matches) != 1 || got.Matches[0].FileName != "known.exe" {
+					failures.Add(1)
+					firstFailure.CompareAndSwap(nil, fmt.Sprintf("status %d, %+v, %v", resp.StatusCode, got, err))
+				}
+				checks.Add(1)
+			}
+		}()
+	}
+
+	waitFor := func(records int) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for db.get().Len() != records {
+			if time.Now().After(deadline) {
+				t.Fatalf("database has %d records, want %d after a reload", db.get().Len(), records)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	replace(withExtra(1))
+	waitFor(5)
+	replace(withExtra(2))
+	waitFor(6)
+
+	// A file that fails to parse leaves the loaded copy in use.
+	replace([]byte("not,a,database\n"))
+	time.Sleep(50 * dbPollInterval)
+	if n := db.get().Len(); n != 6 {
+		t.Errorf("database has %d records after a bad file, want the 6 of the previous copy", n)
+	}
+	replace(withExtra(3))
+	waitFor(7)
+
+	close(stop)
+	wg.Wait()
+	if checks.Load() == 0 || failures.Load() != 0 {
+		t.Errorf("%d of %d checks failed, first: %v", failures.Load(), checks.Load(), firstFailure.Load())
+	}
+
+	os.Stderr = oldErr
+	errFile.Close()
+	log, _ := os.ReadFile(errFile.Name())
+	for _, want := range []string{
+		"Reloaded database: 5 records (was 4)",
+		"Reloaded database: 6 records (was 5)",
+		"Warning: failed to reload database, keeping previous copy",
+		"Reloaded database: 7 records (was 6)",
+	} {
+		if !strings.Contains(string(log), want) {
+			t.Errorf("stderr:\n%s\nwant %q", log, want)
+		}
+	}
+	if n := strings.Count(string(log), "Warning"); n != 1 {
+		t.Errorf("stderr:\n%s\nwant the bad file reported once", log)
+	}
+}
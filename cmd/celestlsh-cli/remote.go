@@ -0,0 +1,227 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/Magonia-Research/CelesTLSH-CLI/pkg/celestlsh"
+)
+
+// remoteTimeout bounds each check sent to --remote.
+const remoteTimeout = 30 * time.Second
+
+// remoteLookup checks hashes against another instance of this tool in
+// serve mode, over HTTP for --remote or gRPC for --grpc, so that a thin
+// client needs no copy of the database. The server's --limit caps the
+// records it returns, as it does for any client.
+//
+// With --remote-fallback local, a server that cannot answer at all is
+// given up on for the rest of the run and hashes are checked against the
+// local database instead, loaded when first needed. Requests the server
+// refuses, such as with bad credentials, still fail.
+type remoteLookup struct {
+	config   Config
+	name     string
+	client   remoteClient
+	fallback bool
+
+	// quiet is set for the daemon on --socket, which is often simply not
+	// running, so falling back to the local database is not warned about.
+	quiet bool
+
+	// mu guards the switch to the local database, which workers of a scan
+	// may all attempt at once. localErr is why it failed to load, so that
+	// it is only tried once.
+	mu       sync.Mutex
+	local    *celestlsh.Database
+	localErr error
+}
+
+// remoteClient sends checks to a server in serve mode.
+type remoteClient interface {
+	check(ctx context.Context, req checkRequest) (checkResponse, error)
+
+	// unavailable reports whether err, returned by check, means the
+	// server could not answer at all, rather than refusing the request.
+	unavailable(err error) bool
+
+	close()
+}
+
+// newRemoteLookup returns the lookup for --remote or --grpc, or nil if
+// neither is set.
+func newRemoteLookup(config Config) (*remoteLookup, error) {
+	r := &remoteLookup{config: config, fallback: config.RemoteFallback == "local"}
+	var err error
+	switch {
+	case config.Remote != "":
+		r.name = config.Remote
+		r.client, err = newHTTPRemote(config)
+	case config.GRPC != "":
+		r.name = config.GRPC
+		r.client, err = newGRPCRemote(config)
+	default:
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// remoteName names the server of --remote or --grpc, or returns "" if
+// neither is set.
+func remoteName(config Config) string {
+	if config.Remote != "" {
+		return config.Remote
+	}
+	return config.GRPC
+}
+
+// close closes the connection to the server. It is safe on a nil lookup.
+func (r *remoteLookup) close() {
+	if r != nil {
+		r.client.close()
+	}
+}
+
+// query returns up to top records within maxDistance of hash, closest
+// first, from the server or, once it has been given up on, the local
+// database.
+func (r *remoteLookup) query(ctx context.Context, hash string, maxDistance, top int) ([]celestlsh.HashRecord, error) {
+	if db := r.localDatabase(); db != nil {
+		return db.Nearest(ctx, hash, maxDistance, top)
+	}
+
+	req := checkRequest{TLSH: hash, Top: &top}
+	if maxDistance >= 0 {
+		req.MaxDistance = &maxDistance
+	}
+	resp, err := r.client.check(ctx, req)
+	if err == nil {
+		return resp.Matches, nil
+	}
+	if !r.fallback || !r.client.unavailable(err) || ctx.Err() != nil {
+		return nil, err
+	}
+
+	db, err := r.fallBack(ctx, err)
+	if err != nil {
+		return nil, err
+	}
+	return db.Nearest(ctx, hash, maxDistance, top)
+}
+
+// httpRemote sends checks to the POST /check endpoint of the server at
+// --remote.
+type httpRemote struct {
+	url    string
+	header http.Header
+	client *http.Client
+}
+
+func newHTTPRemote(config Config) (*httpRemote, error) {
+	base, err := url.Parse(config.Remote)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --remote: %w", err)
+	}
+	transport, err := newHTTPTransport(config)
+	if err != nil {
+		return nil, err
+	}
+	r := &httpRemote{
+		url:    base.JoinPath("check").String(),
+		header: config.Headers.header(),
+		client: &http.Client{Transport: transport, Timeout: remoteTimeout},
+	}
+	if config.BearerToken != "" {
+		r.header.Set("Authorization", "Bearer "+config.BearerToken)
+	}
+	return r, nil
+}
+
+func (r *httpRemote) check(ctx context.Context, req checkRequest) (checkResponse, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return checkResponse{}, err
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, r.url, bytes.NewReader(body))
+	if err != nil {
+		return checkResponse{}, &celestlsh.RequestError{URL: r.url, Err: err}
+	}
+	httpReq.Header = r.header.Clone()
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	resp, err := r.client.Do(httpReq)
+	if err != nil {
+		return checkResponse{}, &celestlsh.RequestError{URL: r.url, Err: err}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		statusErr := &celestlsh.StatusError{URL: r.url, StatusCode: resp.StatusCode}
+		var e errorResponse
+		if json.NewDecoder(io.LimitReader(resp.Body, maxCheckBodyBytes)).Decode(&e) == nil && e.Error != "" {
+			return checkResponse{}, fmt.Errorf("%w: %s", statusErr, e.Error)
+		}
+		return checkResponse{}, statusErr
+	}
+	var answer checkResponse
+	if err := json.NewDecoder(resp.Body).Decode(&answer); err != nil {
+		return checkResponse{}, &celestlsh.RequestError{URL: r.url, Err: fmt.Errorf("invalid response: %w", err)}
+	}
+	return answer, nil
+}
+
+// unavailable holds network errors and 5xx statuses.
+func (r *httpRemote) unavailable(err error) bool {
+	var statusErr *celestlsh.StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode >= 500
+	}
+	var requestErr *celestlsh.RequestError
+	return errors.As(err, &requestErr)
+}
+
+func (r *httpRemote) close() {
+	r.client.CloseIdleConnections()
+}
+
+// localDatabase returns the local database if the server has been given
+// up on.
+func (r *remoteLookup) localDatabase() *celestlsh.Database {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.local
+}
+
+// fallBack gives up on the server, which failed with cause, and loads the
+// local database unless another check already has.
+func (r *remoteLookup) fallBack(ctx context.Context, cause error) (*celestlsh.Database, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.local != nil || r.localErr != nil {
+		return r.local, r.localErr
+	}
+	if r.quiet {
+		slog.Debug("falling back to the local database", "server", r.name, "error", cause)
+	} else {
+		clearProgress()
+		fmt.Fprintf(os.Stderr, "Warning: %s is unavailable, checking against the local database instead: %v\n", r.name, cause)
+	}
+	r.local, r.localErr = loadDatabase(ctx, r.config)
+	if r.localErr != nil {
+		r.localErr = fmt.Errorf("%v, and %w", cause, r.localErr)
+	}
+	return r.local, r.localErr
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// remoteServer serves the test database in serve mode and records the
+// headers of each request, passing them through wrap first if it is set.
+type remoteServer struct {
+	*httptest.Server
+
+	mu      sync.Mutex
+	headers []http.Header
+}
+
+func newRemoteServer(t *testing.T, wrap func(http.Handler) http.Handler) *remoteServer {
+	t.Helper()
+	s, _ := newTestServer(t)
+	rs := &remoteServer{}
+	var h http.Handler = s.routes()
+	if wrap != nil {
+		h = wrap(h)
+	}
+	rs.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rs.mu.Lock()
+		rs.headers = append(rs.headers, r.Header.Clone())
+		rs.mu.Unlock()
+		h.ServeHTTP(w, r)
+	}))
+	t.Cleanup(rs.Close)
+	return rs
+}
+
+func (rs *remoteServer) requests() []http.Header {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	return append([]http.Header(nil), rs.headers...)
+}
+
+// failing answers every request with status.
+func failing(status int) func(http.Handler) http.Handler {
+	return func(http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, `{"error":"refused"}`, status)
+		})
+	}
+}
+
+// missingDatabase is a --db that does not exist, to show that a run only
+// used the server.
+func missingDatabase(t *testing.T) string {
+	return filepath.Join(t.TempDir(), "missing.csv")
+}
+
+func TestRemoteCheck(t *testing.T) {
+	rs := newRemoteServer(t, nil)
+	records := testRecords(t)
+
+	for _, args := range [][]string{
+		{records[0].TLSHHash},
+		{"--top", "2", records[0].TLSHHash},
+		{"--max-distance", "100", records[1].TLSHHash},
+		{"--max-distance", "0", testHash(t, sampleData(9, 8192))},
+	} {
+		t.Run(strings.Join(args[:len(args)-1], " "), func(t *testing.T) {
+			// A remote check answers as a local one does.
+			local, _, localStatus, err := runCLI(t, append([]string{"-c", "--json", "--db", writeTestDatabase(t)}, args...)...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			remote, _, remoteStatus, err := runCLI(t, append([]string{"-c", "--json", "--remote", rs.URL, "--db", missingDatabase(t)}, args...)...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if remote != local || remoteStatus != localStatus {
+				t.Errorf("remote check (status %v):\n%s\nwant as local (status %v):\n%s", remoteStatus, remote, localStatus, local)
+			}
+		})
+	}
+}
+
+func TestRemoteScan(t *testing.T) {
+	rs := newRemoteServer(t, nil)
+	dir := t.TempDir()
+	writeFile(t, dir, "known.exe", testSample)
+	writeFile(t, dir, "clean.bin", sampleData(9, 8192))
+
+	out, _, st, err := runCLI(t, "-s", "--jsonl", "--max-distance", "30", "--remote", rs.URL, "--db", missingDatabase(t), dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	results, summary := parseJSONL(t, out)
+	byPath := resultsByPath(results)
+	if m := byPath[filepath.Join(dir, "known.exe")].Match; m == nil || m.FileName != "known.exe" || m.Distance != 0 {
+		t.Errorf("known.exe matched %+v, want known.exe at 0", m)
+	}
+	if m := byPath[filepath.Join(dir, "clean.bin")].Match; m != nil {
+		t.Errorf("clean.bin matched %+v", m)
+	}
+	if st != statusMatch || summary.Files != 2 || summary.Matched != 1 {
+		t.Errorf("status %v, summary %+v, want one match of two files", st, summary)
+	}
+	if n := len(rs.requests()); n != 2 {
+		t.Errorf("server got %d requests, want one per file", n)
+	}
+}
+
+func TestRemoteHeaders(t *testing.T) {
+	rs := newRemoteServer(t, nil)
+	hash := testRecords(t)[0].TLSHHash
+
+	tests := []struct {
+		name string
+		args []string
+		env  string
+		want map[string]string
+	}{
+		{"none", nil, "", map[string]string{"Authorization": "", "Content-Type": "application/json"}},
+		{"bearer token", []string{"--bearer-token", "secret"}, "", map[string]string{"Authorization": "Bearer secret"}},
+		{"token from environment", nil, "from-env", map[string]string{"Authorization": "Bearer from-env"}},
+		{"header", []string{"--header", "X-Team: red", "--header", "Authorization: Basic abc"}, "", map[string]string{"X-Team": "red", "Authorization": "Basic abc"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("CELESTLSH_TOKEN", tt.env)
+			before := len(rs.requests())
+			args := append([]string{"-c", "--json", "--remote", rs.URL, "--db", missingDatabase(t)}, tt.args...)
+			if _, _, _, err := runCLI(t, append(args, hash)...); err != nil {
+				t.Fatal(err)
+			}
+			// A check asks for the closest record and then every record as
+			// close; both carry the headers.
+			reqs := rs.requests()[before:]
+			if len(reqs) != 2 {
+				t.Fatalf("server got %d requests, want 2", len(reqs))
+			}
+			for _, req := range reqs {
+				for name, want := range tt.want {
+					if got := req.Get(name); got != want {
+						t.Errorf("%s = %q, want %q", name, got, want)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestRemoteFallback(t *testing.T) {
+	hash := testRecords(t)[0].TLSHHash
+	down := newRemoteServer(t, failing(http.StatusServiceUnavailable))
+	refusing := newRemoteServer(t, failing(http.StatusUnauthorized))
+	closed := newRemoteServer(t, nil)
+	closed.Close()
+
+	t.Run("unavailable", func(t *testing.T) {
+		for name, url := range map[string]string{"503": down.URL, "closed": closed.URL} {
+			out, stderr, st, err := runCLI(t, "-c", "--json", "--remote", url, "--remote-fallback", "local", "--db", writeTestDatabase(t), hash)
+			if err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+			var results []checkResult
+			if err := json.Unmarshal([]byte(out), &results); err != nil {
+				t.Fatalf("%s: %v\n%s", name, err, out)
+			}
+			if st != statusMatch || len(results) != 1 || results[0].Match == nil || results[0].Match.FileName != "known.exe" {
+				t.Errorf("%s: status %v, output:\n%s\nwant known.exe from the local database", name, st, out)
+			}
+			if !strings.Contains(stderr, "is unavailable, checking against the local database instead") {
+				t.Errorf("%s: stderr = %q, want the fallback warned about", name, stderr)
+			}
+		}
+	})
+
+	t.Run("scan falls back once", func(t *testing.T) {
+		before := len(down.requests())
+		dir := t.TempDir()
+		for _, name := range []string{"a.exe", "b.exe", "c.exe"} {
+			writeFile(t, dir, name, testSample)
+		}
+		out, stderr, _, err := runCLI(t, "-s", "--jsonl", "--workers", "1", "--max-distance", "30", "--remote", down.URL, "--remote-fallback", "local", "--db", writeTestDatabase(t), dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, summary := parseJSONL(t, out); summary.Matched != 3 {
+			t.Errorf("summary %+v, want all three files matched locally", summary)
+		}
+		if n := strings.Count(stderr, "is unavailable"); n != 1 {
+			t.Errorf("warned %d times, want once:\n%s", n, stderr)
+		}
+		if n := len(down.requests()) - before; n != 1 {
+			t.Errorf("server got %d requests, want 1 before it was given up on", n)
+		}
+	})
+
+	t.Run("no fallback", func(t *testing.T) {
+		_, _, _, err := runCLI(t, "-c", "--json", "--remote", down.URL, "--db", writeTestDatabase(t), hash)
+		if err == nil || !strings.Contains(err.Error(), "503") {
+			t.Errorf("error = %v, want the 503 reported", err)
+		}
+	})
+
+	t.Run("refused", func(t *testing.T) {
+		_, stderr, _, err := runCLI(t, "-c", "--json", "--remote", refusing.URL, "--remote-fallback", "local", "--db", writeTestDatabase(t), hash)
+		if err == nil || !strings.Contains(err.Error(), "401") {
+			t.Errorf("error = %v, want the 401 reported", err)
+		}
+		if strings.Contains(stderr, "is unavailable") {
+			t.Errorf("fell back on a refused request: %s", stderr)
+		}
+	})
+
+	t.Run("no local database", func(t *testing.T) {
+		_, _, _, err := runCLI(t, "-c", "--json", "--remote", down.URL, "--remote-fallback", "local", "--db", missingDatabase(t), hash)
+		if err == nil || !strings.Contains(err.Error(), "503") || !strings.Contains(err.Error(), "missing.csv") {
+			t.Errorf("error = %v, want both the server and database failures", err)
+		}
+	})
+}
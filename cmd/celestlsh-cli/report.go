@@ -0,0 +1,294 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Magonia-Research/CelesTLSH-CLI/pkg/celestlsh"
+)
+
+// reportListLimit is how many skipped files, and how many errors, an HTML
+// report lists; the rest are only counted, so that a scan skipping most of
+// a large tree does not write a report too big to open.
+const reportListLimit = 1000
+
+// htmlReport collects the results of a scan for --report, a self-contained
+// HTML page written when the scan ends, however it ends. Results and
+// skipped files arrive from the printer and from workers at once.
+type htmlReport struct {
+	path  string
+	start time.Time
+
+	mu          sync.Mutex
+	matches     []scanResult
+	errors      []reportEntry
+	skipped     []reportEntry
+	moreErrors  int
+	moreSkipped int
+}
+
+// reportEntry is a file listed in a report with why it was not checked.
+type reportEntry struct {
+	Path   string
+	Reason string
+}
+
+// newHTMLReport returns the report for --report, or nil if it is not set.
+func newHTMLReport(config Config, start time.Time) *htmlReport {
+	if config.Report == "" {
+		return nil
+	}
+	return &htmlReport{path: config.Report, start: start}
+}
+
+// add records a result printed by the scan.
+func (r *htmlReport) add(result scanResult) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	switch {
+	case result.Error != "":
+		if len(r.errors) < reportListLimit {
+			r.errors = append(r.errors, reportEntry{displayPath(result.Path), result.Error})
+		} else {
+			r.moreErrors++
+		}
+	case result.Match != nil && !result.Suppressed:
+		r.matches = append(r.matches, result)
+	}
+}
+
+// skip records a file or directory the scan skipped.
+func (r *htmlReport) skip(path, reason string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.skipped) < reportListLimit {
+		r.skipped = append(r.skipped, reportEntry{displayPath(path), reason})
+	} else {
+		r.moreSkipped++
+	}
+}
+
+// reportMatch is a row of the table of matches.
+type reportMatch struct {
+	Path        string
+	Distance    int
+	Confidence  string
+	Repo        string
+	File        string
+	Version     string
+	Intel       string
+	SHA256      string
+	Quarantined string
+}
+
+// reportData is what the report template renders.
+type reportData struct {
+	Host        string
+	Version     string
+	Started     string
+	Finished    string
+	Duration    time.Duration
+	Paths       []string
+	Database    string
+	Records     int
+	MaxDistance int
+	Partial     bool
+	Summary     jsonlSummary
+	Skipped     []string
+	Matches     []reportMatch
+	ShowSHA256  bool
+	Errors      []reportEntry
+	MoreErrors  int
+	SkippedList []reportEntry
+	MoreSkipped int
+}
+
+// write renders the report of a scan that ended with summary, checked
+// against a database of records, or -1 if they are unknown, marking it
+// partial if the scan was interrupted or stopped by --fail-fast. The page
+// is rendered in full before anything is written.
+func (r *htmlReport) write(config Config, summary jsonlSummary, records int) error {
+	if r == nil {
+		return nil
+	}
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	finished := time.Now()
+	database := config.DbPath
+	switch {
+	case remoteName(config) != "":
+		database = remoteName(config)
+	case useEmbedded(config):
+		database = "embedded snapshot"
+	case database == stdinDatabase:
+		database = "stdin"
+	}
+
+	r.mu.Lock()
+	data := reportData{
+		Host:        host,
+		Version:     version,
+		Started:     r.start.Format(time.RFC1123),
+		Finished:    finished.Format(time.RFC1123),
+		Duration:    finished.Sub(r.start).Round(time.Second),
+		Database:    database,
+		Records:     records,
+		MaxDistance: config.MaxDistance,
+		Partial:     summary.Interrupted || summary.StoppedEarly,
+		Summary:     summary,
+		Skipped:     summary.skipped(),
+		ShowSHA256:  config.Digests&celestlsh.DigestSHA256 != 0,
+		Errors:      r.errors,
+		MoreErrors:  r.moreErrors,
+		SkippedList: r.skipped,
+		MoreSkipped: r.moreSkipped,
+	}
+	for _, p := range config.Paths {
+		data.Paths = append(data.Paths, displayPath(p))
+	}
+	for _, m := range r.matches {
+		data.Matches = append(data.Matches, reportMatch{
+			Path: displayPath(m.Path), Distance: m.Match.Distance, Confidence: m.Confidence,
+			Repo: m.Match.RepoName, File: m.Match.FileName, Version: m.Match.Version, Intel: m.Match.Intel,
+			SHA256: m.SHA256, Quarantined: displayPath(m.Quarantined),
+		})
+	}
+	r.mu.Unlock()
+
+	var b bytes.Buffer
+	if err := reportTemplate.Execute(&b, data); err != nil {
+		return fmt.Errorf("failed to render report: %w", err)
+	}
+	if err := os.WriteFile(r.path, b.Bytes(), 0644); err != nil {
+		return fmt.Errorf("failed to write report: %w", err)
+	}
+	return nil
+}
+
+// reportTemplate is the HTML report. Everything taken from the scan, file
+// names above all, is escaped by html/template, and the page loads nothing
+// from elsewhere: its style and the script sorting the table of matches
+// are inline.
+var reportTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"join": strings.Join,
+	"add":  func(a, b int) int { return a + b },
+}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>CelesTLSH scan report{{if .Partial}} (partial){{end}} - {{.Host}}</title>
+<style>
+body { font-family: -apple-system, "Segoe UI", Helvetica, Arial, sans-serif; margin: 2em; color: #222; }
+h1 { font-size: 1.5em; margin-bottom: 0.2em; }
+h2 { font-size: 1.2em; margin-top: 1.6em; }
+.partial { background: #fff3cd; border: 1px solid #e0b400; padding: 0.6em 1em; margin: 1em 0; }
+dl { display: grid; grid-template-columns: max-content auto; gap: 0.3em 1.5em; }
+dt { font-weight: 600; }
+dd { margin: 0; }
+table { border-collapse: collapse; width: 100%; font-size: 0.9em; }
+th, td { border: 1px solid #ddd; padding: 0.35em 0.6em; text-align: left; vertical-align: top; }
+th { background: #f4f4f4; }
+table.sortable th { cursor: pointer; user-select: none; }
+table.sortable th::after { content: " \2195"; color: #999; }
+td.path, td.hash { font-family: ui-monospace, Menlo, Consolas, monospace; word-break: break-all; }
+tr.exact td.sev { background: #842029; color: #fff; font-weight: 600; }
+tr.high td.sev { background: #f8d7da; color: #842029; font-weight: 600; }
+tr.medium td.sev { background: #ffe5d0; color: #8a4b08; font-weight: 600; }
+tr.low td.sev { background: #fff3cd; color: #664d03; }
+tr.none td.sev { background: #eee; color: #555; }
+details { margin-top: 1em; }
+summary { cursor: pointer; font-weight: 600; }
+.muted { color: #666; }
+</style>
+</head>
+<body>
+<h1>CelesTLSH scan report</h1>
+<p class="muted">{{.Host}} &middot; celestlsh-cli {{.Version}}</p>
+{{if .Partial}}<div class="partial"><strong>Partial report:</strong> {{if .Summary.StoppedEarly}}the scan stopped at the first match (--fail-fast){{else}}the scan was interrupted{{end}}, so some files were not scanned.</div>{{end}}
+
+<h2>Summary</h2>
+<dl>
+<dt>Host</dt><dd>{{.Host}}</dd>
+<dt>Started</dt><dd>{{.Started}}</dd>
+<dt>Finished</dt><dd>{{.Finished}} ({{.Duration}})</dd>
+<dt>Scanned paths</dt><dd>{{join .Paths ", "}}</dd>
+<dt>Database</dt><dd>{{.Database}} ({{if ge .Records 0}}{{.Records}} records, {{end}}max distance {{.MaxDistance}})</dd>
+<dt>Files scanned</dt><dd>{{.Summary.Files}}{{if .Summary.Pending}} ({{.Summary.Pending}} pending){{end}}</dd>
+<dt>Matches</dt><dd>{{.Summary.Matched}}</dd>
+{{if .Summary.Suppressed}}<dt>Suppressed</dt><dd>{{.Summary.Suppressed}} allowlisted</dd>{{end}}
+{{if .Summary.Quarantined}}<dt>Quarantined</dt><dd>{{.Summary.Quarantined}}</dd>{{end}}
+<dt>Errors</dt><dd>{{.Summary.Failed}}{{if .Summary.TimedOut}} ({{.Summary.TimedOut}} timed out){{end}}</dd>
+{{if .Skipped}}<dt>Skipped</dt><dd>{{join .Skipped ", "}}</dd>{{end}}
+</dl>
+
+<h2>Matches</h2>
+{{if .Matches}}
+<table id="matches" class="sortable">
+<thead><tr><th>File</th><th data-type="number">Distance</th><th>Confidence</th><th>Tool</th><th>Matched file</th><th>Version</th><th>Intel</th>{{if .ShowSHA256}}<th>SHA256</th>{{end}}</tr></thead>
+<tbody>
+{{range .Matches}}<tr class="{{.Confidence}}"><td class="path">{{.Path}}{{if .Quarantined}}<br><span class="muted">quarantined to {{.Quarantined}}</span>{{end}}</td><td data-sort="{{.Distance}}">{{.Distance}}</td><td class="sev">{{.Confidence}}</td><td>{{.Repo}}</td><td>{{.File}}</td><td>{{.Version}}</td><td>{{.Intel}}</td>{{if $.ShowSHA256}}<td class="hash">{{.SHA256}}</td>{{end}}</tr>
+{{end}}</tbody>
+</table>
+{{else}}
+<p>No file matched the database.</p>
+{{end}}
+
+<details>
+<summary>Errors ({{len .Errors | add .MoreErrors}})</summary>
+{{if .Errors}}<table>
+<thead><tr><th>File</th><th>Error</th></tr></thead>
+<tbody>
+{{range .Errors}}<tr><td class="path">{{.Path}}</td><td>{{.Reason}}</td></tr>
+{{end}}</tbody>
+</table>
+{{if .MoreErrors}}<p class="muted">{{.MoreErrors}} more not listed.</p>{{end}}
+{{else}}<p>None.</p>{{end}}
+</details>
+
+<details>
+<summary>Skipped ({{len .SkippedList | add .MoreSkipped}})</summary>
+{{if .SkippedList}}<table>
+<thead><tr><th>Path</th><th>Reason</th></tr></thead>
+<tbody>
+{{range .SkippedList}}<tr><td class="path">{{.Path}}</td><td>{{.Reason}}</td></tr>
+{{end}}</tbody>
+</table>
+{{if .MoreSkipped}}<p class="muted">{{.MoreSkipped}} more not listed.</p>{{end}}
+{{else}}<p>None.</p>{{end}}
+</details>
+
+<script>
+document.querySelectorAll("table.sortable").forEach(function (table) {
+  table.querySelectorAll("th").forEach(function (th, col) {
+    var ascending = true;
+    th.addEventListener("click", function () {
+      var numeric = th.dataset.type === "number";
+      var body = table.tBodies[0];
+      var rows = Array.prototype.slice.call(body.rows);
+      rows.sort(function (a, b) {
+        var x = a.cells[col], y = b.cells[col];
+        var c = numeric ? Number(x.dataset.sort) - Number(y.dataset.sort) : x.textContent.localeCompare(y.textContent);
+        return ascending ? c : -c;
+      });
+      ascending = !ascending;
+      rows.forEach(function (row) { body.appendChild(row); });
+    });
+  });
+});
+</script>
+</body>
+</html>
+`))
@@ -0,0 +1,405 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// resultsMigrations create and update the schema of a results database, one
+// step per version; PRAGMA user_version records how many have been applied.
+// Steps are only ever appended, so that older files can be brought forward.
+var resultsMigrations = []string{
+	`CREATE TABLE scans (
+		id          INTEGER PRIMARY KEY,
+		host        TEXT NOT NULL,
+		mode        TEXT NOT NULL,
+		roots       TEXT NOT NULL,
+		started_at  TEXT NOT NULL,
+		finished_at TEXT,
+		files       INTEGER,
+		matched     INTEGER,
+		failed      INTEGER,
+		interrupted INTEGER NOT NULL DEFAULT 0
+	);
+	CREATE TABLE results (
+		id            INTEGER PRIMARY KEY,
+		scan_id       INTEGER NOT NULL REFERENCES scans(id),
+		scanned_at    TEXT NOT NULL,
+		path          TEXT NOT NULL,
+		size          INTEGER,
+		sha256        TEXT,
+		tlsh          TEXT,
+		error         TEXT,
+		match_repo    TEXT,
+		match_file    TEXT,
+		match_version TEXT,
+		match_tlsh    TEXT,
+		match_sha256  TEXT,
+		distance      INTEGER,
+		suppressed    INTEGER NOT NULL DEFAULT 0
+	);
+	CREATE INDEX results_scan ON results(scan_id);
+	CREATE INDEX results_path ON results(path);`,
+}
+
+// resultsBatch is how many results a scan writes in each transaction. A
+// run holds the database's write lock while its transaction is open, so
+// that other runs recording to it wait at most for one batch.
+const resultsBatch = 500
+
+// resultsStore appends the results of scan and watch runs to a SQLite
+// database, one scans row per run, for the results subcommand to query.
+type resultsStore struct {
+	db     *sql.DB
+	scanID int64
+
+	// batch is set for scans, which write their results in transactions
+	// of resultsBatch; watch mode commits each result as it arrives.
+	mu      sync.Mutex
+	batch   bool
+	tx      *sql.Tx
+	pending int
+}
+
+// resultsInsert records a result.
+const resultsInsert = `INSERT INTO results (scan_id, scanned_at, path, size, sha256, tlsh, error,
+	match_repo, match_file, match_version, match_tlsh, match_sha256, distance, suppressed)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+// openResultsDB opens, creating or migrating as needed, the results database
+// at path.
+func openResultsDB(ctx context.Context, path string) (*sql.DB, error) {
+	db, err := openSQLite(ctx, path, resultsMigrations)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open results database %s: %v", path, err)
+	}
+	return db, nil
+}
+
+// newResultsStore returns the store for --results-db, or nil if it is not
+// set, with a scans row started for this run of mode over roots. Batch runs
+// write their results in transactions of resultsBatch.
+func newResultsStore(ctx context.Context, config Config, roots []string, batch bool) (*resultsStore, error) {
+	if config.ResultsDB == "" {
+		return nil, nil
+	}
+	db, err := openResultsDB(ctx, config.ResultsDB)
+	if err != nil {
+		return nil, err
+	}
+
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	abs := make([]string, len(roots))
+	for i, root := range roots {
+		abs[i] = absPath(root)
+	}
+
+	res, err := db.ExecContext(ctx, "INSERT INTO scans (host, mode, roots, started_at) VALUES (?, ?, ?, ?)",
+		host, config.Mode, strings.Join(abs, "\n"), timestamp(time.Now()))
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to record scan in results database: %w", err)
+	}
+	s := &resultsStore{db: db, batch: batch}
+	if s.scanID, err = res.LastInsertId(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to record scan in results database: %w", err)
+	}
+	return s, nil
+}
+
+// add records result, warning rather than failing the scan if it cannot.
+func (s *resultsStore) add(result scanResult) {
+	var repo, file, version, tlsh, sha256 sql.NullString
+	var distance sql.NullInt64
+	if m := result.Match; m != nil && result.Error == "" {
+		repo = sql.NullString{String: m.RepoName, Valid: true}
+		file = sql.NullString{String: m.FileName, Valid: true}
+		version = sql.NullString{String: m.Version, Valid: true}
+		tlsh = sql.NullString{String: m.TLSHHash, Valid: true}
+		sha256 = nullString(m.SHA256Hash)
+		distance = sql.NullInt64{Int64: int64(m.Distance), Valid: true}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	err := s.exec(resultsInsert, s.scanID, timestamp(time.Now()), absPath(result.Path),
+		sql.NullInt64{Int64: result.size, Valid: result.Error == ""},
+		nullString(result.SHA256), nullString(result.TLSH), nullString(result.Error),
+		repo, file, version, tlsh, sha256, distance, result.Suppressed)
+	if err == nil && s.pending >= resultsBatch {
+		err = s.commit()
+	}
+	if err != nil {
+		clearProgress()
+		fmt.Fprintf(os.Stderr, "Warning: failed to record %s in the results database: %v\n", result.Path, err)
+	}
+}
+
+// exec runs query in the open batch, starting one if there is none, or on
+// its own outside batch runs.
+func (s *resultsStore) exec(query string, args ...any) error {
+	if !s.batch {
+		_, err := s.db.Exec(query, args...)
+		return err
+	}
+	if s.tx == nil {
+		tx, err := s.db.Begin()
+		if err != nil {
+			return err
+		}
+		s.tx = tx
+	}
+	s.pending++
+	_, err := s.tx.Exec(query, args...)
+	return err
+}
+
+// commit ends the open batch, if there is one.
+func (s *resultsStore) commit() error {
+	if s.tx == nil {
+		return nil
+	}
+	err := s.tx.Commit()
+	s.tx, s.pending = nil, 0
+	return err
+}
+
+// finish records the end of the run, with its totals if summary is set,
+// and commits its results.
+func (s *resultsStore) finish(summary *jsonlSummary) error {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	defer s.close()
+
+	var err error
+	if summary == nil {
+		err = s.exec("UPDATE scans SET finished_at = ? WHERE id = ?", timestamp(time.Now()), s.scanID)
+	} else {
+		err = s.exec("UPDATE scans SET finished_at = ?, files = ?, matched = ?, failed = ?, interrupted = ? WHERE id = ?",
+			timestamp(time.Now()), summary.Files, summary.Matched, summary.Failed, summary.Interrupted, s.scanID)
+	}
+	if err == nil {
+		err = s.commit()
+	}
+	if err != nil {
+		return fmt.Errorf("failed to save results database: %w", err)
+	}
+	return nil
+}
+
+func (s *resultsStore) close() {
+	if s.tx != nil {
+		s.tx.Rollback()
+	}
+	s.db.Close()
+}
+
+// absPath returns path made absolute, so that the same file scanned from
+// different working directories is recorded once; the archive member part
+// of a path is kept as is.
+func absPath(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return path
+}
+
+func timestamp(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
+
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+// executeResults answers the results subcommand's queries from --results-db:
+//
+//	last          the totals and matches of the latest run
+//	path <path>   every result recorded for a path, oldest first
+//	new <date>    matches first seen on or after a date
+func executeResults(ctx context.Context, config Config) error {
+	db, err := openResultsDB(ctx, config.ResultsDB)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	switch config.ResultsQuery {
+	case "last":
+		err = printLastScan(ctx, db, tw)
+	case "path":
+		err = printPathHistory(ctx, db, tw, absPath(config.ResultsArg))
+	case "new":
+		err = printNewMatches(ctx, db, tw, config.ResultsArg)
+	default:
+		return fmt.Errorf("unknown results query %q", config.ResultsQuery)
+	}
+	if err != nil {
+		return err
+	}
+	return tw.Flush()
+}
+
+func printLastScan(ctx context.Context, db *sql.DB, tw *tabwriter.Writer) error {
+	var (
+		id                     int64
+		host, mode, roots      string
+		started                string
+		finished               sql.NullString
+		files, matched, failed sql.NullInt64
+		interrupted            bool
+	)
+	err := db.QueryRowContext(ctx, `SELECT id, host, mode, roots, started_at, finished_at, files, matched, failed, interrupted
+		FROM scans ORDER BY id DESC LIMIT 1`).Scan(&id, &host, &mode, &roots, &started, &finished, &files, &matched, &failed, &interrupted)
+	if errors.Is(err, sql.ErrNoRows) {
+		return errors.New("no scans recorded")
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(tw, "Host:\t%s\n", host)
+	fmt.Fprintf(tw, "Mode:\t%s\n", mode)
+	fmt.Fprintf(tw, "Paths:\t%s\n", strings.ReplaceAll(roots, "\n", ", "))
+	fmt.Fprintf(tw, "Started:\t%s\n", started)
+	switch {
+	case !finished.Valid:
+		fmt.Fprintf(tw, "Finished:\tnot finished\n")
+	case interrupted:
+		fmt.Fprintf(tw, "Finished:\t%s (interrupted)\n", finished.String)
+	default:
+		fmt.Fprintf(tw, "Finished:\t%s\n", finished.String)
+	}
+	if files.Valid {
+		fmt.Fprintf(tw, "Files scanned:\t%d\n", files.Int64)
+		fmt.Fprintf(tw, "Matched:\t%d\n", matched.Int64)
+		fmt.Fprintf(tw, "Errors:\t%d\n", failed.Int64)
+	}
+
+	rows, err := db.QueryContext(ctx, `SELECT match_repo, COUNT(*) FROM results
+		WHERE scan_id = ? AND match_repo IS NOT NULL AND suppressed = 0
+		GROUP BY match_repo ORDER BY COUNT(*) DESC, match_repo`, id)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var repo string
+		var n int64
+		if err := rows.Scan(&repo, &n); err != nil {
+			return err
+		}
+		fmt.Fprintf(tw, "  %s\t%d\n", repo, n)
+	}
+	return rows.Err()
+}
+
+func printPathHistory(ctx context.Context, db *sql.DB, tw *tabwriter.Writer, path string) error {
+	rows, err := db.QueryContext(ctx, `SELECT r.scanned_at, s.host, r.tlsh, r.error, r.match_repo, r.match_file, r.distance, r.suppressed
+		FROM results r JOIN scans s ON s.id = r.scan_id
+		WHERE r.path = ? ORDER BY r.scanned_at, r.id`, path)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	fmt.Fprintln(tw, "SCANNED\tHOST\tTLSH\tMATCH\tDISTANCE")
+	found := false
+	for rows.Next() {
+		var (
+			scanned, host         string
+			tlsh, failure         sql.NullString
+			repo, file            sql.NullString
+			distance              sql.NullInt64
+			suppressed            bool
+			match, distanceColumn string
+		)
+		if err := rows.Scan(&scanned, &host, &tlsh, &failure, &repo, &file, &distance, &suppressed); err != nil {
+			return err
+		}
+		found = true
+		switch {
+		case failure.Valid:
+			match = "error: " + failure.String
+		case repo.Valid:
+			match = repo.String + " " + file.String
+			distanceColumn = fmt.Sprint(distance.Int64)
+			if suppressed {
+				match += " [suppressed]"
+			}
+		default:
+			match = "no match"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", scanned, host, tlsh.String, match, distanceColumn)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("no results recorded for %s", path)
+	}
+	return nil
+}
+
+// printNewMatches lists the matches recorded on or after since whose path
+// had not matched the same record on the same host before it.
+func printNewMatches(ctx context.Context, db *sql.DB, tw *tabwriter.Writer, since string) error {
+	t, err := parseDate(since)
+	if err != nil {
+		return err
+	}
+	cutoff := timestamp(t)
+
+	rows, err := db.QueryContext(ctx, `SELECT MIN(r.scanned_at), s.host, r.path, r.match_repo, r.match_file, MIN(r.distance)
+		FROM results r JOIN scans s ON s.id = r.scan_id
+		WHERE r.match_tlsh IS NOT NULL AND r.suppressed = 0 AND r.scanned_at >= ?
+		AND NOT EXISTS (
+			SELECT 1 FROM results p JOIN scans ps ON ps.id = p.scan_id
+			WHERE ps.host = s.host AND p.path = r.path AND p.match_tlsh = r.match_tlsh
+			AND p.suppressed = 0 AND p.scanned_at < ?)
+		GROUP BY s.host, r.path, r.match_tlsh, r.match_repo, r.match_file
+		ORDER BY 1, 3`, cutoff, cutoff)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	fmt.Fprintln(tw, "FIRST SEEN\tHOST\tPATH\tMATCH\tDISTANCE")
+	for rows.Next() {
+		var first, host, path, repo, file string
+		var distance int64
+		if err := rows.Scan(&first, &host, &path, &repo, &file, &distance); err != nil {
+			return err
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s %s\t%d\n", first, host, path, repo, file, distance)
+	}
+	return rows.Err()
+}
+
+// parseDate accepts a date, taken as midnight UTC, or an RFC 3339 time.
+func parseDate(s string) (time.Time, error) {
+	if t, err := time.Parse(time.DateOnly, s); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid date %q: use YYYY-MM-DD or an RFC 3339 time", s)
+	}
+	return t, nil
+}
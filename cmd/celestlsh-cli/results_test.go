@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/Magonia-Research/CelesTLSH-CLI/pkg/celestlsh"
+)
+
+func TestResultsHistory(t *testing.T) {
+	dir := t.TempDir()
+	known := filepath.Join(dir, "known.exe")
+	writeFile(t, dir, "other.exe", sampleData(2, 8192))
+	history := filepath.Join(t.TempDir(), "history.db")
+	db := writeTestDatabase(t)
+
+	// The first scan finds other.exe; by the second, known.exe has
+	// appeared.
+	for i := range 2 {
+		if i == 1 {
+			writeFile(t, dir, "known.exe", testSample)
+		}
+		if _, _, _, err := runCLI(t, "-s", "--quiet", "--max-distance", "30", "--results-db", history, "--db", db, dir); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	out, _, _, err := runCLI(t, "results", "--results-db", history, "last")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{`Mode:\s+scan\n`, `Paths:\s+` + regexp.QuoteMeta(dir) + `\n`, `Files scanned:\s+2\n`, `Matched:\s+2\n`, `  KnownTool\s+1\n`, `  OtherTool\s+1\n`} {
+		if !regexp.MustCompile(want).MatchString(out) {
+			t.Errorf("last lacks %s:\n%s", want, out)
+		}
+	}
+
+	out, _, _, err = runCLI(t, "results", "--results-db", history, "path", known)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Split(strings.TrimSpace(out), "\n"); len(lines) != 2 || !strings.Contains(lines[1], "KnownTool known.exe") {
+		t.Errorf("path history:\n%s\nwant the one scan of known.exe", out)
+	}
+	if _, _, _, err := runCLI(t, "results", "--results-db", history, "path", filepath.Join(dir, "missing")); err == nil {
+		t.Error("history of a path never scanned: no error")
+	}
+
+	// The tables are there for other tools to query.
+	sdb, err := openResultsDB(context.Background(), history)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sdb.Close()
+	var scans, results, version int
+	if err := sdb.QueryRow("SELECT (SELECT COUNT(*) FROM scans), (SELECT COUNT(*) FROM results)").Scan(&scans, &results); err != nil {
+		t.Fatal(err)
+	}
+	if err := sdb.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		t.Fatal(err)
+	}
+	if scans != 2 || results != 3 || version != len(resultsMigrations) {
+		t.Errorf("%d scans, %d results at version %d; want 2, 3 and %d", scans, results, version, len(resultsMigrations))
+	}
+}
+
+func TestResultsNewMatches(t *testing.T) {
+	history := filepath.Join(t.TempDir(), "history.db")
+	db, err := openResultsDB(context.Background(), history)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, stmt := range []string{
+		`INSERT INTO scans (id, host, mode, roots, started_at, finished_at, files, matched, failed) VALUES
+			(1, 'web1', 'scan', '/srv', '2026-09-01T00:00:00Z', '2026-09-01T00:00:02Z', 1, 1, 0),
+			(2, 'web1', 'scan', '/srv', '2026-10-02T00:00:00Z', NULL, NULL, NULL, NULL),
+			(3, 'web2', 'watch', '/srv', '2026-10-03T00:00:00Z', NULL, NULL, NULL, NULL)`,
+		`INSERT INTO results (scan_id, scanned_at, path, match_repo, match_file, match_tlsh, distance, suppressed) VALUES
+			(1, '2026-09-01T00:00:01Z', '/srv/old', 'KnownTool', 'known.exe', 'T1A', 20, 0),
+			-- Seen before the date: not new.
+			(2, '2026-10-02T00:00:01Z', '/srv/old', 'KnownTool', 'known.exe', 'T1A', 18, 0),
+			(2, '2026-10-02T00:00:02Z', '/srv/new', 'OtherTool', 'other.exe', 'T1B', 25, 0),
+			(2, '2026-10-02T00:00:03Z', '/srv/allowed', 'OtherTool', 'other.exe', 'T1B', 5, 1),
+			-- The same path on another host is new there.
+			(3, '2026-10-03T00:00:01Z', '/srv/old', 'KnownTool', 'known.exe', 'T1A', 30, 0)`,
+	} {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	db.Close()
+
+	out, _, _, err := runCLI(t, "results", "--results-db", history, "new", "2026-10-01")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	if len(lines) != 3 || !strings.Contains(lines[1], "web1  /srv/new  OtherTool other.exe  25") || !strings.Contains(lines[2], "web2  /srv/old  KnownTool known.exe  30") {
+		t.Errorf("new matches:\n%s\nwant /srv/new on web1 and /srv/old on web2", out)
+	}
+
+	out, _, _, err = runCLI(t, "results", "--results-db", history, "last")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !regexp.MustCompile(`Mode:\s+watch\n`).MatchString(out) || !strings.Contains(out, "not finished") {
+		t.Errorf("last:\n%s\nwant the unfinished watch on web2", out)
+	}
+}
+
+func TestResultsMigrations(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "history.db")
+	v1 := []string{`CREATE TABLE scans (id INTEGER PRIMARY KEY, host TEXT NOT NULL)`}
+	v2 := append(v1, `ALTER TABLE scans ADD COLUMN mode TEXT NOT NULL DEFAULT 'scan'`)
+
+	db, err := openSQLite(ctx, path, v1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`INSERT INTO scans (host) VALUES ('web1')`); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	// A later version brings the file forward, keeping what it holds.
+	db, err = openSQLite(ctx, path, v2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var host, mode string
+	var version int
+	if err := db.QueryRow(`SELECT host, mode FROM scans`).Scan(&host, &mode); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.QueryRow(`PRAGMA user_version`).Scan(&version); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+	if host != "web1" || mode != "scan" || version != 2 {
+		t.Errorf("after migrating: host %q, mode %q, version %d; want web1, scan and 2", host, mode, version)
+	}
+
+	// An earlier version refuses the file rather than changing it.
+	if _, err := openSQLite(ctx, path, v1); err == nil || !strings.Contains(err.Error(), "schema version 2 is newer") {
+		t.Errorf("opening with fewer migrations: %v, want the file refused", err)
+	}
+	// So does a scan, whose --results-db is at version 2 where the tool
+	// knows one migration.
+	_, _, _, err = runCLI(t, "-s", "--results-db", path, "--db", writeTestDatabase(t), t.TempDir())
+	if err == nil || !strings.Contains(err.Error(), "newer than this version") {
+		t.Errorf("scan error = %v, want the newer file refused", err)
+	}
+
+	other := writeFile(t, t.TempDir(), "notes.txt", []byte(strings.Repeat("notes\n", 200)))
+	_, _, _, err = runCLI(t, "results", "--results-db", other, "last")
+	if err == nil || !strings.Contains(err.Error(), "not a database") {
+		t.Errorf("error = %v, want the file refused", err)
+	}
+}
+
+func TestResultsConcurrentRuns(t *testing.T) {
+	ctx := context.Background()
+	config := Config{Mode: "scan", ResultsDB: filepath.Join(t.TempDir(), "history.db")}
+	match := &celestlsh.HashRecord{RepoName: "KnownTool", FileName: "known.exe", TLSHHash: "T1A"}
+
+	// A scan writing more than a batch and a watch writing each result
+	// record to the same file at once, neither losing any.
+	const scanned, watched = 3*resultsBatch + 7, 40
+	var wg sync.WaitGroup
+	for _, run := range []struct {
+		batch bool
+		n     int
+	}{{true, scanned}, {false, watched}} {
+		store, err := newResultsStore(ctx, config, []string{"/srv"}, run.batch)
+		if err != nil {
+			t.Fatal(err)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range run.n {
+				store.add(scanResult{Path: fmt.Sprintf("/srv/%v-%d", run.batch, i), Match: match})
+			}
+			if err := store.finish(&jsonlSummary{Files: int64(run.n)}); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	db, err := openResultsDB(ctx, config.ResultsDB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	rows, err := db.Query(`SELECT s.files, COUNT(r.id) FROM scans s LEFT JOIN results r ON r.scan_id = s.id GROUP BY s.id ORDER BY 1`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var got []string
+	for rows.Next() {
+		var files, n int
+		if err := rows.Scan(&files, &n); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, fmt.Sprintf("%d/%d", n, files))
+	}
+	if want := []string{fmt.Sprintf("%d/%d", watched, watched), fmt.Sprintf("%d/%d", scanned, scanned)}; strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("results recorded per run: %v, want %v", got, want)
+	}
+}
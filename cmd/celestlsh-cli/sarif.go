@@ -0,0 +1,225 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"path/filepath"
+	"strings"
+)
+
+// sarifSchema and sarifVersion identify the SARIF format written by
+// --format sarif.
+const (
+	sarifSchema  = "https://json.schemastore.org/sarif-2.1.0.json"
+	sarifVersion = "2.1.0"
+)
+
+// sarifLog is the subset of a SARIF 2.1.0 log that describes database
+// matches: one run, with a rule per matched repository and a result per
+// matched file. Files that could not be checked are reported as tool
+// execution notifications, so that they do not look like findings.
+type sarifLog struct {
+	Schema  string     `json:"$schema"`
+	Version string     `json:"version"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool        sarifTool         `json:"tool"`
+	Invocations []sarifInvocation `json:"invocations"`
+	Results     []sarifResult     `json:"results"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name           string      `json:"name"`
+	InformationURI string      `json:"informationUri"`
+	Rules          []sarifRule `json:"rules"`
+}
+
+type sarifRule struct {
+	ID               string         `json:"id"`
+	Name             string         `json:"name"`
+	ShortDescription sarifMessage   `json:"shortDescription"`
+	HelpURI          string         `json:"helpUri,omitempty"`
+	Properties       map[string]any `json:"properties,omitempty"`
+}
+
+type sarifInvocation struct {
+	ExecutionSuccessful        bool                `json:"executionSuccessful"`
+	ToolExecutionNotifications []sarifNotification `json:"toolExecutionNotifications,omitempty"`
+}
+
+type sarifNotification struct {
+	Level     string          `json:"level"`
+	Message   sarifMessage    `json:"message"`
+	Locations []sarifLocation `json:"locations,omitempty"`
+}
+
+type sarifResult struct {
+	RuleID     string          `json:"ruleId"`
+	RuleIndex  int             `json:"ruleIndex"`
+	Level      string          `json:"level"`
+	Message    sarifMessage    `json:"message"`
+	Locations  []sarifLocation `json:"locations,omitempty"`
+	Properties map[string]any  `json:"properties"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation sarifPhysicalLocation `json:"physicalLocation"`
+}
+
+type sarifPhysicalLocation struct {
+	ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+}
+
+type sarifArtifactLocation struct {
+	URI string `json:"uri"`
+}
+
+// sarifDocument builds a SARIF log from scan or check results.
+type sarifDocument struct {
+	rules   map[string]int
+	driver  sarifDriver
+	results []sarifResult
+	notes   []sarifNotification
+}
+
+func newSARIFDocument() *sarifDocument {
+	return &sarifDocument{
+		rules: make(map[string]int),
+		driver: sarifDriver{
+			Name:           "CelesTLSH",
+			InformationURI: "https://github.com/Magonia-Research/CelesTLSH-CLI",
+			Rules:          []sarifRule{},
+		},
+	}
+}
+
+func (d *sarifDocument) add(result scanResult) {
+	if result.Error != "" {
+		d.notes = append(d.notes, sarifNotification{
+			Level:     "error",
+			Message:   sarifMessage{Text: result.Error},
+			Locations: sarifLocations(result.Path),
+		})
+		return
+	}
+	if result.Match == nil {
+		return
+	}
+
+	m := result.Match
+	index := d.rule(m.RepoName, m.Intel)
+
+	subject := result.Path
+	if subject == "" {
+		subject = "TLSH " + result.TLSH
+	}
+
+	properties := map[string]any{
+		"tlsh":           result.TLSH,
+		"distance":       m.Distance,
+		"matchedFile":    m.FileName,
+		"matchedVersion": m.Version,
+		"matchedTlsh":    m.TLSHHash,
+		"matchedSha256":  m.SHA256Hash,
+	}
+	if result.SHA256 != "" {
+		properties["sha256"] = result.SHA256
+	}
+	if result.MatchType != "" {
+		properties["matchType"] = result.MatchType
+	}
+
+	d.results = append(d.results, sarifResult{
+		RuleID:     d.driver.Rules[index].ID,
+		RuleIndex:  index,
+		Level:      "error",
+		Message:    sarifMessage{Text: sarifMatchMessage(subject, result)},
+		Locations:  sarifLocations(result.Path),
+		Properties: properties,
+	})
+}
+
+// rule returns the index of the rule for repo, adding it on first use.
+func (d *sarifDocument) rule(repo, intel string) int {
+	if index, ok := d.rules[repo]; ok {
+		return index
+	}
+
+	index := len(d.driver.Rules)
+	d.rules[repo] = index
+	d.driver.Rules = append(d.driver.Rules, sarifRule{
+		ID:               "celestlsh/" + strings.Join(strings.Fields(repo), "-"),
+		Name:             repo,
+		ShortDescription: sarifMessage{Text: fmt.Sprintf("File similar to a known build of %s", repo)},
+		HelpURI:          intel,
+		Properties:       map[string]any{"tags": []string{"security"}},
+	})
+	return index
+}
+
+func (d *sarifDocument) write(w io.Writer) error {
+	results := d.results
+	if results == nil {
+		results = []sarifResult{}
+	}
+
+	log := sarifLog{
+		Schema:  sarifSchema,
+		Version: sarifVersion,
+		Runs: []sarifRun{{
+			Tool: sarifTool{Driver: d.driver},
+			Invocations: []sarifInvocation{{
+				ExecutionSuccessful:        true,
+				ToolExecutionNotifications: d.notes,
+			}},
+			Results: results,
+		}},
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(log)
+}
+
+// sarifLocations returns the location of a scanned path, or none for a
+// hash checked on its own. Relative paths stay relative, so that code
+// scanning can resolve them against the repository.
+func sarifLocations(path string) []sarifLocation {
+	if path == "" {
+		return nil
+	}
+
+	uri := (&url.URL{Path: filepath.ToSlash(filepath.Clean(path))}).EscapedPath()
+	if filepath.IsAbs(path) {
+		if !strings.HasPrefix(uri, "/") {
+			uri = "/" + uri
+		}
+		uri = "file://" + uri
+	}
+	return []sarifLocation{{PhysicalLocation: sarifPhysicalLocation{ArtifactLocation: sarifArtifactLocation{URI: uri}}}}
+}
+
+// sarifMatchMessage describes the match of result, found for subject.
+func sarifMatchMessage(subject string, result scanResult) string {
+	m := result.Match
+	if result.MatchType == matchExact {
+		return fmt.Sprintf("%s is identical to %s %s (version %s): its SHA256 is the record's", subject, m.RepoName, m.FileName, m.Version)
+	}
+	text := fmt.Sprintf("%s is similar to %s %s (version %s) at TLSH distance %d", subject, m.RepoName, m.FileName, m.Version, m.Distance)
+	if result.MatchType == matchSimilar {
+		text += ", by TLSH only"
+	}
+	return text
+}
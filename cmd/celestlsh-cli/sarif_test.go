@@ -0,0 +1,246 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// sarifObject lists, for an object type of the SARIF 2.1.0 schema, the
+// properties it requires and, as the schema forbids any others, all it
+// allows.
+type sarifObject struct {
+	required []string
+	allowed  []string
+	// children gives the object type of properties holding objects, or
+	// arrays of them.
+	children map[string]string
+}
+
+var sarifSchemaObjects = map[string]sarifObject{
+	"sarifLog": {
+		required: []string{"version", "runs"},
+		allowed:  []string{"$schema", "version", "runs", "inlineExternalProperties", "properties"},
+		children: map[string]string{"runs": "run"},
+	},
+	"run": {
+		required: []string{"tool"},
+		allowed: []string{"tool", "invocations", "conversion", "language", "versionControlProvenance", "originalUriBaseIds",
+			"artifacts", "logicalLocations", "graphs", "results", "automationDetails", "runAggregates", "baselineGuid",
+			"redactionTokens", "defaultEncoding", "defaultSourceLanguage", "newlineSequences", "columnKind",
+			"externalPropertyFileReferences", "threadFlowLocations", "taxonomies", "addresses", "translations",
+			"policies", "webRequests", "webResponses", "specialLocations", "properties"},
+		children: map[string]string{"tool": "tool", "invocations": "invocation", "results": "result"},
+	},
+	"tool": {
+		required: []string{"driver"},
+		allowed:  []string{"driver", "extensions", "properties"},
+		children: map[string]string{"driver": "toolComponent"},
+	},
+	"toolComponent": {
+		required: []string{"name"},
+		allowed: []string{"guid", "name", "organization", "product", "productSuite", "shortDescription", "fullDescription",
+			"fullName", "version", "semanticVersion", "dottedQuadFileVersion", "releaseDateUtc", "downloadUri",
+			"informationUri", "globalMessageStrings", "notifications", "rules", "taxa", "locations", "language",
+			"contents", "isComprehensive", "localizedDataSemanticVersion", "minimumRequiredLocalizedDataSemanticVersion",
+			"associatedComponent", "translationMetadata", "supportedTaxonomies", "properties"},
+		children: map[string]string{"rules": "reportingDescriptor"},
+	},
+	"reportingDescriptor": {
+		required: []string{"id"},
+		allowed: []string{"id", "deprecatedIds", "guid", "deprecatedGuids", "name", "deprecatedNames", "shortDescription",
+			"fullDescription", "messageStrings", "defaultConfiguration", "helpUri", "help", "relationships", "properties"},
+		children: map[string]string{"shortDescription": "multiformatMessageString", "properties": "propertyBag"},
+	},
+	"invocation": {
+		required: []string{"executionSuccessful"},
+		allowed: []string{"commandLine", "arguments", "responseFiles", "startTimeUtc", "endTimeUtc", "exitCode",
+			"ruleConfigurationOverrides", "notificationConfigurationOverrides", "toolExecutionNotifications",
+			"toolConfigurationNotifications", "exitCodeDescription", "exitSignalName", "exitSignalNumber",
+			"processStartFailureMessage", "executionSuccessful", "machine", "account", "processId", "executableLocation",
+			"workingDirectory", "environmentVariables", "stdin", "stdout", "stderr", "stdoutStderr", "properties"},
+		children: map[string]string{"toolExecutionNotifications": "notification"},
+	},
+	"notification": {
+		required: []string{"message"},
+		allowed:  []string{"locations", "message", "level", "threadId", "timeUtc", "exception", "descriptor", "associatedRule", "properties"},
+		children: map[string]string{"locations": "location", "message": "message"},
+	},
+	"result": {
+		required: []string{"message"},
+		allowed: []string{"ruleId", "ruleIndex", "rule", "kind", "level", "message", "analysisTarget", "locations", "guid",
+			"correlationGuid", "occurrenceCount", "partialFingerprints", "fingerprints", "stacks", "codeFlows", "graphs",
+			"graphTraversals", "relatedLocations", "suppressions", "baselineState", "rank", "attachments",
+			"hostedViewerUri", "workItemUris", "provenance", "fixes", "taxa", "webRequest", "webResponse", "properties"},
+		children: map[string]string{"locations": "location", "message": "message", "properties": "propertyBag"},
+	},
+	"message": {
+		allowed: []string{"text", "markdown", "id", "arguments", "properties"},
+	},
+	"multiformatMessageString": {
+		required: []string{"text"},
+		allowed:  []string{"text", "markdown", "properties"},
+	},
+	"location": {
+		allowed:  []string{"id", "physicalLocation", "logicalLocations", "message", "annotations", "relationships", "properties"},
+		children: map[string]string{"physicalLocation": "physicalLocation"},
+	},
+	"physicalLocation": {
+		allowed:  []string{"address", "artifactLocation", "region", "contextRegion", "properties"},
+		children: map[string]string{"artifactLocation": "artifactLocation"},
+	},
+	"artifactLocation": {
+		allowed: []string{"uri", "uriBaseId", "index", "description", "properties"},
+	},
+	"propertyBag": {},
+}
+
+// validateSARIF checks a decoded SARIF object of the given type, and the
+// objects in it, against sarifSchemaObjects and the enumerations and
+// formats of the schema.
+func validateSARIF(t *testing.T, path, kind string, v any) {
+	t.Helper()
+	obj, ok := v.(map[string]any)
+	if !ok {
+		t.Errorf("%s: %T, want a %s object", path, v, kind)
+		return
+	}
+	schema := sarifSchemaObjects[kind]
+	for _, name := range schema.required {
+		if _, ok := obj[name]; !ok {
+			t.Errorf("%s: %s lacks required property %s", path, kind, name)
+		}
+	}
+	for name, value := range obj {
+		if schema.allowed != nil && !slices.Contains(schema.allowed, name) {
+			t.Errorf("%s: %s has property %s, which the schema does not allow", path, kind, name)
+		}
+		child, ok := schema.children[name]
+		if !ok {
+			continue
+		}
+		if items, ok := value.([]any); ok {
+			for i, item := range items {
+				validateSARIF(t, fmt.Sprintf("%s.%s[%d]", path, name, i), child, item)
+			}
+		} else {
+			validateSARIF(t, path+"."+name, child, value)
+		}
+	}
+
+	switch kind {
+	case "sarifLog":
+		if obj["version"] != "2.1.0" {
+			t.Errorf("%s: version %v, want 2.1.0", path, obj["version"])
+		}
+	case "result", "notification":
+		if level, ok := obj["level"]; ok && !slices.Contains([]any{"none", "note", "warning", "error"}, level) {
+			t.Errorf("%s: level %v is not one the schema allows", path, level)
+		}
+	case "message":
+		if _, ok := obj["text"]; !ok {
+			if _, ok := obj["id"]; !ok {
+				t.Errorf("%s: message has neither text nor id", path)
+			}
+		}
+	case "artifactLocation":
+		uri, _ := obj["uri"].(string)
+		if _, err := url.Parse(uri); err != nil || uri == "" {
+			t.Errorf("%s: uri %q is not a URI reference", path, uri)
+		}
+	case "propertyBag":
+		if tags, ok := obj["tags"]; ok {
+			if _, ok := tags.([]any); !ok {
+				t.Errorf("%s: tags %v, want an array", path, tags)
+			}
+		}
+	}
+}
+
+// parseSARIF validates a SARIF log and returns its first run.
+func parseSARIF(t *testing.T, out string) map[string]any {
+	t.Helper()
+	var log map[string]any
+	if err := json.Unmarshal([]byte(out), &log); err != nil {
+		t.Fatalf("parsing SARIF %s: %v", out, err)
+	}
+	validateSARIF(t, "log", "sarifLog", log)
+	runs, _ := log["runs"].([]any)
+	if len(runs) != 1 {
+		t.Fatalf("%d runs, want 1", len(runs))
+	}
+	return runs[0].(map[string]any)
+}
+
+func TestScanSARIF(t *testing.T) {
+	dir := t.TempDir()
+	known := writeFile(t, dir, "bin/known #1.exe", testSample)
+	writeFile(t, dir, "bin/variant.exe", variantData(testSample, 40))
+	writeFile(t, dir, "docs/notes.bin", sampleData(5, 8192))
+	broken := makeTar(t, archiveEntry{name: "x.exe", data: testSample})
+	writeFile(t, dir, "broken.tar", broken[:len(broken)/2])
+
+	out, _, _, err := runCLI(t, "-s", "--format", "sarif", "--max-distance", "60", "--db", writeTestDatabase(t), dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	run := parseSARIF(t, out)
+
+	rules := run["tool"].(map[string]any)["driver"].(map[string]any)["rules"].([]any)
+	if len(rules) != 1 || rules[0].(map[string]any)["id"] != "celestlsh/KnownTool" {
+		t.Errorf("rules = %v, want one for KnownTool", rules)
+	}
+
+	results := run["results"].([]any)
+	if len(results) != 2 {
+		t.Fatalf("%d results, want known.exe and its variant", len(results))
+	}
+	var uris []string
+	for _, r := range results {
+		r := r.(map[string]any)
+		if r["ruleId"] != "celestlsh/KnownTool" || r["ruleIndex"] != float64(0) {
+			t.Errorf("result %v does not refer to the KnownTool rule", r)
+		}
+		props := r["properties"].(map[string]any)
+		if props["tlsh"] == "" || props["matchedSha256"] == "" {
+			t.Errorf("properties %v lack the hashes", props)
+		}
+		loc := r["locations"].([]any)[0].(map[string]any)["physicalLocation"].(map[string]any)["artifactLocation"].(map[string]any)
+		uris = append(uris, loc["uri"].(string))
+	}
+	u, err := url.Parse(uris[0])
+	if err != nil || u.Scheme != "file" || u.Path != filepath.ToSlash(known) {
+		t.Errorf("uri %q, want a file URI of %s", uris[0], known)
+	}
+	if msg := results[1].(map[string]any)["message"].(map[string]any)["text"].(string); !strings.Contains(msg, "known-variant.exe (version v1.0) at TLSH distance 0") {
+		t.Errorf("message %q lacks the distance", msg)
+	}
+
+	notes := run["invocations"].([]any)[0].(map[string]any)["toolExecutionNotifications"].([]any)
+	if len(notes) == 0 {
+		t.Error("the unreadable archive is not reported as a notification")
+	}
+}
+
+func TestSARIFWithoutMatches(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "notes.bin", sampleData(5, 8192))
+
+	for _, args := range [][]string{
+		{"-s", "--max-distance", "30", dir},
+		{"-c", "--max-distance", "30", testHash(t, sampleData(5, 8192))},
+	} {
+		out, _, _, err := runCLI(t, append([]string{"--format", "sarif", "--db", writeTestDatabase(t)}, args...)...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		run := parseSARIF(t, out)
+		if results, ok := run["results"].([]any); !ok || len(results) != 0 {
+			t.Errorf("%s: results = %v, want an empty array", args[0], run["results"])
+		}
+	}
+}
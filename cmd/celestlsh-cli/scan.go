@@ -0,0 +1,955 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"debug/elf"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/Magonia-Research/CelesTLSH-CLI/pkg/celestlsh"
+)
+
+// scanResult is the outcome of hashing one file and checking it against the
+// database. Match is nil when nothing was found within the distance limit.
+type scanResult struct {
+	Path string `json:"path"`
+	celestlsh.Digests
+	Match *celestlsh.HashRecord `json:"match,omitempty"`
+	Error string                `json:"error,omitempty"`
+
+	// Confidence labels the distance of Match; see confidenceBands. It is
+	// exactConfidence when MatchType is exact.
+	Confidence string `json:"confidence,omitempty"`
+
+	// MatchType tells a scanned file whose SHA256 is that of Match, an
+	// exact match, from one that is only similar; see verifyMatch.
+	MatchType string `json:"match_type,omitempty"`
+
+	// Repos lists the best match of each repository with --group-by-repo,
+	// closest first; Match is the first of them.
+	Repos []celestlsh.RepoMatch `json:"repos,omitempty"`
+
+	// ErrorCode classifies Error; see errorCode.
+	ErrorCode string `json:"error_code,omitempty"`
+
+	// Suppressed marks a match allowlisted with --allowlist, which is
+	// counted but not reported as a match.
+	Suppressed bool `json:"suppressed,omitempty"`
+
+	// Quarantined is where --quarantine moved the matched file.
+	Quarantined string `json:"quarantined,omitempty"`
+
+	// HighEntropy marks a file with at least --min-entropy, flagged
+	// whether or not it matched.
+	HighEntropy bool `json:"high_entropy,omitempty"`
+
+	// MatchedSection names the section whose hash found Match, with
+	// --sections, when that of the whole file found nothing.
+	MatchedSection string `json:"matched_section,omitempty"`
+
+	// file is the file on disk the result was scanned from: Path itself,
+	// or the outermost archive when Path names a member of one.
+	file string
+
+	// size is the number of bytes hashed.
+	size int64
+
+	// tooSmall marks an Error from a file too small or too uniform to
+	// hash, which scans skip rather than report.
+	tooSmall bool
+}
+
+// scanner hashes files, and the members of archives, and checks them
+// against an in-memory database. It is shared by scan and watch modes.
+//
+// With a pool, files are scanned concurrently and their results printed by
+// the pool; without one, files are scanned one at a time and printed
+// immediately.
+type scanner struct {
+	config Config
+	db     *celestlsh.Database
+	hasher celestlsh.Hasher
+	pool   *resultPool
+
+	// stats, if set, counts files and results for the progress line, the
+	// exit status and the summary of an interrupted scan.
+	stats *scanStats
+
+	// filter, if set, skips files and directories found while walking.
+	filter *pathFilter
+
+	// queue, if set, holds the files found so that they are scanned in
+	// the --order given.
+	queue *scanQueue
+
+	// allowlist, if set, suppresses known-good matches.
+	allowlist *allowlist
+
+	// quarantine, if set, moves matched files out of the scanned tree.
+	quarantine *quarantine
+
+	// results, if set, records every result for --results-db.
+	results *resultsStore
+
+	// cache, if set, holds the digests of files hashed by earlier scans.
+	cache *hashCache
+
+	// remote, if set, checks hashes on the --remote or --grpc server, or
+	// the daemon on --socket, in place of db, which is then nil.
+	remote *remoteLookup
+
+	// report, if set, collects results and skipped files for --report.
+	report *htmlReport
+
+	// checkpoint, if set, saves the scan's progress and skips what an
+	// earlier run finished.
+	checkpoint *checkpoint
+
+	// failFast, if set, stops the scan at its first match.
+	failFast *failFast
+
+	// throttle, if set, paces the reads of every worker for --throttle.
+	throttle *throttle
+
+	// mounts maps the mount points of virtual filesystems, which walks do
+	// not enter, to their types.
+	mounts map[string]string
+
+	// document, if set, collects results for a --format that is written
+	// as a whole at the end of the run.
+	document document
+
+	// syslog and webhook, if set, are notified of every match.
+	syslog  *syslogForwarder
+	webhook *webhookNotifier
+
+	// collect gathers the results of a single file scanned on the pool.
+	collect *[]scanResult
+
+	// source is the file being scanned, recorded in its results.
+	source string
+}
+
+// document is an output format that describes a whole run, such as a SARIF
+// log, rather than one line per result.
+type document interface {
+	add(result scanResult)
+	write(w io.Writer) error
+}
+
+// skipList is a document that also lists the entries a scan skipped. Its
+// skip method is called by workers, concurrently with add.
+type skipList interface {
+	skip(path, reason string)
+}
+
+// newDocument returns the document for the selected --format, or nil if
+// results are printed as they arrive.
+func newDocument(config Config) document {
+	switch config.Format {
+	case "sarif":
+		return newSARIFDocument()
+	case "stix":
+		return newSTIXDocument()
+	case "misp":
+		return newMISPDocument(config.MISPEventInfo)
+	case "markdown":
+		return newMarkdownDocument(config)
+	case "junit":
+		return newJUnitDocument()
+	default:
+		return nil
+	}
+}
+
+func executeScan(ctx context.Context, config Config) (status, error) {
+	remote, err := newRemoteLookup(config)
+	if err != nil {
+		return statusOK, err
+	}
+	// With --group-by-repo every repository is needed, which the daemon
+	// does not answer for, so the database is loaded.
+	if remote == nil && useDaemon(config) && !config.GroupByRepo {
+		remote = newDaemonLookup(config)
+	}
+	defer remote.close()
+	age, err := checkStale(config)
+	if err != nil {
+		return statusOK, err
+	}
+	var db *celestlsh.Database
+	if remote == nil {
+		if db, err = loadDatabase(ctx, config); err != nil {
+			return statusOK, err
+		}
+	}
+
+	forwarder, err := newSyslogForwarder(config)
+	if err != nil {
+		return statusOK, err
+	}
+	defer forwarder.close()
+
+	notifier := newWebhookNotifier(config)
+	if notifier != nil {
+		notifier.start()
+		defer notifier.close()
+	}
+
+	filter, err := newPathFilter(config.Include, config.Exclude)
+	if err != nil {
+		return statusOK, err
+	}
+
+	var mounts map[string]string
+	if !config.AllFilesystems {
+		if mounts, err = virtualMounts(); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: cannot read the mount table, so virtual filesystems will be scanned: %v\n", err)
+		}
+	}
+
+	allow, err := loadAllowlist(config.Allowlist)
+	if err != nil {
+		return statusOK, err
+	}
+
+	q, err := newQuarantine(config, config.Paths)
+	if err != nil {
+		return statusOK, err
+	}
+	defer q.close()
+
+	cache := newHashCache(config)
+	defer cache.close()
+
+	store, err := newResultsStore(ctx, config, config.Paths, true)
+	if err != nil {
+		return statusOK, err
+	}
+
+	stats := &scanStats{}
+	cp, err := newCheckpoint(config, stats)
+	if err != nil {
+		return statusOK, err
+	}
+	if cp.resuming() && !config.Quiet {
+		fmt.Fprintf(os.Stderr, "Resuming from checkpoint %s: %d files already scanned\n", config.Checkpoint, cp.resumed.Files)
+	}
+
+	workers := workerCount(config)
+	s := &scanner{allowlist: allow, quarantine: q, results: store, cache: cache, remote: remote, mounts: mounts, config: config, db: db, hasher: newHasher(config), stats: stats, checkpoint: cp, filter: filter, queue: newScanQueue(config), document: newDocument(config), syslog: forwarder, webhook: notifier, throttle: newThrottle(config)}
+	if workers > 1 {
+		s.pool = newResultPool(workers, config.Unordered, s.output)
+	}
+
+	// Results on an interactive stdout are feedback enough, and would be
+	// garbled by a progress line on the same terminal.
+	var progress *progressLine
+	if progressEnabled(config) && !isTerminal(os.Stdout) {
+		progress = startProgress(s.stats.render)
+	}
+
+	start := time.Now()
+	s.report = newHTMLReport(config, start)
+	slog.Info("scan started", "paths", config.Paths, "workers", workers)
+	work, cancel := graceContext(ctx)
+	defer cancel()
+	ff, walk, work := newFailFast(config, ctx, work)
+	defer ff.release()
+	s.failFast = ff
+
+	cp.start()
+	if config.Image != "" {
+		err = s.scanImage(walk, work, config.Image)
+	} else {
+		for i, root := range config.Paths {
+			cp.enter(i)
+			if err = s.scanTree(walk, work, root); err != nil {
+				break
+			}
+		}
+	}
+	if err == nil {
+		s.flushQueue(walk, work)
+	}
+
+	if s.pool != nil {
+		s.pool.wait()
+	}
+	progress.finish()
+
+	if ff.done() && errors.Is(err, context.Canceled) && ctx.Err() == nil {
+		err = nil
+	}
+	if cerr := cp.finish(err == nil && walk.Err() == nil); cerr != nil && err == nil {
+		err = cerr
+	}
+
+	if s.document != nil && !config.StatsOnly {
+		if werr := s.document.write(os.Stdout); werr != nil && err == nil {
+			err = fmt.Errorf("failed to write results: %v", werr)
+		}
+	}
+
+	summary := s.stats.summary(time.Since(start))
+	summary.Interrupted = ctx.Err() != nil
+	summary.StoppedEarly = ff.done()
+	summary.Throttle = config.Throttle
+	// Earlier runs skip whole directories, so their files are counted
+	// from the checkpoint rather than as they are skipped.
+	summary.Resumed = cp.resumedFiles()
+	summary.setDatabaseAge(config, age)
+	records := -1
+	if db != nil {
+		summary.FilteredRecords = int64(db.LoadStats().Filtered)
+		records = db.Len()
+	}
+	slog.Info("scan finished", "files", summary.Files, "matched", summary.Matched, "failed", summary.Failed,
+		"interrupted", summary.Interrupted, "stopped_early", summary.StoppedEarly, "duration", time.Since(start))
+	switch {
+	case config.OutputJSONL:
+		printJSONLSummary(summary)
+	case config.StatsOnly:
+		summary.report(os.Stdout)
+	case !config.Quiet:
+		summary.report(os.Stderr)
+	}
+	if serr := store.finish(&summary); serr != nil && err == nil {
+		err = serr
+	}
+	if rerr := s.report.write(config, summary, records); rerr != nil && err == nil {
+		err = rerr
+	} else if rerr == nil && s.report != nil && !config.Quiet {
+		fmt.Fprintf(os.Stderr, "HTML report written to %s\n", config.Report)
+	}
+	if config.SyslogSummary && forwarder != nil {
+		if config.OutputJSONL {
+			forwarder.send(summary.line(), true)
+		} else {
+			forwarder.send(summary.text(), true)
+		}
+	}
+
+	if ctx.Err() != nil {
+		return statusOK, fmt.Errorf("scan interrupted: %d files scanned, %d pending", summary.Files, summary.Pending)
+	}
+
+	// A resumed scan exits with the status of the whole scan.
+	matched := summary.Matched + cp.resumedMatched()
+	failed := summary.Failed + summary.QuarantineFailed + cp.resumedFailed()
+	return batchStatus(int(matched), int(failed)), err
+}
+
+// scanTree scans root, walking it recursively if it is a directory.
+// Symbolic links below root are only followed with --follow-symlinks; see
+// walkTree. Cancelling ctx stops the walk and skips files not yet started;
+// work bounds the files being scanned.
+func (s *scanner) scanTree(ctx, work context.Context, root string) error {
+	info, err := os.Stat(root)
+	if err != nil {
+		s.emit(scanResult{Path: root, Error: err.Error(), ErrorCode: errorCode(err)})
+		return nil
+	}
+	if !info.IsDir() {
+		if !info.Mode().IsRegular() {
+			s.skipSpecial(root)
+		} else if !s.tooLarge(root, info) {
+			s.enqueue(ctx, work, root, info)
+		}
+		return ctx.Err()
+	}
+
+	w := &treeWalk{root: root}
+	if s.config.FollowSymlinks {
+		if w.realRoot, err = filepath.EvalSymlinks(root); err != nil {
+			s.emit(scanResult{Path: root, Error: err.Error(), ErrorCode: errorCode(err)})
+			return nil
+		}
+		w.visited = newVisitedSet()
+		w.visited.add(w.realRoot, info)
+	}
+	return s.walkTree(ctx, work, w, root, root)
+}
+
+// tooLarge reports whether the file described by info exceeds
+// --max-file-size, counting and noting it if so. It only needs the result
+// of a stat, so skipped files are never opened.
+func (s *scanner) tooLarge(path string, info fs.FileInfo) bool {
+	if s.config.MaxFileSize <= 0 || info.Size() <= s.config.MaxFileSize {
+		return false
+	}
+	s.stats.oversized.Add(1)
+	s.note(path, formatSize(info.Size())+" exceeds --max-file-size")
+	return true
+}
+
+// dispatch scans path, on the pool if there is one.
+func (s *scanner) dispatch(ctx, work context.Context, path string) {
+	if s.checkpoint.skipFile(path) {
+		return
+	}
+	if s.stats != nil {
+		s.stats.discovered.Add(1)
+	}
+
+	pos := s.checkpoint.position(path)
+	if s.pool == nil {
+		s.scanFile(work, path)
+		s.idle(work)
+		if s.stats != nil {
+			s.stats.processed.Add(1)
+		}
+		s.checkpoint.finished(pos, work.Err() == nil)
+		return
+	}
+
+	// ok is set by the worker before the printer calls then. A file
+	// skipped or cut short by an interruption is not finished.
+	var ok bool
+	s.pool.submitThen(func() []scanResult {
+		if ctx.Err() != nil {
+			return nil
+		}
+		if s.stats != nil {
+			defer s.stats.processed.Add(1)
+		}
+		var results []scanResult
+		job := *s
+		job.collect = &results
+		job.scanFile(work, path)
+		ok = work.Err() == nil
+		job.idle(work)
+		return results
+	}, func() { s.checkpoint.finished(pos, ok) })
+}
+
+// idle rests the worker after a file with --nice-idle.
+func (s *scanner) idle(ctx context.Context) {
+	if s.config.NiceIdle {
+		sleepContext(ctx, niceIdlePause)
+	}
+}
+
+// scanFile checks a single file, or each member of it if it is an archive.
+func (s *scanner) scanFile(ctx context.Context, path string) {
+	info, hit := s.cached(ctx, path)
+	if hit {
+		return
+	}
+
+	// With --file-timeout, the file is opened and read in goroutines that
+	// are abandoned when time runs out, so that a file on a hung mount
+	// cannot stall the worker.
+	var f *os.File
+	var err error
+	if s.config.FileTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeoutCause(ctx, s.config.FileTimeout, errFileTimeout)
+		defer cancel()
+		f, err = openWithin(ctx, path)
+	} else {
+		f, err = os.Open(path)
+	}
+	if context.Cause(ctx) == errFileTimeout {
+		s.emit(s.timedOut(path))
+		return
+	}
+	if err != nil {
+		s.emit(scanResult{Path: path, Error: fmt.Sprintf("failed to calculate TLSH hash: error reading file: %v", err), ErrorCode: errorCode(err)})
+		return
+	}
+	defer f.Close()
+
+	s.source = path
+	defer func() { s.source = "" }()
+
+	// Closing the file when time runs out or the run is interrupted
+	// unblocks a read stuck on it, where the platform allows, and
+	// otherwise releases it once the read returns.
+	defer context.AfterFunc(ctx, func() { f.Close() })()
+	var src scanSource = f
+	if s.config.FileTimeout > 0 {
+		src = &deadlineFile{ctx: ctx, f: f}
+	}
+	if s.throttle != nil {
+		src = &throttledSource{scanSource: src, ctx: ctx, throttle: s.throttle}
+	}
+
+	head := make([]byte, sniffLen)
+	n, _ := io.ReadFull(src, head)
+	if context.Cause(ctx) == errFileTimeout {
+		s.emit(s.timedOut(path))
+		return
+	}
+	kind := detectArchive(path, head[:n])
+	if (kind == notArchive || s.config.ArchiveDepth < 1) && !s.wantedType(path, head[:n]) {
+		return
+	}
+
+	if _, err := src.Seek(0, io.SeekStart); err != nil {
+		s.emit(scanResult{Path: path, Error: err.Error(), ErrorCode: errorCode(err)})
+		return
+	}
+
+	if kind == notArchive || s.config.ArchiveDepth < 1 {
+		result := s.check(ctx, path, src)
+		if info != nil && kind == notArchive && result.Error == "" {
+			s.cacheMiss(path, info, src, detectFileType(head[:n]), result.Digests)
+		}
+		s.emit(result)
+		return
+	}
+
+	info, err = src.Stat()
+	if err != nil {
+		s.emit(scanResult{Path: path, Error: err.Error(), ErrorCode: errorCode(err)})
+		return
+	}
+
+	budget := &extractBudget{remaining: s.config.MaxExtracted}
+	s.scanArchive(ctx, path, kind, archiveSource{reader: src, readerAt: src, size: info.Size()}, 1, budget)
+
+	if budget.exhausted {
+		s.emit(scanResult{Path: path, Error: fmt.Sprintf("scan truncated: more than %s would be decompressed (see --max-extracted)", formatSize(s.config.MaxExtracted))})
+	}
+}
+
+// timedOut is the result of a file abandoned after --file-timeout.
+func (s *scanner) timedOut(path string) scanResult {
+	return scanResult{Path: path, Error: fmt.Sprintf("gave up on the file after %v (see --file-timeout)", s.config.FileTimeout), ErrorCode: codeFileTimeout}
+}
+
+// checkFile hashes the file at path, without looking inside archives.
+func (s *scanner) checkFile(ctx context.Context, path string) scanResult {
+	f, err := os.Open(path)
+	if err != nil {
+		return scanResult{Path: path, Error: fmt.Sprintf("failed to calculate TLSH hash: error reading file: %v", err), ErrorCode: errorCode(err)}
+	}
+	defer f.Close()
+
+	return s.check(ctx, path, f)
+}
+
+// check hashes everything read from r and looks up its closest record.
+func (s *scanner) check(ctx context.Context, name string, r io.Reader) (result scanResult) {
+	result.Path = name
+	start := time.Now()
+	defer func() { logCheck(result, time.Since(start)) }()
+
+	var size atomic.Int64
+	counted := io.Reader(&countingReader{r: r, n: &size})
+	if ra, ok := r.(io.ReaderAt); ok {
+		counted = struct {
+			io.Reader
+			io.ReaderAt
+		}{counted, ra}
+	}
+	r = counted
+	defer func() {
+		if s.stats != nil {
+			s.stats.bytes.Add(size.Load())
+		}
+	}()
+
+	// The imphash and section hashes need random access to the file,
+	// which archive members only get by being buffered first; only PE and
+	// ELF files are worth that.
+	if _, ok := r.(io.ReaderAt); !ok && (s.config.Digests&celestlsh.DigestImphash != 0 || s.config.Sections) {
+		br := bufio.NewReader(r)
+		r = br
+		if head, _ := br.Peek(4); strings.HasPrefix(string(head), "MZ") || s.config.Sections && string(head) == elf.ELFMAG {
+			ra, size, cleanup, err := spool(br)
+			if err != nil {
+				result.Error, result.ErrorCode = fmt.Sprintf("failed to calculate TLSH hash: %v", err), errorCode(err)
+				return result
+			}
+			defer cleanup()
+			r = io.NewSectionReader(ra, 0, size)
+		}
+	}
+
+	digests, err := s.hasher.DigestReader(ctx, r, s.digests())
+	if context.Cause(ctx) == errFileTimeout {
+		return s.timedOut(name)
+	}
+	if err != nil {
+		result.Error, result.ErrorCode = hashError(err), errorCode(err)
+		result.tooSmall = errors.Is(err, celestlsh.ErrInsufficientData)
+		return result
+	}
+	if ra, ok := r.(io.ReaderAt); ok && s.config.Sections {
+		digests.Sections = hashSections(ctx, &s.hasher, name, ra)
+	}
+	result.Digests = digests
+	result.size = size.Load()
+	return s.lookup(ctx, result)
+}
+
+// digests returns the digests computed for each file. The SHA256 is always
+// computed, in the same read as the TLSH hash, to tell exact matches from
+// similar ones, and for allowlists, quarantine, stored results and hash
+// cache entries; output drops it again unless it was asked for.
+func (s *scanner) digests() celestlsh.Digest {
+	return s.config.Digests | celestlsh.DigestSHA256
+}
+
+// lookup finds the closest record to the hashed result, or with
+// --group-by-repo that of each repository.
+func (s *scanner) lookup(ctx context.Context, result scanResult) scanResult {
+	result.HighEntropy = s.config.MinEntropy > 0 && result.Entropy >= s.config.MinEntropy
+
+	if s.config.GroupByRepo {
+		repos, err := s.db.NearestRepos(ctx, result.TLSH, s.config.MaxDistance, s.config.Top)
+		if err != nil {
+			result.Error = fmt.Sprintf("failed to check TLSH against database: %v", err)
+			return result
+		}
+		if len(repos) > 0 {
+			best := repos[0].HashRecord
+			sortRepos(s.config.Sort, repos)
+			result.Repos = repos
+			result.Match = &best
+			result.Confidence = s.config.ConfidenceBands.label(result.Match.Distance)
+			verifyMatch(&result)
+			result.Suppressed = s.allowlist.suppresses(result)
+		}
+		return result
+	}
+
+	matches, err := s.nearest(ctx, result.TLSH)
+	// Appended data or edited resources can take a binary out of reach of
+	// its record while its code stays close.
+	if err == nil && len(matches) == 0 && s.config.Sections {
+		if text := textSection(result.Sections); text != nil {
+			matches, err = s.nearest(ctx, text.TLSH)
+			if len(matches) > 0 {
+				result.MatchedSection = text.Name
+			}
+		}
+	}
+	if err != nil {
+		where := "database"
+		if s.remote != nil {
+			where = s.remote.name
+		}
+		result.Error = fmt.Sprintf("failed to check TLSH against %s: %v", where, err)
+		result.ErrorCode = errorCode(err)
+		return result
+	}
+
+	if len(matches) > 0 {
+		result.Match = &matches[0]
+		result.Confidence = s.config.ConfidenceBands.label(result.Match.Distance)
+		verifyMatch(&result)
+		result.Suppressed = s.allowlist.suppresses(result)
+	}
+
+	return result
+}
+
+// nearest returns the closest record to hash within --max-distance, if
+// any, from the server of --remote or --grpc, the daemon or the database.
+func (s *scanner) nearest(ctx context.Context, hash string) ([]celestlsh.HashRecord, error) {
+	if s.remote != nil {
+		return s.remote.query(ctx, hash, s.config.MaxDistance, 1)
+	}
+	return s.db.Nearest(ctx, hash, s.config.MaxDistance, 1)
+}
+
+func (s *scanner) emit(result scanResult) {
+	if result.file == "" {
+		result.file = s.source
+	}
+	if result.Error != "" && result.ErrorCode == "" {
+		result.ErrorCode = codeError
+	}
+	switch {
+	case s.collect != nil:
+		*s.collect = append(*s.collect, result)
+	case s.pool != nil:
+		// Keep results that are not from a file's scan, such as walk
+		// errors, in order with the rest.
+		s.pool.submit(func() []scanResult { return []scanResult{result} })
+	default:
+		s.output(result)
+	}
+}
+
+// output counts a result, quarantines its file if it matched, and prints
+// it, or adds it to the document. Once --fail-fast has stopped the scan,
+// results still arriving are dropped.
+func (s *scanner) output(result scanResult) {
+	if s.failFast.done() {
+		return
+	}
+	defer s.failFast.trip(result)
+	if result.tooSmall {
+		if s.stats != nil {
+			s.stats.tooSmall.Add(1)
+		}
+		s.note(result.Path, result.Error)
+		return
+	}
+	if s.quarantine != nil && result.Error == "" && result.Match != nil && !result.Suppressed && result.file != "" {
+		s.quarantineFile(&result)
+	}
+	if s.results != nil {
+		s.results.add(result)
+	}
+	if s.config.Digests&celestlsh.DigestSHA256 == 0 {
+		result.SHA256 = ""
+	}
+	if s.stats != nil {
+		s.stats.tally(result)
+	}
+	s.report.add(result)
+	if result.Suppressed && !s.config.ShowSuppressed {
+		return
+	}
+	if result.Error == "" && result.Match != nil && !result.Suppressed {
+		if s.syslog != nil {
+			s.syslog.send(scanLine(s.config, result), false)
+		}
+		if s.webhook != nil {
+			s.webhook.notify(result)
+		}
+	}
+	switch {
+	case s.config.StatsOnly:
+	case s.document != nil:
+		// Documents have no notion of a suppressed match.
+		if !result.Suppressed {
+			s.document.add(result)
+		}
+	default:
+		printScanResult(s.config, result)
+	}
+}
+
+// note logs, in verbose mode, that path was skipped and why, and lists it
+// in the --report and documents that list skipped entries.
+func (s *scanner) note(path, reason string) {
+	slog.Info("skipped", "path", path, "reason", reason)
+	s.report.skip(path, reason)
+	if list, ok := s.document.(skipList); ok {
+		list.skip(path, reason)
+	}
+}
+
+func printScanResult(config Config, result scanResult) {
+	if result.Error != "" && !config.OutputJSONL {
+		clearProgress()
+		printResultError(config, result)
+		return
+	}
+	if config.Template != nil {
+		printTemplate(config, result)
+		return
+	}
+
+	if line := scanLine(config, result); line != "" {
+		if textFormat(config) {
+			line = paint(resultStyle(config, result), line)
+		}
+		fmt.Println(line)
+	}
+}
+
+// scanLine renders a result in the selected line format, or returns "" if
+// the format omits it. Errors are only rendered as JSON Lines.
+func scanLine(config Config, result scanResult) string {
+	switch {
+	case config.OutputJSONL:
+		line, err := json.Marshal(result)
+		if err != nil {
+			return ""
+		}
+		return string(line)
+
+	case result.Error != "":
+		return ""
+
+	// Only the text and JSON Lines formats mark a match as suppressed.
+	case result.Suppressed && (config.Format == "cef" || config.OutputCSV || config.Quiet):
+		return ""
+
+	case config.Format == "cef":
+		if result.Match == nil {
+			return ""
+		}
+		return cefLine(result)
+
+	case config.OutputCSV:
+		row := append([]string{result.Path}, digestFields(config.Digests, result.Digests)...)
+		if result.Match == nil {
+			return csvLine(append(row, "", "", "", "", "", ""))
+		}
+		if len(result.Repos) > 0 {
+			// One row per repository, with the number of its matches.
+			rows := make([][]string, len(result.Repos))
+			for i, r := range result.Repos {
+				rows[i] = append(slices.Clip(row), r.RepoName, r.FileName, r.Version, r.SHA256Hash, strconv.Itoa(r.Distance), config.ConfidenceBands.label(r.Distance), strconv.Itoa(r.Count))
+			}
+			return csvLine(rows...)
+		}
+		m := result.Match
+		return csvLine(append(row, m.RepoName, m.FileName, m.Version, m.SHA256Hash, strconv.Itoa(m.Distance), result.Confidence))
+
+	case config.Quiet:
+		if result.Match == nil {
+			return ""
+		}
+		return fmt.Sprintf("%s %s", displayPath(result.Path), result.Match.SHA256Hash)
+
+	default:
+		if result.Match == nil {
+			line := fmt.Sprintf("%s: no match%s", displayPath(result.Path), digestSuffix(result.Digests))
+			if result.HighEntropy {
+				line += " [high entropy]"
+			}
+			return line
+		}
+		m := result.Match
+		detail := describeMatch(result)
+		if detail != "" {
+			detail = ", " + detail
+		}
+		line := fmt.Sprintf("%s: %s %s (distance %d%s)%s", displayPath(result.Path), m.RepoName, m.FileName, m.Distance, detail, digestSuffix(result.Digests))
+		if result.MatchedSection != "" {
+			line += fmt.Sprintf(" [section %s]", sectionName(result.MatchedSection))
+		}
+		if len(result.Repos) > 0 {
+			line = fmt.Sprintf("%s: %s%s", displayPath(result.Path), repoSummary(config.ConfidenceBands, result.Repos), digestSuffix(result.Digests))
+			if result.MatchType == matchExact {
+				line += " [exact match]"
+			}
+		}
+		if result.Suppressed {
+			line += " [suppressed]"
+		}
+		if result.HighEntropy {
+			line += " [high entropy]"
+		}
+		return line
+	}
+}
+
+// csvLine renders rows as CSV, quoting fields as needed, without the final
+// line break.
+func csvLine(rows ...[]string) string {
+	var b strings.Builder
+	w := csv.NewWriter(&b)
+	w.WriteAll(rows)
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// digestFields lists the TLSH hash and the digests selected by which in
+// their documented output order: TLSH, MD5, SHA1, SHA256, imphash,
+// entropy, ssdeep. A field is present, though possibly empty, for every selected
+// digest.
+func digestFields(which celestlsh.Digest, d celestlsh.Digests) []string {
+	fields := []string{d.TLSH}
+	for _, sum := range []struct {
+		digest celestlsh.Digest
+		value  string
+	}{
+		{celestlsh.DigestMD5, d.MD5},
+		{celestlsh.DigestSHA1, d.SHA1},
+		{celestlsh.DigestSHA256, d.SHA256},
+		{celestlsh.DigestImphash, d.Imphash},
+		{celestlsh.DigestEntropy, formatEntropy(d.Entropy)},
+		{celestlsh.DigestSSDeep, d.SSDeep},
+	} {
+		if which&sum.digest != 0 {
+			fields = append(fields, sum.value)
+		}
+	}
+	return fields
+}
+
+// displayPath returns path for plain text output, quoted and escaped as a
+// Go string if it holds control characters or invalid UTF-8, so that a
+// file name cannot break a line apart or inject terminal escapes.
+func displayPath(path string) string {
+	if !utf8.ValidString(path) || strings.ContainsFunc(path, unicode.IsControl) {
+		return strconv.Quote(path)
+	}
+	return path
+}
+
+// digestSuffix renders the computed cryptographic digests for plain output,
+// e.g. " sha256=<hex>", or nothing when none were requested.
+func digestSuffix(d celestlsh.Digests) string {
+	var b strings.Builder
+	for _, sum := range []struct{ name, value string }{{"md5", d.MD5}, {"sha1", d.SHA1}, {"sha256", d.SHA256}, {"imphash", d.Imphash}} {
+		if sum.value != "" {
+			fmt.Fprintf(&b, " %s=%s", sum.name, sum.value)
+		}
+	}
+	if d.Entropy > 0 {
+		fmt.Fprintf(&b, " entropy=%s", formatEntropy(d.Entropy))
+	}
+	if d.SSDeep != "" {
+		fmt.Fprintf(&b, " ssdeep=%s", d.SSDeep)
+	}
+	if len(d.Sections) > 0 {
+		b.WriteString(" sections=")
+		for i, section := range d.Sections {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			fmt.Fprintf(&b, "%s:%s", sectionName(section.Name), section.TLSH)
+		}
+	}
+	return b.String()
+}
+
+// formatEntropy renders an entropy for text and CSV output.
+func formatEntropy(e float64) string {
+	return strconv.FormatFloat(e, 'f', 3, 64)
+}
+
+// databaseFilters returns the filters restricting which database records
+// are loaded, from --since, --until, --strict-dates and --version-filter.
+func databaseFilters(config Config) []celestlsh.Filter {
+	var filters []celestlsh.Filter
+	if !config.Since.IsZero() || !config.Until.IsZero() {
+		filters = append(filters, celestlsh.AddedBetween(config.Since, config.Until, config.StrictDates))
+	}
+	if config.VersionFilter != nil {
+		filters = append(filters, config.VersionFilter)
+	}
+	return filters
+}
+
+// loadDatabase checks that the configured database exists and parses it.
+func loadDatabase(ctx context.Context, config Config) (*celestlsh.Database, error) {
+	if _, err := os.Stat(config.DbPath); errors.Is(err, os.ErrNotExist) && config.DbPath != stdinDatabase && !useEmbedded(config) {
+		return nil, fmt.Errorf("%w; download it first with --download", &celestlsh.DatabaseNotFoundError{Path: config.DbPath})
+	}
+
+	start := time.Now()
+	db, err := loadVerifiedDatabase(ctx, config, databaseFilters(config)...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load database: %w", err)
+	}
+	db.Workers = config.Workers
+	logDatabase(config.DbPath, db, time.Since(start))
+
+	return db, nil
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/Magonia-Research/CelesTLSH-CLI/pkg/celestlsh"
+)
+
+// searchRecord is a database record found by search mode, in the JSON
+// form of a record without the distance of a check.
+type searchRecord struct {
+	RepoName   string `json:"repo_name"`
+	FileName   string `json:"file_name"`
+	Version    string `json:"version"`
+	TLSHHash   string `json:"tlsh"`
+	SHA256Hash string `json:"sha256"`
+	Imphash    string `json:"imphash"`
+	DateAdded  string `json:"date_added"`
+	Intel      string `json:"intel"`
+}
+
+// executeSearch lists the database records whose Repo Name or File Name,
+// and with --search-intel Intel, contain the query, ignoring case, or with
+// --regex match it. It returns statusMatch when any record is found, as a
+// check does.
+func executeSearch(ctx context.Context, config Config) (status, error) {
+	match, err := searchMatcher(config.Search, config.SearchRegex)
+	if err != nil {
+		return statusOK, err
+	}
+
+	db, err := loadDatabase(ctx, config)
+	if err != nil {
+		return statusOK, err
+	}
+
+	var found []celestlsh.HashRecord
+	for _, r := range db.Records() {
+		if config.Limit > 0 && len(found) == config.Limit {
+			break
+		}
+		if match(r.RepoName) || match(r.FileName) || (config.SearchIntel && match(r.Intel)) {
+			found = append(found, r)
+		}
+	}
+
+	st := statusOK
+	if len(found) > 0 {
+		st = statusMatch
+	}
+
+	switch {
+	case config.OutputJSON:
+		records := make([]searchRecord, len(found))
+		for i, r := range found {
+			records[i] = searchRecord{
+				RepoName: r.RepoName, FileName: r.FileName, Version: r.Version, TLSHHash: r.TLSHHash,
+				SHA256Hash: r.SHA256Hash, Imphash: r.Imphash, DateAdded: r.DateAdded, Intel: r.Intel,
+			}
+		}
+		return st, printJSON(records)
+
+	case config.OutputCSV:
+		// Rows keep the database schema, so the output can be loaded with
+		// --db or merged with --db-import.
+		w := csv.NewWriter(os.Stdout)
+		w.Write(celestlsh.Header)
+		for _, r := range found {
+			w.Write(r.CSVRow())
+		}
+		w.Flush()
+		return st, w.Error()
+
+	case config.Quiet:
+		for _, r := range found {
+			fmt.Println(r.SHA256Hash)
+		}
+		return st, nil
+	}
+
+	if len(found) == 0 {
+		fmt.Println("No records found")
+		return st, nil
+	}
+	fit := tableFit(config)
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	header := "Tool\tFile\tVersion\tSHA256\tDate"
+	if config.SearchIntel {
+		header += "\tIntel"
+	}
+	fmt.Fprintln(tw, header)
+	for _, r := range found {
+		row := fmt.Sprintf("%s\t%s\t%s\t%s\t%s", fit(r.RepoName, tableRepoWidth), fit(r.FileName, tableFileWidth),
+			fit(r.Version, tableVersionWidth), fit(r.SHA256Hash, tableSHA256Width), fit(r.DateAdded, tableDateWidth))
+		if config.SearchIntel {
+			row += "\t" + r.Intel
+		}
+		fmt.Fprintln(tw, row)
+	}
+	tw.Flush()
+	if len(found) == 1 {
+		fmt.Fprintln(os.Stderr, "1 record found")
+	} else {
+		fmt.Fprintf(os.Stderr, "%d records found\n", len(found))
+	}
+	return st, nil
+}
+
+// searchMatcher returns a function reporting whether a field matches the
+// query: as a case-insensitive substring, or a case-insensitive regular
+// expression with --regex.
+func searchMatcher(query string, regex bool) (func(string) bool, error) {
+	if regex {
+		re, err := regexp.Compile("(?i)" + query)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --search regular expression: %w", err)
+		}
+		return re.MatchString, nil
+	}
+	query = strings.ToLower(query)
+	return func(field string) bool {
+		return strings.Contains(strings.ToLower(field), query)
+	}, nil
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/Magonia-Research/CelesTLSH-CLI/pkg/celestlsh"
+)
+
+// hashSections returns the hashes of the executable sections of the PE or
+// ELF file name, read from r, for --sections. A binary whose sections
+// cannot be read, such as a truncated one, keeps just its whole-file hash,
+// with a warning.
+func hashSections(ctx context.Context, hasher *celestlsh.Hasher, name string, r io.ReaderAt) []celestlsh.SectionDigest {
+	sections, err := hasher.HashSections(ctx, r)
+	if err != nil {
+		if ctx.Err() == nil {
+			clearProgress()
+			fmt.Fprintf(os.Stderr, "Warning: %s: hashing the whole file only: %v\n", displayPath(name), err)
+		}
+		return nil
+	}
+	return sections
+}
+
+// textSection returns the .text section of sections, which holds the code
+// of most binaries and is checked against the database when the whole file
+// does not match, or nil if there is none.
+func textSection(sections []celestlsh.SectionDigest) *celestlsh.SectionDigest {
+	for i := range sections {
+		if sections[i].Name == ".text" {
+			return &sections[i]
+		}
+	}
+	return nil
+}
+
+// sectionName returns a section name for plain output, quoted if it holds
+// characters that would make the compact "name:hash,..." notation
+// ambiguous, which the names in a crafted file can.
+func sectionName(name string) string {
+	if name == "" || strings.ContainsAny(name, " ,:=\"") || strings.ContainsFunc(name, func(r rune) bool { return !unicode.IsPrint(r) }) {
+		return strconv.Quote(name)
+	}
+	return name
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/Magonia-Research/CelesTLSH-CLI/pkg/celestlsh"
+)
+
+// selftestVector is an input generated in the binary, with the TLSH hash it
+// must have. An empty want means the input must be rejected as too short.
+type selftestVector struct {
+	name  string
+	data  func() []byte
+	force bool
+	want  string
+}
+
+// selftestDistance is the distance expected between two vectors' hashes.
+type selftestDistance struct {
+	a, b string
+	want int
+}
+
+// selftestVectors cover both sides of the minimum lengths, with and without
+// --force, text and a large pseudorandom buffer. Their hashes are those of
+// the reference platform, linux/amd64, and every other platform must
+// produce the same.
+var selftestVectors = []selftestVector{
+	{name: "random-255", data: func() []byte { return pseudorandom(1, 255) }},
+	{name: "random-256", data: func() []byte { return pseudorandom(1, 256) }, want: "a8d095482a6158f4fdb79251d07b9df390f621c1cfb16158105741cd41b4621c6c3453"},
+	{name: "random-300", data: func() []byte { return pseudorandom(1, 300) }, want: "a3e0e744266048f4f9baa243c07b5df360a22581cf72615c104740cd41a4221d6c7ad7"},
+	{name: "forced-49", data: func() []byte { return pseudorandom(2, 49) }, force: true},
+	{name: "forced-50", data: func() []byte { return pseudorandom(2, 50) }, force: true, want: "bf900253145c5504985c4a2565313d95130987a485f781015a049795d3016804484626"},
+	{name: "text-4k", data: func() []byte { return selftestText(4096) }, want: "e381548b212d23b479cf2c8883cdd4f7c7ecc556723228667835b013a86d521adec9e1"},
+	{name: "text-4k-edited", data: func() []byte { return editedText(4096) }, want: "5081649b612d13b478cf2c8883cdd4f7c6ecc566723228666835b013a86d565adec8e1"},
+	{name: "random-1m", data: func() []byte { return pseudorandom(42, 1<<20) }, want: "e42533ee66b57838fdc0a897187519732af6df8abb6c1588429a344c374f1342eec750"},
+}
+
+var selftestDistances = []selftestDistance{
+	{a: "random-256", b: "random-300", want: 97},
+	{a: "text-4k", b: "text-4k-edited", want: 12},
+	{a: "text-4k", b: "random-1m", want: 901},
+}
+
+// pseudorandom returns n bytes of a xorshift64 sequence. It is written out
+// rather than taken from math/rand so that the bytes cannot change with
+// the Go release.
+func pseudorandom(seed uint64, n int) []byte {
+	b := make([]byte, n)
+	x := seed
+	for i := range b {
+		x ^= x << 13
+		x ^= x >> 7
+		x ^= x << 17
+		b[i] = byte(x >> 32)
+	}
+	return b
+}
+
+// selftestText returns n bytes of numbered lines of text.
+func selftestText(n int) []byte {
+	var sb strings.Builder
+	for i := 0; sb.Len() < n; i++ {
+		fmt.Fprintf(&sb, "%d: the quick brown fox jumps over the lazy dog\n", i)
+	}
+	return []byte(sb.String()[:n])
+}
+
+// editedText returns selftestText with every 97th byte changed, a close
+// but not identical variant.
+func editedText(n int) []byte {
+	b := selftestText(n)
+	for i := 0; i < len(b); i += 97 {
+		b[i] = '#'
+	}
+	return b
+}
+
+// executeSelftest hashes the built-in vectors and compares the hashes and
+// the distances between them with the expected values, to check a build
+// for a new platform.
+func executeSelftest(config Config) error {
+	failed, checks := 0, 0
+	report := func(name string, err error) {
+		checks++
+		if err != nil {
+			failed++
+			fmt.Printf("%s %s: %v\n", paint(styleMatch, "FAIL"), name, err)
+		} else if !config.Quiet {
+			fmt.Printf("%s %s\n", paint(styleClean, "PASS"), name)
+		}
+	}
+
+	hashes := make(map[string]string)
+	for _, v := range selftestVectors {
+		hasher := celestlsh.Hasher{Force: v.force}
+		got, err := hasher.HashBytes(v.data())
+		switch {
+		case v.want == "" && errors.Is(err, celestlsh.ErrInsufficientData):
+			report(v.name, nil)
+		case v.want == "" && err == nil:
+			report(v.name, fmt.Errorf("hashed to %s, want rejected as too short", got))
+		case err != nil:
+			report(v.name, err)
+		case got != v.want:
+			report(v.name, fmt.Errorf("hashed to %s, want %s", got, v.want))
+		default:
+			hashes[v.name] = got
+			report(v.name, nil)
+		}
+	}
+
+	for _, d := range selftestDistances {
+		name := fmt.Sprintf("distance %s %s", d.a, d.b)
+		if hashes[d.a] == "" || hashes[d.b] == "" {
+			report(name, errors.New("skipped, a hash failed"))
+			continue
+		}
+		got, err := celestlsh.Distance(hashes[d.a], hashes[d.b])
+		switch {
+		case err != nil:
+			report(name, err)
+		case got != d.want:
+			report(name, fmt.Errorf("got %d, want %d", got, d.want))
+		default:
+			report(name, nil)
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("self-test failed: %d of %d checks", failed, checks)
+	}
+	fmt.Printf("All %d checks passed\n", checks)
+	return nil
+}
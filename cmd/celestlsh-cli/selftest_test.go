@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+func TestSelftest(t *testing.T) {
+	out, _, _, err := runCLI(t, "--selftest")
+	if err != nil {
+		t.Fatalf("%v\n%s", err, out)
+	}
+	checks := len(selftestVectors) + len(selftestDistances)
+	if strings.Count(out, "PASS ") != checks || !strings.Contains(out, "All 11 checks passed") {
+		t.Errorf("output:\n%s\nwant %d checks passed", out, checks)
+	}
+
+	out, _, _, err = runCLI(t, "--selftest", "--quiet")
+	if err != nil || strings.Contains(out, "PASS") {
+		t.Errorf("--quiet: %v, output:\n%s\nwant the total alone", err, out)
+	}
+}
+
+func TestSelftestFailure(t *testing.T) {
+	old := selftestVectors
+	t.Cleanup(func() { selftestVectors = old })
+	selftestVectors = append([]selftestVector(nil), old...)
+	for i, v := range selftestVectors {
+		switch v.name {
+		case "text-4k":
+			// One wrong digit.
+			selftestVectors[i].want = "f" + v.want[1:]
+		case "random-255":
+			// Long enough with --force.
+			selftestVectors[i].force = true
+		}
+	}
+
+	out, _, _, err := runCLI(t, "--selftest")
+	if err == nil || !strings.Contains(err.Error(), "self-test failed: 4 of 11 checks") {
+		t.Errorf("error = %v, want 4 failed checks", err)
+	}
+	for _, want := range []string{
+		"FAIL text-4k: hashed to e381",
+		"FAIL random-255: hashed to ",
+		"FAIL distance text-4k text-4k-edited: skipped, a hash failed",
+		"FAIL distance text-4k random-1m: skipped",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output lacks %q:\n%s", want, out)
+		}
+	}
+}
+
+// The vectors must not change with the Go release or the platform.
+func TestPseudorandom(t *testing.T) {
+	if got := hex.EncodeToString(pseudorandom(1, 8)); got != "00062f03b00208c1" {
+		t.Errorf("pseudorandom(1, 8) = %s, want 00062f03b00208c1", got)
+	}
+	if got := string(selftestText(60)); got != "0: the quick brown fox jumps over the lazy dog\n1: the quick " {
+		t.Errorf("selftestText(60) = %q", got)
+	}
+}
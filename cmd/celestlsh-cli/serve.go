@@ -0,0 +1,329 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Magonia-Research/CelesTLSH-CLI/pkg/celestlsh"
+)
+
+const (
+	maxCheckBodyBytes = 64 << 10
+	maxHashBodyBytes  = 256 << 20
+	shutdownTimeout   = 10 * time.Second
+)
+
+// statusClientClosedRequest is the non-standard status, from nginx, of a
+// request whose client went away before it was answered. The client never
+// sees it, but it is logged and counted in the metrics.
+const statusClientClosedRequest = 499
+
+type checkRequest struct {
+	TLSH        string `json:"tlsh"`
+	MaxDistance *int   `json:"max_distance,omitempty"`
+	Top         *int   `json:"top,omitempty"`
+}
+
+// checkResponse answers a checkRequest. Truncated counts the matches left
+// out because top was more than the server's --limit.
+type checkResponse struct {
+	TLSH      string                 `json:"tlsh"`
+	Matches   []celestlsh.HashRecord `json:"matches"`
+	Truncated int                    `json:"truncated,omitempty"`
+}
+
+type hashResponse struct {
+	TLSH string `json:"tlsh"`
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+type server struct {
+	db      *liveDatabase
+	hasher  celestlsh.Hasher
+	metrics *metrics
+
+	// stopping, if set, is done once requests still running at shutdown
+	// have had their time, and is what cancels them.
+	stopping context.Context
+}
+
+// requestError is a request the server cannot answer because of what the
+// client sent, such as invalid JSON or a negative top.
+type requestError struct {
+	err error
+}
+
+func (e *requestError) Error() string { return e.err.Error() }
+
+func (e *requestError) Unwrap() error { return e.err }
+
+func badRequest(format string, args ...any) error {
+	return &requestError{err: fmt.Errorf(format, args...)}
+}
+
+func executeServe(ctx context.Context, config Config) error {
+	db, err := newLiveDatabase(ctx, config)
+	if err != nil {
+		return err
+	}
+
+	s := newServer(db)
+	stopping, cancelRequests := context.WithCancel(context.Background())
+	defer cancelRequests()
+	s.stopping = stopping
+	srv := &http.Server{
+		Addr:              config.Listen,
+		Handler:           s.routes(),
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       2 * time.Minute,
+		WriteTimeout:      2 * time.Minute,
+		IdleTimeout:       2 * time.Minute,
+		BaseContext:       func(net.Listener) context.Context { return stopping },
+	}
+
+	go db.watch(ctx)
+
+	errCh := make(chan error, 2)
+	var grpcSrv *grpcService
+	if config.GRPCListen != "" {
+		l, err := net.Listen("tcp", config.GRPCListen)
+		if err != nil {
+			return fmt.Errorf("gRPC server failed: %w", err)
+		}
+		grpcSrv = newGRPCService(s)
+		defer grpcSrv.Stop()
+		go func() {
+			if err := grpcSrv.Serve(l); err != nil {
+				errCh <- fmt.Errorf("gRPC: %w", err)
+			}
+		}()
+	}
+	go func() {
+		errCh <- srv.ListenAndServe()
+	}()
+
+	if !config.Quiet {
+		if grpcSrv != nil {
+			fmt.Printf("Serving %d database records on %s, and over gRPC on %s\n", db.get().Len(), config.Listen, config.GRPCListen)
+		} else {
+			fmt.Printf("Serving %d database records on %s\n", db.get().Len(), config.Listen)
+		}
+	}
+
+	select {
+	case err := <-errCh:
+		return fmt.Errorf("server failed: %w", err)
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	if grpcSrv != nil {
+		// GracefulStop waits for streams to end, which a BatchCheck client
+		// may never do, so it is cut short with the HTTP shutdown.
+		stopped := make(chan struct{})
+		go func() {
+			grpcSrv.GracefulStop()
+			close(stopped)
+		}()
+		defer func() {
+			select {
+			case <-stopped:
+			case <-shutdownCtx.Done():
+				grpcSrv.Stop()
+			}
+		}()
+	}
+
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		// Stop the requests that outlasted the timeout, which answer 503.
+		cancelRequests()
+		return fmt.Errorf("server shutdown failed: %w", err)
+	}
+
+	return nil
+}
+
+func newServer(db *liveDatabase) *server {
+	s := &server{db: db}
+	s.metrics = newMetrics(s)
+	return s
+}
+
+func (s *server) routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("POST /check", s.metrics.instrument("check", s.handleCheck))
+	mux.Handle("POST /hash", s.metrics.instrument("hash", s.handleHash))
+	mux.Handle("GET /healthz", s.metrics.instrument("healthz", s.handleHealthz))
+	mux.Handle("GET /metrics", s.metrics.handler())
+	return mux
+}
+
+func (s *server) handleCheck(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxCheckBodyBytes)
+
+	var req checkRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.writeRequestError(w, badRequest("invalid JSON body: %w", err))
+		return
+	}
+
+	resp, err := runCheck(r.Context(), s.db.get(), req, s.db.config.Limit)
+	if err != nil {
+		s.writeRequestError(w, err)
+		return
+	}
+
+	s.metrics.checks.Inc()
+	for _, match := range resp.Matches {
+		s.metrics.observeMatch(match.Distance)
+	}
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// runCheck validates a check request and answers it from db with at most
+// limit matches, however many the request asks for. It is shared by the
+// HTTP server and the Unix socket daemon.
+func runCheck(ctx context.Context, db *celestlsh.Database, req checkRequest, limit int) (checkResponse, error) {
+	if req.TLSH == "" {
+		return checkResponse{}, badRequest("tlsh is required")
+	}
+
+	maxDistance, top := -1, 1
+	if req.MaxDistance != nil {
+		if *req.MaxDistance < 0 {
+			return checkResponse{}, badRequest("max_distance must not be negative")
+		}
+		maxDistance = *req.MaxDistance
+	}
+	if req.Top != nil {
+		if *req.Top < 1 {
+			return checkResponse{}, badRequest("top must be at least 1")
+		}
+		top = *req.Top
+	}
+
+	c := celestlsh.NewCollector(min(top, limit))
+	if err := db.Collect(ctx, req.TLSH, maxDistance, c); err != nil {
+		return checkResponse{}, err
+	}
+
+	resp := checkResponse{TLSH: req.TLSH, Matches: c.Records()}
+	if top > limit {
+		resp.Truncated = c.Dropped()
+	}
+	return resp, nil
+}
+
+func (s *server) handleHash(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxHashBodyBytes)
+
+	body := io.Reader(r.Body)
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if strings.HasPrefix(mediaType, "multipart/") {
+		part, err := multipartFile(r)
+		if err != nil {
+			s.writeRequestError(w, err)
+			return
+		}
+		defer part.Close()
+		body = part
+	}
+
+	hash, err := s.hasher.HashReader(r.Context(), body)
+	if err != nil {
+		s.writeRequestError(w, err)
+		return
+	}
+
+	s.metrics.hashes.Inc()
+
+	writeJSON(w, http.StatusOK, hashResponse{TLSH: hash})
+}
+
+func (s *server) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{
+		"status":  "ok",
+		"records": s.db.get().Len(),
+	})
+}
+
+// multipartFile returns the first file part of a multipart upload.
+func multipartFile(r *http.Request) (io.ReadCloser, error) {
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return nil, badRequest("invalid multipart body: %w", err)
+	}
+
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			return nil, badRequest("multipart body contains no file")
+		}
+		if err != nil {
+			return nil, badRequest("invalid multipart body: %w", err)
+		}
+		if part.FileName() != "" {
+			return part, nil
+		}
+		part.Close()
+	}
+}
+
+func (s *server) writeRequestError(w http.ResponseWriter, err error) {
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		writeError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("request body exceeds %d bytes", maxBytesErr.Limit))
+		return
+	}
+
+	writeError(w, s.errorStatus(err), err.Error())
+}
+
+// errorStatus returns the status code of a request that failed with err.
+// Errors the client caused, including a truncated upload, are 400; a
+// request cancelled by its client is 499, or by the server shutting down
+// 503; one that ran out of time is 504; anything else is a failure of the
+// server.
+func (s *server) errorStatus(err error) int {
+	var reqErr *requestError
+	var hashingErr *celestlsh.HashingError
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return http.StatusGatewayTimeout
+	case errors.Is(err, context.Canceled):
+		if s.stopping != nil && s.stopping.Err() != nil {
+			return http.StatusServiceUnavailable
+		}
+		return statusClientClosedRequest
+	case errors.As(err, &reqErr), errors.Is(err, celestlsh.ErrInvalidHash), errors.As(err, &hashingErr),
+		errors.Is(err, io.ErrUnexpectedEOF):
+		return http.StatusBadRequest
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, errorResponse{Error: msg})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Magonia-Research/CelesTLSH-CLI/pkg/celestlsh"
+)
+
+// newTestServer serves the test database with the handlers of serve mode,
+// configured by extra flags.
+func newTestServer(t *testing.T, extra ...string) (*server, *httptest.Server) {
+	t.Helper()
+	args := append([]string{"--serve", "--quiet", "--db", writeTestDatabase(t)}, extra...)
+	config := parseTestFlags(t, args...)
+	db, err := newLiveDatabase(context.Background(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := newServer(db)
+	ts := httptest.NewServer(s.routes())
+	t.Cleanup(ts.Close)
+	return s, ts
+}
+
+func postJSON(t *testing.T, url, body string) (int, map[string]any) {
+	t.Helper()
+	resp, err := http.Post(url, "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var v map[string]any
+	if err := json.NewDecoder(resp.Body).Decode(&v); err != nil {
+		t.Fatalf("decoding response: %v", err)
+	}
+	return resp.StatusCode, v
+}
+
+func TestServeCheck(t *testing.T) {
+	_, ts := newTestServer(t)
+	records := testRecords(t)
+	hash := records[0].TLSHHash
+
+	tests := []struct {
+		name    string
+		body    string
+		files   []string
+		closest int
+	}{
+		{"closest only", fmt.Sprintf(`{"tlsh":%q}`, hash), []string{"known.exe"}, 0},
+		{"T1 prefix", fmt.Sprintf(`{"tlsh":%q}`, celestlsh.WithT1Prefix(hash)), []string{"known.exe"}, 0},
+		{"top", fmt.Sprintf(`{"tlsh":%q,"top":2}`, hash), []string{"known.exe", "known-variant.exe"}, 0},
+		{"max distance", fmt.Sprintf(`{"tlsh":%q,"top":10,"max_distance":100}`, hash), []string{"known.exe", "known-variant.exe"}, 0},
+		{"nothing close", fmt.Sprintf(`{"tlsh":%q,"max_distance":0}`, testHash(t, sampleData(9, 8192))), nil, -1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := http.Post(ts.URL+"/check", "application/json", strings.NewReader(tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				t.Fatalf("status = %d, want 200", resp.StatusCode)
+			}
+			if ct := resp.Header.Get("Content-Type"); ct != "application/json" {
+				t.Errorf("Content-Type = %q", ct)
+			}
+			var got checkResponse
+			if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+				t.Fatal(err)
+			}
+			var files []string
+			for _, m := range got.Matches {
+				files = append(files, m.FileName)
+			}
+			if fmt.Sprint(files) != fmt.Sprint(tt.files) {
+				t.Errorf("matches = %v, want %v", files, tt.files)
+			}
+			if tt.closest >= 0 && got.Matches[0].Distance != tt.closest {
+				t.Errorf("closest distance = %d, want %d", got.Matches[0].Distance, tt.closest)
+			}
+		})
+	}
+}
+
+func TestServeCheckTruncated(t *testing.T) {
+	_, ts := newTestServer(t, "--limit", "2")
+	status, v := postJSON(t, ts.URL+"/check", fmt.Sprintf(`{"tlsh":%q,"top":10}`, testRecords(t)[0].TLSHHash))
+	if status != http.StatusOK || len(v["matches"].([]any)) != 2 || v["truncated"] != 2.0 {
+		t.Errorf("status %d, response %v; want 2 matches with 2 truncated", status, v)
+	}
+}
+
+func TestServeErrors(t *testing.T) {
+	_, ts := newTestServer(t)
+	hash := testRecords(t)[0].TLSHHash
+
+	tests := []struct {
+		name   string
+		path   string
+		body   string
+		status int
+		msg    string
+	}{
+		{"invalid JSON", "/check", `{"tlsh":`, http.StatusBadRequest, "invalid JSON body"},
+		{"missing tlsh", "/check", `{}`, http.StatusBadRequest, "tlsh is required"},
+		{"invalid hash", "/check", `{"tlsh":"abc"}`, http.StatusBadRequest, "error parsing input hash"},
+		{"negative distance", "/check", fmt.Sprintf(`{"tlsh":%q,"max_distance":-1}`, hash), http.StatusBadRequest, "max_distance must not be negative"},
+		{"zero top", "/check", fmt.Sprintf(`{"tlsh":%q,"top":0}`, hash), http.StatusBadRequest, "top must be at least 1"},
+		{"oversized check", "/check", `{"tlsh":"` + strings.Repeat("a", maxCheckBodyBytes) + `"}`, http.StatusRequestEntityTooLarge, "request body exceeds"},
+		{"too little to hash", "/hash", "short", http.StatusBadRequest, "below the TLSH minimum"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, v := postJSON(t, ts.URL+tt.path, tt.body)
+			if status != tt.status {
+				t.Errorf("status = %d, want %d", status, tt.status)
+			}
+			if msg, _ := v["error"].(string); !strings.Contains(msg, tt.msg) {
+				t.Errorf("error = %q, want it to contain %q", msg, tt.msg)
+			}
+		})
+	}
+
+	resp, err := http.Get(ts.URL + "/check")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("GET /check status = %d, want 405", resp.StatusCode)
+	}
+}
+
+func TestServeErrorStatus(t *testing.T) {
+	stopping, stop := context.WithCancel(context.Background())
+	s := &server{stopping: stopping}
+
+	tests := []struct {
+		name   string
+		err    error
+		status int
+	}{
+		{"bad request", badRequest("tlsh is required"), http.StatusBadRequest},
+		{"invalid hash", &celestlsh.HashError{Err: errors.New("x")}, http.StatusBadRequest},
+		{"unhashable", &celestlsh.HashingError{Err: errors.New("x")}, http.StatusBadRequest},
+		{"truncated upload", &celestlsh.FileError{Op: "reading file", Err: io.ErrUnexpectedEOF}, http.StatusBadRequest},
+		{"client gone", fmt.Errorf("checking: %w", context.Canceled), statusClientClosedRequest},
+		{"deadline", &celestlsh.FileError{Op: "reading file", Err: context.DeadlineExceeded}, http.StatusGatewayTimeout},
+		{"internal", errors.New("disk on fire"), http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		if got := s.errorStatus(tt.err); got != tt.status {
+			t.Errorf("%s: status = %d, want %d", tt.name, got, tt.status)
+		}
+	}
+
+	stop()
+	if got := s.errorStatus(context.Canceled); got != http.StatusServiceUnavailable {
+		t.Errorf("cancelled at shutdown: status = %d, want 503", got)
+	}
+}
+
+func TestServeCancelledRequest(t *testing.T) {
+	s, _ := newTestServer(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	req := httptest.NewRequestWithContext(ctx, http.MethodPost, "/hash", bytes.NewReader(testSample))
+	rec := httptest.NewRecorder()
+	s.routes().ServeHTTP(rec, req)
+	if rec.Code != statusClientClosedRequest {
+		t.Errorf("status = %d, want %d", rec.Code, statusClientClosedRequest)
+	}
+}
+
+func TestServeHash(t *testing.T) {
+	_, ts := newTestServer(t)
+	want := testRecords(t)[0].TLSHHash
+
+	status, v := postJSON(t, ts.URL+"/hash", string(testSample))
+	if status != http.StatusOK || v["tlsh"] != want {
+		t.Errorf("raw upload: status %d, response %v; want %s", status, v, want)
+	}
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	mw.WriteField("comment", "not the file")
+	fw, _ := mw.CreateFormFile("file", "known.exe")
+	fw.Write(testSample)
+	mw.Close()
+	resp, err := http.Post(ts.URL+"/hash", mw.FormDataContentType(), &body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var got hashResponse
+	json.NewDecoder(resp.Body).Decode(&got)
+	if resp.StatusCode != http.StatusOK || got.TLSH != want {
+		t.Errorf("multipart upload: status %d, hash %s; want %s", resp.StatusCode, got.TLSH, want)
+	}
+
+	body.Reset()
+	mw = multipart.NewWriter(&body)
+	mw.WriteField("comment", "no file at all")
+	mw.Close()
+	resp, err = http.Post(ts.URL+"/hash", mw.FormDataContentType(), &body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("multipart upload without a file: status %d, want 400", resp.StatusCode)
+	}
+}
+
+func TestServeHealthz(t *testing.T) {
+	_, ts := newTestServer(t)
+	resp, err := http.Get(ts.URL + "/healthz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var v map[string]any
+	json.NewDecoder(resp.Body).Decode(&v)
+	if resp.StatusCode != http.StatusOK || v["status"] != "ok" || v["records"] != 4.0 {
+		t.Errorf("status %d, response %v; want ok with 4 records", resp.StatusCode, v)
+	}
+}
+
+func TestServeShutdown(t *testing.T) {
+	config := parseTestFlags(t, "--serve", "--quiet", "--listen", "127.0.0.1:0", "--db", writeTestDatabase(t))
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- executeServe(ctx, config) }()
+
+	time.Sleep(100 * time.Millisecond)
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("executeServe = %v, want a clean shutdown", err)
+		}
+	case <-time.After(shutdownTimeout):
+		t.Fatal("server did not shut down")
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/Magonia-Research/CelesTLSH-CLI/pkg/celestlsh"
+)
+
+// publishingKey is the minisign public key the database is verified with
+// when --pubkey is not given, set at build time with
+// -ldflags "-X main.publishingKey=<base64 key>". It is empty by default.
+var publishingKey = ""
+
+// maxSignatureSize bounds a signature file, which is a few hundred bytes.
+const maxSignatureSize = 64 << 10
+
+// signaturePath is where the signature of a downloaded database is kept,
+// for --require-signed.
+func signaturePath(dbPath string) string {
+	return dbPath + ".minisig"
+}
+
+// publicKey returns the key given with --pubkey, as a file or the key
+// itself, or else the embedded publishing key.
+func publicKey(config Config) (*celestlsh.PublicKey, error) {
+	text := config.PublicKey
+	if text == "" {
+		text = publishingKey
+	} else if data, err := os.ReadFile(text); err == nil {
+		text = string(data)
+	}
+	if text == "" {
+		return nil, errors.New("no public key to verify the database with; give one with --pubkey")
+	}
+	return celestlsh.ParsePublicKey(text)
+}
+
+// fetchSignature reads the signature at location, a file or an http(s)
+// URL fetched like the database.
+func fetchSignature(ctx context.Context, d *celestlsh.Downloader, location string) ([]byte, error) {
+	if !strings.HasPrefix(location, "https://") && !strings.HasPrefix(location, "http://") {
+		data, err := os.ReadFile(location)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read signature: %w", err)
+		}
+		return data, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, location, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download signature: %w", &celestlsh.RequestError{URL: location, Err: err})
+	}
+	for name, values := range d.Header {
+		req.Header[name] = append(req.Header[name], values...)
+	}
+	resp, err := d.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download signature: %w", &celestlsh.RequestError{URL: location, Err: err})
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to download signature: %w", &celestlsh.StatusError{URL: location, StatusCode: resp.StatusCode})
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxSignatureSize))
+	if err != nil {
+		return nil, fmt.Errorf("failed to download signature: %w", &celestlsh.RequestError{URL: location, Err: err})
+	}
+	return data, nil
+}
+
+// verifyDownload arranges for the database downloaded by d to be verified
+// against the signature at --signature, or beside the database URL,
+// before it replaces the old one. The signature is then saved next to the
+// database for --require-signed.
+func verifyDownload(ctx context.Context, config Config, d *celestlsh.Downloader) error {
+	key, err := publicKey(config)
+	if err != nil {
+		return err
+	}
+	location := config.Signature
+	if location == "" {
+		location = signaturePath(d.URL)
+	}
+	data, err := fetchSignature(ctx, d, location)
+	if err != nil {
+		return err
+	}
+	sig, err := celestlsh.ParseSignature(data)
+	if err != nil {
+		return err
+	}
+
+	d.Verify = func(path string) error {
+		db, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if err := key.Verify(db, sig); err != nil {
+			return err
+		}
+		if err := os.WriteFile(signaturePath(config.DbPath), data, 0644); err != nil {
+			return fmt.Errorf("failed to save signature: %w", err)
+		}
+		slog.Info("database signature verified", "signature", location, "comment", sig.TrustedComment)
+		return nil
+	}
+	return nil
+}
+
+// loadVerifiedDatabase loads the database, first checking it against the
+// signature saved when it was downloaded if --require-signed is given. The
+// verified bytes are the ones parsed, so the file cannot change in
+// between. The embedded snapshot, when used, is trusted as part of the
+// binary.
+func loadVerifiedDatabase(ctx context.Context, config Config, filters ...celestlsh.Filter) (*celestlsh.Database, error) {
+	if useEmbedded(config) {
+		return loadEmbeddedDatabase(ctx, config, filters...)
+	}
+	if config.DbPath == stdinDatabase {
+		return loadStdinDatabase(ctx, filters...)
+	}
+	if !config.RequireSigned {
+		return celestlsh.Load(ctx, config.DbPath, filters...)
+	}
+
+	key, err := publicKey(config)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(signaturePath(config.DbPath))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, &celestlsh.SignatureError{Err: fmt.Errorf("%s has no recorded signature; download it with --signature or --pubkey", config.DbPath)}
+	}
+	if err != nil {
+		return nil, err
+	}
+	sig, err := celestlsh.ParseSignature(data)
+	if err != nil {
+		return nil, err
+	}
+	db, err := os.ReadFile(config.DbPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, &celestlsh.DatabaseNotFoundError{Path: config.DbPath}
+	}
+	if err != nil {
+		return nil, &celestlsh.FileError{Op: "opening database file", Path: config.DbPath, Err: err}
+	}
+	if err := key.Verify(db, sig); err != nil {
+		return nil, err
+	}
+	return celestlsh.LoadReader(ctx, bytes.NewReader(db), filters...)
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Magonia-Research/CelesTLSH-CLI/pkg/celestlsh"
+)
+
+// testKeyID is the minisign key ID of the keys of newTestSigner.
+var testKeyID = []byte{0x17, 0xb8, 0xe4, 0xd2, 0xa5, 0xf0, 0x1c, 0xc3}
+
+// newTestSigner generates a minisign key pair and returns its public key,
+// as a .pub file would hold it, and a function signing data with it in the
+// legacy format of minisign -S -l.
+func newTestSigner(t *testing.T) (pub string, sign func(data []byte) []byte) {
+	t.Helper()
+	pubKey, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub = "untrusted comment: minisign public key\n" +
+		base64.StdEncoding.EncodeToString(append(append([]byte("Ed"), testKeyID...), pubKey...)) + "\n"
+	return pub, func(data []byte) []byte {
+		sig := ed25519.Sign(priv, data)
+		comment := "timestamp:1700000000\tfile:db.csv"
+		global := ed25519.Sign(priv, append(append([]byte(nil), sig...), comment...))
+		return []byte("untrusted comment: signature from minisign secret key\n" +
+			base64.StdEncoding.EncodeToString(append(append([]byte("Ed"), testKeyID...), sig...)) + "\n" +
+			"trusted comment: " + comment + "\n" +
+			base64.StdEncoding.EncodeToString(global) + "\n")
+	}
+}
+
+func TestDownloadSigned(t *testing.T) {
+	pub, sign := newTestSigner(t)
+	dir := t.TempDir()
+	pubPath := writeFile(t, dir, "celestlsh.pub", []byte(pub))
+	database, err := os.ReadFile(writeTestDatabase(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	signature := sign(database)
+	forged := sign([]byte("another database"))
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/db.csv":
+			w.Write(database)
+		case "/db.csv.minisig":
+			w.Write(signature)
+		case "/forged.minisig":
+			w.Write(forged)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+
+	download := func(config Config) error {
+		d := celestlsh.NewDownloader()
+		d.URL = ts.URL + "/db.csv"
+		if err := verifyDownload(context.Background(), config, d); err != nil {
+			return err
+		}
+		return d.Download(context.Background(), config.DbPath)
+	}
+
+	// The signature beside the database URL is used and saved.
+	dbPath := filepath.Join(dir, "db.csv")
+	if err := download(Config{DbPath: dbPath, PublicKey: pubPath}); err != nil {
+		t.Fatal(err)
+	}
+	if saved, _ := os.ReadFile(signaturePath(dbPath)); string(saved) != string(signature) {
+		t.Errorf("saved signature:\n%s\nwant:\n%s", saved, signature)
+	}
+
+	// A signature that does not match leaves the existing database alone.
+	os.WriteFile(dbPath, []byte("old database\n"), 0644)
+	err = download(Config{DbPath: dbPath, PublicKey: pubPath, Signature: ts.URL + "/forged.minisig"})
+	if err == nil || !strings.Contains(err.Error(), "signature does not match the file") {
+		t.Errorf("download with a forged signature = %v", err)
+	}
+	if got, _ := os.ReadFile(dbPath); string(got) != "old database\n" {
+		t.Errorf("database after a failed verification = %q, want the old one", got)
+	}
+
+	// So does a signature that cannot be fetched, before any download.
+	err = download(Config{DbPath: dbPath, PublicKey: pubPath, Signature: ts.URL + "/missing.minisig"})
+	if err == nil || !strings.Contains(err.Error(), "failed to download signature") {
+		t.Errorf("download with a missing signature = %v", err)
+	}
+	err = download(Config{DbPath: dbPath})
+	if err == nil || !strings.Contains(err.Error(), "no public key") {
+		t.Errorf("download without a key = %v", err)
+	}
+}
+
+func TestRequireSigned(t *testing.T) {
+	pub, sign := newTestSigner(t)
+	pubPath := writeFile(t, t.TempDir(), "celestlsh.pub", []byte(pub))
+	hash := testRecords(t)[0].TLSHHash
+
+	signed := writeTestDatabase(t)
+	data, err := os.ReadFile(signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, filepath.Dir(signed), filepath.Base(signaturePath(signed)), sign(data))
+
+	unsigned := writeTestDatabase(t)
+	tampered := writeTestDatabase(t)
+	writeFile(t, filepath.Dir(tampered), filepath.Base(signaturePath(tampered)), sign(data))
+	f, err := os.OpenFile(tampered, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString("EvilTool,evil.exe,v1,T1" + strings.Repeat("A", 70) + "," + strings.Repeat("cd", 32) + ",2024-03-01,\n")
+	f.Close()
+
+	_, _, st, err := runCLI(t, "-c", "--require-signed", "--pubkey", pubPath, "--db", signed, hash)
+	if err != nil || st != statusMatch {
+		t.Errorf("signed database: status %v, error %v", st, err)
+	}
+	// The key itself, rather than a file, is accepted too.
+	key := strings.Split(pub, "\n")[1]
+	if _, _, _, err := runCLI(t, "-c", "--require-signed", "--pubkey", key, "--db", signed, hash); err != nil {
+		t.Errorf("signed database, key given inline: %v", err)
+	}
+
+	tests := []struct {
+		db   string
+		want string
+	}{
+		{unsigned, "has no recorded signature"},
+		{tampered, "signature does not match the file"},
+	}
+	for _, tt := range tests {
+		_, _, _, err := runCLI(t, "-c", "--require-signed", "--pubkey", pubPath, "--db", tt.db, hash)
+		if err == nil || !strings.Contains(err.Error(), tt.want) || errorCode(err) != codeBadSignature {
+			t.Errorf("%s: error %v (code %s), want %q and %s", filepath.Base(tt.db), err, errorCode(err), tt.want, codeBadSignature)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		in   string
+		want int64
+	}{
+		{"0", 0},
+		{"1234", 1234},
+		{"12B", 12},
+		{"1k", 1 << 10},
+		{"1K", 1 << 10},
+		{"1KB", 1 << 10},
+		{"1KiB", 1 << 10},
+		{"500M", 500 << 20},
+		{"500 MB", 500 << 20},
+		{"2G", 2 << 30},
+		{"2gib", 2 << 30},
+		{"3T", 3 << 40},
+		{" 7m ", 7 << 20},
+	}
+	for _, tt := range tests {
+		got, err := parseSize(tt.in)
+		if err != nil || got != tt.want {
+			t.Errorf("parseSize(%q) = %d, %v; want %d", tt.in, got, err, tt.want)
+		}
+	}
+
+	for _, in := range []string{"", "M", "-1", "1.5G", "12X", "1MM", "9999999T", "9223372036854775807K"} {
+		if got, err := parseSize(in); err == nil {
+			t.Errorf("parseSize(%q) = %d, want an error", in, got)
+		}
+	}
+}
+
+func TestFormatSize(t *testing.T) {
+	tests := []struct {
+		n    int64
+		want string
+	}{
+		{0, "0 B"},
+		{1023, "1023 B"},
+		{1024, "1.0 KiB"},
+		{1536, "1.5 KiB"},
+		{500 << 20, "500.0 MiB"},
+		{2 << 30, "2.0 GiB"},
+		{3 << 50, "3072.0 TiB"},
+	}
+	for _, tt := range tests {
+		if got := formatSize(tt.n); got != tt.want {
+			t.Errorf("formatSize(%d) = %q, want %q", tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestScanMaxFileSize(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "small.bin", sampleData(1, 4096))
+	big := writeFile(t, dir, "big.bin", sampleData(2, 3<<20))
+
+	var log bytes.Buffer
+	old := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(&log, nil)))
+	defer slog.SetDefault(old)
+
+	out, _, _, err := runCLI(t, "-s", "--jsonl", "--max-file-size", "2M", "--db", writeTestDatabase(t), dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	results, summary := parseJSONL(t, out)
+	if len(results) != 1 || strings.HasSuffix(results[0].Path, "big.bin") || summary.Oversized != 1 {
+		t.Errorf("results %+v, %d oversized; want only small.bin scanned", results, summary.Oversized)
+	}
+	if !strings.Contains(log.String(), "path="+big) || !strings.Contains(log.String(), "3.0 MiB exceeds --max-file-size") {
+		t.Errorf("log %q does not list the skipped file", log.String())
+	}
+
+	// 0 is no limit.
+	out, _, _, err = runCLI(t, "-s", "--jsonl", "--max-file-size", "0", "--db", writeTestDatabase(t), dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results, summary := parseJSONL(t, out); len(results) != 2 || summary.Oversized != 0 {
+		t.Errorf("with no limit, %d results and %d oversized, want 2 and 0", len(results), summary.Oversized)
+	}
+}
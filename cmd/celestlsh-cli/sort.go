@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Magonia-Research/CelesTLSH-CLI/pkg/celestlsh"
+)
+
+// sortFields are the fields --sort accepts, in the order they are listed
+// in errors.
+var sortFields = []string{"distance", "repo", "file", "date", "version"}
+
+// sortKey is one field of --sort, descending if it had a - prefix.
+type sortKey struct {
+	field string
+	desc  bool
+}
+
+// parseSort parses --sort, a comma-separated list of fields each with an
+// optional - prefix for descending order.
+func parseSort(s string) ([]sortKey, error) {
+	var keys []sortKey
+	for _, field := range strings.Split(s, ",") {
+		field = strings.TrimSpace(field)
+		key := sortKey{field: strings.TrimPrefix(field, "-"), desc: strings.HasPrefix(field, "-")}
+		valid := false
+		for _, f := range sortFields {
+			valid = valid || key.field == f
+		}
+		if !valid {
+			return nil, fmt.Errorf("unknown field %q; use %s, each with an optional - prefix for descending order", field, strings.Join(sortFields, ", "))
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// sortRepos orders the repositories of a --group-by-repo result by keys.
+// The sort is stable, so repositories equal on every key keep their order,
+// closest first. Without keys the order is left alone.
+func sortRepos(keys []sortKey, repos []celestlsh.RepoMatch) {
+	if len(keys) == 0 {
+		return
+	}
+	sort.SliceStable(repos, func(i, j int) bool {
+		return compareRecords(keys, &repos[i].HashRecord, &repos[j].HashRecord) < 0
+	})
+}
+
+// sortMatches orders the records listed by --top by keys, like sortRepos.
+func sortMatches(keys []sortKey, matches []celestlsh.HashRecord) {
+	if len(keys) == 0 {
+		return
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		return compareRecords(keys, &matches[i], &matches[j]) < 0
+	})
+}
+
+// compareRecords compares two records on each key in turn, returning a
+// negative number if a sorts first, a positive one if b does, and 0 if
+// they are equal on every key.
+func compareRecords(keys []sortKey, a, b *celestlsh.HashRecord) int {
+	for _, key := range keys {
+		var c int
+		switch key.field {
+		case "distance":
+			c = a.Distance - b.Distance
+		case "repo":
+			c = strings.Compare(a.RepoName, b.RepoName)
+		case "file":
+			c = strings.Compare(a.FileName, b.FileName)
+		case "date":
+			c = compareDates(a.DateAdded, b.DateAdded)
+		case "version":
+			c = compareVersions(a.Version, b.Version)
+		}
+		if key.desc {
+			c = -c
+		}
+		if c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+// compareDates compares two Date Added values as times. Dates that cannot
+// be parsed sort after those that can, as if newest, by their text.
+func compareDates(a, b string) int {
+	ta, errA := celestlsh.ParseDate(a)
+	tb, errB := celestlsh.ParseDate(b)
+	switch {
+	case errA == nil && errB == nil:
+		return ta.Compare(tb)
+	case errA == nil:
+		return -1
+	case errB == nil:
+		return 1
+	default:
+		return strings.Compare(a, b)
+	}
+}
+
+// compareVersions compares two versions piece by piece, runs of digits
+// numerically and anything else as text, so that 4.10 sorts after 4.9.
+func compareVersions(a, b string) int {
+	pa, pb := versionPieces(a), versionPieces(b)
+	for i := 0; i < len(pa) && i < len(pb); i++ {
+		na, errA := strconv.Atoi(pa[i])
+		nb, errB := strconv.Atoi(pb[i])
+		var c int
+		if errA == nil && errB == nil {
+			c = na - nb
+		} else {
+			c = strings.Compare(pa[i], pb[i])
+		}
+		if c != 0 {
+			return c
+		}
+	}
+	return len(pa) - len(pb)
+}
+
+// versionPieces splits a version into runs of digits and runs of anything
+// else, such as "v4.10rc1" into "v", "4", ".", "10", "rc", "1".
+func versionPieces(v string) []string {
+	var pieces []string
+	start := 0
+	for i := 1; i <= len(v); i++ {
+		if i == len(v) || isDigit(v[i]) != isDigit(v[i-1]) {
+			pieces = append(pieces, v[start:i])
+			start = i
+		}
+	}
+	return pieces
+}
+
+func isDigit(c byte) bool { return c >= '0' && c <= '9' }
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/Magonia-Research/CelesTLSH-CLI/pkg/celestlsh"
+)
+
+func TestParseSort(t *testing.T) {
+	keys, err := parseSort("repo, -date,distance")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []sortKey{{"repo", false}, {"date", true}, {"distance", false}}
+	if len(keys) != len(want) {
+		t.Fatalf("keys = %v, want %v", keys, want)
+	}
+	for i := range keys {
+		if keys[i] != want[i] {
+			t.Errorf("keys = %v, want %v", keys, want)
+		}
+	}
+
+	for _, s := range []string{"size", "repo,", "--repo", "Repo"} {
+		_, err := parseSort(s)
+		if err == nil || !strings.Contains(err.Error(), "use distance, repo, file, date, version") {
+			t.Errorf("parseSort(%q) = %v, want an error listing the fields", s, err)
+		}
+	}
+}
+
+func TestSortRepos(t *testing.T) {
+	repo := func(name, version, date string, distance int) celestlsh.RepoMatch {
+		return celestlsh.RepoMatch{HashRecord: celestlsh.HashRecord{
+			RepoName:  name,
+			FileName:  strings.ToLower(name) + ".exe",
+			Version:   version,
+			DateAdded: date,
+			Distance:  distance,
+		}}
+	}
+	repos := func() []celestlsh.RepoMatch {
+		// Closest first, as NearestRepos returns them.
+		return []celestlsh.RepoMatch{
+			repo("Echo", "v2.0", "2024-03-01", 10),
+			repo("Alpha", "v10.1", "2024/01/15", 20),
+			repo("Delta", "v2.0", "", 20),
+			repo("Bravo", "v9", "2024-03-01T10:00:00Z", 20),
+			repo("Charlie", "v2.0", "2023-12-31", 30),
+		}
+	}
+	tests := []struct {
+		sort string
+		want string
+	}{
+		{"distance", "Echo Alpha Delta Bravo Charlie"},
+		{"-distance", "Charlie Alpha Delta Bravo Echo"},
+		{"repo", "Alpha Bravo Charlie Delta Echo"},
+		{"-distance,repo", "Charlie Alpha Bravo Delta Echo"},
+		// Equal versions keep their order, closest first.
+		{"version", "Echo Delta Charlie Bravo Alpha"},
+		{"-version,-repo", "Alpha Bravo Echo Delta Charlie"},
+		// Undated records sort last, as if newest.
+		{"date", "Charlie Alpha Echo Bravo Delta"},
+		{"-date", "Delta Bravo Echo Alpha Charlie"},
+		{"version,-distance", "Charlie Delta Echo Bravo Alpha"},
+	}
+	for _, tt := range tests {
+		keys, err := parseSort(tt.sort)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := repos()
+		sortRepos(keys, got)
+		var names []string
+		for _, r := range got {
+			names = append(names, r.RepoName)
+		}
+		if strings.Join(names, " ") != tt.want {
+			t.Errorf("--sort %s = %v, want %s", tt.sort, names, tt.want)
+		}
+	}
+}
+
+func TestCheckSortFormats(t *testing.T) {
+	records := testRecords(t)
+	records[0].Version = "v1.10"
+	records[2].Version = "v1.9"
+	records[3].Version = "v1.10"
+	db := writeTestDatabase(t, records...)
+	hash := records[0].TLSHHash
+	want := []string{"KnownTool", "ThirdTool", "OtherTool"}
+
+	check := func(format string) string {
+		out, _, _, err := runCLI(t, "-c", "--group-by-repo", "--max-distance", "-1", "--sort", "-version,repo", format, "--db", db, hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return out
+	}
+
+	var csvRepos []string
+	for _, row := range readCSV(t, check("--csv")) {
+		csvRepos = append(csvRepos, row[0])
+	}
+	if strings.Join(csvRepos, ",") != strings.Join(want, ",") {
+		t.Errorf("CSV repos = %q, want %q", csvRepos, want)
+	}
+
+	var results []checkResult
+	if err := json.Unmarshal([]byte(check("--json")), &results); err != nil || len(results) != 1 {
+		t.Fatalf("parsing JSON output: %v, %d results", err, len(results))
+	}
+	result := results[0]
+	var jsonRepos []string
+	for _, r := range result.Repos {
+		jsonRepos = append(jsonRepos, r.RepoName)
+	}
+	if strings.Join(jsonRepos, ",") != strings.Join(want, ",") {
+		t.Errorf("JSON repos = %q, want %q", jsonRepos, want)
+	}
+	// The best match stays the closest, whatever the order.
+	if result.Match == nil || result.Match.FileName != "known.exe" {
+		t.Errorf("JSON match = %+v, want known.exe", result.Match)
+	}
+
+	text := check("--no-color")
+	last := -1
+	for _, repo := range want {
+		i := strings.Index(text, repo)
+		if i < last {
+			t.Errorf("text output lists %s out of order:\n%s", repo, text)
+		}
+		last = i
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+// The SQLite files of --results-db and --hash-cache, through the pure-Go
+// modernc.org/sqlite driver, which needs no cgo.
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	_ "modernc.org/sqlite"
+)
+
+// openSQLite opens the SQLite file at path, creating it if it does not
+// exist, and brings its schema up to date with migrations.
+//
+// Several runs can use the same file at once: it is kept in WAL mode so
+// that readers do not wait for a writer, transactions take the write lock
+// as they begin, and a run waits up to ten seconds for another to finish
+// writing.
+func openSQLite(ctx context.Context, path string, migrations []string) (*sql.DB, error) {
+	db, err := sql.Open("sqlite", path+"?_pragma=busy_timeout(10000)&_pragma=journal_mode(WAL)&_txlock=immediate")
+	if err != nil {
+		return nil, err
+	}
+	// SQLite allows one writer at a time, so one connection is all a run
+	// needs.
+	db.SetMaxOpenConns(1)
+
+	if err := migrateSQLite(ctx, db, migrations); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// migrateSQLite applies the migrations db has not had yet, one step per
+// version, recording them in PRAGMA user_version. Steps are only ever
+// appended, so that older files can be brought forward; a file from a
+// newer version of the tool is refused. Each step reads the version again
+// in its own transaction, so that of two runs creating a file at once,
+// only one applies it.
+func migrateSQLite(ctx context.Context, db *sql.DB, migrations []string) error {
+	for {
+		done, err := migrateSQLiteStep(ctx, db, migrations)
+		if done || err != nil {
+			return err
+		}
+	}
+}
+
+func migrateSQLiteStep(ctx context.Context, db *sql.DB, migrations []string) (done bool, err error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	var version int
+	if err := tx.QueryRowContext(ctx, "PRAGMA user_version").Scan(&version); err != nil {
+		return false, err
+	}
+	if version > len(migrations) {
+		return false, fmt.Errorf("schema version %d is newer than this version of the tool supports", version)
+	}
+	if version == len(migrations) {
+		return true, nil
+	}
+	if _, err := tx.ExecContext(ctx, migrations[version]); err != nil {
+		return false, err
+	}
+	// PRAGMA does not take parameters.
+	if _, err := tx.ExecContext(ctx, fmt.Sprintf("PRAGMA user_version = %d", version+1)); err != nil {
+		return false, err
+	}
+	return false, tx.Commit()
+}
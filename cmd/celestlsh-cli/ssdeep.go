@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/Magonia-Research/CelesTLSH-CLI/pkg/ssdeep"
+)
+
+// executeSSDeepCompare prints the ssdeep similarity of two hashes or
+// files. It is separate from the database, which has no ssdeep column.
+func executeSSDeepCompare(config Config) error {
+	hash1, err := resolveSSDeepInput(config, config.Hash1)
+	if err != nil {
+		return err
+	}
+	hash2, err := resolveSSDeepInput(config, config.Hash2)
+	if err != nil {
+		return err
+	}
+
+	score, err := ssdeep.Compare(hash1, hash2)
+	if err != nil {
+		return fmt.Errorf("failed to compare ssdeep hashes: %w", err)
+	}
+
+	if config.Quiet {
+		fmt.Println(score)
+	} else {
+		fmt.Printf("ssdeep similarity: %d\n", score)
+	}
+	return nil
+}
+
+// resolveSSDeepInput returns the ssdeep hash for an --ssdeep-compare
+// argument: the argument itself if it parses as one, or else the hash of
+// the file it names, noted unless in quiet mode.
+func resolveSSDeepInput(config Config, arg string) (string, error) {
+	if _, err := ssdeep.Compare(arg, arg); err == nil {
+		return arg, nil
+	}
+	info, err := os.Stat(arg)
+	if err != nil || info.IsDir() {
+		return arg, nil
+	}
+
+	f, err := os.Open(arg)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := ssdeep.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", fmt.Errorf("%s: %w", arg, err)
+	}
+	hash := h.Sum()
+
+	if !config.Quiet {
+		fmt.Printf("ssdeep hash of %s: %s\n", arg, hash)
+	}
+	return hash, nil
+}
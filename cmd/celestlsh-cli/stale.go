@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultStaleAfter is the age at which check and scan modes warn that the
+// database needs refreshing.
+const defaultStaleAfter = 14 * 24 * time.Hour
+
+// parseStaleAfter parses --stale-after: a whole number of days such as
+// "14d", or a Go duration such as "36h".
+func parseStaleAfter(s string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n < 0 || n > 1<<20 {
+			return 0, fmt.Errorf("invalid number of days %q", s)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, err
+	}
+	if d < 0 {
+		return 0, errors.New("must not be negative")
+	}
+	return d, nil
+}
+
+// databaseAge returns how long ago the database at --db was downloaded,
+// as recorded in the metadata the download saved beside it, or failing
+// that as told by its modification time. It reports false for databases
+// with no age to give: those served by --remote or --grpc, piped to stdin,
+// embedded, or missing.
+func databaseAge(config Config) (time.Duration, bool) {
+	if remoteName(config) != "" || config.DbPath == stdinDatabase || useEmbedded(config) {
+		return 0, false
+	}
+	if data, err := os.ReadFile(dbMetaPath(config.DbPath)); err == nil {
+		var meta dbMeta
+		if json.Unmarshal(data, &meta) == nil {
+			if downloaded, err := time.Parse(time.RFC3339, meta.Downloaded); err == nil {
+				return max(time.Since(downloaded), 0), true
+			}
+		}
+	}
+	info, err := os.Stat(config.DbPath)
+	if err != nil {
+		return 0, false
+	}
+	return max(time.Since(info.ModTime()), 0), true
+}
+
+// checkStale compares the age of the database with --stale-after, warning
+// on stderr, unless --quiet, when it is older or, with --fail-if-stale,
+// failing. It returns the age for the JSON output, or -1 if unknown.
+func checkStale(config Config) (time.Duration, error) {
+	age, ok := databaseAge(config)
+	if !ok {
+		return -1, nil
+	}
+	if config.StaleAfter <= 0 || age <= config.StaleAfter {
+		return age, nil
+	}
+
+	msg := fmt.Sprintf("the database %s is %s, older than --stale-after %s; refresh it with: %s --download --db %s",
+		displayPath(config.DbPath), describeAge(age), describeStaleAfter(config.StaleAfter), filepath.Base(os.Args[0]), displayPath(config.DbPath))
+	if config.FailIfStale {
+		return age, errors.New(msg)
+	}
+	if !config.Quiet {
+		fmt.Fprintf(os.Stderr, "Warning: %s\n", msg)
+	}
+	return age, nil
+}
+
+// setDatabaseAge records age, as returned by checkStale, in summary.
+func (s *jsonlSummary) setDatabaseAge(config Config, age time.Duration) {
+	if age < 0 {
+		return
+	}
+	s.DatabaseAge = int64(age / time.Second)
+	s.DatabaseStale = config.StaleAfter > 0 && age > config.StaleAfter
+}
+
+// describeStaleAfter renders --stale-after in days when it is a whole
+// number of them.
+func describeStaleAfter(d time.Duration) string {
+	if d%(24*time.Hour) == 0 {
+		return fmt.Sprintf("%dd", d/(24*time.Hour))
+	}
+	return d.String()
+}
@@ -0,0 +1,54 @@
+package main
+
+// status classifies a run that completed without error, for main to map to
+// an exit code.
+type status int
+
+const (
+	// statusOK means the run succeeded and, in check, scan and procscan
+	// modes, that nothing matched.
+	statusOK status = iota
+	// statusMatch means a database record matched a checked hash or file.
+	statusMatch
+	// statusPartial means some inputs of a batch could not be processed.
+	statusPartial
+	// statusMismatch means a verified file is further from its expected
+	// hash than allowed. It shares the exit code of a match, the code
+	// scripts test to fail a check.
+	statusMismatch
+)
+
+// Exit codes, as documented in the README.
+const (
+	exitOK      = 0
+	exitError   = 1
+	exitMatch   = 2
+	exitPartial = 3
+	// exitInterrupted follows the shell convention of 128 plus the signal
+	// number of SIGINT.
+	exitInterrupted = 130
+)
+
+// batchStatus summarises a batch run. A match takes precedence over failed
+// inputs, so that a detection is never hidden behind an unreadable file.
+func batchStatus(matched, failed int) status {
+	switch {
+	case matched > 0:
+		return statusMatch
+	case failed > 0:
+		return statusPartial
+	default:
+		return statusOK
+	}
+}
+
+func (s status) exitCode() int {
+	switch s {
+	case statusMatch, statusMismatch:
+		return exitMatch
+	case statusPartial:
+		return exitPartial
+	default:
+		return exitOK
+	}
+}
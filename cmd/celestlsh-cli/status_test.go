@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// runMain runs the program with args in a child process and returns its
+// exit code and stderr.
+func runMain(t *testing.T, args ...string) (code int, stderr string) {
+	t.Helper()
+	cmd := exec.Command(os.Args[0], args...)
+	cmd.Env = append(os.Environ(), "CELESTLSH_TEST_MAIN=1", "CELESTLSH_NO_UPDATE_CHECK=1")
+	var errOut strings.Builder
+	cmd.Stderr = &errOut
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+	case errors.As(err, &exitErr):
+		code = exitErr.ExitCode()
+	default:
+		t.Fatal(err)
+	}
+	return code, errOut.String()
+}
+
+func TestExitCodes(t *testing.T) {
+	dir := t.TempDir()
+	db := writeTestDatabase(t)
+	records := testRecords(t)
+	known := writeFile(t, dir, "scan/known.exe", testSample)
+	other := writeFile(t, dir, "clean/other.bin", sampleData(5, 8192))
+	missing := filepath.Join(dir, "missing.exe")
+	unrelated := testHash(t, sampleData(6, 8192))
+
+	tests := []struct {
+		name string
+		args []string
+		want int
+	}{
+		{"hash", []string{"-h", known}, exitOK},
+		{"hash of several, one missing", []string{"-h", known, missing}, exitPartial},
+		{"hash of a missing file", []string{"-h", missing}, exitError},
+		{"check without a match", []string{"-c", "--max-distance", "30", "--db", db, unrelated}, exitOK},
+		{"check with a match", []string{"-c", "--db", db, records[0].TLSHHash}, exitMatch},
+		{"check of several, one invalid", []string{"-c", "--max-distance", "30", "--db", db, unrelated, "bogus"}, exitPartial},
+		{"check of several, one invalid, one matching", []string{"-c", "--db", db, records[0].TLSHHash, "bogus"}, exitMatch},
+		{"check of an invalid hash", []string{"-c", "--db", db, "bogus"}, exitError},
+		{"check without the database", []string{"-c", "--db", filepath.Join(dir, "none.csv"), unrelated}, exitError},
+		{"scan without a match", []string{"-s", "--max-distance", "30", "--db", db, filepath.Dir(other)}, exitOK},
+		{"scan with a match", []string{"-s", "--max-distance", "30", "--db", db, filepath.Dir(known)}, exitMatch},
+		// Without a threshold, the nearest record is no match unless it
+		// has some confidence.
+		{"check without a threshold, nothing close", []string{"-c", "--db", db, unrelated}, exitOK},
+		{"scan without a threshold, nothing close", []string{"-s", "--db", db, filepath.Dir(other)}, exitOK},
+		{"scan without a limit", []string{"-s", "--max-distance", "-1", "--db", db, filepath.Dir(other)}, exitMatch},
+		{"verify", []string{"--verify", known, records[0].TLSHHash}, exitOK},
+		{"verify a mismatch", []string{"--verify", other, records[0].TLSHHash}, exitMatch},
+		{"distance", []string{"-d", records[0].TLSHHash, records[1].TLSHHash}, exitOK},
+		// Without a mode the usage summary is printed as help.
+		{"no mode", nil, exitOK},
+		{"unknown flag", []string{"--no-such-flag"}, exitError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, stderr := runMain(t, tt.args...)
+			if code != tt.want {
+				t.Errorf("exit code %d, want %d; stderr:\n%s", code, tt.want, stderr)
+			}
+		})
+	}
+}
+
+func TestReportsWithoutThreshold(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "known.exe", testSample)
+	writeFile(t, dir, "other.bin", sampleData(5, 8192))
+	db := writeTestDatabase(t)
+
+	// other.bin is nearest to a record too far away to have any
+	// confidence, which neither fails a test case nor is a SARIF result.
+	out, _, st, err := runCLI(t, "-s", "--format", "junit", "--db", db, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st != statusMatch || !strings.Contains(out, `tests="2" failures="1"`) {
+		t.Errorf("JUnit report, status %v:\n%s\nwant known.exe alone failed", st, out)
+	}
+	out, _, _, err = runCLI(t, "-s", "--format", "sarif", "--db", db, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var log struct {
+		Runs []struct {
+			Results []json.RawMessage `json:"results"`
+		} `json:"runs"`
+	}
+	if err := json.Unmarshal([]byte(out), &log); err != nil {
+		t.Fatal(err)
+	}
+	if len(log.Runs) != 1 || len(log.Runs[0].Results) != 1 {
+		t.Errorf("SARIF log:\n%s\nwant one result, for known.exe", out)
+	}
+}
+
+func TestStatusExitCode(t *testing.T) {
+	for s, want := range map[status]int{
+		statusOK:       exitOK,
+		statusMatch:    exitMatch,
+		statusPartial:  exitPartial,
+		statusMismatch: exitMatch,
+	} {
+		if got := s.exitCode(); got != want {
+			t.Errorf("status %d: exit code %d, want %d", s, got, want)
+		}
+	}
+	for _, tt := range []struct {
+		matched, failed int
+		want            status
+	}{
+		{0, 0, statusOK},
+		{1, 0, statusMatch},
+		{0, 2, statusPartial},
+		{1, 2, statusMatch},
+	} {
+		if got := batchStatus(tt.matched, tt.failed); got != tt.want {
+			t.Errorf("batchStatus(%d, %d) = %d, want %d", tt.matched, tt.failed, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/Magonia-Research/CelesTLSH-CLI/pkg/celestlsh"
+)
+
+// stdinDatabase is the --db value that reads the database from stdin, for
+// piping one in from curl or a secrets store without a copy on disk.
+const stdinDatabase = "-"
+
+// loadStdinDatabase parses the database CSV piped to stdin, which is
+// gunzipped first if it starts with the gzip magic number. Stdin can only
+// be read once, so the database is loaded once and indexed in memory like
+// any other; the modes that reload --db reject "-".
+func loadStdinDatabase(ctx context.Context, filters ...celestlsh.Filter) (*celestlsh.Database, error) {
+	br := bufio.NewReader(os.Stdin)
+	var r io.Reader = br
+	if head, _ := br.Peek(len(gzipMagic)); bytes.Equal(head, gzipMagic) {
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, fmt.Errorf("database from stdin: %w", err)
+		}
+		defer zr.Close()
+		r = zr
+	}
+	db, err := celestlsh.LoadReader(ctx, r, filters...)
+	if err != nil {
+		return nil, fmt.Errorf("database from stdin: %w", err)
+	}
+	return db, nil
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"time"
+
+	"github.com/Magonia-Research/CelesTLSH-CLI/pkg/celestlsh"
+)
+
+// stixSCONamespace is the namespace STIX 2.1 defines for the UUIDv5
+// identifiers of cyber-observable objects.
+var stixSCONamespace = [16]byte{0x00, 0xab, 0xed, 0xb4, 0xaa, 0x42, 0x46, 0x6c, 0x9c, 0x01, 0xfe, 0xd2, 0x33, 0x15, 0xa9, 0xb7}
+
+// stixNamespace derives the identifiers of the indicators and relationships
+// written by this tool, so that re-running a scan yields the same objects.
+// It is the UUIDv5 of the project URL in the RFC 4122 URL namespace.
+var stixNamespace = uuidV5([16]byte{0x6b, 0xa7, 0xb8, 0x11, 0x9d, 0xad, 0x11, 0xd1, 0x80, 0xb4, 0x00, 0xc0, 0x4f, 0xd4, 0x30, 0xc8}, "https://github.com/Magonia-Research/CelesTLSH-CLI")
+
+// stixTimeFormat is the STIX timestamp format, in UTC with milliseconds.
+const stixTimeFormat = "2006-01-02T15:04:05.000Z"
+
+type stixBundle struct {
+	Type    string `json:"type"`
+	ID      string `json:"id"`
+	Objects []any  `json:"objects"`
+}
+
+type stixFile struct {
+	Type        string            `json:"type"`
+	SpecVersion string            `json:"spec_version"`
+	ID          string            `json:"id"`
+	Hashes      map[string]string `json:"hashes"`
+	Name        string            `json:"name,omitempty"`
+}
+
+type stixIndicator struct {
+	Type               string                  `json:"type"`
+	SpecVersion        string                  `json:"spec_version"`
+	ID                 string                  `json:"id"`
+	Created            string                  `json:"created"`
+	Modified           string                  `json:"modified"`
+	Name               string                  `json:"name"`
+	Description        string                  `json:"description"`
+	IndicatorTypes     []string                `json:"indicator_types"`
+	Pattern            string                  `json:"pattern"`
+	PatternType        string                  `json:"pattern_type"`
+	ValidFrom          string                  `json:"valid_from"`
+	ExternalReferences []stixExternalReference `json:"external_references,omitempty"`
+}
+
+type stixExternalReference struct {
+	SourceName string `json:"source_name"`
+	URL        string `json:"url,omitempty"`
+}
+
+type stixRelationship struct {
+	Type             string `json:"type"`
+	SpecVersion      string `json:"spec_version"`
+	ID               string `json:"id"`
+	Created          string `json:"created"`
+	Modified         string `json:"modified"`
+	RelationshipType string `json:"relationship_type"`
+	Description      string `json:"description"`
+	SourceRef        string `json:"source_ref"`
+	TargetRef        string `json:"target_ref"`
+}
+
+// stixDocument builds a STIX 2.1 bundle with, for each match, a file
+// object for the scanned sample, an indicator for the database record and a
+// relationship between the two. Objects are identified by UUIDv5s derived
+// from the hashes, so each appears once however often it matches, and are
+// dated by the record's Date Added, so that re-running a scan yields the
+// same objects. Records without a date take the time of the export.
+type stixDocument struct {
+	now     string
+	objects []any
+	seen    map[string]bool
+}
+
+func newSTIXDocument() *stixDocument {
+	return &stixDocument{
+		now:  time.Now().UTC().Format(stixTimeFormat),
+		seen: make(map[string]bool),
+	}
+}
+
+func (d *stixDocument) add(result scanResult) {
+	if result.Error != "" || result.Match == nil {
+		return
+	}
+
+	file := stixFileObject(result)
+	indicator := d.indicatorObject(result.Match)
+	relationship := stixRelationship{
+		Type:             "relationship",
+		SpecVersion:      "2.1",
+		ID:               "relationship--" + formatUUID(uuidV5(stixNamespace, indicator.ID+" "+file.ID)),
+		Created:          indicator.Created,
+		Modified:         indicator.Created,
+		RelationshipType: "related-to",
+		Description:      stixRelationshipDescription(result),
+		SourceRef:        indicator.ID,
+		TargetRef:        file.ID,
+	}
+
+	for _, obj := range []struct {
+		id     string
+		object any
+	}{{file.ID, file}, {indicator.ID, indicator}, {relationship.ID, relationship}} {
+		if !d.seen[obj.id] {
+			d.seen[obj.id] = true
+			d.objects = append(d.objects, obj.object)
+		}
+	}
+}
+
+// stixFileObject describes a scanned file. Its identifier is derived from
+// its hashes alone, as STIX specifies, using the preferred SHA-256 when it
+// was computed.
+func stixFileObject(result scanResult) stixFile {
+	file := stixFile{
+		Type:        "file",
+		SpecVersion: "2.1",
+		Hashes:      map[string]string{"TLSH": result.TLSH},
+	}
+	if result.Path != "" {
+		file.Name = filepath.Base(result.Path)
+	}
+
+	idHashes := map[string]string{"TLSH": result.TLSH}
+	if result.SHA256 != "" {
+		file.Hashes["SHA-256"] = result.SHA256
+		idHashes = map[string]string{"SHA-256": result.SHA256}
+	}
+	// encoding/json sorts map keys and adds no whitespace, which is the
+	// canonical form for these plain string values.
+	contributing, _ := json.Marshal(map[string]map[string]string{"hashes": idHashes})
+	file.ID = "file--" + formatUUID(uuidV5(stixSCONamespace, string(contributing)))
+
+	return file
+}
+
+func (d *stixDocument) indicatorObject(m *celestlsh.HashRecord) stixIndicator {
+	created := d.now
+	if t, err := celestlsh.ParseDate(m.DateAdded); err == nil {
+		created = t.UTC().Format(stixTimeFormat)
+	}
+
+	pattern := fmt.Sprintf("[file:hashes.TLSH = '%s']", m.TLSHHash)
+	if m.SHA256Hash != "" {
+		pattern = fmt.Sprintf("[file:hashes.'SHA-256' = '%s'] OR %s", m.SHA256Hash, pattern)
+	}
+
+	indicator := stixIndicator{
+		Type:           "indicator",
+		SpecVersion:    "2.1",
+		ID:             "indicator--" + formatUUID(uuidV5(stixNamespace, m.SHA256Hash+" "+m.TLSHHash)),
+		Created:        created,
+		Modified:       created,
+		Name:           fmt.Sprintf("%s %s %s", m.RepoName, m.FileName, m.Version),
+		Description:    fmt.Sprintf("Build of %s (%s, version %s) from the CelesTLSH database of attack tools", m.RepoName, m.FileName, m.Version),
+		IndicatorTypes: []string{"malicious-activity"},
+		Pattern:        pattern,
+		PatternType:    "stix",
+		ValidFrom:      created,
+	}
+	if m.Intel != "" {
+		indicator.ExternalReferences = []stixExternalReference{{SourceName: m.RepoName, URL: m.Intel}}
+	}
+
+	return indicator
+}
+
+func (d *stixDocument) write(w io.Writer) error {
+	var id [16]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return err
+	}
+	id[6] = id[6]&0x0f | 0x40
+	id[8] = id[8]&0x3f | 0x80
+
+	objects := d.objects
+	if objects == nil {
+		objects = []any{}
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(stixBundle{Type: "bundle", ID: "bundle--" + formatUUID(id), Objects: objects})
+}
+
+// uuidV5 returns the name-based UUID of name in namespace, per RFC 4122.
+func uuidV5(namespace [16]byte, name string) [16]byte {
+	h := sha1.New()
+	h.Write(namespace[:])
+	h.Write([]byte(name))
+
+	var u [16]byte
+	copy(u[:], h.Sum(nil))
+	u[6] = u[6]&0x0f | 0x50
+	u[8] = u[8]&0x3f | 0x80
+	return u
+}
+
+func formatUUID(u [16]byte) string {
+	return fmt.Sprintf("%x-%x-%x-%x-%x", u[0:4], u[4:6], u[6:8], u[8:10], u[10:16])
+}
+
+// stixRelationshipDescription describes how a file relates to the
+// indicator it matched.
+func stixRelationshipDescription(result scanResult) string {
+	switch result.MatchType {
+	case matchExact:
+		return fmt.Sprintf("TLSH distance %d, exact match by SHA256", result.Match.Distance)
+	case matchSimilar:
+		return fmt.Sprintf("TLSH distance %d, similar (TLSH only)", result.Match.Distance)
+	default:
+		return fmt.Sprintf("TLSH distance %d", result.Match.Distance)
+	}
+}
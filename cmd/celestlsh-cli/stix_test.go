@@ -0,0 +1,177 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Magonia-Research/CelesTLSH-CLI/pkg/celestlsh"
+)
+
+func TestUUIDv5(t *testing.T) {
+	dns := [16]byte{0x6b, 0xa7, 0xb8, 0x10, 0x9d, 0xad, 0x11, 0xd1, 0x80, 0xb4, 0x00, 0xc0, 0x4f, 0xd4, 0x30, 0xc8}
+	tests := []struct {
+		name, got, want string
+	}{
+		{"RFC 4122 DNS example", formatUUID(uuidV5(dns, "www.example.com")), "2ed6657d-e927-568b-95e1-2665a8aea6a2"},
+		{"project namespace", formatUUID(stixNamespace), "3bb285a9-3965-579c-8969-0991ef04a58c"},
+		{"file object", stixFileObject(scanResult{Digests: celestlsh.Digests{TLSH: "T1AB", SHA256: strings.Repeat("ab", 32)}}).ID, "file--20432b06-75d2-54b6-80ef-6d923b5d925d"},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("%s: %s, want %s", tt.name, tt.got, tt.want)
+		}
+	}
+}
+
+var (
+	stixIDPattern   = regexp.MustCompile(`^([a-z0-9-]+)--[0-9a-f]{8}-[0-9a-f]{4}-[1-5][0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+	stixTimePattern = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}(\.\d+)?Z$`)
+)
+
+// validateSTIX checks a bundle against the required-property rules of
+// STIX 2.1 for the object types this tool writes, and returns its objects
+// by identifier.
+func validateSTIX(t *testing.T, out string) map[string]map[string]any {
+	t.Helper()
+	var bundle map[string]any
+	if err := json.Unmarshal([]byte(out), &bundle); err != nil {
+		t.Fatalf("parsing bundle %s: %v", out, err)
+	}
+	if bundle["type"] != "bundle" || !stixIDPattern.MatchString(bundle["id"].(string)) || !strings.HasPrefix(bundle["id"].(string), "bundle--") {
+		t.Errorf("bundle type %v, id %v; want a bundle identifier", bundle["type"], bundle["id"])
+	}
+	if _, ok := bundle["spec_version"]; ok {
+		t.Error("a STIX 2.1 bundle has no spec_version")
+	}
+	objects, ok := bundle["objects"].([]any)
+	if !ok {
+		t.Fatalf("objects = %v, want an array", bundle["objects"])
+	}
+
+	byID := make(map[string]map[string]any)
+	for _, o := range objects {
+		obj := o.(map[string]any)
+		id, _ := obj["id"].(string)
+		m := stixIDPattern.FindStringSubmatch(id)
+		if m == nil || m[1] != obj["type"] {
+			t.Errorf("object id %q does not match its type %v", id, obj["type"])
+			continue
+		}
+		if byID[id] != nil {
+			t.Errorf("object %s appears twice", id)
+		}
+		byID[id] = obj
+		if obj["spec_version"] != "2.1" {
+			t.Errorf("%s: spec_version %v, want 2.1", id, obj["spec_version"])
+		}
+
+		var required []string
+		switch obj["type"] {
+		case "file":
+			if hashes, ok := obj["hashes"].(map[string]any); !ok || len(hashes) == 0 {
+				t.Errorf("%s: a file needs hashes or a name; it has %v", id, obj["hashes"])
+			}
+		case "indicator":
+			required = []string{"created", "modified", "pattern", "pattern_type", "valid_from"}
+		case "relationship":
+			required = []string{"created", "modified", "relationship_type", "source_ref", "target_ref"}
+		default:
+			t.Errorf("%s: unexpected object type", id)
+		}
+		for _, name := range required {
+			if v, ok := obj[name].(string); !ok || v == "" {
+				t.Errorf("%s lacks %s", id, name)
+			}
+		}
+		for _, name := range []string{"created", "modified", "valid_from"} {
+			if v, ok := obj[name].(string); ok && !stixTimePattern.MatchString(v) {
+				t.Errorf("%s: %s %q is not a STIX timestamp", id, name, v)
+			}
+		}
+	}
+
+	// Relationships must join objects in the bundle.
+	for id, obj := range byID {
+		if obj["type"] != "relationship" {
+			continue
+		}
+		for _, ref := range []string{"source_ref", "target_ref"} {
+			if byID[obj[ref].(string)] == nil {
+				t.Errorf("%s: %s %v is not in the bundle", id, ref, obj[ref])
+			}
+		}
+	}
+	return byID
+}
+
+func TestScanSTIX(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "a/known.exe", testSample)
+	// A second copy is the same file object.
+	writeFile(t, dir, "b/known.exe", testSample)
+	writeFile(t, dir, "c/variant.exe", variantData(testSample, 40))
+	db := writeTestDatabase(t)
+
+	run := func() map[string]map[string]any {
+		out, _, _, err := runCLI(t, "-s", "--sha256", "--format", "stix", "--max-distance", "30", "--db", db, dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return validateSTIX(t, out)
+	}
+	objects := run()
+
+	counts := make(map[any]int)
+	for _, obj := range objects {
+		counts[obj["type"]]++
+	}
+	if counts["file"] != 2 || counts["indicator"] != 2 || counts["relationship"] != 2 {
+		t.Errorf("object counts %v, want two files, indicators and relationships", counts)
+	}
+
+	var indicator map[string]any
+	for _, obj := range objects {
+		if obj["type"] == "indicator" && obj["name"] == "KnownTool known.exe v1.0" {
+			indicator = obj
+		}
+	}
+	if indicator == nil {
+		t.Fatal("no indicator for known.exe")
+	}
+	want := "[file:hashes.'SHA-256' = '" + strings.Repeat("ab", 32) + "'] OR [file:hashes.TLSH = '" + testHash(t, testSample) + "']"
+	if indicator["pattern"] != want {
+		t.Errorf("pattern %v, want %s", indicator["pattern"], want)
+	}
+	refs, _ := indicator["external_references"].([]any)
+	if len(refs) != 1 || refs[0].(map[string]any)["url"] != "https://example.com/KnownTool" {
+		t.Errorf("external references %v, want the intel URL", refs)
+	}
+	if created, _ := time.Parse(stixTimeFormat, indicator["created"].(string)); !created.Equal(time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("created %v, want the date the record was added", indicator["created"])
+	}
+
+	for _, obj := range objects {
+		if obj["type"] == "relationship" && (obj["created"] != objects[obj["source_ref"].(string)]["created"] || obj["modified"] != obj["created"]) {
+			t.Errorf("relationship created %v, modified %v; want the date of its indicator", obj["created"], obj["modified"])
+		}
+	}
+
+	// Every run writes the same objects, timestamps included.
+	if again := run(); !reflect.DeepEqual(again, objects) {
+		t.Errorf("second run differs from the first:\n%v\n%v", again, objects)
+	}
+}
+
+func TestSTIXWithoutMatches(t *testing.T) {
+	out, _, _, err := runCLI(t, "-c", "--format", "stix", "--max-distance", "30", "--db", writeTestDatabase(t), testHash(t, sampleData(5, 8192)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if objects := validateSTIX(t, out); len(objects) != 0 {
+		t.Errorf("objects = %v, want none", objects)
+	}
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// jsonlSummary is the last record of a --jsonl stream from a batch mode,
+// written even when the run is interrupted. Its type field tells it apart
+// from the result records, which have none. Scan mode also prints it to
+// stderr as a recap; the fields after Pending are only set by scan mode.
+type jsonlSummary struct {
+	Type        string `json:"type"`
+	Files       int64  `json:"files"`
+	Results     int64  `json:"results"`
+	Matched     int64  `json:"matched"`
+	Failed      int64  `json:"failed"`
+	TimedOut    int64  `json:"timed_out,omitempty"`
+	Pending     int64  `json:"pending,omitempty"`
+	Discovered  int64  `json:"discovered,omitempty"`
+	Filtered    int64  `json:"filtered,omitempty"`
+	FilteredDir int64  `json:"filtered_dirs,omitempty"`
+	Oversized   int64  `json:"oversized,omitempty"`
+	SkippedExt  int64  `json:"skipped_ext,omitempty"`
+	SkippedType int64  `json:"skipped_type,omitempty"`
+	TooSmall    int64  `json:"too_small,omitempty"`
+	Links       int64  `json:"skipped_links,omitempty"`
+	Special     int64  `json:"non_regular,omitempty"`
+	Mounts      int64  `json:"skipped_mounts,omitempty"`
+	Deep        int64  `json:"skipped_depth,omitempty"`
+	Hidden      int64  `json:"skipped_hidden,omitempty"`
+	Suppressed  int64  `json:"suppressed,omitempty"`
+	HighEntropy int64  `json:"high_entropy,omitempty"`
+
+	Quarantined      int64 `json:"quarantined,omitempty"`
+	QuarantineFailed int64 `json:"quarantine_failed,omitempty"`
+	Resumed          int64 `json:"resumed,omitempty"`
+	CacheHits        int64 `json:"cache_hits,omitempty"`
+	CacheMisses      int64 `json:"cache_misses,omitempty"`
+	// FilteredRecords counts the database records left out by --since,
+	// --until and --version-filter.
+	FilteredRecords int64 `json:"filtered_records,omitempty"`
+
+	MatchedByRepo  map[string]int64 `json:"matched_by_repo,omitempty"`
+	Bytes          int64            `json:"bytes,omitempty"`
+	ElapsedSeconds float64          `json:"elapsed_seconds,omitempty"`
+	BytesPerSecond float64          `json:"bytes_per_second,omitempty"`
+	// Throttle is the --throttle limit BytesPerSecond was held to.
+	Throttle int64 `json:"throttle_bytes_per_second,omitempty"`
+
+	// DatabaseAge is how long ago the database was downloaded, in seconds,
+	// and DatabaseStale whether that is past --stale-after; see
+	// checkStale. Both are left out when the age is unknown.
+	DatabaseAge   int64 `json:"database_age_seconds,omitempty"`
+	DatabaseStale bool  `json:"database_stale,omitempty"`
+
+	Interrupted bool `json:"interrupted,omitempty"`
+	// StoppedEarly marks a scan that --fail-fast stopped at its first
+	// match, leaving the files after it unscanned.
+	StoppedEarly bool `json:"stopped_early,omitempty"`
+}
+
+// summary returns the counts of a scan that has run for elapsed.
+func (p *scanStats) summary(elapsed time.Duration) jsonlSummary {
+	s := jsonlSummary{
+		Files:          p.processed.Load(),
+		Results:        p.results.Load(),
+		Matched:        p.matched.Load(),
+		Failed:         p.failed.Load(),
+		TimedOut:       p.timedOut.Load(),
+		Discovered:     p.discovered.Load(),
+		Filtered:       p.filteredFile.Load(),
+		FilteredDir:    p.filteredDir.Load(),
+		Oversized:      p.oversized.Load(),
+		SkippedExt:     p.skippedExt.Load(),
+		SkippedType:    p.skippedType.Load(),
+		TooSmall:       p.tooSmall.Load(),
+		Links:          p.skippedLinks.Load(),
+		Special:        p.special.Load(),
+		Mounts:         p.skippedMounts.Load(),
+		Deep:           p.skippedDepth.Load(),
+		Hidden:         p.skippedHidden.Load(),
+		Suppressed:     p.suppressed.Load(),
+		HighEntropy:    p.highEntropy.Load(),
+		Bytes:          p.bytes.Load(),
+		ElapsedSeconds: elapsed.Seconds(),
+	}
+	s.Pending = s.Discovered - s.Files
+	s.Quarantined, s.QuarantineFailed = p.quarantined.Load(), p.quarantineFailed.Load()
+	s.CacheHits, s.CacheMisses = p.cacheHits.Load(), p.cacheMisses.Load()
+	if elapsed > 0 {
+		s.BytesPerSecond = float64(s.Bytes) / elapsed.Seconds()
+	}
+
+	p.mu.Lock()
+	if len(p.byRepo) > 0 {
+		s.MatchedByRepo = make(map[string]int64, len(p.byRepo))
+		for repo, n := range p.byRepo {
+			s.MatchedByRepo[repo] = n
+		}
+	}
+	p.mu.Unlock()
+
+	return s
+}
+
+// skipped lists the non-zero counts of entries that were not scanned.
+func (s jsonlSummary) skipped() []string {
+	var parts []string
+	for _, c := range []struct {
+		n    int64
+		what string
+	}{
+		{s.Filtered, "filtered"},
+		{s.FilteredDir, "directories filtered"},
+		{s.Oversized, "too large"},
+		{s.SkippedExt, "skipped by extension"},
+		{s.SkippedType, "not of a selected type"},
+		{s.TooSmall, "too small"},
+		{s.Links, "links skipped"},
+		{s.Special, "non-regular"},
+		{s.Mounts, "mounts skipped"},
+		{s.Deep, "directories beyond --max-depth"},
+		{s.Hidden, "hidden"},
+	} {
+		if c.n > 0 {
+			parts = append(parts, fmt.Sprintf("%d %s", c.n, c.what))
+		}
+	}
+	return parts
+}
+
+// verb says how the scan ended.
+func (s jsonlSummary) verb() string {
+	switch {
+	case s.Interrupted:
+		return "interrupted"
+	case s.StoppedEarly:
+		return "stopped at the first match"
+	default:
+		return "finished"
+	}
+}
+
+// text describes the summary in a sentence.
+func (s jsonlSummary) text() string {
+	verb := s.verb()
+	text := fmt.Sprintf("Scan %s: %d files scanned, %d matched, %d failed", verb, s.Files, s.Matched, s.Failed)
+	if s.TimedOut > 0 {
+		text += fmt.Sprintf(" (%d timed out)", s.TimedOut)
+	}
+	text += fmt.Sprintf(", %d pending", s.Pending)
+	for _, part := range s.skipped() {
+		text += ", " + part
+	}
+	return text
+}
+
+// report writes the summary as an aligned recap, one count per line, with
+// matches broken down by repository.
+func (s jsonlSummary) report(w io.Writer) {
+	verb := s.verb()
+	elapsed := time.Duration(s.ElapsedSeconds * float64(time.Second)).Round(time.Millisecond)
+	fmt.Fprintf(w, "Scan %s in %v\n", verb, elapsed)
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	if s.Resumed > 0 {
+		fmt.Fprintf(tw, "  Already scanned:\t%d\n", s.Resumed)
+	}
+	fmt.Fprintf(tw, "  Files discovered:\t%d\n", s.Discovered)
+	fmt.Fprintf(tw, "  Files scanned:\t%d\n", s.Files)
+	if s.Pending > 0 {
+		fmt.Fprintf(tw, "  Files pending:\t%d\n", s.Pending)
+	}
+	fmt.Fprintf(tw, "  Hashed:\t%d\n", s.Results-s.Failed)
+	if s.CacheHits > 0 || s.CacheMisses > 0 {
+		fmt.Fprintf(tw, "    from hash cache\t%d (%d read)\n", s.CacheHits, s.CacheMisses)
+	}
+	fmt.Fprintf(tw, "  Matched:\t%d\n", s.Matched)
+
+	repos := make([]string, 0, len(s.MatchedByRepo))
+	for repo := range s.MatchedByRepo {
+		repos = append(repos, repo)
+	}
+	sort.Slice(repos, func(i, j int) bool {
+		if s.MatchedByRepo[repos[i]] != s.MatchedByRepo[repos[j]] {
+			return s.MatchedByRepo[repos[i]] > s.MatchedByRepo[repos[j]]
+		}
+		return repos[i] < repos[j]
+	})
+	for _, repo := range repos {
+		fmt.Fprintf(tw, "    %s\t%d\n", repo, s.MatchedByRepo[repo])
+	}
+
+	if s.Suppressed > 0 {
+		fmt.Fprintf(tw, "  Suppressed:\t%d\n", s.Suppressed)
+	}
+	if s.HighEntropy > 0 {
+		fmt.Fprintf(tw, "  High entropy:\t%d\n", s.HighEntropy)
+	}
+	if s.Quarantined > 0 || s.QuarantineFailed > 0 {
+		fmt.Fprintf(tw, "  Quarantined:\t%d\n", s.Quarantined)
+	}
+	if s.QuarantineFailed > 0 {
+		fmt.Fprintf(tw, "  Quarantine failures:\t%d\n", s.QuarantineFailed)
+	}
+	fmt.Fprintf(tw, "  Errors:\t%d\n", s.Failed)
+	if s.TimedOut > 0 {
+		fmt.Fprintf(tw, "    timed out\t%d\n", s.TimedOut)
+	}
+	if s.FilteredRecords > 0 {
+		fmt.Fprintf(tw, "  Records filtered:\t%d\n", s.FilteredRecords)
+	}
+	if skipped := s.skipped(); len(skipped) > 0 {
+		fmt.Fprintf(tw, "  Skipped:\t%s\n", strings.Join(skipped, ", "))
+	}
+	if s.Throttle > 0 {
+		fmt.Fprintf(tw, "  Bytes processed:\t%s (%s/s, throttled to %s/s)\n", formatSize(s.Bytes), formatSize(int64(s.BytesPerSecond)), formatSize(s.Throttle))
+	} else {
+		fmt.Fprintf(tw, "  Bytes processed:\t%s (%s/s)\n", formatSize(s.Bytes), formatSize(int64(s.BytesPerSecond)))
+	}
+	tw.Flush()
+}
+
+// line renders the summary as a JSON Lines record.
+func (s jsonlSummary) line() string {
+	s.Type = "summary"
+	line, _ := json.Marshal(s)
+	return string(line)
+}
+
+func printJSONLSummary(summary jsonlSummary) {
+	fmt.Println(summary.line())
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// summaryTree writes a tree of files to scan whose counts are known:
+// copies of known.exe and other.exe, unrelated files, truncated archives
+// that each fail twice, files too small to hash and files --exclude
+// drops. It returns the root and the bytes the hashed files hold.
+func summaryTree(t *testing.T) (root string, hashedBytes int64) {
+	t.Helper()
+	root = t.TempDir()
+	other := sampleData(2, 8192)
+	broken := makeTar(t, archiveEntry{name: "known.exe", data: testSample})
+	for i := range 20 {
+		writeFile(t, root, fmt.Sprintf("known/%d.exe", i), testSample)
+		writeFile(t, root, fmt.Sprintf("other/%d.exe", i), other)
+		writeFile(t, root, fmt.Sprintf("misc/%d.bin", i), sampleData(uint64(100+i), 4096))
+		hashedBytes += int64(2*8192 + 4096)
+	}
+	for i := range 10 {
+		writeFile(t, root, fmt.Sprintf("broken/%d.tar", i), broken[:len(broken)/2])
+		writeFile(t, root, fmt.Sprintf("small/%d", i), []byte("tiny"))
+	}
+	for i := range 3 {
+		writeFile(t, root, fmt.Sprintf("misc/%d.log", i), sampleData(uint64(200+i), 4096))
+	}
+	return root, hashedBytes
+}
+
+func TestScanSummaryCounts(t *testing.T) {
+	root, hashedBytes := summaryTree(t)
+	for _, workers := range []string{"1", "8"} {
+		t.Run("workers "+workers, func(t *testing.T) {
+			out, _, _, err := runCLI(t, "-s", "--jsonl", "--max-distance", "30", "--workers", workers,
+				"--exclude", "*.log", "--db", writeTestDatabase(t), root)
+			if err != nil {
+				t.Fatal(err)
+			}
+			results, s := parseJSONL(t, out)
+
+			// Too-small files are scanned but print no result, and each
+			// broken archive prints two.
+			if s.Discovered != 80 || s.Files != 80 || s.Pending != 0 {
+				t.Errorf("discovered %d, scanned %d, pending %d; want 80, 80, none", s.Discovered, s.Files, s.Pending)
+			}
+			if s.Results != 80 || int64(len(results)) != s.Results {
+				t.Errorf("%d results, %d printed; want 80", s.Results, len(results))
+			}
+			if s.Matched != 40 || s.Failed != 20 || s.TooSmall != 10 || s.Filtered != 3 {
+				t.Errorf("summary %+v; want 40 matched, 20 failed, 10 too small, 3 filtered", s)
+			}
+			if s.MatchedByRepo["KnownTool"] != 20 || s.MatchedByRepo["OtherTool"] != 20 || len(s.MatchedByRepo) != 2 {
+				t.Errorf("matched by repository %v, want 20 each for KnownTool and OtherTool", s.MatchedByRepo)
+			}
+			// Broken archives and small files add a little to what was read.
+			if s.Bytes < hashedBytes || s.Bytes > hashedBytes+10*int64(len(testSample)+4) {
+				t.Errorf("%d bytes read, want about %d", s.Bytes, hashedBytes)
+			}
+			if s.ElapsedSeconds <= 0 || s.BytesPerSecond <= 0 {
+				t.Errorf("elapsed %v, throughput %v; want both set", s.ElapsedSeconds, s.BytesPerSecond)
+			}
+		})
+	}
+}
+
+func TestScanSummaryOutput(t *testing.T) {
+	root, _ := summaryTree(t)
+	db := writeTestDatabase(t)
+
+	out, errOut, _, err := runCLI(t, "-s", "--max-distance", "30", "--db", db, root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(out, "Files discovered:") || !strings.Contains(errOut, "Files discovered:") {
+		t.Errorf("plain summary not on stderr alone:\nstdout:\n%s\nstderr:\n%s", out, errOut)
+	}
+	for _, want := range []string{`Matched:\s+40\n`, `Errors:\s+20\n`, `KnownTool\s+20\n`, `OtherTool\s+20\n`, `Skipped:\s+10 too small\n`} {
+		if !regexp.MustCompile(want).MatchString(errOut) {
+			t.Errorf("summary lacks %s:\n%s", want, errOut)
+		}
+	}
+
+	_, errOut, _, err = runCLI(t, "-s", "--quiet", "--max-distance", "30", "--db", db, root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(errOut, "Files discovered:") {
+		t.Errorf("--quiet printed the summary:\n%s", errOut)
+	}
+
+	out, _, _, err = runCLI(t, "-s", "--stats-only", "--max-distance", "30", "--db", db, root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(out, "Scan finished in ") || strings.Contains(out, "known/0.exe") {
+		t.Errorf("--stats-only printed more than the summary:\n%s", out)
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// syslogLocal is the --syslog value that selects the local syslog daemon.
+const syslogLocal = "local"
+
+// syslogAttempts and syslogBackoff bound how long a message is retried
+// before it is dropped with a warning. After that, messages are dropped
+// without trying for syslogCooldown, so that an unreachable target does not
+// slow the scan down.
+const (
+	syslogAttempts = 3
+	syslogBackoff  = 200 * time.Millisecond
+	syslogCooldown = 30 * time.Second
+)
+
+// syslogFacilities and syslogSeverities map the names accepted by
+// --syslog-facility and --syslog-severity to their RFC 5424 codes.
+var (
+	syslogFacilities = map[string]int{
+		"kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5,
+		"lpr": 6, "news": 7, "uucp": 8, "cron": 9, "authpriv": 10, "ftp": 11,
+		"local0": 16, "local1": 17, "local2": 18, "local3": 19,
+		"local4": 20, "local5": 21, "local6": 22, "local7": 23,
+	}
+	syslogSeverities = map[string]int{
+		"emerg": 0, "alert": 1, "crit": 2, "err": 3,
+		"warning": 4, "notice": 5, "info": 6, "debug": 7,
+	}
+)
+
+// syslogFlag is the value of --syslog. Given without a value it selects
+// the local syslog daemon; --syslog=<address> selects a remote one.
+type syslogFlag struct {
+	target string
+}
+
+func (f *syslogFlag) String() string { return f.target }
+
+func (f *syslogFlag) Set(value string) error {
+	switch value {
+	case "true":
+		f.target = syslogLocal
+	case "false":
+		f.target = ""
+	default:
+		f.target = value
+	}
+	return nil
+}
+
+func (f *syslogFlag) IsBoolFlag() bool { return true }
+
+// parseSyslogTarget splits a --syslog value into a network and address
+// for dialing: empty for the local daemon, or udp or tcp with host:port.
+// The scheme defaults to udp and the port to 514.
+func parseSyslogTarget(target string) (network, addr string, err error) {
+	if target == syslogLocal {
+		return "", "", nil
+	}
+
+	network = "udp"
+	if scheme, rest, ok := strings.Cut(target, "://"); ok {
+		network, target = scheme, rest
+	}
+	if network != "udp" && network != "tcp" {
+		return "", "", fmt.Errorf("unsupported syslog transport %q (use udp or tcp)", network)
+	}
+
+	if _, _, err := net.SplitHostPort(target); err != nil {
+		target = net.JoinHostPort(target, "514")
+	}
+	return network, target, nil
+}
+
+// syslogConn is a connection to a syslog daemon, as provided by log/syslog.
+type syslogConn interface {
+	Write(message []byte) (int, error)
+	Info(message string) error
+	Close() error
+}
+
+// syslogForwarder sends match events to syslog. Messages that cannot be
+// delivered after a few attempts are dropped with a warning on stderr, so
+// that an unreachable syslog target never stops a scan.
+type syslogForwarder struct {
+	network  string
+	addr     string
+	priority int
+
+	mu      sync.Mutex
+	conn    syslogConn
+	down    time.Time
+	dropped int
+}
+
+func newSyslogForwarder(config Config) (*syslogForwarder, error) {
+	if config.Syslog == "" {
+		return nil, nil
+	}
+
+	network, addr, err := parseSyslogTarget(config.Syslog)
+	if err != nil {
+		return nil, err
+	}
+
+	f := &syslogForwarder{
+		network:  network,
+		addr:     addr,
+		priority: syslogFacilities[config.SyslogFacility]<<3 | syslogSeverities[config.SyslogSeverity],
+	}
+
+	// Connect up front to report an unsupported platform as an error; an
+	// unreachable target is only a warning and is retried per message.
+	f.conn, err = dialSyslog(network, addr, f.priority)
+	if err == errSyslogUnsupported {
+		return nil, err
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: syslog: %v\n", err)
+	}
+
+	return f, nil
+}
+
+// send delivers a message at the configured severity, or at info level
+// for a summary.
+func (f *syslogForwarder) send(message string, summary bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if time.Since(f.down) < syslogCooldown {
+		f.dropped++
+		return
+	}
+
+	var err error
+	for attempt := 0; attempt < syslogAttempts; attempt++ {
+		if attempt > 0 {
+			time.Sleep(syslogBackoff << (attempt - 1))
+		}
+
+		if f.conn == nil {
+			if f.conn, err = dialSyslog(f.network, f.addr, f.priority); err != nil {
+				continue
+			}
+		}
+
+		if summary {
+			err = f.conn.Info(message)
+		} else {
+			_, err = f.conn.Write([]byte(message))
+		}
+		if err == nil {
+			if f.dropped > 0 {
+				clearProgress()
+				fmt.Fprintf(os.Stderr, "Warning: syslog: reconnected; messages dropped while unreachable: %d\n", f.dropped)
+				f.dropped = 0
+			}
+			return
+		}
+		f.conn.Close()
+		f.conn = nil
+	}
+
+	f.down = time.Now()
+	f.dropped++
+	clearProgress()
+	fmt.Fprintf(os.Stderr, "Warning: syslog: %v; dropping messages for %s\n", err, syslogCooldown)
+}
+
+// close closes the connection, reporting any messages that were dropped.
+// It is safe on a nil forwarder.
+func (f *syslogForwarder) close() {
+	if f == nil {
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.dropped > 0 {
+		fmt.Fprintf(os.Stderr, "Warning: syslog: messages dropped: %d\n", f.dropped)
+	}
+	if f.conn != nil {
+		f.conn.Close()
+		f.conn = nil
+	}
+}
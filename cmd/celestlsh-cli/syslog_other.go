@@ -0,0 +1,11 @@
+//go:build windows || plan9
+
+package main
+
+import "errors"
+
+var errSyslogUnsupported = errors.New("syslog forwarding is not supported on this platform")
+
+func dialSyslog(network, addr string, priority int) (syslogConn, error) {
+	return nil, errSyslogUnsupported
+}
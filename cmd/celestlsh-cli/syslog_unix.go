@@ -0,0 +1,19 @@
+//go:build !windows && !plan9
+
+package main
+
+import (
+	"errors"
+	"log/syslog"
+)
+
+// errSyslogUnsupported is never returned where log/syslog is available.
+var errSyslogUnsupported = errors.New("syslog forwarding is not supported on this platform")
+
+func dialSyslog(network, addr string, priority int) (syslogConn, error) {
+	w, err := syslog.Dial(network, addr, syslog.Priority(priority), "celestlsh")
+	if err != nil {
+		return nil, err
+	}
+	return w, nil
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"unicode/utf8"
+
+	"github.com/Magonia-Research/CelesTLSH-CLI/pkg/celestlsh"
+)
+
+// Widths of the table columns that are truncated on a terminal, in
+// characters including the ellipsis.
+const (
+	tableRepoWidth    = 24
+	tableFileWidth    = 32
+	tableVersionWidth = 16
+	tableSHA256Width  = 12
+	tableDateWidth    = 20
+)
+
+// printMatchTable prints the matches of a checked hash as an aligned table,
+// closest first unless --sort says otherwise: the repositories of a
+// --group-by-repo result, with their match counts, the records listed by
+// --top, or the best match followed by the other records at the same
+// distance. Results that reach it without --format table have a title
+// line, as the Best match found: block does; with it, only the table is
+// printed. On a terminal, long fields are cut short with an ellipsis
+// unless --wide is given; redirected output is never cut.
+func printMatchTable(config Config, r checkResult) {
+	if !config.Table {
+		title := "Best match found"
+		switch {
+		case r.Repos != nil:
+			title = "Best match per tool"
+		case r.Matches != nil:
+			title = "Closest matches"
+		}
+		if r.Suppressed {
+			title += " (suppressed by the allowlist)"
+		}
+		fmt.Println(paint(resultStyle(config, r.scanResult()), title+":"))
+	}
+
+	records := []celestlsh.RepoMatch{{HashRecord: *r.Match, Count: 1}}
+	for _, m := range r.Identical {
+		records = append(records, celestlsh.RepoMatch{HashRecord: m, Count: 1})
+	}
+	switch {
+	case r.Repos != nil:
+		records = r.Repos
+	case r.Matches != nil:
+		records = records[:0]
+		for _, m := range r.Matches {
+			records = append(records, celestlsh.RepoMatch{HashRecord: m, Count: 1})
+		}
+	}
+	fit := tableFit(config)
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	header := "  Rank\tDistance\tConfidence\tTool\tFile\tVersion\tSHA256\tDate"
+	if r.Repos != nil {
+		header += "\tMatches"
+	}
+	fmt.Fprintln(tw, header)
+	for i, m := range records {
+		row := fmt.Sprintf("  %d\t%d\t%s\t%s\t%s\t%s\t%s\t%s", i+1, m.Distance, config.ConfidenceBands.label(m.Distance),
+			fit(m.RepoName, tableRepoWidth), fit(m.FileName, tableFileWidth), fit(m.Version, tableVersionWidth),
+			fit(m.SHA256Hash, tableSHA256Width), fit(m.DateAdded, tableDateWidth))
+		if r.Repos != nil {
+			row += "\t" + strconv.Itoa(m.Count)
+		}
+		fmt.Fprintln(tw, row)
+	}
+	tw.Flush()
+	if config.Table && r.Suppressed {
+		fmt.Println("  (suppressed by the allowlist)")
+	}
+	if r.IdenticalTruncated {
+		fmt.Println("  (more records at this distance not shown; raise --limit to list them)")
+	}
+}
+
+// tableFit returns a function cutting a field short to width characters
+// with an ellipsis, when writing to a terminal without --wide, and
+// otherwise returning it as it is.
+func tableFit(config Config) func(s string, width int) string {
+	cut := !config.Wide && isTerminal(os.Stdout)
+	return func(s string, width int) string {
+		if !cut || utf8.RuneCountInString(s) <= width {
+			return s
+		}
+		return string([]rune(s)[:width-1]) + "…"
+	}
+}
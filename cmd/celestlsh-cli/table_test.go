@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestCheckTop(t *testing.T) {
+	db := writeTestDatabase(t)
+	hash := testRecords(t)[0].TLSHHash
+	check := func(args ...string) string {
+		t.Helper()
+		out, _, st, err := runCLI(t, append(append([]string{"-c", "--max-distance", "-1", "--db", db}, args...), hash)...)
+		if err != nil || st != statusMatch {
+			t.Fatalf("%v: status %v, error %v", args, st, err)
+		}
+		return out
+	}
+
+	var files []string
+	for _, row := range readCSV(t, check("--top", "3", "--csv")) {
+		files = append(files, row[1])
+	}
+	if want := "known.exe known-variant.exe other.exe"; strings.Join(files, " ") != want {
+		t.Errorf("--top 3 CSV files = %q, want %s", files, want)
+	}
+
+	var results []checkResult
+	if err := json.Unmarshal([]byte(check("--top", "2", "--sort", "-distance", "--json")), &results); err != nil || len(results) != 1 {
+		t.Fatalf("parsing JSON output: %v, %d results", err, len(results))
+	}
+	r := results[0]
+	if len(r.Matches) != 2 || r.Matches[0].FileName != "known-variant.exe" || r.Matches[1].FileName != "known.exe" {
+		t.Errorf("--sort -distance matches = %+v, want known-variant.exe then known.exe", r.Matches)
+	}
+	if r.Match == nil || r.Match.FileName != "known.exe" {
+		t.Errorf("match = %+v, want the closest record, known.exe", r.Match)
+	}
+
+	out := check("--top", "2", "--no-color")
+	if !strings.HasPrefix(out, "Closest matches:\n") || strings.Count(out, "\n") != 4 || !strings.Contains(out, "known-variant.exe") {
+		t.Errorf("--top 2 output:\n%s\nwant a titled table of 2 rows", out)
+	}
+}
+
+func TestCheckTableDefault(t *testing.T) {
+	records := testRecords(t)
+	copied := records[0]
+	copied.RepoName, copied.Version = "KnownTool", "v1.1"
+	hash := records[0].TLSHHash
+
+	tests := []struct {
+		name  string
+		db    string
+		args  []string
+		title string
+		rows  int
+	}{
+		{"single match", writeTestDatabase(t), nil, "Best match found:", 0},
+		{"identical matches", writeTestDatabase(t, append(records, copied)...), nil, "Best match found:", 2},
+		{"format table", writeTestDatabase(t), []string{"--format", "table"}, "", 1},
+		{"format table, identical matches", writeTestDatabase(t, append(records, copied)...), []string{"--format", "table"}, "", 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args := append(append([]string{"-c", "--no-color", "--db", tt.db}, tt.args...), hash)
+			out, _, _, err := runCLI(t, args...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			lines := strings.Split(strings.TrimSuffix(out, "\n"), "\n")
+			if tt.title != "" {
+				if lines[0] != tt.title {
+					t.Fatalf("output:\n%s\nwant the title %q", out, tt.title)
+				}
+				lines = lines[1:]
+			}
+			if tt.rows == 0 {
+				if strings.Contains(out, "Rank") || !strings.Contains(out, "  Tool: KnownTool") {
+					t.Errorf("output:\n%s\nwant the match block", out)
+				}
+				return
+			}
+			if !strings.HasPrefix(lines[0], "  Rank") || len(lines) != tt.rows+1 || tt.title == "" && strings.Contains(out, "Best match") {
+				t.Errorf("output:\n%s\nwant a table of %d rows", out, tt.rows)
+			}
+		})
+	}
+}
+
+func TestTopFlags(t *testing.T) {
+	db := writeTestDatabase(t)
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"-s", "--top", "2", "--db", db, t.TempDir()}, "--top without --group-by-repo only applies to check mode"},
+		{[]string{"-c", "--top", "2", "--format", "sarif", "--db", db, testRecords(t)[0].TLSHHash}, "--top cannot be used with --format sarif"},
+		{[]string{"-c", "--top", "0", "--db", db, testRecords(t)[0].TLSHHash}, "--top must be at least 1"},
+		{[]string{"-c", "--sort", "repo", "--db", db, testRecords(t)[0].TLSHHash}, "--sort requires --group-by-repo or --top"},
+	}
+	for _, tt := range tests {
+		code, stderr := runMain(t, tt.args...)
+		if code != exitError || !strings.Contains(stderr, tt.want) {
+			t.Errorf("%v: exit %d, stderr %q; want %d and %q", tt.args, code, stderr, exitError, tt.want)
+		}
+	}
+}
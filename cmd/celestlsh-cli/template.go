@@ -0,0 +1,168 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+	"text/tabwriter"
+	"text/template"
+
+	"github.com/Magonia-Research/CelesTLSH-CLI/pkg/celestlsh"
+)
+
+// templateRecord is what --template renders, once per result, or once per
+// repository of a --group-by-repo result. The fields of the matched record
+// are promoted, so that {{.RepoName}} names the matched tool; they are
+// empty, and Matched false, when nothing matched.
+type templateRecord struct {
+	Path        string  `help:"File the result is for; the hash itself in check mode"`
+	TLSH        string  `help:"TLSH hash computed for the file, or checked"`
+	MD5         string  `help:"MD5 of the file, with --all-hashes"`
+	SHA1        string  `help:"SHA1 of the file, with --all-hashes"`
+	SHA256      string  `help:"SHA256 of the file, with --sha256 or --all-hashes"`
+	FileImphash string  `help:"Import hash of the file, with --imphash"`
+	Entropy     float64 `help:"Shannon entropy of the file in bits per byte, with --entropy"`
+	HighEntropy bool    `help:"Whether the entropy is at least --min-entropy"`
+	SSDeep      string  `help:"ssdeep fuzzy hash of the file, with --ssdeep"`
+	Matched     bool    `help:"Whether a database record matched"`
+	Confidence  string  `help:"Confidence label of the match's distance, or exact if the SHA256 is the record's"`
+	MatchType   string  `help:"exact if the file's SHA256 is the matched record's, otherwise similar; empty in check mode"`
+	Count       int     `help:"Records of the repository that matched, with --group-by-repo"`
+	Suppressed  bool    `help:"Whether the match is allowlisted, with --show-suppressed"`
+
+	celestlsh.HashRecord
+}
+
+// recordFieldHelp describes the fields of celestlsh.HashRecord, which
+// cannot carry help tags of their own.
+var recordFieldHelp = map[string]string{
+	"RepoName":   "Tool the matched record belongs to",
+	"FileName":   "File name of the matched record",
+	"Version":    "Version of the matched record",
+	"TLSHHash":   "TLSH hash of the matched record",
+	"SHA256Hash": "SHA256 of the matched record",
+	"Imphash":    "Import hash of the matched record",
+	"DateAdded":  "Date the matched record was added to the database",
+	"Intel":      "Intel column of the matched record",
+	"Distance":   "TLSH distance to the matched record",
+}
+
+// unescapeTemplate turns the \n, \t and \\ escapes of a --template given on
+// the command line into the characters they stand for, since shells pass
+// them through literally.
+var unescapeTemplate = strings.NewReplacer(`\\`, `\`, `\n`, "\n", `\t`, "\t")
+
+// templateSample is a match rendered by parseTemplate. Its fields are all
+// filled in, with values of realistic lengths, so that templates which
+// slice them do not fail on it.
+var templateSample = templateRecord{
+	Path:        "sample.exe",
+	TLSH:        strings.Repeat("0", celestlsh.HashLength),
+	MD5:         strings.Repeat("0", 32),
+	SHA1:        strings.Repeat("0", 40),
+	SHA256:      strings.Repeat("0", 64),
+	FileImphash: strings.Repeat("0", 32),
+	Entropy:     7.5,
+	HighEntropy: true,
+	SSDeep:      "3:AXGBicFlgVNhBGcL6wCrFQEv:AXGHsNhxLsr2C",
+	Matched:     true,
+	Confidence:  exactConfidence,
+	MatchType:   matchExact,
+	Count:       1,
+	HashRecord: celestlsh.HashRecord{
+		RepoName:   "sample",
+		FileName:   "sample.exe",
+		Version:    "1.0",
+		TLSHHash:   strings.Repeat("0", celestlsh.HashLength),
+		SHA256Hash: strings.Repeat("0", 64),
+		Imphash:    strings.Repeat("0", 32),
+		DateAdded:  "2024-01-01",
+		Intel:      "sample",
+	},
+}
+
+// parseTemplate parses an output template and renders it once with
+// templateSample, so that unknown fields are reported before any file is
+// scanned rather than on every result.
+func parseTemplate(text string) (*template.Template, error) {
+	tmpl, err := template.New("output").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	if err := tmpl.Execute(io.Discard, templateSample); err != nil {
+		return nil, err
+	}
+	return tmpl, nil
+}
+
+// templateRecords returns the records --template renders for a result.
+func templateRecords(config Config, result scanResult) []templateRecord {
+	rec := templateRecord{
+		Path:        result.Path,
+		TLSH:        result.TLSH,
+		MD5:         result.MD5,
+		SHA1:        result.SHA1,
+		SHA256:      result.SHA256,
+		FileImphash: result.Digests.Imphash,
+		Entropy:     result.Digests.Entropy,
+		HighEntropy: result.HighEntropy,
+		SSDeep:      result.Digests.SSDeep,
+		Suppressed:  result.Suppressed,
+		MatchType:   result.MatchType,
+	}
+	if result.Match == nil {
+		return []templateRecord{rec}
+	}
+	rec.Matched = true
+	if len(result.Repos) == 0 {
+		rec.HashRecord = *result.Match
+		rec.Confidence = result.Confidence
+		rec.Count = 1
+		return []templateRecord{rec}
+	}
+	records := make([]templateRecord, len(result.Repos))
+	for i, r := range result.Repos {
+		records[i] = rec
+		records[i].HashRecord = r.HashRecord
+		records[i].Confidence = config.ConfidenceBands.label(r.Distance)
+		records[i].Count = r.Count
+	}
+	return records
+}
+
+// printTemplate renders a result through --template on stdout. Errors are
+// reported on stderr, and the remaining records still rendered.
+func printTemplate(config Config, result scanResult) {
+	for _, rec := range templateRecords(config, result) {
+		if err := config.Template.Execute(os.Stdout, rec); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %s: %v\n", displayPath(result.Path), err)
+		}
+	}
+}
+
+// printTemplateHelp lists the fields of templateRecord, as --template-help.
+func printTemplateHelp() {
+	fmt.Println("Fields available to --template, rendered once per result, or per repository with --group-by-repo:")
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	var list func(t reflect.Type)
+	list = func(t reflect.Type) {
+		for i := range t.NumField() {
+			f := t.Field(i)
+			if f.Anonymous {
+				list(f.Type)
+				continue
+			}
+			help := f.Tag.Get("help")
+			if help == "" {
+				help = recordFieldHelp[f.Name]
+			}
+			fmt.Fprintf(tw, "  .%s\t%s\t%s\n", f.Name, f.Type, help)
+		}
+	}
+	list(reflect.TypeOf(templateRecord{}))
+	tw.Flush()
+	fmt.Println("\nOn the command line, \\n, \\t and \\\\ stand for a newline, a tab and a backslash.")
+	fmt.Println("Example: --template '{{if .Matched}}{{.Path}}\\t{{.RepoName}}\\t{{.Distance}}\\n{{end}}'")
+}
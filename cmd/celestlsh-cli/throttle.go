@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// niceIdlePause is how long each worker rests after a file with
+// --nice-idle, leaving the disk to other processes in between.
+const niceIdlePause = 20 * time.Millisecond
+
+// throttle limits the bytes read from scanned files by all workers
+// together to a rate, for --throttle. It is a token bucket holding up to a
+// tenth of a second of reading: each read is let through, and the bucket
+// goes into debt for it, which the next read waits to pay off. A nil
+// throttle lets every read through.
+type throttle struct {
+	rate  float64 // bytes per second
+	burst float64
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// newThrottle returns the throttle for --throttle, or nil if it is not
+// set.
+func newThrottle(config Config) *throttle {
+	if config.Throttle <= 0 {
+		return nil
+	}
+	rate := float64(config.Throttle)
+	return &throttle{rate: rate, burst: rate / 10, tokens: rate / 10, last: time.Now()}
+}
+
+// wait takes n bytes from the bucket, once they are read, and sleeps until
+// they are paid for or ctx is done.
+func (t *throttle) wait(ctx context.Context, n int) error {
+	if t == nil || n <= 0 {
+		return nil
+	}
+	t.mu.Lock()
+	now := time.Now()
+	t.tokens = min(t.burst, t.tokens+now.Sub(t.last).Seconds()*t.rate)
+	t.last = now
+	t.tokens -= float64(n)
+	delay := time.Duration(-t.tokens / t.rate * float64(time.Second))
+	t.mu.Unlock()
+
+	return sleepContext(ctx, delay)
+}
+
+// throttledSource is a scanned file whose reads are paced by a throttle.
+type throttledSource struct {
+	scanSource
+	ctx      context.Context
+	throttle *throttle
+}
+
+func (t *throttledSource) Read(p []byte) (int, error) {
+	n, err := t.scanSource.Read(p)
+	if werr := t.throttle.wait(t.ctx, n); err == nil {
+		err = werr
+	}
+	return n, err
+}
+
+func (t *throttledSource) ReadAt(p []byte, off int64) (int, error) {
+	n, err := t.scanSource.ReadAt(p, off)
+	if werr := t.throttle.wait(t.ctx, n); err == nil {
+		err = werr
+	}
+	return n, err
+}
+
+// sleepContext sleeps for d, or until ctx is done.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return context.Cause(ctx)
+	}
+}
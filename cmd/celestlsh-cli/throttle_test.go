@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestThrottle(t *testing.T) {
+	const rate = 4 << 20
+	th := newThrottle(Config{Throttle: rate})
+
+	// Four workers read 2 MiB between them in 64 KiB reads. The bucket
+	// starts full, so all but a tenth of a second's worth is paced.
+	const workers, reads, chunk = 4, 8, 64 << 10
+	start := time.Now()
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range reads {
+				if err := th.wait(context.Background(), chunk); err != nil {
+					t.Error(err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	want := time.Second * (workers*reads*chunk - rate/10) / rate
+	if elapsed < want*9/10 || elapsed > want*3 {
+		t.Errorf("2 MiB at 4 MiB/s took %v, want about %v", elapsed, want)
+	}
+}
+
+func TestThrottleOff(t *testing.T) {
+	if th := newThrottle(Config{}); th != nil {
+		t.Fatalf("newThrottle without --throttle = %+v, want nil", th)
+	}
+	var th *throttle
+	start := time.Now()
+	for range 1000 {
+		if err := th.wait(context.Background(), 1<<30); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Errorf("nil throttle took %v", elapsed)
+	}
+
+	// Empty reads take nothing from the bucket.
+	th = newThrottle(Config{Throttle: 1})
+	for range 1000 {
+		if err := th.wait(context.Background(), 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if th.tokens != th.burst {
+		t.Errorf("tokens = %v after empty reads, want %v", th.tokens, th.burst)
+	}
+}
+
+func TestThrottleCancel(t *testing.T) {
+	th := newThrottle(Config{Throttle: 1 << 10})
+	cause := errors.New("scan interrupted")
+	ctx, cancel := context.WithCancelCause(context.Background())
+	time.AfterFunc(20*time.Millisecond, func() { cancel(cause) })
+
+	// A megabyte at a kilobyte per second would take over a quarter of an
+	// hour.
+	start := time.Now()
+	if err := th.wait(ctx, 1<<20); !errors.Is(err, cause) {
+		t.Errorf("wait = %v, want the cause of the cancellation", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("cancelled wait took %v", elapsed)
+	}
+}
+
+func TestScanThrottle(t *testing.T) {
+	dir := t.TempDir()
+	const files, size = 16, 256 << 10
+	for i := range files {
+		writeFile(t, dir, fmt.Sprintf("f%02d.bin", i), sampleData(uint64(i+100), size))
+	}
+	db := writeTestDatabase(t)
+
+	// 4 MiB at 8 MiB/s, less the tenth of a second the bucket starts
+	// with: at least 0.4s, whatever the number of workers.
+	const rate = 8
+	start := time.Now()
+	out, _, _, err := runCLI(t, "-s", "--jsonl", "--throttle", fmt.Sprint(rate), "--workers", "4", "--db", db, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	elapsed := time.Since(start)
+	_, summary := parseJSONL(t, out)
+
+	want := time.Second * (files*size - rate<<20/10) / (rate << 20)
+	if elapsed < want*9/10 {
+		t.Errorf("throttled scan took %v, want at least %v", elapsed, want)
+	}
+	if summary.Files != files || summary.Bytes < files*size {
+		t.Errorf("summary %+v, want %d files of %d bytes", summary, files, size)
+	}
+	if summary.Throttle != rate<<20 {
+		t.Errorf("summary throttle = %d, want %d", summary.Throttle, rate<<20)
+	}
+	// The initial burst lets the measured rate run a little over the limit.
+	if limit := 1.3 * (rate << 20); summary.BytesPerSecond <= 0 || summary.BytesPerSecond > limit {
+		t.Errorf("summary rate = %.0f B/s, want at most %.0f", summary.BytesPerSecond, limit)
+	}
+	var text strings.Builder
+	summary.report(&text)
+	if !strings.Contains(text.String(), "throttled to 8.0 MiB/s") {
+		t.Errorf("summary does not give the throttle:\n%s", text.String())
+	}
+
+	// Without --throttle, nothing is paced or reported.
+	_, stderr, _, err := runCLI(t, "-s", "--workers", "4", "--db", db, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(stderr, "Bytes processed:") || strings.Contains(stderr, "throttled") {
+		t.Errorf("unthrottled summary mentions a throttle:\n%s", stderr)
+	}
+}
+
+func TestScanNiceIdle(t *testing.T) {
+	dir := t.TempDir()
+	const files = 10
+	for i := range files {
+		writeFile(t, dir, fmt.Sprintf("f%02d.bin", i), sampleData(uint64(i+100), 8192))
+	}
+	start := time.Now()
+	out, _, _, err := runCLI(t, "-s", "--jsonl", "--nice-idle", "--workers", "1", "--db", writeTestDatabase(t), dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if elapsed, want := time.Since(start), files*niceIdlePause; elapsed < want {
+		t.Errorf("scan with --nice-idle took %v, want at least %v", elapsed, want)
+	}
+	if _, summary := parseJSONL(t, out); summary.Files != files {
+		t.Errorf("summary %+v, want %d files", summary, files)
+	}
+}
+
+func TestThrottleFlags(t *testing.T) {
+	db := writeTestDatabase(t)
+	for _, tt := range []struct {
+		args []string
+		want string
+	}{
+		{[]string{"-s", "--throttle", "-1", "--db", db, t.TempDir()}, "--throttle must be a positive number of MiB per second"},
+		{[]string{"-s", "--throttle", "0.0000001", "--db", db, t.TempDir()}, "--throttle must be a positive number of MiB per second"},
+		{[]string{"-c", "--throttle", "5", "--db", db, testRecords(t)[0].TLSHHash}, "--throttle and --nice-idle only apply to scan mode"},
+		{[]string{"-c", "--nice-idle", "--db", db, testRecords(t)[0].TLSHHash}, "--throttle and --nice-idle only apply to scan mode"},
+	} {
+		code, stderr := runMain(t, tt.args...)
+		if code == 0 || !strings.Contains(stderr, tt.want) {
+			t.Errorf("%v: exit %d, stderr %q; want %q", tt.args, code, stderr, tt.want)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+)
+
+// tlsConfigured reports whether any of the TLS options of the download
+// client are set.
+func tlsConfigured(config Config) bool {
+	return config.CACert != "" || config.ClientCert != "" || config.InsecureSkipVerify
+}
+
+// newHTTPTransport returns the transport of the download client, with the
+// TLS options of newTLSConfig. Requests are logged at debug level.
+func newHTTPTransport(config Config) (http.RoundTripper, error) {
+	if !tlsConfigured(config) {
+		return newLoggingTransport(nil), nil
+	}
+	tlsConfig, err := newTLSConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	return newLoggingTransport(transport), nil
+}
+
+// newTLSConfig returns the client TLS configuration with the CA bundle,
+// client certificate and verification set by --ca-cert, --client-cert,
+// --client-key and --insecure-skip-verify.
+func newTLSConfig(config Config) (*tls.Config, error) {
+	tlsConfig := &tls.Config{}
+	if config.CACert != "" {
+		pem, err := os.ReadFile(config.CACert)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read --ca-cert: %w", err)
+		}
+		// The bundle adds to the system roots rather than replacing them,
+		// so that hosts outside the intercepting proxy still verify.
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("failed to read --ca-cert: no PEM certificates found in %s", config.CACert)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if config.ClientCert != "" {
+		cert, err := tls.LoadX509KeyPair(config.ClientCert, config.ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load --client-cert and --client-key: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	if config.InsecureSkipVerify {
+		fmt.Fprintln(os.Stderr, "Warning: --insecure-skip-verify is set: server certificates are NOT verified, and anyone on the network path can tamper with the download")
+		tlsConfig.InsecureSkipVerify = true
+	}
+	return tlsConfig, nil
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Magonia-Research/CelesTLSH-CLI/pkg/celestlsh"
+)
+
+// writeTestCert writes a self-signed certificate for name, and its key, as
+// PEM files in dir, and returns their paths and the parsed pair.
+func writeTestCert(t *testing.T, dir, name string) (certPath, keyPath string, cert tls.Certificate) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	certPath = writeFile(t, dir, name+".pem", certPEM)
+	keyPath = writeFile(t, dir, name+".key", keyPEM)
+	cert, err = tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return certPath, keyPath, cert
+}
+
+func TestDownloadTLS(t *testing.T) {
+	dir := t.TempDir()
+	database, err := os.ReadFile(writeTestDatabase(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.Write(database) })
+
+	// The server's own certificate, as a TLS-intercepting proxy's CA would
+	// be given.
+	ts := httptest.NewTLSServer(handler)
+	defer ts.Close()
+	serverCA := writeFile(t, dir, "server.pem", pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ts.Certificate().Raw}))
+
+	// A mirror requiring a client certificate signed by clientCA.
+	clientCert, clientKey, client := writeTestCert(t, dir, "client")
+	otherCert, otherKey, _ := writeTestCert(t, dir, "other")
+	clientPool := x509.NewCertPool()
+	clientPool.AddCert(client.Leaf)
+	mtls := httptest.NewUnstartedServer(handler)
+	mtls.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientPool}
+	mtls.StartTLS()
+	defer mtls.Close()
+
+	tests := []struct {
+		name   string
+		url    string
+		config Config
+		err    string
+	}{
+		{"system roots", ts.URL, Config{}, "certificate"},
+		{"ca-cert", ts.URL, Config{CACert: serverCA}, ""},
+		{"insecure-skip-verify", ts.URL, Config{InsecureSkipVerify: true}, ""},
+		{"mtls without client cert", mtls.URL, Config{CACert: serverCA}, "certificate"},
+		{"mtls with another client cert", mtls.URL, Config{CACert: serverCA, ClientCert: otherCert, ClientKey: otherKey}, "certificate"},
+		{"mtls", mtls.URL, Config{CACert: serverCA, ClientCert: clientCert, ClientKey: clientKey}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			transport, err := newHTTPTransport(tt.config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			d := celestlsh.NewDownloader()
+			d.URL = tt.url
+			d.Client.Transport = transport
+			path := filepath.Join(t.TempDir(), "db.csv")
+			err = d.Download(context.Background(), path)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("Download = %v, want a %s error", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, _ := os.ReadFile(path); string(got) != string(database) {
+				t.Errorf("downloaded %d bytes, want the %d of the database", len(got), len(database))
+			}
+		})
+	}
+}
+
+func TestTLSConfigErrors(t *testing.T) {
+	dir := t.TempDir()
+	notPEM := writeFile(t, dir, "ca.txt", []byte("not a certificate\n"))
+	cert, _, _ := writeTestCert(t, dir, "client")
+	_, otherKey, _ := writeTestCert(t, dir, "other")
+
+	tests := []struct {
+		config Config
+		want   string
+	}{
+		{Config{CACert: filepath.Join(dir, "missing.pem")}, "failed to read --ca-cert"},
+		{Config{CACert: notPEM}, "no PEM certificates found in " + notPEM},
+		{Config{ClientCert: cert, ClientKey: otherKey}, "failed to load --client-cert and --client-key"},
+	}
+	for _, tt := range tests {
+		if _, err := newHTTPTransport(tt.config); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("newHTTPTransport(%+v) = %v, want %q", tt.config, err, tt.want)
+		}
+	}
+}
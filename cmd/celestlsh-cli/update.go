@@ -0,0 +1,254 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/Magonia-Research/CelesTLSH-CLI/pkg/celestlsh"
+)
+
+// latestReleaseURL is the GitHub API endpoint describing the newest
+// release, whose assets are named as in .github/workflows/go.yml. It is a
+// variable so that tests can point it at a local server.
+var latestReleaseURL = "https://api.github.com/repos/Magonia-Research/CelesTLSH-CLI/releases/latest"
+
+// checksumsAsset is the release asset listing the SHA256 of every other
+// asset, in sha256sum format.
+const checksumsAsset = "checksums.txt"
+
+// updateTimeout bounds each request of an update, including downloading
+// the binary.
+const updateTimeout = 5 * time.Minute
+
+type release struct {
+	TagName string         `json:"tag_name"`
+	Assets  []releaseAsset `json:"assets"`
+}
+
+type releaseAsset struct {
+	Name string `json:"name"`
+	URL  string `json:"browser_download_url"`
+}
+
+// asset returns the asset called name, or nil.
+func (r *release) asset(name string) *releaseAsset {
+	for i := range r.Assets {
+		if r.Assets[i].Name == name {
+			return &r.Assets[i]
+		}
+	}
+	return nil
+}
+
+// releaseAssetName is the name of the binary built for this platform.
+func releaseAssetName() string {
+	name := fmt.Sprintf("CelesTLSH-CLI-%s-%s", runtime.GOOS, runtime.GOARCH)
+	if runtime.GOOS == "windows" {
+		name += ".exe"
+	}
+	return name
+}
+
+// executeUpdate replaces the running binary with the latest release if it
+// is newer, after checking the download against the published checksum.
+// With --check-only it only reports whether there is one. Any failure
+// leaves the binary as it was.
+func executeUpdate(ctx context.Context, config Config) error {
+	transport, err := newHTTPTransport(config)
+	if err != nil {
+		return err
+	}
+	client := &http.Client{Timeout: updateTimeout, Transport: transport}
+
+	rel, err := latestRelease(ctx, client)
+	if err != nil {
+		return err
+	}
+
+	// A development build has no version to compare, and is taken to be
+	// older than any release.
+	newer := version == "dev" || compareSemver(rel.TagName, version) > 0
+	slog.Info("latest release", "version", rel.TagName, "current", version, "newer", newer)
+
+	if !newer {
+		fmt.Printf("celestlsh-cli %s is the latest release\n", version)
+		return nil
+	}
+	if config.CheckOnly {
+		fmt.Printf("Update available: %s (running %s)\n", rel.TagName, version)
+		return nil
+	}
+
+	name := releaseAssetName()
+	asset := rel.asset(name)
+	if asset == nil {
+		return fmt.Errorf("release %s has no binary for %s/%s (%s)", rel.TagName, runtime.GOOS, runtime.GOARCH, name)
+	}
+	sums := rel.asset(checksumsAsset)
+	if sums == nil {
+		return fmt.Errorf("release %s publishes no %s to verify the binary with; not updating", rel.TagName, checksumsAsset)
+	}
+	want, err := publishedChecksum(ctx, client, sums.URL, name)
+	if err != nil {
+		return err
+	}
+
+	exe, err := os.Executable()
+	if err == nil {
+		exe, err = filepath.EvalSymlinks(exe)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to locate the running binary: %w", err)
+	}
+	if err := installRelease(ctx, config, client, asset.URL, want, exe); err != nil {
+		return err
+	}
+
+	if !config.Quiet {
+		fmt.Printf("Updated %s from %s to %s\n", exe, version, rel.TagName)
+	}
+	return nil
+}
+
+// latestRelease asks the GitHub API for the latest release.
+func latestRelease(ctx context.Context, client *http.Client) (*release, error) {
+	body, err := get(ctx, client, latestReleaseURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up the latest release: %w", err)
+	}
+	defer body.Close()
+	var rel release
+	if err := json.NewDecoder(body).Decode(&rel); err != nil {
+		return nil, fmt.Errorf("failed to look up the latest release: %w", err)
+	}
+	return &rel, nil
+}
+
+// get fetches url, returning the body of a 200 response.
+func get(ctx context.Context, client *http.Client, url string) (io.ReadCloser, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, &celestlsh.RequestError{URL: url, Err: err}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, &celestlsh.RequestError{URL: url, Err: err}
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, &celestlsh.StatusError{URL: url, StatusCode: resp.StatusCode}
+	}
+	return resp.Body, nil
+}
+
+// publishedChecksum returns the SHA256 listed for name in the checksums
+// file at url.
+func publishedChecksum(ctx context.Context, client *http.Client, url, name string) (string, error) {
+	body, err := get(ctx, client, url)
+	if err != nil {
+		return "", fmt.Errorf("failed to download %s: %w", checksumsAsset, err)
+	}
+	defer body.Close()
+
+	scanner := bufio.NewScanner(body)
+	for scanner.Scan() {
+		// sha256sum marks binary mode files with a * before the name.
+		sum, file, ok := strings.Cut(scanner.Text(), " ")
+		if ok && strings.TrimLeft(file, " *") == name {
+			return strings.ToLower(sum), nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", fmt.Errorf("failed to download %s: %w", checksumsAsset, err)
+	}
+	return "", fmt.Errorf("%s lists no checksum for %s; not updating", checksumsAsset, name)
+}
+
+// installRelease downloads the binary at url beside exe, checks its SHA256
+// against want and then moves it over exe. The download is removed if any
+// step fails.
+func installRelease(ctx context.Context, config Config, client *http.Client, url, want, exe string) (err error) {
+	info, err := os.Stat(exe)
+	if err != nil {
+		return fmt.Errorf("failed to locate the running binary: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(exe), "."+filepath.Base(exe)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to write the new binary: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+
+	body, err := get(ctx, client, url)
+	if err != nil {
+		return fmt.Errorf("failed to download the new binary: %w", err)
+	}
+	defer body.Close()
+
+	var progress *progressLine
+	var r io.Reader = body
+	if progressEnabled(config) {
+		var received atomic.Int64
+		progress = startProgress(downloadProgress(&received, -1))
+		r = &countingReader{r: body, n: &received}
+	}
+	sum := sha256.New()
+	_, err = io.Copy(io.MultiWriter(tmp, sum), r)
+	progress.finish()
+	if err != nil {
+		return fmt.Errorf("failed to download the new binary: %w", err)
+	}
+	if got := hex.EncodeToString(sum.Sum(nil)); got != want {
+		return fmt.Errorf("the new binary's SHA256 is %s but %s lists %s; not updating", got, checksumsAsset, want)
+	}
+	if err = tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write the new binary: %w", err)
+	}
+	if err = os.Chmod(tmp.Name(), info.Mode().Perm()|0111); err != nil {
+		return fmt.Errorf("failed to write the new binary: %w", err)
+	}
+	if err = replaceExecutable(tmp.Name(), exe); err != nil {
+		return fmt.Errorf("failed to replace %s: %w", exe, err)
+	}
+	return nil
+}
+
+// replaceExecutable moves the file at path over exe. Windows does not
+// allow replacing a running binary, but does allow renaming it, so there
+// exe is first moved aside to exe.old, and moved back if the new one
+// cannot take its place; the .old file is removed by the next update.
+func replaceExecutable(path, exe string) error {
+	if runtime.GOOS != "windows" {
+		return os.Rename(path, exe)
+	}
+	old := exe + ".old"
+	if err := os.Remove(old); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if err := os.Rename(exe, old); err != nil {
+		return err
+	}
+	if err := os.Rename(path, exe); err != nil {
+		os.Rename(old, exe)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// updateCheckInterval is how often the update check asks for the latest
+// release; in between, the answer saved in the cache is used.
+const updateCheckInterval = 24 * time.Hour
+
+// updateCheckTimeout bounds the update check, which gives up quietly when
+// there is no network.
+const updateCheckTimeout = 3 * time.Second
+
+// updateCheckRetry is how long a check cut short by the end of a run is
+// left before the next run tries again, so that a stream of short runs
+// sends a request at most this often.
+const updateCheckRetry = time.Hour
+
+// updateCheckState is saved in the user cache directory between runs.
+// Checked is when a request last completed, or failed, and Attempted when
+// one was last sent.
+type updateCheckState struct {
+	Checked   time.Time `json:"checked"`
+	Attempted time.Time `json:"attempted"`
+	Latest    string    `json:"latest,omitempty"`
+}
+
+// updateCheck is an update check started by startUpdateCheck.
+type updateCheck struct {
+	// known is the latest release found by an earlier run, if any.
+	known string
+	// latest receives the latest release once this run's request, if it
+	// sent one, completes.
+	latest chan string
+}
+
+// updateCheckEnabled reports whether a run should look for a newer
+// release: not for development builds, which have no version to compare,
+// with --quiet or CELESTLSH_NO_UPDATE_CHECK, or in the modes that fetch
+// from the network themselves.
+func updateCheckEnabled(config Config) bool {
+	if v := os.Getenv("CELESTLSH_NO_UPDATE_CHECK"); v != "" && v != "0" {
+		return false
+	}
+	return version != "dev" && !config.Quiet && config.Mode != "download" && config.Mode != "update"
+}
+
+// startUpdateCheck looks up the latest release in the background, at most
+// once per updateCheckInterval, and returns the check, or nil when it is
+// disabled. The request goes through the transport of the download client,
+// so that --ca-cert and the proxy settings apply to it too.
+func startUpdateCheck(config Config) *updateCheck {
+	if !updateCheckEnabled(config) {
+		return nil
+	}
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return nil
+	}
+	path := filepath.Join(dir, "celestlsh-cli", "update-check.json")
+	transport, err := newHTTPTransport(config)
+	if err != nil {
+		return nil
+	}
+
+	var state updateCheckState
+	if data, err := os.ReadFile(path); err == nil {
+		json.Unmarshal(data, &state)
+	}
+	check := &updateCheck{known: state.Latest}
+	if recent(state.Checked, updateCheckInterval) || recent(state.Attempted, updateCheckRetry) {
+		return check
+	}
+
+	// The attempt is recorded before the request, since the run may exit
+	// before it completes. A check cut short is tried again by a run after
+	// updateCheckRetry, and one that fails after the next interval, keeping
+	// the last answer meanwhile.
+	state.Attempted = time.Now()
+	saveUpdateCheck(path, state)
+
+	check.latest = make(chan string, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), updateCheckTimeout)
+		defer cancel()
+		client := &http.Client{Transport: transport}
+		rel, err := latestRelease(ctx, client)
+		if err != nil {
+			slog.Debug("update check failed", "error", err)
+		} else {
+			state.Latest = rel.TagName
+		}
+		state.Checked = time.Now()
+		saveUpdateCheck(path, state)
+		check.latest <- state.Latest
+	}()
+	return check
+}
+
+// recent reports whether t is less than d ago.
+func recent(t time.Time, d time.Duration) bool {
+	age := time.Since(t)
+	return age >= 0 && age < d
+}
+
+// saveUpdateCheck writes the update check state to path, ignoring errors:
+// without a cache the check just runs again next time.
+func saveUpdateCheck(path string, state updateCheckState) {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err == nil {
+		os.WriteFile(path, data, 0644)
+	}
+}
+
+// printUpdateNotice prints a line on stderr if the update check found a
+// release newer than this one. It does not wait for a request still in
+// flight: the run goes by the answer of an earlier one, and the new answer
+// is saved for the next run if it arrives in time.
+func printUpdateNotice(check *updateCheck) {
+	if check == nil {
+		return
+	}
+	tag := check.known
+	select {
+	case latest := <-check.latest:
+		if latest != "" {
+			tag = latest
+		}
+	default:
+	}
+	if tag != "" && compareSemver(tag, version) > 0 {
+		fmt.Fprintf(os.Stderr, "Note: a newer version %s is available (running %s); run celestlsh-cli update\n", tag, version)
+	}
+}
+
+// compareSemver compares two semantic versions, with or without a leading
+// v: numerically by major, minor and patch, then with a pre-release such as
+// 1.2.0-rc.1 before the release itself, ignoring build metadata. Versions
+// that are not semantic fall back to compareVersions.
+func compareSemver(a, b string) int {
+	va, okA := parseSemver(a)
+	vb, okB := parseSemver(b)
+	if !okA || !okB {
+		return compareVersions(a, b)
+	}
+	for i := range va.core {
+		if c := va.core[i] - vb.core[i]; c != 0 {
+			return c
+		}
+	}
+	switch {
+	case va.pre == nil && vb.pre == nil:
+		return 0
+	case va.pre == nil:
+		return 1
+	case vb.pre == nil:
+		return -1
+	}
+	for i := 0; i < len(va.pre) && i < len(vb.pre); i++ {
+		if c := comparePrerelease(va.pre[i], vb.pre[i]); c != 0 {
+			return c
+		}
+	}
+	return len(va.pre) - len(vb.pre)
+}
+
+type semver struct {
+	core [3]int
+	pre  []string
+}
+
+// parseSemver parses MAJOR[.MINOR[.PATCH]][-PRE][+BUILD], taking missing
+// minor and patch numbers as 0.
+func parseSemver(v string) (semver, bool) {
+	var s semver
+	v = strings.TrimPrefix(v, "v")
+	v, _, _ = strings.Cut(v, "+")
+	v, pre, hasPre := strings.Cut(v, "-")
+	parts := strings.Split(v, ".")
+	if len(parts) > 3 {
+		return s, false
+	}
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return s, false
+		}
+		s.core[i] = n
+	}
+	if hasPre {
+		s.pre = strings.Split(pre, ".")
+	}
+	return s, true
+}
+
+// comparePrerelease compares pre-release identifiers the semver way:
+// numeric ones numerically and before alphanumeric ones, which compare as
+// text.
+func comparePrerelease(a, b string) int {
+	na, errA := strconv.Atoi(a)
+	nb, errB := strconv.Atoi(b)
+	switch {
+	case errA == nil && errB == nil:
+		return na - nb
+	case errA == nil:
+		return -1
+	case errB == nil:
+		return 1
+	}
+	return strings.Compare(a, b)
+}
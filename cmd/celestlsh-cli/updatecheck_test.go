@@ -0,0 +1,229 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCompareSemver(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"v1.2.3", "1.2.3", 0},
+		{"v1.10.0", "v1.9.2", 1},
+		{"v2", "v1.99.99", 1},
+		{"v1.2", "v1.2.0", 0},
+		{"v1.2.0-rc.1", "v1.2.0", -1},
+		{"v1.2.0-rc.2", "v1.2.0-rc.10", -1},
+		{"v1.2.0-alpha", "v1.2.0-1", 1},
+		{"v1.2.0-alpha", "v1.2.0-alpha.1", -1},
+		{"v1.2.0-beta", "v1.2.0-alpha.9", 1},
+		{"v1.2.0+build.5", "v1.2.0+build.9", 0},
+		{"v1.2.0+build", "v1.2.1", -1},
+		// Versions that are not semantic compare piece by piece.
+		{"2024.10", "2024.9", 1},
+		{"nightly", "v1.0.0", -1},
+	}
+	for _, tt := range tests {
+		got := compareSemver(tt.a, tt.b)
+		if sign(got) != tt.want {
+			t.Errorf("compareSemver(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+		if back := compareSemver(tt.b, tt.a); sign(back) != -tt.want {
+			t.Errorf("compareSemver(%q, %q) = %d, want %d", tt.b, tt.a, back, -tt.want)
+		}
+	}
+}
+
+func sign(n int) int {
+	switch {
+	case n < 0:
+		return -1
+	case n > 0:
+		return 1
+	}
+	return 0
+}
+
+func TestParseSemver(t *testing.T) {
+	tests := []struct {
+		in   string
+		core [3]int
+		pre  string
+		ok   bool
+	}{
+		{"v1.2.3", [3]int{1, 2, 3}, "", true},
+		{"1.2", [3]int{1, 2, 0}, "", true},
+		{"v3", [3]int{3, 0, 0}, "", true},
+		{"v1.2.3-rc.1+linux.amd64", [3]int{1, 2, 3}, "rc.1", true},
+		{"v1.2.3+meta-data", [3]int{1, 2, 3}, "", true},
+		{"v1.2.3.4", [3]int{}, "", false},
+		{"v1.x", [3]int{}, "", false},
+		{"v1.-2", [3]int{}, "", false},
+		{"", [3]int{}, "", false},
+		{"dev", [3]int{}, "", false},
+	}
+	for _, tt := range tests {
+		v, ok := parseSemver(tt.in)
+		if ok != tt.ok || ok && (v.core != tt.core || strings.Join(v.pre, ".") != tt.pre) {
+			t.Errorf("parseSemver(%q) = %v, %v; want %v, %q, %v", tt.in, v.core, v.pre, tt.core, tt.pre, tt.ok)
+		}
+	}
+}
+
+// setupUpdateCheck makes the update check run as in a release build,
+// against handler, with its state in a temporary cache directory whose
+// state file path it returns.
+func setupUpdateCheck(t *testing.T, server func(http.Handler) *httptest.Server, handler http.HandlerFunc) (statePath string, ts *httptest.Server) {
+	t.Helper()
+	cache := t.TempDir()
+	t.Setenv("XDG_CACHE_HOME", cache)
+	t.Setenv("CELESTLSH_NO_UPDATE_CHECK", "")
+	oldVersion, oldURL := version, latestReleaseURL
+	t.Cleanup(func() { version, latestReleaseURL = oldVersion, oldURL })
+
+	ts = server(handler)
+	t.Cleanup(ts.Close)
+	version, latestReleaseURL = "v1.2.0", ts.URL
+	return filepath.Join(cache, "celestlsh-cli", "update-check.json"), ts
+}
+
+func readUpdateCheck(t *testing.T, path string) updateCheckState {
+	t.Helper()
+	var state updateCheckState
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(data, &state); err != nil {
+		t.Fatal(err)
+	}
+	return state
+}
+
+func TestUpdateCheck(t *testing.T) {
+	release := make(chan struct{})
+	var requests atomic.Int32
+	path, _ := setupUpdateCheck(t, httptest.NewServer, func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		<-release
+		fmt.Fprint(w, `{"tag_name": "v1.3.0"}`)
+	})
+
+	start := time.Now()
+	check := startUpdateCheck(Config{Mode: "check"})
+	// The attempt is on disk before the answer, for a run that exits first,
+	// and a run meanwhile does not send another request.
+	if state := readUpdateCheck(t, path); state.Attempted.Before(start) || !state.Checked.IsZero() || state.Latest != "" {
+		t.Errorf("state before the answer = %+v, want the attempt recorded", state)
+	}
+	if c := startUpdateCheck(Config{Mode: "check"}); c.latest != nil || c.known != "" {
+		t.Errorf("check during the first = %+v, want no request and nothing known", c)
+	}
+	close(release)
+	if tag := <-check.latest; tag != "v1.3.0" {
+		t.Errorf("latest = %q, want v1.3.0", tag)
+	}
+	if state := readUpdateCheck(t, path); state.Latest != "v1.3.0" || state.Checked.Before(start) {
+		t.Errorf("state after the answer = %+v, want v1.3.0", state)
+	}
+
+	// The next run within a day uses the saved answer.
+	if c := startUpdateCheck(Config{Mode: "check"}); c.latest != nil || c.known != "v1.3.0" || requests.Load() != 1 {
+		t.Errorf("second check = %+v after %d requests, want the saved v1.3.0 after 1", c, requests.Load())
+	}
+
+	// A run a day later sends a request again, and meanwhile knows the
+	// earlier answer.
+	saveUpdateCheck(path, updateCheckState{Checked: start.Add(-updateCheckInterval), Latest: "v1.3.0"})
+	if c := startUpdateCheck(Config{Mode: "check"}); c.known != "v1.3.0" || c.latest == nil || <-c.latest != "v1.3.0" || requests.Load() != 2 {
+		t.Errorf("check a day later = %+v after %d requests, want v1.3.0 known and a second request", c, requests.Load())
+	}
+	if latest := startUpdateCheck(Config{Mode: "check", Quiet: true}); latest != nil {
+		t.Error("update check ran with --quiet")
+	}
+}
+
+func TestUpdateCheckCACert(t *testing.T) {
+	path, ts := setupUpdateCheck(t, httptest.NewTLSServer, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"tag_name": "v1.3.0"}`)
+	})
+	ca := writeFile(t, t.TempDir(), "ca.pem", pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ts.Certificate().Raw}))
+
+	// Without the CA the check fails quietly, and is not retried that day.
+	if tag := <-startUpdateCheck(Config{Mode: "check"}).latest; tag != "" {
+		t.Errorf("latest without --ca-cert = %q, want none", tag)
+	}
+	if state := readUpdateCheck(t, path); state.Checked.IsZero() || state.Latest != "" {
+		t.Errorf("state after a failed check = %+v", state)
+	}
+
+	os.Remove(path)
+	if tag := <-startUpdateCheck(Config{Mode: "check", CACert: ca}).latest; tag != "v1.3.0" {
+		t.Errorf("latest with --ca-cert = %q, want v1.3.0", tag)
+	}
+}
+
+func TestPrintUpdateNotice(t *testing.T) {
+	oldVersion := version
+	t.Cleanup(func() { version = oldVersion })
+	version = "v1.2.0"
+
+	notice := func(check *updateCheck) string {
+		t.Helper()
+		f, err := os.Create(filepath.Join(t.TempDir(), "stderr"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		oldErr := os.Stderr
+		os.Stderr = f
+		printUpdateNotice(check)
+		os.Stderr = oldErr
+		f.Close()
+		out, _ := os.ReadFile(f.Name())
+		return string(out)
+	}
+
+	answered := func(tag string) *updateCheck {
+		c := &updateCheck{latest: make(chan string, 1)}
+		c.latest <- tag
+		return c
+	}
+	if out := notice(answered("v1.3.0")); !strings.Contains(out, "a newer version v1.3.0 is available (running v1.2.0)") {
+		t.Errorf("notice for v1.3.0 = %q", out)
+	}
+	for _, tag := range []string{"v1.2.0", "v1.1.9", ""} {
+		if out := notice(answered(tag)); out != "" {
+			t.Errorf("notice for %q = %q, want none", tag, out)
+		}
+	}
+	if out := notice(&updateCheck{known: "v1.3.0"}); !strings.Contains(out, "v1.3.0") {
+		t.Errorf("notice for a saved v1.3.0 = %q", out)
+	}
+
+	// A request still in flight is not waited for: the run goes by what
+	// an earlier one found.
+	for _, known := range []string{"", "v1.3.0"} {
+		start := time.Now()
+		out := notice(&updateCheck{known: known, latest: make(chan string)})
+		if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+			t.Errorf("notice with a request in flight took %v", elapsed)
+		}
+		if strings.Contains(out, "v1.3.0") != (known != "") {
+			t.Errorf("notice with a request in flight and %q saved = %q", known, out)
+		}
+	}
+	if out := notice(nil); out != "" {
+		t.Errorf("notice without a check = %q", out)
+	}
+}
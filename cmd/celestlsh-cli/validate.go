@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/Magonia-Research/CelesTLSH-CLI/pkg/celestlsh"
+)
+
+// verdict is the outcome of validating one TLSH string. TLSH is the hash
+// in the form the rest of the tool compares, only set when it is valid.
+type verdict struct {
+	Input string `json:"input"`
+	Valid bool   `json:"valid"`
+	TLSH  string `json:"tlsh,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// executeValidate checks that each input parses as a TLSH hash, with the
+// same rules as every other mode, so that a valid hash can be used
+// anywhere. An input of "-" reads more hashes from stdin, one per line.
+func executeValidate(config Config) (status, error) {
+	var inputs []string
+	for _, arg := range config.Hashes {
+		if arg != "-" {
+			inputs = append(inputs, strings.TrimSpace(arg))
+			continue
+		}
+		lines, err := readFileList("-", false)
+		if err != nil {
+			return statusOK, err
+		}
+		inputs = append(inputs, lines...)
+	}
+
+	verdicts := make([]verdict, len(inputs))
+	invalid := 0
+	for i, input := range inputs {
+		verdicts[i] = validate(input)
+		if !verdicts[i].Valid {
+			invalid++
+		}
+	}
+
+	switch {
+	case config.OutputJSON:
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(verdicts); err != nil {
+			return statusOK, fmt.Errorf("failed to write results: %w", err)
+		}
+	case config.OutputJSONL:
+		for _, v := range verdicts {
+			line, err := json.Marshal(v)
+			if err != nil {
+				return statusOK, fmt.Errorf("failed to write results: %w", err)
+			}
+			fmt.Println(string(line))
+		}
+	default:
+		for _, v := range verdicts {
+			if v.Valid {
+				fmt.Printf("%s: %s\n", displayPath(v.Input), paint(styleClean, "OK"))
+			} else {
+				fmt.Printf("%s: %s\n", displayPath(v.Input), paint(styleMatch, "invalid ("+v.Error+")"))
+			}
+		}
+	}
+
+	return batchStatus(0, invalid), nil
+}
+
+// validate returns the verdict on input, giving the reason the library
+// rejected it without the "error parsing hash" wrapping.
+func validate(input string) verdict {
+	err := celestlsh.ValidateHash(input)
+	if err == nil {
+		return verdict{Input: input, Valid: true, TLSH: strings.ToLower(celestlsh.NormalizeHash(input))}
+	}
+	var hashErr *celestlsh.HashError
+	if errors.As(err, &hashErr) {
+		err = hashErr.Err
+	}
+	return verdict{Input: input, Error: err.Error()}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/Magonia-Research/CelesTLSH-CLI/pkg/celestlsh"
+)
+
+// dbProblem is a fault validate-db mode found in the database: in its
+// header, on line 1, or in the row on Line.
+type dbProblem struct {
+	Line   int    `json:"line"`
+	Reason string `json:"reason"`
+}
+
+// dbValidation is the result of validate-db mode.
+type dbValidation struct {
+	Path     string      `json:"path"`
+	Rows     int         `json:"rows"`
+	Valid    bool        `json:"valid"`
+	Problems []dbProblem `json:"problems"`
+}
+
+// executeValidateDB checks that the --db database follows the CSV schema
+// strictly, as rows contributed upstream must: the exact header, and rows
+// that db-import would accept, with a well-formed Imphash, none repeating
+// an earlier row's file and hashes. Unlike loading the database, which
+// skips faulty rows, it reports every one with its line number.
+func executeValidateDB(config Config) (status, error) {
+	f, err := os.Open(config.DbPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return statusOK, &celestlsh.DatabaseNotFoundError{Path: config.DbPath}
+	}
+	if err != nil {
+		return statusOK, fmt.Errorf("failed to read database: %w", err)
+	}
+	defer f.Close()
+
+	v, err := validateDatabase(f)
+	if err != nil {
+		return statusOK, err
+	}
+	v.Path = config.DbPath
+
+	if config.OutputJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(v); err != nil {
+			return statusOK, fmt.Errorf("failed to write results: %w", err)
+		}
+	} else {
+		for _, p := range v.Problems {
+			fmt.Printf("%s:%d: %s\n", displayPath(v.Path), p.Line, paint(styleMatch, p.Reason))
+		}
+		if !config.Quiet {
+			fmt.Fprintf(os.Stderr, "%d rows checked, %d problems\n", v.Rows, len(v.Problems))
+		}
+	}
+	return batchStatus(0, len(v.Problems)), nil
+}
+
+// validateDatabase reads a database CSV from r and reports its faults.
+// Only a file that is not CSV at all is an error.
+func validateDatabase(r io.Reader) (dbValidation, error) {
+	v := dbValidation{Problems: []dbProblem{}}
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		v.Problems = append(v.Problems, dbProblem{Line: 1, Reason: "no header"})
+		return v, nil
+	}
+	if err != nil {
+		return v, &celestlsh.DatabaseError{Op: "reading CSV header", Err: err}
+	}
+	if !slices.Equal(header, celestlsh.Header) {
+		v.Problems = append(v.Problems, dbProblem{Line: 1, Reason: fmt.Sprintf("header %q, want %q", strings.Join(header, ","), strings.Join(celestlsh.Header, ","))})
+	}
+
+	// A release may ship the same file under several names, so only a
+	// row repeating the name as well is a duplicate.
+	type rowKey struct {
+		repo, file string
+		importKey
+	}
+	first := make(map[rowKey]int)
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			var parseErr *csv.ParseError
+			if !errors.As(err, &parseErr) {
+				return v, &celestlsh.DatabaseError{Op: "reading CSV record", Err: err}
+			}
+			v.Rows++
+			v.Problems = append(v.Problems, dbProblem{Line: parseErr.StartLine, Reason: parseErr.Err.Error()})
+			continue
+		}
+		v.Rows++
+		line, _ := reader.FieldPos(0)
+
+		reason := invalidImportRow(record)
+		if reason == "" && record[5] != "" && !isImphash(record[5]) {
+			reason = fmt.Sprintf("invalid Imphash %q", record[5])
+		}
+		if reason == "" {
+			key := rowKey{record[0], record[1], newImportKey(record[3], record[4])}
+			if at, ok := first[key]; ok {
+				reason = fmt.Sprintf("repeats line %d", at)
+			} else {
+				first[key] = line
+			}
+		}
+		if reason != "" {
+			v.Problems = append(v.Problems, dbProblem{Line: line, Reason: reason})
+		}
+	}
+	v.Valid = len(v.Problems) == 0
+	return v, nil
+}
+
+// isImphash reports whether s is an import hash: an MD5 in hex.
+func isImphash(s string) bool {
+	_, err := hex.DecodeString(s)
+	return err == nil && len(s) == 32
+}
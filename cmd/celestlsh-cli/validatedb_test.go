@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestValidateDBManifest(t *testing.T) {
+	pe, err := os.ReadFile(filepath.Join("..", "..", "pkg", "celestlsh", "testdata", "imports32.exe"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	writeFile(t, dir, "bin/tool.exe", sampleData(1, 8192))
+	writeFile(t, dir, "bin/loader.exe", pe)
+	writeFile(t, dir, "README", []byte("tiny"))
+	// The same file under a second name is not a duplicate.
+	writeFile(t, dir, "dist/tool.exe", sampleData(1, 8192))
+
+	out, _, _, err := runCLI(t, "--manifest", dir, "--repo-name", "Tool, Inc", "--release-version", "v1.0", "--intel", `said "hi"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{",N/A,", ",cf78e464f5009a4a79b0fbab8fd16e25,"} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("manifest lacks %s:\n%s", want, out)
+		}
+	}
+	manifest := writeFile(t, t.TempDir(), "manifest.csv", []byte(out))
+
+	out, _, st, err := runCLI(t, "--validate-db", "--json", "--db", manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var v dbValidation
+	if err := json.Unmarshal([]byte(out), &v); err != nil {
+		t.Fatal(err)
+	}
+	if st != statusOK || !v.Valid || v.Rows != 4 || len(v.Problems) != 0 {
+		t.Errorf("manifest: status %d, %+v; want 4 valid rows", st, v)
+	}
+}
+
+func TestValidateDBProblems(t *testing.T) {
+	rows := strings.SplitAfter(strings.TrimSpace(string(mustRead(t, writeTestDatabase(t)))), "\n")
+	bad := rows[0] + rows[1] + rows[1] +
+		"KnownTool,short.exe,v1,T1ABC,,,,\n" +
+		"KnownTool,other.exe,v1,N/A," + strings.Repeat("ab", 32) + ",not-an-md5,,\n" +
+		"KnownTool,\"open.exe\n"
+	db := writeFile(t, t.TempDir(), "db.csv", []byte(bad))
+
+	out, _, st, err := runCLI(t, "--validate-db", "--db", db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st != statusPartial {
+		t.Errorf("status = %d, want %d", st, statusPartial)
+	}
+	for _, want := range []string{":3: repeats line 2", ":4: invalid SHA256", ":5: invalid Imphash", ":6: "} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output lacks %q:\n%s", want, out)
+		}
+	}
+
+	bad = strings.Replace(bad, "Repo Name", "Repository", 1)
+	out, _, _, _ = runCLI(t, "--validate-db", "--db", writeFile(t, t.TempDir(), "db.csv", []byte(bad)))
+	if !strings.Contains(out, ":1: header") {
+		t.Errorf("renamed column not reported:\n%s", out)
+	}
+}
+
+func mustRead(t testing.TB, path string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/Magonia-Research/CelesTLSH-CLI/pkg/celestlsh"
+)
+
+// defaultVerifyDistance is the verify mode tolerance without --max-distance:
+// only an identical digest passes.
+const defaultVerifyDistance = 0
+
+// executeVerify hashes a file and compares it with the TLSH hash it is
+// expected to have, such as that of an approved build. The file passes if
+// it is within --max-distance of the expected hash, and otherwise the run
+// exits with statusMismatch.
+func executeVerify(ctx context.Context, config Config) (status, error) {
+	tolerance := config.MaxDistance
+	if tolerance < 0 {
+		tolerance = defaultVerifyDistance
+	}
+
+	path, expected := config.Paths[0], config.Hashes[0]
+	if err := celestlsh.ValidateHash(expected); err != nil {
+		return statusOK, fmt.Errorf("invalid expected TLSH hash: %w", err)
+	}
+
+	hasher := newHasher(config)
+	actual, err := hasher.HashFile(ctx, path)
+	if err != nil {
+		return statusOK, fmt.Errorf("%s: %s", displayPath(path), hashError(err))
+	}
+
+	distance, err := celestlsh.Distance(actual, expected)
+	if err != nil {
+		return statusOK, fmt.Errorf("failed to calculate TLSH distance: %w", err)
+	}
+	ok := distance <= tolerance
+
+	if config.Quiet {
+		fmt.Println(distance)
+	} else {
+		fmt.Printf("TLSH hash of %s: %s\n", displayPath(path), actual)
+		fmt.Printf("Expected TLSH hash: %s\n", expected)
+		fmt.Printf("Distance: %d\n", distance)
+		if ok {
+			fmt.Println(paint(styleClean, fmt.Sprintf("Verified: within the maximum distance of %d", tolerance)))
+		} else {
+			fmt.Println(paint(styleMatch, fmt.Sprintf("Not verified: further than the maximum distance of %d", tolerance)))
+		}
+	}
+
+	if !ok {
+		return statusMismatch, nil
+	}
+	return statusOK, nil
+}
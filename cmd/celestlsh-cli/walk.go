@@ -0,0 +1,303 @@
+package main
+
+import (
+	"context"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// virtualFilesystems are the filesystem types whose mounts scan mode skips
+// unless --all-filesystems is given: kernel pseudo-filesystems, whose files
+// describe the running system rather than hold data and can block when
+// read, and memory-backed ones.
+var virtualFilesystems = map[string]bool{
+	"proc":        true,
+	"sysfs":       true,
+	"devtmpfs":    true,
+	"devpts":      true,
+	"tmpfs":       true,
+	"ramfs":       true,
+	"cgroup":      true,
+	"cgroup2":     true,
+	"securityfs":  true,
+	"debugfs":     true,
+	"tracefs":     true,
+	"pstore":      true,
+	"bpf":         true,
+	"configfs":    true,
+	"fusectl":     true,
+	"mqueue":      true,
+	"hugetlbfs":   true,
+	"binfmt_misc": true,
+	"efivarfs":    true,
+	"selinuxfs":   true,
+	"autofs":      true,
+	"nsfs":        true,
+}
+
+// treeWalk is the state of walking one directory given to scan mode.
+type treeWalk struct {
+	root string
+
+	// realRoot and visited are only set with --follow-symlinks. realRoot
+	// is root with every link resolved, to tell which links lead outside
+	// it, and visited holds every directory entered, to stop link loops.
+	realRoot string
+	visited  *visitedSet
+}
+
+// walkTree scans the files below dir, reporting them under display, which
+// differs from dir when dir is the target of a followed symbolic link.
+func (s *scanner) walkTree(ctx, work context.Context, w *treeWalk, dir, display string) error {
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		real := path
+		if display != dir {
+			rel, _ := filepath.Rel(dir, path)
+			path = filepath.Join(display, rel)
+		}
+		if err != nil {
+			s.emit(scanResult{Path: path, Error: err.Error()})
+			return nil
+		}
+		// The top of the walk was filtered, and recorded as visited,
+		// before it was entered.
+		if path == display {
+			return nil
+		}
+
+		if d.IsDir() && s.checkpoint.skipDir(path) {
+			return filepath.SkipDir
+		}
+
+		rel, _ := filepath.Rel(w.root, path)
+		if d.IsDir() && s.beyondDepth(path, rel) {
+			return filepath.SkipDir
+		}
+		if s.config.SkipHidden && isHidden(d) {
+			s.stats.skippedHidden.Add(1)
+			s.note(path, "hidden")
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() && s.filter.skipDir(rel) {
+			s.stats.filteredDir.Add(1)
+			s.note(path, "directory excluded by filter")
+			return filepath.SkipDir
+		}
+		if !d.IsDir() && d.Type()&fs.ModeSymlink == 0 && s.filter.skipFile(rel) {
+			s.stats.filteredFile.Add(1)
+			s.note(path, "excluded by filter")
+			return nil
+		}
+		if d.Type().IsRegular() && !s.wantedExt(path) {
+			return nil
+		}
+
+		switch {
+		case d.IsDir():
+			if rel, err := filepath.Rel(dir, real); err == nil && s.skipDirectory(path, filepath.Join(absDir, rel)) {
+				return filepath.SkipDir
+			}
+			if w.visited != nil {
+				info, err := d.Info()
+				if err != nil {
+					s.emit(scanResult{Path: path, Error: err.Error()})
+					return filepath.SkipDir
+				}
+				if !w.visited.add(real, info) {
+					// Already reached through a followed link.
+					s.skipLink(path, "directory already scanned")
+					return filepath.SkipDir
+				}
+			}
+
+		case d.Type()&fs.ModeSymlink != 0:
+			return s.followLink(ctx, work, w, path, real)
+
+		case d.Type().IsRegular():
+			var info fs.FileInfo
+			if s.config.MaxFileSize > 0 || s.queue.needsInfo() {
+				if info, err = d.Info(); err != nil {
+					s.emit(scanResult{Path: path, Error: err.Error()})
+					return nil
+				}
+				if s.tooLarge(path, info) {
+					return nil
+				}
+			}
+			s.enqueue(ctx, work, path, info)
+
+		default:
+			s.skipSpecial(path)
+		}
+		return ctx.Err()
+	})
+}
+
+// followLink scans what the symbolic link at link points to, if
+// --follow-symlinks is given, reporting it under path. Dangling links,
+// links leading outside the scanned directory (unless
+// --allow-external-links is given) and links back into directories already
+// walked are skipped and counted.
+func (s *scanner) followLink(ctx, work context.Context, w *treeWalk, path, link string) error {
+	if w.visited == nil {
+		s.skipLink(path, "symbolic link not followed")
+		return nil
+	}
+
+	target, err := filepath.EvalSymlinks(link)
+	if err != nil {
+		s.skipLink(path, "dangling symbolic link")
+		return nil
+	}
+	if !s.config.AllowExternalLinks && !withinDir(w.realRoot, target) {
+		s.skipLink(path, "symbolic link leads outside "+w.root)
+		return nil
+	}
+	info, err := os.Stat(target)
+	if err != nil {
+		s.skipLink(path, "dangling symbolic link")
+		return nil
+	}
+
+	rel, _ := filepath.Rel(w.root, path)
+	switch {
+	case info.IsDir():
+		if s.beyondDepth(path, rel) {
+			return nil
+		}
+		if s.filter.skipDir(rel) {
+			s.stats.filteredDir.Add(1)
+			s.note(path, "directory excluded by filter")
+			return nil
+		}
+		if s.skipDirectory(path, target) {
+			return nil
+		}
+		if !w.visited.add(target, info) {
+			s.skipLink(path, "symbolic link loops back to a directory already scanned")
+			return nil
+		}
+		return s.walkTree(ctx, work, w, target, path)
+
+	case info.Mode().IsRegular():
+		if s.filter.skipFile(rel) {
+			s.stats.filteredFile.Add(1)
+			s.note(path, "excluded by filter")
+			return nil
+		}
+		if !s.wantedExt(path) {
+			return nil
+		}
+		if !s.tooLarge(path, info) {
+			s.enqueue(ctx, work, path, info)
+		}
+
+	default:
+		s.skipSpecial(path)
+	}
+	return ctx.Err()
+}
+
+// beyondDepth reports whether the directory at path, rel below the scanned
+// directory, is deeper than --max-depth allows entering, counting and
+// noting it if so. The files of the scanned directory are at depth 0, so a
+// directory directly in it is at depth 1.
+func (s *scanner) beyondDepth(path, rel string) bool {
+	if s.config.MaxDepth < 0 || strings.Count(filepath.ToSlash(rel), "/")+1 <= s.config.MaxDepth {
+		return false
+	}
+	s.stats.skippedDepth.Add(1)
+	s.note(path, "directory beyond --max-depth")
+	return true
+}
+
+// skipDirectory reports whether the directory at path, whose absolute path
+// is abs, is one that walks do not enter: the quarantine directory, where
+// matched files would be found and moved again, or a virtual mount.
+func (s *scanner) skipDirectory(path, abs string) bool {
+	if s.quarantine.contains(abs) {
+		s.note(path, "quarantine directory")
+		return true
+	}
+	return s.skipMount(path, abs)
+}
+
+// skipMount reports whether the directory at path, whose absolute path is
+// abs, is the mount point of a virtual filesystem, counting and noting it
+// if so.
+func (s *scanner) skipMount(path, abs string) bool {
+	fsType, ok := s.mounts[abs]
+	if !ok {
+		return false
+	}
+	s.stats.skippedMounts.Add(1)
+	s.note(path, fsType+" filesystem (use --all-filesystems to scan it)")
+	return true
+}
+
+// skipSpecial counts a FIFO, socket or device node, which is never read.
+func (s *scanner) skipSpecial(path string) {
+	s.stats.special.Add(1)
+	s.note(path, "not a regular file")
+}
+
+// skipLink counts a symbolic link that was not scanned, noting why.
+func (s *scanner) skipLink(path, reason string) {
+	s.stats.skippedLinks.Add(1)
+	s.note(path, reason)
+}
+
+// withinDir reports whether path is dir or below it. Both must be clean.
+func withinDir(dir, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// fileID identifies a file independently of the paths leading to it.
+type fileID struct {
+	dev uint64
+	ino uint64
+}
+
+// visitedSet records the directories entered while following links, by
+// device and inode where the platform has them and by resolved path
+// otherwise.
+type visitedSet struct {
+	ids   map[fileID]bool
+	paths map[string]bool
+}
+
+func newVisitedSet() *visitedSet {
+	return &visitedSet{ids: make(map[fileID]bool), paths: make(map[string]bool)}
+}
+
+// add records the directory at path, described by info, and reports whether
+// it was not already recorded.
+func (v *visitedSet) add(path string, info fs.FileInfo) bool {
+	if id, ok := statFileID(info); ok {
+		if v.ids[id] {
+			return false
+		}
+		v.ids[id] = true
+		return true
+	}
+
+	if real, err := filepath.EvalSymlinks(path); err == nil {
+		path = real
+	}
+	if v.paths[path] {
+		return false
+	}
+	v.paths[path] = true
+	return true
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// symlinkTree writes a tree to scan with a link loop, a dangling link, a
+// link to a file and a link leading outside it, and returns its root and
+// the directory outside it.
+func symlinkTree(t *testing.T) (root, outside string) {
+	t.Helper()
+	root, outside = t.TempDir(), t.TempDir()
+	writeFile(t, root, "a/known.exe", testSample)
+	writeFile(t, outside, "other.bin", sampleData(7, 4096))
+	for link, target := range map[string]string{
+		"a/loop":   "..",
+		"a/self":   ".",
+		"dangling": "missing",
+		"link.exe": filepath.Join("a", "known.exe"),
+		"external": outside,
+	} {
+		if err := os.Symlink(target, filepath.Join(root, link)); err != nil {
+			t.Skipf("symbolic links unavailable: %v", err)
+		}
+	}
+	return root, outside
+}
+
+func TestScanSymlinks(t *testing.T) {
+	root, outside := symlinkTree(t)
+
+	tests := []struct {
+		name  string
+		args  []string
+		want  []string
+		links int64
+	}{
+		{"not followed", nil, []string{"a/known.exe"}, 5},
+		{"followed", []string{"--follow-symlinks"}, []string{"a/known.exe", "link.exe"}, 4},
+		{"followed outside", []string{"--follow-symlinks", "--allow-external-links"}, []string{"a/known.exe", "link.exe", "external/other.bin"}, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// A loop that was followed would never finish.
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+			args := append([]string{"-s", "--jsonl", "--db", writeTestDatabase(t)}, tt.args...)
+			out, _, _, err := runCLIContext(t, ctx, append(args, root)...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			results, summary := parseJSONL(t, out)
+			byPath := resultsByPath(results)
+			for _, name := range tt.want {
+				if r, ok := byPath[filepath.Join(root, filepath.FromSlash(name))]; !ok || r.Error != "" {
+					t.Errorf("%s: result %+v, want it hashed", name, r)
+				}
+			}
+			if len(results) != len(tt.want) || summary.Failed != 0 || summary.Links != tt.links {
+				t.Errorf("%d results, %d failed, %d links skipped; want %d, none failed, %d skipped", len(results), summary.Failed, summary.Links, len(tt.want), tt.links)
+			}
+			if _, ok := byPath[filepath.Join(outside, "other.bin")]; ok {
+				t.Errorf("the link target outside was reported under its own path")
+			}
+		})
+	}
+}
+
+func TestAllowExternalLinksRequiresFollow(t *testing.T) {
+	if code, _ := runMain(t, "-s", "--allow-external-links", t.TempDir()); code != exitError {
+		t.Errorf("exit code %d, want %d", code, exitError)
+	}
+}
+
+// depthTree writes a tree to scan three levels deep, with dotfiles and
+// dot-directories at several depths, and returns its root.
+func depthTree(t *testing.T) string {
+	t.Helper()
+	root := t.TempDir()
+	for i, name := range []string{
+		"top.exe", ".hidden.exe",
+		"a/one.exe", "a/b/two.exe", "a/b/c/three.exe", "a/.cache/x.exe",
+		".git/config.bin", ".git/sub/y.exe",
+		"node_modules/z.exe",
+	} {
+		writeFile(t, root, name, sampleData(uint64(i+40), 2048))
+	}
+	return root
+}
+
+// scannedPaths returns the paths of results relative to root, sorted, with
+// / as the separator.
+func scannedPaths(results []scanResult, root string) []string {
+	var paths []string
+	for _, r := range results {
+		rel, _ := filepath.Rel(root, r.Path)
+		paths = append(paths, filepath.ToSlash(rel))
+	}
+	slices.Sort(paths)
+	return paths
+}
+
+func TestScanDepthAndHidden(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("hidden files are marked by an attribute on Windows, not a dot")
+	}
+	root := depthTree(t)
+	all := []string{".git/config.bin", ".git/sub/y.exe", ".hidden.exe", "a/.cache/x.exe", "a/b/c/three.exe", "a/b/two.exe", "a/one.exe", "node_modules/z.exe", "top.exe"}
+
+	tests := []struct {
+		name                   string
+		args                   []string
+		want                   []string
+		deep, hidden           int64
+		filtered, filteredDirs int64
+	}{
+		{"no limits", nil, all, 0, 0, 0, 0},
+		{"max depth 0", []string{"--max-depth", "0"},
+			[]string{".hidden.exe", "top.exe"}, 3, 0, 0, 0},
+		{"max depth 1", []string{"--max-depth", "1"},
+			[]string{".git/config.bin", ".hidden.exe", "a/one.exe", "node_modules/z.exe", "top.exe"}, 3, 0, 0, 0},
+		{"max depth 2", []string{"--max-depth", "2"},
+			[]string{".git/config.bin", ".git/sub/y.exe", ".hidden.exe", "a/.cache/x.exe", "a/b/two.exe", "a/one.exe", "node_modules/z.exe", "top.exe"}, 1, 0, 0, 0},
+		{"max depth beyond the tree", []string{"--max-depth", "3"}, all, 0, 0, 0, 0},
+		{"skip hidden", []string{"--skip-hidden"},
+			[]string{"a/b/c/three.exe", "a/b/two.exe", "a/one.exe", "node_modules/z.exe", "top.exe"}, 0, 3, 0, 0},
+		// The depth is checked first, so a/.cache counts as too deep and
+		// .git, which is not, as hidden.
+		{"max depth and skip hidden", []string{"--max-depth", "1", "--skip-hidden"},
+			[]string{"a/one.exe", "node_modules/z.exe", "top.exe"}, 2, 2, 0, 0},
+		// A directory pruned by depth never reaches the exclude patterns.
+		{"max depth before exclude", []string{"--max-depth", "0", "--exclude", "a"},
+			[]string{".hidden.exe", "top.exe"}, 3, 0, 0, 0},
+		{"skip hidden before exclude and include", []string{"--skip-hidden", "--exclude", "node_modules", "--include", "**/*.exe"},
+			[]string{"a/b/c/three.exe", "a/b/two.exe", "a/one.exe", "top.exe"}, 0, 3, 0, 1},
+		// Includes cannot bring back what was pruned.
+		{"include cannot undo skip hidden", []string{"--skip-hidden", "--include", "**/x.exe", "--include", ".git/**"},
+			nil, 0, 3, 5, 0},
+		{"include cannot undo max depth", []string{"--max-depth", "1", "--include", "**/three.exe"},
+			nil, 3, 0, 5, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args := append([]string{"-s", "--jsonl", "--db", writeTestDatabase(t)}, tt.args...)
+			out, _, _, err := runCLI(t, append(args, root)...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			results, summary := parseJSONL(t, out)
+			if got := scannedPaths(results, root); !slices.Equal(got, tt.want) {
+				t.Errorf("scanned %q, want %q", got, tt.want)
+			}
+			if summary.Deep != tt.deep || summary.Hidden != tt.hidden || summary.Filtered != tt.filtered || summary.FilteredDir != tt.filteredDirs {
+				t.Errorf("skipped %d too deep, %d hidden, filtered %d files and %d directories; want %d, %d, %d and %d",
+					summary.Deep, summary.Hidden, summary.Filtered, summary.FilteredDir, tt.deep, tt.hidden, tt.filtered, tt.filteredDirs)
+			}
+		})
+	}
+}
+
+func TestScanDepthAndHiddenSummary(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("hidden files are marked by an attribute on Windows, not a dot")
+	}
+	_, stderr, _, err := runCLI(t, "-s", "--max-depth", "1", "--skip-hidden", "--db", writeTestDatabase(t), depthTree(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(stderr, "2 directories beyond --max-depth") || !strings.Contains(stderr, "2 hidden") {
+		t.Errorf("summary does not count the skipped entries:\n%s", stderr)
+	}
+}
+
+func TestScanHiddenNamedDirectly(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("hidden files are marked by an attribute on Windows, not a dot")
+	}
+	root := depthTree(t)
+
+	// Paths named on the command line are scanned even if hidden; only
+	// what is below them is pruned.
+	out, _, _, err := runCLI(t, "-s", "--jsonl", "--skip-hidden", "--max-depth", "0", "--db", writeTestDatabase(t),
+		filepath.Join(root, ".git"), filepath.Join(root, ".hidden.exe"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	results, summary := parseJSONL(t, out)
+	if got, want := scannedPaths(results, root), []string{".git/config.bin", ".hidden.exe"}; !slices.Equal(got, want) {
+		t.Errorf("scanned %q, want %q", got, want)
+	}
+	if summary.Deep != 1 || summary.Hidden != 0 {
+		t.Errorf("skipped %d too deep and %d hidden, want 1 and 0", summary.Deep, summary.Hidden)
+	}
+}
+
+func TestScanMaxDepthFollowedLink(t *testing.T) {
+	root := depthTree(t)
+	if err := os.Symlink(filepath.Join("a", "b", "c"), filepath.Join(root, "shortcut")); err != nil {
+		t.Skipf("symbolic links unavailable: %v", err)
+	}
+
+	// A followed link to a/b/c is one level down, as reached, not three.
+	for _, tt := range []struct {
+		depth string
+		want  bool
+		deep  int64
+	}{
+		{"0", false, 4},
+		{"1", true, 3},
+	} {
+		out, _, _, err := runCLI(t, "-s", "--jsonl", "--follow-symlinks", "--max-depth", tt.depth, "--db", writeTestDatabase(t), root)
+		if err != nil {
+			t.Fatal(err)
+		}
+		results, summary := parseJSONL(t, out)
+		_, got := resultsByPath(results)[filepath.Join(root, "shortcut", "three.exe")]
+		if got != tt.want || summary.Deep != tt.deep {
+			t.Errorf("--max-depth %s: shortcut/three.exe scanned %v, %d too deep; want %v, %d", tt.depth, got, summary.Deep, tt.want, tt.deep)
+		}
+	}
+}
+
+func TestDepthAndHiddenFlags(t *testing.T) {
+	for _, tt := range []struct {
+		args []string
+		want string
+	}{
+		{[]string{"-s", "--max-depth", "-2", t.TempDir()}, "--max-depth must be -1 (no limit) or more"},
+		{[]string{"-c", "--max-depth", "1", testRecords(t)[0].TLSHHash}, "--max-depth and --skip-hidden only apply to scan mode"},
+		{[]string{"-c", "--skip-hidden", testRecords(t)[0].TLSHHash}, "--max-depth and --skip-hidden only apply to scan mode"},
+	} {
+		code, stderr := runMain(t, tt.args...)
+		if code != exitError || !strings.Contains(stderr, tt.want) {
+			t.Errorf("%v: exit %d, stderr %q; want %q", tt.args, code, stderr, tt.want)
+		}
+	}
+}
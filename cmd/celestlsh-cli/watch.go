@@ -0,0 +1,288 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+const (
+	// watchSettle is how long a file must go without events before its
+	// size is sampled, and how long that size must then stay unchanged
+	// before the file is hashed.
+	watchSettle = time.Second
+
+	// watchMaxAttempts bounds how often a file that is still changing or
+	// has disappeared is re-examined before it is reported and dropped.
+	watchMaxAttempts = 10
+)
+
+// pendingFile tracks a path that has seen filesystem events and is waiting
+// to settle before it is hashed.
+type pendingFile struct {
+	lastEvent time.Time
+	size      int64
+	modTime   time.Time
+	sampled   bool
+	attempts  int
+}
+
+type watcher struct {
+	config  Config
+	fsw     *fsnotify.Watcher
+	scanner *scanner
+	dbPath  string
+	dirs    map[string]bool
+	pending map[string]*pendingFile
+}
+
+func executeWatch(ctx context.Context, config Config) error {
+	db, err := loadDatabase(ctx, config)
+	if err != nil {
+		return err
+	}
+
+	dbPath, err := filepath.Abs(config.DbPath)
+	if err != nil {
+		return fmt.Errorf("failed to resolve database path: %w", err)
+	}
+
+	forwarder, err := newSyslogForwarder(config)
+	if err != nil {
+		return err
+	}
+	defer forwarder.close()
+
+	notifier := newWebhookNotifier(config)
+	if notifier != nil {
+		notifier.start()
+		defer notifier.close()
+	}
+
+	allow, err := loadAllowlist(config.Allowlist)
+	if err != nil {
+		return err
+	}
+
+	q, err := newQuarantine(config, []string{config.WatchDir})
+	if err != nil {
+		return err
+	}
+	defer q.close()
+
+	store, err := newResultsStore(ctx, config, []string{config.WatchDir}, false)
+	if err != nil {
+		return err
+	}
+
+	fsw, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("failed to create file watcher: %w", err)
+	}
+	defer fsw.Close()
+
+	w := &watcher{
+		config:  config,
+		fsw:     fsw,
+		scanner: &scanner{config: config, db: db, hasher: newHasher(config), allowlist: allow, quarantine: q, results: store, syslog: forwarder, webhook: notifier},
+		dbPath:  dbPath,
+		dirs:    make(map[string]bool),
+		pending: make(map[string]*pendingFile),
+	}
+
+	if err := w.addTree(config.WatchDir, false); err != nil {
+		return err
+	}
+
+	// Watch the database's directory rather than the file itself so that
+	// downloads replacing the file are noticed as well as in-place writes.
+	if err := fsw.Add(filepath.Dir(dbPath)); err != nil {
+		return fmt.Errorf("failed to watch database: %w", err)
+	}
+
+	if !config.Quiet && !config.OutputJSONL {
+		fmt.Fprintf(os.Stderr, "Watching %s with %d database records\n", config.WatchDir, db.Len())
+	}
+
+	err = w.run(ctx)
+	if serr := store.finish(nil); serr != nil && err == nil {
+		err = serr
+	}
+	return err
+}
+
+// addTree watches dir and every directory below it. When enqueue is set,
+// files already present are queued for hashing; this covers files created
+// in a new directory before its watch was registered.
+func (w *watcher) addTree(dir string, enqueue bool) error {
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if path == dir {
+				return fmt.Errorf("failed to watch %s: %v", dir, err)
+			}
+			return nil
+		}
+
+		if d.IsDir() {
+			if abs, err := filepath.Abs(path); err == nil && w.scanner.quarantine.contains(abs) {
+				return filepath.SkipDir
+			}
+			if err := w.fsw.Add(path); err != nil {
+				return fmt.Errorf("failed to watch %s: %v", path, err)
+			}
+			w.dirs[path] = true
+			return nil
+		}
+
+		if enqueue && d.Type().IsRegular() {
+			w.touch(path)
+		}
+		return nil
+	})
+}
+
+func (w *watcher) run(ctx context.Context) error {
+	ticker := time.NewTicker(watchSettle / 4)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+
+		case event, ok := <-w.fsw.Events:
+			if !ok {
+				return nil
+			}
+			w.handleEvent(event)
+
+		case err, ok := <-w.fsw.Errors:
+			if !ok {
+				return nil
+			}
+			fmt.Fprintf(os.Stderr, "Warning: file watcher: %v\n", err)
+
+		case now := <-ticker.C:
+			w.processPending(ctx, now)
+		}
+	}
+}
+
+func (w *watcher) handleEvent(event fsnotify.Event) {
+	if !event.Has(fsnotify.Create) && !event.Has(fsnotify.Write) {
+		return
+	}
+
+	if abs, err := filepath.Abs(event.Name); err == nil && abs == w.dbPath {
+		w.touch(w.dbPath)
+		return
+	}
+
+	// The database's directory may be watched without being part of the
+	// watched tree.
+	if !w.dirs[filepath.Dir(event.Name)] {
+		return
+	}
+
+	info, err := os.Lstat(event.Name)
+	if err != nil {
+		// Gone already; let the retry logic decide whether it comes back.
+		w.touch(event.Name)
+		return
+	}
+
+	if info.IsDir() {
+		if event.Has(fsnotify.Create) {
+			if err := w.addTree(event.Name, true); err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+			}
+		}
+		return
+	}
+
+	if info.Mode().IsRegular() {
+		w.touch(event.Name)
+	}
+}
+
+func (w *watcher) touch(path string) {
+	p, ok := w.pending[path]
+	if !ok {
+		p = &pendingFile{}
+		w.pending[path] = p
+	}
+	p.lastEvent = time.Now()
+	p.sampled = false
+}
+
+// processPending hashes every pending file that has stopped changing and
+// retries or drops the ones that are still being written or have vanished.
+func (w *watcher) processPending(ctx context.Context, now time.Time) {
+	for path, p := range w.pending {
+		if now.Sub(p.lastEvent) < watchSettle {
+			continue
+		}
+
+		info, err := os.Stat(path)
+		if err != nil {
+			if !w.retry(path, p, now) {
+				fmt.Fprintf(os.Stderr, "Warning: %s disappeared before it could be hashed\n", path)
+			}
+			continue
+		}
+
+		if !p.sampled {
+			p.size, p.modTime, p.sampled = info.Size(), info.ModTime(), true
+			p.lastEvent = now
+			continue
+		}
+
+		if info.Size() != p.size || !info.ModTime().Equal(p.modTime) {
+			p.size, p.modTime = info.Size(), info.ModTime()
+			if !w.retry(path, p, now) {
+				w.reportBusy(path)
+			}
+			continue
+		}
+
+		delete(w.pending, path)
+
+		if path == w.dbPath {
+			w.reloadDatabase(ctx)
+			continue
+		}
+
+		w.scanner.scanFile(ctx, path)
+	}
+}
+
+// retry schedules another look at a pending file, returning false once the
+// file has used up its attempts.
+func (w *watcher) retry(path string, p *pendingFile, now time.Time) bool {
+	p.attempts++
+	if p.attempts >= watchMaxAttempts {
+		delete(w.pending, path)
+		return false
+	}
+	p.lastEvent = now
+	return true
+}
+
+func (w *watcher) reportBusy(path string) {
+	if path == w.dbPath {
+		fmt.Fprintf(os.Stderr, "Warning: database %s is still being written; not reloading\n", path)
+		return
+	}
+	w.scanner.emit(scanResult{Path: path, Error: "file is still being written"})
+}
+
+func (w *watcher) reloadDatabase(ctx context.Context) {
+	if db := reloadDatabase(ctx, w.config, w.scanner.db); db != nil {
+		w.scanner.db = db
+	}
+}
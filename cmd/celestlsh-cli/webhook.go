@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Magonia-Research/CelesTLSH-CLI/pkg/celestlsh"
+)
+
+// webhookAttempts and webhookBackoff bound the retries of one notification.
+const (
+	webhookAttempts = 3
+	webhookBackoff  = 500 * time.Millisecond
+)
+
+// webhookQueue is how many notifications may wait to be sent before new
+// ones are dropped, so that a slow endpoint cannot hold up a scan.
+const webhookQueue = 256
+
+// headerList collects repeated --header and --webhook-header flags.
+type headerList []string
+
+func (h *headerList) String() string { return strings.Join(*h, ", ") }
+
+func (h *headerList) Set(value string) error {
+	name, _, ok := strings.Cut(value, ":")
+	if !ok || strings.TrimSpace(name) == "" {
+		return fmt.Errorf("expected 'Name: value', got %q", value)
+	}
+	*h = append(*h, value)
+	return nil
+}
+
+// header returns the collected headers, their names canonicalized and
+// their values trimmed.
+func (h headerList) header() http.Header {
+	header := make(http.Header)
+	for _, v := range h {
+		name, value, _ := strings.Cut(v, ":")
+		header.Add(strings.TrimSpace(name), strings.TrimSpace(value))
+	}
+	return header
+}
+
+// webhookPayload is the JSON body posted for each match.
+type webhookPayload struct {
+	Path      string               `json:"path,omitempty"`
+	TLSH      string               `json:"tlsh"`
+	SHA256    string               `json:"sha256,omitempty"`
+	Match     celestlsh.HashRecord `json:"match"`
+	Distance  int                  `json:"distance"`
+	MatchType string               `json:"match_type,omitempty"`
+	Hostname  string               `json:"hostname"`
+	Timestamp string               `json:"timestamp"`
+	Test      bool                 `json:"test,omitempty"`
+}
+
+// webhookNotifier posts matches to --webhook in the background. Failed
+// notifications are retried a few times and then logged to stderr; they
+// never fail the scan.
+type webhookNotifier struct {
+	url      string
+	headers  http.Header
+	client   *http.Client
+	hostname string
+
+	queue chan webhookPayload
+	done  sync.WaitGroup
+}
+
+func newWebhookNotifier(config Config) *webhookNotifier {
+	if config.Webhook == "" {
+		return nil
+	}
+
+	hostname, _ := os.Hostname()
+
+	return &webhookNotifier{
+		url:      config.Webhook,
+		headers:  config.WebhookHeaders.header(),
+		client:   &http.Client{Timeout: config.WebhookTimeout},
+		hostname: hostname,
+	}
+}
+
+// start begins sending queued notifications.
+func (n *webhookNotifier) start() {
+	n.queue = make(chan webhookPayload, webhookQueue)
+	n.done.Add(1)
+	go func() {
+		defer n.done.Done()
+		for payload := range n.queue {
+			if err := n.post(context.Background(), payload); err != nil {
+				clearProgress()
+				fmt.Fprintf(os.Stderr, "Warning: webhook: %s: %v\n", payload.Path, err)
+			}
+		}
+	}()
+}
+
+// notify queues a notification for a match.
+func (n *webhookNotifier) notify(result scanResult) {
+	payload := n.payload(result)
+	select {
+	case n.queue <- payload:
+	default:
+		clearProgress()
+		fmt.Fprintf(os.Stderr, "Warning: webhook: %s: dropped, too many notifications pending\n", result.Path)
+	}
+}
+
+// close sends the notifications still queued. It is safe on a nil notifier.
+func (n *webhookNotifier) close() {
+	if n == nil {
+		return
+	}
+	close(n.queue)
+	n.done.Wait()
+}
+
+func (n *webhookNotifier) payload(result scanResult) webhookPayload {
+	return webhookPayload{
+		Path:      result.Path,
+		TLSH:      result.TLSH,
+		SHA256:    result.SHA256,
+		Match:     *result.Match,
+		Distance:  result.Match.Distance,
+		MatchType: result.MatchType,
+		Hostname:  n.hostname,
+		Timestamp: time.Now().UTC().Format(time.RFC3339),
+	}
+}
+
+// post sends one notification, retrying network errors and server errors
+// with exponential backoff.
+func (n *webhookNotifier) post(ctx context.Context, payload webhookPayload) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 {
+			time.Sleep(webhookBackoff << (attempt - 1))
+		}
+
+		var retry bool
+		retry, err = n.postOnce(ctx, body)
+		if err == nil || !retry || attempt+1 == webhookAttempts {
+			return err
+		}
+	}
+}
+
+func (n *webhookNotifier) postOnce(ctx context.Context, body []byte) (retry bool, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header = n.headers.Clone()
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+			return false, fmt.Errorf("authentication failed: %s returned status %d; check --webhook-header", n.url, resp.StatusCode)
+		}
+		retry := resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests
+		return retry, fmt.Errorf("%s returned status %d", n.url, resp.StatusCode)
+	}
+	return false, nil
+}
+
+// executeWebhookTest posts a sample notification, flagged as a test, to
+// check that the endpoint is reachable and accepts it.
+func executeWebhookTest(ctx context.Context, config Config) error {
+	n := newWebhookNotifier(config)
+
+	payload := n.payload(scanResult{
+		Path:    "/tmp/celestlsh-webhook-test.exe",
+		Digests: celestlsh.Digests{TLSH: "8ed02202fc30802303a002b03b33300fc30a82f83008c2fa000a0080b8ba0e02cca0c3"},
+		Match: &celestlsh.HashRecord{
+			RepoName: "celestlsh-webhook-test",
+			FileName: "test.exe",
+			Version:  "0.0.0",
+			Distance: 0,
+		},
+	})
+	payload.Test = true
+
+	if err := n.post(ctx, payload); err != nil {
+		return fmt.Errorf("webhook test failed: %w", err)
+	}
+	if !config.Quiet {
+		fmt.Printf("Test notification delivered to %s\n", config.Webhook)
+	}
+	return nil
+}
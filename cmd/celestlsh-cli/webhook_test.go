@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestHeaderList(t *testing.T) {
+	var h headerList
+	for _, v := range []string{"Authorization: Bearer abc", "x-team:  red ", "X-Team: blue"} {
+		if err := h.Set(v); err != nil {
+			t.Fatalf("Set(%q): %v", v, err)
+		}
+	}
+	header := h.header()
+	if header.Get("Authorization") != "Bearer abc" || strings.Join(header.Values("X-Team"), ",") != "red,blue" {
+		t.Errorf("header = %v", header)
+	}
+	for _, v := range []string{"no colon", ": value", "  : value"} {
+		if err := h.Set(v); err == nil {
+			t.Errorf("Set(%q) succeeded, want an error", v)
+		}
+	}
+}
+
+// webhookServer records the notifications posted to it, answering each
+// request with the next of statuses and then with 200.
+type webhookServer struct {
+	*httptest.Server
+	mu       sync.Mutex
+	statuses []int
+	requests int
+	payloads []webhookPayload
+	headers  []http.Header
+}
+
+func newWebhookServer(t *testing.T, statuses ...int) *webhookServer {
+	s := &webhookServer{statuses: statuses}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.requests++
+		if len(s.statuses) > 0 {
+			status := s.statuses[0]
+			s.statuses = s.statuses[1:]
+			if status != http.StatusOK {
+				w.WriteHeader(status)
+				return
+			}
+		}
+		var p webhookPayload
+		body, _ := io.ReadAll(r.Body)
+		if err := json.Unmarshal(body, &p); err != nil {
+			t.Errorf("payload %s: %v", body, err)
+		}
+		s.payloads = append(s.payloads, p)
+		s.headers = append(s.headers, r.Header)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func TestScanWebhook(t *testing.T) {
+	srv := newWebhookServer(t)
+	dir := t.TempDir()
+	known := writeFile(t, dir, "known.exe", testSample)
+	writeFile(t, dir, "other.bin", sampleData(5, 8192))
+
+	_, _, st, err := runCLI(t, "-s", "--max-distance", "30", "--webhook", srv.URL, "--webhook-header", "Authorization: Bearer abc", "--db", writeTestDatabase(t), dir)
+	if err != nil || st != statusMatch {
+		t.Fatalf("scan = %v, %v; want a match", st, err)
+	}
+	if len(srv.payloads) != 1 {
+		t.Fatalf("%d notifications, want one for known.exe", len(srv.payloads))
+	}
+	p := srv.payloads[0]
+	if p.Path != known || p.TLSH != testHash(t, testSample) || p.Match.FileName != "known.exe" || p.Distance != 0 || p.Hostname == "" || p.Timestamp == "" || p.Test {
+		t.Errorf("payload = %+v", p)
+	}
+	if h := srv.headers[0]; h.Get("Authorization") != "Bearer abc" || h.Get("Content-Type") != "application/json" {
+		t.Errorf("headers = %v", h)
+	}
+}
+
+func TestWebhookRetries(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "known.exe", testSample)
+
+	tests := []struct {
+		name         string
+		statuses     []int
+		wantRequests int
+		wantWarning  bool
+	}{
+		{"server error, then success", []int{http.StatusServiceUnavailable}, 2, false},
+		{"refused", []int{http.StatusUnauthorized}, 1, true},
+		{"client error", []int{http.StatusBadRequest}, 1, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newWebhookServer(t, tt.statuses...)
+			_, stderr, st, err := runCLI(t, "-s", "--max-distance", "30", "--webhook", srv.URL, "--db", writeTestDatabase(t), dir)
+			// A failed notification never fails the scan.
+			if err != nil || st != statusMatch {
+				t.Errorf("scan = %v, %v; want a match", st, err)
+			}
+			if srv.requests != tt.wantRequests {
+				t.Errorf("%d requests, want %d", srv.requests, tt.wantRequests)
+			}
+			if got := strings.Contains(stderr, "Warning: webhook:"); got != tt.wantWarning {
+				t.Errorf("stderr %q, want a warning %v", stderr, tt.wantWarning)
+			}
+		})
+	}
+}
+
+func TestWebhookTest(t *testing.T) {
+	srv := newWebhookServer(t)
+	out, _, _, err := runCLI(t, "--webhook-test", "--webhook", srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(srv.payloads) != 1 || !srv.payloads[0].Test {
+		t.Errorf("payloads = %+v, want one test notification", srv.payloads)
+	}
+	if !strings.Contains(out, "Test notification delivered to "+srv.URL) {
+		t.Errorf("output %q does not confirm delivery", out)
+	}
+
+	failing := newWebhookServer(t, http.StatusForbidden)
+	if _, _, _, err := runCLI(t, "--webhook-test", "--webhook", failing.URL); err == nil || !strings.Contains(err.Error(), "authentication failed") {
+		t.Errorf("error = %v, want an authentication failure", err)
+	}
+}
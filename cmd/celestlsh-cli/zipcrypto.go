@@ -0,0 +1,61 @@
+package main
+
+import (
+	"errors"
+	"hash/crc32"
+	"io"
+)
+
+// zipCryptoHeaderLen is the size of the encryption header that precedes the
+// data of a member protected with traditional PKWARE (ZipCrypto) encryption.
+const zipCryptoHeaderLen = 12
+
+var errZipWrongPassword = errors.New("wrong zip password")
+
+// zipCryptoReader decrypts a ZipCrypto-encrypted stream, as used by the
+// conventional "infected" password on malware sample archives.
+type zipCryptoReader struct {
+	r    io.Reader
+	keys [3]uint32
+}
+
+// newZipCryptoReader consumes the 12-byte encryption header from r and
+// checks it against checkByte, the high byte of the member's CRC-32 (or of
+// its modification time when a data descriptor is used). A mismatch means
+// the password is wrong.
+func newZipCryptoReader(r io.Reader, password string, checkByte byte) (io.Reader, error) {
+	z := &zipCryptoReader{r: r, keys: [3]uint32{0x12345678, 0x23456789, 0x34567890}}
+	for i := 0; i < len(password); i++ {
+		z.update(password[i])
+	}
+
+	header := make([]byte, zipCryptoHeaderLen)
+	if _, err := io.ReadFull(z, header); err != nil {
+		return nil, err
+	}
+	if header[zipCryptoHeaderLen-1] != checkByte {
+		return nil, errZipWrongPassword
+	}
+
+	return z, nil
+}
+
+func (z *zipCryptoReader) Read(p []byte) (int, error) {
+	n, err := z.r.Read(p)
+	for i := 0; i < n; i++ {
+		temp := uint16(z.keys[2] | 2)
+		p[i] ^= byte((uint32(temp) * uint32(temp^1)) >> 8)
+		z.update(p[i])
+	}
+	return n, err
+}
+
+func (z *zipCryptoReader) update(b byte) {
+	z.keys[0] = crc32Update(z.keys[0], b)
+	z.keys[1] = (z.keys[1]+(z.keys[0]&0xff))*134775813 + 1
+	z.keys[2] = crc32Update(z.keys[2], byte(z.keys[1]>>24))
+}
+
+func crc32Update(crc uint32, b byte) uint32 {
+	return crc32.IEEETable[byte(crc)^b] ^ (crc >> 8)
+}
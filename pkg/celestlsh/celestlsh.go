@@ -0,0 +1,100 @@
+// Package celestlsh calculates and compares TLSH hashes and checks them
+// against the CelesTLSH database of hashes from known attack tools.
+package celestlsh
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/glaslos/tlsh"
+)
+
+// HashRecord is a single row of the CelesTLSH database. Distance is only
+// meaningful on records returned by a check and holds the TLSH distance
+// between the queried hash and TLSHHash.
+type HashRecord struct {
+	RepoName   string `json:"repo_name"`
+	FileName   string `json:"file_name"`
+	Version    string `json:"version"`
+	TLSHHash   string `json:"tlsh"`
+	SHA256Hash string `json:"sha256"`
+	Imphash    string `json:"imphash"`
+	DateAdded  string `json:"date_added"`
+	Intel      string `json:"intel"`
+	Distance   int    `json:"distance"`
+}
+
+// CSVRow returns r as a row of the CelesTLSH CSV schema, in the order of
+// Header. Distance is not part of the schema.
+func (r *HashRecord) CSVRow() []string {
+	return []string{r.RepoName, r.FileName, r.Version, r.TLSHHash, r.SHA256Hash, r.Imphash, r.DateAdded, r.Intel}
+}
+
+// Distance returns the TLSH distance between two hashes. Lower values
+// indicate more similar inputs, 0 meaning identical digests.
+func Distance(hash1, hash2 string) (int, error) {
+	t1, err := parseHash("first", hash1)
+	if err != nil {
+		return -1, err
+	}
+
+	t2, err := parseHash("second", hash2)
+	if err != nil {
+		return -1, err
+	}
+
+	return t1.Diff(t2), nil
+}
+
+// ValidateHash reports whether hash is a TLSH string that Distance and the
+// database checks accept, returning a *HashError if it is not.
+func ValidateHash(hash string) error {
+	_, err := parseHash("", hash)
+	return err
+}
+
+// T1Prefix is the version prefix newer TLSH tools, and services such as
+// VirusTotal, put in front of a hash. Every function taking a TLSH string
+// accepts hashes with or without it.
+const T1Prefix = "T1"
+
+// NormalizeHash returns hash without its T1 version prefix, in the form
+// Hasher produces. The prefix cannot be mistaken for hex digits, so it is
+// stripped in either case.
+func NormalizeHash(hash string) string {
+	if len(hash) >= len(T1Prefix) && strings.EqualFold(hash[:len(T1Prefix)], T1Prefix) {
+		return hash[len(T1Prefix):]
+	}
+	return hash
+}
+
+// WithT1Prefix returns hash with the T1 version prefix, whether or not it
+// already had one.
+func WithT1Prefix(hash string) string {
+	return T1Prefix + NormalizeHash(hash)
+}
+
+// HashLength is the length of a TLSH hash without the T1 prefix: 70 hex
+// digits encoding a 1 byte checksum, the length and quartile bytes, and a
+// 32 byte body. The checksum covers the hashed data, so it cannot be
+// verified from the hash alone.
+const HashLength = 70
+
+// parseHash parses a TLSH string, with or without the T1 prefix, labelling
+// any failure with the role the hash plays in the caller's operation.
+func parseHash(role, hash string) (*tlsh.TLSH, error) {
+	digits := NormalizeHash(hash)
+
+	// The tlsh package does not check the length: it indexes into the
+	// header regardless, and truncates or zero-fills the body.
+	if len(digits) != HashLength {
+		return nil, &HashError{Role: role, Hash: hash, Err: fmt.Errorf("wrong length: %d characters, want %d", len(digits), HashLength)}
+	}
+
+	t, err := tlsh.ParseStringToTlsh(digits)
+	if err != nil {
+		return nil, &HashError{Role: role, Hash: hash, Err: err}
+	}
+
+	return t, nil
+}
@@ -0,0 +1,222 @@
+package celestlsh
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"strings"
+	"testing"
+)
+
+// sample returns n bytes of pseudo-random data that is the same for the
+// same seed, varied enough to hash.
+func sample(seed uint64, n int) []byte {
+	r := rand.New(rand.NewPCG(seed, seed))
+	data := make([]byte, n)
+	for i := range data {
+		data[i] = byte(r.Uint32())
+	}
+	return data
+}
+
+// variant returns a copy of data with every step-th byte changed, for a
+// hash near that of data.
+func variant(data []byte, step int) []byte {
+	out := append([]byte(nil), data...)
+	for i := 0; i < len(out); i += step {
+		out[i] ^= 0x5a
+	}
+	return out
+}
+
+func mustHash(t testing.TB, data []byte) string {
+	t.Helper()
+	var h Hasher
+	hash, err := h.HashBytes(data)
+	if err != nil {
+		t.Fatalf("HashBytes: %v", err)
+	}
+	return hash
+}
+
+func TestDistance(t *testing.T) {
+	base := sample(1, 4096)
+	hash := mustHash(t, base)
+	near := mustHash(t, variant(base, 64))
+	far := mustHash(t, sample(2, 4096))
+
+	if d, err := Distance(hash, hash); err != nil || d != 0 {
+		t.Fatalf("Distance(h, h) = %d, %v; want 0", d, err)
+	}
+
+	dNear, err := Distance(hash, near)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dFar, err := Distance(hash, far)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dNear <= 0 || dNear >= dFar {
+		t.Errorf("distance to a variant is %d, to unrelated data %d; want 0 < variant < unrelated", dNear, dFar)
+	}
+
+	tests := []struct {
+		name         string
+		hash1, hash2 string
+	}{
+		{"symmetric", far, hash},
+		{"T1 first", WithT1Prefix(hash), far},
+		{"T1 second", hash, WithT1Prefix(far)},
+		{"lowercase t1", "t1" + hash, far},
+		{"upper case digits", strings.ToUpper(hash), far},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, err := Distance(tt.hash1, tt.hash2)
+			if err != nil || d != dFar {
+				t.Errorf("Distance = %d, %v; want %d", d, err, dFar)
+			}
+		})
+	}
+}
+
+func TestDistanceInvalid(t *testing.T) {
+	hash := mustHash(t, sample(1, 4096))
+
+	tests := []struct {
+		name         string
+		hash1, hash2 string
+		role         string
+		msg          string
+	}{
+		{"empty first", "", hash, "first", "wrong length: 0 characters, want 70"},
+		{"short second", hash, hash[:68], "second", "wrong length: 68 characters, want 70"},
+		{"long", hash + "00", hash, "first", "wrong length: 72 characters, want 70"},
+		{"T1 only", "T1", hash, "first", "wrong length: 0 characters, want 70"},
+		{"not hex", hash, "zz" + hash[2:], "second", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, err := Distance(tt.hash1, tt.hash2)
+			if d != -1 {
+				t.Errorf("distance = %d, want -1", d)
+			}
+			var hashErr *HashError
+			if !errors.As(err, &hashErr) {
+				t.Fatalf("error = %v, want a *HashError", err)
+			}
+			if hashErr.Role != tt.role {
+				t.Errorf("role = %q, want %q", hashErr.Role, tt.role)
+			}
+			if !errors.Is(err, ErrInvalidHash) {
+				t.Errorf("error does not match ErrInvalidHash")
+			}
+			if want := "error parsing " + tt.role + " hash: " + tt.msg; tt.msg != "" && err.Error() != want {
+				t.Errorf("error = %q, want %q", err, want)
+			}
+		})
+	}
+}
+
+func TestNormalizeHash(t *testing.T) {
+	hash := mustHash(t, sample(1, 4096))
+
+	tests := []struct {
+		in, want string
+	}{
+		{hash, hash},
+		{"T1" + hash, hash},
+		{"t1" + hash, hash},
+		{"T1", ""},
+		{"T", "T"},
+		{"", ""},
+		// Only one prefix is stripped.
+		{"T1T1" + hash, "T1" + hash},
+	}
+	for _, tt := range tests {
+		if got := NormalizeHash(tt.in); got != tt.want {
+			t.Errorf("NormalizeHash(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+
+	for _, in := range []string{hash, "T1" + hash, "t1" + hash} {
+		if got := WithT1Prefix(in); got != "T1"+hash {
+			t.Errorf("WithT1Prefix(%q) = %q, want %q", in, got, "T1"+hash)
+		}
+	}
+}
+
+func TestValidateHash(t *testing.T) {
+	hash := mustHash(t, sample(1, 4096))
+
+	tests := []struct {
+		hash  string
+		valid bool
+	}{
+		{hash, true},
+		{"T1" + hash, true},
+		{strings.ToLower(hash), true},
+		{"", false},
+		{hash[:69], false},
+		{hash + "0", false},
+		{"g" + hash[1:], false},
+		{"T2" + hash, false},
+	}
+	for _, tt := range tests {
+		err := ValidateHash(tt.hash)
+		if (err == nil) != tt.valid {
+			t.Errorf("ValidateHash(%q) = %v, want valid %v", tt.hash, err, tt.valid)
+		}
+		if err != nil && !errors.Is(err, ErrInvalidHash) {
+			t.Errorf("ValidateHash(%q) error %v does not match ErrInvalidHash", tt.hash, err)
+		}
+	}
+}
+
+func TestHasherMinimumSize(t *testing.T) {
+	tests := []struct {
+		name  string
+		data  []byte
+		force bool
+		ok    bool
+	}{
+		{"empty", nil, false, false},
+		{"below minimum", sample(1, MinDataLength-1), false, false},
+		{"minimum", sample(1, MinDataLength), false, true},
+		{"forced", sample(1, MinForcedDataLength), true, true},
+		{"below forced minimum", sample(1, MinForcedDataLength-1), true, false},
+		{"uniform", make([]byte, 4096), false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := Hasher{Force: tt.force}
+			hash, err := h.HashBytes(tt.data)
+			if tt.ok {
+				if err != nil || ValidateHash(hash) != nil {
+					t.Fatalf("HashBytes = %q, %v; want a valid hash", hash, err)
+				}
+				return
+			}
+			if !errors.Is(err, ErrInsufficientData) {
+				t.Fatalf("HashBytes error = %v, want ErrInsufficientData", err)
+			}
+		})
+	}
+}
+
+func TestHashReaderMatchesHashBytes(t *testing.T) {
+	data := sample(3, 10000)
+	var h Hasher
+	want := mustHash(t, data)
+	got, err := h.HashReader(context.Background(), strings.NewReader(string(data)))
+	if err != nil || got != want {
+		t.Fatalf("HashReader = %q, %v; want %q", got, err, want)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := h.HashReader(ctx, strings.NewReader(string(data))); !errors.Is(err, context.Canceled) {
+		t.Fatalf("HashReader with a cancelled context = %v, want context.Canceled", err)
+	}
+}
@@ -0,0 +1,90 @@
+package celestlsh
+
+import (
+	"container/heap"
+	"sort"
+)
+
+// Collector keeps the closest of the records added to it, up to a limit,
+// so that a query matching much of the database does not hold every match
+// in memory. The records kept are those with the smallest Distance, ties
+// going to those added first. A Collector is not safe for concurrent use.
+type Collector struct {
+	limit   int
+	kept    collectorHeap
+	added   int
+	dropped int
+}
+
+// NewCollector returns a Collector keeping up to limit records; a negative
+// limit keeps them all.
+func NewCollector(limit int) *Collector {
+	return &Collector{limit: limit}
+}
+
+// Add offers a record, whose Distance must be set, to the collector.
+func (c *Collector) Add(record HashRecord) {
+	item := collected{record: record, seq: c.added}
+	c.added++
+	if c.limit < 0 || len(c.kept) < c.limit {
+		heap.Push(&c.kept, item)
+		return
+	}
+	c.dropped++
+	// The root is the furthest record kept, and the first to go when a
+	// closer one arrives.
+	if len(c.kept) > 0 && item.less(c.kept[0]) {
+		c.kept[0] = item
+		heap.Fix(&c.kept, 0)
+	}
+}
+
+// Records returns the records kept, closest first and in the order they
+// were added among records at equal distance.
+func (c *Collector) Records() []HashRecord {
+	items := make([]collected, len(c.kept))
+	copy(items, c.kept)
+	sort.Slice(items, func(i, j int) bool { return items[i].less(items[j]) })
+
+	records := make([]HashRecord, len(items))
+	for i, item := range items {
+		records[i] = item.record
+	}
+	return records
+}
+
+// Dropped returns how many of the records added were left out because of
+// the limit.
+func (c *Collector) Dropped() int {
+	return c.dropped
+}
+
+// collected is a record kept by a Collector, with the order it was added
+// in to break ties.
+type collected struct {
+	record HashRecord
+	seq    int
+}
+
+func (a collected) less(b collected) bool {
+	if a.record.Distance != b.record.Distance {
+		return a.record.Distance < b.record.Distance
+	}
+	return a.seq < b.seq
+}
+
+// collectorHeap is a max-heap of the records kept, furthest at the root.
+type collectorHeap []collected
+
+func (h collectorHeap) Len() int           { return len(h) }
+func (h collectorHeap) Less(i, j int) bool { return h[j].less(h[i]) }
+func (h collectorHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+
+func (h *collectorHeap) Push(x any) { *h = append(*h, x.(collected)) }
+
+func (h *collectorHeap) Pop() any {
+	old := *h
+	item := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return item
+}
@@ -0,0 +1,115 @@
+package celestlsh
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// collectorRecords returns n records at random distances with many ties,
+// each named after its position.
+func collectorRecords(seed uint64, n int) []HashRecord {
+	r := rand.New(rand.NewPCG(seed, seed))
+	records := make([]HashRecord, n)
+	for i := range records {
+		records[i] = HashRecord{FileName: fmt.Sprintf("file%d", i), Distance: r.IntN(50)}
+	}
+	return records
+}
+
+func TestCollector(t *testing.T) {
+	records := collectorRecords(1, 1000)
+	// What the collector keeps: the closest records, the first added
+	// among those at the same distance.
+	sorted := append([]HashRecord(nil), records...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Distance < sorted[j].Distance })
+
+	for _, limit := range []int{-1, 0, 1, 2, 10, 999, 1000, 5000} {
+		t.Run(fmt.Sprint(limit), func(t *testing.T) {
+			c := NewCollector(limit)
+			for _, rec := range records {
+				c.Add(rec)
+			}
+			want, dropped := sorted, 0
+			if limit >= 0 && limit < len(sorted) {
+				want, dropped = sorted[:limit], len(sorted)-limit
+			}
+			got := c.Records()
+			if len(got) != len(want) || len(got) > 0 && !reflect.DeepEqual(got, want) {
+				t.Errorf("Records = %v\nwant %v", got, want)
+			}
+			if c.Dropped() != dropped {
+				t.Errorf("Dropped = %d, want %d", c.Dropped(), dropped)
+			}
+		})
+	}
+}
+
+func TestCollectorOrder(t *testing.T) {
+	// Records arriving closest first, furthest first or in any order are
+	// kept the same way.
+	records := collectorRecords(2, 200)
+	var want []HashRecord
+	for _, order := range []string{"as added", "closest first", "furthest first", "shuffled"} {
+		in := append([]HashRecord(nil), records...)
+		switch order {
+		case "closest first":
+			sort.SliceStable(in, func(i, j int) bool { return in[i].Distance < in[j].Distance })
+		case "furthest first":
+			sort.SliceStable(in, func(i, j int) bool { return in[i].Distance > in[j].Distance })
+		case "shuffled":
+			rand.New(rand.NewPCG(3, 3)).Shuffle(len(in), func(i, j int) { in[i], in[j] = in[j], in[i] })
+		}
+		c := NewCollector(25)
+		for _, rec := range in {
+			c.Add(rec)
+		}
+		got := c.Records()
+		for i := 1; i < len(got); i++ {
+			if got[i].Distance < got[i-1].Distance {
+				t.Fatalf("%s: Records not closest first: %v", order, got)
+			}
+		}
+		if want == nil {
+			want = got
+			continue
+		}
+		// Ties may be broken differently, but the distances kept are the
+		// same.
+		for i := range got {
+			if got[i].Distance != want[i].Distance {
+				t.Errorf("%s: distances kept %v, want those of %v", order, got, want)
+				break
+			}
+		}
+		if c.Dropped() != len(records)-25 {
+			t.Errorf("%s: Dropped = %d, want %d", order, c.Dropped(), len(records)-25)
+		}
+	}
+}
+
+func TestCollect(t *testing.T) {
+	query, records := testRecords(t, 16)
+	db, err := NewDatabase(records)
+	if err != nil {
+		t.Fatal(err)
+	}
+	all := bruteForce(t, query, records, -1, -1)
+	median := all[len(all)/2].Distance
+	within := bruteForce(t, query, records, median, -1)
+
+	c := NewCollector(3)
+	if err := db.Collect(context.Background(), query, median, c); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := c.Records(), bruteForce(t, query, records, median, 3); !reflect.DeepEqual(got, want) {
+		t.Errorf("Records = %+v\nwant %+v", got, want)
+	}
+	// Only the records within the distance count as dropped.
+	if c.Dropped() != len(within)-3 {
+		t.Errorf("Dropped = %d, want %d of %d within %d", c.Dropped(), len(within)-3, len(within), median)
+	}
+}
@@ -0,0 +1,363 @@
+package celestlsh
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"runtime"
+	"sort"
+	"sync"
+
+	"github.com/glaslos/tlsh"
+)
+
+// Columns is the number of columns in the CelesTLSH CSV schema:
+//
+//	Repo Name,File Name,Release Version,TLSH Hash,SHA256 Hash,Imphash,Date Added,Intel
+const Columns = 8
+
+// Header is the header row of the CelesTLSH CSV schema.
+var Header = []string{"Repo Name", "File Name", "Release Version", "TLSH Hash", "SHA256 Hash", "Imphash", "Date Added", "Intel"}
+
+// minChunk is the fewest records handed to one goroutine by CheckAll;
+// smaller databases are not worth splitting further.
+const minChunk = 1024
+
+// Database is an in-memory copy of the CelesTLSH database. Rows without a
+// parseable TLSH hash are dropped at load time. A Database is safe for
+// concurrent use once loaded.
+type Database struct {
+	// Workers caps how many goroutines compare hashes against the records
+	// in CheckAll and unbounded Nearest queries. Zero or less means one per
+	// CPU. Set it before the Database is shared.
+	Workers int
+
+	entries []entry
+	stats   LoadStats
+
+	// index is built on the first query with a distance bound.
+	indexOnce sync.Once
+	index     *vpIndex
+}
+
+// maxSkippedRows is how many skipped rows LoadStats describes individually.
+const maxSkippedRows = 20
+
+// LoadStats describes how a Database was loaded: how many rows were read and
+// why those that were dropped were skipped.
+type LoadStats struct {
+	Rows        int
+	ShortRows   int
+	MissingHash int
+	InvalidHash int
+	// Filtered counts the rows a Filter passed to Load left out. They are
+	// not described in Skipped, which is for rows that are faulty.
+	Filtered int
+
+	// Skipped describes the first skipped rows.
+	Skipped []SkippedRow
+}
+
+// SkippedRow is a row dropped at load time. Line is its line in the CSV,
+// counting the header as line 1.
+type SkippedRow struct {
+	Line   int
+	Reason string
+}
+
+type entry struct {
+	record HashRecord
+	digest *tlsh.TLSH
+}
+
+// Load reads and parses the database CSV at path, keeping only the rows
+// that pass every filter.
+func Load(ctx context.Context, path string, filters ...Filter) (*Database, error) {
+	file, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, &DatabaseNotFoundError{Path: path}
+	}
+	if err != nil {
+		return nil, &FileError{Op: "opening database file", Path: path, Err: err}
+	}
+	defer file.Close()
+
+	return LoadReader(ctx, file, filters...)
+}
+
+// LoadReader parses a database CSV from r, keeping only the rows that pass
+// every filter. The first row must be a header with at least Columns
+// columns.
+func LoadReader(ctx context.Context, r io.Reader, filters ...Filter) (*Database, error) {
+	reader := csv.NewReader(r)
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, &DatabaseError{Op: "reading CSV header", Err: err}
+	}
+
+	if len(header) < Columns {
+		return nil, &DatabaseError{Err: fmt.Errorf("CSV header has fewer columns than expected: got %d, want at least %d", len(header), Columns)}
+	}
+
+	db := &Database{}
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, &DatabaseError{Op: "reading CSV record", Err: err}
+		}
+
+		db.stats.Rows++
+
+		if len(record) < Columns {
+			db.stats.ShortRows++
+			db.skip(reader, fmt.Sprintf("%d columns, want %d", len(record), Columns))
+			continue
+		}
+
+		tlshHashStr := record[3]
+		if tlshHashStr == "" || tlshHashStr == "N/A" {
+			db.stats.MissingHash++
+			db.skip(reader, "no TLSH hash")
+			continue
+		}
+
+		rec := HashRecord{
+			RepoName:   record[0],
+			FileName:   record[1],
+			Version:    record[2],
+			TLSHHash:   record[3],
+			SHA256Hash: record[4],
+			Imphash:    record[5],
+			DateAdded:  record[6],
+			Intel:      record[7],
+		}
+		// Filter before parsing the hash, which is the costly part.
+		if !keep(filters, &rec) {
+			db.stats.Filtered++
+			continue
+		}
+
+		digest, err := parseHash("", tlshHashStr)
+		if err != nil {
+			db.stats.InvalidHash++
+			db.skip(reader, err.Error())
+			continue
+		}
+
+		db.entries = append(db.entries, entry{record: rec, digest: digest})
+	}
+
+	return db, nil
+}
+
+// NewDatabase returns a Database holding records, in that order, for
+// comparing hashes against a source other than the CelesTLSH CSV. It fails
+// with a *HashError if the TLSH hash of a record cannot be parsed.
+func NewDatabase(records []HashRecord) (*Database, error) {
+	db := &Database{entries: make([]entry, 0, len(records))}
+	for _, rec := range records {
+		digest, err := parseHash("", rec.TLSHHash)
+		if err != nil {
+			return nil, err
+		}
+		db.entries = append(db.entries, entry{record: rec, digest: digest})
+	}
+	db.stats.Rows = len(records)
+	return db, nil
+}
+
+// skip notes the row just read from reader as skipped for reason.
+func (db *Database) skip(reader *csv.Reader, reason string) {
+	if len(db.stats.Skipped) < maxSkippedRows {
+		line, _ := reader.FieldPos(0)
+		db.stats.Skipped = append(db.stats.Skipped, SkippedRow{Line: line, Reason: reason})
+	}
+}
+
+// LoadStats returns how the database was loaded.
+func (db *Database) LoadStats() LoadStats {
+	return db.stats
+}
+
+// Records returns a copy of the records with a usable TLSH hash, in the
+// order of the CSV.
+func (db *Database) Records() []HashRecord {
+	records := make([]HashRecord, len(db.entries))
+	for i, e := range db.entries {
+		records[i] = e.record
+	}
+	return records
+}
+
+// Len returns the number of records with a usable TLSH hash.
+func (db *Database) Len() int {
+	return len(db.entries)
+}
+
+// Check returns the record closest to hash. It returns ErrNoMatch if the
+// database holds no comparable records.
+func (db *Database) Check(ctx context.Context, hash string) (*HashRecord, error) {
+	matches, err := db.Nearest(ctx, hash, -1, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(matches) == 0 {
+		return nil, ErrNoMatch
+	}
+
+	return &matches[0], nil
+}
+
+// Nearest returns up to top records whose distance to hash is at most
+// maxDistance, closest first. A negative maxDistance or top disables the
+// corresponding limit. With a distance limit, only records that an index
+// built on first use cannot rule out are compared; without one every
+// record is.
+func (db *Database) Nearest(ctx context.Context, hash string, maxDistance, top int) ([]HashRecord, error) {
+	c := NewCollector(top)
+	if err := db.Collect(ctx, hash, maxDistance, c); err != nil {
+		return nil, err
+	}
+	return c.Records(), nil
+}
+
+// Collect adds the records whose distance to hash is at most maxDistance,
+// or every record if it is negative, to c in database order, with their
+// Distance set. c keeps only the closest of them, and its Dropped method
+// then tells how many more there were.
+func (db *Database) Collect(ctx context.Context, hash string, maxDistance int, c *Collector) error {
+	hashObj, err := parseHash("input", hash)
+	if err != nil {
+		return err
+	}
+
+	if maxDistance < 0 {
+		return db.collectAll(ctx, hashObj, c)
+	}
+
+	db.indexOnce.Do(func() {
+		db.index = newVPIndex(db.entries)
+	})
+
+	candidates := db.index.candidates(hashObj, maxDistance)
+	// Keep database order among records at equal distance, as CheckAll does.
+	sort.Ints(candidates)
+
+	for _, i := range candidates {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		d := hashObj.Diff(db.entries[i].digest)
+		if d > maxDistance {
+			continue
+		}
+		record := db.entries[i].record
+		record.Distance = d
+		c.Add(record)
+	}
+	return nil
+}
+
+// RepoMatch summarises the matches from one repository: its closest
+// record, whose Distance is the repository's best, and the number of its
+// records that matched.
+type RepoMatch struct {
+	HashRecord
+	Count int `json:"count"`
+}
+
+// NearestRepos is like Nearest, but groups the matching records by
+// RepoName and returns up to top repositories, closest first.
+func (db *Database) NearestRepos(ctx context.Context, hash string, maxDistance, top int) ([]RepoMatch, error) {
+	matches, err := db.Nearest(ctx, hash, maxDistance, -1)
+	if err != nil {
+		return nil, err
+	}
+
+	repos := GroupByRepo(matches)
+	if top >= 0 && len(repos) > top {
+		repos = repos[:top]
+	}
+	return repos, nil
+}
+
+// GroupByRepo groups matches, which must be ordered closest first, by
+// RepoName. The repositories are in the order of their closest records.
+func GroupByRepo(matches []HashRecord) []RepoMatch {
+	var repos []RepoMatch
+	index := make(map[string]int)
+	for _, m := range matches {
+		if i, ok := index[m.RepoName]; ok {
+			repos[i].Count++
+			continue
+		}
+		index[m.RepoName] = len(repos)
+		repos = append(repos, RepoMatch{HashRecord: m, Count: 1})
+	}
+	return repos
+}
+
+// CheckAll compares hash against every record and returns them all ordered
+// by ascending distance. Records at equal distance keep database order.
+func (db *Database) CheckAll(ctx context.Context, hash string) ([]HashRecord, error) {
+	return db.Nearest(ctx, hash, -1, -1)
+}
+
+// collectAll compares digest against every record, in parallel, and adds
+// them all to c in database order.
+func (db *Database) collectAll(ctx context.Context, digest *tlsh.TLSH, c *Collector) error {
+	distances := make([]int, len(db.entries))
+
+	workers := db.Workers
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	chunk := (len(db.entries) + workers - 1) / workers
+	if chunk < minChunk {
+		chunk = minChunk
+	}
+
+	// Each worker fills its own range of distances, so the outcome does
+	// not depend on how the work interleaves.
+	var wg sync.WaitGroup
+	for start := 0; start < len(db.entries); start += chunk {
+		end := min(start+chunk, len(db.entries))
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := start; i < end; i++ {
+				if i%minChunk == 0 && ctx.Err() != nil {
+					return
+				}
+				distances[i] = digest.Diff(db.entries[i].digest)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	for i, d := range distances {
+		record := db.entries[i].record
+		record.Distance = d
+		c.Add(record)
+	}
+	return nil
+}
@@ -0,0 +1,347 @@
+package celestlsh
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// testRecords returns n records hashing variants of one sample, so that
+// their distances from the sample vary, with a few unrelated ones after
+// them. They are named after their position.
+func testRecords(t testing.TB, n int) (query string, records []HashRecord) {
+	t.Helper()
+	base := sample(100, 4096)
+	query = mustHash(t, base)
+	for i := 0; i < n; i++ {
+		data := variant(base, 8+i*3)
+		if i%4 == 3 {
+			data = sample(uint64(200+i), 4096)
+		}
+		records = append(records, HashRecord{
+			RepoName:   "repo" + string(rune('a'+i%3)),
+			FileName:   "file" + string(rune('a'+i)) + ".exe",
+			Version:    "v1",
+			TLSHHash:   mustHash(t, data),
+			SHA256Hash: strings.Repeat("0", 64),
+			DateAdded:  "2024-01-01",
+		})
+	}
+	return query, records
+}
+
+// writeCSV returns records as a database CSV.
+func writeCSV(t testing.TB, records []HashRecord, extra ...[]string) string {
+	t.Helper()
+	var b strings.Builder
+	w := csv.NewWriter(&b)
+	w.Write(Header)
+	for _, r := range records {
+		w.Write(r.CSVRow())
+	}
+	w.WriteAll(extra)
+	if err := w.Error(); err != nil {
+		t.Fatal(err)
+	}
+	return b.String()
+}
+
+// bruteForce returns records ordered by distance from query, keeping
+// database order among equal distances, limited like Nearest.
+func bruteForce(t testing.TB, query string, records []HashRecord, maxDistance, top int) []HashRecord {
+	t.Helper()
+	var out []HashRecord
+	for _, r := range records {
+		d, err := Distance(query, r.TLSHHash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if maxDistance >= 0 && d > maxDistance {
+			continue
+		}
+		r.Distance = d
+		out = append(out, r)
+	}
+	// Insertion sort keeps it stable without repeating the code under test.
+	for i := 1; i < len(out); i++ {
+		for j := i; j > 0 && out[j].Distance < out[j-1].Distance; j-- {
+			out[j], out[j-1] = out[j-1], out[j]
+		}
+	}
+	if top >= 0 && len(out) > top {
+		out = out[:top]
+	}
+	return out
+}
+
+func TestLoadReader(t *testing.T) {
+	_, records := testRecords(t, 5)
+	data := writeCSV(t, records,
+		[]string{"r", "f", "v", "N/A", "", "", "", ""},
+		[]string{"r", "f", "v", "", "", "", "", ""},
+		[]string{"r", "f", "v", "nothex", "", "", "", ""},
+		append([]string{"r", "f", "v"}, WithT1Prefix(records[0].TLSHHash), "", "", "", ""),
+	)
+
+	db, err := LoadReader(context.Background(), strings.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if db.Len() != 6 {
+		t.Errorf("Len = %d, want 6", db.Len())
+	}
+	stats := db.LoadStats()
+	want := LoadStats{Rows: 9, MissingHash: 2, InvalidHash: 1}
+	if stats.Rows != want.Rows || stats.MissingHash != want.MissingHash || stats.InvalidHash != want.InvalidHash {
+		t.Errorf("LoadStats = %+v, want counts %+v", stats, want)
+	}
+	lines := make([]int, len(stats.Skipped))
+	for i, s := range stats.Skipped {
+		lines[i] = s.Line
+	}
+	if !reflect.DeepEqual(lines, []int{7, 8, 9}) {
+		t.Errorf("skipped lines = %v, want [7 8 9]", lines)
+	}
+	if got := db.Records()[:5]; !reflect.DeepEqual(got, records) {
+		t.Errorf("Records = %+v, want %+v", got, records)
+	}
+}
+
+func TestLoadReaderMalformed(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+	}{
+		{"empty", ""},
+		{"narrow header", "Repo Name,File Name\n"},
+		{"bad quoting", strings.Join(Header, ",") + "\n\"unterminated\n"},
+		// The reader holds every row to the width of the header.
+		{"short row", strings.Join(Header, ",") + "\nshort,row\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := LoadReader(context.Background(), strings.NewReader(tt.data))
+			var dbErr *DatabaseError
+			if !errors.As(err, &dbErr) || !errors.Is(err, ErrMalformedDatabase) {
+				t.Fatalf("LoadReader error = %v, want a *DatabaseError matching ErrMalformedDatabase", err)
+			}
+		})
+	}
+}
+
+func TestLoad(t *testing.T) {
+	_, records := testRecords(t, 3)
+	path := filepath.Join(t.TempDir(), "db.csv")
+	if err := os.WriteFile(path, []byte(writeCSV(t, records)), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := Load(context.Background(), path)
+	if err != nil || db.Len() != 3 {
+		t.Fatalf("Load = %v, %v; want 3 records", db, err)
+	}
+
+	missing := filepath.Join(t.TempDir(), "missing.csv")
+	_, err = Load(context.Background(), missing)
+	var notFound *DatabaseNotFoundError
+	if !errors.As(err, &notFound) || notFound.Path != missing {
+		t.Fatalf("Load of a missing file = %v, want a *DatabaseNotFoundError for %s", err, missing)
+	}
+	if !errors.Is(err, ErrDatabaseNotFound) || !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("missing database error does not match ErrDatabaseNotFound and fs.ErrNotExist")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := Load(ctx, path); !errors.Is(err, context.Canceled) {
+		t.Errorf("Load with a cancelled context = %v, want context.Canceled", err)
+	}
+}
+
+func TestLoadFilter(t *testing.T) {
+	_, records := testRecords(t, 6)
+	onlyA := func(r *HashRecord) bool { return r.RepoName == "repoa" }
+	db, err := LoadReader(context.Background(), strings.NewReader(writeCSV(t, records)), onlyA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if db.Len() != 2 || db.LoadStats().Filtered != 4 {
+		t.Errorf("Len = %d, Filtered = %d; want 2 and 4", db.Len(), db.LoadStats().Filtered)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	query, records := testRecords(t, 12)
+	db, err := NewDatabase(records)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	best, err := db.Check(ctx, query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := bruteForce(t, query, records, -1, 1)[0]
+	if *best != want {
+		t.Errorf("Check = %+v, want %+v", *best, want)
+	}
+
+	empty, _ := NewDatabase(nil)
+	if _, err := empty.Check(ctx, query); !errors.Is(err, ErrNoMatch) {
+		t.Errorf("Check of an empty database = %v, want ErrNoMatch", err)
+	}
+
+	_, err = db.Check(ctx, "bogus")
+	var hashErr *HashError
+	if !errors.As(err, &hashErr) || hashErr.Role != "input" {
+		t.Errorf("Check of an invalid hash = %v, want a *HashError for the input hash", err)
+	}
+}
+
+func TestCheckT1Prefix(t *testing.T) {
+	query, records := testRecords(t, 12)
+	db, err := NewDatabase(records)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	want := bruteForce(t, query, records, -1, 1)[0]
+
+	// The T1 prefix does not change the result, on either side.
+	if best, err := db.Check(ctx, WithT1Prefix(query)); err != nil || *best != want {
+		t.Errorf("Check with T1 prefix = %+v, %v; want %+v", best, err, want)
+	}
+	prefixed := make([]HashRecord, len(records))
+	for i, r := range records {
+		r.TLSHHash = WithT1Prefix(r.TLSHHash)
+		prefixed[i] = r
+	}
+	prefixedDB, err := NewDatabase(prefixed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if best, err := prefixedDB.Check(ctx, query); err != nil || best.Distance != want.Distance || best.FileName != want.FileName {
+		t.Errorf("Check against T1 records = %+v, %v; want %s at %d", best, err, want.FileName, want.Distance)
+	}
+}
+
+func TestCheckAll(t *testing.T) {
+	query, records := testRecords(t, 12)
+	// Duplicates tie with the originals and must follow them.
+	records = append(records, records[0], records[5])
+	records[12].FileName = "dup0"
+	records[13].FileName = "dup5"
+
+	db, err := NewDatabase(records)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := db.CheckAll(context.Background(), query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := bruteForce(t, query, records, -1, -1); !reflect.DeepEqual(got, want) {
+		t.Errorf("CheckAll =\n%+v\nwant\n%+v", got, want)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := db.CheckAll(ctx, query); !errors.Is(err, context.Canceled) {
+		t.Errorf("CheckAll with a cancelled context = %v, want context.Canceled", err)
+	}
+}
+
+func BenchmarkCheckAll(b *testing.B) {
+	records, queries := randomDatabase(b, 7, 200000)
+	db, err := NewDatabase(records)
+	if err != nil {
+		b.Fatal(err)
+	}
+	ctx := context.Background()
+
+	workers := []int{1, 2, 4}
+	if n := runtime.NumCPU(); !slices.Contains(workers, n) {
+		workers = append(workers, n)
+	}
+	for _, n := range workers {
+		b.Run(fmt.Sprintf("workers=%d", n), func(b *testing.B) {
+			db.Workers = n
+			for i := 0; i < b.N; i++ {
+				if _, err := db.CheckAll(ctx, queries[i%len(queries)]); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestNearest(t *testing.T) {
+	query, records := testRecords(t, 16)
+	db, err := NewDatabase(records)
+	if err != nil {
+		t.Fatal(err)
+	}
+	all := bruteForce(t, query, records, -1, -1)
+	median := all[len(all)/2].Distance
+
+	tests := []struct {
+		name             string
+		maxDistance, top int
+	}{
+		{"unbounded", -1, -1},
+		{"top 3", -1, 3},
+		{"top 0", -1, 0},
+		{"within median", median, -1},
+		{"within median, top 2", median, 2},
+		{"exact only", 0, -1},
+		{"everything", 10000, -1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := db.Nearest(context.Background(), query, tt.maxDistance, tt.top)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := bruteForce(t, query, records, tt.maxDistance, tt.top)
+			if len(got) != len(want) || len(got) > 0 && !reflect.DeepEqual(got, want) {
+				t.Errorf("Nearest =\n%+v\nwant\n%+v", got, want)
+			}
+		})
+	}
+}
+
+func TestNearestRepos(t *testing.T) {
+	query, records := testRecords(t, 12)
+	db, err := NewDatabase(records)
+	if err != nil {
+		t.Fatal(err)
+	}
+	repos, err := db.NearestRepos(context.Background(), query, -1, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	all := bruteForce(t, query, records, -1, -1)
+	if len(repos) != 2 || repos[0].HashRecord != all[0] || repos[0].Count != 4 {
+		t.Fatalf("NearestRepos = %+v, want 2 repositories led by %+v with 4 records", repos, all[0])
+	}
+	if repos[1].RepoName == repos[0].RepoName || repos[1].Distance < repos[0].Distance {
+		t.Errorf("second repository %+v repeats or beats the first %+v", repos[1], repos[0])
+	}
+}
+
+func TestNewDatabaseInvalid(t *testing.T) {
+	_, err := NewDatabase([]HashRecord{{TLSHHash: "bogus"}})
+	if !errors.Is(err, ErrInvalidHash) {
+		t.Fatalf("NewDatabase error = %v, want ErrInvalidHash", err)
+	}
+}
@@ -0,0 +1,210 @@
+package celestlsh
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultDatabaseURL is where the published CelesTLSH database is fetched from.
+const DefaultDatabaseURL = "https://github.com/Magonia-Research/CelesTLSH-Hashes/blob/main/all_attack_tools_hashes.csv"
+
+// Downloader fetches the database CSV over HTTP.
+type Downloader struct {
+	// URL is the location of the database CSV, or for DownloadShards of
+	// its ShardManifest.
+	URL string
+
+	// Client performs the request. It must not be nil.
+	Client *http.Client
+
+	// Header is added to the request, for example to authenticate to a
+	// private mirror.
+	Header http.Header
+
+	// Verify, if set, is called with the path of the complete download
+	// before it replaces the output file. If it returns an error the
+	// download is discarded and any existing file left in place.
+	Verify func(path string) error
+
+	// Progress, if set, wraps the response body before it is saved, for
+	// example to report how much has been received. total is the size
+	// announced by the server, or -1 if unknown.
+	Progress func(body io.Reader, total int64) io.Reader
+
+	// Concurrency is how many shards DownloadShards fetches at once; 0
+	// means DefaultShardConcurrency.
+	Concurrency int
+}
+
+// NewDownloader returns a Downloader for DefaultDatabaseURL using a client
+// with a 30 second timeout.
+func NewDownloader() *Downloader {
+	return &Downloader{
+		URL: DefaultDatabaseURL,
+		Client: &http.Client{
+			Timeout: 30 * time.Second,
+		},
+	}
+}
+
+// Download saves the database to outputPath, creating its parent directory
+// if needed. The data is written to a temporary file beside outputPath and
+// renamed into place once complete, so an existing database is left intact
+// if the download fails or ctx is cancelled.
+func (d *Downloader) Download(ctx context.Context, outputPath string) (err error) {
+	dirPath := filepath.Dir(outputPath)
+	if dirPath != "." {
+		if err := os.MkdirAll(dirPath, 0755); err != nil {
+			return &FileError{Op: "creating directory", Path: dirPath, Err: err}
+		}
+	}
+
+	resp, err := d.get(ctx, d.URL, d.Header)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	out, err := os.CreateTemp(dirPath, "."+filepath.Base(outputPath)+".*.tmp")
+	if err != nil {
+		return &FileError{Op: "creating output file", Path: outputPath, Err: err}
+	}
+	defer func() {
+		if err != nil {
+			out.Close()
+			os.Remove(out.Name())
+		}
+	}()
+
+	var body io.Reader = resp.Body
+	if d.Progress != nil {
+		body = d.Progress(body, resp.ContentLength)
+	}
+
+	if _, err = io.Copy(out, body); err != nil {
+		return &FileError{Op: "saving data to file", Path: outputPath, Err: err}
+	}
+	if err = out.Close(); err != nil {
+		return &FileError{Op: "saving data to file", Path: outputPath, Err: err}
+	}
+	// CreateTemp makes the file private; give it the permissions os.Create would.
+	if err = os.Chmod(out.Name(), 0644); err != nil {
+		return &FileError{Op: "saving data to file", Path: outputPath, Err: err}
+	}
+	if d.Verify != nil {
+		if err = d.Verify(out.Name()); err != nil {
+			return err
+		}
+	}
+	if err = os.Rename(out.Name(), outputPath); err != nil {
+		return &FileError{Op: "saving data to file", Path: outputPath, Err: err}
+	}
+
+	return nil
+}
+
+// get requests url with header, returning the response if its status is
+// 200 OK.
+func (d *Downloader) get(ctx context.Context, url string, header http.Header) (*http.Response, error) {
+	resp, err := d.request(ctx, http.MethodGet, url, header)
+	if err != nil {
+		return nil, &RequestError{URL: url, Err: err}
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, &StatusError{URL: url, StatusCode: resp.StatusCode}
+	}
+	return resp, nil
+}
+
+// request sends a request for url with header.
+func (d *Downloader) request(ctx context.Context, method, url string, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range header {
+		req.Header[name] = append(req.Header[name], values...)
+	}
+	return d.Client.Do(req)
+}
+
+// RemoteInfo describes the database at a Downloader's URL as its server
+// reports it, without fetching it.
+type RemoteInfo struct {
+	// URL is where the database is served from, after any redirects.
+	URL string
+
+	// Size is the length of the database in bytes, or -1 if the server
+	// did not say.
+	Size int64
+
+	// LastModified and ETag are empty if the server did not send them.
+	LastModified string
+	ETag         string
+
+	// Ranges reports whether the server answers Range requests, so that an
+	// interrupted download could be resumed.
+	Ranges bool
+}
+
+// Probe asks the server for the headers of the database at d.URL with a
+// HEAD request. Servers that refuse HEAD, as object stores do for URLs
+// signed for GET, are sent a GET for the first byte instead, whose body is
+// not read.
+func (d *Downloader) Probe(ctx context.Context) (RemoteInfo, error) {
+	resp, err := d.request(ctx, http.MethodHead, d.URL, d.Header)
+	if err != nil {
+		return RemoteInfo{}, &RequestError{URL: d.URL, Err: err}
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		return RemoteInfo{
+			URL:          resp.Request.URL.String(),
+			Size:         resp.ContentLength,
+			LastModified: resp.Header.Get("Last-Modified"),
+			ETag:         resp.Header.Get("ETag"),
+			Ranges:       strings.EqualFold(resp.Header.Get("Accept-Ranges"), "bytes"),
+		}, nil
+	}
+
+	header := d.Header.Clone()
+	if header == nil {
+		header = http.Header{}
+	}
+	header.Set("Range", "bytes=0-0")
+	resp, err = d.request(ctx, http.MethodGet, d.URL, header)
+	if err != nil {
+		return RemoteInfo{}, &RequestError{URL: d.URL, Err: err}
+	}
+	resp.Body.Close()
+	info := RemoteInfo{
+		URL:          resp.Request.URL.String(),
+		Size:         resp.ContentLength,
+		LastModified: resp.Header.Get("Last-Modified"),
+		ETag:         resp.Header.Get("ETag"),
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+		// The server ignored the range and started sending everything.
+	case http.StatusPartialContent:
+		info.Ranges = true
+		info.Size = -1
+		// Content-Range is "bytes 0-0/<size>", or "bytes 0-0/*" when the
+		// size is unknown.
+		if _, total, ok := strings.Cut(resp.Header.Get("Content-Range"), "/"); ok {
+			if n, err := strconv.ParseInt(total, 10, 64); err == nil && n >= 0 {
+				info.Size = n
+			}
+		}
+	default:
+		return RemoteInfo{}, &StatusError{URL: d.URL, StatusCode: resp.StatusCode}
+	}
+	return info, nil
+}
@@ -0,0 +1,264 @@
+package celestlsh
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testDatabase = "Repo Name,File Name,Release Version,TLSH Hash,SHA256 Hash,Imphash,Date Added,Intel\n"
+
+func newTestDownloader(url string) *Downloader {
+	d := NewDownloader()
+	d.URL = url
+	return d
+}
+
+// tempFiles returns the names of the files Download leaves beside its
+// output while it runs.
+func tempFiles(t *testing.T, dir string) []string {
+	t.Helper()
+	matches, err := filepath.Glob(filepath.Join(dir, ".*.tmp"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return matches
+}
+
+func TestDownload(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		io.WriteString(w, testDatabase)
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	out := filepath.Join(dir, "sub", "db.csv")
+	d := newTestDownloader(srv.URL)
+	d.Header = http.Header{"Authorization": {"Bearer secret"}}
+
+	var verified string
+	d.Verify = func(path string) error {
+		if path == out {
+			t.Errorf("Verify called with the output path rather than the download")
+		}
+		data, err := os.ReadFile(path)
+		verified = string(data)
+		return err
+	}
+	if err := d.Download(context.Background(), out); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(out)
+	if err != nil || string(data) != testDatabase {
+		t.Fatalf("downloaded %q, %v; want %q", data, err, testDatabase)
+	}
+	if verified != testDatabase {
+		t.Errorf("Verify saw %q, want the complete download", verified)
+	}
+	if info, err := os.Stat(out); err != nil || info.Mode().Perm() != 0644 {
+		t.Errorf("mode of the download = %v, %v; want 0644", info.Mode().Perm(), err)
+	}
+	if tmp := tempFiles(t, filepath.Dir(out)); len(tmp) != 0 {
+		t.Errorf("temporary files left behind: %v", tmp)
+	}
+}
+
+func TestDownloadKeepsExistingFile(t *testing.T) {
+	const existing = "the old database\n"
+
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		verify  func(string) error
+		match   error
+	}{
+		{
+			name:    "not found",
+			handler: func(w http.ResponseWriter, r *http.Request) { http.NotFound(w, r) },
+			match:   ErrDownloadFailed,
+		},
+		{
+			name:    "unauthorized",
+			handler: func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusForbidden) },
+			match:   ErrAuthenticationFailed,
+		},
+		{
+			name:    "verification failure",
+			handler: func(w http.ResponseWriter, r *http.Request) { io.WriteString(w, testDatabase) },
+			verify:  func(string) error { return &SignatureError{Err: errors.New("bad signature")} },
+			match:   ErrInvalidSignature,
+		},
+		{
+			name: "truncated body",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Length", "1000")
+				io.WriteString(w, testDatabase)
+			},
+			match: io.ErrUnexpectedEOF,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(tt.handler)
+			defer srv.Close()
+
+			dir := t.TempDir()
+			out := filepath.Join(dir, "db.csv")
+			if err := os.WriteFile(out, []byte(existing), 0644); err != nil {
+				t.Fatal(err)
+			}
+			d := newTestDownloader(srv.URL)
+			d.Verify = tt.verify
+
+			err := d.Download(context.Background(), out)
+			if !errors.Is(err, tt.match) {
+				t.Fatalf("Download error = %v, want a match for %v", err, tt.match)
+			}
+			if data, _ := os.ReadFile(out); string(data) != existing {
+				t.Errorf("existing database replaced with %q", data)
+			}
+			if tmp := tempFiles(t, dir); len(tmp) != 0 {
+				t.Errorf("temporary files left behind: %v", tmp)
+			}
+		})
+	}
+}
+
+func TestDownloadCancelled(t *testing.T) {
+	started := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, strings.Repeat("x", 1024))
+		w.(http.Flusher).Flush()
+		close(started)
+		<-r.Context().Done()
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	out := filepath.Join(dir, "db.csv")
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-started
+		cancel()
+	}()
+
+	err := newTestDownloader(srv.URL).Download(ctx, out)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Download error = %v, want context.Canceled", err)
+	}
+	if _, err := os.Stat(out); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("output exists after a cancelled download: %v", err)
+	}
+	if tmp := tempFiles(t, dir); len(tmp) != 0 {
+		t.Errorf("temporary files left behind: %v", tmp)
+	}
+}
+
+func TestDownloadProgress(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, testDatabase)
+	}))
+	defer srv.Close()
+
+	d := newTestDownloader(srv.URL)
+	var total int64
+	var read int
+	d.Progress = func(body io.Reader, n int64) io.Reader {
+		total = n
+		return readerFunc(func(p []byte) (int, error) {
+			n, err := body.Read(p)
+			read += n
+			return n, err
+		})
+	}
+	if err := d.Download(context.Background(), filepath.Join(t.TempDir(), "db.csv")); err != nil {
+		t.Fatal(err)
+	}
+	if total != int64(len(testDatabase)) || read != len(testDatabase) {
+		t.Errorf("progress saw %d of %d bytes, want %d of %d", read, total, len(testDatabase), len(testDatabase))
+	}
+}
+
+type readerFunc func([]byte) (int, error)
+
+func (f readerFunc) Read(p []byte) (int, error) { return f(p) }
+
+func TestProbe(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		want    RemoteInfo
+	}{
+		{
+			name: "head",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Accept-Ranges", "bytes")
+				w.Header().Set("ETag", `"abc"`)
+				w.Header().Set("Last-Modified", "Mon, 02 Jan 2006 15:04:05 GMT")
+				w.Header().Set("Content-Length", "1234")
+			},
+			want: RemoteInfo{Size: 1234, ETag: `"abc"`, LastModified: "Mon, 02 Jan 2006 15:04:05 GMT", Ranges: true},
+		},
+		{
+			name: "head refused, ranged get",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				if r.Method == http.MethodHead {
+					w.WriteHeader(http.StatusForbidden)
+					return
+				}
+				if r.Header.Get("Range") != "bytes=0-0" {
+					t.Errorf("Range = %q, want bytes=0-0", r.Header.Get("Range"))
+				}
+				w.Header().Set("Content-Range", "bytes 0-0/5678")
+				w.WriteHeader(http.StatusPartialContent)
+				io.WriteString(w, "R")
+			},
+			want: RemoteInfo{Size: 5678, Ranges: true},
+		},
+		{
+			name: "head refused, range ignored",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				if r.Method == http.MethodHead {
+					w.WriteHeader(http.StatusMethodNotAllowed)
+					return
+				}
+				io.WriteString(w, testDatabase)
+			},
+			want: RemoteInfo{Size: int64(len(testDatabase))},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(tt.handler)
+			defer srv.Close()
+
+			got, err := newTestDownloader(srv.URL).Probe(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			tt.want.URL = srv.URL
+			if got != tt.want {
+				t.Errorf("Probe = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+
+	srv := httptest.NewServer(http.NotFoundHandler())
+	defer srv.Close()
+	_, err := newTestDownloader(srv.URL).Probe(context.Background())
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusNotFound {
+		t.Errorf("Probe of a missing database = %v, want a 404 *StatusError", err)
+	}
+}
@@ -0,0 +1,41 @@
+package celestlsh
+
+import "math"
+
+// byteHistogram counts the byte values written to it.
+type byteHistogram [256]uint64
+
+func (h *byteHistogram) Write(p []byte) (int, error) {
+	for _, b := range p {
+		h[b]++
+	}
+	return len(p), nil
+}
+
+// entropy returns the Shannon entropy of the bytes counted so far.
+func (h *byteHistogram) entropy() float64 {
+	var total uint64
+	for _, n := range h {
+		total += n
+	}
+	if total == 0 {
+		return 0
+	}
+	var e float64
+	for _, n := range h {
+		if n > 0 {
+			p := float64(n) / float64(total)
+			e -= p * math.Log2(p)
+		}
+	}
+	return e
+}
+
+// Entropy returns the Shannon entropy of data in bits per byte, from 0 for
+// a single repeated byte value up to 8 when all 256 values are equally
+// common, as in compressed or encrypted data.
+func Entropy(data []byte) float64 {
+	var h byteHistogram
+	h.Write(data)
+	return h.entropy()
+}
@@ -0,0 +1,62 @@
+package celestlsh
+
+import (
+	"bytes"
+	"context"
+	"math"
+	"testing"
+	"testing/iotest"
+)
+
+func TestEntropy(t *testing.T) {
+	uniform := make([]byte, 256*16)
+	for i := range uniform {
+		uniform[i] = byte(i)
+	}
+	tests := []struct {
+		name string
+		data []byte
+		want float64
+	}{
+		{"empty", nil, 0},
+		{"all zero", make([]byte, 4096), 0},
+		{"two values", bytes.Repeat([]byte{0, 0xff}, 512), 1},
+		{"four values", bytes.Repeat([]byte("abcd"), 100), 2},
+		{"every value equally", uniform, 8},
+		{"skewed", []byte("aaab"), 0.8112781244591328},
+	}
+	for _, tt := range tests {
+		if got := Entropy(tt.data); math.Abs(got-tt.want) > 1e-12 {
+			t.Errorf("%s: Entropy = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	// Random data comes close to 8 without reaching it.
+	if got := Entropy(sample(1, 1<<20)); got < 7.999 || got >= 8 {
+		t.Errorf("Entropy of 1 MiB of random data = %v, want just under 8", got)
+	}
+}
+
+func TestDigestEntropy(t *testing.T) {
+	data := append(bytes.Repeat([]byte("log line 42: all quiet\n"), 2000), sample(2, 32<<10)...)
+	var h Hasher
+	// Counted as the data streams past, in whatever pieces it arrives.
+	d, err := h.DigestReader(context.Background(), iotest.HalfReader(bytes.NewReader(data)), DigestEntropy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := Entropy(data); d.Entropy != want {
+		t.Errorf("DigestReader entropy = %v, want %v", d.Entropy, want)
+	}
+	if d.TLSH == "" {
+		t.Error("no TLSH hash alongside the entropy")
+	}
+
+	d, err = h.DigestReader(context.Background(), bytes.NewReader(data), DigestSHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Entropy != 0 {
+		t.Errorf("entropy = %v without DigestEntropy, want 0", d.Entropy)
+	}
+}
@@ -0,0 +1,236 @@
+package celestlsh
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+)
+
+var (
+	// ErrInvalidHash is matched by every *HashError.
+	ErrInvalidHash = errors.New("invalid TLSH hash")
+
+	// ErrMalformedDatabase is matched by every *DatabaseError.
+	ErrMalformedDatabase = errors.New("malformed database")
+
+	// ErrInsufficientData is matched by every *InsufficientDataError.
+	ErrInsufficientData = errors.New("not enough data for a TLSH hash")
+
+	// ErrDatabaseNotFound is matched by every *DatabaseNotFoundError.
+	ErrDatabaseNotFound = errors.New("database not found")
+
+	// ErrDownloadFailed is matched by every *RequestError, *StatusError,
+	// *ManifestError and *ShardError.
+	ErrDownloadFailed = errors.New("download failed")
+
+	// ErrAuthenticationFailed is matched by a *StatusError for a 401 or
+	// 403 response, as well as by ErrDownloadFailed.
+	ErrAuthenticationFailed = errors.New("authentication failed")
+
+	// ErrInvalidSignature is matched by every *SignatureError.
+	ErrInvalidSignature = errors.New("invalid signature")
+
+	// ErrNoMatch is returned by Database.Check when no record in the
+	// database has a usable TLSH hash to compare against.
+	ErrNoMatch = errors.New("no matches found")
+)
+
+// HashError reports a TLSH string that could not be parsed. Role describes
+// which hash of the operation failed, such as "first" or "input".
+type HashError struct {
+	Role string
+	Hash string
+	Err  error
+}
+
+func (e *HashError) Error() string {
+	if e.Role == "" {
+		return fmt.Sprintf("error parsing hash: %v", e.Err)
+	}
+	return fmt.Sprintf("error parsing %s hash: %v", e.Role, e.Err)
+}
+
+func (e *HashError) Unwrap() error { return e.Err }
+
+func (e *HashError) Is(target error) bool { return target == ErrInvalidHash }
+
+// HashingError reports input that was read successfully but could not be
+// turned into a TLSH digest.
+type HashingError struct {
+	Err error
+}
+
+func (e *HashingError) Error() string {
+	return fmt.Sprintf("error calculating TLSH hash: %v", e.Err)
+}
+
+func (e *HashingError) Unwrap() error { return e.Err }
+
+// InsufficientDataError reports input that TLSH cannot meaningfully hash:
+// shorter than MinSize bytes, or with contents too uniform to fill at least
+// half of the digest's buckets. It is returned wrapped in a *HashingError.
+type InsufficientDataError struct {
+	Size          int64
+	MinSize       int64
+	LowComplexity bool
+}
+
+func (e *InsufficientDataError) Error() string {
+	if e.LowComplexity {
+		return fmt.Sprintf("%d bytes have too little variation for a TLSH hash", e.Size)
+	}
+	return fmt.Sprintf("%d bytes is below the TLSH minimum of %d bytes", e.Size, e.MinSize)
+}
+
+func (e *InsufficientDataError) Is(target error) bool { return target == ErrInsufficientData }
+
+// ImphashError reports a file that starts like a PE file but whose headers
+// or import table could not be parsed.
+type ImphashError struct {
+	Err error
+}
+
+func (e *ImphashError) Error() string {
+	return fmt.Sprintf("error calculating imphash: %v", e.Err)
+}
+
+func (e *ImphashError) Unwrap() error { return e.Err }
+
+// SectionError reports a file that starts like a PE or ELF file but whose
+// section table could not be parsed, or whose sections could not be read.
+type SectionError struct {
+	Err error
+}
+
+func (e *SectionError) Error() string {
+	return fmt.Sprintf("error hashing sections: %v", e.Err)
+}
+
+func (e *SectionError) Unwrap() error { return e.Err }
+
+// FileError records a failed filesystem operation and the path involved.
+type FileError struct {
+	Op   string
+	Path string
+	Err  error
+}
+
+func (e *FileError) Error() string {
+	return fmt.Sprintf("error %s: %v", e.Op, e.Err)
+}
+
+func (e *FileError) Unwrap() error { return e.Err }
+
+// DatabaseError reports a database whose contents could not be parsed.
+type DatabaseError struct {
+	Op  string
+	Err error
+}
+
+func (e *DatabaseError) Error() string {
+	if e.Op == "" {
+		return e.Err.Error()
+	}
+	return fmt.Sprintf("error %s: %v", e.Op, e.Err)
+}
+
+func (e *DatabaseError) Unwrap() error { return e.Err }
+
+func (e *DatabaseError) Is(target error) bool { return target == ErrMalformedDatabase }
+
+// DatabaseNotFoundError reports a database file that does not exist. It
+// also matches fs.ErrNotExist.
+type DatabaseNotFoundError struct {
+	Path string
+}
+
+func (e *DatabaseNotFoundError) Error() string {
+	return fmt.Sprintf("database file %s does not exist", e.Path)
+}
+
+func (e *DatabaseNotFoundError) Is(target error) bool {
+	return target == ErrDatabaseNotFound || target == fs.ErrNotExist
+}
+
+// RequestError reports a failed HTTP round trip while downloading.
+type RequestError struct {
+	URL string
+	Err error
+}
+
+func (e *RequestError) Error() string {
+	return fmt.Sprintf("error making HTTP request: %v", e.Err)
+}
+
+func (e *RequestError) Unwrap() error { return e.Err }
+
+func (e *RequestError) Is(target error) bool { return target == ErrDownloadFailed }
+
+// StatusError reports an HTTP response with an unexpected status code.
+type StatusError struct {
+	URL        string
+	StatusCode int
+}
+
+func (e *StatusError) Error() string {
+	if e.authentication() {
+		return fmt.Sprintf("authentication failed: status code %d", e.StatusCode)
+	}
+	return fmt.Sprintf("unexpected status code: %d", e.StatusCode)
+}
+
+func (e *StatusError) Is(target error) bool {
+	return target == ErrDownloadFailed || target == ErrAuthenticationFailed && e.authentication()
+}
+
+// authentication reports whether the server refused the request's
+// credentials, or their absence.
+func (e *StatusError) authentication() bool {
+	return e.StatusCode == http.StatusUnauthorized || e.StatusCode == http.StatusForbidden
+}
+
+// SignatureError reports a key or signature that could not be parsed, or a
+// signature that does not verify.
+type SignatureError struct {
+	Err error
+}
+
+func (e *SignatureError) Error() string {
+	return fmt.Sprintf("signature verification failed: %v", e.Err)
+}
+
+func (e *SignatureError) Unwrap() error { return e.Err }
+
+func (e *SignatureError) Is(target error) bool { return target == ErrInvalidSignature }
+
+// ManifestError reports a shard manifest that could not be parsed or lists
+// no usable shards.
+type ManifestError struct {
+	URL string
+	Err error
+}
+
+func (e *ManifestError) Error() string {
+	return fmt.Sprintf("invalid shard manifest: %v", e.Err)
+}
+
+func (e *ManifestError) Unwrap() error { return e.Err }
+
+func (e *ManifestError) Is(target error) bool { return target == ErrDownloadFailed }
+
+// ShardError reports a shard whose download does not match the size or
+// checksum its manifest gives, or whose CSV header differs from the first
+// shard's.
+type ShardError struct {
+	URL string
+	Err error
+}
+
+func (e *ShardError) Error() string {
+	return fmt.Sprintf("shard %s: %v", e.URL, e.Err)
+}
+
+func (e *ShardError) Unwrap() error { return e.Err }
+
+func (e *ShardError) Is(target error) bool { return target == ErrDownloadFailed }
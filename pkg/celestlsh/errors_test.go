@@ -0,0 +1,89 @@
+package celestlsh
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"testing"
+)
+
+func TestErrorsIs(t *testing.T) {
+	cause := errors.New("cause")
+	sentinels := []error{
+		ErrInvalidHash, ErrMalformedDatabase, ErrInsufficientData, ErrDatabaseNotFound,
+		ErrDownloadFailed, ErrAuthenticationFailed, ErrInvalidSignature, fs.ErrNotExist,
+	}
+
+	tests := []struct {
+		err  error
+		is   []error
+		msg  string
+		wrap bool
+	}{
+		{&HashError{Role: "first", Err: cause}, []error{ErrInvalidHash}, "error parsing first hash: cause", true},
+		{&HashError{Err: cause}, []error{ErrInvalidHash}, "error parsing hash: cause", true},
+		{&HashingError{Err: &InsufficientDataError{Size: 10, MinSize: 256}}, []error{ErrInsufficientData},
+			"error calculating TLSH hash: 10 bytes is below the TLSH minimum of 256 bytes", false},
+		{&InsufficientDataError{Size: 300, MinSize: 256, LowComplexity: true}, []error{ErrInsufficientData},
+			"300 bytes have too little variation for a TLSH hash", false},
+		{&ImphashError{Err: cause}, nil, "error calculating imphash: cause", true},
+		{&SectionError{Err: cause}, nil, "error hashing sections: cause", true},
+		{&FileError{Op: "reading file", Path: "x", Err: cause}, nil, "error reading file: cause", true},
+		{&DatabaseError{Op: "reading CSV header", Err: cause}, []error{ErrMalformedDatabase}, "error reading CSV header: cause", true},
+		{&DatabaseError{Err: cause}, []error{ErrMalformedDatabase}, "cause", true},
+		{&DatabaseNotFoundError{Path: "db.csv"}, []error{ErrDatabaseNotFound, fs.ErrNotExist}, "database file db.csv does not exist", false},
+		{&RequestError{URL: "u", Err: cause}, []error{ErrDownloadFailed}, "error making HTTP request: cause", true},
+		{&StatusError{StatusCode: http.StatusNotFound}, []error{ErrDownloadFailed}, "unexpected status code: 404", false},
+		{&StatusError{StatusCode: http.StatusUnauthorized}, []error{ErrDownloadFailed, ErrAuthenticationFailed},
+			"authentication failed: status code 401", false},
+		{&StatusError{StatusCode: http.StatusForbidden}, []error{ErrDownloadFailed, ErrAuthenticationFailed},
+			"authentication failed: status code 403", false},
+		{&SignatureError{Err: cause}, []error{ErrInvalidSignature}, "signature verification failed: cause", true},
+		{&ManifestError{Err: cause}, []error{ErrDownloadFailed}, "invalid shard manifest: cause", true},
+		{&ShardError{URL: "s.csv", Err: cause}, []error{ErrDownloadFailed}, "shard s.csv: cause", true},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%T %s", tt.err, tt.msg), func(t *testing.T) {
+			if got := tt.err.Error(); got != tt.msg {
+				t.Errorf("Error() = %q, want %q", got, tt.msg)
+			}
+			// Wrapping must not hide the mapping.
+			wrapped := fmt.Errorf("context: %w", tt.err)
+			for _, target := range sentinels {
+				want := false
+				for _, is := range tt.is {
+					want = want || is == target
+				}
+				if got := errors.Is(wrapped, target); got != want {
+					t.Errorf("errors.Is(err, %v) = %v, want %v", target, got, want)
+				}
+			}
+			if got := errors.Is(wrapped, cause); got != tt.wrap {
+				t.Errorf("errors.Is(err, cause) = %v, want %v", got, tt.wrap)
+			}
+		})
+	}
+}
+
+func TestErrorsAs(t *testing.T) {
+	err := fmt.Errorf("loading: %w", &DatabaseError{Op: "reading CSV record", Err: &HashError{Role: "input", Err: errors.New("bad")}})
+
+	var dbErr *DatabaseError
+	if !errors.As(err, &dbErr) || dbErr.Op != "reading CSV record" {
+		t.Errorf("errors.As *DatabaseError = %v", dbErr)
+	}
+	var hashErr *HashError
+	if !errors.As(err, &hashErr) || hashErr.Role != "input" {
+		t.Errorf("errors.As *HashError = %v", hashErr)
+	}
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		t.Errorf("errors.As found an unrelated *StatusError")
+	}
+
+	var insufficient *InsufficientDataError
+	if !errors.As(&HashingError{Err: &InsufficientDataError{Size: 3, MinSize: 50}}, &insufficient) || insufficient.MinSize != 50 {
+		t.Errorf("errors.As *InsufficientDataError = %v", insufficient)
+	}
+}
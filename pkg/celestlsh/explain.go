@@ -0,0 +1,80 @@
+package celestlsh
+
+// DistanceParts breaks a TLSH distance down into the terms the algorithm
+// adds up. The header terms compare the summary values at the start of the
+// digests: the log-scaled input length, the two quartile ratios and the
+// checksum. The body term compares the 128 bucket codes after them. A
+// distance made up mostly of header terms comes from inputs of different
+// length or byte distribution rather than different content.
+type DistanceParts struct {
+	Length   int `json:"length"`
+	Q1Ratio  int `json:"q1_ratio"`
+	Q2Ratio  int `json:"q2_ratio"`
+	Checksum int `json:"checksum"`
+	Header   int `json:"header"`
+	Body     int `json:"body"`
+	Total    int `json:"total"`
+}
+
+// ExplainDistance returns the terms of the TLSH distance between two
+// hashes, whose Total is the value Distance returns.
+func ExplainDistance(hash1, hash2 string) (DistanceParts, error) {
+	t1, err := parseHash("first", hash1)
+	if err != nil {
+		return DistanceParts{}, err
+	}
+	t2, err := parseHash("second", hash2)
+	if err != nil {
+		return DistanceParts{}, err
+	}
+
+	a, b := t1.Binary(), t2.Binary()
+	var p DistanceParts
+	// The checksum and length bytes are stored with their nibbles swapped.
+	p.Length = headerTerm(circularDiff(swapNibbles(a[1]), swapNibbles(b[1]), 256), 0)
+	p.Q1Ratio = headerTerm(circularDiff(a[2]>>4, b[2]>>4, 16), 1)
+	p.Q2Ratio = headerTerm(circularDiff(a[2]&0xf, b[2]&0xf, 16), 1)
+	if a[0] != b[0] {
+		p.Checksum = 1
+	}
+	p.Header = p.Length + p.Q1Ratio + p.Q2Ratio + p.Checksum
+
+	for i := 3; i < len(a) && i < len(b); i++ {
+		for shift := 0; shift < 8; shift += 2 {
+			x, y := int(a[i]>>shift&3), int(b[i]>>shift&3)
+			d := x - y
+			if d < 0 {
+				d = -d
+			}
+			if d == 3 {
+				d = 6
+			}
+			p.Body += d
+		}
+	}
+	p.Total = p.Header + p.Body
+	return p, nil
+}
+
+// headerTerm scores a difference of d steps in a header value: 0 and 1 as
+// they are, and larger ones at 12 per step beyond the first free steps,
+// none for the length and one for the quartile ratios.
+func headerTerm(d, free int) int {
+	if d <= 1 {
+		return d
+	}
+	return (d - free) * 12
+}
+
+// circularDiff is the number of steps between x and y on a ring of size n.
+func circularDiff(x, y byte, n int) int {
+	d := int(x) - int(y)
+	if d < 0 {
+		d = -d
+	}
+	return min(d, n-d)
+}
+
+func swapNibbles(b byte) byte {
+	return b<<4 | b>>4
+}
@@ -0,0 +1,119 @@
+package celestlsh
+
+import (
+	"math/rand/v2"
+	"testing"
+)
+
+func TestExplainDistanceSumsToDistance(t *testing.T) {
+	check := func(hash1, hash2 string) {
+		t.Helper()
+		p, err := ExplainDistance(hash1, hash2)
+		if err != nil {
+			t.Fatalf("ExplainDistance: %v", err)
+		}
+		d, err := Distance(hash1, hash2)
+		if err != nil {
+			t.Fatalf("Distance: %v", err)
+		}
+		if p.Total != d {
+			t.Errorf("ExplainDistance(%s, %s).Total = %d, Distance = %d", hash1, hash2, p.Total, d)
+		}
+		if p.Header != p.Length+p.Q1Ratio+p.Q2Ratio+p.Checksum || p.Total != p.Header+p.Body {
+			t.Errorf("ExplainDistance(%s, %s) = %+v, terms do not add up", hash1, hash2, p)
+		}
+	}
+
+	// Hashes of real data, near and far apart.
+	base := sample(1, 8192)
+	check(mustHash(t, base), mustHash(t, base))
+	check(mustHash(t, base), mustHash(t, variant(base, 50)))
+	check(mustHash(t, base), mustHash(t, base[:3000]))
+	check(mustHash(t, base), mustHash(t, sample(2, 100000)))
+
+	// Random digests reach header values real data rarely does, such as
+	// lengths and quartile ratios that wrap around.
+	records, queries := randomDatabase(t, 7, 200)
+	for _, q := range queries {
+		for _, rec := range records {
+			check(q, rec.TLSHHash)
+		}
+	}
+	r := rand.New(rand.NewPCG(8, 8))
+	for range 2000 {
+		a, b := make([]byte, 70), make([]byte, 70)
+		for i := range a {
+			a[i], b[i] = hexDigits[r.IntN(16)], hexDigits[r.IntN(16)]
+		}
+		check(string(a), string(b))
+	}
+}
+
+func TestExplainDistanceTerms(t *testing.T) {
+	hash := mustHash(t, sample(3, 8192))
+	// with returns hash with the hex digit at i replaced by c.
+	with := func(i int, c byte) string {
+		b := []byte(hash)
+		b[i] = c
+		return string(b)
+	}
+	step := func(i, n int) byte {
+		return hexDigits[(hexValue(hash[i])+n)%16]
+	}
+
+	tests := []struct {
+		name  string
+		other string
+		check func(p DistanceParts) bool
+	}{
+		{"identical", hash, func(p DistanceParts) bool { return p == DistanceParts{} }},
+		{"checksum", with(0, step(0, 5)), func(p DistanceParts) bool {
+			return p.Checksum == 1 && p.Header == 1 && p.Body == 0
+		}},
+		{"length", with(2, step(2, 1)), func(p DistanceParts) bool {
+			return p.Length > 0 && p.Header == p.Length && p.Body == 0
+		}},
+		// A quartile ratio three steps away scores 12 for each step after
+		// the first free one.
+		{"quartile ratio", with(4, step(4, 3)), func(p DistanceParts) bool {
+			return p.Q1Ratio+p.Q2Ratio == 24 && p.Header == 24 && p.Body == 0
+		}},
+		{"body", with(40, step(40, 1)), func(p DistanceParts) bool {
+			return p.Header == 0 && p.Body > 0
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := ExplainDistance(hash, tt.other)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !tt.check(p) {
+				t.Errorf("ExplainDistance = %+v", p)
+			}
+			if d, _ := Distance(hash, tt.other); p.Total != d {
+				t.Errorf("Total = %d, Distance = %d", p.Total, d)
+			}
+		})
+	}
+}
+
+func TestExplainDistanceInvalid(t *testing.T) {
+	hash := mustHash(t, sample(1, 4096))
+	if _, err := ExplainDistance("not a hash", hash); err == nil {
+		t.Error("ExplainDistance with an invalid first hash succeeded")
+	}
+	if _, err := ExplainDistance(hash, "not a hash"); err == nil {
+		t.Error("ExplainDistance with an invalid second hash succeeded")
+	}
+}
+
+func hexValue(c byte) int {
+	switch {
+	case c >= 'a':
+		return int(c-'a') + 10
+	case c >= 'A':
+		return int(c-'A') + 10
+	}
+	return int(c - '0')
+}
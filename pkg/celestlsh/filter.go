@@ -0,0 +1,85 @@
+package celestlsh
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"time"
+)
+
+// Filter reports whether Load should keep a database record. It sees the
+// record before its TLSH hash is parsed, so filtered rows cost little.
+type Filter func(record *HashRecord) bool
+
+// keep reports whether record passes every filter.
+func keep(filters []Filter, record *HashRecord) bool {
+	for _, f := range filters {
+		if !f(record) {
+			return false
+		}
+	}
+	return true
+}
+
+// dateLayouts are the formats ParseDate accepts, as found in the Date Added
+// column over time: ISO 8601 timestamps with or without a zone, and plain
+// dates. Times without a zone are taken as UTC.
+var dateLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05Z07:00",
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02",
+	"2006/01/02",
+}
+
+// ParseDate parses a date in ISO 8601 form, such as 2024-01-31 or
+// 2024-01-31T12:00:00Z.
+func ParseDate(s string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	for _, layout := range dateLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid date %q: want an ISO 8601 date such as 2024-01-31 or 2024-01-31T12:00:00Z", s)
+}
+
+// AddedBetween returns a Filter keeping the records whose DateAdded is at
+// or after since and before until. A zero since or until leaves that end of
+// the range open. Records whose date is empty or cannot be parsed are kept,
+// unless strict is set.
+func AddedBetween(since, until time.Time, strict bool) Filter {
+	return func(record *HashRecord) bool {
+		added, err := ParseDate(record.DateAdded)
+		if err != nil {
+			return !strict
+		}
+		if !since.IsZero() && added.Before(since) {
+			return false
+		}
+		return until.IsZero() || added.Before(until)
+	}
+}
+
+// VersionMatching returns a Filter keeping the records whose Version
+// matches pattern, a path.Match glob such as "4.*" or "v2.?". A trailing
+// ".x", as in "4.x", stands for ".*", a whole release line. A pattern
+// without wildcards must match exactly. Records without a version never
+// match.
+func VersionMatching(pattern string) (Filter, error) {
+	if rest, ok := strings.CutSuffix(pattern, ".x"); ok {
+		pattern = rest + ".*"
+	}
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, fmt.Errorf("invalid version pattern %q: %w", pattern, err)
+	}
+	return func(record *HashRecord) bool {
+		if record.Version == "" {
+			return false
+		}
+		ok, _ := path.Match(pattern, record.Version)
+		return ok
+	}, nil
+}
@@ -0,0 +1,85 @@
+package celestlsh
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseDate(t *testing.T) {
+	day := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		in   string
+		want time.Time
+	}{
+		{"2024-03-01", day},
+		{" 2024-03-01 ", day},
+		{"2024/03/01", day},
+		{"2024-03-01T12:30:00Z", day.Add(12*time.Hour + 30*time.Minute)},
+		{"2024-03-01T12:30:00.5+02:00", day.Add(10*time.Hour + 30*time.Minute + 500*time.Millisecond)},
+		{"2024-03-01T12:30:00", day.Add(12*time.Hour + 30*time.Minute)},
+		{"2024-03-01 12:30:00", day.Add(12*time.Hour + 30*time.Minute)},
+		{"2024-03-01T12:30", day.Add(12*time.Hour + 30*time.Minute)},
+	}
+	for _, tt := range tests {
+		got, err := ParseDate(tt.in)
+		if err != nil || !got.Equal(tt.want) {
+			t.Errorf("ParseDate(%q) = %v, %v; want %v", tt.in, got, err, tt.want)
+		}
+	}
+	for _, in := range []string{"", "N/A", "01/03/2024", "March 1, 2024", "2024-13-01"} {
+		if _, err := ParseDate(in); err == nil {
+			t.Errorf("ParseDate(%q) succeeded, want an error", in)
+		}
+	}
+}
+
+func TestAddedBetween(t *testing.T) {
+	_, records := testRecords(t, 8)
+	for i, date := range []string{
+		"2023-12-31T23:59:59Z",
+		"2024-01-01",
+		"2024/02/15",
+		"2024-02-29 08:00:00",
+		"2024-03-01T00:00:00+01:00", // 2024-02-29T23:00Z
+		"2024-03-01T00:00:00Z",
+		"",
+		"unknown",
+	} {
+		records[i].DateAdded = date
+	}
+	names := func(db *Database) string {
+		var names []string
+		for _, r := range db.Records() {
+			names = append(names, r.FileName)
+		}
+		return strings.Join(names, " ")
+	}
+	since := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	until := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name         string
+		since, until time.Time
+		strict       bool
+		want         string
+		filtered     int
+	}{
+		{"range", since, until, false, "fileb.exe filec.exe filed.exe filee.exe fileg.exe fileh.exe", 2},
+		{"range, strict", since, until, true, "fileb.exe filec.exe filed.exe filee.exe", 4},
+		{"since only", until, time.Time{}, false, "filef.exe fileg.exe fileh.exe", 5},
+		{"until only", time.Time{}, since, true, "filea.exe", 7},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, err := LoadReader(context.Background(), strings.NewReader(writeCSV(t, records)), AddedBetween(tt.since, tt.until, tt.strict))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := names(db); got != tt.want || db.LoadStats().Filtered != tt.filtered {
+				t.Errorf("kept %q, %d filtered; want %q, %d", got, db.LoadStats().Filtered, tt.want, tt.filtered)
+			}
+		})
+	}
+}
@@ -0,0 +1,256 @@
+package celestlsh
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"io"
+	"os"
+
+	"github.com/Magonia-Research/CelesTLSH-CLI/pkg/ssdeep"
+	"github.com/glaslos/tlsh"
+)
+
+// MinDataLength is the shortest input hashed by default. TLSH digests of
+// shorter inputs are unreliable for comparison.
+const MinDataLength = 256
+
+// MinForcedDataLength is the shortest input hashed when Hasher.Force is set.
+const MinForcedDataLength = 50
+
+// Hasher calculates TLSH hashes. The zero value is ready to use.
+//
+// Inputs shorter than MinDataLength, or whose contents are too uniform to
+// fill at least half of the digest's buckets, are rejected with an
+// *InsufficientDataError, as the reference TLSH implementation does.
+type Hasher struct {
+	// Force hashes inputs of at least MinForcedDataLength bytes, rather than
+	// MinDataLength, like the reference implementation's force option.
+	Force bool
+}
+
+// Digest selects cryptographic digests to compute alongside a TLSH hash.
+// Values can be combined with |.
+type Digest uint
+
+const (
+	DigestMD5 Digest = 1 << iota
+	DigestSHA1
+	DigestSHA256
+
+	// DigestImphash selects the import hash of PE files; see Imphash.
+	DigestImphash
+
+	// DigestEntropy selects the Shannon entropy of the contents; see
+	// Entropy.
+	DigestEntropy
+
+	// DigestSSDeep selects the ssdeep fuzzy hash of the contents, which
+	// the database does not hold but some other sources do.
+	DigestSSDeep
+
+	// AllDigests selects every cryptographic digest of the contents.
+	AllDigests = DigestMD5 | DigestSHA1 | DigestSHA256
+)
+
+// Digests holds the TLSH hash of some data and any cryptographic digests
+// requested with it, hex encoded. Digests that were not requested are empty.
+type Digests struct {
+	TLSH   string `json:"tlsh,omitempty"`
+	MD5    string `json:"md5,omitempty"`
+	SHA1   string `json:"sha1,omitempty"`
+	SHA256 string `json:"sha256,omitempty"`
+
+	// Imphash is empty for files that are not PE files, that have no
+	// imports, or whose import table cannot be parsed.
+	Imphash string `json:"imphash,omitempty"`
+
+	// Entropy is the Shannon entropy of the data in bits per byte. Data
+	// that can be hashed always has some, so it is only 0 when not
+	// requested.
+	Entropy float64 `json:"entropy,omitempty"`
+
+	SSDeep string `json:"ssdeep,omitempty"`
+
+	// Sections holds the hashes of the executable sections of PE and ELF
+	// files, which the digest functions leave to HashSections.
+	Sections []SectionDigest `json:"sections,omitempty"`
+}
+
+// HashFile returns the TLSH hash of the file at path. The file is streamed
+// rather than read into memory, and reading stops early if ctx is done.
+func (h *Hasher) HashFile(ctx context.Context, path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", &FileError{Op: "reading file", Path: path, Err: err}
+	}
+	defer f.Close()
+
+	d, err := h.digest(ctx, path, f, 0)
+	return d.TLSH, err
+}
+
+// HashReader returns the TLSH hash of everything read from r.
+func (h *Hasher) HashReader(ctx context.Context, r io.Reader) (string, error) {
+	d, err := h.digest(ctx, "", r, 0)
+	return d.TLSH, err
+}
+
+// DigestFile returns the TLSH hash of the file at path together with the
+// digests selected by which, all computed in a single read of the file.
+func (h *Hasher) DigestFile(ctx context.Context, path string, which Digest) (Digests, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return Digests{}, &FileError{Op: "reading file", Path: path, Err: err}
+	}
+	defer f.Close()
+
+	return h.digest(ctx, path, f, which)
+}
+
+// DigestReader is like DigestFile for everything read from r. The imphash
+// can only be computed when r also implements io.ReaderAt.
+func (h *Hasher) DigestReader(ctx context.Context, r io.Reader, which Digest) (Digests, error) {
+	return h.digest(ctx, "", r, which)
+}
+
+// HashBytes returns the TLSH hash of data.
+func (h *Hasher) HashBytes(data []byte) (string, error) {
+	t, err := tlsh.HashReader(bytes.NewReader(data))
+	if err := h.validate(int64(len(data)), t, err); err != nil {
+		return "", err
+	}
+	return t.String(), nil
+}
+
+// validate turns the outcome of hashing size bytes into the error to return,
+// if any, rejecting inputs that are too short or too uniform.
+func (h *Hasher) validate(size int64, t *tlsh.TLSH, err error) error {
+	minSize := int64(MinDataLength)
+	if h.Force {
+		minSize = MinForcedDataLength
+	}
+	if size < minSize {
+		return &HashingError{Err: &InsufficientDataError{Size: size, MinSize: minSize}}
+	}
+	if err != nil {
+		return &HashingError{Err: err}
+	}
+	if lowComplexity(t) {
+		return &HashingError{Err: &InsufficientDataError{Size: size, MinSize: minSize, LowComplexity: true}}
+	}
+	return nil
+}
+
+// lowComplexity reports whether no more than half of the digest's 128
+// buckets were filled, which the reference implementation rejects. The
+// buckets themselves are not exposed, but when at least half of them are
+// empty the first quartile and the median are zero: the empty buckets, and
+// no others, are coded 0, none can be coded 1, and both quartile ratios in
+// the header are zero. If even the third quartile is zero, the ratios are
+// undefined, but no bucket can be coded 2 either.
+func lowComplexity(t *tlsh.TLSH) bool {
+	b := t.Binary()
+	var counts [4]int
+	for _, c := range b[3:] {
+		for j := 0; j < 4; j++ {
+			counts[(c>>(2*j))&3]++
+		}
+	}
+	return counts[0] >= 64 && counts[1] == 0 && (b[2] == 0 || counts[2] == 0)
+}
+
+func (h *Hasher) digest(ctx context.Context, path string, r io.Reader, which Digest) (Digests, error) {
+	var sums struct{ md5, sha1, sha256 hash.Hash }
+	var histogram *byteHistogram
+	var fuzzy *ssdeep.Hash
+	var writers []io.Writer
+	if which&DigestMD5 != 0 {
+		sums.md5 = md5.New()
+		writers = append(writers, sums.md5)
+	}
+	if which&DigestSHA1 != 0 {
+		sums.sha1 = sha1.New()
+		writers = append(writers, sums.sha1)
+	}
+	if which&DigestSHA256 != 0 {
+		sums.sha256 = sha256.New()
+		writers = append(writers, sums.sha256)
+	}
+	if which&DigestEntropy != 0 {
+		histogram = new(byteHistogram)
+		writers = append(writers, histogram)
+	}
+	if which&DigestSSDeep != 0 {
+		fuzzy = ssdeep.New()
+		writers = append(writers, fuzzy)
+	}
+
+	cr := &contextReader{ctx: ctx, r: r}
+	var in io.Reader = cr
+	if len(writers) > 0 {
+		in = io.TeeReader(cr, io.MultiWriter(writers...))
+	}
+
+	t, err := tlsh.HashReader(bufio.NewReader(in))
+	if cr.err != nil {
+		return Digests{}, &FileError{Op: "reading file", Path: path, Err: cr.err}
+	}
+	if err := h.validate(cr.n, t, err); err != nil {
+		return Digests{}, err
+	}
+
+	d := Digests{TLSH: t.String()}
+	if sums.md5 != nil {
+		d.MD5 = hex.EncodeToString(sums.md5.Sum(nil))
+	}
+	if sums.sha1 != nil {
+		d.SHA1 = hex.EncodeToString(sums.sha1.Sum(nil))
+	}
+	if sums.sha256 != nil {
+		d.SHA256 = hex.EncodeToString(sums.sha256.Sum(nil))
+	}
+	if histogram != nil {
+		d.Entropy = histogram.entropy()
+	}
+	if fuzzy != nil {
+		d.SSDeep = fuzzy.Sum()
+	}
+	if ra, ok := r.(io.ReaderAt); ok && which&DigestImphash != 0 {
+		// A malformed PE file still has a usable TLSH hash, so an
+		// unparseable import table only leaves the imphash empty.
+		d.Imphash, _ = Imphash(ra)
+	}
+
+	return d, nil
+}
+
+// contextReader stops reading once its context is done and remembers the
+// first error returned by the underlying reader, so that I/O failures can
+// be told apart from inputs the TLSH algorithm rejects. It also counts the
+// bytes read.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+	err error
+	n   int64
+}
+
+func (c *contextReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		c.err = err
+		return 0, err
+	}
+
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	if err != nil && err != io.EOF && c.err == nil {
+		c.err = err
+	}
+	return n, err
+}
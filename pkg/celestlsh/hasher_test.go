@@ -0,0 +1,78 @@
+package celestlsh
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// sumOf runs a coreutils checksum tool on path and returns the digest it
+// prints, skipping the test where the tool is missing.
+func sumOf(t *testing.T, tool, path string) string {
+	t.Helper()
+	if _, err := exec.LookPath(tool); err != nil {
+		t.Skipf("%s not found", tool)
+	}
+	out, err := exec.Command(tool, path).Output()
+	if err != nil {
+		t.Fatalf("%s: %v", tool, err)
+	}
+	sum, _, _ := strings.Cut(string(out), " ")
+	return sum
+}
+
+func TestDigestFile(t *testing.T) {
+	dir := t.TempDir()
+	for _, tt := range []struct {
+		name string
+		data []byte
+	}{
+		{"small", sample(1, 256)},
+		{"medium", sample(2, 64<<10)},
+		{"large", sample(3, 3<<20)},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, tt.name)
+			if err := os.WriteFile(path, tt.data, 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			var h Hasher
+			d, err := h.DigestFile(context.Background(), path, AllDigests)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, sum := range []struct{ tool, got string }{
+				{"md5sum", d.MD5},
+				{"sha1sum", d.SHA1},
+				{"sha256sum", d.SHA256},
+			} {
+				if want := sumOf(t, sum.tool, path); sum.got != want {
+					t.Errorf("%s = %s, want %s", sum.tool, sum.got, want)
+				}
+			}
+			if d.TLSH != mustHash(t, tt.data) {
+				t.Errorf("TLSH = %s, want that of HashBytes", d.TLSH)
+			}
+		})
+	}
+}
+
+func TestDigestFileSelection(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sample")
+	if err := os.WriteFile(path, sample(1, 4096), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var h Hasher
+	d, err := h.DigestFile(context.Background(), path, DigestSHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.SHA256 == "" || d.MD5 != "" || d.SHA1 != "" || d.Entropy != 0 {
+		t.Errorf("digests = %+v, want only the TLSH and SHA256", d)
+	}
+}
@@ -0,0 +1,266 @@
+package celestlsh
+
+import (
+	"bytes"
+	"crypto/md5"
+	"debug/pe"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+const (
+	// Bounds on the import table, so that corrupt or hostile files cannot
+	// make Imphash loop for long.
+	maxImportedLibraries = 4096
+	maxImportsPerLibrary = 65536
+
+	importDescriptorLen = 20
+)
+
+// Imphash returns the import hash of the PE file in r: the MD5 of its
+// imported functions in import-table order, written as lowercase
+// "library.function" and joined with commas, following the convention of
+// the pefile Python library. Imports by ordinal are resolved to names for
+// the few system libraries whose ordinals are stable, and written as
+// "ordN" otherwise.
+//
+// Files that are not PE files, and PE files without imports, have an empty
+// imphash and no error.
+func Imphash(r io.ReaderAt) (string, error) {
+	var magic [2]byte
+	if _, err := r.ReadAt(magic[:], 0); err != nil || string(magic[:]) != "MZ" {
+		return "", nil
+	}
+
+	f, err := pe.NewFile(r)
+	if err != nil {
+		return "", &ImphashError{Err: err}
+	}
+	defer f.Close()
+
+	imports, err := readImports(f)
+	if err != nil {
+		return "", &ImphashError{Err: err}
+	}
+	if len(imports) == 0 {
+		return "", nil
+	}
+
+	sum := md5.Sum([]byte(strings.Join(imports, ",")))
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// readImports walks the import directory of f and returns its entries in
+// the form hashed by Imphash.
+func readImports(f *pe.File) ([]string, error) {
+	var dir pe.DataDirectory
+	var thunkSize int
+	switch oh := f.OptionalHeader.(type) {
+	case *pe.OptionalHeader32:
+		if oh.NumberOfRvaAndSizes <= pe.IMAGE_DIRECTORY_ENTRY_IMPORT {
+			return nil, nil
+		}
+		dir, thunkSize = oh.DataDirectory[pe.IMAGE_DIRECTORY_ENTRY_IMPORT], 4
+	case *pe.OptionalHeader64:
+		if oh.NumberOfRvaAndSizes <= pe.IMAGE_DIRECTORY_ENTRY_IMPORT {
+			return nil, nil
+		}
+		dir, thunkSize = oh.DataDirectory[pe.IMAGE_DIRECTORY_ENTRY_IMPORT], 8
+	default:
+		return nil, nil
+	}
+	if dir.VirtualAddress == 0 {
+		return nil, nil
+	}
+
+	img := &peImage{file: f, data: make(map[*pe.Section][]byte)}
+	var imports []string
+
+	for i := 0; i < maxImportedLibraries; i++ {
+		desc, err := img.read(dir.VirtualAddress+uint32(i*importDescriptorLen), importDescriptorLen)
+		if err != nil {
+			return nil, err
+		}
+		if bytes.Equal(desc, make([]byte, importDescriptorLen)) {
+			return imports, nil
+		}
+
+		originalFirstThunk := binary.LittleEndian.Uint32(desc[0:])
+		nameRVA := binary.LittleEndian.Uint32(desc[12:])
+		firstThunk := binary.LittleEndian.Uint32(desc[16:])
+
+		dll, err := img.cstring(nameRVA)
+		if err != nil {
+			return nil, err
+		}
+		dll = strings.ToLower(dll)
+		library := dll
+		if idx := strings.LastIndex(dll, "."); idx >= 0 {
+			switch dll[idx+1:] {
+			case "dll", "ocx", "sys":
+				library = dll[:idx]
+			}
+		}
+
+		thunks := originalFirstThunk
+		if thunks == 0 {
+			thunks = firstThunk
+		}
+
+		for j := 0; j < maxImportsPerLibrary; j++ {
+			entry, err := img.read(thunks+uint32(j*thunkSize), thunkSize)
+			if err != nil {
+				return nil, err
+			}
+
+			var value, ordinalFlag uint64
+			if thunkSize == 4 {
+				value, ordinalFlag = uint64(binary.LittleEndian.Uint32(entry)), 1<<31
+			} else {
+				value, ordinalFlag = binary.LittleEndian.Uint64(entry), 1<<63
+			}
+			if value == 0 {
+				break
+			}
+
+			var function string
+			if value&ordinalFlag != 0 {
+				function = ordinalName(dll, uint16(value))
+			} else {
+				// Skip the two-byte hint before the name.
+				function, err = img.cstring(uint32(value&0x7fffffff) + 2)
+				if err != nil {
+					return nil, err
+				}
+			}
+			if function == "" {
+				continue
+			}
+
+			imports = append(imports, library+"."+strings.ToLower(function))
+		}
+	}
+
+	return nil, errors.New("too many imported libraries")
+}
+
+// peImage reads data from a PE file by relative virtual address.
+type peImage struct {
+	file *pe.File
+	data map[*pe.Section][]byte
+}
+
+// read returns n bytes at rva.
+func (img *peImage) read(rva uint32, n int) ([]byte, error) {
+	b, err := img.tail(rva)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) < n {
+		return nil, fmt.Errorf("truncated import data at RVA %#x", rva)
+	}
+	return b[:n], nil
+}
+
+// cstring returns the NUL-terminated string at rva.
+func (img *peImage) cstring(rva uint32) (string, error) {
+	b, err := img.tail(rva)
+	if err != nil {
+		return "", err
+	}
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	return string(b), nil
+}
+
+// tail returns the contents of the section holding rva, starting at rva.
+func (img *peImage) tail(rva uint32) ([]byte, error) {
+	for _, s := range img.file.Sections {
+		size := s.VirtualSize
+		if size == 0 {
+			size = s.Size
+		}
+		if rva < s.VirtualAddress || rva >= s.VirtualAddress+size {
+			continue
+		}
+
+		data, ok := img.data[s]
+		if !ok {
+			var err error
+			data, err = s.Data()
+			if err != nil {
+				return nil, err
+			}
+			img.data[s] = data
+		}
+
+		off := rva - s.VirtualAddress
+		if off >= uint32(len(data)) {
+			return nil, fmt.Errorf("RVA %#x is outside the data of section %s", rva, s.Name)
+		}
+		return data[off:], nil
+	}
+
+	return nil, fmt.Errorf("RVA %#x is not in any section", rva)
+}
+
+// ordinalName resolves an import by ordinal to its function name for the
+// libraries covered by ordinalNames, and to "ordN" otherwise.
+func ordinalName(dll string, ordinal uint16) string {
+	if names, ok := ordinalNames[dll]; ok {
+		if name, ok := names[ordinal]; ok {
+			return name
+		}
+	}
+	return fmt.Sprintf("ord%d", ordinal)
+}
+
+// winsockOrdinals are the Winsock 1.1 exports, whose ordinals are shared by
+// ws2_32.dll and wsock32.dll.
+var winsockOrdinals = map[uint16]string{
+	1: "accept", 2: "bind", 3: "closesocket", 4: "connect", 5: "getpeername",
+	6: "getsockname", 7: "getsockopt", 8: "htonl", 9: "htons", 10: "ioctlsocket",
+	11: "inet_addr", 12: "inet_ntoa", 13: "listen", 14: "ntohl", 15: "ntohs",
+	16: "recv", 17: "recvfrom", 18: "select", 19: "send", 20: "sendto",
+	21: "setsockopt", 22: "shutdown", 23: "socket",
+	51: "gethostbyaddr", 52: "gethostbyname", 53: "getprotobyname",
+	54: "getprotobynumber", 55: "getservbyname", 56: "getservbyport", 57: "gethostname",
+	101: "WSAAsyncSelect", 102: "WSAAsyncGetHostByAddr", 103: "WSAAsyncGetHostByName",
+	104: "WSAAsyncGetProtoByNumber", 105: "WSAAsyncGetProtoByName", 106: "WSAAsyncGetServByPort",
+	107: "WSAAsyncGetServByName", 108: "WSACancelAsyncRequest", 109: "WSASetBlockingHook",
+	110: "WSAUnhookBlockingHook", 111: "WSAGetLastError", 112: "WSASetLastError",
+	113: "WSACancelBlockingCall", 114: "WSAIsBlocking", 115: "WSAStartup", 116: "WSACleanup",
+	151: "__WSAFDIsSet", 500: "WEP",
+}
+
+// oleaut32Ordinals are the long-standing OLE Automation exports.
+var oleaut32Ordinals = map[uint16]string{
+	2: "SysAllocString", 3: "SysReAllocString", 4: "SysAllocStringLen",
+	5: "SysReAllocStringLen", 6: "SysFreeString", 7: "SysStringLen",
+	8: "VariantInit", 9: "VariantClear", 10: "VariantCopy", 11: "VariantCopyInd",
+	12: "VariantChangeType", 13: "VariantTimeToDosDateTime", 14: "DosDateTimeToVariantTime",
+	15: "SafeArrayCreate", 16: "SafeArrayDestroy", 17: "SafeArrayGetDim",
+	18: "SafeArrayGetElemsize", 19: "SafeArrayGetUBound", 20: "SafeArrayGetLBound",
+	21: "SafeArrayLock", 22: "SafeArrayUnlock", 23: "SafeArrayAccessData",
+	24: "SafeArrayUnaccessData", 25: "SafeArrayGetElement", 26: "SafeArrayPutElement",
+	27: "SafeArrayCopy", 28: "DispGetParam", 29: "DispGetIDsOfNames", 30: "DispInvoke",
+	31: "CreateDispTypeInfo", 32: "CreateStdDispatch", 33: "RegisterActiveObject",
+	34: "RevokeActiveObject", 35: "GetActiveObject", 36: "SafeArrayAllocDescriptor",
+	37: "SafeArrayAllocData", 38: "SafeArrayDestroyDescriptor", 39: "SafeArrayDestroyData",
+	40:  "SafeArrayRedim",
+	147: "VariantChangeTypeEx", 148: "SafeArrayPtrOfIndex", 149: "SysStringByteLen",
+	150: "SysAllocStringByteLen", 161: "LoadTypeLib", 162: "LoadRegTypeLib",
+	163: "RegisterTypeLib", 183: "LoadTypeLibEx",
+}
+
+// ordinalNames maps lowercase library names to their ordinal exports.
+var ordinalNames = map[string]map[uint16]string{
+	"ws2_32.dll":   winsockOrdinals,
+	"wsock32.dll":  winsockOrdinals,
+	"oleaut32.dll": oleaut32Ordinals,
+}
@@ -0,0 +1,73 @@
+package celestlsh
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// The PE files under testdata are written by testdata/genpe.go, and the
+// imphash of each is that of pefile's get_imphash, as printed by
+// testdata/imphash.py.
+func TestImphash(t *testing.T) {
+	tests := []struct {
+		file string
+		want string
+	}{
+		// KERNEL32.dll by name and by ordinal 5, which has no known name;
+		// WS2_32.dll and WSOCK32.DLL, which share the Winsock ordinals, and
+		// OLEAUT32.dll by ordinal:
+		//
+		// kernel32.createfilea,kernel32.readfile,kernel32.ord5,
+		// ws2_32.wsastartup,ws2_32.socket,ws2_32.connect,ws2_32.closesocket,
+		// wsock32.accept,wsock32.htons,wsock32.__wsafdisset,wsock32.wep,
+		// oleaut32.sysallocstring,oleaut32.sysfreestring,
+		// oleaut32.variantinit,oleaut32.variantclear
+		{"imports32.exe", "cf78e464f5009a4a79b0fbab8fd16e25"},
+		// PE32+. Only .dll, .ocx and .sys are dropped from library names,
+		// and ordinals are only looked up for ws2_32 with its extension:
+		//
+		// advapi32.regopenkeyexw,mscomctl.dllgetclassobject,
+		// fltmgr.fltregisterfilter,ntoskrnl.exe.iocreatedevice,
+		// msvcrt.dll.bak.malloc,ws2_32.ord23,oleaut32.ord9999,ws2_32.socket
+		{"imports64.exe", "90e25bcde260ea27be34307d955c26b1"},
+		{"noimports.exe", ""},
+	}
+	for _, tt := range tests {
+		data, err := os.ReadFile(filepath.Join("testdata", tt.file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := Imphash(bytes.NewReader(data))
+		if err != nil {
+			t.Errorf("%s: %v", tt.file, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s: Imphash = %q, want %q", tt.file, got, tt.want)
+		}
+	}
+}
+
+func TestImphashInvalid(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("testdata", "imports32.exe"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Files that are not PE files have no imphash.
+	for _, other := range [][]byte{nil, []byte("#!/bin/sh\n"), []byte("\x7fELF")} {
+		if got, err := Imphash(bytes.NewReader(other)); got != "" || err != nil {
+			t.Errorf("Imphash(%q) = %q, %v; want none", other, got, err)
+		}
+	}
+
+	// A PE file cut short inside its import table is an error.
+	_, err = Imphash(bytes.NewReader(data[:0x220]))
+	var ie *ImphashError
+	if !errors.As(err, &ie) {
+		t.Errorf("truncated file: error %v, want an *ImphashError", err)
+	}
+}
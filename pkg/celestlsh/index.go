@@ -0,0 +1,201 @@
+package celestlsh
+
+import (
+	"github.com/glaslos/tlsh"
+)
+
+// The TLSH distance is not a metric: the body term scores two bit pairs
+// that differ by 3 as 6, which breaks the triangle inequality. The index
+// therefore works on the L1 distance between the bucket codes, which is a
+// metric and never exceeds the TLSH distance. A range query on it yields a
+// superset of the records within a TLSH bound, which is then filtered with
+// the exact distance.
+
+// bodyLen is the size in bytes of the bucket code of a TLSH digest.
+const bodyLen = 32
+
+type body [bodyLen]byte
+
+// bodyDistanceTable holds the L1 distance between the four 2-bit bucket
+// values packed in each pair of bytes.
+var bodyDistanceTable = func() (t [256][256]uint8) {
+	for x := 0; x < 256; x++ {
+		for y := 0; y < 256; y++ {
+			var d uint8
+			for shift := 0; shift < 8; shift += 2 {
+				a, b := (x>>shift)&3, (y>>shift)&3
+				if a > b {
+					d += uint8(a - b)
+				} else {
+					d += uint8(b - a)
+				}
+			}
+			t[x][y] = d
+		}
+	}
+	return t
+}()
+
+func bodyDistance(a, b *body) int {
+	d := 0
+	for i := range a {
+		d += int(bodyDistanceTable[a[i]][b[i]])
+	}
+	return d
+}
+
+// bodyOf returns the bucket code of a parsed digest.
+func bodyOf(digest *tlsh.TLSH) (b body, ok bool) {
+	bin := digest.Binary()
+	if len(bin) != 3+bodyLen {
+		return b, false
+	}
+	copy(b[:], bin[3:])
+	return b, true
+}
+
+// vpIndex is a vantage-point tree over the bucket codes of database
+// entries. Entries whose code cannot be extracted are kept in unindexed and
+// returned by every query.
+type vpIndex struct {
+	bodies    []body
+	root      *vpNode
+	unindexed []int
+}
+
+// vpNode splits the entries below it by their distance to the vantage
+// point: those at most radius away go inside, the rest outside.
+type vpNode struct {
+	point   int
+	radius  int
+	inside  *vpNode
+	outside *vpNode
+}
+
+func newVPIndex(entries []entry) *vpIndex {
+	idx := &vpIndex{bodies: make([]body, len(entries))}
+
+	points := make([]int, 0, len(entries))
+	for i, e := range entries {
+		b, ok := bodyOf(e.digest)
+		if !ok {
+			idx.unindexed = append(idx.unindexed, i)
+			continue
+		}
+		idx.bodies[i] = b
+		points = append(points, i)
+	}
+
+	idx.root = idx.build(points, make([]int, len(points)))
+	return idx
+}
+
+// build makes a subtree of points, using scratch to hold their distances
+// to the vantage point. The first point is taken as the vantage point;
+// database order is arbitrary enough for that to balance reasonably.
+func (idx *vpIndex) build(points, scratch []int) *vpNode {
+	if len(points) == 0 {
+		return nil
+	}
+
+	node := &vpNode{point: points[0]}
+	rest := points[1:]
+	if len(rest) == 0 {
+		return node
+	}
+
+	vp := &idx.bodies[node.point]
+	dist := scratch[:len(rest)]
+	for i, p := range rest {
+		dist[i] = bodyDistance(vp, &idx.bodies[p])
+	}
+
+	// Partition around the median distance.
+	mid := len(rest) / 2
+	selectNth(rest, dist, mid)
+	node.radius = dist[mid]
+
+	// Points beyond mid may tie with the median; move them inside so that
+	// outside holds exactly the points further than radius.
+	split := mid + 1
+	for i := split; i < len(rest); i++ {
+		if dist[i] <= node.radius {
+			rest[i], rest[split] = rest[split], rest[i]
+			dist[i], dist[split] = dist[split], dist[i]
+			split++
+		}
+	}
+
+	node.inside = idx.build(rest[:split], scratch)
+	node.outside = idx.build(rest[split:], scratch)
+	return node
+}
+
+// selectNth reorders points and dist together so that dist[n] holds the
+// value it would have if sorted, with no larger value before it and no
+// smaller value after it.
+func selectNth(points, dist []int, n int) {
+	lo, hi := 0, len(dist)-1
+	for lo < hi {
+		pivot := dist[(lo+hi)/2]
+		i, j := lo, hi
+		for i <= j {
+			for dist[i] < pivot {
+				i++
+			}
+			for dist[j] > pivot {
+				j--
+			}
+			if i <= j {
+				dist[i], dist[j] = dist[j], dist[i]
+				points[i], points[j] = points[j], points[i]
+				i++
+				j--
+			}
+		}
+		switch {
+		case n <= j:
+			hi = j
+		case n >= i:
+			lo = i
+		default:
+			return
+		}
+	}
+}
+
+// candidates returns the entries whose TLSH distance to the digest may be
+// at most maxDistance, in no particular order.
+func (idx *vpIndex) candidates(digest *tlsh.TLSH, maxDistance int) []int {
+	q, ok := bodyOf(digest)
+	if !ok {
+		all := make([]int, len(idx.bodies))
+		for i := range all {
+			all[i] = i
+		}
+		return all
+	}
+
+	found := append([]int(nil), idx.unindexed...)
+	stack := []*vpNode{idx.root}
+	for len(stack) > 0 {
+		node := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if node == nil {
+			continue
+		}
+
+		d := bodyDistance(&q, &idx.bodies[node.point])
+		if d <= maxDistance {
+			found = append(found, node.point)
+		}
+		if d-maxDistance <= node.radius {
+			stack = append(stack, node.inside)
+		}
+		if d+maxDistance > node.radius {
+			stack = append(stack, node.outside)
+		}
+	}
+
+	return found
+}
@@ -0,0 +1,128 @@
+package celestlsh
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"reflect"
+	"testing"
+)
+
+const hexDigits = "0123456789ABCDEF"
+
+// randomDatabase returns n records whose hashes form clusters of near
+// neighbours around random centres, as builds of one tool do, and queries
+// near some of those clusters and far from all of them.
+func randomDatabase(t testing.TB, seed uint64, n int) (records []HashRecord, queries []string) {
+	t.Helper()
+	r := rand.New(rand.NewPCG(seed, seed))
+	randomHash := func() []byte {
+		h := make([]byte, 70)
+		for i := range h {
+			h[i] = hexDigits[r.IntN(16)]
+		}
+		return h
+	}
+	// mutate changes k digits of the header or body of a copy of h.
+	mutate := func(h []byte, k int) string {
+		out := append([]byte(nil), h...)
+		for range k {
+			out[r.IntN(len(out))] = hexDigits[r.IntN(16)]
+		}
+		return string(out)
+	}
+
+	var centres [][]byte
+	for len(records) < n {
+		if len(records)%20 == 0 {
+			centres = append(centres, randomHash())
+		}
+		centre := centres[len(centres)-1]
+		records = append(records, HashRecord{
+			RepoName: fmt.Sprintf("repo%d", len(centres)),
+			FileName: fmt.Sprintf("file%d.exe", len(records)),
+			TLSHHash: mutate(centre, r.IntN(12)),
+		})
+	}
+	for i := 0; i < 10; i++ {
+		queries = append(queries, mutate(centres[r.IntN(len(centres))], r.IntN(8)))
+		queries = append(queries, string(randomHash()))
+	}
+	return records, queries
+}
+
+func TestIndexMatchesBruteForce(t *testing.T) {
+	for _, seed := range []uint64{1, 2, 3} {
+		records, queries := randomDatabase(t, seed, 2000)
+		db, err := NewDatabase(records)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, query := range queries {
+			for _, maxDistance := range []int{0, 10, 30, 70, 150, 400} {
+				got, err := db.Nearest(context.Background(), query, maxDistance, -1)
+				if err != nil {
+					t.Fatal(err)
+				}
+				want := bruteForce(t, query, records, maxDistance, -1)
+				if len(got) != len(want) || len(got) > 0 && !reflect.DeepEqual(got, want) {
+					t.Fatalf("seed %d, %s within %d: index found %d records, brute force %d", seed, query, maxDistance, len(got), len(want))
+				}
+			}
+		}
+	}
+}
+
+// The index relies on the distance between bucket codes never exceeding
+// the TLSH distance.
+func TestBodyDistanceIsLowerBound(t *testing.T) {
+	records, queries := randomDatabase(t, 4, 500)
+	for _, query := range queries {
+		q, err := parseHash("query", query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		qb, _ := bodyOf(q)
+		for _, r := range records {
+			d, err := parseHash("record", r.TLSHHash)
+			if err != nil {
+				t.Fatal(err)
+			}
+			db, _ := bodyOf(d)
+			if bd, td := bodyDistance(&qb, &db), q.Diff(d); bd > td {
+				t.Fatalf("body distance %d exceeds TLSH distance %d for %s and %s", bd, td, query, r.TLSHHash)
+			}
+		}
+	}
+}
+
+func BenchmarkNearest(b *testing.B) {
+	records, queries := randomDatabase(b, 5, 50000)
+	db, err := NewDatabase(records)
+	if err != nil {
+		b.Fatal(err)
+	}
+	ctx := context.Background()
+
+	for _, bench := range []struct {
+		name        string
+		maxDistance int
+	}{
+		{"linear", -1},
+		{"indexed within 30", 30},
+		{"indexed within 70", 70},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			// The index is built on first use, outside the timing.
+			if _, err := db.Nearest(ctx, queries[0], bench.maxDistance, 1); err != nil {
+				b.Fatal(err)
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := db.Nearest(ctx, queries[i%len(queries)], bench.maxDistance, 1); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
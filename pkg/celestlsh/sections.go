@@ -0,0 +1,114 @@
+package celestlsh
+
+import (
+	"context"
+	"debug/elf"
+	"debug/pe"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// maxHashedSections bounds the executable sections hashed in one file, so
+// that a corrupt or hostile section table cannot make HashSections read the
+// same data over and over.
+const maxHashedSections = 64
+
+// SectionDigest is the TLSH hash of an executable section of a PE or ELF
+// file. Unlike the hash of the whole file, it is unaffected by data
+// appended to the file or by edits to its resources.
+type SectionDigest struct {
+	Name string `json:"name"`
+
+	// Offset and Size locate the section's contents in the file.
+	Offset int64 `json:"offset"`
+	Size   int64 `json:"size"`
+
+	TLSH string `json:"tlsh"`
+}
+
+// HashSections returns the TLSH hashes of the executable sections of the
+// PE or ELF file in r, in section table order. Sections too short or too
+// uniform to hash are left out.
+//
+// Files that are neither PE nor ELF files have no sections and no error.
+// Files that start like one but whose section table cannot be parsed, or
+// whose sections extend past the end of the file, give a *SectionError.
+func (h *Hasher) HashSections(ctx context.Context, r io.ReaderAt) ([]SectionDigest, error) {
+	var magic [4]byte
+	n, _ := r.ReadAt(magic[:], 0)
+
+	var sections []SectionDigest
+	switch {
+	case n >= 2 && string(magic[:2]) == "MZ":
+		f, err := pe.NewFile(r)
+		if err != nil {
+			return nil, &SectionError{Err: err}
+		}
+		defer f.Close()
+		for _, s := range f.Sections {
+			if s.Characteristics&(pe.IMAGE_SCN_CNT_CODE|pe.IMAGE_SCN_MEM_EXECUTE) != 0 && s.Size > 0 {
+				sections = append(sections, SectionDigest{Name: s.Name, Offset: int64(s.Offset), Size: int64(s.Size)})
+			}
+		}
+	case n == 4 && string(magic[:]) == elf.ELFMAG:
+		f, err := elf.NewFile(r)
+		if err != nil {
+			return nil, &SectionError{Err: err}
+		}
+		defer f.Close()
+		for _, s := range f.Sections {
+			if s.Flags&elf.SHF_EXECINSTR != 0 && s.Type != elf.SHT_NOBITS && s.FileSize > 0 {
+				sections = append(sections, SectionDigest{Name: s.Name, Offset: int64(s.Offset), Size: int64(s.FileSize)})
+			}
+		}
+	default:
+		return nil, nil
+	}
+	if len(sections) > maxHashedSections {
+		sections = sections[:maxHashedSections]
+	}
+
+	hashed := sections[:0]
+	for _, s := range sections {
+		if s.Offset < 0 || s.Size < 0 {
+			return nil, &SectionError{Err: fmt.Errorf("section %q has an invalid location", s.Name)}
+		}
+		d, err := h.digest(ctx, "", &fullReader{r: io.NewSectionReader(r, s.Offset, s.Size), n: s.Size}, 0)
+		if errors.Is(err, ErrInsufficientData) {
+			continue
+		}
+		if err != nil {
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return nil, ctxErr
+			}
+			return nil, &SectionError{Err: fmt.Errorf("section %q: %w", s.Name, err)}
+		}
+		s.TLSH = d.TLSH
+		hashed = append(hashed, s)
+	}
+	return hashed, nil
+}
+
+// fullReader reads n bytes from r, failing with io.ErrUnexpectedEOF rather
+// than ending early if r holds fewer, as a truncated file's last section
+// does.
+type fullReader struct {
+	r io.Reader
+	n int64
+}
+
+func (f *fullReader) Read(p []byte) (int, error) {
+	if f.n <= 0 {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > f.n {
+		p = p[:f.n]
+	}
+	n, err := f.r.Read(p)
+	f.n -= int64(n)
+	if err == io.EOF && f.n > 0 {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
@@ -0,0 +1,389 @@
+package celestlsh
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultShardConcurrency is how many shards DownloadShards fetches at
+// once when Downloader.Concurrency is 0.
+const DefaultShardConcurrency = 4
+
+// shardAttempts is how many times DownloadShards fetches a shard before
+// giving up on it.
+const shardAttempts = 3
+
+// shardRetryDelay is the wait before failed shards are fetched again,
+// doubled for each later attempt. It is a variable so that tests can
+// shorten it.
+var shardRetryDelay = time.Second
+
+// maxManifestSize bounds a shard manifest, which lists a few URLs.
+const maxManifestSize = 1 << 20
+
+// ShardManifest lists the shards a database is published in. Joined in
+// order, each after the first without its CSV header, they make up the
+// database. The manifest is JSON, an object with a shards array or the
+// array alone:
+//
+//	{"shards": [
+//	  {"url": "c2.csv", "size": 1048576, "sha256": "9f86d081884c7d65..."},
+//	  {"url": "https://mirror.example/loaders.csv", "size": 52133, "sha256": "..."}
+//	]}
+type ShardManifest struct {
+	Shards []Shard `json:"shards"`
+}
+
+// Shard is one part of a sharded database. URL may be relative to the
+// manifest's. SHA256 is required; Size is checked unless it is 0.
+type Shard struct {
+	URL    string `json:"url"`
+	Size   int64  `json:"size,omitempty"`
+	SHA256 string `json:"sha256"`
+}
+
+// ParseShardManifest parses a manifest, resolving the URLs of its shards
+// against base, the manifest's own URL.
+func ParseShardManifest(data []byte, base string) (*ShardManifest, error) {
+	var m ShardManifest
+	data = bytes.TrimSpace(data)
+	var err error
+	if bytes.HasPrefix(data, []byte("[")) {
+		err = json.Unmarshal(data, &m.Shards)
+	} else {
+		err = json.Unmarshal(data, &m)
+	}
+	if err != nil {
+		return nil, &ManifestError{URL: base, Err: err}
+	}
+	if len(m.Shards) == 0 {
+		return nil, &ManifestError{URL: base, Err: errors.New("no shards listed")}
+	}
+
+	baseURL, err := url.Parse(base)
+	if err != nil {
+		return nil, &ManifestError{URL: base, Err: err}
+	}
+	for i, s := range m.Shards {
+		if s.URL == "" {
+			return nil, &ManifestError{URL: base, Err: fmt.Errorf("shard %d has no url", i+1)}
+		}
+		ref, err := url.Parse(s.URL)
+		if err != nil {
+			return nil, &ManifestError{URL: base, Err: fmt.Errorf("shard %d: %v", i+1, err)}
+		}
+		m.Shards[i].URL = baseURL.ResolveReference(ref).String()
+
+		sum, err := hex.DecodeString(s.SHA256)
+		if err != nil || len(sum) != sha256.Size {
+			return nil, &ManifestError{URL: base, Err: fmt.Errorf("shard %s has no valid sha256 checksum", s.URL)}
+		}
+		m.Shards[i].SHA256 = strings.ToLower(s.SHA256)
+		if s.Size < 0 {
+			return nil, &ManifestError{URL: base, Err: fmt.Errorf("shard %s has a negative size", s.URL)}
+		}
+	}
+	return &m, nil
+}
+
+// DownloadShards saves to outputPath the database whose ShardManifest is
+// at URL. Shards are fetched Concurrency at a time into temporary files
+// beside outputPath and checked against the manifest. Shards that fail are
+// fetched again, up to three times in all, while those already fetched
+// are kept. The shards are then joined into one file, with the header of
+// the first, which is passed to Verify and renamed into place, so that as
+// with Download an existing database is left intact if anything fails.
+//
+// Header is only sent to shards on the manifest's host, so that credentials
+// for a mirror do not leak to the other sites a manifest may list.
+//
+// Progress, if set, wraps the body of each shard, from several goroutines
+// at once, with total the combined size of the shards in the manifest, or
+// -1 if any size is missing. A shard fetched again is received again.
+func (d *Downloader) DownloadShards(ctx context.Context, outputPath string) (err error) {
+	manifest, err := d.fetchManifest(ctx)
+	if err != nil {
+		return err
+	}
+
+	dirPath := filepath.Dir(outputPath)
+	if dirPath != "." {
+		if err := os.MkdirAll(dirPath, 0755); err != nil {
+			return &FileError{Op: "creating directory", Path: dirPath, Err: err}
+		}
+	}
+
+	var total int64
+	for _, s := range manifest.Shards {
+		if s.Size == 0 {
+			total = -1
+			break
+		}
+		total += s.Size
+	}
+
+	paths := make([]string, len(manifest.Shards))
+	defer func() {
+		for _, path := range paths {
+			if path != "" {
+				os.Remove(path)
+			}
+		}
+	}()
+
+	pending := make([]int, len(manifest.Shards))
+	for i := range pending {
+		pending[i] = i
+	}
+	delay := shardRetryDelay
+	for attempt := 1; ; attempt++ {
+		failed, firstErr := d.fetchShards(ctx, outputPath, manifest.Shards, pending, paths, total)
+		if len(failed) == 0 {
+			break
+		}
+		if attempt == shardAttempts || ctx.Err() != nil {
+			if len(failed) > 1 {
+				return fmt.Errorf("%d shards failed, first: %w", len(failed), firstErr)
+			}
+			return firstErr
+		}
+		select {
+		case <-ctx.Done():
+			return &RequestError{URL: d.URL, Err: ctx.Err()}
+		case <-time.After(delay):
+		}
+		delay *= 2
+		pending = failed
+	}
+
+	out, err := os.CreateTemp(dirPath, "."+filepath.Base(outputPath)+".*.tmp")
+	if err != nil {
+		return &FileError{Op: "creating output file", Path: outputPath, Err: err}
+	}
+	defer func() {
+		if err != nil {
+			out.Close()
+			os.Remove(out.Name())
+		}
+	}()
+
+	if err = joinShards(out, manifest.Shards, paths); err != nil {
+		return err
+	}
+	if err = out.Close(); err != nil {
+		return &FileError{Op: "saving data to file", Path: outputPath, Err: err}
+	}
+	if err = os.Chmod(out.Name(), 0644); err != nil {
+		return &FileError{Op: "saving data to file", Path: outputPath, Err: err}
+	}
+	if d.Verify != nil {
+		if err = d.Verify(out.Name()); err != nil {
+			return err
+		}
+	}
+	if err = os.Rename(out.Name(), outputPath); err != nil {
+		return &FileError{Op: "saving data to file", Path: outputPath, Err: err}
+	}
+	return nil
+}
+
+// fetchManifest downloads and parses the manifest at d.URL.
+func (d *Downloader) fetchManifest(ctx context.Context) (*ShardManifest, error) {
+	resp, err := d.get(ctx, d.URL, d.Header)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxManifestSize+1))
+	if err != nil {
+		return nil, &RequestError{URL: d.URL, Err: err}
+	}
+	if len(data) > maxManifestSize {
+		return nil, &ManifestError{URL: d.URL, Err: fmt.Errorf("larger than %d bytes", maxManifestSize)}
+	}
+	return ParseShardManifest(data, d.URL)
+}
+
+// fetchShards fetches the pending shards, recording the file each is
+// saved to in paths, and returns those that failed with the error of the
+// first of them.
+func (d *Downloader) fetchShards(ctx context.Context, outputPath string, shards []Shard, pending []int, paths []string, total int64) ([]int, error) {
+	workers := d.Concurrency
+	if workers <= 0 {
+		workers = DefaultShardConcurrency
+	}
+	workers = min(workers, len(pending))
+
+	errs := make([]error, len(shards))
+	work := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range work {
+				paths[i], errs[i] = d.fetchShard(ctx, outputPath, shards[i], total)
+			}
+		}()
+	}
+	for _, i := range pending {
+		work <- i
+	}
+	close(work)
+	wg.Wait()
+
+	var failed []int
+	var firstErr error
+	for _, i := range pending {
+		if errs[i] != nil {
+			failed = append(failed, i)
+			if firstErr == nil {
+				firstErr = errs[i]
+			}
+		}
+	}
+	return failed, firstErr
+}
+
+// fetchShard saves shard to a temporary file beside outputPath and checks
+// its size and checksum, returning the file's path.
+func (d *Downloader) fetchShard(ctx context.Context, outputPath string, shard Shard, total int64) (path string, err error) {
+	// Credentials for the manifest are not sent to shards on other hosts.
+	var header http.Header
+	if sameHost(d.URL, shard.URL) {
+		header = d.Header
+	}
+	resp, err := d.get(ctx, shard.URL, header)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	out, err := os.CreateTemp(filepath.Dir(outputPath), "."+filepath.Base(outputPath)+".shard*.tmp")
+	if err != nil {
+		return "", &FileError{Op: "creating shard file", Path: outputPath, Err: err}
+	}
+	defer func() {
+		if err != nil {
+			out.Close()
+			os.Remove(out.Name())
+		}
+	}()
+
+	var body io.Reader = resp.Body
+	if d.Progress != nil {
+		body = d.Progress(body, total)
+	}
+	sum := sha256.New()
+	n, err := io.Copy(io.MultiWriter(out, sum), body)
+	if err != nil {
+		return "", &RequestError{URL: shard.URL, Err: err}
+	}
+	if err = out.Close(); err != nil {
+		return "", &FileError{Op: "saving shard to file", Path: out.Name(), Err: err}
+	}
+
+	if shard.Size != 0 && n != shard.Size {
+		return "", &ShardError{URL: shard.URL, Err: fmt.Errorf("received %d bytes, manifest says %d", n, shard.Size)}
+	}
+	if got := hex.EncodeToString(sum.Sum(nil)); got != shard.SHA256 {
+		return "", &ShardError{URL: shard.URL, Err: fmt.Errorf("sha256 checksum %s does not match the manifest's %s", got, shard.SHA256)}
+	}
+	return out.Name(), nil
+}
+
+// sameHost reports whether URLs a and b have the same scheme and host.
+func sameHost(a, b string) bool {
+	ua, err := url.Parse(a)
+	if err != nil {
+		return false
+	}
+	ub, err := url.Parse(b)
+	if err != nil {
+		return false
+	}
+	return ua.Scheme == ub.Scheme && strings.EqualFold(ua.Host, ub.Host)
+}
+
+// joinShards writes the shards saved at paths to out, the first in full
+// and the others without their header, which must match the first's.
+func joinShards(out *os.File, shards []Shard, paths []string) error {
+	w := bufio.NewWriter(out)
+	var header string
+	for i, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			return &FileError{Op: "reading shard file", Path: path, Err: err}
+		}
+		err = joinShard(w, f, i == 0, &header)
+		f.Close()
+		if err != nil {
+			var shardErr *ShardError
+			if errors.As(err, &shardErr) {
+				shardErr.URL = shards[i].URL
+			}
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return &FileError{Op: "saving data to file", Path: out.Name(), Err: err}
+	}
+	return nil
+}
+
+// joinShard copies one shard to w, recording its header in header if it
+// is the first and otherwise checking and dropping it. Every shard ends
+// with a newline in the output, so that the next one starts on a row of
+// its own.
+func joinShard(w *bufio.Writer, r io.Reader, first bool, header *string) error {
+	br := bufio.NewReader(r)
+	line, err := br.ReadString('\n')
+	if err != nil && err != io.EOF {
+		return &FileError{Op: "reading shard file", Err: err}
+	}
+	if line == "" {
+		return &ShardError{Err: errors.New("empty shard, without a CSV header")}
+	}
+	got := strings.TrimRight(strings.TrimPrefix(line, "\ufeff"), "\r\n")
+	if first {
+		*header = got
+		w.WriteString(strings.TrimSuffix(line, "\n") + "\n")
+	} else if got != *header {
+		return &ShardError{Err: fmt.Errorf("CSV header %q differs from the first shard's %q", got, *header)}
+	}
+
+	var last byte = '\n'
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := br.Read(buf)
+		if n > 0 {
+			w.Write(buf[:n])
+			last = buf[n-1]
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return &FileError{Op: "reading shard file", Err: err}
+		}
+	}
+	if last != '\n' {
+		w.WriteByte('\n')
+	}
+	return nil
+}
@@ -0,0 +1,312 @@
+package celestlsh
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func sha256Hex(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestParseShardManifest(t *testing.T) {
+	sum := sha256Hex("x")
+	for _, data := range []string{
+		fmt.Sprintf(`{"shards":[{"url":"a.csv","size":1,"sha256":%q},{"url":"https://mirror.example/b.csv","sha256":%q}]}`, sum, strings.ToUpper(sum)),
+		fmt.Sprintf(` [{"url":"a.csv","size":1,"sha256":%q},{"url":"https://mirror.example/b.csv","sha256":%q}]`, sum, sum),
+	} {
+		m, err := ParseShardManifest([]byte(data), "https://example.com/db/manifest.json")
+		if err != nil {
+			t.Fatalf("ParseShardManifest(%s): %v", data, err)
+		}
+		want := []Shard{
+			{URL: "https://example.com/db/a.csv", Size: 1, SHA256: sum},
+			{URL: "https://mirror.example/b.csv", SHA256: sum},
+		}
+		if len(m.Shards) != len(want) || m.Shards[0] != want[0] || m.Shards[1] != want[1] {
+			t.Errorf("ParseShardManifest(%s) = %+v, want %+v", data, m.Shards, want)
+		}
+	}
+
+	for _, tt := range []struct{ data, want string }{
+		{`not json`, "invalid character"},
+		{`{"shards":[]}`, "no shards listed"},
+		{`[]`, "no shards listed"},
+		{fmt.Sprintf(`[{"sha256":%q}]`, sum), "shard 1 has no url"},
+		{`[{"url":"a.csv","sha256":"abc"}]`, "shard a.csv has no valid sha256 checksum"},
+		{`[{"url":"a.csv"}]`, "shard a.csv has no valid sha256 checksum"},
+		{fmt.Sprintf(`[{"url":"a.csv","size":-1,"sha256":%q}]`, sum), "shard a.csv has a negative size"},
+	} {
+		_, err := ParseShardManifest([]byte(tt.data), "https://example.com/manifest.json")
+		var manifestErr *ManifestError
+		if !errors.As(err, &manifestErr) || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("ParseShardManifest(%s) = %v, want a ManifestError saying %q", tt.data, err, tt.want)
+		}
+	}
+}
+
+// shardServer serves a manifest at /manifest.json listing shards, and the
+// shards themselves at their names, counting the requests for each path.
+// fail, if set, is called before a shard is served and can answer it
+// instead.
+type shardServer struct {
+	*httptest.Server
+	mu       sync.Mutex
+	requests map[string]int
+	headers  map[string]string
+	fail     func(w http.ResponseWriter, name string, attempt int) bool
+}
+
+func newShardServer(t *testing.T, shards map[string]string, order []string, extra ...Shard) *shardServer {
+	t.Helper()
+	s := &shardServer{requests: map[string]int{}, headers: map[string]string{}}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(r.URL.Path, "/")
+		s.mu.Lock()
+		s.requests[name]++
+		attempt := s.requests[name]
+		s.headers[name] = r.Header.Get("Authorization")
+		fail := s.fail
+		s.mu.Unlock()
+
+		if name == "manifest.json" {
+			var entries []string
+			for _, name := range order {
+				entries = append(entries, fmt.Sprintf(`{"url":%q,"size":%d,"sha256":%q}`, name, len(shards[name]), sha256Hex(shards[name])))
+			}
+			for _, e := range extra {
+				entries = append(entries, fmt.Sprintf(`{"url":%q,"size":%d,"sha256":%q}`, e.URL, e.Size, e.SHA256))
+			}
+			fmt.Fprintf(w, `{"shards":[%s]}`, strings.Join(entries, ","))
+			return
+		}
+		if fail != nil && fail(w, name, attempt) {
+			return
+		}
+		data, ok := shards[name]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		io.WriteString(w, data)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *shardServer) count(name string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests[name]
+}
+
+func shortenShardRetries(t *testing.T) {
+	old := shardRetryDelay
+	shardRetryDelay = time.Millisecond
+	t.Cleanup(func() { shardRetryDelay = old })
+}
+
+var testShards = map[string]string{
+	"c2.csv":      testDatabase + "C2,a.exe,1,T1AA,ab,,2024-01-01,\n",
+	"loaders.csv": "\ufeff" + strings.ReplaceAll(testDatabase, "\n", "\r\n") + "Loader,b.exe,1,T1BB,cd,,2024-01-02,\r\n",
+	// Without a final newline, the next shard must still start a row.
+	"rats.csv":  testDatabase + "Rat,c.exe,1,T1CC,ef,,2024-01-03,",
+	"empty.csv": testDatabase,
+}
+
+func TestDownloadShards(t *testing.T) {
+	order := []string{"c2.csv", "empty.csv", "rats.csv", "loaders.csv"}
+	srv := newShardServer(t, testShards, order)
+
+	dir := t.TempDir()
+	out := filepath.Join(dir, "db.csv")
+	d := newTestDownloader(srv.URL + "/manifest.json")
+	d.Concurrency = 2
+	d.Header = http.Header{"Authorization": {"Bearer secret"}}
+	var received atomic.Int64
+	var totals sync.Map
+	d.Progress = func(body io.Reader, total int64) io.Reader {
+		totals.Store(total, true)
+		return readerFunc(func(p []byte) (int, error) {
+			n, err := body.Read(p)
+			received.Add(int64(n))
+			return n, err
+		})
+	}
+	if err := d.DownloadShards(context.Background(), out); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := testDatabase +
+		"C2,a.exe,1,T1AA,ab,,2024-01-01,\n" +
+		"Rat,c.exe,1,T1CC,ef,,2024-01-03,\n" +
+		"Loader,b.exe,1,T1BB,cd,,2024-01-02,\r\n"
+	if string(data) != want {
+		t.Errorf("joined database:\n%q\nwant\n%q", data, want)
+	}
+	if info, err := os.Stat(out); err != nil || info.Mode().Perm() != 0644 {
+		t.Errorf("mode of the download = %v, %v; want 0644", info.Mode().Perm(), err)
+	}
+	if tmp := tempFiles(t, dir); len(tmp) != 0 {
+		t.Errorf("temporary files left behind: %v", tmp)
+	}
+
+	// Progress covers every shard, against their combined size.
+	var size int64
+	for _, name := range order {
+		size += int64(len(testShards[name]))
+		if srv.count(name) != 1 || srv.headers[name] != "Bearer secret" {
+			t.Errorf("%s requested %d times with Authorization %q", name, srv.count(name), srv.headers[name])
+		}
+	}
+	if received.Load() != size {
+		t.Errorf("progress saw %d bytes, want %d", received.Load(), size)
+	}
+	totals.Range(func(k, _ any) bool {
+		if k.(int64) != size {
+			t.Errorf("progress total %d, want %d", k, size)
+		}
+		return true
+	})
+}
+
+func TestDownloadShardsMirror(t *testing.T) {
+	// A shard on another host does not get the manifest's credentials.
+	mirror := newShardServer(t, map[string]string{"mirror.csv": testShards["c2.csv"]}, nil)
+	srv := newShardServer(t, testShards, []string{"rats.csv"},
+		Shard{URL: mirror.URL + "/mirror.csv", Size: int64(len(testShards["c2.csv"])), SHA256: sha256Hex(testShards["c2.csv"])})
+
+	d := newTestDownloader(srv.URL + "/manifest.json")
+	d.Header = http.Header{"Authorization": {"Bearer secret"}}
+	if err := d.DownloadShards(context.Background(), filepath.Join(t.TempDir(), "db.csv")); err != nil {
+		t.Fatal(err)
+	}
+	if srv.headers["rats.csv"] != "Bearer secret" {
+		t.Errorf("shard on the manifest's host sent Authorization %q", srv.headers["rats.csv"])
+	}
+	if mirror.count("mirror.csv") != 1 || mirror.headers["mirror.csv"] != "" {
+		t.Errorf("mirror shard requested %d times with Authorization %q; want once without", mirror.count("mirror.csv"), mirror.headers["mirror.csv"])
+	}
+}
+
+func TestDownloadShardsRetry(t *testing.T) {
+	shortenShardRetries(t)
+	order := []string{"c2.csv", "rats.csv", "loaders.csv"}
+	srv := newShardServer(t, testShards, order)
+	// rats.csv fails twice, once with an error status and once cut short,
+	// and is fetched a third time; the others are fetched once.
+	srv.fail = func(w http.ResponseWriter, name string, attempt int) bool {
+		if name != "rats.csv" {
+			return false
+		}
+		switch attempt {
+		case 1:
+			w.WriteHeader(http.StatusBadGateway)
+			return true
+		case 2:
+			io.WriteString(w, testShards[name][:10])
+			return true
+		}
+		return false
+	}
+
+	out := filepath.Join(t.TempDir(), "db.csv")
+	if err := newTestDownloader(srv.URL+"/manifest.json").DownloadShards(context.Background(), out); err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]int{"manifest.json": 1, "c2.csv": 1, "loaders.csv": 1, "rats.csv": 3} {
+		if got := srv.count(name); got != want {
+			t.Errorf("%s requested %d times, want %d", name, got, want)
+		}
+	}
+	if data, _ := os.ReadFile(out); !strings.Contains(string(data), "Rat,c.exe") {
+		t.Errorf("database after retries:\n%s", data)
+	}
+}
+
+func TestDownloadShardsErrors(t *testing.T) {
+	shortenShardRetries(t)
+	const existing = "the old database\n"
+
+	tests := []struct {
+		name   string
+		shards map[string]string
+		fail   func(w http.ResponseWriter, name string, attempt int) bool
+		check  func(err error) bool
+	}{
+		{"checksum", testShards, func(w http.ResponseWriter, name string, _ int) bool {
+			if name != "c2.csv" {
+				return false
+			}
+			io.WriteString(w, strings.ToUpper(testShards[name]))
+			return true
+		}, func(err error) bool {
+			var shardErr *ShardError
+			return errors.As(err, &shardErr) && strings.Contains(err.Error(), "does not match the manifest's")
+		}},
+		{"size", testShards, func(w http.ResponseWriter, name string, _ int) bool {
+			if name != "c2.csv" {
+				return false
+			}
+			io.WriteString(w, testShards[name]+"extra\n")
+			return true
+		}, func(err error) bool {
+			var shardErr *ShardError
+			return errors.As(err, &shardErr) && strings.Contains(err.Error(), "manifest says")
+		}},
+		{"status", testShards, func(w http.ResponseWriter, name string, _ int) bool {
+			w.WriteHeader(http.StatusInternalServerError)
+			return true
+		}, func(err error) bool {
+			var statusErr *StatusError
+			return errors.As(err, &statusErr) && strings.HasPrefix(err.Error(), "2 shards failed, first: ")
+		}},
+		{"header", map[string]string{
+			"c2.csv":   testShards["c2.csv"],
+			"rats.csv": "Repo,File\nRat,c.exe\n",
+		}, nil, func(err error) bool {
+			var shardErr *ShardError
+			return errors.As(err, &shardErr) && strings.Contains(err.Error(), "rats.csv") && strings.Contains(err.Error(), "differs from the first shard's")
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newShardServer(t, tt.shards, []string{"c2.csv", "rats.csv"})
+			srv.fail = tt.fail
+
+			dir := t.TempDir()
+			out := filepath.Join(dir, "db.csv")
+			if err := os.WriteFile(out, []byte(existing), 0644); err != nil {
+				t.Fatal(err)
+			}
+			err := newTestDownloader(srv.URL+"/manifest.json").DownloadShards(context.Background(), out)
+			if err == nil || !tt.check(err) {
+				t.Errorf("DownloadShards = %v", err)
+			}
+			if data, _ := os.ReadFile(out); string(data) != existing {
+				t.Errorf("existing database replaced with %q", data)
+			}
+			if tmp := tempFiles(t, dir); len(tmp) != 0 {
+				t.Errorf("temporary files left behind: %v", tmp)
+			}
+		})
+	}
+}
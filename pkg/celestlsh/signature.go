@@ -0,0 +1,127 @@
+package celestlsh
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Signatures use the minisign format: an Ed25519 key pair identified by an
+// 8-byte key ID, and a detached signature of the file together with a
+// global signature over it and its trusted comment. Both algorithms are
+// supported: the default one, which signs the BLAKE2b-512 hash of the
+// file, and the legacy one of minisign -S -l, which signs the file itself.
+var (
+	algorithmEd        = [2]byte{'E', 'd'}
+	algorithmPrehashed = [2]byte{'E', 'D'}
+)
+
+const (
+	untrustedPrefix = "untrusted comment:"
+	trustedPrefix   = "trusted comment: "
+)
+
+// PublicKey is an Ed25519 public key in the minisign format.
+type PublicKey struct {
+	KeyID [8]byte
+	Key   ed25519.PublicKey
+}
+
+// ParsePublicKey parses a minisign public key, either its base64 line
+// alone or the contents of a .pub file with its untrusted comment.
+func ParsePublicKey(text string) (*PublicKey, error) {
+	lines := signatureLines([]byte(text))
+	if len(lines) != 1 {
+		return nil, &SignatureError{Err: errors.New("public key: expected a single base64 line")}
+	}
+	raw, err := base64.StdEncoding.DecodeString(lines[0])
+	if err != nil {
+		return nil, &SignatureError{Err: fmt.Errorf("public key: %w", err)}
+	}
+	if len(raw) != 2+8+ed25519.PublicKeySize || [2]byte(raw[:2]) != algorithmEd {
+		return nil, &SignatureError{Err: errors.New("public key: not a minisign Ed25519 key")}
+	}
+	return &PublicKey{KeyID: [8]byte(raw[2:10]), Key: ed25519.PublicKey(raw[10:])}, nil
+}
+
+// Signature is a detached minisign signature.
+type Signature struct {
+	Algorithm       [2]byte
+	KeyID           [8]byte
+	Signature       []byte
+	TrustedComment  string
+	GlobalSignature []byte
+}
+
+// ParseSignature parses the contents of a minisign .minisig file.
+func ParseSignature(data []byte) (*Signature, error) {
+	lines := signatureLines(data)
+	if len(lines) != 3 || !strings.HasPrefix(lines[1], trustedPrefix) {
+		return nil, &SignatureError{Err: errors.New("signature: expected a signature line, a trusted comment and a global signature")}
+	}
+	raw, err := base64.StdEncoding.DecodeString(lines[0])
+	if err != nil {
+		return nil, &SignatureError{Err: fmt.Errorf("signature: %w", err)}
+	}
+	if len(raw) != 2+8+ed25519.SignatureSize {
+		return nil, &SignatureError{Err: fmt.Errorf("signature: wrong length: %d bytes", len(raw))}
+	}
+	global, err := base64.StdEncoding.DecodeString(lines[2])
+	if err != nil || len(global) != ed25519.SignatureSize {
+		return nil, &SignatureError{Err: errors.New("signature: malformed global signature")}
+	}
+	return &Signature{
+		Algorithm:       [2]byte(raw[:2]),
+		KeyID:           [8]byte(raw[2:10]),
+		Signature:       raw[10:],
+		TrustedComment:  strings.TrimPrefix(lines[1], trustedPrefix),
+		GlobalSignature: global,
+	}, nil
+}
+
+// Verify checks that sig is a valid signature of message by k, and that its
+// trusted comment was signed along with it.
+func (k *PublicKey) Verify(message []byte, sig *Signature) error {
+	switch sig.Algorithm {
+	case algorithmEd:
+	case algorithmPrehashed:
+		sum := blake2b512(message)
+		message = sum[:]
+	default:
+		return &SignatureError{Err: fmt.Errorf("unknown signature algorithm %q", sig.Algorithm[:])}
+	}
+	if sig.KeyID != k.KeyID {
+		return &SignatureError{Err: fmt.Errorf("signed by key %s, want key %s", keyID(sig.KeyID), keyID(k.KeyID))}
+	}
+	if !ed25519.Verify(k.Key, message, sig.Signature) {
+		return &SignatureError{Err: errors.New("signature does not match the file")}
+	}
+	global := append(bytes.Clone(sig.Signature), sig.TrustedComment...)
+	if !ed25519.Verify(k.Key, global, sig.GlobalSignature) {
+		return &SignatureError{Err: errors.New("trusted comment does not match its signature")}
+	}
+	return nil
+}
+
+// keyID formats a key ID the way minisign prints it.
+func keyID(id [8]byte) string {
+	return fmt.Sprintf("%016X", binary.LittleEndian.Uint64(id[:]))
+}
+
+// signatureLines returns the non-empty lines of a key or signature file,
+// leaving out the untrusted comment.
+func signatureLines(data []byte) []string {
+	var lines []string
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimRight(line, "\r")
+		if line == "" || strings.HasPrefix(line, untrustedPrefix) {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
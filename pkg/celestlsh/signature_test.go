@@ -0,0 +1,149 @@
+package celestlsh
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// A minisign key pair and signatures of testSignedFile, one of each
+// algorithm, made from a fixed seed. The BLAKE2b-512 hash the prehashed
+// signature covers was computed independently, with Python's hashlib.
+const (
+	testPublicKey = "untrusted comment: minisign public key 17B8E4D2A5F01CC3\n" +
+		"RWQXuOTSpfAcwxB/2JEl8Yf8x/TeAVFR0K1ivvb8wg+0Oq9ncfyzVyKz\n"
+	testSignedFile = "Repo Name,File Name\nKnownTool,known.exe\n"
+
+	testLegacySignature = "untrusted comment: signature from minisign secret key\n" +
+		"RWQXuOTSpfAcw8c8/1pqyPhsB2hNUVftpUlR4rw9ari3i50U5G1gPGWBBWpKFw70TLfA84Al58i/IZ1zFOUwxrcYqElE+4k1zgA=\n" +
+		"trusted comment: timestamp:1700000000\tfile:db.csv\n" +
+		"h8gX0TwHfLFQjlMOhymZ6x6v7/nmq2aNR8s1KtQ5TvQJxU9nxaYo3/rSp8pLwzmv3NfPP987QuAOHxP9+l1EDA==\n"
+	testPrehashedSignature = "untrusted comment: signature from minisign secret key\n" +
+		"RUQXuOTSpfAcw2fYB9u0Ok2+Hz2SkMF1shlwL42KbO2Qk7Saq6CbVscN8croaGXMnHm7Dy1MCU+zug9wP2rvB6zhXqj4qI829QM=\n" +
+		"trusted comment: timestamp:1700000000\tfile:db.csv\thashed\n" +
+		"E7AB9IQ3q87l9Hl6+wRykyn9TGr+VbMQySDFhoLrsIiDQCdNKzJMFEvw8iwjMTHTVC0uxBgznAFEOtS4OeyABw==\n"
+)
+
+func TestBlake2b512(t *testing.T) {
+	// From Python's hashlib.blake2b, of bytes (i*7+3)%251.
+	tests := []struct {
+		n    int
+		want string
+	}{
+		{0, "786a02f742015903c6c6fd852552d272912f4740e15847618a86e217f71f5419d25e1031afee585313896444934eb04b903a685b1448b755d56f701afe9be2ce"},
+		{3, "76ce7e90c577d436a65b691e51f0a1ad536130d70a18df3bf5368551327258b6f706f4a47c45f2d0d8147cfbb5623077e798247972e4f9338603a13abc05a4db"},
+		{127, "965b0d67d74567a8e84273ff74730f14645dfb11cf263d0e20cbcb5fa3fadb5eec725f9d5dd4f4fd60ee10390fcdd3188ce53faf105d82e6594773ea398edbe1"},
+		{128, "a4d40e9fac2f4491cc7c048202589a43de09be6e0a9e16efe8765eb2fe2c9395d27417f6e21256f26d90a1315d76e51db0045e934a29c8dae2150a4484ad5d87"},
+		{129, "0263b9cb461cd36c77452119f6920ce4a95a9c47f48f241377dc33d09732464c30295c38590761f1b16464f4fa54bbb37e95c6e664adf7b66800b71ffec75503"},
+		{256, "5eb68fbd373e852c1f4aaf96953c480367c87135e721deb5db341bbbc497cfae000aef2af67dbd311ecf01a42a23d442f1fec22d9a0258d8fad22da2390c0680"},
+		{1000, "4f7c042aaed7f13a9c87a6ad31816ecdd9a19bf5568d552cc9753fa5e68ed99273a7aa4990c1f462cc2b4b740e170b97820551eef6d2f8aa21401ce8c3f67ee7"},
+	}
+	for _, tt := range tests {
+		data := make([]byte, tt.n)
+		for i := range data {
+			data[i] = byte((i*7 + 3) % 251)
+		}
+		if sum := blake2b512(data); hex.EncodeToString(sum[:]) != tt.want {
+			t.Errorf("blake2b512(%d bytes) = %x, want %s", tt.n, sum, tt.want)
+		}
+	}
+}
+
+func TestVerifySignature(t *testing.T) {
+	key, err := ParsePublicKey(testPublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keyID(key.KeyID) != "C31CF0A5D2E4B817" {
+		t.Errorf("key ID = %s, want C31CF0A5D2E4B817", keyID(key.KeyID))
+	}
+	// The base64 line alone is a key too.
+	if _, err := ParsePublicKey(strings.Split(testPublicKey, "\n")[1]); err != nil {
+		t.Errorf("ParsePublicKey of the bare key: %v", err)
+	}
+
+	for _, text := range []string{testLegacySignature, testPrehashedSignature} {
+		sig, err := ParseSignature([]byte(text))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(sig.TrustedComment, "timestamp:1700000000\tfile:db.csv") {
+			t.Errorf("trusted comment = %q", sig.TrustedComment)
+		}
+		if err := key.Verify([]byte(testSignedFile), sig); err != nil {
+			t.Errorf("%s signature: %v", sig.Algorithm[:], err)
+		}
+
+		tampered := []byte(strings.Replace(testSignedFile, "known", "other", 1))
+		if err := key.Verify(tampered, sig); err == nil || !strings.Contains(err.Error(), "signature does not match the file") {
+			t.Errorf("%s signature of a tampered file: %v", sig.Algorithm[:], err)
+		}
+
+		comment := *sig
+		comment.TrustedComment += "\tedited"
+		if err := key.Verify([]byte(testSignedFile), &comment); err == nil || !strings.Contains(err.Error(), "trusted comment does not match") {
+			t.Errorf("%s signature with an edited comment: %v", sig.Algorithm[:], err)
+		}
+	}
+}
+
+func TestVerifySignatureErrors(t *testing.T) {
+	key, err := ParsePublicKey(testPublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig, err := ParseSignature([]byte(testPrehashedSignature))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A key generated here, under the same key ID.
+	pub, _, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other := &PublicKey{KeyID: key.KeyID, Key: pub}
+	if err := other.Verify([]byte(testSignedFile), sig); err == nil || !strings.Contains(err.Error(), "signature does not match") {
+		t.Errorf("Verify with another key = %v", err)
+	}
+	other.KeyID[0]++
+	if err := other.Verify([]byte(testSignedFile), sig); err == nil || !strings.Contains(err.Error(), "signed by key C31CF0A5D2E4B817, want key C31CF0A5D2E4B818") {
+		t.Errorf("Verify with another key ID = %v", err)
+	}
+
+	unknown := *sig
+	unknown.Algorithm = [2]byte{'X', 'x'}
+	if err := key.Verify([]byte(testSignedFile), &unknown); err == nil || !strings.Contains(err.Error(), `unknown signature algorithm "Xx"`) {
+		t.Errorf("Verify with an unknown algorithm = %v", err)
+	}
+
+	lines := strings.Split(testPrehashedSignature, "\n")
+	badKeys := []string{
+		"",
+		"not base64!",
+		base64.StdEncoding.EncodeToString([]byte("Ed too short")),
+		strings.Replace(strings.Split(testPublicKey, "\n")[1], "RWQ", "RUQ", 1),
+	}
+	for _, text := range badKeys {
+		if _, err := ParsePublicKey(text); err == nil {
+			t.Errorf("ParsePublicKey(%q) succeeded", text)
+		}
+	}
+	badSignatures := []string{
+		"",
+		strings.Join(lines[1:3], "\n"),
+		strings.Join([]string{lines[1], "comment without its prefix", lines[3]}, "\n"),
+		strings.Join([]string{lines[1][:40], lines[2], lines[3]}, "\n"),
+		strings.Join([]string{lines[1], lines[2], lines[3][:20]}, "\n"),
+	}
+	for _, text := range badSignatures {
+		_, err := ParseSignature([]byte(text))
+		var sigErr *SignatureError
+		if !errors.As(err, &sigErr) {
+			t.Errorf("ParseSignature(%q) = %v, want a SignatureError", text, err)
+		}
+	}
+}
@@ -0,0 +1,241 @@
+//go:build ignore
+
+// Genpe writes the PE files the imphash tests read: minimal executables
+// with one section holding their import table, and no code.
+//
+//	go run testdata/genpe.go
+//
+// run from pkg/celestlsh. The imphash of each, as computed by pefile, can
+// be checked with testdata/imphash.py.
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// function is an import, by name or, if name is empty, by ordinal.
+type function struct {
+	name    string
+	ordinal uint16
+}
+
+type library struct {
+	dll       string
+	functions []function
+}
+
+func byName(names ...string) []function {
+	var fs []function
+	for _, n := range names {
+		fs = append(fs, function{name: n})
+	}
+	return fs
+}
+
+func byOrdinal(ordinals ...uint16) []function {
+	var fs []function
+	for _, o := range ordinals {
+		fs = append(fs, function{ordinal: o})
+	}
+	return fs
+}
+
+var fixtures = []struct {
+	name    string
+	pe64    bool
+	imports []library
+}{
+	{"imports32.exe", false, []library{
+		{"KERNEL32.dll", append(byName("CreateFileA", "ReadFile"), byOrdinal(5)...)},
+		{"WS2_32.dll", byOrdinal(115, 23, 4, 3)},
+		{"WSOCK32.DLL", byOrdinal(1, 9, 151, 500)},
+		{"OLEAUT32.dll", byOrdinal(2, 6, 8, 9)},
+	}},
+	{"imports64.exe", true, []library{
+		{"ADVAPI32.DLL", byName("RegOpenKeyExW")},
+		{"MSCOMCTL.OCX", byName("DllGetClassObject")},
+		{"FltMgr.sys", byName("FltRegisterFilter")},
+		{"ntoskrnl.exe", byName("IoCreateDevice")},
+		{"msvcrt.dll.bak", byName("malloc")},
+		// Without the extension, ordinals are not looked up.
+		{"ws2_32", byOrdinal(23)},
+		{"oleaut32.dll", byOrdinal(9999)},
+		{"ws2_32.dll", byOrdinal(23)},
+	}},
+	{"noimports.exe", false, nil},
+}
+
+const (
+	fileAlignment    = 0x200
+	sectionAlignment = 0x1000
+	sectionRVA       = 0x1000
+	headerSize       = 0x200
+)
+
+func main() {
+	for _, f := range fixtures {
+		data := build(f.pe64, f.imports)
+		if err := os.WriteFile(filepath.Join("testdata", f.name), data, 0644); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
+
+// build returns a PE32 or PE32+ file importing imports.
+func build(pe64 bool, imports []library) []byte {
+	section, importSize := importSection(pe64, imports)
+	rawSize := align(len(section), fileAlignment)
+	section = append(section, make([]byte, rawSize-len(section))...)
+
+	var b bytes.Buffer
+	le := func(v any) { binary.Write(&b, binary.LittleEndian, v) }
+
+	// DOS header, pointing at the PE header right after it.
+	dos := make([]byte, 0x40)
+	copy(dos, "MZ")
+	binary.LittleEndian.PutUint32(dos[0x3c:], 0x40)
+	b.Write(dos)
+
+	machine, optSize, characteristics := uint16(0x14c), uint16(224), uint16(0x0102)
+	if pe64 {
+		machine, optSize, characteristics = 0x8664, 240, 0x0022
+	}
+	b.WriteString("PE\x00\x00")
+	le(machine)
+	le(uint16(1)) // NumberOfSections
+	le(uint32(0)) // TimeDateStamp
+	le(uint32(0)) // PointerToSymbolTable
+	le(uint32(0)) // NumberOfSymbols
+	le(optSize)
+	le(characteristics)
+
+	imageSize := uint32(sectionRVA + align(len(section), sectionAlignment))
+	if pe64 {
+		le(uint16(0x20b))
+	} else {
+		le(uint16(0x10b))
+	}
+	le([2]uint8{14, 0})    // linker version
+	le(uint32(0))          // SizeOfCode
+	le(uint32(rawSize))    // SizeOfInitializedData
+	le(uint32(0))          // SizeOfUninitializedData
+	le(uint32(sectionRVA)) // AddressOfEntryPoint
+	le(uint32(sectionRVA)) // BaseOfCode
+	if pe64 {
+		le(uint64(0x140000000)) // ImageBase
+	} else {
+		le(uint32(sectionRVA)) // BaseOfData
+		le(uint32(0x400000))   // ImageBase
+	}
+	le(uint32(sectionAlignment))
+	le(uint32(fileAlignment))
+	le([6]uint16{6, 0, 0, 0, 6, 0}) // OS, image and subsystem versions
+	le(uint32(0))                   // Win32VersionValue
+	le(imageSize)
+	le(uint32(headerSize))
+	le(uint32(0))      // CheckSum
+	le(uint16(3))      // Subsystem: console
+	le(uint16(0x8140)) // DllCharacteristics
+	if pe64 {
+		le([4]uint64{0x100000, 0x1000, 0x100000, 0x1000})
+	} else {
+		le([4]uint32{0x100000, 0x1000, 0x100000, 0x1000})
+	}
+	le(uint32(0))  // LoaderFlags
+	le(uint32(16)) // NumberOfRvaAndSizes
+	for i := range 16 {
+		if i == 1 && imports != nil {
+			le([2]uint32{sectionRVA, uint32(importSize)})
+		} else {
+			le([2]uint32{0, 0})
+		}
+	}
+
+	var name [8]byte
+	copy(name[:], ".idata")
+	le(name)
+	le(uint32(len(section))) // VirtualSize
+	le(uint32(sectionRVA))
+	le(uint32(rawSize))
+	le(uint32(headerSize)) // PointerToRawData
+	le([3]uint32{0, 0, 0}) // relocations and line numbers
+	le(uint32(0xc0000040)) // initialized data, readable, writable
+
+	b.Write(make([]byte, headerSize-b.Len()))
+	b.Write(section)
+	return b.Bytes()
+}
+
+// importSection lays out the import table of imports at sectionRVA: the
+// descriptors, then each library's lookup and address tables, then the
+// names. It returns the section's contents and the size of the
+// descriptors.
+func importSection(pe64 bool, imports []library) ([]byte, int) {
+	if imports == nil {
+		return make([]byte, 16), 0
+	}
+	thunkSize, ordinalFlag := 4, uint64(1)<<31
+	if pe64 {
+		thunkSize, ordinalFlag = 8, 1<<63
+	}
+
+	descSize := 20 * (len(imports) + 1)
+	tablesSize := 0
+	for _, lib := range imports {
+		tablesSize += 2 * thunkSize * (len(lib.functions) + 1)
+	}
+	out := make([]byte, descSize+tablesSize)
+
+	// Names follow the tables, each function's after its two-byte hint.
+	names := len(out)
+	addString := func(s string, hint bool) uint32 {
+		rva := uint32(sectionRVA + names)
+		if hint {
+			out = append(out, 0, 0)
+		}
+		out = append(out, s...)
+		out = append(out, 0)
+		if len(out)%2 != 0 {
+			out = append(out, 0)
+		}
+		names = len(out)
+		return rva
+	}
+
+	table := descSize
+	for i, lib := range imports {
+		lookup := table
+		address := lookup + thunkSize*(len(lib.functions)+1)
+		table = address + thunkSize*(len(lib.functions)+1)
+
+		// addString can move out, so the descriptor is written after.
+		dll := addString(lib.dll, false)
+		desc := out[20*i:]
+		binary.LittleEndian.PutUint32(desc[0:], uint32(sectionRVA+lookup))
+		binary.LittleEndian.PutUint32(desc[12:], dll)
+		binary.LittleEndian.PutUint32(desc[16:], uint32(sectionRVA+address))
+
+		for j, f := range lib.functions {
+			value := uint64(f.ordinal) | ordinalFlag
+			if f.name != "" {
+				value = uint64(addString(f.name, true))
+			}
+			for _, at := range []int{lookup, address} {
+				if pe64 {
+					binary.LittleEndian.PutUint64(out[at+j*thunkSize:], value)
+				} else {
+					binary.LittleEndian.PutUint32(out[at+j*thunkSize:], uint32(value))
+				}
+			}
+		}
+	}
+	return out, descSize
+}
+
+func align(n, to int) int {
+	return (n + to - 1) / to * to
+}
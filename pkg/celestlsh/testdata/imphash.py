@@ -0,0 +1,14 @@
+#!/usr/bin/env python3
+"""Print the pefile imphash of each PE file named, for imphash_test.go.
+
+    pip install pefile
+    python3 testdata/imphash.py testdata/*.exe
+"""
+
+import sys
+
+import pefile
+
+for path in sys.argv[1:]:
+    pe = pefile.PE(path)
+    print(f"{path}\t{pe.get_imphash()}")
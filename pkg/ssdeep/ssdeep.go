@@ -0,0 +1,350 @@
+// Package ssdeep computes and compares ssdeep fuzzy hashes, as produced by
+// the ssdeep tool and libfuzzy, for intel sources that carry them instead
+// of TLSH hashes.
+//
+// An ssdeep hash has the form blocksize:digest:digest2. The data is cut
+// into pieces where a rolling hash over a small window hits a value that
+// depends on the block size, and each piece contributes one base64
+// character to the digest; digest2 does the same with twice the block
+// size. Two hashes can only be compared when their block sizes are equal
+// or a factor of two apart.
+package ssdeep
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	rollingWindow  = 7
+	minBlockSize   = 3
+	spamSumLength  = 64
+	numBlockHashes = 31
+
+	// hashInit is the initial value of the piece hash, the FNV offset
+	// basis 0x28021967 reduced to the six bits that are kept.
+	hashInit  = 0x27
+	hashPrime = 0x01000193
+
+	b64 = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+/"
+)
+
+// ErrInvalidHash is returned by Compare for a malformed hash.
+var ErrInvalidHash = errors.New("invalid ssdeep hash")
+
+// rollState is the rolling hash over the last rollingWindow bytes.
+type rollState struct {
+	window     [rollingWindow]byte
+	h1, h2, h3 uint32
+	n          int
+}
+
+func (r *rollState) roll(c byte) {
+	r.h2 -= r.h1
+	r.h2 += rollingWindow * uint32(c)
+	r.h1 += uint32(c)
+	r.h1 -= uint32(r.window[r.n])
+	r.window[r.n] = c
+	r.n = (r.n + 1) % rollingWindow
+	r.h3 = r.h3<<5 ^ uint32(c)
+}
+
+func (r *rollState) sum() uint32 {
+	return r.h1 + r.h2 + r.h3
+}
+
+// sumHash adds c to a piece hash, keeping the six bits that become a
+// digest character.
+func sumHash(c, h byte) byte {
+	return byte((uint32(h)*hashPrime ^ uint32(c)) & 0x3f)
+}
+
+// blockHash is the digest being built for one block size.
+type blockHash struct {
+	h, halfh   byte
+	digest     [spamSumLength]byte
+	halfDigest byte
+	dlen       int
+}
+
+// Hash computes the ssdeep hash of the data written to it. The digests of
+// every block size that may end up chosen are built at once, so the data
+// is only read once. The zero value is not usable; use New.
+type Hash struct {
+	roll           rollState
+	bh             [numBlockHashes]blockHash
+	bhStart, bhEnd int
+	total          uint64
+}
+
+// New returns a Hash ready for writing.
+func New() *Hash {
+	h := &Hash{bhEnd: 1}
+	h.bh[0].h, h.bh[0].halfh = hashInit, hashInit
+	return h
+}
+
+// Write adds p to the hashed data. It never returns an error.
+func (h *Hash) Write(p []byte) (int, error) {
+	h.total += uint64(len(p))
+	for _, c := range p {
+		h.step(c)
+	}
+	return len(p), nil
+}
+
+func blockSize(i int) uint64 {
+	return minBlockSize << i
+}
+
+func (h *Hash) step(c byte) {
+	h.roll.roll(c)
+	sum := uint64(h.roll.sum())
+
+	for i := h.bhStart; i < h.bhEnd; i++ {
+		h.bh[i].h = sumHash(c, h.bh[i].h)
+		h.bh[i].halfh = sumHash(c, h.bh[i].halfh)
+	}
+
+	// A piece ends for block size bs when the rolling hash is bs-1 modulo
+	// bs; block sizes double, so each one that ends a piece implies that
+	// all smaller ones do too.
+	for i := h.bhStart; i < h.bhEnd; i++ {
+		if bs := blockSize(i); sum%bs != bs-1 {
+			break
+		}
+		b := &h.bh[i]
+		if b.dlen == 0 {
+			h.fork()
+		}
+		b.digest[b.dlen] = b64[b.h]
+		b.halfDigest = b64[b.halfh]
+		if b.dlen < spamSumLength-1 {
+			// Once the digest is full, the last character keeps covering
+			// the rest of the data.
+			b.dlen++
+			b.digest[b.dlen] = 0
+			b.h = hashInit
+			if b.dlen < spamSumLength/2 {
+				b.halfh = hashInit
+				b.halfDigest = 0
+			}
+		} else {
+			h.reduce()
+		}
+	}
+}
+
+// fork starts the digest of the next larger block size, once the largest
+// one so far has ended its first piece. Until then the two would be the
+// same.
+func (h *Hash) fork() {
+	if h.bhEnd >= numBlockHashes {
+		return
+	}
+	prev, next := &h.bh[h.bhEnd-1], &h.bh[h.bhEnd]
+	next.h, next.halfh = prev.h, prev.halfh
+	next.dlen, next.halfDigest = 0, 0
+	h.bhEnd++
+}
+
+// reduce stops building the digest of the smallest block size once Sum can
+// no longer choose it: the data is already too long for it, and the next
+// block size has a long enough digest.
+func (h *Hash) reduce() {
+	if h.bhEnd-h.bhStart < 2 {
+		return
+	}
+	if blockSize(h.bhStart)*spamSumLength >= h.total {
+		return
+	}
+	if h.bh[h.bhStart+1].dlen < spamSumLength/2 {
+		return
+	}
+	h.bhStart++
+}
+
+// Sum returns the ssdeep hash of the data written so far.
+func (h *Hash) Sum() string {
+	// The block size is the smallest for which the data would fill the
+	// digest, halved while the digest is less than half full.
+	bi := h.bhStart
+	for bi < numBlockHashes-1 && blockSize(bi)*spamSumLength < h.total {
+		bi++
+	}
+	if bi >= h.bhEnd {
+		bi = h.bhEnd - 1
+	}
+	for bi > h.bhStart && h.bh[bi].dlen < spamSumLength/2 {
+		bi--
+	}
+
+	// The data after the last piece ends adds a final character, unless
+	// the rolling hash is zero.
+	roll := h.roll.sum()
+	var sb strings.Builder
+	b := &h.bh[bi]
+	sb.WriteString(strconv.FormatUint(blockSize(bi), 10))
+	sb.WriteByte(':')
+	sb.Write(b.digest[:b.dlen])
+	if roll != 0 {
+		sb.WriteByte(b64[b.h])
+	} else if b.digest[b.dlen] != 0 {
+		sb.WriteByte(b.digest[b.dlen])
+	}
+	sb.WriteByte(':')
+	if bi < h.bhEnd-1 {
+		b2 := &h.bh[bi+1]
+		sb.Write(b2.digest[:min(b2.dlen, spamSumLength/2-1)])
+		if roll != 0 {
+			sb.WriteByte(b64[b2.halfh])
+		} else if b2.halfDigest != 0 {
+			sb.WriteByte(b2.halfDigest)
+		}
+	} else if roll != 0 {
+		sb.WriteByte(b64[b.h])
+	}
+	return sb.String()
+}
+
+// Sum returns the ssdeep hash of data.
+func Sum(data []byte) string {
+	h := New()
+	h.Write(data)
+	return h.Sum()
+}
+
+// parsed is a hash split into its parts, with runs of more than three
+// identical characters shortened to three, as they carry little
+// information and would inflate the score.
+type parsed struct {
+	blockSize uint64
+	digest    string
+	digest2   string
+}
+
+func parse(s string) (parsed, error) {
+	bs, rest, ok := strings.Cut(s, ":")
+	if !ok {
+		return parsed{}, fmt.Errorf("%w: %q: no block size", ErrInvalidHash, s)
+	}
+	blockSize, err := strconv.ParseUint(bs, 10, 64)
+	if err != nil || blockSize == 0 {
+		return parsed{}, fmt.Errorf("%w: %q: bad block size", ErrInvalidHash, s)
+	}
+	d1, d2, ok := strings.Cut(rest, ":")
+	if !ok {
+		return parsed{}, fmt.Errorf("%w: %q: no second digest", ErrInvalidHash, s)
+	}
+	// The ssdeep tool's output appends ,"filename".
+	d2, _, _ = strings.Cut(d2, ",")
+	if len(d1) > spamSumLength || len(d2) > spamSumLength {
+		return parsed{}, fmt.Errorf("%w: %q: digest longer than %d characters", ErrInvalidHash, s, spamSumLength)
+	}
+	for _, d := range []string{d1, d2} {
+		if i := strings.IndexFunc(d, func(r rune) bool { return !strings.ContainsRune(b64, r) }); i >= 0 {
+			return parsed{}, fmt.Errorf("%w: %q: unexpected character %q", ErrInvalidHash, s, d[i])
+		}
+	}
+	return parsed{blockSize: blockSize, digest: eliminateSequences(d1), digest2: eliminateSequences(d2)}, nil
+}
+
+func eliminateSequences(s string) string {
+	out := make([]byte, 0, len(s))
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if n := len(out); n < 3 || c != out[n-1] || c != out[n-2] || c != out[n-3] {
+			out = append(out, c)
+		}
+	}
+	return string(out)
+}
+
+// Compare returns the similarity of two ssdeep hashes, from 0 for nothing
+// in common to 100 for a near or exact match, scored the way the ssdeep
+// tool does. Hashes whose block sizes are not equal or a factor of two
+// apart always score 0.
+func Compare(a, b string) (int, error) {
+	pa, err := parse(a)
+	if err != nil {
+		return 0, err
+	}
+	pb, err := parse(b)
+	if err != nil {
+		return 0, err
+	}
+
+	switch {
+	case pa.blockSize == pb.blockSize:
+		if pa.digest == pb.digest && pa.digest2 == pb.digest2 {
+			return 100, nil
+		}
+		return max(scoreStrings(pa.digest, pb.digest, pa.blockSize), scoreStrings(pa.digest2, pb.digest2, pa.blockSize*2)), nil
+	case pa.blockSize == pb.blockSize*2:
+		return scoreStrings(pa.digest, pb.digest2, pa.blockSize), nil
+	case pb.blockSize == pa.blockSize*2:
+		return scoreStrings(pa.digest2, pb.digest, pb.blockSize), nil
+	}
+	return 0, nil
+}
+
+// scoreStrings scores two digests of the same block size by their edit
+// distance relative to their length. Digests with no run of rollingWindow
+// characters in common score 0, and at small block sizes the score is
+// capped so that short inputs do not look more alike than they are.
+func scoreStrings(s1, s2 string, blockSize uint64) int {
+	if !hasCommonSubstring(s1, s2) {
+		return 0
+	}
+	score := uint64(editDistance(s1, s2))
+	score = score * spamSumLength / uint64(len(s1)+len(s2))
+	score = 100 * score / spamSumLength
+	score = 100 - score
+	if blockSize >= (99+rollingWindow)/rollingWindow*minBlockSize {
+		return int(score)
+	}
+	if limit := blockSize / minBlockSize * uint64(min(len(s1), len(s2))); score > limit {
+		score = limit
+	}
+	return int(score)
+}
+
+func hasCommonSubstring(s1, s2 string) bool {
+	if len(s1) < rollingWindow || len(s2) < rollingWindow {
+		return false
+	}
+	seen := make(map[string]bool, len(s1))
+	for i := 0; i+rollingWindow <= len(s1); i++ {
+		seen[s1[i:i+rollingWindow]] = true
+	}
+	for i := 0; i+rollingWindow <= len(s2); i++ {
+		if seen[s2[i:i+rollingWindow]] {
+			return true
+		}
+	}
+	return false
+}
+
+// editDistance is the edit distance ssdeep uses: insertions and deletions
+// cost 1, and substitutions 2.
+func editDistance(s1, s2 string) int {
+	prev := make([]int, len(s2)+1)
+	cur := make([]int, len(s2)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(s1); i++ {
+		cur[0] = i
+		for j := 1; j <= len(s2); j++ {
+			sub := prev[j-1]
+			if s1[i-1] != s2[j-1] {
+				sub += 2
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, sub)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(s2)]
+}
@@ -0,0 +1,168 @@
+package ssdeep
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+// The expected hashes and scores below come from a separate port of the
+// classic spamsum algorithm, which makes a full pass over the data for
+// each block size tried rather than building them all at once, and of
+// libfuzzy's fuzzy_compare. The fixtures are generated here and there
+// alike.
+
+// lcg is the 64-bit linear congruential generator of Knuth's MMIX, taking
+// the top byte of each state.
+type lcg uint64
+
+func (g *lcg) byte() byte {
+	*g = *g*6364136223846793005 + 1442695040888963407
+	return byte(*g >> 56)
+}
+
+func randomBytes(seed lcg, n int) []byte {
+	data := make([]byte, n)
+	for i := range data {
+		data[i] = seed.byte()
+	}
+	return data
+}
+
+// text returns n bytes of words, some lines long.
+func text(seed lcg, n int) []byte {
+	words := bytes.Fields([]byte("the quick brown fox jumps over lazy dog while packet header sends payload to remote host and waits for reply"))
+	var out []byte
+	for len(out) < n {
+		out = append(out, words[int(seed.byte())%len(words)]...)
+		if seed.byte()%13 == 0 {
+			out = append(out, '\n')
+		} else {
+			out = append(out, ' ')
+		}
+	}
+	return out[:n]
+}
+
+// mutate returns a copy of data with every step-th byte changed.
+func mutate(data []byte, seed lcg, step int) []byte {
+	out := bytes.Clone(data)
+	for i := 0; i < len(out); i += step {
+		out[i] ^= seed.byte() | 1
+	}
+	return out
+}
+
+func fixtures() map[string][]byte {
+	random := randomBytes(3, 200000)
+	words := text(4, 20000)
+	return map[string][]byte{
+		"empty":                 {},
+		"random-100":            randomBytes(1, 100),
+		"random-5000":           randomBytes(2, 5000),
+		"random-200000":         random,
+		"random-200000-mutated": mutate(random, 5, 25000),
+		"random-100000-prefix":  random[:100000],
+		"random-60000-prefix":   random[:60000],
+		"text-20000":            words,
+		"text-20000-edited":     append(append(bytes.Clone(words[:9000]), bytes.Repeat([]byte("INSERTED "), 40)...), words[9000:19000]...),
+		// The rolling hash is zero at the end, so no final character is
+		// added for the data after the last piece.
+		"zero-tail": append(randomBytes(6, 3000), make([]byte, 16)...),
+	}
+}
+
+var referenceHashes = map[string]string{
+	"empty":                 "3::",
+	"random-100":            "3:piH06MsHt8pK9cGP8IXy7sQfwN7+U9p:Ijp8k+Ey7sP7r9p",
+	"random-5000":           "96:rOuiUjDgHXdCG9OdHXqTI1EScXSFwV18+4FPRw6nEgWajtID:rjVjDsXd8dwI/cXSFM18+4tRwwE8jWD",
+	"random-200000":         "3072:7/J9lFzUaOJQIl4TIdcxDa28WZi3KvKoX/Lfk9OzqwPYMY9rsJsH4sJ7WAmnR:LJLrTxDajWIIKoXTf8Gwb9f4sJa9R",
+	"random-200000-mutated": "3072:Q/J9lFzUatJQIl4TJdcxDa28WZi3XvKoX/Luk9OzqwPzMY9rjJsH4sw7WAmnR:cJLrHxDajWI/KoXTu8Grb944swa9R",
+	"random-100000-prefix":  "3072:7/J9lFzUaOJQIl4TIdcxDa28WZi3KvKoX/Lh:LJLrTxDajWIIKoXTh",
+	"random-60000-prefix":   "1536:7/JcrwlmR0znIviH3PRtuTlPBw6KahrvlQrTPr0dxyxDac:7/J9lFzUaOJQIl4TIdcxDac",
+	"text-20000":            "384:8fA94AC2FqJPc+Xv7XHKaXbEzFwvT77bTLZPAsHN4Z1QGrcTC2xZ347+x:sA94gFA7XHK4EhmXNBN4/vrcTC2xZ3ei",
+	"text-20000-edited":     "384:8fA94AC2FqJPc+Xv7XHKaXbEzVwvT77bTLZPAsHN4Z1QGrcTC2xZ3g:sA94gFA7XHK4ExmXNBN4/vrcTC2xZ3g",
+	"zero-tail":             "48:Mr2jHlOjEMVszF51ygjjopouheubM/uzytZC3zwhSM6K5YGB28wU/zM:BF6EYsx51ygjKo8gmzytez+SxOTB2pUY",
+}
+
+func TestSum(t *testing.T) {
+	for name, data := range fixtures() {
+		want := referenceHashes[name]
+		if got := Sum(data); got != want {
+			t.Errorf("%s: Sum = %s, want %s", name, got, want)
+		}
+		// Written in pieces, the data hashes the same.
+		h := New()
+		for rest := data; len(rest) > 0; {
+			n := min(len(rest), 1+len(rest)%997)
+			h.Write(rest[:n])
+			rest = rest[n:]
+		}
+		if got := h.Sum(); got != want {
+			t.Errorf("%s: Sum after several writes = %s, want %s", name, got, want)
+		}
+	}
+}
+
+func TestCompare(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"random-200000", "random-200000-mutated", 88},
+		{"random-200000", "random-100000-prefix", 74},
+		// Block sizes a factor of two apart compare the larger one's
+		// digest with the smaller one's second digest.
+		{"random-200000", "random-60000-prefix", 54},
+		{"random-60000-prefix", "random-200000", 54},
+		{"text-20000", "text-20000-edited", 94},
+		{"random-5000", "random-200000", 0},
+		{"random-100", "random-5000", 0},
+		{"text-20000", "text-20000", 100},
+	}
+	for _, tt := range tests {
+		got, err := Compare(referenceHashes[tt.a], referenceHashes[tt.b])
+		if err != nil || got != tt.want {
+			t.Errorf("Compare(%s, %s) = %d, %v; want %d", tt.a, tt.b, got, err, tt.want)
+		}
+	}
+
+	// The ssdeep tool's output format, with the file name, is accepted.
+	tool := referenceHashes["text-20000"] + `,"/tmp/text-20000"`
+	if got, err := Compare(tool, referenceHashes["text-20000"]); err != nil || got != 100 {
+		t.Errorf("Compare with a file name = %d, %v; want 100", got, err)
+	}
+}
+
+func TestCompareInvalid(t *testing.T) {
+	valid := referenceHashes["random-5000"]
+	for _, bad := range []string{
+		"",
+		"96",
+		"x:abc:def",
+		"0:abc:def",
+		"96:abc",
+		"96:ab!c:def",
+		"96:" + string(bytes.Repeat([]byte("A"), 65)) + ":def",
+	} {
+		if _, err := Compare(bad, valid); !errors.Is(err, ErrInvalidHash) {
+			t.Errorf("Compare(%q) = %v, want ErrInvalidHash", bad, err)
+		}
+		if _, err := Compare(valid, bad); !errors.Is(err, ErrInvalidHash) {
+			t.Errorf("Compare(valid, %q) = %v, want ErrInvalidHash", bad, err)
+		}
+	}
+}
+
+func TestEliminateSequences(t *testing.T) {
+	for in, want := range map[string]string{
+		"":           "",
+		"AAA":        "AAA",
+		"AAAAAAB":    "AAAB",
+		"ABBBBBCCCC": "ABBBCCC",
+	} {
+		if got := eliminateSequences(in); got != want {
+			t.Errorf("eliminateSequences(%q) = %q, want %q", in, got, want)
+		}
+	}
+}